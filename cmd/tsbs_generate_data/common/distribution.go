@@ -3,6 +3,7 @@ package common
 import (
 	"math"
 	"math/rand"
+	"time"
 )
 
 // Distribution provides an interface to model a statistical distribution.
@@ -175,3 +176,63 @@ func (d *ConstantDistribution) Advance() {
 func (d *ConstantDistribution) Get() float64 {
 	return d.State
 }
+
+// Seasonality describes periodic variation in a value over time: a daily sine
+// wave that peaks at midday and a lower level during weekends.
+type Seasonality struct {
+	// Daily is the difference between the daily peak (12:00) and trough (00:00)
+	Daily float64
+	// Weekend is how much lower values are on Saturdays and Sundays
+	Weekend float64
+}
+
+// Offset returns the seasonal change of a value at time t (in UTC).
+func (s Seasonality) Offset(t time.Time) float64 {
+	t = t.UTC()
+	hours := float64(t.Hour()) + float64(t.Minute())/60 + float64(t.Second())/3600
+	offset := s.Daily / 2 * math.Sin(2*math.Pi*(hours-6)/24)
+	if wd := t.Weekday(); wd == time.Saturday || wd == time.Sunday {
+		offset -= s.Weekend
+	}
+	return offset
+}
+
+// SeasonalDistribution adds Seasonality to the values of an underlying
+// distribution, clamped to Min and Max. The time used is read from Clock, so
+// the underlying distribution keeps evolving as before, e.g. as a random walk.
+type SeasonalDistribution struct {
+	Base        Distribution
+	Seasonality Seasonality
+	Min         float64
+	Max         float64
+	Clock       *time.Time
+}
+
+// SD creates a new SeasonalDistribution based on a given distribution, seasonality, bounds and clock
+func SD(base Distribution, seasonality Seasonality, min, max float64, clock *time.Time) *SeasonalDistribution {
+	return &SeasonalDistribution{
+		Base:        base,
+		Seasonality: seasonality,
+		Min:         min,
+		Max:         max,
+		Clock:       clock,
+	}
+}
+
+// Advance advances the underlying distribution.
+func (d *SeasonalDistribution) Advance() {
+	d.Base.Advance()
+}
+
+// Get returns the last value of the underlying distribution with the
+// seasonal offset at the current time added.
+func (d *SeasonalDistribution) Get() float64 {
+	v := d.Base.Get() + d.Seasonality.Offset(*d.Clock)
+	if v > d.Max {
+		v = d.Max
+	}
+	if v < d.Min {
+		v = d.Min
+	}
+	return v
+}
@@ -1,6 +1,10 @@
 package devops
 
 import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strconv"
 	"time"
 
 	"github.com/timescale/tsbs/cmd/tsbs_generate_data/common"
@@ -16,8 +20,41 @@ type commonDevopsSimulatorConfig struct {
 	InitHostCount uint64
 	// HostCount is the total number of hosts to have in the last reporting period
 	HostCount uint64
-	// HostConstructor is the function used to create a new Host given an id number and start time
-	HostConstructor func(i int, start time.Time) Host
+	// HostConstructor is the function used to create a new Host given an id number, start time and distribution parameters
+	HostConstructor func(i int, start time.Time, params *DistributionParams) Host
+	// DistributionParams overrides the parameters of the simulated distributions; nil means defaults
+	DistributionParams *DistributionParams
+	// GapProbability is the probability that a host skips any given reporting interval
+	GapProbability float64
+	// MaxGapLength is the maximum number of consecutive intervals a single gap can span
+	MaxGapLength uint64
+	// AnomalyProbability is the probability that a host's measurement enters an anomaly in any given interval
+	AnomalyProbability float64
+	// AnomalyDuration is how long an anomaly lasts once started
+	AnomalyDuration time.Duration
+	// ExtraTagCount is the number of tags to add to each host on top of MachineTagKeys
+	ExtraTagCount uint64
+	// ExtraTagCardinality is the number of distinct values of each extra tag
+	ExtraTagCardinality uint64
+	// HostChurnRate is the number of hosts replaced per simulated day, as a fraction of HostCount
+	HostChurnRate float64
+	// DeployInterval is how often, in simulated time, every host's service_version is bumped;
+	// 0 means never
+	DeployInterval time.Duration
+	// TeamReassignRate is the number of hosts moved to another team per simulated day, as a
+	// fraction of HostCount
+	TeamReassignRate float64
+	// TagSource, if set, provides the machine tag values of hosts instead of random ones
+	TagSource TagSource
+	// MeasurementCoverage is the probability that a host reports each measurement other than
+	// cpu, mem and disk; 0 means every host reports every measurement
+	MeasurementCoverage float64
+	// CoverageSeed seeds the picks of the measurements each host reports under
+	// MeasurementCoverage, apart from the source of the simulated data
+	CoverageSeed int64
+	// ScaleRamp governs how the number of reporting hosts grows from InitHostCount to HostCount;
+	// nil means linearly
+	ScaleRamp ScaleRamp
 }
 
 func calculateEpochs(c commonDevopsSimulatorConfig, interval time.Duration) uint64 {
@@ -35,10 +72,55 @@ type commonDevopsSimulator struct {
 	epochs     uint64
 	epochHosts uint64
 	initHosts  uint64
+	scaleRamp  ScaleRamp
 
 	timestampStart time.Time
 	timestampEnd   time.Time
 	interval       time.Duration
+
+	gapProbability float64
+	maxGapLength   uint64
+	// gaps holds, per host, the number of intervals left in its current gap
+	gaps []uint64
+
+	anomalyProbability float64
+	anomalyIntervals   uint64
+	anomalies          []Anomaly
+
+	// extraTagKeys are the keys of the tags added on top of MachineTagKeys
+	extraTagKeys [][]byte
+
+	churnRate float64
+	// churnDue is the (fractional) number of hosts due to be replaced
+	churnDue   float64
+	nextHostID int
+	// newHost creates a replacement host with the given id, starting at the given time
+	newHost func(i int, start time.Time) Host
+
+	deployInterval time.Duration
+	// nextDeploy is when service versions are next bumped
+	nextDeploy       time.Time
+	teamReassignRate float64
+	// teamReassignDue is the (fractional) number of hosts due to change team
+	teamReassignDue float64
+}
+
+// Anomaly describes a period during which one measurement of a host was
+// forced into an anomaly regime. End is exclusive.
+type Anomaly struct {
+	Host        string
+	Measurement string
+	Start       time.Time
+	End         time.Time
+}
+
+// anomalyMeasurement is implemented by measurements that can be forced into
+// an anomaly regime, which is every measurement embedding a subsystemMeasurement.
+type anomalyMeasurement interface {
+	canAnomaly() bool
+	inAnomaly() bool
+	startAnomaly(intervals uint64, high bool)
+	stepAnomaly()
 }
 
 // Finished tells whether we have simulated all the necessary points
@@ -53,8 +135,39 @@ func (s *commonDevopsSimulator) Fields() map[string][][]byte {
 	return s.fields(s.hosts[0].SimulatedMeasurements)
 }
 
+// FieldTypes returns a map of subsystems to the types of the metrics collected,
+// in the same order as the metrics returned by Fields.
+func (s *commonDevopsSimulator) FieldTypes() map[string][]string {
+	if len(s.hosts) <= 0 {
+		panic("cannot get field types because no hosts added")
+	}
+	return s.fieldTypes(s.hosts[0].SimulatedMeasurements)
+}
+
+// Anomalies returns all anomalies injected so far
+func (s *commonDevopsSimulator) Anomalies() []Anomaly {
+	return s.anomalies
+}
+
+// NumHosts returns the number of hosts simulated, including those not yet
+// reporting.
+func (s *commonDevopsSimulator) NumHosts() int {
+	return len(s.hosts)
+}
+
+// LastHostIndex returns the index, among all simulated hosts, of the host the
+// last Point returned by Next belongs to.
+func (s *commonDevopsSimulator) LastHostIndex() int {
+	return int(s.hostIndex) - 1
+}
+
 func (s *commonDevopsSimulator) TagKeys() [][]byte {
-	return MachineTagKeys
+	if len(s.extraTagKeys) == 0 {
+		return MachineTagKeys
+	}
+	keys := make([][]byte, 0, len(MachineTagKeys)+len(s.extraTagKeys))
+	keys = append(keys, MachineTagKeys...)
+	return append(keys, s.extraTagKeys...)
 }
 
 func (s *commonDevopsSimulator) fields(measurements []common.SimulatedMeasurement) map[string][][]byte {
@@ -68,6 +181,23 @@ func (s *commonDevopsSimulator) fields(measurements []common.SimulatedMeasuremen
 	return data
 }
 
+func (s *commonDevopsSimulator) fieldTypes(measurements []common.SimulatedMeasurement) map[string][]string {
+	data := make(map[string][]string)
+	for _, sm := range measurements {
+		point := serialize.NewPoint()
+		sm.ToPoint(point)
+		name := string(point.MeasurementName())
+		keys := point.FieldKeys()
+		types := make([]string, 0, len(keys))
+		for _, key := range keys {
+			types = append(types, fieldType(name, key, point.GetFieldValue(key)))
+		}
+		data[name] = types
+	}
+
+	return data
+}
+
 func (s *commonDevopsSimulator) populatePoint(p *serialize.Point, measureIdx int) bool {
 	host := &s.hosts[s.hostIndex]
 
@@ -82,27 +212,229 @@ func (s *commonDevopsSimulator) populatePoint(p *serialize.Point, measureIdx int
 	p.AppendTag(MachineTagKeys[7], host.Service)
 	p.AppendTag(MachineTagKeys[8], host.ServiceVersion)
 	p.AppendTag(MachineTagKeys[9], host.ServiceEnvironment)
+	for i, v := range host.ExtraTags {
+		p.AppendTag(s.extraTagKeys[i], v)
+	}
 
 	// Populate measurement-specific tags and fields:
 	host.SimulatedMeasurements[measureIdx].ToPoint(p)
 
-	ret := s.hostIndex < s.epochHosts
+	ret := s.hostIndex < s.epochHosts && !s.inGap(s.hostIndex) && host.reports(measureIdx)
 	s.madePoints++
 	s.hostIndex++
 	return ret
 }
 
-// TODO(rrk) - Can probably turn this logic into a separate interface and implement other
-// types of scale up, e.g., exponential
-//
 // To "scale up" the number of reporting items, we need to know when
-// which epoch we are currently in. Once we know that, we can take the "missing"
-// amount of scale -- i.e., the max amount of scale less the initial amount
-// -- and add it in proportion to the percentage of epochs that have passed. This
+// which epoch we are currently in. Once we know that, the ScaleRamp tells how
+// much of the "missing" amount of scale -- i.e., the max amount of scale less
+// the initial amount -- has been added by then, e.g., in proportion to the
+// percentage of epochs that have passed for a LinearRamp. This
 // way we simulate all items at each epoch, but at the end of the function
 // we check whether the point should be recorded by the calling process.
 func (s *commonDevopsSimulator) adjustNumHostsForEpoch() {
 	s.epoch++
-	missingScale := float64(uint64(len(s.hosts)) - s.initHosts)
-	s.epochHosts = s.initHosts + uint64(missingScale*float64(s.epoch)/float64(s.epochs-1))
+	ramp := s.scaleRamp
+	if ramp == nil {
+		ramp = LinearRamp{}
+	}
+	s.epochHosts = ramp.Hosts(s.epoch, s.epochs, s.interval, s.initHosts, uint64(len(s.hosts)))
+}
+
+// churnHosts replaces hosts, picked at random among the reporting ones, with
+// new hosts that have fresh names and tag values. On average churnRate of the
+// fleet is replaced per simulated day, so the number of reporting hosts stays
+// the same while the number of distinct hosts grows.
+func (s *commonDevopsSimulator) churnHosts() {
+	if s.churnRate <= 0 || s.epochHosts == 0 {
+		return
+	}
+	s.churnDue += s.churnRate * float64(len(s.hosts)) * float64(s.interval) / float64(24*time.Hour)
+
+	now := s.timestampStart.Add(time.Duration(s.epoch) * s.interval)
+	for ; s.churnDue >= 1; s.churnDue-- {
+		i := rand.Intn(int(s.epochHosts))
+		s.hosts[i] = s.newHost(s.nextHostID, now)
+		s.nextHostID++
+		if i < len(s.gaps) {
+			s.gaps[i] = 0
+		}
+	}
+}
+
+// updateDynamicTags changes the tag values of hosts that vary over time: at
+// every deploy all hosts get the next service_version, and on average
+// teamReassignRate of the fleet moves to another team per simulated day. New
+// values are always freshly allocated, as Points made earlier, e.g., those
+// held back to be written late, still refer to the old ones.
+func (s *commonDevopsSimulator) updateDynamicTags() {
+	now := s.timestampStart.Add(time.Duration(s.epoch) * s.interval)
+	if s.deployInterval > 0 {
+		if s.nextDeploy.IsZero() {
+			s.nextDeploy = s.timestampStart.Add(s.deployInterval)
+		}
+		for ; !now.Before(s.nextDeploy); s.nextDeploy = s.nextDeploy.Add(s.deployInterval) {
+			for i := range s.hosts {
+				s.hosts[i].ServiceVersion = nextServiceVersion(s.hosts[i].ServiceVersion)
+			}
+		}
+	}
+
+	if s.teamReassignRate <= 0 || s.epochHosts == 0 {
+		return
+	}
+	s.teamReassignDue += s.teamReassignRate * float64(len(s.hosts)) * float64(s.interval) / float64(24*time.Hour)
+	for ; s.teamReassignDue >= 1; s.teamReassignDue-- {
+		host := &s.hosts[rand.Intn(int(s.epochHosts))]
+		team := MachineTeamChoices[rand.Intn(len(MachineTeamChoices)-1)]
+		if bytes.Equal(team, host.Team) {
+			// Only the last team is never drawn, so it takes the place of the current one
+			team = MachineTeamChoices[len(MachineTeamChoices)-1]
+		}
+		host.Team = team
+	}
+}
+
+// nextServiceVersion returns v with the number it ends with incremented, or
+// with a 1 appended if it does not end with a number, e.g., 1 becomes 2,
+// v1.9 becomes v1.10 and beta becomes beta1.
+func nextServiceVersion(v []byte) []byte {
+	i := len(v)
+	for i > 0 && v[i-1] >= '0' && v[i-1] <= '9' {
+		i--
+	}
+	n, _ := strconv.ParseUint(string(v[i:]), 10, 64)
+	next := make([]byte, i, i+20)
+	copy(next, v[:i])
+	if i == len(v) {
+		return strconv.AppendUint(next, 1, 10)
+	}
+	return strconv.AppendUint(next, n+1, 10)
+}
+
+func (s *commonDevopsSimulator) inGap(hostIndex uint64) bool {
+	return hostIndex < uint64(len(s.gaps)) && s.gaps[hostIndex] > 0
+}
+
+// updateGaps decides for each host whether it skips reporting in the current
+// epoch. Hosts still in a gap count down the remaining intervals, while other
+// hosts start a new gap (of uniformly random length up to maxGapLength) with a
+// probability chosen such that, given the expected gap length, the long-run
+// fraction of skipped intervals equals gapProbability.
+func (s *commonDevopsSimulator) updateGaps() {
+	if s.gapProbability <= 0 {
+		return
+	}
+	if s.gaps == nil {
+		s.gaps = make([]uint64, len(s.hosts))
+	}
+
+	maxLen := s.maxGapLength
+	if maxLen == 0 {
+		maxLen = 1
+	}
+	meanLen := float64(1+maxLen) / 2
+	startProb := s.gapProbability / (meanLen*(1-s.gapProbability) + s.gapProbability)
+
+	for i := range s.gaps {
+		if s.gaps[i] > 0 {
+			s.gaps[i]--
+		}
+		if s.gaps[i] == 0 && rand.Float64() < startProb {
+			s.gaps[i] = 1 + uint64(rand.Int63n(int64(maxLen)))
+		}
+	}
+}
+
+// updateAnomalies advances ongoing anomalies and, with probability
+// anomalyProbability, starts a new one for each host measurement that is not
+// already in one. Newly started anomalies are recorded so they can be
+// reported as ground truth.
+func (s *commonDevopsSimulator) updateAnomalies() {
+	if s.anomalyProbability <= 0 {
+		return
+	}
+
+	now := s.timestampStart.Add(time.Duration(s.epoch) * s.interval)
+	end := now.Add(time.Duration(s.anomalyIntervals) * s.interval)
+	for i := range s.hosts {
+		host := &s.hosts[i]
+		for _, sm := range host.SimulatedMeasurements {
+			am, ok := sm.(anomalyMeasurement)
+			if !ok || !am.canAnomaly() {
+				continue
+			}
+			if am.inAnomaly() {
+				am.stepAnomaly()
+				continue
+			}
+			if rand.Float64() >= s.anomalyProbability {
+				continue
+			}
+			am.startAnomaly(s.anomalyIntervals, rand.Intn(2) == 0)
+
+			p := serialize.NewPoint()
+			sm.ToPoint(p)
+			s.anomalies = append(s.anomalies, Anomaly{
+				Host:        string(host.Name),
+				Measurement: string(p.MeasurementName()),
+				Start:       now,
+				End:         end,
+			})
+		}
+	}
+}
+
+// anomalyIntervals returns how many intervals an anomaly of duration d spans,
+// which is always at least one.
+func anomalyIntervals(d, interval time.Duration) uint64 {
+	n := uint64(d / interval)
+	if d%interval != 0 || n == 0 {
+		n++
+	}
+	return n
+}
+
+// extraTagKeys returns the keys of count extra tags: extra_tag_0, extra_tag_1, ...
+func extraTagKeys(count uint64) [][]byte {
+	if count == 0 {
+		return nil
+	}
+	keys := make([][]byte, count)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("extra_tag_%d", i))
+	}
+	return keys
+}
+
+// addExtraTags gives each host a value for each of count extra tags, drawn at
+// random from a pool of cardinality values per tag.
+func addExtraTags(hosts []Host, count, cardinality uint64) {
+	if count == 0 {
+		return
+	}
+	if cardinality == 0 {
+		cardinality = 1
+	}
+	for i := range hosts {
+		hosts[i].ExtraTags = make([][]byte, count)
+		for j := range hosts[i].ExtraTags {
+			hosts[i].ExtraTags[j] = []byte(fmt.Sprintf("value_%d", rand.Int63n(int64(cardinality))))
+		}
+	}
+}
+
+// hostChurner returns a function creating replacement hosts the same way as
+// the initial ones, or nil if there is no churn.
+func hostChurner(c *commonDevopsSimulatorConfig) func(i int, start time.Time) Host {
+	if c.HostChurnRate <= 0 {
+		return nil
+	}
+	return func(i int, start time.Time) Host {
+		hosts := []Host{c.HostConstructor(i, start, c.DistributionParams)}
+		applyTagSource(hosts, i, c.TagSource)
+		addExtraTags(hosts, c.ExtraTagCount, c.ExtraTagCardinality)
+		assignMeasurements(hosts, i, c.MeasurementCoverage, c.CoverageSeed)
+		return hosts[0]
+	}
 }
@@ -2,6 +2,8 @@ package devops
 
 import (
 	"fmt"
+	"math"
+	"math/rand"
 	"testing"
 	"time"
 
@@ -173,6 +175,58 @@ func TestCommonDevopsSimulatorFields(t *testing.T) {
 	}()
 }
 
+func TestCommonDevopsSimulatorFieldTypes(t *testing.T) {
+	s := &commonDevopsSimulator{}
+	host := Host{}
+	host.SimulatedMeasurements = []common.SimulatedMeasurement{
+		NewCPUMeasurement(time.Now()),
+		NewMemMeasurement(time.Now()),
+	}
+	s.hosts = append(s.hosts, host)
+	fields := s.Fields()
+	types := s.FieldTypes()
+	if got := len(types); got != len(fields) {
+		t.Fatalf("field types length does not match fields: got %d want %d", got, len(fields))
+	}
+	for name, keys := range fields {
+		if got := len(types[name]); got != len(keys) {
+			t.Errorf("%s: incorrect number of field types: got %d want %d", name, got, len(keys))
+		}
+	}
+
+	for _, typ := range types[string(labelCPU)] {
+		if typ != FieldTypeInt64 {
+			t.Errorf("cpu: incorrect field type: got %s want %s", typ, FieldTypeInt64)
+		}
+	}
+
+	// mem mixes unsigned byte amounts with float percentages
+	wantMem := []string{
+		FieldTypeUInt64, FieldTypeUInt64, FieldTypeUInt64, FieldTypeUInt64, FieldTypeUInt64, FieldTypeUInt64,
+		FieldTypeFloat64, FieldTypeFloat64, FieldTypeFloat64,
+	}
+	gotMem := types[string(labelMem)]
+	if len(gotMem) != len(wantMem) {
+		t.Fatalf("mem: incorrect number of field types: got %d want %d", len(gotMem), len(wantMem))
+	}
+	for i, typ := range gotMem {
+		if typ != wantMem[i] {
+			t.Errorf("mem: incorrect type for field %s: got %s want %s", fields[string(labelMem)][i], typ, wantMem[i])
+		}
+	}
+
+	// Test panic condition
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("did not panic when should")
+			}
+		}()
+		s.hosts = s.hosts[:0]
+		_ = s.FieldTypes()
+	}()
+}
+
 func bprintf(format string, args ...interface{}) []byte {
 	return []byte(fmt.Sprintf(format, args...))
 }
@@ -238,6 +292,302 @@ func TestCommonDevopsSimulatorPopulatePoint(t *testing.T) {
 	}
 }
 
+func TestCommonDevopsSimulatorLastHostIndex(t *testing.T) {
+	conf := &DevopsSimulatorConfig{
+		Start:           testTime,
+		End:             testTime.Add(2 * time.Second),
+		InitHostCount:   3,
+		HostCount:       3,
+		HostConstructor: NewHost,
+	}
+	s := conf.NewSimulator(time.Second, 0).(*DevopsSimulator)
+	if got := s.NumHosts(); got != 3 {
+		t.Errorf("incorrect number of hosts: got %d want %d", got, 3)
+	}
+	p := serialize.NewPoint()
+	for i := 0; !s.Finished(); i++ {
+		s.Next(p)
+		if got, want := s.LastHostIndex(), i%3; got != want {
+			t.Fatalf("incorrect host index for point %d: got %d want %d", i, got, want)
+		}
+		if got, want := string(p.GetTagValue(MachineTagKeys[0])), fmt.Sprintf("host_%d", i%3); got != want {
+			t.Fatalf("incorrect hostname for point %d: got %s want %s", i, got, want)
+		}
+		p.Reset()
+	}
+}
+
+func TestCommonDevopsSimulatorMeasurementCoverage(t *testing.T) {
+	const (
+		numHosts = 200
+		coverage = 0.3
+	)
+	// hostsPerMeasurement returns the hosts reporting each measurement, with the
+	// field values they report last
+	hostsPerMeasurement := func(coverage float64) map[string]map[string]string {
+		rand.Seed(123)
+		conf := &DevopsSimulatorConfig{
+			Start:               testTime,
+			End:                 testTime.Add(3 * time.Second),
+			InitHostCount:       numHosts,
+			HostCount:           numHosts,
+			HostConstructor:     NewHost,
+			MeasurementCoverage: coverage,
+			CoverageSeed:        456,
+		}
+		s := conf.NewSimulator(time.Second, 0)
+		if _, ok := s.Fields()[string(labelNginx)]; !ok {
+			t.Errorf("coverage %v: nginx missing from fields", coverage)
+		}
+		ret := make(map[string]map[string]string)
+		p := serialize.NewPoint()
+		for !s.Finished() {
+			if s.Next(p) {
+				name := string(p.MeasurementName())
+				if ret[name] == nil {
+					ret[name] = make(map[string]string)
+				}
+				values := make([]interface{}, 0, len(p.FieldKeys()))
+				for _, k := range p.FieldKeys() {
+					values = append(values, p.GetFieldValue(k))
+				}
+				ret[name][string(p.GetTagValue(MachineTagKeys[0]))] = fmt.Sprint(values...)
+			}
+			p.Reset()
+		}
+		return ret
+	}
+
+	full := hostsPerMeasurement(0)
+	for name, hosts := range full {
+		if len(hosts) != numHosts {
+			t.Errorf("full coverage: incorrect number of hosts for %s: got %d want %d", name, len(hosts), numHosts)
+		}
+	}
+
+	sparse := hostsPerMeasurement(coverage)
+	for _, name := range []string{string(labelCPU), string(labelMem), string(labelDisk)} {
+		if got := len(sparse[name]); got != numHosts {
+			t.Errorf("core measurement %s not reported by all hosts: got %d want %d", name, got, numHosts)
+		}
+	}
+	// the number of nginx hosts is binomial with a stddev of about 6.5
+	if got, want := len(sparse[string(labelNginx)]), int(coverage*numHosts); got < want-20 || got > want+20 {
+		t.Errorf("incorrect number of nginx hosts: got %d want about %d", got, want)
+	}
+
+	again := hostsPerMeasurement(coverage)
+	if got, want := len(again[string(labelNginx)]), len(sparse[string(labelNginx)]); got != want {
+		t.Errorf("coverage not deterministic: got %d nginx hosts then %d", want, got)
+	}
+	for host := range sparse[string(labelNginx)] {
+		if _, ok := again[string(labelNginx)][host]; !ok {
+			t.Errorf("coverage not deterministic: %s reports nginx only once", host)
+		}
+	}
+
+	// The picks do not draw from the source of the data, which the hosts
+	// report whatever the coverage
+	for name, hosts := range sparse {
+		for host, values := range hosts {
+			if want := full[name][host]; values != want {
+				t.Errorf("%s of %s changed by coverage: got %s want %s", name, host, values, want)
+			}
+		}
+	}
+}
+
+func TestCommonDevopsSimulatorExtraTags(t *testing.T) {
+	cases := []struct {
+		desc        string
+		count       uint64
+		cardinality uint64
+	}{
+		{desc: "no extra tags", count: 0, cardinality: 100},
+		{desc: "one extra tag", count: 1, cardinality: 1},
+		{desc: "many extra tags", count: 50, cardinality: 1000000},
+	}
+
+	const numHosts = 20
+	for _, c := range cases {
+		conf := &DevopsSimulatorConfig{
+			Start:               testTime,
+			End:                 testTime.Add(time.Second),
+			InitHostCount:       numHosts,
+			HostCount:           numHosts,
+			HostConstructor:     NewHost,
+			ExtraTagCount:       c.count,
+			ExtraTagCardinality: c.cardinality,
+		}
+		s := conf.NewSimulator(time.Second, 0).(*DevopsSimulator)
+
+		keys := s.TagKeys()
+		if got, want := len(keys), len(MachineTagKeys)+int(c.count); got != want {
+			t.Fatalf("%s: incorrect number of tag keys: got %d want %d", c.desc, got, want)
+		}
+		for i := range MachineTagKeys {
+			if got := string(keys[i]); got != string(MachineTagKeys[i]) {
+				t.Errorf("%s: incorrect tag key %d: got %s want %s", c.desc, i, got, MachineTagKeys[i])
+			}
+		}
+		for i := uint64(0); i < c.count; i++ {
+			if got, want := string(keys[len(MachineTagKeys)+int(i)]), fmt.Sprintf("extra_tag_%d", i); got != want {
+				t.Errorf("%s: incorrect extra tag key: got %s want %s", c.desc, got, want)
+			}
+		}
+
+		p := serialize.NewPoint()
+		distinct := make(map[string]bool)
+		for i := 0; i < numHosts; i++ {
+			s.Next(p)
+			for _, key := range keys {
+				if p.GetTagValue(key) == nil {
+					t.Fatalf("%s: missing tag in point: %s", c.desc, key)
+				}
+			}
+			for _, key := range keys[len(MachineTagKeys):] {
+				v := string(p.GetTagValue(key))
+				var n uint64
+				if _, err := fmt.Sscanf(v, "value_%d", &n); err != nil || n >= c.cardinality {
+					t.Errorf("%s: extra tag value out of pool: got %s", c.desc, v)
+				}
+				distinct[v] = true
+			}
+			p.Reset()
+		}
+		if c.cardinality == 1 && len(distinct) != 1 {
+			t.Errorf("%s: incorrect number of distinct values: got %d want 1", c.desc, len(distinct))
+		}
+		if c.count == 50 && len(distinct) < numHosts {
+			t.Errorf("%s: too few distinct values for high cardinality: got %d", c.desc, len(distinct))
+		}
+	}
+}
+
+func TestCommonDevopsSimulatorHostChurn(t *testing.T) {
+	const (
+		numHosts = 10
+		days     = 5
+		rate     = 0.2
+	)
+	cases := []struct {
+		desc string
+		rate float64
+		// replaced is how many hosts should be replaced over the run
+		replaced int
+	}{
+		{desc: "no churn", rate: 0, replaced: 0},
+		// 119 epoch changes, each replacing 0.2 * 10 / 24 hosts, so 9.92 in total
+		{desc: "churn", rate: rate, replaced: 9},
+	}
+
+	for _, c := range cases {
+		rand.Seed(123)
+		conf := &CPUOnlySimulatorConfig{
+			Start:           testTime,
+			End:             testTime.Add(days * 24 * time.Hour),
+			InitHostCount:   numHosts,
+			HostCount:       numHosts,
+			HostConstructor: NewHostCPUOnly,
+			HostChurnRate:   c.rate,
+		}
+		s := conf.NewSimulator(time.Hour, 0).(*CPUOnlySimulator)
+		p := serialize.NewPoint()
+		names := make(map[string]bool)
+		epochNames := make(map[string]bool)
+		for !s.Finished() {
+			if s.Next(p) {
+				name := string(p.GetTagValue(MachineTagKeys[0]))
+				names[name] = true
+				epochNames[name] = true
+			}
+			p.Reset()
+			if s.hostIndex == uint64(len(s.hosts)) {
+				if got := len(epochNames); got != numHosts {
+					t.Errorf("%s: incorrect number of reporting hosts in epoch %d: got %d want %d", c.desc, s.epoch, got, numHosts)
+				}
+				epochNames = make(map[string]bool)
+			}
+		}
+		if got, want := len(names), numHosts+c.replaced; got != want {
+			t.Errorf("%s: incorrect number of distinct hosts: got %d want %d", c.desc, got, want)
+		}
+	}
+}
+
+func TestCommonDevopsSimulatorDynamicTags(t *testing.T) {
+	const (
+		numHosts = 10
+		days     = 5
+	)
+	cases := []struct {
+		desc           string
+		deployInterval time.Duration
+		reassignRate   float64
+		// minRows and maxRows bound the number of distinct tag rows
+		minRows int
+		maxRows int
+	}{
+		{desc: "static", minRows: numHosts, maxRows: numHosts},
+		// deploys at hours 24, 48, 72 and 96, so each host has 5 versions
+		{desc: "deploys", deployInterval: 24 * time.Hour, minRows: 5 * numHosts, maxRows: 5 * numHosts},
+		// 9 reassignments, as for the host churn, some possibly back to an earlier team
+		{desc: "team reassignment", reassignRate: 0.2, minRows: numHosts + 1, maxRows: numHosts + 9},
+		{desc: "both", deployInterval: 24 * time.Hour, reassignRate: 0.2, minRows: 5*numHosts + 1, maxRows: 5*numHosts + 9},
+	}
+
+	for _, c := range cases {
+		rand.Seed(123)
+		conf := &CPUOnlySimulatorConfig{
+			Start:            testTime,
+			End:              testTime.Add(days * 24 * time.Hour),
+			InitHostCount:    numHosts,
+			HostCount:        numHosts,
+			HostConstructor:  NewHostCPUOnly,
+			DeployInterval:   c.deployInterval,
+			TeamReassignRate: c.reassignRate,
+		}
+		s := conf.NewSimulator(time.Hour, 0).(*CPUOnlySimulator)
+		p := serialize.NewPoint()
+		rows := make(map[string]bool)
+		for !s.Finished() {
+			if s.Next(p) {
+				var row []byte
+				for _, k := range MachineTagKeys {
+					row = append(append(row, p.GetTagValue(k)...), ',')
+				}
+				rows[string(row)] = true
+			}
+			p.Reset()
+		}
+		if got := len(rows); got < c.minRows || got > c.maxRows {
+			t.Errorf("%s: incorrect number of distinct tag rows: got %d want %d to %d", c.desc, got, c.minRows, c.maxRows)
+		}
+	}
+}
+
+func TestNextServiceVersion(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{in: "0", want: "1"},
+		{in: "9", want: "10"},
+		{in: "v1.9", want: "v1.10"},
+		{in: "beta", want: "beta1"},
+		{in: "", want: "1"},
+	}
+	for _, c := range cases {
+		in := []byte(c.in)
+		if got := string(nextServiceVersion(in)); got != c.want {
+			t.Errorf("incorrect next version of '%s': got %s want %s", c.in, got, c.want)
+		}
+		if string(in) != c.in {
+			t.Errorf("version '%s' was modified in place: now %s", c.in, in)
+		}
+	}
+}
+
 func TestAdjustNumHostsForEpoch(t *testing.T) {
 	totalHosts := 100
 	cases := []struct {
@@ -296,3 +646,169 @@ func TestAdjustNumHostsForEpoch(t *testing.T) {
 		}
 	}
 }
+
+func TestCommonDevopsSimulatorGaps(t *testing.T) {
+	cases := []struct {
+		desc           string
+		gapProbability float64
+		maxGapLength   uint64
+	}{
+		{
+			desc:           "single interval gaps",
+			gapProbability: 0.1,
+			maxGapLength:   1,
+		},
+		{
+			desc:           "multi interval gaps",
+			gapProbability: 0.25,
+			maxGapLength:   6,
+		},
+		{
+			desc:           "always in a gap",
+			gapProbability: 1.0,
+			maxGapLength:   3,
+		},
+	}
+
+	const numHosts = 50
+	const epochs = 2000
+	const tolerance = 0.02
+	start := time.Unix(0, 0)
+	for _, c := range cases {
+		run := func() []bool {
+			rand.Seed(123)
+			conf := &CPUOnlySimulatorConfig{
+				Start:           start,
+				End:             start.Add(epochs * time.Second),
+				InitHostCount:   numHosts,
+				HostCount:       numHosts,
+				HostConstructor: NewHostCPUOnly,
+				GapProbability:  c.gapProbability,
+				MaxGapLength:    c.maxGapLength,
+			}
+			sim := conf.NewSimulator(time.Second, 0)
+			p := serialize.NewPoint()
+			written := make([]bool, 0, numHosts*epochs)
+			for !sim.Finished() {
+				written = append(written, sim.Next(p))
+				p.Reset()
+			}
+			return written
+		}
+
+		written := run()
+		if got := len(written); got != numHosts*epochs {
+			t.Errorf("%s: incorrect number of points: got %d want %d", c.desc, got, numHosts*epochs)
+		}
+		skipped := 0
+		for _, w := range written {
+			if !w {
+				skipped++
+			}
+		}
+		if got := float64(skipped) / float64(len(written)); math.Abs(got-c.gapProbability) > tolerance {
+			t.Errorf("%s: realized gap fraction too far off: got %v want %v", c.desc, got, c.gapProbability)
+		}
+
+		again := run()
+		for i := range written {
+			if written[i] != again[i] {
+				t.Errorf("%s: gaps not deterministic for same seed at point %d", c.desc, i)
+				break
+			}
+		}
+	}
+}
+
+func TestUpdateGapsDisabled(t *testing.T) {
+	s := &commonDevopsSimulator{}
+	s.hosts = append(s.hosts, Host{})
+	s.updateGaps()
+	if s.gaps != nil {
+		t.Errorf("gaps tracked when gap probability is 0")
+	}
+	if s.inGap(0) {
+		t.Errorf("host in a gap when gap probability is 0")
+	}
+}
+
+func TestAnomalyIntervals(t *testing.T) {
+	cases := []struct {
+		d    time.Duration
+		want uint64
+	}{
+		{0, 1},
+		{time.Second, 1},
+		{10 * time.Second, 1},
+		{11 * time.Second, 2},
+		{10 * time.Minute, 60},
+	}
+	for _, c := range cases {
+		if got := anomalyIntervals(c.d, 10*time.Second); got != c.want {
+			t.Errorf("incorrect intervals for %v: got %d want %d", c.d, got, c.want)
+		}
+	}
+}
+
+func TestCommonDevopsSimulatorAnomalies(t *testing.T) {
+	const numHosts = 20
+	const epochs = 500
+	start := time.Unix(0, 0)
+	run := func() ([]Anomaly, map[string]map[int64]int64) {
+		rand.Seed(123)
+		conf := &CPUOnlySimulatorConfig{
+			Start:              start,
+			End:                start.Add(epochs * time.Second),
+			InitHostCount:      numHosts,
+			HostCount:          numHosts,
+			HostConstructor:    NewHostCPUOnly,
+			AnomalyProbability: 0.01,
+			AnomalyDuration:    5 * time.Second,
+		}
+		sim := conf.NewSimulator(time.Second, 0)
+		p := serialize.NewPoint()
+		usage := make(map[string]map[int64]int64)
+		for !sim.Finished() {
+			sim.Next(p)
+			host := string(p.GetTagValue(MachineTagKeys[0]))
+			if usage[host] == nil {
+				usage[host] = make(map[int64]int64)
+			}
+			usage[host][p.Timestamp().UnixNano()] = p.GetFieldValue([]byte("usage_user")).(int64)
+			p.Reset()
+		}
+		return sim.(*CPUOnlySimulator).Anomalies(), usage
+	}
+
+	anomalies, usage := run()
+	if len(anomalies) == 0 {
+		t.Fatalf("no anomalies injected")
+	}
+	for _, a := range anomalies {
+		if a.Measurement != string(labelCPU) {
+			t.Errorf("incorrect measurement: got %s want %s", a.Measurement, labelCPU)
+		}
+		if got := a.End.Sub(a.Start); got != 5*time.Second {
+			t.Errorf("incorrect anomaly length: got %v", got)
+		}
+		want := usage[a.Host][a.Start.UnixNano()]
+		if want != 0 && want != 100 {
+			t.Errorf("value not pinned at anomaly start: got %d", want)
+		}
+		for ts := a.Start; ts.Before(a.End); ts = ts.Add(time.Second) {
+			if got, ok := usage[a.Host][ts.UnixNano()]; ok && got != want {
+				t.Errorf("value not pinned during anomaly for %s at %v: got %d want %d", a.Host, ts, got, want)
+			}
+		}
+	}
+
+	again, _ := run()
+	if len(again) != len(anomalies) {
+		t.Fatalf("anomalies not deterministic: got %d want %d", len(again), len(anomalies))
+	}
+	for i := range again {
+		if again[i] != anomalies[i] {
+			t.Errorf("anomaly %d not deterministic: got %v want %v", i, again[i], anomalies[i])
+		}
+	}
+}
@@ -46,6 +46,20 @@ func newCPUMeasurementNumDistributions(start time.Time, numDistributions int) *C
 	return &CPUMeasurement{sub}
 }
 
+// newCPUMeasurementWithParams creates a CPUMeasurement whose random walks use
+// the step std dev from params. The default distributions are built first so
+// the sequence of random numbers consumed does not depend on params.
+func newCPUMeasurementWithParams(start time.Time, numDistributions int, params *DistributionParams) *CPUMeasurement {
+	m := newCPUMeasurementNumDistributions(start, numDistributions)
+	if params.CPUStepStdDev != cpuND.StdDev {
+		step := common.ND(0.0, params.CPUStepStdDev)
+		for _, d := range m.distributions {
+			d.(*common.ClampedRandomWalkDistribution).Step = step
+		}
+	}
+	return m
+}
+
 func (m *CPUMeasurement) ToPoint(p *serialize.Point) {
 	m.toPointAllInt64(p, labelCPU, cpuFields)
 }
@@ -18,6 +18,11 @@ func (d *CPUOnlySimulator) Fields() map[string][][]byte {
 	return d.fields(d.hosts[0].SimulatedMeasurements[:1])
 }
 
+// FieldTypes returns a map of subsystems to the types of the metrics collected
+func (d *CPUOnlySimulator) FieldTypes() map[string][]string {
+	return d.fieldTypes(d.hosts[0].SimulatedMeasurements[:1])
+}
+
 // Next advances a Point to the next state in the generator.
 func (d *CPUOnlySimulator) Next(p *serialize.Point) bool {
 	// Switch to the next metric if needed
@@ -29,6 +34,10 @@ func (d *CPUOnlySimulator) Next(p *serialize.Point) bool {
 		}
 
 		d.adjustNumHostsForEpoch()
+		d.churnHosts()
+		d.updateDynamicTags()
+		d.updateGaps()
+		d.updateAnomalies()
 	}
 
 	return d.populatePoint(p, 0)
@@ -41,8 +50,11 @@ type CPUOnlySimulatorConfig commonDevopsSimulatorConfig
 func (c *CPUOnlySimulatorConfig) NewSimulator(interval time.Duration, limit uint64) common.Simulator {
 	hostInfos := make([]Host, c.HostCount)
 	for i := 0; i < len(hostInfos); i++ {
-		hostInfos[i] = c.HostConstructor(i, c.Start)
+		hostInfos[i] = c.HostConstructor(i, c.Start, c.DistributionParams)
 	}
+	applyTagSource(hostInfos, 0, c.TagSource)
+	addExtraTags(hostInfos, c.ExtraTagCount, c.ExtraTagCardinality)
+	assignMeasurements(hostInfos, 0, c.MeasurementCoverage, c.CoverageSeed)
 
 	epochs := calculateEpochs(commonDevopsSimulatorConfig(*c), interval)
 	maxPoints := epochs * c.HostCount
@@ -61,10 +73,28 @@ func (c *CPUOnlySimulatorConfig) NewSimulator(interval time.Duration, limit uint
 		epochs:         epochs,
 		epochHosts:     c.InitHostCount,
 		initHosts:      c.InitHostCount,
+		scaleRamp:      c.ScaleRamp,
 		timestampStart: c.Start,
 		timestampEnd:   c.End,
 		interval:       interval,
+
+		gapProbability: c.GapProbability,
+		maxGapLength:   c.MaxGapLength,
+
+		anomalyProbability: c.AnomalyProbability,
+		anomalyIntervals:   anomalyIntervals(c.AnomalyDuration, interval),
+
+		extraTagKeys: extraTagKeys(c.ExtraTagCount),
+
+		churnRate:  c.HostChurnRate,
+		nextHostID: len(hostInfos),
+		newHost:    hostChurner((*commonDevopsSimulatorConfig)(c)),
+
+		deployInterval:   c.DeployInterval,
+		teamReassignRate: c.TeamReassignRate,
 	}}
+	sim.updateGaps()
+	sim.updateAnomalies()
 
 	return sim
 }
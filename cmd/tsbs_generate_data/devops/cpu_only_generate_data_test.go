@@ -44,6 +44,35 @@ func TestCPUOnlySimulatorFields(t *testing.T) {
 	}()
 }
 
+func TestCPUOnlySimulatorSubSecondInterval(t *testing.T) {
+	const interval = 100 * time.Millisecond
+	start := time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC)
+	conf := &CPUOnlySimulatorConfig{
+		Start:           start,
+		End:             start.Add(time.Hour),
+		InitHostCount:   1,
+		HostCount:       1,
+		HostConstructor: NewHostCPUOnly,
+	}
+	s := conf.NewSimulator(interval, 0)
+	p := serialize.NewPoint()
+	n := 0
+	for !s.Finished() {
+		if !s.Next(p) {
+			t.Fatalf("point %d unexpectedly not written", n)
+		}
+		want := start.Add(time.Duration(n) * interval)
+		if got := *p.Timestamp(); !got.Equal(want) {
+			t.Fatalf("incorrect timestamp for point %d: got %v want %v", n, got, want)
+		}
+		n++
+		p.Reset()
+	}
+	if want := 36000; n != want {
+		t.Errorf("incorrect number of points: got %d want %d", n, want)
+	}
+}
+
 func TestCPUOnlySimulatorNext(t *testing.T) {
 	s := testCPUOnlyConf.NewSimulator(time.Second, 0).(*CPUOnlySimulator)
 	// There are two epochs for the test configuration, and a difference of 90
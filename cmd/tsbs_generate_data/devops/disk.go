@@ -56,10 +56,14 @@ type DiskMeasurement struct {
 }
 
 func NewDiskMeasurement(start time.Time) *DiskMeasurement {
+	return newDiskMeasurementWithParams(start, DefaultDistributionParams())
+}
+
+func newDiskMeasurementWithParams(start time.Time, params *DistributionParams) *DiskMeasurement {
 	path := []byte(fmt.Sprintf(pathFmt, rand.Intn(10)))
 	fsType := randomByteStringSliceChoice(diskFSTypeChoices)
 	sub := newSubsystemMeasurement(start, 1)
-	sub.distributions[0] = common.CWD(common.ND(50, 1), 0, oneTerabyte, oneTerabyte/2)
+	sub.distributions[0] = common.CWD(common.ND(params.DiskStepMean, params.DiskStepStdDev), 0, oneTerabyte, oneTerabyte/2)
 
 	return &DiskMeasurement{
 		subsystemMeasurement: sub,
@@ -0,0 +1,97 @@
+package devops
+
+// Field types that can be reported for a field in a data file header
+const (
+	FieldTypeFloat64 = "float64"
+	FieldTypeInt64   = "int64"
+	FieldTypeUInt64  = "uint64"
+	FieldTypeString  = "string"
+	FieldTypeBool    = "bool"
+)
+
+// IsNumericFieldType reports whether fields of type t hold numbers, which
+// loaders can store as floats when the header does not give their type.
+func IsNumericFieldType(t string) bool {
+	switch t {
+	case FieldTypeString, FieldTypeBool:
+		return false
+	}
+	return true
+}
+
+// unsignedFields lists, per measurement, the integer fields that are counters
+// or byte/inode amounts and therefore never negative.
+var unsignedFields = map[string]map[string]bool{
+	string(labelDisk): {
+		string(labelDiskTotal):       true,
+		string(labelDiskFree):        true,
+		string(labelDiskUsed):        true,
+		string(labelDiskINodesTotal): true,
+		string(labelDiskINodesFree):  true,
+		string(labelDiskINodesUsed):  true,
+	},
+	string(labelDiskIO): {
+		"reads":       true,
+		"writes":      true,
+		"read_bytes":  true,
+		"write_bytes": true,
+		"read_time":   true,
+		"write_time":  true,
+		"io_time":     true,
+	},
+	string(labelKernel): {
+		"interrupts":       true,
+		"context_switches": true,
+		"processes_forked": true,
+		"disk_pages_in":    true,
+		"disk_pages_out":   true,
+	},
+	string(labelMem): {
+		"total":     true,
+		"available": true,
+		"used":      true,
+		"free":      true,
+		"cached":    true,
+		"buffered":  true,
+	},
+	string(labelNet): {
+		"bytes_sent":   true,
+		"bytes_recv":   true,
+		"packets_sent": true,
+		"packets_recv": true,
+		"err_in":       true,
+		"err_out":      true,
+		"drop_in":      true,
+		"drop_out":     true,
+	},
+	string(labelNginx): {
+		"accepts":  true,
+		"handled":  true,
+		"requests": true,
+	},
+	string(labelRedis): {
+		"total_connections_received": true,
+		"expired_keys":               true,
+		"evicted_keys":               true,
+		"keyspace_hits":              true,
+		"keyspace_misses":            true,
+	},
+}
+
+// fieldType returns the type to report for a field given the measurement it
+// belongs to and a sample value.
+func fieldType(measurementName string, key []byte, value interface{}) string {
+	switch value.(type) {
+	case int, int64:
+		if unsignedFields[measurementName][string(key)] {
+			return FieldTypeUInt64
+		}
+		return FieldTypeInt64
+	case []byte, string:
+		return FieldTypeString
+	case bool:
+		return FieldTypeBool
+	default:
+		return FieldTypeFloat64
+	}
+}
@@ -30,6 +30,10 @@ func (d *DevopsSimulator) Next(p *serialize.Point) bool {
 		}
 
 		d.adjustNumHostsForEpoch()
+		d.churnHosts()
+		d.updateDynamicTags()
+		d.updateGaps()
+		d.updateAnomalies()
 	}
 
 	return d.populatePoint(p, d.simulatedMeasurementIndex)
@@ -42,8 +46,11 @@ type DevopsSimulatorConfig commonDevopsSimulatorConfig
 func (d *DevopsSimulatorConfig) NewSimulator(interval time.Duration, limit uint64) common.Simulator {
 	hostInfos := make([]Host, d.HostCount)
 	for i := 0; i < len(hostInfos); i++ {
-		hostInfos[i] = d.HostConstructor(i, d.Start)
+		hostInfos[i] = d.HostConstructor(i, d.Start, d.DistributionParams)
 	}
+	applyTagSource(hostInfos, 0, d.TagSource)
+	addExtraTags(hostInfos, d.ExtraTagCount, d.ExtraTagCardinality)
+	assignMeasurements(hostInfos, 0, d.MeasurementCoverage, d.CoverageSeed)
 
 	epochs := calculateEpochs(commonDevopsSimulatorConfig(*d), interval)
 	maxPoints := epochs * d.HostCount * uint64(len(hostInfos[0].SimulatedMeasurements))
@@ -63,12 +70,30 @@ func (d *DevopsSimulatorConfig) NewSimulator(interval time.Duration, limit uint6
 			epochs:         epochs,
 			epochHosts:     d.InitHostCount,
 			initHosts:      d.InitHostCount,
+			scaleRamp:      d.ScaleRamp,
 			timestampStart: d.Start,
 			timestampEnd:   d.End,
 			interval:       interval,
+
+			gapProbability: d.GapProbability,
+			maxGapLength:   d.MaxGapLength,
+
+			anomalyProbability: d.AnomalyProbability,
+			anomalyIntervals:   anomalyIntervals(d.AnomalyDuration, interval),
+
+			extraTagKeys: extraTagKeys(d.ExtraTagCount),
+
+			churnRate:  d.HostChurnRate,
+			nextHostID: len(hostInfos),
+			newHost:    hostChurner((*commonDevopsSimulatorConfig)(d)),
+
+			deployInterval:   d.DeployInterval,
+			teamReassignRate: d.TeamReassignRate,
 		},
 		simulatedMeasurementIndex: 0,
 	}
+	dg.updateGaps()
+	dg.updateAnomalies()
 
 	return dg
 }
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/timescale/tsbs/cmd/tsbs_generate_data/common"
+	"github.com/timescale/tsbs/cmd/tsbs_generate_data/serialize"
 )
 
 // Count of choices for auto-generated tag values:
@@ -136,15 +137,22 @@ type Host struct {
 	// These are all assigned once, at Host creation:
 	Name, Region, Datacenter, Rack, OS, Arch          []byte
 	Team, Service, ServiceVersion, ServiceEnvironment []byte
+
+	// ExtraTags holds the values of any extra tags, in the order of their keys
+	ExtraTags [][]byte
+
+	// missing marks, by position in SimulatedMeasurements, the measurements
+	// that are simulated but never reported by this Host. nil if it reports all.
+	missing []bool
 }
 
-func newHostMeasurements(start time.Time) []common.SimulatedMeasurement {
+func newHostMeasurements(start time.Time, params *DistributionParams) []common.SimulatedMeasurement {
 	return []common.SimulatedMeasurement{
-		NewCPUMeasurement(start),
+		newCPUMeasurementWithParams(start, len(cpuFields), params),
 		NewDiskIOMeasurement(start),
-		NewDiskMeasurement(start),
+		newDiskMeasurementWithParams(start, params),
 		NewKernelMeasurement(start),
-		NewMemMeasurement(start),
+		newMemMeasurementWithParams(start, params),
 		NewNetMeasurement(start),
 		NewNginxMeasurement(start),
 		NewPostgresqlMeasurement(start),
@@ -152,37 +160,67 @@ func newHostMeasurements(start time.Time) []common.SimulatedMeasurement {
 	}
 }
 
-func newCPUOnlyHostMeasurements(start time.Time) []common.SimulatedMeasurement {
+func newCPUOnlyHostMeasurements(start time.Time, params *DistributionParams) []common.SimulatedMeasurement {
 	return []common.SimulatedMeasurement{
-		NewCPUMeasurement(start),
+		newCPUMeasurementWithParams(start, len(cpuFields), params),
 	}
 }
 
-func newCPUSingleHostMeasurements(start time.Time) []common.SimulatedMeasurement {
+func newCPUSingleHostMeasurements(start time.Time, params *DistributionParams) []common.SimulatedMeasurement {
 	return []common.SimulatedMeasurement{
-		newSingleCPUMeasurement(start),
+		newCPUMeasurementWithParams(start, 1, params),
 	}
 }
 
-// NewHost creates a new host in a simulated devops use case
-func NewHost(i int, start time.Time) Host {
-	return newHostWithMeasurementGenerator(i, start, newHostMeasurements)
+// NewHost creates a new host in a simulated devops use case. If params is nil,
+// the default distribution parameters are used.
+func NewHost(i int, start time.Time, params *DistributionParams) Host {
+	return newHostWithMeasurementGenerator(i, start, params, newHostMeasurements)
 }
 
 // NewHostCPUOnly creates a new host in a simulated cpu-only use case, which is a subset of a devops case
 // with only CPU metrics simulated
-func NewHostCPUOnly(i int, start time.Time) Host {
-	return newHostWithMeasurementGenerator(i, start, newCPUOnlyHostMeasurements)
+func NewHostCPUOnly(i int, start time.Time, params *DistributionParams) Host {
+	return newHostWithMeasurementGenerator(i, start, params, newCPUOnlyHostMeasurements)
 }
 
 // NewHostCPUSingle creates a new host in a simulated cpu-single use case, which is a subset of a devops case
 // with only a single CPU metric is simulated
-func NewHostCPUSingle(i int, start time.Time) Host {
-	return newHostWithMeasurementGenerator(i, start, newCPUSingleHostMeasurements)
+func NewHostCPUSingle(i int, start time.Time, params *DistributionParams) Host {
+	return newHostWithMeasurementGenerator(i, start, params, newCPUSingleHostMeasurements)
 }
 
-func newHostWithMeasurementGenerator(i int, start time.Time, generator func(time.Time) []common.SimulatedMeasurement) Host {
-	sm := generator(start)
+// seasonalMeasurement is implemented by measurements whose bounded fields can
+// be given seasonality, which is every measurement embedding a subsystemMeasurement.
+type seasonalMeasurement interface {
+	addSeasonality(common.Seasonality)
+}
+
+// applySeasonality adds to each measurement the seasonality configured for its name.
+func applySeasonality(measurements []common.SimulatedMeasurement, seasonality map[string]common.Seasonality) {
+	if len(seasonality) == 0 {
+		return
+	}
+	p := serialize.NewPoint()
+	for _, m := range measurements {
+		sm, ok := m.(seasonalMeasurement)
+		if !ok {
+			continue
+		}
+		p.Reset()
+		m.ToPoint(p)
+		if s, ok := seasonality[string(p.MeasurementName())]; ok {
+			sm.addSeasonality(s)
+		}
+	}
+}
+
+func newHostWithMeasurementGenerator(i int, start time.Time, params *DistributionParams, generator func(time.Time, *DistributionParams) []common.SimulatedMeasurement) Host {
+	if params == nil {
+		params = DefaultDistributionParams()
+	}
+	sm := generator(start, params)
+	applySeasonality(sm, params.Seasonality)
 
 	region := randomRegionSliceChoice(regions)
 
@@ -205,6 +243,43 @@ func newHostWithMeasurementGenerator(i int, start time.Time, generator func(time
 	return h
 }
 
+// coreMeasurements are reported by every host regardless of measurement coverage
+var coreMeasurements = map[string]bool{
+	string(labelCPU):  true,
+	string(labelMem):  true,
+	string(labelDisk): true,
+}
+
+// reports tells whether the Host reports the i-th of its SimulatedMeasurements
+func (h *Host) reports(i int) bool {
+	return h.missing == nil || !h.missing[i]
+}
+
+// assignMeasurements picks which measurements each host reports, keeping each
+// non-core one with probability coverage, first being the id of hosts[0].
+// The picks are drawn from their own source seeded by seed and first, not the
+// global one the distributions draw from, and all measurements are still
+// simulated for every host, so the data of the ones reported does not depend
+// on coverage.
+func assignMeasurements(hosts []Host, first int, coverage float64, seed int64) {
+	if coverage <= 0 || coverage >= 1 {
+		return
+	}
+	r := rand.New(rand.NewSource(seed + int64(first)))
+	p := serialize.NewPoint()
+	for i := range hosts {
+		h := &hosts[i]
+		h.missing = make([]bool, len(h.SimulatedMeasurements))
+		for j, m := range h.SimulatedMeasurements {
+			p.Reset()
+			m.ToPoint(p)
+			if !coreMeasurements[string(p.MeasurementName())] {
+				h.missing[j] = r.Float64() >= coverage
+			}
+		}
+	}
+}
+
 // TickAll advances all Distributions of a Host.
 func (h *Host) TickAll(d time.Duration) {
 	for i := range h.SimulatedMeasurements {
@@ -13,7 +13,7 @@ import (
 
 func TestNewHostMeasurements(t *testing.T) {
 	start := time.Now()
-	measurements := newHostMeasurements(start)
+	measurements := newHostMeasurements(start, DefaultDistributionParams())
 	if got := len(measurements); got != 9 {
 		t.Errorf("incorrect number of measurements: got %d want %d", got, 9)
 	}
@@ -61,7 +61,7 @@ func TestNewHostMeasurements(t *testing.T) {
 
 func TestNewCPUOnlyHostMeasurements(t *testing.T) {
 	start := time.Now()
-	measurements := newCPUOnlyHostMeasurements(start)
+	measurements := newCPUOnlyHostMeasurements(start, DefaultDistributionParams())
 	if got := len(measurements); got != 1 {
 		t.Errorf("incorrect number of measurements: got %d want %d", got, 9)
 	}
@@ -77,7 +77,7 @@ func TestNewCPUOnlyHostMeasurements(t *testing.T) {
 
 func TestNewCPUSingleHostMeasurements(t *testing.T) {
 	start := time.Now()
-	measurements := newCPUSingleHostMeasurements(start)
+	measurements := newCPUSingleHostMeasurements(start, DefaultDistributionParams())
 	if got := len(measurements); got != 1 {
 		t.Errorf("incorrect number of measurements: got %d want %d", got, 9)
 	}
@@ -95,7 +95,7 @@ func TestNewHost(t *testing.T) {
 	now := time.Now()
 	// test 1000 times to get diversity of results
 	for i := 0; i < 1000; i++ {
-		h := NewHost(i, now)
+		h := NewHost(i, now, nil)
 		if got := len(h.SimulatedMeasurements); got != 9 {
 			t.Errorf("incorrect number of measurements: got %d want %d", got, 9)
 		}
@@ -118,7 +118,7 @@ func TestNewHostCPUOnly(t *testing.T) {
 	now := time.Now()
 	// test 1000 times to get diversity of results
 	for i := 0; i < 1000; i++ {
-		h := NewHostCPUOnly(i, now)
+		h := NewHostCPUOnly(i, now, nil)
 		if got := len(h.SimulatedMeasurements); got != 1 {
 			t.Errorf("incorrect number of measurements: got %d want %d", got, 9)
 		}
@@ -141,7 +141,7 @@ func TestNewHostCPUSingle(t *testing.T) {
 	now := time.Now()
 	// test 1000 times to get diversity of results
 	for i := 0; i < 1000; i++ {
-		h := NewHostCPUSingle(i, now)
+		h := NewHostCPUSingle(i, now, nil)
 		if got := len(h.SimulatedMeasurements); got != 1 {
 			t.Errorf("incorrect number of measurements: got %d want %d", got, 9)
 		}
@@ -160,7 +160,7 @@ func TestNewHostCPUSingle(t *testing.T) {
 	}
 }
 
-func testGenerator(s time.Time) []common.SimulatedMeasurement {
+func testGenerator(s time.Time, _ *DistributionParams) []common.SimulatedMeasurement {
 	return []common.SimulatedMeasurement{
 		&testMeasurement{ticks: 0},
 	}
@@ -189,7 +189,7 @@ func TestNewHostWithMeasurementGenerator(t *testing.T) {
 	now := time.Now()
 	// test 1000 times to get diversity of results
 	for i := 0; i < 1000; i++ {
-		h := newHostWithMeasurementGenerator(i, now, testGenerator)
+		h := newHostWithMeasurementGenerator(i, now, nil, testGenerator)
 		wantName := fmt.Sprintf(hostFmt, i)
 		if got := string(h.Name); got != wantName {
 			t.Errorf("incorrect host name format: got %s want %s", got, wantName)
@@ -219,7 +219,7 @@ func (m *testMeasurement) ToPoint(_ *serialize.Point) {}
 
 func TestHostTickAll(t *testing.T) {
 	now := time.Now()
-	h := newHostWithMeasurementGenerator(0, now, testGenerator)
+	h := newHostWithMeasurementGenerator(0, now, nil, testGenerator)
 	if got := h.SimulatedMeasurements[0].(*testMeasurement).ticks; got != 0 {
 		t.Errorf("ticks not equal to 0 to start: got %d", got)
 	}
@@ -0,0 +1,198 @@
+package devops
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TagSource provides the machine tag values of hosts, replacing the randomly
+// generated ones, e.g., to match a real inventory.
+type TagSource interface {
+	// Tags returns the values of MachineTagKeys, in order, for host i or nil
+	// if the source has no values for that host.
+	Tags(i int) [][]byte
+}
+
+// HostInventory is a TagSource backed by a fixed list of hosts.
+type HostInventory struct {
+	hosts [][][]byte
+	cycle bool
+}
+
+// NewHostInventory creates a HostInventory from a list of tag values per host.
+// If cycle is set, the hosts are reused once exhausted, with the round number
+// appended to their hostnames to keep them unique.
+func NewHostInventory(hosts [][][]byte, cycle bool) *HostInventory {
+	return &HostInventory{hosts: hosts, cycle: cycle}
+}
+
+// Len returns the number of hosts in the inventory.
+func (inv *HostInventory) Len() int {
+	return len(inv.hosts)
+}
+
+// Tags returns the tag values of host i.
+func (inv *HostInventory) Tags(i int) [][]byte {
+	if len(inv.hosts) == 0 || (i >= len(inv.hosts) && !inv.cycle) {
+		return nil
+	}
+	tags := inv.hosts[i%len(inv.hosts)]
+	if round := i / len(inv.hosts); round > 0 {
+		cycled := make([][]byte, len(tags))
+		copy(cycled, tags)
+		cycled[0] = []byte(fmt.Sprintf("%s_%d", tags[0], round))
+		return cycled
+	}
+	return tags
+}
+
+// setTags overrides the machine tag values of h with tags, given in the order
+// of MachineTagKeys.
+func (h *Host) setTags(tags [][]byte) {
+	h.Name = tags[0]
+	h.Region = tags[1]
+	h.Datacenter = tags[2]
+	h.Rack = tags[3]
+	h.OS = tags[4]
+	h.Arch = tags[5]
+	h.Team = tags[6]
+	h.Service = tags[7]
+	h.ServiceVersion = tags[8]
+	h.ServiceEnvironment = tags[9]
+}
+
+// applyTagSource overrides the tags of each host with those given by source,
+// if any. The first host in hosts has id firstID.
+func applyTagSource(hosts []Host, firstID int, source TagSource) {
+	if source == nil {
+		return
+	}
+	for i := range hosts {
+		if tags := source.Tags(firstID + i); tags != nil {
+			hosts[i].setTags(tags)
+		}
+	}
+}
+
+// LoadHostInventory reads a HostInventory from filename. Files ending in
+// .json or .jsonl hold one JSON object per line, while any other file is a CSV
+// with a header line. Either way every host needs a value for each of
+// MachineTagKeys, and hostnames must be unique.
+func LoadHostInventory(filename string, cycle bool) (*HostInventory, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open host file %s: %v", filename, err)
+	}
+	defer f.Close()
+
+	var hosts [][][]byte
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json", ".jsonl":
+		hosts, err = readHostsJSON(f)
+	default:
+		hosts, err = readHostsCSV(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid host file %s: %v", filename, err)
+	}
+	return NewHostInventory(hosts, cycle), nil
+}
+
+// hostValidator checks the tag values of each host read, tracking hostnames
+// to catch duplicates.
+type hostValidator struct {
+	seen map[string]int
+}
+
+func (v *hostValidator) check(line int, tags map[string]string) ([][]byte, error) {
+	ret := make([][]byte, len(MachineTagKeys))
+	for i, key := range MachineTagKeys {
+		val, ok := tags[string(key)]
+		if !ok || len(val) == 0 {
+			return nil, fmt.Errorf("line %d: missing value for '%s'", line, key)
+		}
+		if strings.ContainsAny(val, ",= \n") {
+			return nil, fmt.Errorf("line %d: value of '%s' cannot contain commas, equal signs or whitespace: '%s'", line, key, val)
+		}
+		ret[i] = []byte(val)
+	}
+
+	name := string(ret[0])
+	if first, ok := v.seen[name]; ok {
+		return nil, fmt.Errorf("line %d: duplicate hostname '%s' (first on line %d)", line, name, first)
+	}
+	v.seen[name] = line
+	return ret, nil
+}
+
+func readHostsCSV(r io.Reader) ([][][]byte, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("line 1: missing header")
+	} else if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]bool)
+	for _, col := range header {
+		columns[strings.TrimSpace(col)] = true
+	}
+	for _, key := range MachineTagKeys {
+		if !columns[string(key)] {
+			return nil, fmt.Errorf("line 1: missing column '%s'", key)
+		}
+	}
+
+	v := &hostValidator{seen: make(map[string]int)}
+	var hosts [][][]byte
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if len(record) != len(header) {
+			return nil, fmt.Errorf("line %d: expected %d values, got %d", line, len(header), len(record))
+		}
+		tags := make(map[string]string)
+		for i, col := range header {
+			tags[strings.TrimSpace(col)] = strings.TrimSpace(record[i])
+		}
+		host, err := v.check(line, tags)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+func readHostsJSON(r io.Reader) ([][][]byte, error) {
+	v := &hostValidator{seen: make(map[string]int)}
+	var hosts [][][]byte
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 {
+			continue
+		}
+		tags := make(map[string]string)
+		if err := json.Unmarshal([]byte(text), &tags); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		host, err := v.check(line, tags)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, scanner.Err()
+}
@@ -0,0 +1,197 @@
+package devops
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/timescale/tsbs/cmd/tsbs_generate_data/serialize"
+)
+
+const testInventoryHeader = "hostname,region,datacenter,rack,os,arch,team,service,service_version,service_environment\n"
+
+func writeTestHostFile(t *testing.T, dir, name, contents string) string {
+	filename := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(filename, []byte(contents), 0644); err != nil {
+		t.Fatalf("could not write host file: %v", err)
+	}
+	return filename
+}
+
+func TestLoadHostInventory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inventory")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		desc      string
+		name      string
+		contents  string
+		wantNames []string
+		wantErr   string
+	}{
+		{
+			desc:      "csv",
+			name:      "hosts.csv",
+			contents:  testInventoryHeader + "web01,us-east-1,us-east-1a,1,Ubuntu16.04LTS,x64,SF,1,0,production\ndb01,eu-west-1,eu-west-1b,2,Ubuntu16.10,x86,NYC,2,1,staging\n",
+			wantNames: []string{"web01", "db01"},
+		},
+		{
+			desc:      "csv with reordered and extra columns",
+			name:      "hosts.csv",
+			contents:  "owner,service_environment,service_version,service,team,arch,os,rack,datacenter,region,hostname\nme,production,0,1,SF,x64,Ubuntu16.04LTS,1,us-east-1a,us-east-1,web01\n",
+			wantNames: []string{"web01"},
+		},
+		{
+			desc:     "csv missing column",
+			name:     "hosts.csv",
+			contents: "hostname,region\nweb01,us-east-1\n",
+			wantErr:  "line 1: missing column 'datacenter'",
+		},
+		{
+			desc:     "csv missing value",
+			name:     "hosts.csv",
+			contents: testInventoryHeader + "web01,us-east-1,us-east-1a,1,Ubuntu16.04LTS,x64,SF,1,0,production\ndb01,,eu-west-1b,2,Ubuntu16.10,x86,NYC,2,1,staging\n",
+			wantErr:  "line 3: missing value for 'region'",
+		},
+		{
+			desc:     "csv duplicate hostname",
+			name:     "hosts.csv",
+			contents: testInventoryHeader + "web01,us-east-1,us-east-1a,1,Ubuntu16.04LTS,x64,SF,1,0,production\nweb01,eu-west-1,eu-west-1b,2,Ubuntu16.10,x86,NYC,2,1,staging\n",
+			wantErr:  "line 3: duplicate hostname 'web01' (first on line 2)",
+		},
+		{
+			desc:     "csv invalid value",
+			name:     "hosts.csv",
+			contents: testInventoryHeader + "web 01,us-east-1,us-east-1a,1,Ubuntu16.04LTS,x64,SF,1,0,production\n",
+			wantErr:  "line 2: value of 'hostname' cannot contain",
+		},
+		{
+			desc: "json lines",
+			name: "hosts.json",
+			contents: `{"hostname":"web01","region":"us-east-1","datacenter":"us-east-1a","rack":"1","os":"Ubuntu16.04LTS","arch":"x64","team":"SF","service":"1","service_version":"0","service_environment":"production"}
+
+{"hostname":"db01","region":"eu-west-1","datacenter":"eu-west-1b","rack":"2","os":"Ubuntu16.10","arch":"x86","team":"NYC","service":"2","service_version":"1","service_environment":"staging"}
+`,
+			wantNames: []string{"web01", "db01"},
+		},
+		{
+			desc: "json lines missing value",
+			name: "hosts.json",
+			contents: `{"hostname":"web01","region":"us-east-1","datacenter":"us-east-1a","rack":"1","os":"Ubuntu16.04LTS","arch":"x64","team":"SF","service":"1","service_version":"0","service_environment":"production"}
+{"hostname":"db01","region":"eu-west-1"}
+`,
+			wantErr: "line 2: missing value for 'datacenter'",
+		},
+		{
+			desc:     "json lines bad json",
+			name:     "hosts.json",
+			contents: "{\"hostname\":\n",
+			wantErr:  "line 1: ",
+		},
+	}
+
+	for _, c := range cases {
+		filename := writeTestHostFile(t, dir, c.name, c.contents)
+		inv, err := LoadHostInventory(filename, false)
+		if len(c.wantErr) > 0 {
+			if err == nil {
+				t.Errorf("%s: unexpected lack of error", c.desc)
+			} else if !strings.Contains(err.Error(), c.wantErr) {
+				t.Errorf("%s: incorrect error: got\n%s\nwant it to contain\n%s", c.desc, err.Error(), c.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.desc, err)
+			continue
+		}
+		if got := inv.Len(); got != len(c.wantNames) {
+			t.Errorf("%s: incorrect number of hosts: got %d want %d", c.desc, got, len(c.wantNames))
+			continue
+		}
+		for i, want := range c.wantNames {
+			tags := inv.Tags(i)
+			if got := len(tags); got != len(MachineTagKeys) {
+				t.Errorf("%s: incorrect number of tags: got %d want %d", c.desc, got, len(MachineTagKeys))
+			} else if got := string(tags[0]); got != want {
+				t.Errorf("%s: incorrect hostname: got %s want %s", c.desc, got, want)
+			}
+		}
+	}
+
+	if _, err := LoadHostInventory(filepath.Join(dir, "missing.csv"), false); err == nil {
+		t.Errorf("unexpected lack of error for missing file")
+	}
+}
+
+func TestHostInventoryTags(t *testing.T) {
+	hosts := [][][]byte{
+		{[]byte("a"), []byte("r1")},
+		{[]byte("b"), []byte("r2")},
+	}
+	inv := NewHostInventory(hosts, false)
+	if got := inv.Tags(2); got != nil {
+		t.Errorf("tags returned past end without cycling: got %s", got)
+	}
+
+	inv = NewHostInventory(hosts, true)
+	cases := []struct {
+		i        int
+		wantName string
+	}{
+		{0, "a"},
+		{1, "b"},
+		{2, "a_1"},
+		{5, "b_2"},
+	}
+	for _, c := range cases {
+		tags := inv.Tags(c.i)
+		if got := string(tags[0]); got != c.wantName {
+			t.Errorf("incorrect hostname for host %d: got %s want %s", c.i, got, c.wantName)
+		}
+		if got := string(tags[1]); got != string(hosts[c.i%2][1]) {
+			t.Errorf("incorrect region for host %d: got %s want %s", c.i, got, hosts[c.i%2][1])
+		}
+	}
+	if got := string(hosts[0][0]); got != "a" {
+		t.Errorf("cycling modified the inventory: got %s", got)
+	}
+}
+
+func TestSimulatorWithTagSource(t *testing.T) {
+	hosts := make([][][]byte, 0)
+	for _, name := range []string{"web01", "web02"} {
+		tags := make([][]byte, len(MachineTagKeys))
+		for i, key := range MachineTagKeys {
+			tags[i] = []byte(string(key) + "_" + name)
+		}
+		tags[0] = []byte(name)
+		hosts = append(hosts, tags)
+	}
+	conf := &CPUOnlySimulatorConfig{
+		Start:           testTime,
+		End:             testTime.Add(time.Second),
+		InitHostCount:   3,
+		HostCount:       3,
+		HostConstructor: NewHostCPUOnly,
+		TagSource:       NewHostInventory(hosts, true),
+	}
+	s := conf.NewSimulator(time.Second, 0)
+	p := serialize.NewPoint()
+	for _, want := range []string{"web01", "web02", "web01_1"} {
+		s.Next(p)
+		if got := string(p.GetTagValue(MachineTagKeys[0])); got != want {
+			t.Errorf("incorrect hostname: got %s want %s", got, want)
+		}
+		if got, want := string(p.GetTagValue(MachineTagKeys[1])), "region_"+strings.SplitN(want, "_", 2)[0]; got != want {
+			t.Errorf("incorrect region: got %s want %s", got, want)
+		}
+		p.Reset()
+	}
+}
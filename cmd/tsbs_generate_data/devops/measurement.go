@@ -10,6 +10,12 @@ import (
 type subsystemMeasurement struct {
 	timestamp     time.Time
 	distributions []common.Distribution
+
+	// While anomalyLeft > 0 the clamped distributions are pinned to their
+	// maximum (or minimum) value, and afterwards restored to anomalySaved.
+	anomalyLeft  uint64
+	anomalyHigh  bool
+	anomalySaved []float64
 }
 
 func newSubsystemMeasurement(start time.Time, numDistributions int) *subsystemMeasurement {
@@ -32,6 +38,87 @@ func (m *subsystemMeasurement) Tick(d time.Duration) {
 	for i := range m.distributions {
 		m.distributions[i].Advance()
 	}
+	if m.anomalyLeft > 0 {
+		m.pinAnomaly()
+	}
+}
+
+// canAnomaly reports whether the measurement has any distributions that an
+// anomaly would affect.
+func (m *subsystemMeasurement) canAnomaly() bool {
+	for _, d := range m.distributions {
+		if _, ok := clampedWalk(d); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *subsystemMeasurement) inAnomaly() bool {
+	return m.anomalyLeft > 0
+}
+
+// startAnomaly pins the clamped distributions to their max (if high) or min
+// for the given number of intervals, including the current one.
+func (m *subsystemMeasurement) startAnomaly(intervals uint64, high bool) {
+	m.anomalySaved = m.anomalySaved[:0]
+	for _, d := range m.distributions {
+		if cwd, ok := clampedWalk(d); ok {
+			m.anomalySaved = append(m.anomalySaved, cwd.State)
+		}
+	}
+	m.anomalyLeft = intervals
+	m.anomalyHigh = high
+	m.pinAnomaly()
+}
+
+// stepAnomaly moves an ongoing anomaly on to the next interval, reverting the
+// distributions to their pre-anomaly state once it is over.
+func (m *subsystemMeasurement) stepAnomaly() {
+	m.anomalyLeft--
+	if m.anomalyLeft > 0 {
+		return
+	}
+	i := 0
+	for _, d := range m.distributions {
+		if cwd, ok := clampedWalk(d); ok {
+			cwd.State = m.anomalySaved[i]
+			i++
+		}
+	}
+}
+
+func (m *subsystemMeasurement) pinAnomaly() {
+	for _, d := range m.distributions {
+		if cwd, ok := clampedWalk(d); ok {
+			if m.anomalyHigh {
+				cwd.State = cwd.Max
+			} else {
+				cwd.State = cwd.Min
+			}
+		}
+	}
+}
+
+// addSeasonality makes the clamped distributions follow s. The amplitudes of s
+// are fractions of each distribution's range.
+func (m *subsystemMeasurement) addSeasonality(s common.Seasonality) {
+	for i, d := range m.distributions {
+		if cwd, ok := d.(*common.ClampedRandomWalkDistribution); ok {
+			r := cwd.Max - cwd.Min
+			scaled := common.Seasonality{Daily: s.Daily * r, Weekend: s.Weekend * r}
+			m.distributions[i] = common.SD(cwd, scaled, cwd.Min, cwd.Max, &m.timestamp)
+		}
+	}
+}
+
+// clampedWalk returns the clamped random walk d is, or is based on.
+func clampedWalk(d common.Distribution) (*common.ClampedRandomWalkDistribution, bool) {
+	if sd, ok := d.(*common.SeasonalDistribution); ok {
+		d = sd.Base
+	}
+	cwd, ok := d.(*common.ClampedRandomWalkDistribution)
+	return cwd, ok
 }
 
 func (m *subsystemMeasurement) toPoint(p *serialize.Point, measurementName []byte, labels []labeledDistributionMaker) {
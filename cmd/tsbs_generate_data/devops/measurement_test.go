@@ -178,3 +178,91 @@ func testCommonToPoint(t *testing.T, p *serialize.Point, fieldVal float64) {
 		}
 	}
 }
+
+func TestSubsystemMeasurementAnomaly(t *testing.T) {
+	m := newSubsystemMeasurement(time.Now(), 2)
+	m.distributions[0] = common.CWD(common.ND(0, 1), 0, 100, 50)
+	m.distributions[1] = &monotonicDistribution{}
+	if !m.canAnomaly() {
+		t.Fatalf("measurement with clamped distribution cannot have anomaly")
+	}
+
+	m.startAnomaly(2, true)
+	if !m.inAnomaly() {
+		t.Errorf("measurement not in anomaly after start")
+	}
+	if got := m.distributions[0].Get(); got != 100 {
+		t.Errorf("clamped distribution not pinned high: got %v", got)
+	}
+	m.Tick(time.Second)
+	if got := m.distributions[0].Get(); got != 100 {
+		t.Errorf("clamped distribution not pinned high after tick: got %v", got)
+	}
+	if got := m.distributions[1].Get(); got != 1 {
+		t.Errorf("unclamped distribution affected by anomaly: got %v", got)
+	}
+	m.stepAnomaly()
+	if !m.inAnomaly() {
+		t.Errorf("anomaly ended too early")
+	}
+	m.Tick(time.Second)
+	m.stepAnomaly()
+	if m.inAnomaly() {
+		t.Errorf("anomaly did not end")
+	}
+	if got := m.distributions[0].Get(); got != 50 {
+		t.Errorf("clamped distribution not restored: got %v want %v", got, 50)
+	}
+
+	m.startAnomaly(1, false)
+	if got := m.distributions[0].Get(); got != 0 {
+		t.Errorf("clamped distribution not pinned low: got %v", got)
+	}
+
+	m = newSubsystemMeasurement(time.Now(), 1)
+	m.distributions[0] = &monotonicDistribution{}
+	if m.canAnomaly() {
+		t.Errorf("measurement without clamped distributions can have anomaly")
+	}
+}
+
+func TestSubsystemMeasurementAddSeasonality(t *testing.T) {
+	friday := time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC)
+	m := newSubsystemMeasurement(friday, 2)
+	m.distributions[0] = common.CWD(common.ND(0, 0), 0, 200, 100)
+	m.distributions[1] = &monotonicDistribution{}
+	m.addSeasonality(common.Seasonality{Daily: 0.2, Weekend: 0.1})
+	if _, ok := m.distributions[0].(*common.SeasonalDistribution); !ok {
+		t.Fatalf("clamped distribution not made seasonal")
+	}
+	if _, ok := m.distributions[1].(*monotonicDistribution); !ok {
+		t.Errorf("unclamped distribution made seasonal")
+	}
+
+	cases := []struct {
+		desc string
+		d    time.Duration
+		want float64
+	}{
+		{desc: "friday midnight", d: 0, want: 80},
+		{desc: "friday 06:00", d: 6 * time.Hour, want: 100},
+		{desc: "friday midday", d: 6 * time.Hour, want: 120},
+		{desc: "saturday midday", d: 24 * time.Hour, want: 100},
+		{desc: "monday midday", d: 48 * time.Hour, want: 120},
+	}
+	for _, c := range cases {
+		m.Tick(c.d)
+		if got := m.distributions[0].Get(); math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("%s: incorrect value: got %v want %v", c.desc, got, c.want)
+		}
+	}
+
+	// anomalies still pin the underlying random walk
+	if !m.canAnomaly() {
+		t.Fatalf("seasonal measurement cannot have anomaly")
+	}
+	m.startAnomaly(1, false)
+	if got := m.distributions[0].(*common.SeasonalDistribution).Base.Get(); got != 0 {
+		t.Errorf("seasonal distribution not pinned low: got %v", got)
+	}
+}
@@ -33,20 +33,26 @@ type MemMeasurement struct {
 }
 
 func NewMemMeasurement(start time.Time) *MemMeasurement {
+	return newMemMeasurementWithParams(start, DefaultDistributionParams())
+}
+
+func newMemMeasurementWithParams(start time.Time, params *DistributionParams) *MemMeasurement {
 	sub := newSubsystemMeasurement(start, 3)
 	bytesTotal := randomInt64SliceChoice(memoryTotalChoices)
+	low := params.MemMin * float64(bytesTotal)
+	high := params.MemMax * float64(bytesTotal)
 
 	// Reuse NormalDistributions as arguments to other distributions. This is
 	// safe to do because the higher-level distribution advances the ND and
 	// immediately uses its value and saves the state
-	nd := common.ND(0.0, float64(bytesTotal)/64)
+	nd := common.ND(0.0, float64(bytesTotal)*params.MemStepStdDev)
 
 	// used bytes
-	sub.distributions[0] = common.CWD(nd, 0.0, float64(bytesTotal), rand.Float64()*float64(bytesTotal))
+	sub.distributions[0] = common.CWD(nd, low, high, low+rand.Float64()*(high-low))
 	// cached bytes
-	sub.distributions[1] = common.CWD(nd, 0.0, float64(bytesTotal), rand.Float64()*float64(bytesTotal))
+	sub.distributions[1] = common.CWD(nd, low, high, low+rand.Float64()*(high-low))
 	// buffered bytes
-	sub.distributions[2] = common.CWD(nd, 0.0, float64(bytesTotal), rand.Float64()*float64(bytesTotal))
+	sub.distributions[2] = common.CWD(nd, low, high, low+rand.Float64()*(high-low))
 	return &MemMeasurement{
 		subsystemMeasurement: sub,
 		bytesTotal:           bytesTotal,
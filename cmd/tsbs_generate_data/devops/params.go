@@ -0,0 +1,145 @@
+package devops
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/timescale/tsbs/cmd/tsbs_generate_data/common"
+)
+
+// DistributionParams holds the tunable parameters of the distributions used
+// to simulate devops measurements.
+type DistributionParams struct {
+	// CPUStepStdDev is the std dev of each random walk step of the CPU usage fields
+	CPUStepStdDev float64
+	// DiskStepMean is the mean change of free disk bytes per interval
+	DiskStepMean float64
+	// DiskStepStdDev is the std dev of the change of free disk bytes per interval
+	DiskStepStdDev float64
+	// MemStepStdDev is the std dev of each random walk step of the memory fields, as a fraction of total memory
+	MemStepStdDev float64
+	// MemMin is the lower bound of the memory fields, as a fraction of total memory
+	MemMin float64
+	// MemMax is the upper bound of the memory fields, as a fraction of total memory
+	MemMax float64
+	// Seasonality maps measurement names to the seasonality of their bounded
+	// fields, with amplitudes as fractions of each field's range; nil means none
+	Seasonality map[string]common.Seasonality
+}
+
+// DefaultDistributionParams returns the parameters that produce the standard
+// devops dataset.
+func DefaultDistributionParams() *DistributionParams {
+	return &DistributionParams{
+		CPUStepStdDev:  1.0,
+		DiskStepMean:   50,
+		DiskStepStdDev: 1,
+		MemStepStdDev:  1.0 / 64,
+		MemMin:         0.0,
+		MemMax:         1.0,
+	}
+}
+
+// ParseDistributionParams parses a comma-separated list of key=value pairs
+// into DistributionParams, e.g. "cpu.step_stddev=2.5,mem.max=0.9". Keys that
+// are not specified keep their default value.
+func ParseDistributionParams(spec string) (*DistributionParams, error) {
+	p := DefaultDistributionParams()
+	if len(strings.TrimSpace(spec)) == 0 {
+		return p, nil
+	}
+
+	for _, kv := range strings.Split(spec, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid distribution param '%s': expected key=value", kv)
+		}
+		key := strings.TrimSpace(parts[0])
+		val, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for distribution param '%s': %v", key, err)
+		}
+
+		switch key {
+		case "cpu.step_stddev":
+			p.CPUStepStdDev = val
+		case "disk.step_mean":
+			p.DiskStepMean = val
+		case "disk.step_stddev":
+			p.DiskStepStdDev = val
+		case "mem.step_stddev":
+			p.MemStepStdDev = val
+		case "mem.min":
+			p.MemMin = val
+		case "mem.max":
+			p.MemMax = val
+		default:
+			return nil, fmt.Errorf("unknown distribution param '%s'", key)
+		}
+	}
+
+	return p, p.validate()
+}
+
+func (p *DistributionParams) validate() error {
+	if p.CPUStepStdDev < 0 || p.DiskStepStdDev < 0 || p.MemStepStdDev < 0 {
+		return fmt.Errorf("distribution std devs cannot be negative")
+	}
+	if p.MemMin < 0 || p.MemMax > 1 || p.MemMin >= p.MemMax {
+		return fmt.Errorf("memory bounds must satisfy 0 <= mem.min < mem.max <= 1: got %v and %v", p.MemMin, p.MemMax)
+	}
+	return nil
+}
+
+// seasonalMeasurementNames are the measurements with bounded fields, to which
+// seasonality can be added.
+var seasonalMeasurementNames = map[string]bool{
+	string(labelCPU):        true,
+	string(labelDisk):       true,
+	string(labelMem):        true,
+	string(labelNginx):      true,
+	string(labelPostgresql): true,
+	string(labelRedis):      true,
+}
+
+// ParseSeasonality parses a comma-separated list of measurement=daily[:weekend]
+// entries, e.g. "cpu=0.3:0.1,mem=0.2". The daily amplitude is the difference
+// between the midday peak and the midnight trough and the weekend amplitude is
+// how much lower values are on weekends, both as fractions of a field's range.
+func ParseSeasonality(spec string) (map[string]common.Seasonality, error) {
+	if len(strings.TrimSpace(spec)) == 0 {
+		return nil, nil
+	}
+
+	ret := make(map[string]common.Seasonality)
+	for _, kv := range strings.Split(spec, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid seasonality '%s': expected measurement=daily[:weekend]", kv)
+		}
+		name := strings.TrimSpace(parts[0])
+		if !seasonalMeasurementNames[name] {
+			return nil, fmt.Errorf("seasonality not supported for measurement '%s'", name)
+		}
+
+		var s common.Seasonality
+		amplitudes := strings.SplitN(parts[1], ":", 2)
+		for i, dst := range []*float64{&s.Daily, &s.Weekend} {
+			if i >= len(amplitudes) {
+				break
+			}
+			val, err := strconv.ParseFloat(strings.TrimSpace(amplitudes[i]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid seasonality amplitude for '%s': %v", name, err)
+			}
+			if val < 0 || val > 1 {
+				return nil, fmt.Errorf("seasonality amplitude for '%s' must be between 0 and 1: got %v", name, val)
+			}
+			*dst = val
+		}
+		ret[name] = s
+	}
+
+	return ret, nil
+}
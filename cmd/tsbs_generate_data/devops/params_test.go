@@ -0,0 +1,251 @@
+package devops
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/timescale/tsbs/cmd/tsbs_generate_data/common"
+	"github.com/timescale/tsbs/cmd/tsbs_generate_data/serialize"
+)
+
+func TestParseDistributionParams(t *testing.T) {
+	cases := []struct {
+		desc        string
+		spec        string
+		want        func(p *DistributionParams)
+		shouldError bool
+	}{
+		{
+			desc: "empty spec gives defaults",
+			spec: "",
+			want: func(p *DistributionParams) {},
+		},
+		{
+			desc: "single override",
+			spec: "cpu.step_stddev=2.5",
+			want: func(p *DistributionParams) { p.CPUStepStdDev = 2.5 },
+		},
+		{
+			desc: "multiple overrides with spaces",
+			spec: "disk.step_mean=-1000, disk.step_stddev=10,mem.step_stddev=0.1, mem.min=0.2,mem.max=0.9",
+			want: func(p *DistributionParams) {
+				p.DiskStepMean = -1000
+				p.DiskStepStdDev = 10
+				p.MemStepStdDev = 0.1
+				p.MemMin = 0.2
+				p.MemMax = 0.9
+			},
+		},
+		{
+			desc:        "missing value",
+			spec:        "cpu.step_stddev",
+			shouldError: true,
+		},
+		{
+			desc:        "non-numeric value",
+			spec:        "cpu.step_stddev=high",
+			shouldError: true,
+		},
+		{
+			desc:        "unknown key",
+			spec:        "gpu.step_stddev=1",
+			shouldError: true,
+		},
+		{
+			desc:        "negative std dev",
+			spec:        "cpu.step_stddev=-1",
+			shouldError: true,
+		},
+		{
+			desc:        "inverted memory bounds",
+			spec:        "mem.min=0.8,mem.max=0.5",
+			shouldError: true,
+		},
+	}
+
+	for _, c := range cases {
+		got, err := ParseDistributionParams(c.spec)
+		if c.shouldError {
+			if err == nil {
+				t.Errorf("%s: unexpected lack of error", c.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.desc, err)
+			continue
+		}
+		want := DefaultDistributionParams()
+		c.want(want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: incorrect params: got %+v want %+v", c.desc, got, want)
+		}
+	}
+}
+
+// usageUserStepVariance returns the variance of the change in usage_user
+// between consecutive points of a single host.
+func usageUserStepVariance(params *DistributionParams) float64 {
+	rand.Seed(123)
+	start := time.Unix(0, 0)
+	conf := &CPUOnlySimulatorConfig{
+		Start:              start,
+		End:                start.Add(5000 * time.Second),
+		InitHostCount:      1,
+		HostCount:          1,
+		HostConstructor:    NewHostCPUOnly,
+		DistributionParams: params,
+	}
+	sim := conf.NewSimulator(time.Second, 0)
+	p := serialize.NewPoint()
+	var prev, sum, sumSq float64
+	n := 0
+	for i := 0; !sim.Finished(); i++ {
+		sim.Next(p)
+		curr := float64(p.GetFieldValue([]byte("usage_user")).(int64))
+		if i > 0 {
+			diff := curr - prev
+			sum += diff
+			sumSq += diff * diff
+			n++
+		}
+		prev = curr
+		p.Reset()
+	}
+	mean := sum / float64(n)
+	return sumSq/float64(n) - mean*mean
+}
+
+func TestCPUStepStdDevIncreasesVariance(t *testing.T) {
+	low := usageUserStepVariance(nil)
+	params := DefaultDistributionParams()
+	params.CPUStepStdDev = 5
+	high := usageUserStepVariance(params)
+	if high < 10*low {
+		t.Errorf("variance did not increase enough with larger step std dev: got %v (default %v)", high, low)
+	}
+}
+
+func TestNewMemMeasurementWithParamsBounds(t *testing.T) {
+	params := DefaultDistributionParams()
+	params.MemMin = 0.25
+	params.MemMax = 0.5
+	m := newMemMeasurementWithParams(time.Now(), params)
+	for i := 0; i < 1000; i++ {
+		m.Tick(time.Second)
+		for _, d := range m.distributions {
+			if got := d.Get() / float64(m.bytesTotal); got < params.MemMin || got > params.MemMax {
+				t.Fatalf("memory value out of bounds: got fraction %v", got)
+			}
+		}
+	}
+}
+
+func TestParseSeasonality(t *testing.T) {
+	cases := []struct {
+		desc        string
+		spec        string
+		want        map[string]common.Seasonality
+		shouldError bool
+	}{
+		{
+			desc: "empty spec gives none",
+			spec: "",
+			want: nil,
+		},
+		{
+			desc: "daily only",
+			spec: "cpu=0.3",
+			want: map[string]common.Seasonality{"cpu": {Daily: 0.3}},
+		},
+		{
+			desc: "daily and weekend for multiple measurements",
+			spec: "cpu=0.3:0.1, mem = 0.2:0.05",
+			want: map[string]common.Seasonality{
+				"cpu": {Daily: 0.3, Weekend: 0.1},
+				"mem": {Daily: 0.2, Weekend: 0.05},
+			},
+		},
+		{
+			desc:        "missing amplitude",
+			spec:        "cpu",
+			shouldError: true,
+		},
+		{
+			desc:        "non-numeric amplitude",
+			spec:        "cpu=high",
+			shouldError: true,
+		},
+		{
+			desc:        "amplitude out of range",
+			spec:        "cpu=0.3:1.5",
+			shouldError: true,
+		},
+		{
+			desc:        "measurement without bounded fields",
+			spec:        "net=0.3",
+			shouldError: true,
+		},
+	}
+
+	for _, c := range cases {
+		got, err := ParseSeasonality(c.spec)
+		if c.shouldError {
+			if err == nil {
+				t.Errorf("%s: unexpected lack of error", c.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.desc, err)
+		} else if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: incorrect seasonality: got %+v want %+v", c.desc, got, c.want)
+		}
+	}
+}
+
+func TestSeasonalityRaisesMiddayUsage(t *testing.T) {
+	rand.Seed(123)
+	const amplitude = 0.4
+	params := DefaultDistributionParams()
+	params.CPUStepStdDev = 0.1
+	params.Seasonality = map[string]common.Seasonality{"cpu": {Daily: amplitude}}
+	start := time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC)
+	conf := &CPUOnlySimulatorConfig{
+		Start:              start,
+		End:                start.Add(14 * time.Hour),
+		InitHostCount:      100,
+		HostCount:          100,
+		HostConstructor:    NewHostCPUOnly,
+		DistributionParams: params,
+	}
+	sim := conf.NewSimulator(time.Minute, 0)
+	p := serialize.NewPoint()
+	var night, midday float64
+	var nightCnt, middayCnt int
+	for !sim.Finished() {
+		sim.Next(p)
+		v := float64(p.GetFieldValue([]byte("usage_user")).(int64))
+		switch p.Timestamp().Hour() {
+		case 3:
+			night += v
+			nightCnt++
+		case 12:
+			midday += v
+			middayCnt++
+		}
+		p.Reset()
+	}
+	if nightCnt == 0 || middayCnt == 0 {
+		t.Fatalf("no points in compared hours: got %d and %d", nightCnt, middayCnt)
+	}
+
+	// usage_user ranges over [0, 100]
+	want := amplitude * 100
+	diff := midday/float64(middayCnt) - night/float64(nightCnt)
+	if diff < 0.6*want || diff > 1.2*want {
+		t.Errorf("incorrect difference between midday and night usage: got %v want roughly %v", diff, want)
+	}
+}
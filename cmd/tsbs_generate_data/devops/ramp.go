@@ -0,0 +1,96 @@
+package devops
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Names of the scale ramps accepted by ParseScaleRamp
+const (
+	ScaleRampLinear      = "linear"
+	ScaleRampStep        = "step"
+	ScaleRampExponential = "exponential"
+)
+
+// ScaleRamp governs how the number of reporting hosts grows from the initial
+// host count to the total one over the course of a simulation.
+type ScaleRamp interface {
+	// Hosts returns the number of hosts reporting in the given epoch, out of
+	// epochs of length interval, growing from initial hosts in epoch 0 to
+	// at most total hosts.
+	Hosts(epoch, epochs uint64, interval time.Duration, initial, total uint64) uint64
+}
+
+// LinearRamp adds hosts at a constant rate so that all of them report by the
+// last epoch.
+type LinearRamp struct{}
+
+// Hosts returns the number of hosts reporting in epoch.
+func (r LinearRamp) Hosts(epoch, epochs uint64, interval time.Duration, initial, total uint64) uint64 {
+	missingScale := float64(total - initial)
+	return initial + uint64(missingScale*float64(epoch)/float64(epochs-1))
+}
+
+// StepRamp adds Step hosts at once every Every of simulated time, until all of
+// them report.
+type StepRamp struct {
+	Step  uint64
+	Every time.Duration
+}
+
+// Hosts returns the number of hosts reporting in epoch.
+func (r StepRamp) Hosts(epoch, epochs uint64, interval time.Duration, initial, total uint64) uint64 {
+	steps := uint64(time.Duration(epoch) * interval / r.Every)
+	hosts := initial + steps*r.Step
+	if hosts > total {
+		return total
+	}
+	return hosts
+}
+
+// ExponentialRamp grows the number of hosts by the same factor every epoch, so
+// few are added early on and most towards the end, reaching all of them by
+// the last epoch.
+type ExponentialRamp struct{}
+
+// Hosts returns the number of hosts reporting in epoch.
+func (r ExponentialRamp) Hosts(epoch, epochs uint64, interval time.Duration, initial, total uint64) uint64 {
+	if epoch+1 >= epochs {
+		return total
+	}
+	// Offset by one so growth can start from no hosts
+	frac := float64(epoch) / float64(epochs-1)
+	ratio := float64(total+1) / float64(initial+1)
+	return uint64(float64(initial+1)*math.Pow(ratio, frac)) - 1
+}
+
+// ParseScaleRamp parses a ramp given as linear, exponential or step:N@D, the
+// latter adding N hosts every duration D (e.g., step:1000@6h). An empty spec
+// means linear.
+func ParseScaleRamp(spec string) (ScaleRamp, error) {
+	switch {
+	case len(spec) == 0 || spec == ScaleRampLinear:
+		return LinearRamp{}, nil
+	case spec == ScaleRampExponential:
+		return ExponentialRamp{}, nil
+	case strings.HasPrefix(spec, ScaleRampStep+":"):
+		args := strings.SplitN(strings.TrimPrefix(spec, ScaleRampStep+":"), "@", 2)
+		if len(args) != 2 {
+			return nil, fmt.Errorf("invalid scale ramp '%s': step needs the form step:N@D", spec)
+		}
+		step, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil || step == 0 {
+			return nil, fmt.Errorf("invalid scale ramp '%s': step size must be a positive integer", spec)
+		}
+		every, err := time.ParseDuration(args[1])
+		if err != nil || every <= 0 {
+			return nil, fmt.Errorf("invalid scale ramp '%s': step interval must be a positive duration", spec)
+		}
+		return StepRamp{Step: step, Every: every}, nil
+	default:
+		return nil, fmt.Errorf("invalid scale ramp '%s': must be %s, %s or %s:N@D", spec, ScaleRampLinear, ScaleRampExponential, ScaleRampStep)
+	}
+}
@@ -0,0 +1,126 @@
+package devops
+
+import (
+	"testing"
+	"time"
+
+	"github.com/timescale/tsbs/cmd/tsbs_generate_data/serialize"
+)
+
+func TestParseScaleRamp(t *testing.T) {
+	cases := []struct {
+		spec    string
+		want    ScaleRamp
+		wantErr bool
+	}{
+		{spec: "", want: LinearRamp{}},
+		{spec: "linear", want: LinearRamp{}},
+		{spec: "exponential", want: ExponentialRamp{}},
+		{spec: "step:1000@6h", want: StepRamp{Step: 1000, Every: 6 * time.Hour}},
+		{spec: "step:1000", wantErr: true},
+		{spec: "step:0@6h", wantErr: true},
+		{spec: "step:-1@6h", wantErr: true},
+		{spec: "step:1000@0s", wantErr: true},
+		{spec: "step:1000@6", wantErr: true},
+		{spec: "quadratic", wantErr: true},
+	}
+	for _, c := range cases {
+		got, err := ParseScaleRamp(c.spec)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("'%s': unexpected lack of error", c.spec)
+			}
+		} else if err != nil {
+			t.Errorf("'%s': unexpected error: %v", c.spec, err)
+		} else if got != c.want {
+			t.Errorf("'%s': incorrect ramp: got %v want %v", c.spec, got, c.want)
+		}
+	}
+}
+
+func TestScaleRampHosts(t *testing.T) {
+	totalHosts := uint64(100)
+	cases := []struct {
+		desc           string
+		ramp           ScaleRamp
+		initHosts      uint64
+		epochs         uint64
+		wantEpochHosts []uint64
+	}{
+		{
+			desc:           "exponential from 0",
+			ramp:           ExponentialRamp{},
+			initHosts:      0,
+			epochs:         5,
+			wantEpochHosts: []uint64{0, 2, 9, 30, 100},
+		},
+		{
+			desc:           "exponential no change",
+			ramp:           ExponentialRamp{},
+			initHosts:      totalHosts,
+			epochs:         3,
+			wantEpochHosts: []uint64{100, 100, 100},
+		},
+		{
+			desc:           "step every other epoch",
+			ramp:           StepRamp{Step: 30, Every: 2 * time.Minute},
+			initHosts:      10,
+			epochs:         9,
+			wantEpochHosts: []uint64{10, 10, 40, 40, 70, 70, 100, 100, 100},
+		},
+		{
+			desc:           "step too slow to reach all hosts",
+			ramp:           StepRamp{Step: 10, Every: time.Hour},
+			initHosts:      10,
+			epochs:         4,
+			wantEpochHosts: []uint64{10, 10, 10, 10},
+		},
+	}
+
+	for _, c := range cases {
+		s := &commonDevopsSimulator{}
+		for i := uint64(0); i < totalHosts; i++ {
+			s.hosts = append(s.hosts, Host{})
+		}
+		s.initHosts = c.initHosts
+		s.epochHosts = c.initHosts
+		s.epochs = c.epochs
+		s.interval = time.Minute
+		s.scaleRamp = c.ramp
+		for i := 0; i < int(c.epochs); i++ {
+			want := c.wantEpochHosts[i]
+			if got := s.epochHosts; got != want {
+				t.Errorf("%s: incorrect number of hosts in epoch %d: got %d want %d", c.desc, i, got, want)
+			}
+			s.adjustNumHostsForEpoch()
+		}
+	}
+}
+
+func TestCPUOnlySimulatorLinearScaleRamp(t *testing.T) {
+	const initHosts, totalHosts = 20, 100
+	start := time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(101 * time.Second)
+	conf := &CPUOnlySimulatorConfig{
+		Start:           start,
+		End:             end,
+		InitHostCount:   initHosts,
+		HostCount:       totalHosts,
+		HostConstructor: NewHostCPUOnly,
+		ScaleRamp:       LinearRamp{},
+	}
+	s := conf.NewSimulator(time.Second, 0)
+	mid := start.Add(end.Sub(start) / 2)
+	seen := map[string]bool{}
+	p := serialize.NewPoint()
+	for !s.Finished() {
+		if s.Next(p) && p.Timestamp().Before(mid) {
+			seen[string(p.GetTagValue(MachineTagKeys[0]))] = true
+		}
+		p.Reset()
+	}
+	want := initHosts + (totalHosts-initHosts)/2
+	if got := len(seen); got < want-2 || got > want+2 {
+		t.Errorf("incorrect number of hosts in first half: got %d want about %d", got, want)
+	}
+}
@@ -19,30 +19,61 @@ import (
 	"log"
 	"os"
 	"os/signal"
-	"runtime/pprof"
 
+	configfile "github.com/timescale/tsbs/internal/config"
 	"github.com/timescale/tsbs/internal/inputs"
 )
 
 var (
-	profileFile string
-	dg          = &inputs.DataGenerator{}
-	config      = &inputs.DataGeneratorConfig{}
+	profileFile    string
+	cpuProfileFile string
+	configFile     string
+	printConfig    bool
+	dg             = &inputs.DataGenerator{}
+	config         = &inputs.DataGeneratorConfig{}
 )
 
 // Parse args:
 func init() {
 	config.AddToFlagSet(flag.CommandLine)
 
-	flag.StringVar(&profileFile, "profile-file", "", "File to which to write go profiling data")
+	flag.StringVar(&profileFile, "profile-file", "", "File to which to write go memory profiling data")
+	flag.StringVar(&cpuProfileFile, "cpu-profile-file", "", "File to which to write go CPU profiling data")
 	flag.Uint64Var(&config.Limit, "max-data-points", 0, "Limit the number of data points to generate, 0 = no limit")
 
+	flag.StringVar(&configFile, "config", "", "YAML file of flag names and values to use; flags given on the command line take precedence")
+	flag.BoolVar(&printConfig, "print-config", false, "Print the fully-resolved configuration as YAML and exit without generating")
+
 	flag.Parse()
 }
 
 func main() {
-	if len(profileFile) > 0 {
-		defer startMemoryProfile(profileFile)()
+	if len(configFile) > 0 {
+		if err := configfile.LoadFile(flag.CommandLine, configFile); err != nil {
+			fmt.Printf("error: %v\n", err)
+			return
+		}
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "timestamp-end" {
+			config.TimeEndGiven = true
+		}
+	})
+
+	if printConfig {
+		if err := config.Validate(); err != nil {
+			fmt.Printf("error: %v\n", err)
+			return
+		}
+		if err := configfile.Write(os.Stdout, flag.CommandLine, "config", "print-config"); err != nil {
+			fmt.Printf("error: %v\n", err)
+		}
+		return
+	}
+
+	if len(profileFile) > 0 || len(cpuProfileFile) > 0 {
+		defer startProfiling(cpuProfileFile, profileFile)()
 	}
 
 	err := dg.Generate(config)
@@ -51,19 +82,19 @@ func main() {
 	}
 }
 
-// startMemoryProfile sets up memory profiling to be written to profileFile. It
-// returns a function to cleanup/write that should be deferred by the caller
-func startMemoryProfile(profileFile string) func() {
-	f, err := os.Create(profileFile)
+// startProfiling sets up CPU and/or memory profiling to be written to the
+// given files. It returns a function to cleanup/write that should be deferred
+// by the caller
+func startProfiling(cpuProfileFile, memProfileFile string) func() {
+	p, err := startProfiler(cpuProfileFile, memProfileFile)
 	if err != nil {
-		log.Fatal("could not create memory profile: ", err)
+		log.Fatal(err)
 	}
 
 	stop := func() {
-		if err := pprof.WriteHeapProfile(f); err != nil {
-			log.Fatal("could not write memory profile: ", err)
+		if err := p.Stop(); err != nil {
+			log.Fatal(err)
 		}
-		f.Close()
 	}
 
 	// Catches ctrl+c signals
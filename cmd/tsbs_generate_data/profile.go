@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime/pprof"
+	"sync"
+)
+
+// profiler writes a CPU profile and/or a heap profile for a run, either of
+// which is skipped if its filename is empty.
+type profiler struct {
+	cpuFile *os.File
+	memFile *os.File
+
+	stopOnce sync.Once
+	stopErr  error
+}
+
+// startProfiler creates the profile files and starts CPU profiling. The caller
+// must call Stop to write out the profiles.
+func startProfiler(cpuFilename, memFilename string) (*profiler, error) {
+	p := &profiler{}
+	if len(memFilename) > 0 {
+		f, err := os.Create(memFilename)
+		if err != nil {
+			return nil, fmt.Errorf("could not create memory profile: %v", err)
+		}
+		p.memFile = f
+	}
+	if len(cpuFilename) > 0 {
+		f, err := os.Create(cpuFilename)
+		if err != nil {
+			p.closeFiles()
+			return nil, fmt.Errorf("could not create CPU profile: %v", err)
+		}
+		p.cpuFile = f
+		if err := pprof.StartCPUProfile(f); err != nil {
+			p.closeFiles()
+			return nil, fmt.Errorf("could not start CPU profile: %v", err)
+		}
+	}
+	return p, nil
+}
+
+// Stop ends CPU profiling and then writes the heap profile, so that writing
+// the latter does not show up in the former. It is safe to call more than
+// once, e.g., from both the interrupt handler and the normal exit path.
+func (p *profiler) Stop() error {
+	p.stopOnce.Do(func() {
+		if p.cpuFile != nil {
+			pprof.StopCPUProfile()
+		}
+		if p.memFile != nil {
+			if err := pprof.WriteHeapProfile(p.memFile); err != nil {
+				p.stopErr = fmt.Errorf("could not write memory profile: %v", err)
+			}
+		}
+		if err := p.closeFiles(); err != nil && p.stopErr == nil {
+			p.stopErr = err
+		}
+	})
+	return p.stopErr
+}
+
+func (p *profiler) closeFiles() error {
+	var err error
+	for _, f := range []*os.File{p.cpuFile, p.memFile} {
+		if f == nil {
+			continue
+		}
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
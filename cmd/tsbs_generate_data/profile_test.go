@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"testing"
+)
+
+func TestProfiler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profile")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cpuFilename := filepath.Join(dir, "cpu.prof")
+	memFilename := filepath.Join(dir, "mem.prof")
+	p, err := startProfiler(cpuFilename, memFilename)
+	if err != nil {
+		t.Fatalf("unexpected error starting profiler: %v", err)
+	}
+	for _, f := range []string{cpuFilename, memFilename} {
+		if _, err := os.Stat(f); err != nil {
+			t.Errorf("profile file %s not created: %v", f, err)
+		}
+	}
+	// CPU profiling is global, so a second one cannot start until stopped
+	if err := pprof.StartCPUProfile(&bytes.Buffer{}); err == nil {
+		pprof.StopCPUProfile()
+		t.Errorf("CPU profiling not started")
+	}
+
+	if err := p.Stop(); err != nil {
+		t.Fatalf("unexpected error stopping profiler: %v", err)
+	}
+	for _, f := range []string{cpuFilename, memFilename} {
+		if fi, err := os.Stat(f); err != nil {
+			t.Errorf("could not stat profile file %s: %v", f, err)
+		} else if fi.Size() == 0 {
+			t.Errorf("profile file %s is empty", f)
+		}
+	}
+	if err := pprof.StartCPUProfile(&bytes.Buffer{}); err != nil {
+		t.Errorf("CPU profiling not stopped: %v", err)
+	} else {
+		pprof.StopCPUProfile()
+	}
+
+	// Stopping again, as the interrupt handler might, is a no-op
+	if err := p.Stop(); err != nil {
+		t.Errorf("unexpected error stopping profiler twice: %v", err)
+	}
+}
+
+func TestProfilerSingleProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profile")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	memFilename := filepath.Join(dir, "mem.prof")
+	p, err := startProfiler("", memFilename)
+	if err != nil {
+		t.Fatalf("unexpected error starting profiler: %v", err)
+	}
+	if err := pprof.StartCPUProfile(&bytes.Buffer{}); err != nil {
+		t.Errorf("CPU profiling unexpectedly started: %v", err)
+	} else {
+		pprof.StopCPUProfile()
+	}
+	if err := p.Stop(); err != nil {
+		t.Fatalf("unexpected error stopping profiler: %v", err)
+	}
+	if fi, err := os.Stat(memFilename); err != nil || fi.Size() == 0 {
+		t.Errorf("memory profile not written: %v", err)
+	}
+}
+
+func TestProfilerBadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profile")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	memFilename := filepath.Join(dir, "mem.prof")
+	_, err = startProfiler(filepath.Join(dir, "missing", "cpu.prof"), memFilename)
+	if err == nil {
+		t.Fatalf("unexpected lack of error for bad CPU profile path")
+	}
+	// a failed start should not leave CPU profiling running
+	if err := pprof.StartCPUProfile(&bytes.Buffer{}); err != nil {
+		t.Errorf("CPU profiling left running after failed start: %v", err)
+	} else {
+		pprof.StopCPUProfile()
+	}
+
+	if _, err := startProfiler("", filepath.Join(dir, "missing", "mem.prof")); err == nil {
+		t.Errorf("unexpected lack of error for bad memory profile path")
+	}
+}
@@ -1,12 +1,178 @@
 package serialize
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// Parts of the series IDs of the Cassandra format, which CassandraLayout
+// can put in any order
+const (
+	CassandraKeySeries = "series"
+	CassandraKeyField  = "field"
+	CassandraKeyBucket = "bucket"
+)
+
+const (
+	// cassandraLayoutPrefix starts the line describing a layout other than
+	// the default one, ahead of the data
+	cassandraLayoutPrefix = "#layout"
+
+	cassandraDayBucket       = 24 * time.Hour
+	cassandraDayLayout       = "2006-01-02"
+	cassandraSubDayLayout    = "2006-01-02T15:04"
+	cassandraDefaultKeyOrder = CassandraKeySeries + "," + CassandraKeyField + "," + CassandraKeyBucket
+)
+
+// CassandraLayout describes how the series IDs that partition Cassandra
+// tables are composed: the span of time each one covers and the order of
+// their parts. The zero value is the default, a day per series ID, with
+// the parts ordered series, field and bucket.
+type CassandraLayout struct {
+	// Bucket is the span of time covered by a series ID, a day if 0
+	Bucket time.Duration
+	// KeyOrder is the order of the parts of series IDs, the default if
+	// empty
+	KeyOrder []string
+}
+
+// ParseCassandraLayout returns the layout with the given bucket and key
+// order, given as a comma-separated list of series, field and bucket. Buckets
+// must evenly divide a day, so that they start at midnight. Empty values
+// mean the default.
+func ParseCassandraLayout(bucket, keyOrder string) (*CassandraLayout, error) {
+	l := &CassandraLayout{}
+	if len(bucket) > 0 {
+		d, err := time.ParseDuration(bucket)
+		if err != nil || d < time.Minute || cassandraDayBucket%d != 0 {
+			return nil, fmt.Errorf("invalid cassandra bucket '%s': must be at least a minute and evenly divide a day, e.g., 1h or 12h", bucket)
+		}
+		if d != cassandraDayBucket {
+			l.Bucket = d
+		}
+	}
+	if len(keyOrder) > 0 && keyOrder != cassandraDefaultKeyOrder {
+		parts := strings.Split(keyOrder, ",")
+		seen := map[string]bool{}
+		for _, part := range parts {
+			switch part {
+			case CassandraKeySeries, CassandraKeyField, CassandraKeyBucket:
+			default:
+				return nil, fmt.Errorf("invalid cassandra key order '%s': unknown part '%s'", keyOrder, part)
+			}
+			seen[part] = true
+		}
+		if len(parts) != 3 || len(seen) != 3 {
+			return nil, fmt.Errorf("invalid cassandra key order '%s': must have each of %s once", keyOrder, cassandraDefaultKeyOrder)
+		}
+		l.KeyOrder = parts
+	}
+	return l, nil
+}
+
+// ParseCassandraLayoutHeader parses the line written by Header, reporting
+// false if line is not such a header.
+func ParseCassandraLayoutHeader(line string) (*CassandraLayout, bool, error) {
+	args := strings.Fields(line)
+	if len(args) == 0 || args[0] != cassandraLayoutPrefix {
+		return nil, false, nil
+	}
+	var bucket, keyOrder string
+	for _, arg := range args[1:] {
+		kv := strings.SplitN(arg, "=", 2)
+		switch {
+		case len(kv) == 2 && kv[0] == CassandraKeyBucket:
+			bucket = kv[1]
+		case len(kv) == 2 && kv[0] == "key":
+			keyOrder = kv[1]
+		default:
+			return nil, true, fmt.Errorf("invalid cassandra layout header '%s'", line)
+		}
+	}
+	l, err := ParseCassandraLayout(bucket, keyOrder)
+	return l, true, err
+}
+
+// IsDefault reports whether l is the default layout, which needs no header.
+func (l *CassandraLayout) IsDefault() bool {
+	return l.Bucket == 0 && len(l.KeyOrder) == 0
+}
+
+// Header returns the line describing l to write ahead of the data, or nothing
+// for the default layout, so that data in it stays the same as without one.
+func (l *CassandraLayout) Header() []byte {
+	if l.IsDefault() {
+		return nil
+	}
+	return []byte(fmt.Sprintf("%s %s=%s key=%s\n", cassandraLayoutPrefix, CassandraKeyBucket, l.bucket(), l.keyOrder()))
+}
+
+func (l *CassandraLayout) bucket() time.Duration {
+	if l.Bucket == 0 {
+		return cassandraDayBucket
+	}
+	return l.Bucket
+}
+
+func (l *CassandraLayout) keyOrder() string {
+	if len(l.KeyOrder) == 0 {
+		return cassandraDefaultKeyOrder
+	}
+	return strings.Join(l.KeyOrder, ",")
+}
+
+// AppendBucket appends the bucket t is in to buf: the day, in YYYY-MM-DD
+// form, for day buckets, or the start of the bucket, in YYYY-MM-DDTHH:MM
+// form, for shorter ones. A nil layout is the default one.
+func (l *CassandraLayout) AppendBucket(buf []byte, t time.Time) []byte {
+	t = t.UTC()
+	if l == nil || l.Bucket == 0 || l.Bucket == cassandraDayBucket {
+		return t.AppendFormat(buf, cassandraDayLayout)
+	}
+	return t.Truncate(l.Bucket).AppendFormat(buf, cassandraSubDayLayout)
+}
+
+// SeriesID returns the ID of the series of the given measurement and tags,
+// field and bucket, joined by '#' in the key order of l.
+func (l *CassandraLayout) SeriesID(series, field, bucket string) string {
+	if len(l.KeyOrder) == 0 {
+		return series + "#" + field + "#" + bucket
+	}
+	parts := make([]string, len(l.KeyOrder))
+	for i, part := range l.KeyOrder {
+		switch part {
+		case CassandraKeySeries:
+			parts[i] = series
+		case CassandraKeyField:
+			parts[i] = field
+		case CassandraKeyBucket:
+			parts[i] = bucket
+		}
+	}
+	return strings.Join(parts, "#")
+}
+
 // CassandraSerializer writes a Point in a serialized form for Cassandra
-type CassandraSerializer struct{}
+type CassandraSerializer struct {
+	// TimestampUnit is the unit timestamps are written in, nanoseconds if 0
+	TimestampUnit time.Duration
+	// FloatPrecision is the number of decimals floats are written with, as
+	// many as needed to be exact if 0
+	FloatPrecision int
+	// Layout sets the buckets of time written, the default if nil. Its
+	// Header must be written ahead of the data.
+	Layout *CassandraLayout
+
+	// buf and seriesIDPrefix are reused by every call to Serialize to avoid
+	// allocating per Point
+	buf            []byte
+	seriesIDPrefix []byte
+}
 
 // Serialize writes Point data to the given writer, conforming to the
 // Cassandra format.
@@ -17,7 +183,7 @@ type CassandraSerializer struct{}
 // Which the loader will decode into a statement that looks like this:
 // INSERT INTO series_double(series_id,timestamp_ns,value) VALUES('cpu,hostname=host_0,region=eu-west-1,datacenter=eu-west-1b,rack=67,os=Ubuntu16.10,arch=x86,team=NYC,service=7,service_version=0,service_environment=production#usage_guest_nice#2016-01-01', 1451606400000000000, 38.2431182911542820)
 func (s *CassandraSerializer) Serialize(p *Point, w io.Writer) (err error) {
-	seriesIDPrefix := make([]byte, 0, 256)
+	seriesIDPrefix := s.seriesIDPrefix[:0]
 	seriesIDPrefix = append(seriesIDPrefix, p.measurementName...)
 	for i := 0; i < len(p.tagKeys); i++ {
 		seriesIDPrefix = append(seriesIDPrefix, ',')
@@ -26,34 +192,35 @@ func (s *CassandraSerializer) Serialize(p *Point, w io.Writer) (err error) {
 		seriesIDPrefix = append(seriesIDPrefix, p.tagValues[i]...)
 	}
 
-	timestampNanos := p.timestamp.UTC().UnixNano()
-	timestampBucket := p.timestamp.UTC().Format("2006-01-02")
+	timestamp := timestampIn(p.timestamp, s.TimestampUnit)
 
+	// Every field is a line of its own, all written at once
+	buf := s.buf[:0]
 	for fieldID := 0; fieldID < len(p.fieldKeys); fieldID++ {
 		value := p.fieldValues[fieldID]
-		tableName := fmt.Sprintf("series_%s", typeNameForCassandra(value))
 
-		buf := make([]byte, 0, 256)
-		buf = append(buf, []byte(tableName)...)
-		buf = append(buf, []byte(",")...)
+		buf = append(buf, "series_"...)
+		buf = append(buf, typeNameForCassandra(value)...)
+		buf = append(buf, ',')
 		buf = append(buf, seriesIDPrefix...)
-		buf = append(buf, []byte(",")...)
+		buf = append(buf, ',')
 		buf = append(buf, p.fieldKeys[fieldID]...)
-		buf = append(buf, []byte(",")...)
-		buf = append(buf, []byte(timestampBucket)...)
-		buf = append(buf, []byte(",")...)
-		buf = append(buf, []byte(fmt.Sprintf("%d,", timestampNanos))...)
-		buf = fastFormatAppend(value, buf)
-
-		buf = append(buf, []byte("\n")...)
-
-		_, err := w.Write(buf)
+		buf = append(buf, ',')
+		buf = s.Layout.AppendBucket(buf, *p.timestamp)
+		buf = append(buf, ',')
+		buf = strconv.AppendInt(buf, timestamp, 10)
+		buf = append(buf, ',')
+		buf, err = appendField(buf, value, s.FloatPrecision)
 		if err != nil {
 			return err
 		}
+
+		buf = append(buf, '\n')
 	}
+	s.buf, s.seriesIDPrefix = buf, seriesIDPrefix
 
-	return nil
+	_, err = w.Write(buf)
+	return err
 }
 
 func typeNameForCassandra(v interface{}) string {
@@ -72,3 +239,132 @@ func typeNameForCassandra(v interface{}) string {
 		panic(fmt.Sprintf("unknown field type for %#v", v))
 	}
 }
+
+// CassandraDeserializer reads Points written by CassandraSerializer. As every
+// field is a line of its own, consecutive lines of the same series and
+// timestamp are read as one Point, so a Point that repeats the one before it
+// must not be written twice.
+type CassandraDeserializer struct {
+	// TimestampUnit is the unit timestamps were written in, nanoseconds if 0
+	TimestampUnit time.Duration
+	// Layout is read from the header, if any
+	Layout *CassandraLayout
+
+	// next is the line read ahead of the Point it starts
+	next    []byte
+	started bool
+}
+
+// cassandraLine is a line of the Cassandra format, split into its parts
+type cassandraLine struct {
+	typeName    string
+	series      []byte
+	measurement []byte
+	tags        [][]byte
+	field       []byte
+	timestamp   int64
+	value       []byte
+}
+
+func parseCassandraLine(line []byte) (*cassandraLine, error) {
+	parts := bytes.Split(line, []byte(","))
+	if len(parts) < 6 || !bytes.HasPrefix(parts[0], []byte("series_")) {
+		return nil, fmt.Errorf("invalid cassandra line '%s'", line)
+	}
+	n := len(parts)
+	l := &cassandraLine{
+		typeName:    string(parts[0][len("series_"):]),
+		series:      bytes.Join(parts[1:n-4], []byte(",")),
+		measurement: parts[1],
+		tags:        parts[2 : n-4],
+		field:       parts[n-4],
+		value:       parts[n-1],
+	}
+	var err error
+	l.timestamp, err = strconv.ParseInt(string(parts[n-2]), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cassandra line '%s': %v", line, err)
+	}
+	return l, nil
+}
+
+// parseCassandraValue parses the value of a series of the given type name, as
+// written by typeNameForCassandra
+func parseCassandraValue(typeName string, b []byte) (interface{}, error) {
+	switch typeName {
+	case "bigint":
+		return strconv.ParseInt(string(b), 10, 64)
+	case "double":
+		return strconv.ParseFloat(string(b), 64)
+	case "float":
+		f, err := strconv.ParseFloat(string(b), 32)
+		return float32(f), err
+	case "boolean":
+		return strconv.ParseBool(string(b))
+	case "blob":
+		return b, nil
+	}
+	return nil, fmt.Errorf("unknown cassandra type '%s'", typeName)
+}
+
+// Deserialize reads the lines of the next Point of r into p, skipping the
+// layout header ahead of the first, if any.
+func (d *CassandraDeserializer) Deserialize(r *bufio.Reader, p *Point) error {
+	line := d.next
+	d.next = nil
+	if line == nil {
+		var err error
+		if line, err = readLine(r); err != nil {
+			return err
+		}
+	}
+	if !d.started {
+		d.started = true
+		layout, ok, err := ParseCassandraLayoutHeader(string(line))
+		if err != nil {
+			return err
+		}
+		if ok {
+			d.Layout = layout
+			if line, err = readLine(r); err != nil {
+				return err
+			}
+		}
+	}
+
+	first, err := parseCassandraLine(line)
+	if err != nil {
+		return err
+	}
+	p.Reset()
+	p.SetMeasurementName(first.measurement)
+	for _, tag := range first.tags {
+		kv := bytes.SplitN(tag, []byte("="), 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid cassandra line '%s': tag '%s' has no value", line, tag)
+		}
+		p.AppendTag(kv[0], kv[1])
+	}
+	t := timeIn(first.timestamp, d.TimestampUnit)
+	p.SetTimestamp(&t)
+
+	for l := first; ; {
+		v, err := parseCassandraValue(l.typeName, l.value)
+		if err != nil {
+			return fmt.Errorf("invalid cassandra line '%s': %v", line, err)
+		}
+		p.AppendField(l.field, v)
+
+		line, err = readLine(r)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		// A line that does not parse is left to fail the next call
+		if l, err = parseCassandraLine(line); err != nil || !bytes.Equal(l.series, first.series) || l.timestamp != first.timestamp || p.GetFieldValue(l.field) != nil {
+			d.next = line
+			return nil
+		}
+	}
+}
@@ -1,7 +1,13 @@
 package serialize
 
 import (
+	"bufio"
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestCassandraSerializerSerialize(t *testing.T) {
@@ -21,10 +27,209 @@ func TestCassandraSerializerSerialize(t *testing.T) {
 			inputPoint: testPointNoTags,
 			output:     "series_double,cpu,usage_guest_nice,2016-01-01,1451606400000000000,38.24311829\n",
 		},
+		{
+			desc:       "a Point with a sub-second timestamp",
+			inputPoint: testPointSubSecond,
+			output:     "series_double,cpu,hostname=host_0,region=eu-west-1,datacenter=eu-west-1b,usage_guest_nice,2016-01-01,1451606400123456789,38.24311829\n",
+		},
 	}
 	testSerializer(t, cases, &CassandraSerializer{})
 }
 
+func TestCassandraSerializerSerializeLayout(t *testing.T) {
+	layout, err := ParseCassandraLayout("1h", "bucket,series,field")
+	if err != nil {
+		t.Fatalf("unexpected error parsing layout: %v", err)
+	}
+	cases := []serializeCase{
+		{
+			desc:       "a regular Point",
+			inputPoint: testPointDefault,
+			output:     "series_double,cpu,hostname=host_0,region=eu-west-1,datacenter=eu-west-1b,usage_guest_nice,2016-01-01T00:00,1451606400000000000,38.24311829\n",
+		},
+		{
+			desc:       "a Point with a sub-second timestamp",
+			inputPoint: testPointSubSecond,
+			output:     "series_double,cpu,hostname=host_0,region=eu-west-1,datacenter=eu-west-1b,usage_guest_nice,2016-01-01T00:00,1451606400123456789,38.24311829\n",
+		},
+	}
+	testSerializer(t, cases, &CassandraSerializer{Layout: layout})
+}
+
+func TestParseCassandraLayout(t *testing.T) {
+	cases := []struct {
+		desc        string
+		bucket      string
+		keyOrder    string
+		want        *CassandraLayout
+		wantDefault bool
+		shouldErr   bool
+	}{
+		{
+			desc:        "empty means default",
+			want:        &CassandraLayout{},
+			wantDefault: true,
+		},
+		{
+			desc:        "explicit default",
+			bucket:      "24h",
+			keyOrder:    "series,field,bucket",
+			want:        &CassandraLayout{},
+			wantDefault: true,
+		},
+		{
+			desc:   "12h bucket",
+			bucket: "12h",
+			want:   &CassandraLayout{Bucket: 12 * time.Hour},
+		},
+		{
+			desc:   "30m bucket",
+			bucket: "30m",
+			want:   &CassandraLayout{Bucket: 30 * time.Minute},
+		},
+		{
+			desc:     "reordered key",
+			keyOrder: "field,bucket,series",
+			want:     &CassandraLayout{KeyOrder: []string{"field", "bucket", "series"}},
+		},
+		{
+			desc:      "bucket not dividing a day",
+			bucket:    "7h",
+			shouldErr: true,
+		},
+		{
+			desc:      "bucket longer than a day",
+			bucket:    "48h",
+			shouldErr: true,
+		},
+		{
+			desc:      "bucket shorter than a minute",
+			bucket:    "1s",
+			shouldErr: true,
+		},
+		{
+			desc:      "bucket not a duration",
+			bucket:    "1d",
+			shouldErr: true,
+		},
+		{
+			desc:      "unknown key part",
+			keyOrder:  "series,field,day",
+			shouldErr: true,
+		},
+		{
+			desc:      "repeated key part",
+			keyOrder:  "series,series,bucket",
+			shouldErr: true,
+		},
+		{
+			desc:      "missing key part",
+			keyOrder:  "series,bucket",
+			shouldErr: true,
+		},
+	}
+	for _, c := range cases {
+		l, err := ParseCassandraLayout(c.bucket, c.keyOrder)
+		if c.shouldErr {
+			if err == nil {
+				t.Errorf("%s: unexpected lack of error", c.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(l, c.want) {
+			t.Errorf("%s: incorrect layout: got %+v want %+v", c.desc, l, c.want)
+		}
+		if got := l.IsDefault(); got != c.wantDefault {
+			t.Errorf("%s: incorrect default: got %v want %v", c.desc, got, c.wantDefault)
+		}
+
+		// The header must describe the same layout
+		header := l.Header()
+		if c.wantDefault {
+			if len(header) > 0 {
+				t.Errorf("%s: unexpected header for default layout: %s", c.desc, header)
+			}
+			continue
+		}
+		got, ok, err := ParseCassandraLayoutHeader(string(bytes.TrimSpace(header)))
+		if err != nil || !ok {
+			t.Errorf("%s: cannot parse header '%s': %v", c.desc, header, err)
+		} else if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: incorrect layout from header: got %+v want %+v", c.desc, got, c.want)
+		}
+	}
+}
+
+func TestParseCassandraLayoutHeader(t *testing.T) {
+	l, ok, err := ParseCassandraLayoutHeader("series_double,cpu,hostname=host_0,usage_user,2016-01-01,1451606400000000000,1")
+	if l != nil || ok || err != nil {
+		t.Errorf("data line taken as a header: got %v, %v, %v", l, ok, err)
+	}
+	_, ok, err = ParseCassandraLayoutHeader("#layout bucket=1h order=series,field,bucket")
+	if !ok || err == nil {
+		t.Errorf("unexpected lack of error for unknown setting: got %v, %v", ok, err)
+	}
+	_, ok, err = ParseCassandraLayoutHeader("#layout bucket=5h")
+	if !ok || err == nil {
+		t.Errorf("unexpected lack of error for bad bucket: got %v, %v", ok, err)
+	}
+}
+
+func TestCassandraLayoutAppendBucket(t *testing.T) {
+	day := time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		desc   string
+		bucket time.Duration
+		ts     time.Time
+		want   string
+	}{
+		{desc: "day, at its start", ts: day, want: "2016-01-01"},
+		{desc: "day, at its end", ts: day.Add(24*time.Hour - time.Nanosecond), want: "2016-01-01"},
+		{desc: "day, next one", ts: day.Add(24 * time.Hour), want: "2016-01-02"},
+		{desc: "day, from another zone", ts: day.In(time.FixedZone("UTC-5", -5*3600)), want: "2016-01-01"},
+		{desc: "12h, at its start", bucket: 12 * time.Hour, ts: day, want: "2016-01-01T00:00"},
+		{desc: "12h, before noon", bucket: 12 * time.Hour, ts: day.Add(12*time.Hour - time.Nanosecond), want: "2016-01-01T00:00"},
+		{desc: "12h, at noon", bucket: 12 * time.Hour, ts: day.Add(12 * time.Hour), want: "2016-01-01T12:00"},
+		{desc: "12h, before midnight", bucket: 12 * time.Hour, ts: day.Add(24*time.Hour - time.Nanosecond), want: "2016-01-01T12:00"},
+		{desc: "1h, within an hour", bucket: time.Hour, ts: day.Add(5*time.Hour + 59*time.Minute), want: "2016-01-01T05:00"},
+		{desc: "1h, at the next hour", bucket: time.Hour, ts: day.Add(6 * time.Hour), want: "2016-01-01T06:00"},
+		{desc: "30m, at the half hour", bucket: 30 * time.Minute, ts: day.Add(90 * time.Minute), want: "2016-01-01T01:30"},
+		{desc: "8h, from another zone", bucket: 8 * time.Hour, ts: day.Add(16 * time.Hour).In(time.FixedZone("UTC+3", 3*3600)), want: "2016-01-01T16:00"},
+	}
+	for _, c := range cases {
+		l := &CassandraLayout{Bucket: c.bucket}
+		if got := string(l.AppendBucket(nil, c.ts)); got != c.want {
+			t.Errorf("%s: incorrect bucket: got %s want %s", c.desc, got, c.want)
+		}
+	}
+
+	var nilLayout *CassandraLayout
+	if got := string(nilLayout.AppendBucket(nil, day)); got != "2016-01-01" {
+		t.Errorf("incorrect bucket for nil layout: got %s want 2016-01-01", got)
+	}
+}
+
+func TestCassandraLayoutSeriesID(t *testing.T) {
+	cases := []struct {
+		keyOrder []string
+		want     string
+	}{
+		{want: "cpu,hostname=host_0#usage_user#2016-01-01"},
+		{keyOrder: []string{"bucket", "series", "field"}, want: "2016-01-01#cpu,hostname=host_0#usage_user"},
+		{keyOrder: []string{"field", "bucket", "series"}, want: "usage_user#2016-01-01#cpu,hostname=host_0"},
+	}
+	for _, c := range cases {
+		l := &CassandraLayout{KeyOrder: c.keyOrder}
+		if got := l.SeriesID("cpu,hostname=host_0", "usage_user", "2016-01-01"); got != c.want {
+			t.Errorf("incorrect series ID for key order %v: got %s want %s", c.keyOrder, got, c.want)
+		}
+	}
+}
+
 func TestCassandraSerializerSerializeErr(t *testing.T) {
 	p := testPointMultiField
 	s := &CassandraSerializer{}
@@ -104,3 +309,69 @@ func TestTypeNameForCassandra(t *testing.T) {
 		}
 	}
 }
+
+func BenchmarkCassandraSerializerSerialize(b *testing.B) {
+	benchmarkSerializer(b, &CassandraSerializer{})
+}
+
+func TestCassandraDeserializerDeserialize(t *testing.T) {
+	layout, err := ParseCassandraLayout("1h", "field,series,bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := bytes.NewBuffer(layout.Header())
+	s := &CassandraSerializer{Layout: layout}
+	// The same Point twice is still read as two, as its fields repeat
+	points := []*Point{testPointMultiField, testPointMultiField, testPointNoTags, testPointSubSecond}
+	for _, p := range points {
+		if err := s.Serialize(p, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := &CassandraDeserializer{}
+	r := bufio.NewReader(b)
+	p := NewPoint()
+	for i, want := range points {
+		if err := d.Deserialize(r, p); err != nil {
+			t.Fatalf("unexpected error for point %d: %v", i, err)
+		}
+		if diff := diffPoints(p, want, 1); diff != "" {
+			t.Errorf("point %d differs: %s", i, diff)
+		}
+	}
+	if err := d.Deserialize(r, p); err != io.EOF {
+		t.Errorf("incorrect error at the end: got %v want %v", err, io.EOF)
+	}
+	if !reflect.DeepEqual(d.Layout, layout) {
+		t.Errorf("incorrect layout: got %v want %v", d.Layout, layout)
+	}
+}
+
+func TestCassandraDeserializerDeserializeErr(t *testing.T) {
+	cases := []string{
+		"#layout bucket=7m",
+		"series_double,cpu,2016-01-01,1451606400000000000,1",
+		"series_double,cpu,hostname,usage,2016-01-01,1451606400000000000,1",
+		"series_double,cpu,usage,2016-01-01,now,1",
+		"series_double,cpu,usage,2016-01-01,1451606400000000000,x",
+		"series_text,cpu,usage,2016-01-01,1451606400000000000,1",
+	}
+	for _, c := range cases {
+		d := &CassandraDeserializer{}
+		if err := d.Deserialize(bufio.NewReader(strings.NewReader(c+"\n")), NewPoint()); err == nil {
+			t.Errorf("no error returned for '%s'", c)
+		}
+	}
+
+	// A bad line after a good one fails the next call, not the Point before
+	data := "series_double,cpu,usage,2016-01-01,1451606400000000000,1\nbad\n"
+	d := &CassandraDeserializer{}
+	r := bufio.NewReader(strings.NewReader(data))
+	if err := d.Deserialize(r, NewPoint()); err != nil {
+		t.Errorf("unexpected error for the good line: %v", err)
+	}
+	if err := d.Deserialize(r, NewPoint()); err == nil || err == io.EOF {
+		t.Errorf("no error returned for the bad line: %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package serialize
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// ChecksumHash is a hash of serialized data, written as hex in JSON
+type ChecksumHash uint64
+
+// MarshalText implements encoding.TextMarshaler
+func (h ChecksumHash) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%016x", uint64(h))), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (h *ChecksumHash) UnmarshalText(text []byte) error {
+	v, err := strconv.ParseUint(string(text), 16, 64)
+	if err != nil {
+		return fmt.Errorf("invalid checksum hash '%s'", text)
+	}
+	*h = ChecksumHash(v)
+	return nil
+}
+
+// Checksum summarizes serialized points: how many there are of each
+// measurement, their total size and a hash of their contents. The hash is the
+// sum of the FNV-1a hashes of the points, so it does not depend on the order
+// they are written in. Headers are not part of it.
+type Checksum struct {
+	// Group and Groups identify the interleaved group the points are from
+	Group  uint              `json:"group"`
+	Groups uint              `json:"groups"`
+	Points map[string]uint64 `json:"points"`
+	Bytes  uint64            `json:"bytes"`
+	Hash   ChecksumHash      `json:"hash"`
+}
+
+// NewChecksum returns an empty Checksum for the given interleaved group.
+func NewChecksum(group, groups uint) *Checksum {
+	return &Checksum{Group: group, Groups: groups, Points: map[string]uint64{}}
+}
+
+// AddPoint adds a point of the given measurement, serialized as data.
+func (c *Checksum) AddPoint(measurement string, data []byte) {
+	h := fnv.New64a()
+	h.Write(data)
+	c.Points[measurement]++
+	c.Bytes += uint64(len(data))
+	c.Hash += ChecksumHash(h.Sum64())
+}
+
+// Merge adds the points summarized by other to c.
+func (c *Checksum) Merge(other *Checksum) {
+	for m, n := range other.Points {
+		c.Points[m] += n
+	}
+	c.Bytes += other.Bytes
+	c.Hash += other.Hash
+}
+
+// Check returns an error describing the first difference between the points
+// summarized by c and by want, if any.
+func (c *Checksum) Check(want *Checksum) error {
+	measurements := make([]string, 0, len(want.Points))
+	for m := range want.Points {
+		measurements = append(measurements, m)
+	}
+	for m := range c.Points {
+		if _, ok := want.Points[m]; !ok {
+			measurements = append(measurements, m)
+		}
+	}
+	sort.Strings(measurements)
+	for _, m := range measurements {
+		if got, want := c.Points[m], want.Points[m]; got != want {
+			return fmt.Errorf("got %d points of %s, want %d", got, m, want)
+		}
+	}
+	if c.Bytes != want.Bytes {
+		return fmt.Errorf("got %d bytes, want %d", c.Bytes, want.Bytes)
+	}
+	if c.Hash != want.Hash {
+		return fmt.Errorf("got hash %016x, want %016x", uint64(c.Hash), uint64(want.Hash))
+	}
+	return nil
+}
+
+// Write writes c as JSON.
+func (c *Checksum) Write(w io.Writer) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// ReadChecksum reads a Checksum written by Checksum.Write.
+func ReadChecksum(r io.Reader) (*Checksum, error) {
+	c := &Checksum{}
+	if err := json.NewDecoder(r).Decode(c); err != nil {
+		return nil, fmt.Errorf("cannot parse checksum: %v", err)
+	}
+	if c.Points == nil {
+		c.Points = map[string]uint64{}
+	}
+	return c, nil
+}
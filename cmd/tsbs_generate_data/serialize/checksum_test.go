@@ -0,0 +1,122 @@
+package serialize
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChecksumAddPoint(t *testing.T) {
+	points := []struct {
+		measurement string
+		data        string
+	}{
+		{"cpu", "tags,hostname=host_0\ncpu,1451606400000000000,58\n"},
+		{"cpu", "tags,hostname=host_1\ncpu,1451606400000000000,12\n"},
+		{"mem", "tags,hostname=host_0\nmem,1451606400000000000,1024\n"},
+	}
+	forward := NewChecksum(0, 1)
+	backward := NewChecksum(0, 1)
+	for i := range points {
+		forward.AddPoint(points[i].measurement, []byte(points[i].data))
+		j := len(points) - 1 - i
+		backward.AddPoint(points[j].measurement, []byte(points[j].data))
+	}
+	if got, want := forward.Points, map[string]uint64{"cpu": 2, "mem": 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect points: got %v want %v", got, want)
+	}
+	wantBytes := uint64(0)
+	for _, p := range points {
+		wantBytes += uint64(len(p.data))
+	}
+	if forward.Bytes != wantBytes {
+		t.Errorf("incorrect bytes: got %d want %d", forward.Bytes, wantBytes)
+	}
+	if err := backward.Check(forward); err != nil {
+		t.Errorf("checksum depends on the order of points: %v", err)
+	}
+
+	// A single changed byte must change the hash
+	changed := NewChecksum(0, 1)
+	for i, p := range points {
+		data := []byte(p.data)
+		if i == 1 {
+			data[len(data)-2] = '3'
+		}
+		changed.AddPoint(p.measurement, data)
+	}
+	if err := changed.Check(forward); err == nil || !strings.Contains(err.Error(), "hash") {
+		t.Errorf("incorrect error for changed byte: got %v", err)
+	}
+}
+
+func TestChecksumCheck(t *testing.T) {
+	want := &Checksum{Points: map[string]uint64{"cpu": 2, "mem": 1}, Bytes: 100, Hash: 0xabc}
+	cases := []struct {
+		desc    string
+		got     *Checksum
+		wantErr string
+	}{
+		{
+			desc: "same",
+			got:  &Checksum{Points: map[string]uint64{"cpu": 2, "mem": 1}, Bytes: 100, Hash: 0xabc},
+		},
+		{
+			desc:    "missing points",
+			got:     &Checksum{Points: map[string]uint64{"cpu": 2}, Bytes: 100, Hash: 0xabc},
+			wantErr: "got 0 points of mem, want 1",
+		},
+		{
+			desc:    "extra measurement",
+			got:     &Checksum{Points: map[string]uint64{"cpu": 2, "disk": 1, "mem": 1}, Bytes: 100, Hash: 0xabc},
+			wantErr: "got 1 points of disk, want 0",
+		},
+		{
+			desc:    "different bytes",
+			got:     &Checksum{Points: map[string]uint64{"cpu": 2, "mem": 1}, Bytes: 99, Hash: 0xabc},
+			wantErr: "got 99 bytes, want 100",
+		},
+		{
+			desc:    "different hash",
+			got:     &Checksum{Points: map[string]uint64{"cpu": 2, "mem": 1}, Bytes: 100, Hash: 0xabd},
+			wantErr: "got hash 0000000000000abd, want 0000000000000abc",
+		},
+	}
+	for _, c := range cases {
+		err := c.got.Check(want)
+		if len(c.wantErr) == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", c.desc, err)
+			}
+		} else if err == nil {
+			t.Errorf("%s: unexpected lack of error", c.desc)
+		} else if err.Error() != c.wantErr {
+			t.Errorf("%s: incorrect error: got %v want %s", c.desc, err, c.wantErr)
+		}
+	}
+}
+
+func TestChecksumWriteRead(t *testing.T) {
+	c := NewChecksum(1, 3)
+	c.AddPoint("cpu", []byte("tags,hostname=host_0\ncpu,1451606400000000000,58\n"))
+	var buf bytes.Buffer
+	if err := c.Write(&buf); err != nil {
+		t.Fatalf("unexpected error writing checksum: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"group":1,"groups":3`) {
+		t.Errorf("group missing from checksum: %s", buf.String())
+	}
+	got, err := ReadChecksum(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error reading checksum: %v", err)
+	}
+	if !reflect.DeepEqual(got, c) {
+		t.Errorf("incorrect checksum: got %v want %v", got, c)
+	}
+
+	_, err = ReadChecksum(strings.NewReader(`{"points":{"cpu":1},"bytes":10,"hash":"xyz"}`))
+	if err == nil {
+		t.Errorf("unexpected lack of error for invalid hash")
+	}
+}
@@ -1,14 +1,24 @@
 package serialize
 
 import (
-	"fmt"
 	"io"
+	"strconv"
+	"time"
 )
 
 const TAB = '\t'
 
 // CrateDBSerializer writes a Point in a serialized form for CrateDB
-type CrateDBSerializer struct{}
+type CrateDBSerializer struct {
+	// TimestampUnit is the unit timestamps are written in, nanoseconds if 0
+	TimestampUnit time.Duration
+	// FloatPrecision is the number of decimals floats are written with, as
+	// many as needed to be exact if 0
+	FloatPrecision int
+
+	// buf is reused by every call to Serialize to avoid allocating per Point
+	buf []byte
+}
 
 // Serialize Point p to the given Writer w, so it can be  loaded by the CrateDB
 // loader. The format is TSV with one line per point, that contains the
@@ -18,7 +28,7 @@ type CrateDBSerializer struct{}
 // An example of a serialized point:
 //     cpu\t{"hostname":"host_0","rack":"1"}\t1451606400000000000\t38\t0\t50\t41234
 func (s *CrateDBSerializer) Serialize(p *Point, w io.Writer) error {
-	buf := make([]byte, 0, 256)
+	buf := s.buf[:0]
 
 	// measurement type
 	buf = append(buf, p.measurementName...)
@@ -30,27 +40,31 @@ func (s *CrateDBSerializer) Serialize(p *Point, w io.Writer) error {
 		for i, key := range p.tagKeys {
 			buf = append(buf, '"')
 			buf = append(buf, key...)
-			buf = append(buf, []byte("\":\"")...)
+			buf = append(buf, "\":\""...)
 			buf = append(buf, p.tagValues[i]...)
-			buf = append(buf, []byte("\",")...)
+			buf = append(buf, "\","...)
 		}
 		buf = buf[:len(buf)-1]
 		buf = append(buf, '}')
 	} else {
-		buf = append(buf, []byte("null")...)
+		buf = append(buf, "null"...)
 	}
 
 	// timestamp
 	buf = append(buf, TAB)
-	ts := fmt.Sprintf("%d", p.timestamp.UTC().UnixNano())
-	buf = append(buf, ts...)
+	buf = strconv.AppendInt(buf, timestampIn(p.timestamp, s.TimestampUnit), 10)
 
 	// metrics
+	var err error
 	for _, v := range p.fieldValues {
 		buf = append(buf, TAB)
-		buf = fastFormatAppend(v, buf)
+		buf, err = appendTextField(buf, v, s.FloatPrecision)
+		if err != nil {
+			return err
+		}
 	}
 	buf = append(buf, '\n')
-	_, err := w.Write(buf)
+	_, err = w.Write(buf)
+	s.buf = buf
 	return err
 }
@@ -27,6 +27,11 @@ func TestCrateDBSerializerSerialize(t *testing.T) {
 			inputPoint: testPointNoTags,
 			output:     "cpu\tnull\t1451606400000000000\t38.24311829\n",
 		},
+		{
+			desc:       "a Point with a sub-second timestamp",
+			inputPoint: testPointSubSecond,
+			output:     "cpu\t{\"hostname\":\"host_0\",\"region\":\"eu-west-1\",\"datacenter\":\"eu-west-1b\"}\t1451606400123456789\t38.24311829\n",
+		},
 	}
 
 	testSerializer(t, cases, &CrateDBSerializer{})
@@ -42,3 +47,7 @@ func TestCrateDBSerializerSerializeErr(t *testing.T) {
 		t.Errorf("unexpected writer error: %v", err)
 	}
 }
+
+func BenchmarkCrateDBSerializerSerialize(b *testing.B) {
+	benchmarkSerializer(b, &CrateDBSerializer{})
+}
@@ -0,0 +1,309 @@
+package serialize
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// testRoundTripSchema describes the Points round-tripped through every
+// format, with integer columns among the float ones
+var testRoundTripSchema = &Schema{
+	Tags: []string{"hostname", "region", "service"},
+	Measurements: []SchemaMeasurement{
+		{
+			Name: "cpu",
+			Columns: []SchemaColumn{
+				{Name: "usage_user", Type: "float64"},
+				{Name: "usage_system", Type: "float64"},
+			},
+		},
+		{
+			Name: "redis",
+			Columns: []SchemaColumn{
+				{Name: "uptime_in_seconds", Type: "int64"},
+				{Name: "used_memory", Type: "float64"},
+				{Name: "connected_clients", Type: "int64"},
+			},
+		},
+	},
+}
+
+// testRoundTripTypedSchema describes Points with string and bool fields, which
+// only some formats can hold
+var testRoundTripTypedSchema = &Schema{
+	Tags: []string{"hostname"},
+	Measurements: []SchemaMeasurement{
+		{
+			Name: "service",
+			Columns: []SchemaColumn{
+				{Name: "latency", Type: "float64"},
+				{Name: "status", Type: "string"},
+				{Name: "healthy", Type: "bool"},
+			},
+		},
+	},
+}
+
+// testStrings are string values with the characters formats escape
+var testStrings = []string{"ok", "", "up, ready", `say "hi"`, `back\slash`, "a=b c"}
+
+// randomPoints returns n Points of the measurements of schema, with random
+// tags, timestamps and values of the types of their columns. Some Points have
+// no tags at all.
+func randomPoints(rng *rand.Rand, schema *Schema, n int) []*Point {
+	start := time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC)
+	points := make([]*Point, n)
+	for i := range points {
+		p := NewPoint()
+		m := schema.Measurements[rng.Intn(len(schema.Measurements))]
+		p.SetMeasurementName([]byte(m.Name))
+		if rng.Intn(10) > 0 {
+			for _, tag := range schema.Tags {
+				p.AppendTag([]byte(tag), []byte(fmt.Sprintf("%s_%d", tag, rng.Intn(1000))))
+			}
+		}
+		for _, c := range m.Columns {
+			var v interface{}
+			if isIntegerColumnType(c.Type) {
+				v = rng.Int63n(1e12) - 5e11
+			} else if c.Type == columnTypeString {
+				v = []byte(testStrings[rng.Intn(len(testStrings))])
+			} else if c.Type == columnTypeBool {
+				v = rng.Intn(2) == 0
+			} else {
+				v = rng.NormFloat64() * math.Pow10(rng.Intn(10)-3)
+			}
+			p.AppendField([]byte(c.Name), v)
+		}
+		ts := start.Add(time.Duration(rng.Int63n(int64(365 * 24 * time.Hour))))
+		p.SetTimestamp(&ts)
+		points[i] = p
+	}
+	return points
+}
+
+// numericValue returns v as a float64 and, if it is an integer, as an int64
+func numericValue(v interface{}) (f float64, i int64, isInt bool, ok bool) {
+	switch x := v.(type) {
+	case int:
+		return float64(x), int64(x), true, true
+	case int64:
+		return float64(x), x, true, true
+	case float32:
+		return float64(x), 0, false, true
+	case float64:
+		return x, 0, false, true
+	}
+	return 0, 0, false, false
+}
+
+// equalValues reports whether field values a and b are semantically equal,
+// i.e., the same number, whatever its type, or the same bytes
+func equalValues(a, b interface{}) bool {
+	fa, ia, aInt, aNum := numericValue(a)
+	fb, ib, bInt, bNum := numericValue(b)
+	if aNum || bNum {
+		if aInt && bInt {
+			return ia == ib
+		}
+		return aNum && bNum && fa == fb
+	}
+	switch x := a.(type) {
+	case []byte:
+		return fmt.Sprintf("%s", b) == string(x)
+	case string:
+		return fmt.Sprintf("%s", b) == x
+	}
+	return a == b
+}
+
+// diffPoints describes how got differs from want, whose timestamp was
+// written truncated to precision, or returns "" if they are semantically
+// equal: the same measurement, tags, fields and timestamp.
+func diffPoints(got, want *Point, precision time.Duration) string {
+	if !bytes.Equal(got.measurementName, want.measurementName) {
+		return fmt.Sprintf("measurement: got %s want %s", got.measurementName, want.measurementName)
+	}
+	if wantTS := want.timestamp.Truncate(precision); !got.timestamp.Equal(wantTS) {
+		return fmt.Sprintf("timestamp: got %v want %v", got.timestamp, wantTS)
+	}
+	if len(got.tagKeys) != len(want.tagKeys) {
+		return fmt.Sprintf("tag count: got %d want %d", len(got.tagKeys), len(want.tagKeys))
+	}
+	for i, k := range want.tagKeys {
+		if !bytes.Equal(got.tagKeys[i], k) || !bytes.Equal(got.tagValues[i], want.tagValues[i]) {
+			return fmt.Sprintf("tag %d: got %s=%s want %s=%s", i, got.tagKeys[i], got.tagValues[i], k, want.tagValues[i])
+		}
+	}
+	if len(got.fieldKeys) != len(want.fieldKeys) {
+		return fmt.Sprintf("field count: got %d want %d", len(got.fieldKeys), len(want.fieldKeys))
+	}
+	for i, k := range want.fieldKeys {
+		if !bytes.Equal(got.fieldKeys[i], k) || !equalValues(got.fieldValues[i], want.fieldValues[i]) {
+			return fmt.Sprintf("field %d: got %s=%v (%T) want %s=%v (%T)", i, got.fieldKeys[i], got.fieldValues[i], got.fieldValues[i], k, want.fieldValues[i], want.fieldValues[i])
+		}
+	}
+	return ""
+}
+
+// testRoundTrip serializes points after header, then checks that they are
+// deserialized back as they were, timestamps aside, written truncated to
+// precision, and that nothing else follows them.
+func testRoundTrip(t *testing.T, desc string, points []*Point, header []byte, s PointSerializer, d PointDeserializer, precision time.Duration) {
+	b := bytes.NewBuffer(append([]byte{}, header...))
+	for _, p := range points {
+		if err := s.Serialize(p, b); err != nil {
+			t.Fatalf("%s: unexpected serialize error: %v", desc, err)
+		}
+	}
+
+	r := bufio.NewReader(b)
+	got := NewPoint()
+	for i, want := range points {
+		if err := d.Deserialize(r, got); err != nil {
+			t.Fatalf("%s: unexpected deserialize error for point %d: %v", desc, i, err)
+		}
+		if diff := diffPoints(got, want, precision); diff != "" {
+			t.Errorf("%s: point %d differs: %s", desc, i, diff)
+		}
+	}
+	if err := d.Deserialize(r, got); err == nil {
+		t.Errorf("%s: more points than serialized", desc)
+	}
+}
+
+func TestDeserializersRoundTrip(t *testing.T) {
+	layout, err := ParseCassandraLayout("1h", "bucket,series,field")
+	if err != nil {
+		t.Fatal(err)
+	}
+	binaryHeader := append(testRoundTripSchema.Header(), PGCopyHeader()...)
+
+	cases := []struct {
+		desc      string
+		header    []byte
+		s         PointSerializer
+		d         PointDeserializer
+		precision time.Duration
+	}{
+		{
+			desc:      "influx",
+			s:         &InfluxSerializer{},
+			d:         &InfluxDeserializer{},
+			precision: time.Nanosecond,
+		},
+		{
+			desc:      "influx in microseconds",
+			s:         &InfluxSerializer{TimestampUnit: time.Microsecond},
+			d:         &InfluxDeserializer{TimestampUnit: time.Microsecond},
+			precision: time.Microsecond,
+		},
+		{
+			desc:      "timescaledb",
+			header:    testRoundTripSchema.Header(),
+			s:         &TimescaleDBSerializer{},
+			d:         &TimescaleDBDeserializer{},
+			precision: time.Nanosecond,
+		},
+		{
+			desc:      "timescaledb in milliseconds",
+			header:    testRoundTripSchema.Header(),
+			s:         &TimescaleDBSerializer{TimestampUnit: time.Millisecond},
+			d:         &TimescaleDBDeserializer{TimestampUnit: time.Millisecond},
+			precision: time.Millisecond,
+		},
+		{
+			desc:      "timescaledb without header",
+			s:         &TimescaleDBSerializer{},
+			d:         &TimescaleDBDeserializer{Schema: testRoundTripSchema},
+			precision: time.Nanosecond,
+		},
+		{
+			desc:      "timescaledb binary",
+			header:    binaryHeader,
+			s:         &TimescaleDBBinarySerializer{IntegerFields: true},
+			d:         &TimescaleDBBinaryDeserializer{},
+			precision: time.Microsecond,
+		},
+		{
+			desc:      "cassandra",
+			s:         &CassandraSerializer{},
+			d:         &CassandraDeserializer{},
+			precision: time.Nanosecond,
+		},
+		{
+			desc:      "cassandra with a layout",
+			header:    layout.Header(),
+			s:         &CassandraSerializer{TimestampUnit: time.Second, Layout: layout},
+			d:         &CassandraDeserializer{TimestampUnit: time.Second},
+			precision: time.Second,
+		},
+		{
+			desc:      "mongo",
+			s:         &MongoSerializer{},
+			d:         &MongoDeserializer{},
+			precision: time.Nanosecond,
+		},
+		{
+			desc:      "mongo raw",
+			s:         &MongoSerializer{DocStyle: MongoDocStyleRaw},
+			d:         &MongoDeserializer{DocStyle: MongoDocStyleRaw},
+			precision: time.Nanosecond,
+		},
+	}
+
+	rng := rand.New(rand.NewSource(123))
+	for _, c := range cases {
+		points := randomPoints(rng, testRoundTripSchema, 200)
+		testRoundTrip(t, c.desc, points, c.header, c.s, c.d, c.precision)
+	}
+}
+
+func TestDeserializersRoundTripTyped(t *testing.T) {
+	binaryHeader := append(testRoundTripTypedSchema.Header(), PGCopyHeader()...)
+	cases := []struct {
+		desc      string
+		header    []byte
+		s         PointSerializer
+		d         PointDeserializer
+		precision time.Duration
+	}{
+		{
+			desc:      "influx",
+			s:         &InfluxSerializer{},
+			d:         &InfluxDeserializer{},
+			precision: time.Nanosecond,
+		},
+		{
+			desc:      "timescaledb",
+			header:    testRoundTripTypedSchema.Header(),
+			s:         &TimescaleDBSerializer{},
+			d:         &TimescaleDBDeserializer{},
+			precision: time.Nanosecond,
+		},
+		{
+			desc:      "timescaledb binary",
+			header:    binaryHeader,
+			s:         &TimescaleDBBinarySerializer{},
+			d:         &TimescaleDBBinaryDeserializer{},
+			precision: time.Microsecond,
+		},
+		{
+			desc:      "mongo raw",
+			s:         &MongoSerializer{DocStyle: MongoDocStyleRaw},
+			d:         &MongoDeserializer{DocStyle: MongoDocStyleRaw},
+			precision: time.Nanosecond,
+		},
+	}
+
+	rng := rand.New(rand.NewSource(123))
+	for _, c := range cases {
+		points := randomPoints(rng, testRoundTripTypedSchema, 200)
+		testRoundTrip(t, c.desc, points, c.header, c.s, c.d, c.precision)
+	}
+}
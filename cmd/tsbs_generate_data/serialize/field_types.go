@@ -0,0 +1,96 @@
+package serialize
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// Types of field values that are neither floats nor integers
+const (
+	columnTypeString = "string"
+	columnTypeBool   = "bool"
+)
+
+// fieldValueType returns the type of SchemaColumn that holds field value v
+func fieldValueType(v interface{}) string {
+	switch v.(type) {
+	case int, int64:
+		return "int64"
+	case []byte, string:
+		return columnTypeString
+	case bool:
+		return columnTypeBool
+	default:
+		return columnTypeDefault
+	}
+}
+
+// parseColumnValue parses a value of a column of type colType written in one
+// of the text formats, already unescaped: integers as int64s, strings as
+// []byte, booleans as bools and anything else as float64s.
+func parseColumnValue(s string, colType string) (interface{}, error) {
+	switch {
+	case isIntegerColumnType(colType):
+		return strconv.ParseInt(s, 10, 64)
+	case colType == columnTypeString:
+		return []byte(s), nil
+	case colType == columnTypeBool:
+		return strconv.ParseBool(s)
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// fieldTypeSeen is the type a field of a measurement was first seen with
+type fieldTypeSeen struct {
+	key       []byte
+	valueType string
+}
+
+// FieldTypeChecker checks that each field of a measurement has values of the
+// same type in every Point, as columns of the CSV-like formats and of the
+// databases they are loaded into have a single type.
+type FieldTypeChecker struct {
+	// measurements holds the fields of each measurement in the order they
+	// were first seen, which Points usually keep
+	measurements map[string][]fieldTypeSeen
+}
+
+// NewFieldTypeChecker returns a FieldTypeChecker that has not seen any Points
+func NewFieldTypeChecker() *FieldTypeChecker {
+	return &FieldTypeChecker{measurements: make(map[string][]fieldTypeSeen)}
+}
+
+// Check returns an error naming the first field of p whose value is not of
+// the type it had in the Points checked before, and records the types of the
+// fields seen for the first time.
+func (c *FieldTypeChecker) Check(p *Point) error {
+	seen, ok := c.measurements[string(p.measurementName)]
+	if !ok {
+		seen = make([]fieldTypeSeen, 0, len(p.fieldKeys))
+	}
+	added := false
+	for i, key := range p.fieldKeys {
+		if p.fieldValues[i] == nil {
+			// NULLs fit a column of any type
+			continue
+		}
+		valueType := fieldValueType(p.fieldValues[i])
+		j := i
+		if j >= len(seen) || !bytes.Equal(seen[j].key, key) {
+			for j = 0; j < len(seen) && !bytes.Equal(seen[j].key, key); j++ {
+			}
+		}
+		if j == len(seen) {
+			seen = append(seen, fieldTypeSeen{key: append([]byte(nil), key...), valueType: valueType})
+			added = true
+		} else if seen[j].valueType != valueType {
+			return fmt.Errorf("field '%s' of measurement '%s' is a %s, but was a %s in earlier points",
+				key, p.measurementName, valueType, seen[j].valueType)
+		}
+	}
+	if added || !ok {
+		c.measurements[string(p.measurementName)] = seen
+	}
+	return nil
+}
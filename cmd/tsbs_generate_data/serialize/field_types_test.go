@@ -0,0 +1,119 @@
+package serialize
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFieldTypeChecker(t *testing.T) {
+	point := func(measurement string, keys []string, values ...interface{}) *Point {
+		p := NewPoint()
+		p.SetMeasurementName([]byte(measurement))
+		for i, k := range keys {
+			p.AppendField([]byte(k), values[i])
+		}
+		return p
+	}
+	cases := []struct {
+		desc   string
+		points []*Point
+		errKey string
+	}{
+		{
+			desc: "same types",
+			points: []*Point{
+				point("cpu", []string{"usage", "status"}, 1.5, []byte("ok")),
+				point("cpu", []string{"usage", "status"}, 2.5, []byte("down")),
+			},
+		},
+		{
+			desc: "fields in another order",
+			points: []*Point{
+				point("cpu", []string{"usage", "status"}, 1.5, []byte("ok")),
+				point("cpu", []string{"status", "usage"}, []byte("down"), 2.5),
+			},
+		},
+		{
+			desc: "same field in another measurement",
+			points: []*Point{
+				point("cpu", []string{"usage"}, 1.5),
+				point("mem", []string{"usage"}, int64(2)),
+			},
+		},
+		{
+			desc: "int and int64",
+			points: []*Point{
+				point("cpu", []string{"count"}, 1),
+				point("cpu", []string{"count"}, int64(2)),
+			},
+		},
+		{
+			desc: "a NULL value",
+			points: []*Point{
+				point("cpu", []string{"up"}, true),
+				point("cpu", []string{"up"}, nil),
+			},
+		},
+		{
+			desc: "a float then a string",
+			points: []*Point{
+				point("cpu", []string{"usage", "status"}, 1.5, []byte("ok")),
+				point("cpu", []string{"usage", "status"}, 2.5, 3.5),
+			},
+			errKey: "status",
+		},
+		{
+			desc: "a new field of another type",
+			points: []*Point{
+				point("cpu", []string{"usage"}, 1.5),
+				point("cpu", []string{"usage", "up"}, 1.5, true),
+				point("cpu", []string{"up"}, "yes"),
+			},
+			errKey: "up",
+		},
+	}
+	for _, c := range cases {
+		checker := NewFieldTypeChecker()
+		var err error
+		for _, p := range c.points {
+			if err = checker.Check(p); err != nil {
+				break
+			}
+		}
+		if c.errKey == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", c.desc, err)
+		} else if c.errKey != "" && (err == nil || !strings.Contains(err.Error(), "'"+c.errKey+"'")) {
+			t.Errorf("%s: error does not name field %s: %v", c.desc, c.errKey, err)
+		}
+	}
+}
+
+func TestParseColumnValue(t *testing.T) {
+	cases := []struct {
+		value   string
+		colType string
+		want    interface{}
+	}{
+		{value: "1.5", colType: "", want: 1.5},
+		{value: "1.5", colType: "float64", want: 1.5},
+		{value: "-3", colType: "int64", want: int64(-3)},
+		{value: "3", colType: "uint64", want: int64(3)},
+		{value: "true", colType: "bool", want: true},
+	}
+	for _, c := range cases {
+		got, err := parseColumnValue(c.value, c.colType)
+		if err != nil {
+			t.Errorf("%s as %s: unexpected error: %v", c.value, c.colType, err)
+		} else if got != c.want {
+			t.Errorf("%s as %s: got %#v want %#v", c.value, c.colType, got, c.want)
+		}
+	}
+
+	got, err := parseColumnValue("a, b", "string")
+	if b, ok := got.([]byte); err != nil || !ok || string(b) != "a, b" {
+		t.Errorf("string: got %#v (%v)", got, err)
+	}
+	if _, err := parseColumnValue("yes", "bool"); err == nil {
+		t.Errorf("no error for an invalid bool")
+	}
+}
@@ -0,0 +1,75 @@
+package serialize
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// tieMargin is how close, relative to its size, a scaled float may be to
+// halfway between two integers before appendFixed leaves it to strconv. It is
+// a few times the error of the scaling multiplication.
+const tieMargin = 1.0 / (1 << 50)
+
+// pow10 holds the powers of ten appendFixed can scale by, exactly as floats
+// and as integers.
+var (
+	pow10    = [...]float64{1, 1e1, 1e2, 1e3, 1e4, 1e5, 1e6, 1e7, 1e8, 1e9, 1e10, 1e11, 1e12, 1e13, 1e14, 1e15}
+	pow10Int = [...]uint64{1, 1e1, 1e2, 1e3, 1e4, 1e5, 1e6, 1e7, 1e8, 1e9, 1e10, 1e11, 1e12, 1e13, 1e14, 1e15}
+)
+
+// appendFloat appends v, a float of the given bitSize (32 or 64), to buf with
+// precision decimals, or as many as needed to be exact if precision is 0. NaN
+// and infinities are rejected, as no loader can parse them back.
+func appendFloat(buf []byte, v float64, precision int, bitSize int) ([]byte, error) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return buf, fmt.Errorf("cannot serialize non-finite float %v", v)
+	}
+	if precision <= 0 {
+		return strconv.AppendFloat(buf, v, 'f', -1, bitSize), nil
+	}
+	if precision < len(pow10) {
+		if ret, ok := appendFixed(buf, v, precision); ok {
+			return ret, nil
+		}
+	}
+	return strconv.AppendFloat(buf, v, 'f', precision, bitSize), nil
+}
+
+// appendFixed formats v with precision decimals like strconv.AppendFloat with
+// format 'f', but much faster, by rounding v scaled by 10^precision to an
+// integer. It reports false, leaving buf as is, when the scaled value is too
+// large or too close to a tie for that rounding to be certain to match.
+func appendFixed(buf []byte, v float64, precision int) ([]byte, bool) {
+	neg := math.Signbit(v)
+	x := math.Abs(v) * pow10[precision]
+	if x >= 1<<53 {
+		return buf, false
+	}
+	n := math.Floor(x)
+	frac := x - n
+	if math.Abs(frac-0.5) <= x*tieMargin {
+		return buf, false
+	}
+	scaled := uint64(n)
+	if frac > 0.5 {
+		scaled++
+	}
+
+	if neg {
+		buf = append(buf, '-')
+	}
+	buf = strconv.AppendUint(buf, scaled/pow10Int[precision], 10)
+	buf = append(buf, '.')
+	// Decimals are filled in from the last one, keeping leading zeros
+	decimals := scaled % pow10Int[precision]
+	start := len(buf)
+	for i := 0; i < precision; i++ {
+		buf = append(buf, '0')
+	}
+	for i := len(buf) - 1; i >= start && decimals > 0; i-- {
+		buf[i] = byte('0' + decimals%10)
+		decimals /= 10
+	}
+	return buf, true
+}
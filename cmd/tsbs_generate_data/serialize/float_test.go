@@ -0,0 +1,139 @@
+package serialize
+
+import (
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestAppendFloatMatchesStrconv(t *testing.T) {
+	r := rand.New(rand.NewSource(123))
+	for i := 0; i < 200000; i++ {
+		// Values over a wide range of magnitudes, of both signs
+		v := (r.Float64()*2 - 1) * math.Pow(10, float64(r.Intn(24)-10))
+		if i%10 == 0 {
+			// Values with few decimals, as the simulators often produce
+			v = math.Floor(v*1000) / 1000
+		}
+		precision := r.Intn(18)
+		strconvPrecision := precision
+		if precision == 0 {
+			strconvPrecision = -1
+		}
+		want := strconv.FormatFloat(v, 'f', strconvPrecision, 64)
+		got, err := appendFloat(nil, v, precision, 64)
+		if err != nil {
+			t.Fatalf("unexpected error for %v: %v", v, err)
+		}
+		if string(got) != want {
+			t.Fatalf("incorrect format of %v with precision %d: got %s want %s", v, precision, got, want)
+		}
+	}
+}
+
+func TestAppendFloat(t *testing.T) {
+	cases := []struct {
+		desc      string
+		v         float64
+		precision int
+		bitSize   int
+		want      string
+		wantErr   bool
+	}{
+		{desc: "exact", v: 29.37, precision: 0, bitSize: 64, want: "29.37"},
+		{desc: "exact float32", v: float64(float32(29.37)), precision: 0, bitSize: 32, want: "29.37"},
+		{desc: "rounded up", v: 29.376, precision: 2, bitSize: 64, want: "29.38"},
+		{desc: "rounded down", v: 29.374, precision: 2, bitSize: 64, want: "29.37"},
+		{desc: "padded", v: 29.5, precision: 3, bitSize: 64, want: "29.500"},
+		{desc: "leading decimal zeros", v: 1.005625, precision: 6, bitSize: 64, want: "1.005625"},
+		{desc: "negative", v: -29.376, precision: 2, bitSize: 64, want: "-29.38"},
+		{desc: "exact tie to even down", v: 0.125, precision: 2, bitSize: 64, want: "0.12"},
+		{desc: "exact tie to even up", v: 0.375, precision: 2, bitSize: 64, want: "0.38"},
+		{desc: "rounds up to next integer", v: 9.9999996, precision: 6, bitSize: 64, want: "10.000000"},
+		{desc: "too large for fast path", v: 1e20, precision: 6, bitSize: 64, want: "100000000000000000000.000000"},
+		{desc: "very small", v: 1e-300, precision: 6, bitSize: 64, want: "0.000000"},
+		{desc: "very small negative", v: -1e-300, precision: 6, bitSize: 64, want: "-0.000000"},
+		{desc: "smallest denormal exact", v: math.SmallestNonzeroFloat64, precision: 0, bitSize: 64, want: strconv.FormatFloat(math.SmallestNonzeroFloat64, 'f', -1, 64)},
+		{desc: "NaN", v: math.NaN(), precision: 0, bitSize: 64, wantErr: true},
+		{desc: "NaN w/ precision", v: math.NaN(), precision: 6, bitSize: 64, wantErr: true},
+		{desc: "positive infinity", v: math.Inf(1), precision: 0, bitSize: 64, wantErr: true},
+		{desc: "negative infinity", v: math.Inf(-1), precision: 6, bitSize: 32, wantErr: true},
+	}
+	for _, c := range cases {
+		got, err := appendFloat([]byte("values,"), c.v, c.precision, c.bitSize)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("%s: unexpected lack of error", c.desc)
+			}
+		} else if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.desc, err)
+		} else if want := "values," + c.want; string(got) != want {
+			t.Errorf("%s: incorrect output: got %s want %s", c.desc, got, want)
+		}
+	}
+}
+
+func TestSerializersFloatPrecision(t *testing.T) {
+	p := &Point{
+		measurementName: testMeasurement,
+		tagKeys:         testTagKeys[:1],
+		tagValues:       testTagVals[:1],
+		timestamp:       &testNow,
+		fieldKeys:       [][]byte{testColFloat},
+		fieldValues:     []interface{}{38.24311829},
+	}
+	testSerializer(t, []serializeCase{{
+		desc:       "influx with 2 decimals",
+		inputPoint: p,
+		output:     "cpu,hostname=host_0 usage_guest_nice=38.24 1451606400000000000\n",
+	}}, &InfluxSerializer{FloatPrecision: 2})
+	testSerializer(t, []serializeCase{{
+		desc:       "timescaledb with 2 decimals",
+		inputPoint: p,
+		output:     "tags,hostname=host_0\ncpu,1451606400000000000,38.24\n",
+	}}, &TimescaleDBSerializer{FloatPrecision: 2})
+
+	nan := &Point{
+		measurementName: testMeasurement,
+		tagKeys:         testTagKeys[:1],
+		tagValues:       testTagVals[:1],
+		timestamp:       &testNow,
+		fieldKeys:       [][]byte{testColFloat},
+		fieldValues:     []interface{}{math.NaN()},
+	}
+	serializers := map[string]PointSerializer{
+		"influx":      &InfluxSerializer{},
+		"timescaledb": &TimescaleDBSerializer{},
+		"cassandra":   &CassandraSerializer{},
+		"cratedb":     &CrateDBSerializer{},
+	}
+	for name, ps := range serializers {
+		if err := ps.Serialize(nan, ioutil.Discard); err == nil {
+			t.Errorf("%s: unexpected lack of error for NaN", name)
+		}
+	}
+}
+
+func BenchmarkAppendFloat(b *testing.B) {
+	values := make([]float64, 1024)
+	r := rand.New(rand.NewSource(123))
+	for i := range values {
+		values[i] = r.Float64() * 100
+	}
+	for _, precision := range []int{0, 6} {
+		b.Run("precision="+strconv.Itoa(precision), func(b *testing.B) {
+			buf := make([]byte, 0, 64)
+			for i := 0; i < b.N; i++ {
+				buf, _ = appendFloat(buf[:0], values[i%len(values)], precision, 64)
+			}
+		})
+	}
+	b.Run("strconv precision=6", func(b *testing.B) {
+		buf := make([]byte, 0, 64)
+		for i := 0; i < b.N; i++ {
+			buf = strconv.AppendFloat(buf[:0], values[i%len(values)], 'f', 6, 64)
+		}
+	})
+}
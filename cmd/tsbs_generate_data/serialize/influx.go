@@ -1,11 +1,76 @@
 package serialize
 
 import (
+	"bufio"
+	"bytes"
+	"fmt"
 	"io"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// Bytes that must be escaped with a backslash in the line protocol, depending
+// on where they appear
+var (
+	influxMeasurementEscapes = influxEscapes(", ")
+	influxKeyEscapes         = influxEscapes(", =")
+	influxStringEscapes      = influxEscapes("\"\\")
+)
+
+func influxEscapes(special string) *[256]bool {
+	var escapes [256]bool
+	for i := 0; i < len(special); i++ {
+		escapes[special[i]] = true
+	}
+	return &escapes
+}
+
+// appendInfluxEscaped appends s to buf with a backslash before every byte
+// marked in escapes, scanning s once and copying the runs in between whole.
+func appendInfluxEscaped(buf, s []byte, escapes *[256]bool) []byte {
+	start := 0
+	for i, c := range s {
+		if escapes[c] {
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, '\\')
+			start = i
+		}
+	}
+	return append(buf, s[start:]...)
+}
+
+// scanInfluxEscaped returns b from start up to the first unescaped byte in
+// stops, with backslashes before bytes marked in escapes removed, and the
+// index of that byte, or len(b) if there is none.
+func scanInfluxEscaped(b []byte, start int, escapes *[256]bool, stops string) ([]byte, int) {
+	var out []byte
+	for i := start; i < len(b); i++ {
+		c := b[i]
+		if c == '\\' && i+1 < len(b) && escapes[b[i+1]] {
+			i++
+			out = append(out, b[i])
+			continue
+		}
+		if strings.IndexByte(stops, c) >= 0 {
+			return out, i
+		}
+		out = append(out, c)
+	}
+	return out, len(b)
+}
+
 // InfluxSerializer writes a Point in a serialized form for MongoDB
-type InfluxSerializer struct{}
+type InfluxSerializer struct {
+	// TimestampUnit is the unit timestamps are written in, nanoseconds if 0
+	TimestampUnit time.Duration
+	// FloatPrecision is the number of decimals floats are written with, as
+	// many as needed to be exact if 0
+	FloatPrecision int
+
+	// buf is reused by every call to Serialize to avoid allocating per Point
+	buf []byte
+}
 
 // Serialize writes Point data to the given writer, conforming to the
 // InfluxDB wire protocol.
@@ -15,15 +80,20 @@ type InfluxSerializer struct{}
 //
 // For example:
 // foo,tag0=bar baz=-1.0 100\n
+//
+// Commas and spaces are escaped with a backslash in the measurement name, tag
+// keys, tag values and field keys, and so are equals signs in all but the
+// measurement name. String field values are quoted, escaping quotes and
+// backslashes.
 func (s *InfluxSerializer) Serialize(p *Point, w io.Writer) (err error) {
-	buf := make([]byte, 0, 1024)
-	buf = append(buf, p.measurementName...)
+	buf := s.buf[:0]
+	buf = appendInfluxEscaped(buf, p.measurementName, influxMeasurementEscapes)
 
 	for i := 0; i < len(p.tagKeys); i++ {
 		buf = append(buf, ',')
-		buf = append(buf, p.tagKeys[i]...)
+		buf = appendInfluxEscaped(buf, p.tagKeys[i], influxKeyEscapes)
 		buf = append(buf, '=')
-		buf = append(buf, p.tagValues[i]...)
+		buf = appendInfluxEscaped(buf, p.tagValues[i], influxKeyEscapes)
 	}
 
 	if len(p.fieldKeys) > 0 {
@@ -31,11 +101,25 @@ func (s *InfluxSerializer) Serialize(p *Point, w io.Writer) (err error) {
 	}
 
 	for i := 0; i < len(p.fieldKeys); i++ {
-		buf = append(buf, p.fieldKeys[i]...)
+		buf = appendInfluxEscaped(buf, p.fieldKeys[i], influxKeyEscapes)
 		buf = append(buf, '=')
 
 		v := p.fieldValues[i]
-		buf = fastFormatAppend(v, buf)
+		switch str := v.(type) {
+		case []byte:
+			buf = append(buf, '"')
+			buf = appendInfluxEscaped(buf, str, influxStringEscapes)
+			buf = append(buf, '"')
+		case string:
+			buf = append(buf, '"')
+			buf = appendInfluxEscaped(buf, []byte(str), influxStringEscapes)
+			buf = append(buf, '"')
+		default:
+			buf, err = appendField(buf, v, s.FloatPrecision)
+			if err != nil {
+				return err
+			}
+		}
 
 		// Influx uses 'i' to indicate integers:
 		switch v.(type) {
@@ -49,9 +133,95 @@ func (s *InfluxSerializer) Serialize(p *Point, w io.Writer) (err error) {
 	}
 
 	buf = append(buf, ' ')
-	buf = fastFormatAppend(p.timestamp.UTC().UnixNano(), buf)
+	buf = strconv.AppendInt(buf, timestampIn(p.timestamp, s.TimestampUnit), 10)
 	buf = append(buf, '\n')
 	_, err = w.Write(buf)
+	s.buf = buf
 
 	return err
 }
+
+// InfluxDeserializer reads Points written by InfluxSerializer
+type InfluxDeserializer struct {
+	// TimestampUnit is the unit timestamps were written in, nanoseconds if 0
+	TimestampUnit time.Duration
+}
+
+// Deserialize reads the next line of r into p, unescaping names and
+// quoted strings. Field values ending in 'i' are read as int64s, quoted
+// ones as []byte, true and false as bools and all others as float64s.
+func (d *InfluxDeserializer) Deserialize(r *bufio.Reader, p *Point) error {
+	line, err := readLine(r)
+	if err != nil {
+		return err
+	}
+	p.Reset()
+
+	// The timestamp follows the last space, as none are left unescaped
+	// anywhere else but in quoted strings
+	last := bytes.LastIndexByte(line, ' ')
+	if last < 0 {
+		return fmt.Errorf("invalid influx line '%s': no timestamp", line)
+	}
+	ts, err := strconv.ParseInt(string(line[last+1:]), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid influx line '%s': %v", line, err)
+	}
+	t := timeIn(ts, d.TimestampUnit)
+	p.SetTimestamp(&t)
+
+	name, i := scanInfluxEscaped(line, 0, influxMeasurementEscapes, ", ")
+	p.SetMeasurementName(name)
+	for i < last && line[i] == ',' {
+		var key, value []byte
+		key, i = scanInfluxEscaped(line, i+1, influxKeyEscapes, ", =")
+		if i >= last || line[i] != '=' {
+			return fmt.Errorf("invalid influx line '%s': tag '%s' has no value", line, key)
+		}
+		value, i = scanInfluxEscaped(line, i+1, influxKeyEscapes, ", ")
+		p.AppendTag(key, value)
+	}
+
+	// Points without fields have nothing between the tags and the timestamp
+	for i < last {
+		var key []byte
+		key, i = scanInfluxEscaped(line, i+1, influxKeyEscapes, ", =")
+		if i >= last || line[i] != '=' {
+			return fmt.Errorf("invalid influx line '%s': field '%s' has no value", line, key)
+		}
+		i++
+		var value interface{}
+		if line[i] == '"' {
+			var str []byte
+			str, i = scanInfluxEscaped(line, i+1, influxStringEscapes, "\"")
+			if i >= last {
+				return fmt.Errorf("invalid influx line '%s': field '%s' is not terminated", line, key)
+			}
+			value = str
+			i++
+		} else {
+			end := i
+			for end < last && line[end] != ',' {
+				end++
+			}
+			value, err = parseInfluxValue(line[i:end])
+			if err != nil {
+				return fmt.Errorf("invalid influx line '%s': field '%s': %v", line, key, err)
+			}
+			i = end
+		}
+		p.AppendField(key, value)
+	}
+	return nil
+}
+
+func parseInfluxValue(b []byte) (interface{}, error) {
+	s := string(b)
+	switch {
+	case strings.HasSuffix(s, "i"):
+		return strconv.ParseInt(s[:len(s)-1], 10, 64)
+	case s == "true", s == "false":
+		return s == "true", nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
@@ -1,7 +1,10 @@
 package serialize
 
 import (
+	"bufio"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestInfluxSerializerSerialize(t *testing.T) {
@@ -26,7 +29,113 @@ func TestInfluxSerializerSerialize(t *testing.T) {
 			inputPoint: testPointNoTags,
 			output:     "cpu usage_guest_nice=38.24311829 1451606400000000000\n",
 		},
+		{
+			desc:       "a Point with a sub-second timestamp",
+			inputPoint: testPointSubSecond,
+			output:     "cpu,hostname=host_0,region=eu-west-1,datacenter=eu-west-1b usage_guest_nice=38.24311829 1451606400123456789\n",
+		},
+		{
+			desc:       "a Point with string and bool fields",
+			inputPoint: testPointTyped,
+			output:     "cpu,hostname=host_0,region=eu-west-1,datacenter=eu-west-1b usage_guest_nice=38.24311829,status=\"up, \\\"ready\\\"\",healthy=true 1451606400000000000\n",
+		},
+	}
+
+	testSerializer(t, cases, &InfluxSerializer{})
+}
+
+func TestInfluxSerializerSerializeEscaping(t *testing.T) {
+	cases := []serializeCase{
+		{
+			desc: "special characters in tags",
+			inputPoint: &Point{
+				measurementName: testMeasurement,
+				tagKeys:         [][]byte{[]byte("host name"), []byte("a=b"), []byte("c,d")},
+				tagValues:       [][]byte{[]byte("web 01"), []byte("x=1,y=2"), []byte(`back\slash "quoted"`)},
+				timestamp:       &testNow,
+				fieldKeys:       [][]byte{testColFloat},
+				fieldValues:     []interface{}{testFloat},
+			},
+			output: `cpu,host\ name=web\ 01,a\=b=x\=1\,y\=2,c\,d=back\slash\ "quoted" usage_guest_nice=38.24311829 1451606400000000000` + "\n",
+		},
+		{
+			desc: "special characters in measurement and field keys",
+			inputPoint: &Point{
+				measurementName: []byte("cpu usage,total=1"),
+				tagKeys:         [][]byte{},
+				tagValues:       [][]byte{},
+				timestamp:       &testNow,
+				fieldKeys:       [][]byte{[]byte("usage user"), []byte("a,b=c")},
+				fieldValues:     []interface{}{testFloat, testInt},
+			},
+			output: `cpu\ usage\,total=1 usage\ user=38.24311829,a\,b\=c=38i 1451606400000000000` + "\n",
+		},
+		{
+			desc: "string field values",
+			inputPoint: &Point{
+				measurementName: testMeasurement,
+				tagKeys:         testTagKeys[:1],
+				tagValues:       testTagVals[:1],
+				timestamp:       &testNow,
+				fieldKeys:       [][]byte{[]byte("msg"), []byte("raw")},
+				fieldValues:     []interface{}{`say "hi", C:\ = ok`, []byte(`a\"b`)},
+			},
+			output: `cpu,hostname=host_0 msg="say \"hi\", C:\\ = ok",raw="a\\\"b" 1451606400000000000` + "\n",
+		},
+		{
+			desc: "only special characters",
+			inputPoint: &Point{
+				measurementName: testMeasurement,
+				tagKeys:         [][]byte{[]byte("t")},
+				tagValues:       [][]byte{[]byte(" ,= ")},
+				timestamp:       &testNow,
+				fieldKeys:       [][]byte{[]byte("s")},
+				fieldValues:     []interface{}{`""`},
+			},
+			output: `cpu,t=\ \,\=\  s="\"\"" 1451606400000000000` + "\n",
+		},
 	}
 
 	testSerializer(t, cases, &InfluxSerializer{})
 }
+
+func BenchmarkInfluxSerializerSerialize(b *testing.B) {
+	benchmarkSerializer(b, &InfluxSerializer{})
+}
+
+func TestInfluxDeserializerDeserialize(t *testing.T) {
+	special := &Point{
+		measurementName: []byte("cpu usage,total"),
+		tagKeys:         [][]byte{[]byte("t"), []byte("a=b")},
+		tagValues:       [][]byte{[]byte(" ,= "), []byte(`C:\dir`)},
+		timestamp:       &testNowSubSec,
+		fieldKeys:       [][]byte{[]byte("msg"), []byte("a,b=c"), []byte("ok"), []byte("n")},
+		fieldValues:     []interface{}{[]byte(`say "hi", C:\ = ok`), testInt, true, -1.5},
+	}
+	noFields := &Point{
+		measurementName: testMeasurement,
+		tagKeys:         testTagKeys,
+		tagValues:       testTagVals,
+		timestamp:       &testNow,
+	}
+	points := []*Point{testPointMultiField, testPointNoTags, special, noFields}
+	testRoundTrip(t, "influx", points, nil, &InfluxSerializer{}, &InfluxDeserializer{}, time.Nanosecond)
+}
+
+func TestInfluxDeserializerDeserializeErr(t *testing.T) {
+	cases := []string{
+		"cpu,hostname=host_0 usage=1.0",
+		"cpu,hostname=host_0 usage=1.0 now",
+		"cpu,hostname usage=1.0 1451606400000000000",
+		"cpu,hostname=host_0 usage 1451606400000000000",
+		"cpu,hostname=host_0 usage=x1 1451606400000000000",
+		"cpu,hostname=host_0 usage=1.5i 1451606400000000000",
+		`cpu,hostname=host_0 msg="unterminated 1451606400000000000`,
+	}
+	for _, c := range cases {
+		d := &InfluxDeserializer{}
+		if err := d.Deserialize(bufio.NewReader(strings.NewReader(c+"\n")), NewPoint()); err == nil {
+			t.Errorf("no error returned for '%s'", c)
+		}
+	}
+}
@@ -1,14 +1,26 @@
 package serialize
 
 import (
+	"bufio"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"sync"
+	"time"
 
+	"github.com/globalsign/mgo/bson"
 	flatbuffers "github.com/google/flatbuffers/go"
 )
 
+// Styles of documents MongoSerializer can write
+const (
+	// MongoDocStyleFlatbuffer is a flatbuffer MongoPoint prefixed by its
+	// length as 8 bytes, which only tsbs_load_mongo reads
+	MongoDocStyleFlatbuffer = "flatbuffer"
+	// MongoDocStyleRaw is a plain BSON document, as read by mongorestore
+	MongoDocStyleRaw = "raw"
+)
+
 var fbBuilderPool = &sync.Pool{
 	New: func() interface{} {
 		return flatbuffers.NewBuilder(0)
@@ -16,13 +28,35 @@ var fbBuilderPool = &sync.Pool{
 }
 
 // MongoSerializer writes a Point in a serialized form for MongoDB
-type MongoSerializer struct{}
+type MongoSerializer struct {
+	// TimestampUnit is the unit timestamps are written in, nanoseconds if 0
+	TimestampUnit time.Duration
+	// DocStyle is the style of document written, MongoDocStyleFlatbuffer if
+	// empty
+	DocStyle string
+}
 
-// Serialize writes Point data to the given Writer, using basic gob encoding
+// mongoRawPoint is a Point as written in MongoDocStyleRaw
+type mongoRawPoint struct {
+	Measurement string    `bson:"measurement"`
+	Timestamp   time.Time `bson:"timestamp"`
+	// TimestampNS is the timestamp in nanoseconds, truncated to the
+	// TimestampUnit of the serializer, as the BSON datetime of Timestamp only
+	// has millisecond precision
+	TimestampNS int64  `bson:"timestamp_ns"`
+	Tags        bson.D `bson:"tags"`
+	Fields      bson.D `bson:"fields"`
+}
+
+// Serialize writes Point data to the given Writer, in the style of document
+// set by DocStyle
 func (s *MongoSerializer) Serialize(p *Point, w io.Writer) (err error) {
+	if s.DocStyle == MongoDocStyleRaw {
+		return s.serializeRaw(p, w)
+	}
 	b := fbBuilderPool.Get().(*flatbuffers.Builder)
 
-	timestampNanos := p.timestamp.UTC().UnixNano()
+	timestamp := timestampIn(p.timestamp, s.TimestampUnit)
 
 	fieldsMap := make(map[string]interface{})
 	for i, val := range p.fieldKeys {
@@ -68,7 +102,9 @@ func (s *MongoSerializer) Serialize(p *Point, w io.Writer) (err error) {
 		case int64:
 			MongoReadingAddValue(b, float64(val))
 		default:
-			panic(fmt.Sprintf("cannot covert %T to float64", val))
+			b.Reset()
+			fbBuilderPool.Put(b)
+			return fmt.Errorf("cannot serialize field '%s' of type %T in a %s document; use %s documents", k, val, MongoDocStyleFlatbuffer, MongoDocStyleRaw)
 		}
 		fields = append(fields, MongoReadingEnd(b))
 	}
@@ -81,7 +117,7 @@ func (s *MongoSerializer) Serialize(p *Point, w io.Writer) (err error) {
 	measurement := b.CreateString(string(p.measurementName))
 	MongoPointStart(b)
 	MongoPointAddMeasurementName(b, measurement)
-	MongoPointAddTimestamp(b, timestampNanos)
+	MongoPointAddTimestamp(b, timestamp)
 	MongoPointAddTags(b, tagsArr)
 	MongoPointAddFields(b, fieldsArr)
 	point := MongoPointEnd(b)
@@ -108,3 +144,131 @@ func (s *MongoSerializer) Serialize(p *Point, w io.Writer) (err error) {
 
 	return nil
 }
+
+// serializeRaw writes p as a BSON document with the measurement name, the
+// timestamp both as a BSON datetime and as an integer, and subdocuments of
+// the tags and of the fields, in the order of p. BSON documents start with
+// their length, so a stream of them can be read back one at a time.
+func (s *MongoSerializer) serializeRaw(p *Point, w io.Writer) error {
+	doc := mongoRawPoint{
+		Measurement: string(p.measurementName),
+		Timestamp:   p.timestamp.UTC(),
+		TimestampNS: timeIn(timestampIn(p.timestamp, s.TimestampUnit), s.TimestampUnit).UnixNano(),
+		Tags:        make(bson.D, len(p.tagKeys)),
+		Fields:      make(bson.D, len(p.fieldKeys)),
+	}
+	for i, key := range p.tagKeys {
+		doc.Tags[i] = bson.DocElem{Name: string(key), Value: string(p.tagValues[i])}
+	}
+	for i, key := range p.fieldKeys {
+		v := p.fieldValues[i]
+		switch x := v.(type) {
+		case int:
+			// Keep a fixed width, as bson would write small ints as int32
+			v = int64(x)
+		case []byte:
+			// bson would write a byte slice as binary data
+			v = string(x)
+		}
+		doc.Fields[i] = bson.DocElem{Name: string(key), Value: v}
+	}
+
+	buf, err := bson.Marshal(&doc)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(buf)
+	return err
+}
+
+// MongoDeserializer reads Points written by MongoSerializer
+type MongoDeserializer struct {
+	// TimestampUnit is the unit timestamps were written in, nanoseconds if 0,
+	// MongoDocStyleRaw documents having theirs in nanoseconds whatever it is
+	TimestampUnit time.Duration
+	// DocStyle is the style of the documents read, MongoDocStyleFlatbuffer
+	// if empty
+	DocStyle string
+}
+
+// Deserialize reads the next document of r into p. Field values of
+// flatbuffers are all float64s, while those of raw documents keep their
+// BSON type, with strings read as []byte like in other formats.
+func (d *MongoDeserializer) Deserialize(r *bufio.Reader, p *Point) error {
+	if d.DocStyle == MongoDocStyleRaw {
+		return d.deserializeRaw(r, p)
+	}
+	var lenBuf [8]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return err
+	}
+	buf := make([]byte, binary.LittleEndian.Uint64(lenBuf[:]))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	p.Reset()
+
+	mp := GetRootAsMongoPoint(buf, 0)
+	p.SetMeasurementName(mp.MeasurementName())
+	t := timeIn(mp.Timestamp(), d.TimestampUnit)
+	p.SetTimestamp(&t)
+	tag := &MongoTag{}
+	for i := 0; i < mp.TagsLength(); i++ {
+		mp.Tags(tag, i)
+		p.AppendTag(tag.Key(), tag.Value())
+	}
+	reading := &MongoReading{}
+	for i := 0; i < mp.FieldsLength(); i++ {
+		mp.Fields(reading, i)
+		p.AppendField(reading.Key(), reading.Value())
+	}
+	return nil
+}
+
+// deserializeRaw reads the next BSON document of r, which starts with its
+// length, into p
+func (d *MongoDeserializer) deserializeRaw(r *bufio.Reader, p *Point) error {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return err
+	}
+	l := binary.LittleEndian.Uint32(lenBuf[:])
+	if l < uint32(len(lenBuf)) {
+		return fmt.Errorf("invalid BSON document length %d", l)
+	}
+	buf := make([]byte, l)
+	copy(buf, lenBuf[:])
+	if _, err := io.ReadFull(r, buf[len(lenBuf):]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	var doc mongoRawPoint
+	if err := bson.Unmarshal(buf, &doc); err != nil {
+		return err
+	}
+	p.Reset()
+
+	p.SetMeasurementName([]byte(doc.Measurement))
+	t := time.Unix(0, doc.TimestampNS).UTC()
+	p.SetTimestamp(&t)
+	for _, tag := range doc.Tags {
+		value, ok := tag.Value.(string)
+		if !ok {
+			return fmt.Errorf("invalid BSON document: tag '%s' is a %T", tag.Name, tag.Value)
+		}
+		p.AppendTag([]byte(tag.Name), []byte(value))
+	}
+	for _, field := range doc.Fields {
+		if str, ok := field.Value.(string); ok {
+			p.AppendFieldString([]byte(field.Name), []byte(str))
+			continue
+		}
+		p.AppendField([]byte(field.Name), field.Value)
+	}
+	return nil
+}
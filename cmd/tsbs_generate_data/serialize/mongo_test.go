@@ -8,7 +8,9 @@ import (
 	"io"
 	"log"
 	"testing"
+	"time"
 
+	"github.com/globalsign/mgo/bson"
 	flatbuffers "github.com/google/flatbuffers/go"
 )
 
@@ -74,6 +76,18 @@ func TestMongoSerializerSerialize(t *testing.T) {
 				readingVals: testPointNoTags.fieldValues,
 			},
 		},
+		{
+			desc:       "a Point with a sub-second timestamp",
+			inputPoint: testPointSubSecond,
+			want: output{
+				name:        string(testMeasurement),
+				ts:          testNowSubSec.UnixNano(),
+				tagKeys:     testTagKeys,
+				tagVals:     testTagVals,
+				readingKeys: testPointSubSecond.fieldKeys,
+				readingVals: testPointSubSecond.fieldValues,
+			},
+		},
 	}
 
 	ps := &MongoSerializer{}
@@ -142,6 +156,137 @@ func TestMongoSerializerSerialize(t *testing.T) {
 	}
 }
 
+func TestMongoSerializerSerializeRaw(t *testing.T) {
+	bigInt := &Point{
+		measurementName: testMeasurement,
+		tagKeys:         testTagKeys,
+		tagValues:       testTagVals,
+		timestamp:       &testNowSubSec,
+		fieldKeys:       [][]byte{[]byte("small"), []byte("big"), []byte("float")},
+		fieldValues:     []interface{}{int(3), int64(5000000000), 38.24311829},
+	}
+	cases := []struct {
+		desc       string
+		inputPoint *Point
+		unit       time.Duration
+		wantTS     int64
+	}{
+		{
+			desc:       "a regular Point",
+			inputPoint: testPointDefault,
+			wantTS:     testNow.UnixNano(),
+		},
+		{
+			desc:       "a regular Point with multiple fields",
+			inputPoint: testPointMultiField,
+			wantTS:     testNow.UnixNano(),
+		},
+		{
+			desc:       "a Point with no tags",
+			inputPoint: testPointNoTags,
+			wantTS:     testNow.UnixNano(),
+		},
+		{
+			desc:       "a Point with a sub-second timestamp",
+			inputPoint: testPointSubSecond,
+			wantTS:     testNowSubSec.UnixNano(),
+		},
+		{
+			desc:       "a Point with a sub-second timestamp in microseconds",
+			inputPoint: testPointSubSecond,
+			unit:       time.Microsecond,
+			wantTS:     testNowSubSec.UnixNano() / 1e3 * 1e3,
+		},
+		{
+			desc:       "a Point with a sub-second timestamp in seconds",
+			inputPoint: testPointSubSecond,
+			unit:       time.Second,
+			wantTS:     testNowSubSec.Unix() * 1e9,
+		},
+		{
+			desc:       "a Point with int and large int64 values",
+			inputPoint: bigInt,
+			wantTS:     testNowSubSec.UnixNano(),
+		},
+		{
+			desc:       "a Point with string and bool values",
+			inputPoint: testPointTyped,
+			wantTS:     testNow.UnixNano(),
+		},
+	}
+
+	for _, c := range cases {
+		s := &MongoSerializer{TimestampUnit: c.unit, DocStyle: MongoDocStyleRaw}
+		b := new(bytes.Buffer)
+		if err := s.Serialize(c.inputPoint, b); err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.desc, err)
+		}
+		if got := int(binary.LittleEndian.Uint32(b.Bytes())); got != b.Len() {
+			t.Errorf("%s: incorrect document length: got %d want %d", c.desc, got, b.Len())
+		}
+
+		doc := &mongoRawPoint{}
+		if err := bson.Unmarshal(b.Bytes(), doc); err != nil {
+			t.Fatalf("%s: cannot unmarshal document: %v", c.desc, err)
+		}
+		p := c.inputPoint
+		if got := doc.Measurement; got != string(p.measurementName) {
+			t.Errorf("%s: incorrect measurement name: got %s want %s", c.desc, got, p.measurementName)
+		}
+		// BSON datetimes only keep milliseconds
+		wantTime := p.timestamp.Truncate(time.Millisecond)
+		if got := doc.Timestamp; !got.Equal(wantTime) {
+			t.Errorf("%s: incorrect timestamp: got %v want %v", c.desc, got, wantTime)
+		}
+		if got := doc.TimestampNS; got != c.wantTS {
+			t.Errorf("%s: incorrect integer timestamp: got %d want %d", c.desc, got, c.wantTS)
+		}
+
+		if got := len(doc.Tags); got != len(p.tagKeys) {
+			t.Errorf("%s: incorrect tags length: got %d want %d", c.desc, got, len(p.tagKeys))
+		} else {
+			for i, tag := range doc.Tags {
+				if got, want := tag.Name, string(p.tagKeys[i]); got != want {
+					t.Errorf("%s: incorrect tag key %d: got %s want %s", c.desc, i, got, want)
+				}
+				if got, want := tag.Value, string(p.tagValues[i]); got != want {
+					t.Errorf("%s: incorrect tag val %d: got %v want %s", c.desc, i, got, want)
+				}
+			}
+		}
+
+		if got := len(doc.Fields); got != len(p.fieldKeys) {
+			t.Errorf("%s: incorrect fields length: got %d want %d", c.desc, got, len(p.fieldKeys))
+			continue
+		}
+		for i, field := range doc.Fields {
+			if got, want := field.Name, string(p.fieldKeys[i]); got != want {
+				t.Errorf("%s: incorrect field key %d: got %s want %s", c.desc, i, got, want)
+			}
+			want := p.fieldValues[i]
+			switch x := want.(type) {
+			case int:
+				want = int64(x)
+			case []byte:
+				want = string(x)
+			}
+			if got := field.Value; got != want {
+				t.Errorf("%s: incorrect field val %d: got %v (%T) want %v (%T)", c.desc, i, got, got, want, want)
+			}
+		}
+	}
+}
+
+func TestMongoSerializerSerializeRawErr(t *testing.T) {
+	s := &MongoSerializer{DocStyle: MongoDocStyleRaw}
+	err := s.Serialize(testPointMultiField, &errWriter{})
+	if err == nil {
+		t.Errorf("no error returned when expected")
+	} else if err.Error() != errWriterAlwaysErr {
+		t.Errorf("unexpected writer error: %v", err)
+	}
+}
+
 func deserializeMongo(r *bufio.Reader) *MongoPoint {
 	item := &MongoPoint{}
 	lenBuf := make([]byte, 8)
@@ -177,24 +322,21 @@ func deserializeMongo(r *bufio.Reader) *MongoPoint {
 	return item
 }
 
-func TestMongoSerializerTypePanic(t *testing.T) {
-	testPanic := func() {
-		defer func() {
-			if r := recover(); r == nil {
-				t.Errorf("did not panic when should")
-			}
-		}()
-		p := &Point{
-			measurementName: testMeasurement,
-			timestamp:       &testNow,
-		}
-		p.AppendField([]byte("broken"), "a string?")
-		ps := &MongoSerializer{}
-		b := new(bytes.Buffer)
+func TestMongoSerializerTypeErr(t *testing.T) {
+	p := &Point{
+		measurementName: testMeasurement,
+		timestamp:       &testNow,
+	}
+	p.AppendField([]byte("broken"), "a string?")
+	ps := &MongoSerializer{}
+	b := new(bytes.Buffer)
 
-		ps.Serialize(p, b)
+	if err := ps.Serialize(p, b); err == nil {
+		t.Errorf("no error returned for a string field in a flatbuffer document")
+	}
+	if b.Len() > 0 {
+		t.Errorf("output written despite the error: %d bytes", b.Len())
 	}
-	testPanic()
 }
 
 func TestMongoSerializerSerializeErr(t *testing.T) {
@@ -215,3 +357,26 @@ func TestMongoSerializerSerializeErr(t *testing.T) {
 		t.Errorf("unexpected writer error: %v", err)
 	}
 }
+
+func TestMongoDeserializerDeserializeErr(t *testing.T) {
+	for _, style := range []string{MongoDocStyleFlatbuffer, MongoDocStyleRaw} {
+		s := &MongoSerializer{DocStyle: style}
+		b := new(bytes.Buffer)
+		if err := s.Serialize(testPointMultiField, b); err != nil {
+			t.Fatal(err)
+		}
+		d := &MongoDeserializer{DocStyle: style}
+		if err := d.Deserialize(bufio.NewReader(new(bytes.Buffer)), NewPoint()); err != io.EOF {
+			t.Errorf("%s: incorrect error for no data: got %v want %v", style, err, io.EOF)
+		}
+		truncated := bytes.NewReader(b.Bytes()[:b.Len()-1])
+		if err := d.Deserialize(bufio.NewReader(truncated), NewPoint()); err != io.ErrUnexpectedEOF {
+			t.Errorf("%s: incorrect error for a truncated document: got %v want %v", style, err, io.ErrUnexpectedEOF)
+		}
+	}
+
+	d := &MongoDeserializer{DocStyle: MongoDocStyleRaw}
+	if err := d.Deserialize(bufio.NewReader(bytes.NewReader([]byte{2, 0, 0, 0})), NewPoint()); err == nil {
+		t.Errorf("no error returned for an invalid document length")
+	}
+}
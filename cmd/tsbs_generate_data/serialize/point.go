@@ -2,6 +2,7 @@ package serialize
 
 // Point wraps a single data point. It stores database-agnostic data
 import (
+	"bufio"
 	"bytes"
 	"io"
 	"time"
@@ -48,6 +49,26 @@ func (p *Point) SetTimestamp(t *time.Time) {
 	p.timestamp = t
 }
 
+// Timestamp returns the Point's timestamp
+func (p *Point) Timestamp() *time.Time {
+	return p.timestamp
+}
+
+// Copy overwrites this Point with the contents of from. The timestamp value is
+// duplicated so that from can be safely reused afterwards.
+func (p *Point) Copy(from *Point) {
+	p.measurementName = from.measurementName
+	p.tagKeys = append(p.tagKeys[:0], from.tagKeys...)
+	p.tagValues = append(p.tagValues[:0], from.tagValues...)
+	p.fieldKeys = append(p.fieldKeys[:0], from.fieldKeys...)
+	p.fieldValues = append(p.fieldValues[:0], from.fieldValues...)
+	p.timestamp = nil
+	if from.timestamp != nil {
+		ts := *from.timestamp
+		p.timestamp = &ts
+	}
+}
+
 // SetMeasurementName sets the name of the measurement for this data point
 func (p *Point) SetMeasurementName(s []byte) {
 	p.measurementName = s
@@ -69,6 +90,30 @@ func (p *Point) AppendField(key []byte, value interface{}) {
 	p.fieldValues = append(p.fieldValues, value)
 }
 
+// AppendFieldFloat64 adds a float field with a given key and value to this
+// data point
+func (p *Point) AppendFieldFloat64(key []byte, value float64) {
+	p.AppendField(key, value)
+}
+
+// AppendFieldInt64 adds an integer field with a given key and value to this
+// data point
+func (p *Point) AppendFieldInt64(key []byte, value int64) {
+	p.AppendField(key, value)
+}
+
+// AppendFieldString adds a string field with a given key and value to this
+// data point
+func (p *Point) AppendFieldString(key, value []byte) {
+	p.AppendField(key, value)
+}
+
+// AppendFieldBool adds a boolean field with a given key and value to this
+// data point
+func (p *Point) AppendFieldBool(key []byte, value bool) {
+	p.AppendField(key, value)
+}
+
 // GetFieldValue returns the corresponding value for a given field key or nil if it does not exist.
 // This will panic if the internal state has been altered to not have the same number of field keys as field values.
 func (p *Point) GetFieldValue(key []byte) interface{} {
@@ -107,3 +152,12 @@ func (p *Point) GetTagValue(key []byte) []byte {
 type PointSerializer interface {
 	Serialize(p *Point, w io.Writer) error
 }
+
+// PointDeserializer reads back Points written by a PointSerializer, for
+// tools that validate generated data
+type PointDeserializer interface {
+	// Deserialize overwrites p with the next Point read from r, returning
+	// io.EOF once there are none left. A PointDeserializer may read ahead,
+	// so it must be used with a single Reader.
+	Deserialize(r *bufio.Reader, p *Point) error
+}
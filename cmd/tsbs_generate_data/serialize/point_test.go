@@ -3,18 +3,22 @@ package serialize
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"testing"
 	"time"
 )
 
 var (
 	testNow         = time.Unix(1451606400, 0)
+	testNowSubSec   = time.Unix(1451606400, 123456789)
 	testMeasurement = []byte("cpu")
 	testTagKeys     = [][]byte{[]byte("hostname"), []byte("region"), []byte("datacenter")}
 	testTagVals     = [][]byte{[]byte("host_0"), []byte("eu-west-1"), []byte("eu-west-1b")}
 	testColFloat    = []byte("usage_guest_nice")
 	testColInt      = []byte("usage_guest")
 	testColInt64    = []byte("big_usage_guest")
+	testColString   = []byte("status")
+	testColBool     = []byte("healthy")
 )
 
 const (
@@ -77,6 +81,24 @@ var testPointNoTags = &Point{
 	fieldValues:     []interface{}{testFloat},
 }
 
+var testPointSubSecond = &Point{
+	measurementName: testMeasurement,
+	tagKeys:         testTagKeys,
+	tagValues:       testTagVals,
+	timestamp:       &testNowSubSec,
+	fieldKeys:       [][]byte{testColFloat},
+	fieldValues:     []interface{}{testFloat},
+}
+
+var testPointTyped = &Point{
+	measurementName: testMeasurement,
+	tagKeys:         testTagKeys,
+	tagValues:       testTagVals,
+	timestamp:       &testNow,
+	fieldKeys:       [][]byte{testColFloat, testColString, testColBool},
+	fieldValues:     []interface{}{testFloat, []byte("up, \"ready\""), true},
+}
+
 type serializeCase struct {
 	desc       string
 	inputPoint *Point
@@ -94,6 +116,67 @@ func testSerializer(t *testing.T, cases []serializeCase, ps PointSerializer) {
 	}
 }
 
+// benchmarkSerializer measures serializing a Point with several fields, as
+// the generator does for every Point it writes
+func benchmarkSerializer(b *testing.B, ps PointSerializer) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := ps.Serialize(testPointMultiField, ioutil.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestSerializersReuseBuffers(t *testing.T) {
+	serializers := map[string]PointSerializer{
+		"influx":             &InfluxSerializer{},
+		"timescaledb":        &TimescaleDBSerializer{},
+		"timescaledb-binary": &TimescaleDBBinarySerializer{},
+		"cassandra":          &CassandraSerializer{},
+		"cratedb":            &CrateDBSerializer{},
+	}
+	for name, ps := range serializers {
+		allocs := testing.AllocsPerRun(100, func() {
+			ps.Serialize(testPointMultiField, ioutil.Discard)
+		})
+		if allocs > 0 {
+			t.Errorf("%s: serializer allocates %v times per Point, want 0", name, allocs)
+		}
+	}
+}
+
+func TestSerializersChangedTagValues(t *testing.T) {
+	serializers := map[string]PointSerializer{
+		"influx":             &InfluxSerializer{},
+		"timescaledb":        &TimescaleDBSerializer{},
+		"timescaledb-binary": &TimescaleDBBinarySerializer{},
+		"cassandra":          &CassandraSerializer{},
+		"cratedb":            &CrateDBSerializer{},
+	}
+	for name, ps := range serializers {
+		p := NewPoint()
+		for i, team := range []string{"team_before", "team_after"} {
+			p.Reset()
+			p.SetMeasurementName(testMeasurement)
+			p.SetTimestamp(&testNow)
+			p.AppendTag([]byte("hostname"), []byte("host_0"))
+			p.AppendTag([]byte("team"), []byte(team))
+			p.AppendField([]byte("usage_user"), float64(i))
+
+			b := new(bytes.Buffer)
+			if err := ps.Serialize(p, b); err != nil {
+				t.Fatalf("%s: unexpected error: %v", name, err)
+			}
+			if !bytes.Contains(b.Bytes(), []byte(team)) {
+				t.Errorf("%s: tag value %s missing from output: %q", name, team, b.Bytes())
+			}
+			if i > 0 && bytes.Contains(b.Bytes(), []byte("team_before")) {
+				t.Errorf("%s: earlier tag value in output: %q", name, b.Bytes())
+			}
+		}
+	}
+}
+
 func testEmptyPoint(t *testing.T, p *Point, desc string) {
 	if p.measurementName != nil {
 		t.Errorf("%s has a non-nil measurement name: %s", desc, p.measurementName)
@@ -138,6 +221,28 @@ func TestSetTimestamp(t *testing.T) {
 	}
 }
 
+func TestCopy(t *testing.T) {
+	p := NewPoint()
+	p.Copy(testPointMultiField)
+	if got := string(p.MeasurementName()); got != string(testMeasurement) {
+		t.Errorf("incorrect name: got %s want %s", got, testMeasurement)
+	}
+	if got := len(p.tagKeys); got != len(testTagKeys) {
+		t.Errorf("incorrect tag len: got %d want %d", got, len(testTagKeys))
+	}
+	if got := p.GetFieldValue(testColInt64); got != testInt64 {
+		t.Errorf("incorrect field value: got %v want %v", got, testInt64)
+	}
+	if p.Timestamp() == testPointMultiField.timestamp {
+		t.Errorf("timestamp not duplicated")
+	} else if !p.Timestamp().Equal(testNow) {
+		t.Errorf("incorrect timestamp: got %v want %v", p.Timestamp(), testNow)
+	}
+
+	p.Copy(NewPoint())
+	testEmptyPoint(t, p, "Copy of empty point")
+}
+
 func TestSetMeasurementName(t *testing.T) {
 	p := NewPoint()
 	name := []byte("foo")
@@ -177,6 +282,27 @@ func TestFields(t *testing.T) {
 	}
 }
 
+func TestTypedFields(t *testing.T) {
+	p := NewPoint()
+	p.AppendFieldFloat64([]byte("f"), 1.5)
+	p.AppendFieldInt64([]byte("i"), 2)
+	p.AppendFieldString([]byte("s"), []byte("three"))
+	p.AppendFieldBool([]byte("b"), true)
+
+	if got, ok := p.GetFieldValue([]byte("f")).(float64); !ok || got != 1.5 {
+		t.Errorf("incorrect float field: got %#v", p.GetFieldValue([]byte("f")))
+	}
+	if got, ok := p.GetFieldValue([]byte("i")).(int64); !ok || got != 2 {
+		t.Errorf("incorrect int field: got %#v", p.GetFieldValue([]byte("i")))
+	}
+	if got, ok := p.GetFieldValue([]byte("s")).([]byte); !ok || string(got) != "three" {
+		t.Errorf("incorrect string field: got %#v", p.GetFieldValue([]byte("s")))
+	}
+	if got, ok := p.GetFieldValue([]byte("b")).(bool); !ok || !got {
+		t.Errorf("incorrect bool field: got %#v", p.GetFieldValue([]byte("b")))
+	}
+}
+
 func TestFieldsPanic(t *testing.T) {
 	testPanic := func(p *Point) {
 		defer func() {
@@ -0,0 +1,49 @@
+package serialize
+
+import (
+	"fmt"
+	"time"
+)
+
+// Timestamp precisions, i.e., the units serialized timestamps can be written in
+const (
+	PrecisionSeconds      = "s"
+	PrecisionMilliseconds = "ms"
+	PrecisionMicroseconds = "us"
+	PrecisionNanoseconds  = "ns"
+)
+
+var precisionUnits = map[string]time.Duration{
+	PrecisionSeconds:      time.Second,
+	PrecisionMilliseconds: time.Millisecond,
+	PrecisionMicroseconds: time.Microsecond,
+	PrecisionNanoseconds:  time.Nanosecond,
+}
+
+// ParseTimestampPrecision returns the unit of time for a precision name
+// (s, ms, us or ns).
+func ParseTimestampPrecision(precision string) (time.Duration, error) {
+	unit, ok := precisionUnits[precision]
+	if !ok {
+		return 0, fmt.Errorf("invalid timestamp precision '%s': must be one of s, ms, us, ns", precision)
+	}
+	return unit, nil
+}
+
+// timestampIn returns t as a whole number of unit since the Unix epoch,
+// truncating anything finer. A zero unit means nanoseconds.
+func timestampIn(t *time.Time, unit time.Duration) int64 {
+	if unit <= 0 {
+		unit = time.Nanosecond
+	}
+	return t.UTC().UnixNano() / int64(unit)
+}
+
+// timeIn returns the time of ts, a number of unit since the Unix epoch, the
+// inverse of timestampIn. A zero unit means nanoseconds.
+func timeIn(ts int64, unit time.Duration) time.Time {
+	if unit <= 0 {
+		unit = time.Nanosecond
+	}
+	return time.Unix(0, ts*int64(unit)).UTC()
+}
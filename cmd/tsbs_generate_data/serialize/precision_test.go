@@ -0,0 +1,81 @@
+package serialize
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimestampPrecision(t *testing.T) {
+	cases := []struct {
+		precision string
+		want      time.Duration
+		wantErr   bool
+	}{
+		{precision: PrecisionSeconds, want: time.Second},
+		{precision: PrecisionMilliseconds, want: time.Millisecond},
+		{precision: PrecisionMicroseconds, want: time.Microsecond},
+		{precision: PrecisionNanoseconds, want: time.Nanosecond},
+		{precision: "", wantErr: true},
+		{precision: "m", wantErr: true},
+	}
+	for _, c := range cases {
+		got, err := ParseTimestampPrecision(c.precision)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("'%s': unexpected lack of error", c.precision)
+			}
+		} else if err != nil {
+			t.Errorf("'%s': unexpected error: %v", c.precision, err)
+		} else if got != c.want {
+			t.Errorf("'%s': incorrect unit: got %v want %v", c.precision, got, c.want)
+		}
+	}
+}
+
+func TestSerializersTimestampUnit(t *testing.T) {
+	timestamps := []struct {
+		unit time.Duration
+		want string
+	}{
+		{0, "1451606400123456789"},
+		{time.Nanosecond, "1451606400123456789"},
+		{time.Microsecond, "1451606400123456"},
+		{time.Millisecond, "1451606400123"},
+		{time.Second, "1451606400"},
+	}
+	for _, ts := range timestamps {
+		cases := []struct {
+			desc   string
+			ps     PointSerializer
+			output string
+		}{
+			{
+				desc:   "influx",
+				ps:     &InfluxSerializer{TimestampUnit: ts.unit},
+				output: "cpu,hostname=host_0,region=eu-west-1,datacenter=eu-west-1b usage_guest_nice=38.24311829 " + ts.want + "\n",
+			},
+			{
+				desc:   "timescaledb",
+				ps:     &TimescaleDBSerializer{TimestampUnit: ts.unit},
+				output: "tags,hostname=host_0,region=eu-west-1,datacenter=eu-west-1b\ncpu," + ts.want + ",38.24311829\n",
+			},
+			{
+				desc:   "cratedb",
+				ps:     &CrateDBSerializer{TimestampUnit: ts.unit},
+				output: "cpu\t{\"hostname\":\"host_0\",\"region\":\"eu-west-1\",\"datacenter\":\"eu-west-1b\"}\t" + ts.want + "\t38.24311829\n",
+			},
+			{
+				desc:   "cassandra",
+				ps:     &CassandraSerializer{TimestampUnit: ts.unit},
+				output: "series_double,cpu,hostname=host_0,region=eu-west-1,datacenter=eu-west-1b,usage_guest_nice,2016-01-01," + ts.want + ",38.24311829\n",
+			},
+		}
+		for _, c := range cases {
+			testSerializer(t, []serializeCase{{
+				desc:       c.desc + " with unit " + ts.unit.String(),
+				inputPoint: testPointSubSecond,
+				output:     c.output,
+			}}, c.ps)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package serialize
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// columnTypeDefault is the type loaders assume for columns given without one
+const columnTypeDefault = "float64"
+
+// schemaHeaderTags starts the header written by Schema.Header, and the tags of
+// each Point in the TimescaleDB format
+const schemaHeaderTags = "tags"
+
+// Schema describes the tags and measurements of generated data. It carries the
+// same information as the header of the CSV-like formats, but as a JSON
+// document that can be written to a file of its own.
+type Schema struct {
+	Tags         []string            `json:"tags"`
+	Measurements []SchemaMeasurement `json:"measurements"`
+}
+
+// SchemaMeasurement describes the columns of one measurement, in the order
+// its values are serialized.
+type SchemaMeasurement struct {
+	Name    string         `json:"name"`
+	Columns []SchemaColumn `json:"columns"`
+}
+
+// SchemaColumn is a field of a measurement and its intended type, e.g.,
+// float64, int64 or uint64.
+type SchemaColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// ReadSchema reads a Schema written by Schema.Write, checking that it names
+// all of its tags, measurements and columns.
+func ReadSchema(r io.Reader) (*Schema, error) {
+	s := &Schema{}
+	if err := json.NewDecoder(r).Decode(s); err != nil {
+		return nil, fmt.Errorf("cannot parse schema: %v", err)
+	}
+	if len(s.Tags) == 0 {
+		return nil, fmt.Errorf("invalid schema: no tags")
+	}
+	for i, tag := range s.Tags {
+		if len(tag) == 0 {
+			return nil, fmt.Errorf("invalid schema: tag %d has no name", i)
+		}
+	}
+	if len(s.Measurements) == 0 {
+		return nil, fmt.Errorf("invalid schema: no measurements")
+	}
+	for i, m := range s.Measurements {
+		if len(m.Name) == 0 {
+			return nil, fmt.Errorf("invalid schema: measurement %d has no name", i)
+		}
+		if len(m.Columns) == 0 {
+			return nil, fmt.Errorf("invalid schema: measurement '%s' has no columns", m.Name)
+		}
+		for j, c := range m.Columns {
+			if len(c.Name) == 0 {
+				return nil, fmt.Errorf("invalid schema: column %d of measurement '%s' has no name", j, m.Name)
+			}
+		}
+	}
+	return s, nil
+}
+
+// Write writes s as indented JSON.
+func (s *Schema) Write(w io.Writer) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// Header returns the header that describes s in data of the CSV-like formats:
+// a line of tag keys, one line of columns per measurement and a blank line.
+// Columns that are not floats are written as name:type (e.g., accepts:uint64).
+func (s *Schema) Header() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(schemaHeaderTags)
+	for _, tag := range s.Tags {
+		buf.WriteString(",")
+		buf.WriteString(tag)
+	}
+	buf.WriteString("\n")
+	for _, m := range s.Measurements {
+		buf.WriteString(m.Name)
+		for _, c := range m.Columns {
+			buf.WriteString(",")
+			buf.WriteString(c.Name)
+			if len(c.Type) > 0 && c.Type != columnTypeDefault {
+				buf.WriteString(":")
+				buf.WriteString(c.Type)
+			}
+		}
+		buf.WriteString("\n")
+	}
+	buf.WriteString("\n")
+	return buf.Bytes()
+}
+
+// isSchemaHeader reports whether line is the first line of a header written by
+// Schema.Header rather than the tags of a Point, which are key=value pairs.
+func isSchemaHeader(line []byte) bool {
+	fields := strings.Split(string(line), ",")
+	return len(fields) > 1 && fields[0] == schemaHeaderTags && !strings.Contains(fields[1], "=")
+}
+
+// readSchemaHeader reads the rest of the header whose first line is tagLine
+// from r, up to and including its blank line, and returns the Schema it
+// describes.
+func readSchemaHeader(tagLine []byte, r *bufio.Reader) (*Schema, error) {
+	s := &Schema{Tags: strings.Split(string(tagLine), ",")[1:]}
+	for {
+		line, err := readLine(r)
+		if err == io.EOF {
+			return nil, fmt.Errorf("header has no end")
+		} else if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 {
+			return s, nil
+		}
+		parts := strings.Split(string(line), ",")
+		m := SchemaMeasurement{Name: parts[0]}
+		for _, col := range parts[1:] {
+			c := SchemaColumn{Name: col, Type: columnTypeDefault}
+			if i := strings.IndexByte(col, ':'); i >= 0 {
+				c.Name, c.Type = col[:i], col[i+1:]
+			}
+			m.Columns = append(m.Columns, c)
+		}
+		s.Measurements = append(s.Measurements, m)
+	}
+}
+
+// columnsByMeasurement returns the columns of each measurement of s
+func (s *Schema) columnsByMeasurement() map[string][]SchemaColumn {
+	columns := make(map[string][]SchemaColumn)
+	if s == nil {
+		return columns
+	}
+	for _, m := range s.Measurements {
+		columns[m.Name] = m.Columns
+	}
+	return columns
+}
+
+// isIntegerColumnType reports whether columns of type t hold integers
+func isIntegerColumnType(t string) bool {
+	return t == "int64" || t == "uint64"
+}
@@ -0,0 +1,95 @@
+package serialize
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testSchema = &Schema{
+	Tags: []string{"hostname", "region"},
+	Measurements: []SchemaMeasurement{
+		{
+			Name:    "cpu",
+			Columns: []SchemaColumn{{Name: "usage_user", Type: "float64"}, {Name: "usage_system", Type: "float64"}},
+		},
+		{
+			Name:    "nginx",
+			Columns: []SchemaColumn{{Name: "accepts", Type: "uint64"}, {Name: "active", Type: "int64"}},
+		},
+	},
+}
+
+func TestSchemaHeader(t *testing.T) {
+	want := "tags,hostname,region\ncpu,usage_user,usage_system\nnginx,accepts:uint64,active:int64\n\n"
+	if got := string(testSchema.Header()); got != want {
+		t.Errorf("incorrect header: got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSchemaWriteRead(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testSchema.Write(&buf); err != nil {
+		t.Fatalf("unexpected error writing schema: %v", err)
+	}
+	got, err := ReadSchema(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error reading schema: %v", err)
+	}
+	if !reflect.DeepEqual(got, testSchema) {
+		t.Errorf("incorrect schema: got\n%v\nwant\n%v", got, testSchema)
+	}
+}
+
+func TestReadSchemaErrors(t *testing.T) {
+	cases := []struct {
+		desc    string
+		input   string
+		wantErr string
+	}{
+		{
+			desc:    "not json",
+			input:   "tags,hostname\n",
+			wantErr: "cannot parse schema",
+		},
+		{
+			desc:    "no tags",
+			input:   `{"measurements":[{"name":"cpu","columns":[{"name":"usage_user"}]}]}`,
+			wantErr: "invalid schema: no tags",
+		},
+		{
+			desc:    "nameless tag",
+			input:   `{"tags":["hostname",""],"measurements":[{"name":"cpu","columns":[{"name":"usage_user"}]}]}`,
+			wantErr: "invalid schema: tag 1 has no name",
+		},
+		{
+			desc:    "no measurements",
+			input:   `{"tags":["hostname"]}`,
+			wantErr: "invalid schema: no measurements",
+		},
+		{
+			desc:    "nameless measurement",
+			input:   `{"tags":["hostname"],"measurements":[{"columns":[{"name":"usage_user"}]}]}`,
+			wantErr: "invalid schema: measurement 0 has no name",
+		},
+		{
+			desc:    "no columns",
+			input:   `{"tags":["hostname"],"measurements":[{"name":"cpu"}]}`,
+			wantErr: "invalid schema: measurement 'cpu' has no columns",
+		},
+		{
+			desc:    "nameless column",
+			input:   `{"tags":["hostname"],"measurements":[{"name":"cpu","columns":[{"type":"float64"}]}]}`,
+			wantErr: "invalid schema: column 0 of measurement 'cpu' has no name",
+		},
+	}
+	for _, c := range cases {
+		_, err := ReadSchema(strings.NewReader(c.input))
+		if err == nil {
+			t.Errorf("%s: unexpected lack of error", c.desc)
+		} else if !strings.HasPrefix(err.Error(), c.wantErr) {
+			t.Errorf("%s: incorrect error: got %v want %s", c.desc, err, c.wantErr)
+		}
+	}
+}
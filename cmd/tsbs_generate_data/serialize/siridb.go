@@ -2,16 +2,23 @@ package serialize
 
 import (
 	"encoding/binary"
-	"fmt"
 	"io"
 	"log"
-	"strconv"
+	"time"
 
 	qpack "github.com/transceptor-technology/go-qpack"
 )
 
 // SiriDBSerializer writes a Point in a serialized form for SiriDB
-type SiriDBSerializer struct{}
+type SiriDBSerializer struct {
+	// TimestampUnit is the unit timestamps are written in, nanoseconds if 0
+	TimestampUnit time.Duration
+
+	// line and key are reused by every call to Serialize to avoid allocating
+	// per Point
+	line []byte
+	key  []byte
+}
 
 // Serialize writes Point data to the given writer.
 //
@@ -29,7 +36,8 @@ type SiriDBSerializer struct{}
 // The output looks like this:
 // <number of metrics> <length of name and tags> <name and tags> <length of field key_1> <length of timestamp_1 and field value_1> <field key_1> <packed timestamp_1 and value_1> <length of field key_2> <length of timestamp_1 and field value_2> <field key_2> <packed timestamp_1 and value_2>... etc.
 func (s *SiriDBSerializer) Serialize(p *Point, w io.Writer) error {
-	line := make([]byte, 8, 1024)
+	// The first 8 bytes are the main header, filled in at the end
+	line := append(s.line[:0], 0, 0, 0, 0, 0, 0, 0, 0)
 	line = append(line, p.measurementName...)
 	line = append(line, '|')
 	for i, v := range p.tagValues {
@@ -45,20 +53,20 @@ func (s *SiriDBSerializer) Serialize(p *Point, w io.Writer) error {
 
 	var err error
 	metricCount := 0
+	ts := timestampIn(p.timestamp, s.TimestampUnit)
+	key := s.key
 
 	for i, value := range p.fieldValues {
 
 		indexLenData := len(line) + 4
 
-		key := make([]byte, 9, 64)
-		key[8] = '|'
+		key = append(key[:0], 0, 0, 0, 0, 0, 0, 0, 0, '|')
 		key = append(key, p.fieldKeys[i]...)
 
 		binary.LittleEndian.PutUint32(key[0:], uint32(len(key)-8))
 		line = append(line, key...)
 
 		preQpack := len(line)
-		ts, _ := strconv.ParseInt(fmt.Sprintf("%d", p.timestamp.UTC().UnixNano()), 10, 64)
 		err := qpack.PackTo(&line, []interface{}{ts, value}) // packs a byte array in the right format for SiriDB
 		if err != nil {
 			log.Fatal(err)
@@ -73,5 +81,6 @@ func (s *SiriDBSerializer) Serialize(p *Point, w io.Writer) error {
 	binary.LittleEndian.PutUint32(line[4:], uint32(lenName))
 
 	_, err = w.Write(line)
+	s.line, s.key = line, key
 	return err
 }
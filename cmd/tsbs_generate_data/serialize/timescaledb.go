@@ -1,12 +1,25 @@
 package serialize
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
 	"io"
+	"strconv"
+	"time"
 )
 
 // TimescaleDBSerializer writes a Point in a serialized form for TimescaleDB
-type TimescaleDBSerializer struct{}
+type TimescaleDBSerializer struct {
+	// TimestampUnit is the unit timestamps are written in, nanoseconds if 0
+	TimestampUnit time.Duration
+	// FloatPrecision is the number of decimals floats are written with, as
+	// many as needed to be exact if 0
+	FloatPrecision int
+
+	// buf is reused by every call to Serialize to avoid allocating per Point
+	buf []byte
+}
 
 // Serialize writes Point p to the given Writer w, so it can be
 // loaded by the TimescaleDB loader. The format is CSV with two lines per Point,
@@ -15,10 +28,13 @@ type TimescaleDBSerializer struct{}
 // e.g.,
 // tags,<tag1>,<tag2>,<tag3>,...
 // <measurement>,<timestamp>,<field1>,<field2>,<field3>,...
+//
+// String values are escaped with a backslash before commas, tabs and
+// backslashes, and newlines in them are written as \n.
 func (s *TimescaleDBSerializer) Serialize(p *Point, w io.Writer) error {
 	// Tag row first, prefixed with name 'tags'
-	buf := make([]byte, 0, 256)
-	buf = append(buf, []byte("tags")...)
+	buf := s.buf[:0]
+	buf = append(buf, "tags"...)
 	for i, v := range p.tagValues {
 		buf = append(buf, ',')
 		buf = append(buf, p.tagKeys[i]...)
@@ -26,22 +42,106 @@ func (s *TimescaleDBSerializer) Serialize(p *Point, w io.Writer) error {
 		buf = append(buf, v...)
 	}
 	buf = append(buf, '\n')
-	_, err := w.Write(buf)
-	if err != nil {
-		return err
-	}
 
 	// Field row second
-	buf = make([]byte, 0, 256)
 	buf = append(buf, p.measurementName...)
 	buf = append(buf, ',')
-	buf = append(buf, []byte(fmt.Sprintf("%d", p.timestamp.UTC().UnixNano()))...)
+	buf = strconv.AppendInt(buf, timestampIn(p.timestamp, s.TimestampUnit), 10)
 
+	var err error
 	for _, v := range p.fieldValues {
 		buf = append(buf, ',')
-		buf = fastFormatAppend(v, buf)
+		buf, err = appendTextField(buf, v, s.FloatPrecision)
+		if err != nil {
+			return err
+		}
 	}
 	buf = append(buf, '\n')
 	_, err = w.Write(buf)
+	s.buf = buf
 	return err
 }
+
+// TimescaleDBDeserializer reads Points written by TimescaleDBSerializer.
+// Their field keys are not part of the data, but of the header ahead of it.
+type TimescaleDBDeserializer struct {
+	// TimestampUnit is the unit timestamps were written in, nanoseconds if 0
+	TimestampUnit time.Duration
+	// Schema describes the fields of each measurement. If nil, it is read
+	// from the header, which must then start the data.
+	Schema *Schema
+
+	columns map[string][]SchemaColumn
+	started bool
+}
+
+// Deserialize reads the next pair of tag and field lines of r into p,
+// skipping the header ahead of the first. Values of integer columns are read
+// as int64s, of string columns as []byte, of bool columns as bools and all
+// others as float64s.
+func (d *TimescaleDBDeserializer) Deserialize(r *bufio.Reader, p *Point) error {
+	tagLine, err := readLine(r)
+	if err != nil {
+		return err
+	}
+	if !d.started {
+		d.started = true
+		if isSchemaHeader(tagLine) {
+			schema, err := readSchemaHeader(tagLine, r)
+			if err != nil {
+				return fmt.Errorf("invalid timescaledb header: %v", err)
+			}
+			if d.Schema == nil {
+				d.Schema = schema
+			}
+			if tagLine, err = readLine(r); err != nil {
+				return err
+			}
+		}
+		d.columns = d.Schema.columnsByMeasurement()
+	}
+	p.Reset()
+
+	tags := bytes.Split(tagLine, []byte(","))
+	if string(tags[0]) != schemaHeaderTags {
+		return fmt.Errorf("invalid timescaledb tags '%s'", tagLine)
+	}
+	for _, tag := range tags[1:] {
+		kv := bytes.SplitN(tag, []byte("="), 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid timescaledb tags '%s'", tagLine)
+		}
+		p.AppendTag(kv[0], kv[1])
+	}
+
+	fieldLine, err := readLine(r)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	} else if err != nil {
+		return err
+	}
+	values := SplitTextFields(string(fieldLine), ',')
+	if len(values) < 2 {
+		return fmt.Errorf("invalid timescaledb fields '%s'", fieldLine)
+	}
+	p.SetMeasurementName([]byte(values[0]))
+	ts, err := strconv.ParseInt(values[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timescaledb fields '%s': %v", fieldLine, err)
+	}
+	t := timeIn(ts, d.TimestampUnit)
+	p.SetTimestamp(&t)
+
+	columns, ok := d.columns[values[0]]
+	if !ok || len(columns) != len(values)-2 {
+		return fmt.Errorf("invalid timescaledb fields '%s': measurement not described by the header", fieldLine)
+	}
+	for i, c := range columns {
+		v, err := parseColumnValue(values[2+i], c.Type)
+		if err != nil {
+			return fmt.Errorf("invalid timescaledb fields '%s': %v", fieldLine, err)
+		}
+		p.AppendField([]byte(c.Name), v)
+	}
+	return nil
+}
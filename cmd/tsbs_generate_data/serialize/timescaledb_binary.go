@@ -0,0 +1,277 @@
+package serialize
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// PGCopySignature starts the header of PostgreSQL's binary COPY format
+const PGCopySignature = "PGCOPY\n\377\r\n\x00"
+
+// pgEpoch is the zero time of PostgreSQL timestamps
+var pgEpoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// PGCopyHeader returns the header of PostgreSQL's binary COPY format: its
+// signature, no flags and no header extension.
+func PGCopyHeader() []byte {
+	header := []byte(PGCopySignature)
+	header = appendUint32(header, 0) // flags
+	header = appendUint32(header, 0) // header extension length
+	return header
+}
+
+// PGTimestamp returns t as a PostgreSQL timestamp, i.e., the number of
+// microseconds since 2000-01-01 UTC. Finer parts are truncated.
+func PGTimestamp(t time.Time) int64 {
+	return (t.Unix()-pgEpoch.Unix())*1e6 + int64(t.Nanosecond()/1e3)
+}
+
+// PGTime returns the time of PostgreSQL timestamp us, the inverse of
+// PGTimestamp.
+func PGTime(us int64) time.Time {
+	return time.Unix(pgEpoch.Unix()+us/1e6, (us%1e6)*1e3)
+}
+
+// TimescaleDBBinarySerializer writes a Point as a tuple of PostgreSQL's binary
+// COPY format, so it can be loaded by the TimescaleDB loader without parsing
+// text. It should follow the same header as TimescaleDBSerializer, then
+// PGCopyHeader.
+type TimescaleDBBinarySerializer struct {
+	// IntegerFields writes integer values as int8 rather than float8, to
+	// match a header with integer columns
+	IntegerFields bool
+
+	// buf is reused by every call to Serialize to avoid allocating per Point
+	buf []byte
+}
+
+// Serialize writes Point p to the given Writer w as a tuple whose fields are
+// the measurement name as text, the timestamp as a timestamptz, the tags as
+// text in the form <key>=<value>,... and then each field value as a float8
+// (or int8, for integers with IntegerFields set), text or bool.
+func (s *TimescaleDBBinarySerializer) Serialize(p *Point, w io.Writer) error {
+	buf := s.buf[:0]
+	buf = appendUint16(buf, uint16(3+len(p.fieldValues)))
+
+	buf = appendUint32(buf, uint32(len(p.measurementName)))
+	buf = append(buf, p.measurementName...)
+
+	buf = appendUint32(buf, 8)
+	buf = appendUint64(buf, uint64(PGTimestamp(*p.timestamp)))
+
+	// The length of the tags is only known once they are written
+	lenAt := len(buf)
+	buf = appendUint32(buf, 0)
+	for i, v := range p.tagValues {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, p.tagKeys[i]...)
+		buf = append(buf, '=')
+		buf = append(buf, v...)
+	}
+	binary.BigEndian.PutUint32(buf[lenAt:], uint32(len(buf)-lenAt-4))
+
+	for _, v := range p.fieldValues {
+		var bits uint64
+		switch x := v.(type) {
+		case nil:
+			// a length of -1 is a NULL
+			buf = appendUint32(buf, math.MaxUint32)
+			continue
+		case float64:
+			bits = math.Float64bits(x)
+		case float32:
+			bits = math.Float64bits(float64(x))
+		case int:
+			bits = s.intBits(int64(x))
+		case int64:
+			bits = s.intBits(x)
+		case []byte:
+			buf = appendUint32(buf, uint32(len(x)))
+			buf = append(buf, x...)
+			continue
+		case string:
+			buf = appendUint32(buf, uint32(len(x)))
+			buf = append(buf, x...)
+			continue
+		case bool:
+			buf = appendUint32(buf, 1)
+			if x {
+				buf = append(buf, 1)
+			} else {
+				buf = append(buf, 0)
+			}
+			continue
+		default:
+			s.buf = buf
+			return fmt.Errorf("cannot serialize %T as a binary COPY value", v)
+		}
+		buf = appendUint32(buf, 8)
+		buf = appendUint64(buf, bits)
+	}
+
+	_, err := w.Write(buf)
+	s.buf = buf
+	return err
+}
+
+// intBits returns the bits v is written with, as an int8 or a float8
+func (s *TimescaleDBBinarySerializer) intBits(v int64) uint64 {
+	if s.IntegerFields {
+		return uint64(v)
+	}
+	return math.Float64bits(float64(v))
+}
+
+// TimescaleDBBinaryDeserializer reads Points written by
+// TimescaleDBBinarySerializer, which must follow their text header and
+// PGCopyHeader.
+type TimescaleDBBinaryDeserializer struct {
+	// Schema describes the fields of each measurement. It is read from the
+	// header if nil, and the header is skipped otherwise.
+	Schema *Schema
+
+	columns map[string][]SchemaColumn
+	started bool
+}
+
+// Deserialize reads the next tuple of r into p, skipping the headers ahead of
+// the first and stopping at the trailer of the binary COPY format, if any.
+// Values of integer columns are read as int8, of string columns as text, of
+// bool columns as bool and all others as float8.
+func (d *TimescaleDBBinaryDeserializer) Deserialize(r *bufio.Reader, p *Point) error {
+	if !d.started {
+		d.started = true
+		if err := d.readHeaders(r); err != nil {
+			return err
+		}
+	}
+
+	var count [2]byte
+	if _, err := io.ReadFull(r, count[:]); err != nil {
+		return err
+	}
+	n := int(int16(binary.BigEndian.Uint16(count[:])))
+	if n == -1 {
+		return io.EOF
+	}
+	fields := make([][]byte, n)
+	for i := range fields {
+		var err error
+		if fields[i], err = readPGCopyField(r); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+	if n < 3 || len(fields[1]) != 8 {
+		return fmt.Errorf("invalid binary COPY tuple of %d fields", n)
+	}
+	p.Reset()
+
+	p.SetMeasurementName(fields[0])
+	t := PGTime(int64(binary.BigEndian.Uint64(fields[1]))).UTC()
+	p.SetTimestamp(&t)
+	if len(fields[2]) > 0 {
+		for _, tag := range bytes.Split(fields[2], []byte(",")) {
+			kv := bytes.SplitN(tag, []byte("="), 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("invalid binary COPY tags '%s'", fields[2])
+			}
+			p.AppendTag(kv[0], kv[1])
+		}
+	}
+
+	columns, ok := d.columns[string(fields[0])]
+	if !ok || len(columns) != n-3 {
+		return fmt.Errorf("invalid binary COPY tuple: measurement '%s' not described by the header", fields[0])
+	}
+	for i, c := range columns {
+		f := fields[3+i]
+		var v interface{}
+		switch {
+		case f == nil:
+			// NULL
+		case c.Type == columnTypeString:
+			v = f
+		case c.Type == columnTypeBool:
+			if len(f) != 1 {
+				return fmt.Errorf("invalid binary COPY value of %d bytes for '%s'", len(f), c.Name)
+			}
+			v = f[0] != 0
+		case len(f) != 8:
+			return fmt.Errorf("invalid binary COPY value of %d bytes for '%s'", len(f), c.Name)
+		case isIntegerColumnType(c.Type):
+			v = int64(binary.BigEndian.Uint64(f))
+		default:
+			v = math.Float64frombits(binary.BigEndian.Uint64(f))
+		}
+		p.AppendField([]byte(c.Name), v)
+	}
+	return nil
+}
+
+// readHeaders reads the text header and PGCopyHeader ahead of the tuples
+func (d *TimescaleDBBinaryDeserializer) readHeaders(r *bufio.Reader) error {
+	tagLine, err := readLine(r)
+	if err != nil {
+		return err
+	}
+	if !isSchemaHeader(tagLine) {
+		return fmt.Errorf("invalid timescaledb header: no tags")
+	}
+	schema, err := readSchemaHeader(tagLine, r)
+	if err != nil {
+		return fmt.Errorf("invalid timescaledb header: %v", err)
+	}
+	if d.Schema == nil {
+		d.Schema = schema
+	}
+	d.columns = d.Schema.columnsByMeasurement()
+
+	header := make([]byte, len(PGCopyHeader()))
+	if _, err := io.ReadFull(r, header); err != nil {
+		return fmt.Errorf("invalid binary COPY header: %v", err)
+	}
+	if !bytes.HasPrefix(header, []byte(PGCopySignature)) {
+		return fmt.Errorf("invalid binary COPY header: bad signature")
+	}
+	return nil
+}
+
+// readPGCopyField reads a field of a binary COPY tuple, nil for NULL
+func readPGCopyField(r io.Reader) ([]byte, error) {
+	var l [4]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+	n := int32(binary.BigEndian.Uint32(l[:]))
+	if n == -1 {
+		return nil, nil
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("invalid binary COPY field length %d", n)
+	}
+	f := make([]byte, n)
+	_, err := io.ReadFull(r, f)
+	return f, err
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v>>32)), uint32(v))
+}
@@ -0,0 +1,250 @@
+package serialize
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+// pgCopyField is a field of a binary COPY tuple, nil for NULL
+type pgCopyField []byte
+
+// readPGCopyTuple decodes the tuple at the start of b the way PostgreSQL does,
+// returning its fields and the rest of b.
+func readPGCopyTuple(t *testing.T, b []byte) ([]pgCopyField, []byte) {
+	if len(b) < 2 {
+		t.Fatalf("tuple too short for its field count: %d bytes", len(b))
+	}
+	n := int(int16(binary.BigEndian.Uint16(b)))
+	b = b[2:]
+	fields := make([]pgCopyField, 0, n)
+	for i := 0; i < n; i++ {
+		if len(b) < 4 {
+			t.Fatalf("tuple too short for the length of field %d", i)
+		}
+		l := int(int32(binary.BigEndian.Uint32(b)))
+		b = b[4:]
+		if l == -1 {
+			fields = append(fields, nil)
+			continue
+		}
+		if l < 0 || len(b) < l {
+			t.Fatalf("field %d has an invalid length %d", i, l)
+		}
+		fields = append(fields, pgCopyField(b[:l]))
+		b = b[l:]
+	}
+	return fields, b
+}
+
+func TestPGCopyHeader(t *testing.T) {
+	want := []byte("PGCOPY\n\xff\r\n\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+	if got := PGCopyHeader(); !bytes.Equal(got, want) {
+		t.Errorf("incorrect header: got %q want %q", got, want)
+	}
+}
+
+func TestPGTimestamp(t *testing.T) {
+	cases := []struct {
+		ts   time.Time
+		want int64
+	}{
+		{ts: time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC), want: 0},
+		{ts: time.Date(2000, time.January, 1, 0, 0, 1, 999, time.UTC), want: 1000000},
+		{ts: testNow, want: 504921600000000},
+		{ts: testNowSubSec, want: 504921600123456},
+		{ts: time.Date(1999, time.December, 31, 23, 59, 59, 500000000, time.UTC), want: -500000},
+	}
+	for _, c := range cases {
+		if got := PGTimestamp(c.ts); got != c.want {
+			t.Errorf("incorrect timestamp for %v: got %d want %d", c.ts, got, c.want)
+		}
+		if got, want := PGTime(c.want), c.ts.Truncate(time.Microsecond); !got.Equal(want) {
+			t.Errorf("incorrect time for %d: got %v want %v", c.want, got, want)
+		}
+	}
+}
+
+func TestTimescaleDBBinarySerializerSerialize(t *testing.T) {
+	nullField := &Point{
+		measurementName: testMeasurement,
+		tagKeys:         testTagKeys[:1],
+		tagValues:       testTagVals[:1],
+		timestamp:       &testNow,
+		fieldKeys:       [][]byte{[]byte("missing"), []byte("small")},
+		fieldValues:     []interface{}{nil, float32(0.5)},
+	}
+	cases := []struct {
+		desc          string
+		inputPoint    *Point
+		integerFields bool
+		wantTags      string
+		wantTS        time.Time
+		wantValues    []interface{}
+	}{
+		{
+			desc:       "a regular Point",
+			inputPoint: testPointDefault,
+			wantTags:   "hostname=host_0,region=eu-west-1,datacenter=eu-west-1b",
+			wantTS:     testNow,
+			wantValues: []interface{}{38.24311829},
+		},
+		{
+			desc:       "a regular Point using int as value",
+			inputPoint: testPointInt,
+			wantTags:   "hostname=host_0,region=eu-west-1,datacenter=eu-west-1b",
+			wantTS:     testNow,
+			wantValues: []interface{}{38.0},
+		},
+		{
+			desc:          "a regular Point using int as value with integer fields",
+			inputPoint:    testPointInt,
+			integerFields: true,
+			wantTags:      "hostname=host_0,region=eu-west-1,datacenter=eu-west-1b",
+			wantTS:        testNow,
+			wantValues:    []interface{}{int64(38)},
+		},
+		{
+			desc:       "a regular Point with multiple fields",
+			inputPoint: testPointMultiField,
+			wantTags:   "hostname=host_0,region=eu-west-1,datacenter=eu-west-1b",
+			wantTS:     testNow,
+			wantValues: []interface{}{5000000000.0, 38.0, 38.24311829},
+		},
+		{
+			desc:          "a regular Point with multiple fields with integer fields",
+			inputPoint:    testPointMultiField,
+			integerFields: true,
+			wantTags:      "hostname=host_0,region=eu-west-1,datacenter=eu-west-1b",
+			wantTS:        testNow,
+			wantValues:    []interface{}{int64(5000000000), int64(38), 38.24311829},
+		},
+		{
+			desc:       "a Point with no tags",
+			inputPoint: testPointNoTags,
+			wantTags:   "",
+			wantTS:     testNow,
+			wantValues: []interface{}{38.24311829},
+		},
+		{
+			desc:       "a Point with a sub-second timestamp",
+			inputPoint: testPointSubSecond,
+			wantTags:   "hostname=host_0,region=eu-west-1,datacenter=eu-west-1b",
+			wantTS:     testNowSubSec.Truncate(time.Microsecond),
+			wantValues: []interface{}{38.24311829},
+		},
+		{
+			desc:       "a Point with a NULL and a float32",
+			inputPoint: nullField,
+			wantTags:   "hostname=host_0",
+			wantTS:     testNow,
+			wantValues: []interface{}{nil, 0.5},
+		},
+	}
+
+	for _, c := range cases {
+		s := &TimescaleDBBinarySerializer{IntegerFields: c.integerFields}
+		b := new(bytes.Buffer)
+		if err := s.Serialize(c.inputPoint, b); err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.desc, err)
+		}
+		fields, rest := readPGCopyTuple(t, b.Bytes())
+		if len(rest) != 0 {
+			t.Errorf("%s: %d bytes left after the tuple", c.desc, len(rest))
+		}
+		if got, want := len(fields), 3+len(c.wantValues); got != want {
+			t.Errorf("%s: incorrect field count: got %d want %d", c.desc, got, want)
+			continue
+		}
+
+		if got, want := string(fields[0]), string(c.inputPoint.measurementName); got != want {
+			t.Errorf("%s: incorrect measurement: got %s want %s", c.desc, got, want)
+		}
+		if len(fields[1]) != 8 {
+			t.Errorf("%s: incorrect timestamp length: got %d want 8", c.desc, len(fields[1]))
+		} else {
+			us := int64(binary.BigEndian.Uint64(fields[1]))
+			got := PGTime(us)
+			if !got.Equal(c.wantTS) {
+				t.Errorf("%s: incorrect timestamp: got %v want %v", c.desc, got, c.wantTS)
+			}
+		}
+		if got := string(fields[2]); got != c.wantTags {
+			t.Errorf("%s: incorrect tags: got %s want %s", c.desc, got, c.wantTags)
+		}
+
+		for i, want := range c.wantValues {
+			f := fields[3+i]
+			if want == nil {
+				if f != nil {
+					t.Errorf("%s: value %d is not NULL: got %v", c.desc, i, f)
+				}
+				continue
+			}
+			if len(f) != 8 {
+				t.Errorf("%s: incorrect length of value %d: got %d want 8", c.desc, i, len(f))
+				continue
+			}
+			bits := binary.BigEndian.Uint64(f)
+			var got interface{}
+			switch want.(type) {
+			case int64:
+				got = int64(bits)
+			default:
+				got = math.Float64frombits(bits)
+			}
+			if got != want {
+				t.Errorf("%s: incorrect value %d: got %v want %v", c.desc, i, got, want)
+			}
+		}
+	}
+}
+
+func TestTimescaleDBBinarySerializerSerializeStream(t *testing.T) {
+	s := &TimescaleDBBinarySerializer{}
+	b := new(bytes.Buffer)
+	points := []*Point{testPointDefault, testPointMultiField, testPointNoTags}
+	for _, p := range points {
+		if err := s.Serialize(p, b); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	rest := b.Bytes()
+	for i, p := range points {
+		var fields []pgCopyField
+		fields, rest = readPGCopyTuple(t, rest)
+		if got, want := len(fields), 3+len(p.fieldValues); got != want {
+			t.Errorf("incorrect field count of tuple %d: got %d want %d", i, got, want)
+		}
+	}
+	if len(rest) != 0 {
+		t.Errorf("%d bytes left after the tuples", len(rest))
+	}
+}
+
+func TestTimescaleDBBinarySerializerSerializeErr(t *testing.T) {
+	p := testPointMultiField
+	s := &TimescaleDBBinarySerializer{}
+	err := s.Serialize(p, &errWriter{})
+	if err == nil {
+		t.Errorf("no error returned when expected")
+	} else if err.Error() != errWriterAlwaysErr {
+		t.Errorf("unexpected writer error: %v", err)
+	}
+
+	p = &Point{
+		measurementName: testMeasurement,
+		timestamp:       &testNow,
+	}
+	p.AppendField([]byte("broken"), []int{1})
+	err = s.Serialize(p, new(bytes.Buffer))
+	if err == nil {
+		t.Errorf("no error returned for an unsupported value")
+	}
+}
+
+func BenchmarkTimescaleDBBinarySerializerSerialize(b *testing.B) {
+	benchmarkSerializer(b, &TimescaleDBBinarySerializer{})
+}
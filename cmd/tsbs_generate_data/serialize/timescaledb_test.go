@@ -1,6 +1,10 @@
 package serialize
 
 import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
 	"testing"
 )
 
@@ -26,6 +30,16 @@ func TestTimescaleDBSerializerSerialize(t *testing.T) {
 			inputPoint: testPointNoTags,
 			output:     "tags\ncpu,1451606400000000000,38.24311829\n",
 		},
+		{
+			desc:       "a Point with a sub-second timestamp",
+			inputPoint: testPointSubSecond,
+			output:     "tags,hostname=host_0,region=eu-west-1,datacenter=eu-west-1b\ncpu,1451606400123456789,38.24311829\n",
+		},
+		{
+			desc:       "a Point with string and bool fields",
+			inputPoint: testPointTyped,
+			output:     "tags,hostname=host_0,region=eu-west-1,datacenter=eu-west-1b\ncpu,1451606400000000000,38.24311829,up\\, \"ready\",true\n",
+		},
 	}
 
 	testSerializer(t, cases, &TimescaleDBSerializer{})
@@ -41,3 +55,163 @@ func TestTimescaleDBSerializerSerializeErr(t *testing.T) {
 		t.Errorf("unexpected writer error: %v", err)
 	}
 }
+
+func BenchmarkTimescaleDBSerializerSerialize(b *testing.B) {
+	benchmarkSerializer(b, &TimescaleDBSerializer{})
+}
+
+func TestTimescaleDBDeserializerDeserialize(t *testing.T) {
+	header := "tags,hostname,region,datacenter\ncpu,big_usage_guest:int64,usage_guest:int64,usage_guest_nice\n\n"
+	data := header + "tags,hostname=host_0,region=eu-west-1,datacenter=eu-west-1b\ncpu,1451606400000000000,5000000000,38,38.24311829\n"
+	d := &TimescaleDBDeserializer{}
+	r := bufio.NewReader(strings.NewReader(data))
+	p := NewPoint()
+	if err := d.Deserialize(r, p); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := diffPoints(p, testPointMultiField, 1); diff != "" {
+		t.Errorf("incorrect point: %s", diff)
+	}
+	if _, ok := p.GetFieldValue(testColInt).(int64); !ok {
+		t.Errorf("integer column not read as an int64: %T", p.GetFieldValue(testColInt))
+	}
+	if _, ok := p.GetFieldValue(testColFloat).(float64); !ok {
+		t.Errorf("float column not read as a float64: %T", p.GetFieldValue(testColFloat))
+	}
+	if err := d.Deserialize(r, p); err != io.EOF {
+		t.Errorf("incorrect error at the end: got %v want %v", err, io.EOF)
+	}
+	if d.Schema == nil || len(d.Schema.Measurements) != 1 {
+		t.Errorf("header not read into the schema: %v", d.Schema)
+	}
+}
+
+func TestTimescaleDBDeserializerDeserializeTyped(t *testing.T) {
+	header := "tags,hostname,region,datacenter\ncpu,usage_guest_nice,status:string,healthy:bool\n\n"
+	b := new(bytes.Buffer)
+	b.WriteString(header)
+	if err := (&TimescaleDBSerializer{}).Serialize(testPointTyped, b); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	d := &TimescaleDBDeserializer{}
+	p := NewPoint()
+	if err := d.Deserialize(bufio.NewReader(b), p); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, ok := p.GetFieldValue(testColString).([]byte); !ok || string(got) != `up, "ready"` {
+		t.Errorf("incorrect string value: %#v", p.GetFieldValue(testColString))
+	}
+	if got, ok := p.GetFieldValue(testColBool).(bool); !ok || !got {
+		t.Errorf("incorrect bool value: %#v", p.GetFieldValue(testColBool))
+	}
+}
+
+func TestTimescaleDBDeserializerDeserializeErr(t *testing.T) {
+	header := "tags,hostname\ncpu,usage_guest_nice\n\n"
+	cases := []struct {
+		desc string
+		data string
+		want error
+	}{
+		{
+			desc: "no header",
+			data: "tags,hostname=host_0\ncpu,1451606400000000000,38.24311829\n",
+		},
+		{
+			desc: "unterminated header",
+			data: "tags,hostname\ncpu,usage_guest_nice\n",
+		},
+		{
+			desc: "unknown measurement",
+			data: header + "tags,hostname=host_0\nmem,1451606400000000000,38.24311829\n",
+		},
+		{
+			desc: "too many values",
+			data: header + "tags,hostname=host_0\ncpu,1451606400000000000,38.24311829,1\n",
+		},
+		{
+			desc: "bad tags",
+			data: header + "tags,hostname\ncpu,1451606400000000000,38.24311829\n",
+		},
+		{
+			desc: "bad timestamp",
+			data: header + "tags,hostname=host_0\ncpu,now,38.24311829\n",
+		},
+		{
+			desc: "bad value",
+			data: header + "tags,hostname=host_0\ncpu,1451606400000000000,x\n",
+		},
+		{
+			desc: "no fields",
+			data: header + "tags,hostname=host_0\n",
+			want: io.ErrUnexpectedEOF,
+		},
+	}
+	for _, c := range cases {
+		d := &TimescaleDBDeserializer{}
+		err := d.Deserialize(bufio.NewReader(strings.NewReader(c.data)), NewPoint())
+		if err == nil || err == io.EOF {
+			t.Errorf("%s: no error returned: %v", c.desc, err)
+		} else if c.want != nil && err != c.want {
+			t.Errorf("%s: incorrect error: got %v want %v", c.desc, err, c.want)
+		}
+	}
+}
+
+func TestTimescaleDBBinaryDeserializerDeserialize(t *testing.T) {
+	header := "tags,hostname,region,datacenter\ncpu,big_usage_guest:int64,usage_guest:int64,usage_guest_nice\n\n"
+	b := bytes.NewBufferString(header)
+	b.Write(PGCopyHeader())
+	s := &TimescaleDBBinarySerializer{IntegerFields: true}
+	if err := s.Serialize(testPointMultiField, b); err != nil {
+		t.Fatal(err)
+	}
+	// The trailer of the binary COPY format ends the tuples
+	b.Write([]byte{0xff, 0xff})
+	b.WriteString("not a tuple")
+
+	d := &TimescaleDBBinaryDeserializer{}
+	r := bufio.NewReader(b)
+	p := NewPoint()
+	if err := d.Deserialize(r, p); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := diffPoints(p, testPointMultiField, 1); diff != "" {
+		t.Errorf("incorrect point: %s", diff)
+	}
+	if err := d.Deserialize(r, p); err != io.EOF {
+		t.Errorf("incorrect error at the trailer: got %v want %v", err, io.EOF)
+	}
+}
+
+func TestTimescaleDBBinaryDeserializerDeserializeErr(t *testing.T) {
+	header := "tags,hostname\ncpu,usage_guest_nice\n\n"
+	cases := []struct {
+		desc string
+		data string
+	}{
+		{
+			desc: "no header",
+			data: string(PGCopyHeader()),
+		},
+		{
+			desc: "no binary COPY header",
+			data: header + "PGCOPY",
+		},
+		{
+			desc: "bad signature",
+			data: header + "PGCOPY\n\377\r\n\x01\x00\x00\x00\x00\x00\x00\x00\x00",
+		},
+		{
+			desc: "truncated tuple",
+			data: header + string(PGCopyHeader()) + "\x00\x04\x00\x00\x00\x03cpu",
+		},
+	}
+	for _, c := range cases {
+		d := &TimescaleDBBinaryDeserializer{}
+		err := d.Deserialize(bufio.NewReader(strings.NewReader(c.data)), NewPoint())
+		if err == nil || err == io.EOF {
+			t.Errorf("%s: no error returned: %v", c.desc, err)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package serialize
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"strconv"
+	"strings"
 )
 
 // Utility function for appending various data types to a byte string
@@ -32,3 +35,89 @@ func fastFormatAppend(v interface{}, buf []byte) []byte {
 		panic(fmt.Sprintf("unknown field type for %#v", v))
 	}
 }
+
+// appendField appends field value v to buf like fastFormatAppend, except that
+// floats are written with precision decimals, or as many as needed to be
+// exact if precision is 0, and rejected if not finite.
+func appendField(buf []byte, v interface{}, precision int) ([]byte, error) {
+	switch f := v.(type) {
+	case float64:
+		return appendFloat(buf, f, precision, 64)
+	case float32:
+		return appendFloat(buf, float64(f), precision, 32)
+	}
+	return fastFormatAppend(v, buf), nil
+}
+
+// appendTextField appends field value v to buf like appendField, except that
+// strings are escaped with appendTextEscaped so they can be written between
+// separators of the CSV-like formats.
+func appendTextField(buf []byte, v interface{}, precision int) ([]byte, error) {
+	switch str := v.(type) {
+	case []byte:
+		return appendTextEscaped(buf, str), nil
+	case string:
+		return appendTextEscaped(buf, []byte(str)), nil
+	}
+	return appendField(buf, v, precision)
+}
+
+// appendTextEscaped appends s to buf with a backslash before every backslash,
+// comma and tab, and newlines written as \n, so that s is a single value of
+// the CSV-like formats whichever separator they use.
+func appendTextEscaped(buf, s []byte) []byte {
+	for _, c := range s {
+		switch c {
+		case '\\', ',', '\t':
+			buf = append(buf, '\\', c)
+		case '\n':
+			buf = append(buf, '\\', 'n')
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return buf
+}
+
+// SplitTextFields splits a line of the CSV-like formats at every separator
+// sep that is not escaped, undoing the escaping of string values written
+// in it. Lines without backslashes, such as those with only numeric values,
+// are simply split.
+func SplitTextFields(line string, sep byte) []string {
+	if strings.IndexByte(line, '\\') < 0 {
+		return strings.Split(line, string(sep))
+	}
+	var fields []string
+	var field []byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && i+1 < len(line):
+			i++
+			if line[i] == 'n' {
+				field = append(field, '\n')
+			} else {
+				field = append(field, line[i])
+			}
+		case c == sep:
+			fields = append(fields, string(field))
+			field = field[:0]
+		default:
+			field = append(field, c)
+		}
+	}
+	return append(fields, string(field))
+}
+
+// readLine returns the next line of r without its newline, or io.EOF if r has
+// no more lines. A last line without a newline is still returned.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadBytes('\n')
+	if err == io.EOF && len(line) > 0 {
+		return line, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return line[:len(line)-1], nil
+}
@@ -90,3 +90,30 @@ func TestFastFormatAppend(t *testing.T) {
 		}
 	}
 }
+
+func TestSplitTextFields(t *testing.T) {
+	cases := []struct {
+		desc  string
+		value string
+	}{
+		{desc: "plain", value: "up"},
+		{desc: "empty", value: ""},
+		{desc: "commas", value: "a,b,,c"},
+		{desc: "tabs and newlines", value: "a\tb\nc"},
+		{desc: "backslashes", value: `a\,b\\`},
+	}
+	for _, c := range cases {
+		line := []byte("cpu,")
+		line = appendTextEscaped(line, []byte(c.value))
+		line = append(line, ",1.5"...)
+		got := SplitTextFields(string(line), ',')
+		if len(got) != 3 || got[0] != "cpu" || got[1] != c.value || got[2] != "1.5" {
+			t.Errorf("%s: incorrect fields of '%s': got %q", c.desc, line, got)
+		}
+	}
+
+	got := SplitTextFields("cpu\t1\t2", '\t')
+	if len(got) != 3 || got[2] != "2" {
+		t.Errorf("incorrect fields for tab separator: %q", got)
+	}
+}
@@ -449,6 +449,170 @@ func TestGroupByTime(t *testing.T) {
 	runTestCases(t, testFunc, start, end, cases)
 }
 
+// TestGroupByTimeQueryTypes pins the SQL of the single-groupby query types,
+// over the tags table of tsbs_load_clickhouse or, with denormalized tags, the
+// hostname column of the metrics tables
+func TestGroupByTimeQueryTypes(t *testing.T) {
+	cases := []struct {
+		testCase
+		metrics int
+		hours   int
+	}{
+		{
+			testCase: testCase{
+				desc:               "single-groupby-1-1-1",
+				input:              1,
+				devopsUseTags:      true,
+				expectedHumanLabel: "ClickHouse 1 cpu metric(s), random    1 hosts, random 1h0m0s by 1m",
+				expectedHumanDesc:  "ClickHouse 1 cpu metric(s), random    1 hosts, random 1h0m0s by 1m: 1970-01-01T20:16:22Z",
+				expectedQuery: `
+        SELECT
+            toStartOfMinute(created_at) AS minute,
+            max(usage_user) AS max_usage_user
+        FROM cpu
+        WHERE tags_id IN (SELECT id FROM tags WHERE hostname IN ('host_9')) AND (created_at >= '1970-01-01 20:16:22') AND (created_at < '1970-01-01 21:16:22')
+        GROUP BY minute
+        ORDER BY minute ASC
+        `,
+			},
+			metrics: 1,
+			hours:   1,
+		},
+		{
+			testCase: testCase{
+				desc:               "single-groupby-1-1-12",
+				input:              1,
+				devopsUseTags:      true,
+				expectedHumanLabel: "ClickHouse 1 cpu metric(s), random    1 hosts, random 12h0m0s by 1m",
+				expectedHumanDesc:  "ClickHouse 1 cpu metric(s), random    1 hosts, random 12h0m0s by 1m: 1970-01-01T06:16:22Z",
+				expectedQuery: `
+        SELECT
+            toStartOfMinute(created_at) AS minute,
+            max(usage_user) AS max_usage_user
+        FROM cpu
+        WHERE tags_id IN (SELECT id FROM tags WHERE hostname IN ('host_9')) AND (created_at >= '1970-01-01 06:16:22') AND (created_at < '1970-01-01 18:16:22')
+        GROUP BY minute
+        ORDER BY minute ASC
+        `,
+			},
+			metrics: 1,
+			hours:   12,
+		},
+		{
+			testCase: testCase{
+				desc:               "single-groupby-1-8-1",
+				input:              8,
+				devopsUseTags:      true,
+				expectedHumanLabel: "ClickHouse 1 cpu metric(s), random    8 hosts, random 1h0m0s by 1m",
+				expectedHumanDesc:  "ClickHouse 1 cpu metric(s), random    8 hosts, random 1h0m0s by 1m: 1970-01-01T20:16:22Z",
+				expectedQuery: `
+        SELECT
+            toStartOfMinute(created_at) AS minute,
+            max(usage_user) AS max_usage_user
+        FROM cpu
+        WHERE tags_id IN (SELECT id FROM tags WHERE hostname IN ('host_9','host_3','host_5','host_1','host_7','host_2','host_8','host_4')) AND (created_at >= '1970-01-01 20:16:22') AND (created_at < '1970-01-01 21:16:22')
+        GROUP BY minute
+        ORDER BY minute ASC
+        `,
+			},
+			metrics: 1,
+			hours:   1,
+		},
+		{
+			testCase: testCase{
+				desc:               "single-groupby-5-1-1",
+				input:              1,
+				devopsUseTags:      true,
+				expectedHumanLabel: "ClickHouse 5 cpu metric(s), random    1 hosts, random 1h0m0s by 1m",
+				expectedHumanDesc:  "ClickHouse 5 cpu metric(s), random    1 hosts, random 1h0m0s by 1m: 1970-01-01T20:16:22Z",
+				expectedQuery: `
+        SELECT
+            toStartOfMinute(created_at) AS minute,
+            max(usage_user) AS max_usage_user, max(usage_system) AS max_usage_system, max(usage_idle) AS max_usage_idle, max(usage_nice) AS max_usage_nice, max(usage_iowait) AS max_usage_iowait
+        FROM cpu
+        WHERE tags_id IN (SELECT id FROM tags WHERE hostname IN ('host_9')) AND (created_at >= '1970-01-01 20:16:22') AND (created_at < '1970-01-01 21:16:22')
+        GROUP BY minute
+        ORDER BY minute ASC
+        `,
+			},
+			metrics: 5,
+			hours:   1,
+		},
+		{
+			testCase: testCase{
+				desc:               "single-groupby-5-1-12",
+				input:              1,
+				devopsUseTags:      true,
+				expectedHumanLabel: "ClickHouse 5 cpu metric(s), random    1 hosts, random 12h0m0s by 1m",
+				expectedHumanDesc:  "ClickHouse 5 cpu metric(s), random    1 hosts, random 12h0m0s by 1m: 1970-01-01T06:16:22Z",
+				expectedQuery: `
+        SELECT
+            toStartOfMinute(created_at) AS minute,
+            max(usage_user) AS max_usage_user, max(usage_system) AS max_usage_system, max(usage_idle) AS max_usage_idle, max(usage_nice) AS max_usage_nice, max(usage_iowait) AS max_usage_iowait
+        FROM cpu
+        WHERE tags_id IN (SELECT id FROM tags WHERE hostname IN ('host_9')) AND (created_at >= '1970-01-01 06:16:22') AND (created_at < '1970-01-01 18:16:22')
+        GROUP BY minute
+        ORDER BY minute ASC
+        `,
+			},
+			metrics: 5,
+			hours:   12,
+		},
+		{
+			testCase: testCase{
+				desc:               "single-groupby-5-8-1",
+				input:              8,
+				devopsUseTags:      true,
+				expectedHumanLabel: "ClickHouse 5 cpu metric(s), random    8 hosts, random 1h0m0s by 1m",
+				expectedHumanDesc:  "ClickHouse 5 cpu metric(s), random    8 hosts, random 1h0m0s by 1m: 1970-01-01T20:16:22Z",
+				expectedQuery: `
+        SELECT
+            toStartOfMinute(created_at) AS minute,
+            max(usage_user) AS max_usage_user, max(usage_system) AS max_usage_system, max(usage_idle) AS max_usage_idle, max(usage_nice) AS max_usage_nice, max(usage_iowait) AS max_usage_iowait
+        FROM cpu
+        WHERE tags_id IN (SELECT id FROM tags WHERE hostname IN ('host_9','host_3','host_5','host_1','host_7','host_2','host_8','host_4')) AND (created_at >= '1970-01-01 20:16:22') AND (created_at < '1970-01-01 21:16:22')
+        GROUP BY minute
+        ORDER BY minute ASC
+        `,
+			},
+			metrics: 5,
+			hours:   1,
+		},
+		{
+			testCase: testCase{
+				desc:               "single-groupby-5-8-1 denormalized tags",
+				input:              8,
+				devopsUseTags:      false,
+				expectedHumanLabel: "ClickHouse 5 cpu metric(s), random    8 hosts, random 1h0m0s by 1m",
+				expectedHumanDesc:  "ClickHouse 5 cpu metric(s), random    8 hosts, random 1h0m0s by 1m: 1970-01-01T20:16:22Z",
+				expectedQuery: `
+        SELECT
+            toStartOfMinute(created_at) AS minute,
+            max(usage_user) AS max_usage_user, max(usage_system) AS max_usage_system, max(usage_idle) AS max_usage_idle, max(usage_nice) AS max_usage_nice, max(usage_iowait) AS max_usage_iowait
+        FROM cpu
+        WHERE (hostname = 'host_9' OR hostname = 'host_3' OR hostname = 'host_5' OR hostname = 'host_1' OR hostname = 'host_7' OR hostname = 'host_2' OR hostname = 'host_8' OR hostname = 'host_4') AND (created_at >= '1970-01-01 20:16:22') AND (created_at < '1970-01-01 21:16:22')
+        GROUP BY minute
+        ORDER BY minute ASC
+        `,
+			},
+			metrics: 5,
+			hours:   1,
+		},
+	}
+
+	start := time.Unix(0, 0)
+	end := start.Add(24 * time.Hour)
+	for _, c := range cases {
+		metrics, hours := c.metrics, c.hours
+		testFunc := func(d *Devops, c testCase) query.Query {
+			q := d.GenerateEmptyQuery()
+			d.GroupByTime(q, c.input, metrics, time.Duration(hours)*time.Hour)
+			return q
+		}
+		runTestCases(t, testFunc, start, end, []testCase{c.testCase})
+	}
+}
+
 type testCase struct {
 	desc               string
 	input              int
@@ -63,6 +63,7 @@ type benchmark struct {
 }
 
 func (b *benchmark) GetPointDecoder(br *bufio.Reader) load.PointDecoder {
+	readLayout(br)
 	return &decoder{scanner: bufio.NewScanner(br)}
 }
 
@@ -7,9 +7,33 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/timescale/tsbs/cmd/tsbs_generate_data/serialize"
 	"github.com/timescale/tsbs/load"
 )
 
+// layout is how series IDs are composed, as described ahead of the data
+var layout = &serialize.CassandraLayout{}
+
+// readLayout reads the layout described ahead of the data in br, if any,
+// leaving the default one otherwise.
+func readLayout(br *bufio.Reader) {
+	prefix, _ := br.Peek(1)
+	if len(prefix) == 0 || prefix[0] != '#' {
+		return
+	}
+	line, err := br.ReadString('\n')
+	if err != nil {
+		log.Fatalf("cannot read layout: %v", err)
+	}
+	l, ok, err := serialize.ParseCassandraLayoutHeader(strings.TrimSpace(line))
+	if err != nil {
+		log.Fatal(err)
+	} else if !ok {
+		log.Fatalf("input starts with an unknown header: %s", line)
+	}
+	layout = l
+}
+
 type decoder struct {
 	scanner *bufio.Scanner
 }
@@ -32,7 +56,7 @@ func (d *decoder) Decode(_ *bufio.Reader) *load.Point {
 // We currently only support a 1-line:1-metric mapping for Cassandra. Implement
 // other functions here to support other formats.
 func singleMetricToInsertStatement(text string) string {
-	insertStatement := "INSERT INTO %s(series_id, timestamp_ns, value) VALUES('%s#%s#%s', %s, %s)"
+	insertStatement := "INSERT INTO %s(series_id, timestamp_ns, value) VALUES('%s', %s, %s)"
 	parts := strings.Split(text, ",")
 	tagsBeginIndex := 1                  // list of tags begins after the table name
 	tagsEndIndex := (len(parts) - 1) - 4 // list of tags ends right before the last 4 parts of the line
@@ -44,7 +68,7 @@ func singleMetricToInsertStatement(text string) string {
 	timestampNS := parts[tagsEndIndex+3]                            // offset: table + numTags + numTags + measurementName + dayBucket
 	value := parts[tagsEndIndex+4]                                  // offset: table + numTags + timestamp + measurementName + dayBucket + timestampNS
 
-	return fmt.Sprintf(insertStatement, table, tags, measurementName, dayBucket, timestampNS, value)
+	return fmt.Sprintf(insertStatement, table, layout.SeriesID(tags, measurementName, dayBucket), timestampNS, value)
 }
 
 type eventsBatch struct {
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"bufio"
+	"strings"
 	"testing"
+
+	"github.com/timescale/tsbs/cmd/tsbs_generate_data/serialize"
 )
 
 func TestSingleMetricToInsertStatement(t *testing.T) {
@@ -29,3 +33,38 @@ func TestSingleMetricToInsertStatement(t *testing.T) {
 		}
 	}
 }
+
+func TestReadLayout(t *testing.T) {
+	oldLayout := layout
+	defer func() { layout = oldLayout }()
+
+	data := "series_double,cpu,hostname=host_0,usage_user,2016-01-01T12:00,1451649600000000000,1\n"
+	cases := []struct {
+		desc  string
+		input string
+		want  string
+	}{
+		{
+			desc:  "no header",
+			input: data,
+			want:  "INSERT INTO series_double(series_id, timestamp_ns, value) VALUES('cpu,hostname=host_0#usage_user#2016-01-01T12:00', 1451649600000000000, 1)",
+		},
+		{
+			desc:  "header with reordered key",
+			input: "#layout bucket=12h0m0s key=bucket,series,field\n" + data,
+			want:  "INSERT INTO series_double(series_id, timestamp_ns, value) VALUES('2016-01-01T12:00#cpu,hostname=host_0#usage_user', 1451649600000000000, 1)",
+		},
+	}
+	for _, c := range cases {
+		layout = &serialize.CassandraLayout{}
+		br := bufio.NewReader(strings.NewReader(c.input))
+		readLayout(br)
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("%s: cannot read data after layout: %v", c.desc, err)
+		}
+		if got := singleMetricToInsertStatement(strings.TrimSpace(line)); got != c.want {
+			t.Errorf("%s: incorrect statement:\ngot  %s\nwant %s", c.desc, got, c.want)
+		}
+	}
+}
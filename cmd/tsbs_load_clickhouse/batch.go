@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	clickhouse "github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// APIs the rows are inserted with over the native protocol
+const (
+	// insertAPIBatch appends the columns of the rows to a batch of
+	// clickhouse-go v2, each at once
+	insertAPIBatch = "batch"
+	// insertAPIPrepare executes a prepared INSERT for each row within a
+	// transaction of database/sql, which the driver sends as a block
+	insertAPIPrepare = "prepare"
+)
+
+// openBatchConn opens a clickhouse-go v2 connection to database on the first
+// of addresses, failing over to the others, with the settings, timeouts,
+// compression and TLS of the connections of database/sql (see
+// workerConnectString).
+func openBatchConn(addresses []string, database string) (driver.Conn, error) {
+	settings := clickhouse.Settings{}
+	for _, s := range chSettings {
+		settings[s.name] = s.value
+	}
+	for _, s := range insertSettings() {
+		settings[s.name] = s.value
+	}
+	options := &clickhouse.Options{
+		Addr:             addresses,
+		Auth:             clickhouse.Auth{Database: database, Username: user, Password: password},
+		Settings:         settings,
+		ConnOpenStrategy: clickhouse.ConnOpenInOrder,
+		MaxOpenConns:     connectionsPerWorker,
+		MaxIdleConns:     connectionsPerWorker,
+	}
+	if readTimeout > 0 {
+		options.ReadTimeout = readTimeout
+	}
+	switch compress {
+	case compressLZ4:
+		options.Compression = &clickhouse.Compression{Method: clickhouse.CompressionLZ4}
+	case compressZSTD:
+		options.Compression = &clickhouse.Compression{Method: clickhouse.CompressionZSTD}
+	}
+	if secure {
+		config, err := tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		options.TLS = config
+	}
+	conn, err := clickhouse.Open(options)
+	if err != nil {
+		return nil, err
+	}
+	// The connection is only opened by its first use
+	if err := conn.Ping(context.Background()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// insertBatch inserts rows, the values of cols, into table with a batch of
+// clickhouse-go v2, with settings of its own
+func insertBatch(conn driver.Conn, table string, cols []string, rows [][]interface{}, settings []setting) error {
+	sql := fmt.Sprintf("INSERT INTO %s (%s)", table, strings.Join(cols, ","))
+	debugLog.printEvery(2, "insert "+table, "%s", sql)
+	ctx := context.Background()
+	if len(settings) > 0 {
+		querySettings := clickhouse.Settings{}
+		for _, s := range settings {
+			querySettings[s.name] = s.value
+		}
+		ctx = clickhouse.Context(ctx, clickhouse.WithSettings(querySettings))
+	}
+	batch, err := conn.PrepareBatch(ctx, sql)
+	if err != nil {
+		return err
+	}
+	if err := appendColumns(batch, len(cols), rows); err != nil {
+		batch.Abort()
+		return fmt.Errorf("cannot insert %d rows into %s: %v", len(rows), table, err)
+	}
+	if err := batch.Send(); err != nil {
+		return fmt.Errorf("cannot insert %d rows into %s: %v", len(rows), table, err)
+	}
+	return nil
+}
+
+// appendColumns appends rows of numCols values to batch, each column at once.
+// Rows are appended one by one if a column cannot be made a slice of (see
+// columnSlice).
+func appendColumns(batch driver.Batch, numCols int, rows [][]interface{}) error {
+	columns := make([]interface{}, numCols)
+	for i := range columns {
+		column, ok := columnSlice(rows, i)
+		if !ok {
+			for _, r := range rows {
+				if err := batch.Append(r...); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		columns[i] = column
+	}
+	for i, column := range columns {
+		if err := batch.Column(i).Append(column); err != nil {
+			return fmt.Errorf("column %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// columnSlice returns the values of column i of rows as a slice of their type,
+// e.g., []float64, or of pointers to them if some are NULL, e.g., []*float64,
+// as the driver appends to columns of that type. ok is false if the values
+// are all NULL, leaving no type to make a slice of, or of different types.
+func columnSlice(rows [][]interface{}, i int) (column interface{}, ok bool) {
+	var t reflect.Type
+	nulls := false
+	for _, r := range rows {
+		switch {
+		case r[i] == nil:
+			nulls = true
+		case t == nil:
+			t = reflect.TypeOf(r[i])
+		case reflect.TypeOf(r[i]) != t:
+			return nil, false
+		}
+	}
+	if t == nil {
+		return nil, false
+	}
+
+	elemType := t
+	if nulls {
+		elemType = reflect.PtrTo(t)
+	}
+	s := reflect.MakeSlice(reflect.SliceOf(elemType), len(rows), len(rows))
+	for j, r := range rows {
+		if r[i] == nil {
+			continue
+		}
+		v := reflect.ValueOf(r[i])
+		if nulls {
+			p := reflect.New(t)
+			p.Elem().Set(v)
+			v = p
+		}
+		s.Index(j).Set(v)
+	}
+	return s.Interface(), true
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+func TestColumnSlice(t *testing.T) {
+	ts := time.Unix(1451606400, 0)
+	f := 1.5
+	cases := []struct {
+		desc   string
+		values []interface{}
+		want   interface{}
+		wantOk bool
+	}{
+		{desc: "time", values: []interface{}{ts, ts}, want: []time.Time{ts, ts}, wantOk: true},
+		{desc: "tags_id", values: []interface{}{uint32(1), uint32(2)}, want: []uint32{1, 2}, wantOk: true},
+		{desc: "float64", values: []interface{}{1.5, 2.5}, want: []float64{1.5, 2.5}, wantOk: true},
+		{desc: "map", values: []interface{}{map[string]string{"a": "b"}}, want: []map[string]string{{"a": "b"}}, wantOk: true},
+		{desc: "NULL", values: []interface{}{nil, 1.5}, want: []*float64{nil, &f}, wantOk: true},
+		{desc: "all NULL", values: []interface{}{nil, nil}},
+		{desc: "different types", values: []interface{}{1.5, int64(2)}},
+	}
+	for _, c := range cases {
+		rows := make([][]interface{}, len(c.values))
+		for i, v := range c.values {
+			rows[i] = []interface{}{"host_0", v}
+		}
+		got, ok := columnSlice(rows, 1)
+		if ok != c.wantOk {
+			t.Errorf("%s: incorrect ok: got %v want %v", c.desc, ok, c.wantOk)
+		} else if ok && !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: incorrect column: got %#v want %#v", c.desc, got, c.want)
+		}
+	}
+}
+
+// testBatch records the columns and rows appended to it
+type testBatch struct {
+	driver.Batch
+	columns map[int]interface{}
+	rows    [][]interface{}
+}
+
+func (b *testBatch) Append(v ...interface{}) error {
+	b.rows = append(b.rows, v)
+	return nil
+}
+
+func (b *testBatch) Column(i int) driver.BatchColumn {
+	return &testBatchColumn{batch: b, i: i}
+}
+
+type testBatchColumn struct {
+	driver.BatchColumn
+	batch *testBatch
+	i     int
+}
+
+func (c *testBatchColumn) Append(v interface{}) error {
+	c.batch.columns[c.i] = v
+	return nil
+}
+
+func TestAppendColumns(t *testing.T) {
+	ts := time.Unix(1451606400, 0)
+	rows := [][]interface{}{
+		{ts, uint32(1), "", 1.5},
+		{ts, uint32(2), "", 2.5},
+	}
+	b := &testBatch{columns: make(map[int]interface{})}
+	if err := appendColumns(b, 4, rows); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[int]interface{}{0: []time.Time{ts, ts}, 1: []uint32{1, 2}, 2: []string{"", ""}, 3: []float64{1.5, 2.5}}
+	if !reflect.DeepEqual(b.columns, want) || len(b.rows) > 0 {
+		t.Errorf("incorrect columns appended: got %v and rows %v want %v", b.columns, b.rows, want)
+	}
+
+	// A column of NULLs only has its rows appended one by one
+	rows[0][3], rows[1][3] = nil, nil
+	b = &testBatch{columns: make(map[int]interface{})}
+	if err := appendColumns(b, 4, rows); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(b.rows, rows) || len(b.columns) > 0 {
+		t.Errorf("incorrect rows appended: got %v and columns %v want %v", b.rows, b.columns, rows)
+	}
+}
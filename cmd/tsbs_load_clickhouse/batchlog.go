@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// batchLogHeader is the header of the CSV of -batch-log-file, of a record per
+// insert of the rows of a table of a batch
+var batchLogHeader = []string{"time", "worker", "batch", "table", "rows", "metrics", "took_seconds", "rows_per_sec", "metrics_per_sec"}
+
+// batchLogFlushSize is the size of the records a worker buffers before it
+// writes them to -batch-log-file
+const batchLogFlushSize = 64 * 1024
+
+// batchLogWriter writes the records of -batch-log-file, which the batchLoggers
+// of the workers buffer, at once
+type batchLogWriter struct {
+	mutex sync.Mutex
+	out   io.Writer
+}
+
+// batchLogOut is the batchLogWriter of -batch-log-file, nil if not set
+var batchLogOut *batchLogWriter
+
+// newBatchLogWriter returns a batchLogWriter writing to out, having written the
+// header of its CSV
+func newBatchLogWriter(out io.Writer) (*batchLogWriter, error) {
+	w := csv.NewWriter(out)
+	w.Write(batchLogHeader)
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+	return &batchLogWriter{out: out}, nil
+}
+
+// write writes records, of a worker, at once
+func (w *batchLogWriter) write(records []byte) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	_, err := w.out.Write(records)
+	return err
+}
+
+// close closes the output, once the workers have written their records
+func (w *batchLogWriter) close() error {
+	if c, ok := w.out.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// batchLogger logs the inserts of the tables of the batches of a worker, on
+// stdout with -log-batches and into file as CSV if set. Each worker formats
+// and buffers its records on its own, for the workers not to wait for each
+// other but to write them.
+type batchLogger struct {
+	workerNum int
+	stdout    bool
+	file      *batchLogWriter
+	buf       bytes.Buffer
+	csv       *csv.Writer
+	record    []string
+}
+
+func newBatchLogger(workerNum int, stdout bool, file *batchLogWriter) *batchLogger {
+	l := &batchLogger{
+		workerNum: workerNum,
+		stdout:    stdout,
+		file:      file,
+		record:    make([]string, len(batchLogHeader)),
+	}
+	l.csv = csv.NewWriter(&l.buf)
+	return l
+}
+
+// log logs the insert of rows and metrics into table, of batch batchNum of
+// the worker, which took took, ending at end
+func (l *batchLogger) log(end time.Time, batchNum int, table string, rows int, metrics uint64, took time.Duration) {
+	var rowRate, metricRate float64
+	if took > 0 {
+		rowRate, metricRate = float64(rows)/took.Seconds(), float64(metrics)/took.Seconds()
+	}
+	if l.stdout {
+		fmt.Fprintf(os.Stdout, "BATCH: worker %d batch %d table %s: %d rows, %d metrics in %v (%.2f rows/sec, %.2f metrics/sec)\n",
+			l.workerNum, batchNum, table, rows, metrics, took, rowRate, metricRate)
+	}
+	if l.file == nil {
+		return
+	}
+	l.record[0] = end.Format(time.RFC3339Nano)
+	l.record[1] = strconv.Itoa(l.workerNum)
+	l.record[2] = strconv.Itoa(batchNum)
+	l.record[3] = table
+	l.record[4] = strconv.Itoa(rows)
+	l.record[5] = strconv.FormatUint(metrics, 10)
+	l.record[6] = strconv.FormatFloat(took.Seconds(), 'f', -1, 64)
+	l.record[7] = strconv.FormatFloat(rowRate, 'f', 2, 64)
+	l.record[8] = strconv.FormatFloat(metricRate, 'f', 2, 64)
+	l.csv.Write(l.record)
+	l.csv.Flush()
+	if l.buf.Len() >= batchLogFlushSize {
+		l.flush()
+	}
+}
+
+// flush writes the records buffered to the file, the load failing if it
+// cannot
+func (l *batchLogger) flush() {
+	if l == nil || l.file == nil || l.buf.Len() == 0 {
+		return
+	}
+	if err := l.file.write(l.buf.Bytes()); err != nil {
+		fatal("cannot write -batch-log-file: %v", err)
+		return
+	}
+	l.buf.Reset()
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestBatchLog(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	oldHost, oldPort := host, port
+	defer func() { host, port = oldHost, oldPort }()
+	serverURL, _ := url.Parse(server.URL)
+	host, port, _ = net.SplitHostPort(serverURL.Host)
+	tableCols["tags"] = []string{"hostname"}
+	for _, table := range []string{"cpu", "mem"} {
+		tableCols[table], tableColTypes[table] = splitColumnSpecs([]string{"a", "b"})
+	}
+	var out bytes.Buffer
+	w, err := newBatchLogWriter(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A small load of 4 batches by each of 3 workers, worker n inserting n+1
+	// cpu rows and 2 mem rows of each batch
+	const workers, batches = 3, 4
+	var metricCnt, rowCnt uint64
+	var wg sync.WaitGroup
+	for n := 0; n < workers; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			c, err := newHTTPConn(serverAddress(), true)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			p := &processor{http: c, csi: newSyncCSI(), workerNum: n, batchLog: newBatchLogger(n, false, w)}
+			for i := 0; i < batches; i++ {
+				b := &tableArr{m: map[string][]*insertData{}}
+				for j := 0; j <= n; j++ {
+					b.m["cpu"] = append(b.m["cpu"], &insertData{tags: fmt.Sprintf("hostname=host_%d_%d", n, j), fields: fmt.Sprintf("%d,1,2", 1451606400+i)})
+				}
+				for j := 0; j < 2; j++ {
+					b.m["mem"] = append(b.m["mem"], &insertData{tags: fmt.Sprintf("hostname=host_%d_%d", n, j), fields: fmt.Sprintf("%d,1,2", 1451606400+i)})
+				}
+				m, r := p.ProcessBatch(b, true)
+				atomic.AddUint64(&metricCnt, m)
+				atomic.AddUint64(&rowCnt, r)
+			}
+			// The records buffered are written once the worker is closed
+			p.Close(true)
+		}(n)
+	}
+	wg.Wait()
+
+	records, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) == 0 || !reflect.DeepEqual(records[0], batchLogHeader) {
+		t.Fatalf("incorrect header: got %v want %v", records, batchLogHeader)
+	}
+	records = records[1:]
+	if want := workers * batches * 2; len(records) != want {
+		t.Fatalf("incorrect number of records: got %d want %d", len(records), want)
+	}
+	// The totals of the records reconcile with the counts of the load
+	var loggedMetrics, loggedRows uint64
+	workerRows := make(map[string]uint64)
+	for _, r := range records {
+		rows, _ := strconv.ParseUint(r[4], 10, 64)
+		metrics, _ := strconv.ParseUint(r[5], 10, 64)
+		if metrics != 2*rows {
+			t.Errorf("incorrect metrics of record %v: got %d want %d", r, metrics, 2*rows)
+		}
+		if rate, err := strconv.ParseFloat(r[7], 64); err != nil || rate <= 0 {
+			t.Errorf("incorrect row rate of record %v", r)
+		}
+		loggedMetrics += metrics
+		loggedRows += rows
+		workerRows[r[1]] += rows
+	}
+	if loggedMetrics != metricCnt || loggedRows != rowCnt {
+		t.Errorf("incorrect totals: got %d metrics and %d rows want %d and %d", loggedMetrics, loggedRows, metricCnt, rowCnt)
+	}
+	if want := map[string]uint64{"0": 12, "1": 16, "2": 20}; !reflect.DeepEqual(workerRows, want) {
+		t.Errorf("incorrect rows of the workers: got %v want %v", workerRows, want)
+	}
+}
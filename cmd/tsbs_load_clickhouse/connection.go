@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// connectionVars are the flags of the connection to ClickHouse that, unless
+// given, are read from their environment variable, else from the
+// -connection-file, where they are keyed by their name
+var connectionVars = []struct {
+	flag string
+	env  string
+}{
+	{flag: "host", env: "CLICKHOUSE_HOST"},
+	{flag: "port", env: "CLICKHOUSE_PORT"},
+	{flag: "user", env: "CLICKHOUSE_USER"},
+	{flag: "password", env: "CLICKHOUSE_PASSWORD"},
+}
+
+// resolveConnection returns the values of the connection flags that are not
+// among those given: that of their environment variable, as found by
+// lookupEnv, if set and not empty, else that of file. Flags without either
+// are left out, keeping their default.
+func resolveConnection(given map[string]bool, lookupEnv func(string) (string, bool), file map[string]string) map[string]string {
+	values := make(map[string]string)
+	for _, v := range connectionVars {
+		if given[v.flag] {
+			continue
+		}
+		if value, ok := lookupEnv(v.env); ok && len(value) > 0 {
+			values[v.flag] = value
+		} else if value, ok := file[v.flag]; ok {
+			values[v.flag] = value
+		}
+	}
+	return values
+}
+
+// parseConnectionFile parses a -connection-file: key=value lines, keyed by
+// connection flags, blank lines and those starting with # being skipped.
+// Errors do not quote the lines, which may hold the password.
+func parseConnectionFile(r io.Reader) (map[string]string, error) {
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %d: must be key=value", n)
+		}
+		key := strings.TrimSpace(parts[0])
+		known := false
+		for _, v := range connectionVars {
+			known = known || key == v.flag
+		}
+		if !known {
+			return nil, fmt.Errorf("line %d: unknown key '%s'", n, key)
+		}
+		if _, ok := values[key]; ok {
+			return nil, fmt.Errorf("line %d: %s is given twice", n, key)
+		}
+		values[key] = strings.TrimSpace(parts[1])
+	}
+	return values, scanner.Err()
+}
+
+// readConnectionFile reads the -connection-file named fileName, warning if
+// other users may read it
+func readConnectionFile(fileName string) (map[string]string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if info, err := file.Stat(); err == nil && info.Mode().Perm()&0077 != 0 {
+		log.Printf("warning: connection file %s may be read by other users, its mode being %v", fileName, info.Mode().Perm())
+	}
+	values, err := parseConnectionFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+	return values, nil
+}
+
+// passwordParamRegexp matches the password parameter of connect strings
+var passwordParamRegexp = regexp.MustCompile(`([?&]password=)[^&]*`)
+
+// redactConnectString returns connectString with its password, if any,
+// replaced, for it to be printed
+func redactConnectString(connectString string) string {
+	return passwordParamRegexp.ReplaceAllString(connectString, "${1}xxxxx")
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveConnection(t *testing.T) {
+	env := map[string]string{
+		"CLICKHOUSE_HOST":     "env-host",
+		"CLICKHOUSE_USER":     "env-user",
+		"CLICKHOUSE_PASSWORD": "",
+	}
+	lookupEnv := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+	file := map[string]string{"host": "file-host", "port": "9001", "password": "file-password"}
+
+	cases := []struct {
+		desc  string
+		given map[string]bool
+		env   func(string) (string, bool)
+		file  map[string]string
+		want  map[string]string
+	}{
+		{
+			desc: "nothing set",
+			env:  func(string) (string, bool) { return "", false },
+			want: map[string]string{},
+		},
+		{
+			desc: "environment",
+			env:  lookupEnv,
+			want: map[string]string{"host": "env-host", "user": "env-user"},
+		},
+		{
+			desc: "file",
+			env:  func(string) (string, bool) { return "", false },
+			file: file,
+			want: map[string]string{"host": "file-host", "port": "9001", "password": "file-password"},
+		},
+		{
+			desc: "environment over file, empty variables unset",
+			env:  lookupEnv,
+			file: file,
+			want: map[string]string{"host": "env-host", "port": "9001", "user": "env-user", "password": "file-password"},
+		},
+		{
+			desc:  "flags over environment and file",
+			given: map[string]bool{"host": true, "password": true},
+			env:   lookupEnv,
+			file:  file,
+			want:  map[string]string{"port": "9001", "user": "env-user"},
+		},
+	}
+	for _, c := range cases {
+		if got := resolveConnection(c.given, c.env, c.file); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: incorrect values: got %v want %v", c.desc, got, c.want)
+		}
+	}
+}
+
+func TestParseConnectionFile(t *testing.T) {
+	cases := []struct {
+		desc    string
+		content string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			desc:    "all keys",
+			content: "# ClickHouse\nhost = ch1\nport=9440\n\nuser=tsbs\npassword= s3cr=t&# \n",
+			want:    map[string]string{"host": "ch1", "port": "9440", "user": "tsbs", "password": "s3cr=t&#"},
+		},
+		{desc: "empty", content: "", want: map[string]string{}},
+		{desc: "not key=value", content: "host=ch1\nhunter2\n", wantErr: "line 2: must be key=value"},
+		{desc: "unknown key", content: "database=benchmark\n", wantErr: "line 1: unknown key 'database'"},
+		{desc: "key given twice", content: "user=a\nuser=b\n", wantErr: "line 2: user is given twice"},
+	}
+	for _, c := range cases {
+		got, err := parseConnectionFile(strings.NewReader(c.content))
+		if len(c.wantErr) > 0 {
+			if err == nil || err.Error() != c.wantErr {
+				t.Errorf("%s: incorrect error: got %v want %s", c.desc, err, c.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.desc, err)
+		} else if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: incorrect values: got %v want %v", c.desc, got, c.want)
+		}
+	}
+}
+
+func TestRedactConnectString(t *testing.T) {
+	oldUser, oldPassword, oldHost, oldPort := user, password, host, port
+	defer func() { user, password, host, port = oldUser, oldPassword, oldHost, oldPort }()
+	host, port, user, password = "localhost", "9000", "tsbs", "s3cr=t&x y"
+
+	connectString := getConnectString(true)
+	if want := "tcp://localhost:9000?username=tsbs&password=s3cr%3Dt%26x+y&database=benchmark"; connectString != want {
+		t.Errorf("incorrect connect string: got %s want %s", connectString, want)
+	}
+	want := "tcp://localhost:9000?username=tsbs&password=xxxxx&database=benchmark"
+	if got := redactConnectString(connectString); got != want {
+		t.Errorf("incorrect redacted connect string: got %s want %s", got, want)
+	}
+	if got, want := redactConnectString("tcp://localhost:9000?password=a"), "tcp://localhost:9000?password=xxxxx"; got != want {
+		t.Errorf("incorrect redacted connect string: got %s want %s", got, want)
+	}
+}
@@ -2,12 +2,68 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/kshvakov/clickhouse"
+	"github.com/timescale/tsbs/cmd/tsbs_generate_data/serialize"
 )
 
+// ClickHouse types of metrics columns
+const (
+	columnTypeFloat64 = "Float64"
+	columnTypeInt64   = "Int64"
+	columnTypeUInt64  = "UInt64"
+	columnTypeString  = "String"
+	columnTypeBool    = "UInt8"
+)
+
+// singleTableName is the table holding the rows of all the metrics tables in
+// a single table (see singleTable), whose measurementColumn tells which
+const (
+	singleTableName   = "metrics"
+	measurementColumn = "measurement"
+)
+
+// columnTimeNanos is the column of metrics tables holding the nanoseconds of
+// their time if created_at is a DateTime (see timePrecision)
+const columnTimeNanos = "created_at_ns"
+
+// ttlActionDelete is the TTL action deleting expired rows
+const ttlActionDelete = "DELETE"
+
+// ttlActionRegexp matches the TTL actions rows can be given: being deleted or
+// moved to another volume or disk
+var ttlActionRegexp = regexp.MustCompile(`^(DELETE|TO (VOLUME|DISK) '[^'\\]+')$`)
+
+// projectionNameRegexp matches the names projections can be given
+var projectionNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// minProjectionsVersion is the first version of ClickHouse with projections
+var minProjectionsVersion = []int{21, 6}
+
+// dbEngineRegexp matches database engines: a name with optional parameters,
+// e.g., Replicated('/clickhouse/databases/tsbs', '{shard}', '{replica}')
+var dbEngineRegexp = regexp.MustCompile(`^[A-Za-z]+(\(.*\))?$`)
+
+// defaultIndexGranularity is the number of rows between the marks of the
+// primary index of each table, unless -index-granularity says otherwise
+const defaultIndexGranularity = 8192
+
 // loader.DBCreator interface implementation
 type dbCreator struct {
 	tags    string
@@ -18,7 +74,76 @@ type dbCreator struct {
 // loader.DBCreator interface implementation
 func (d *dbCreator) Init() {
 	br := loader.GetBufferedReader()
-	d.readDataHeader(br)
+	if len(schemaFile) == 0 {
+		d.readDataHeader(br)
+		return
+	}
+	file, err := os.Open(schemaFile)
+	if err != nil {
+		fatal("cannot open schema file: %v", err)
+		return
+	}
+	defer file.Close()
+	schema, err := serialize.ReadSchema(file)
+	if err != nil {
+		fatal("%s: %v", schemaFile, err)
+		return
+	}
+	d.readSchema(schema, br)
+}
+
+// readSchema fills dbCreator struct with the tables described by a schema
+// file. If the data starts with a header anyway, it must describe the same
+// tables.
+func (d *dbCreator) readSchema(schema *serialize.Schema, br *bufio.Reader) {
+	for _, m := range schema.Measurements {
+		for _, c := range m.Columns {
+			switch c.Type {
+			case "", "float64", "int64", "uint64", "string", "bool":
+			default:
+				fatal("schema column %s.%s has unsupported type '%s'", m.Name, c.Name, c.Type)
+				return
+			}
+		}
+	}
+	// The schema is turned into the header it stands for, so the tables are
+	// then created the same way whichever describes them
+	lines := strings.Split(strings.TrimSpace(string(schema.Header())), "\n")
+	tags, cols := lines[0], lines[1:]
+
+	if dataHeader {
+		d.readDataHeader(br)
+		if err := checkDataHeader(tags, cols, d.tags, d.cols); err != nil {
+			fatal("schema file does not match data: %v", err)
+			return
+		}
+	}
+	d.tags, d.cols = tags, cols
+}
+
+// checkDataHeader returns an error describing the first difference between
+// the tables in a schema and those in a data header, both given as header lines.
+func checkDataHeader(schemaTags string, schemaCols []string, headerTags string, headerCols []string) error {
+	if schemaTags != headerTags {
+		return fmt.Errorf("tags are '%s' in schema but '%s' in data header", schemaTags, headerTags)
+	}
+	if len(schemaCols) != len(headerCols) {
+		return fmt.Errorf("schema has %d tables but data header has %d", len(schemaCols), len(headerCols))
+	}
+	for i := range schemaCols {
+		schemaSpec := strings.Split(schemaCols[i], ",")
+		headerSpec := strings.Split(headerCols[i], ",")
+		if schemaSpec[0] != headerSpec[0] {
+			return fmt.Errorf("table %d is '%s' in schema but '%s' in data header", i, schemaSpec[0], headerSpec[0])
+		}
+		schemaNames, schemaTypes := splitColumnSpecs(schemaSpec[1:])
+		headerNames, headerTypes := splitColumnSpecs(headerSpec[1:])
+		if !reflect.DeepEqual(schemaNames, headerNames) || !reflect.DeepEqual(schemaTypes, headerTypes) {
+			return fmt.Errorf("columns of table '%s' are '%s' in schema but '%s' in data header",
+				schemaSpec[0], strings.Join(schemaSpec[1:], ","), strings.Join(headerSpec[1:], ","))
+		}
+	}
+	return nil
 }
 
 // readDataHeader fills dbCreator struct with data structure (tables description)
@@ -36,57 +161,130 @@ func (d *dbCreator) readDataHeader(br *bufio.Reader) {
 	// cpu,usage_user,usage_system,usage_idle,usage_nice,usage_iowait,usage_irq,usage_softirq,usage_steal,usage_guest,usage_guest_nice
 	// disk,total,free,used,used_percent,inodes_total,inodes_free,inodes_used
 	// nginx,accepts,active,handled,reading,requests,waiting,writing
+	//
+	// Column names may be followed by a type, as in 'nginx,accepts:uint64,active:int64',
+	// in which case an integer column is created instead of a Float64 one. Columns
+	// of type string and bool are created as String and UInt8 columns.
+
+	tags, cols, err := parseDataHeader(br)
+	if err != nil {
+		fatal("input has wrong header format: %v", err)
+		return
+	}
+	d.tags, d.cols = tags, cols
+	// The data follows the header and its blank line
+	dataHeaderLines = len(cols) + 2
+}
 
-	i := 0
-	for {
-		var err error
-		var line string
+// compressedInputs are the magic numbers of the compressed files that may be
+// given instead of the data they hold, the commands decompressing them and
+// whether -file decompresses them as it reads them
+var compressedInputs = []struct {
+	format, magic, command string
+	file                   bool
+}{
+	{"gzip", "\x1f\x8b", "gunzip -c", true},
+	{"zstd", "\x28\xb5\x2f\xfd", "zstd -dc", true},
+	{"bzip2", "BZh", "bunzip2 -c", false},
+	{"xz", "\xfd7zXZ\x00", "xz -dc", false},
+}
 
-		if i == 0 {
-			// read first line - list of tags
-			d.tags, err = br.ReadString('\n')
-			if err != nil {
-				fatal("input has wrong header format: %v", err)
+// headerSniffLen is the length of the start of the input checked for being
+// compressed or binary
+const headerSniffLen = 512
+
+// parseDataHeader reads the header at the start of the input of br up to the
+// blank line ending it, returning its line of tags and those of its tables,
+// or an error naming the line that is wrong and why
+func parseDataHeader(br *bufio.Reader) (tags string, cols []string, err error) {
+	start, _ := br.Peek(headerSniffLen)
+	if len(start) == 0 {
+		return "", nil, fmt.Errorf("input is empty")
+	}
+	for _, c := range compressedInputs {
+		if bytes.HasPrefix(start, []byte(c.magic)) {
+			if c.file {
+				return "", nil, fmt.Errorf("input is %s-compressed, load it with -file or decompress it first, e.g., with %s", c.format, c.command)
 			}
-			d.tags = strings.TrimSpace(d.tags)
-		} else {
-			// read the second and further lines - metrics descriptions
-			line, err = br.ReadString('\n')
-			if err != nil {
-				fatal("input has wrong header format: %v", err)
+			return "", nil, fmt.Errorf("input is %s-compressed, decompress it first, e.g., with %s", c.format, c.command)
+		}
+	}
+	if bytes.IndexByte(start, 0) >= 0 {
+		return "", nil, fmt.Errorf("input is binary, not the data of tsbs_generate_data -format clickhouse")
+	}
+
+	tables := make(map[string]int)
+	for n := 1; ; n++ {
+		line, err := br.ReadString('\n')
+		if err == io.EOF {
+			return "", nil, fmt.Errorf("line %d: input ends before the blank line ending the header, after '%s'", n, strings.TrimSpace(line))
+		} else if err != nil {
+			return "", nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		line = strings.TrimSpace(line)
+		parts := strings.Split(line, ",")
+		name := parts[0]
+
+		if n == 1 {
+			if name != tagsPrefix {
+				return "", nil, fmt.Errorf("line 1: '%s' does not start with the tags, as 'tags,hostname,region' does: is it the data of tsbs_generate_data -format clickhouse?", line)
 			}
-			line = strings.TrimSpace(line)
-			if len(line) == 0 {
-				// empty line - end of header
-				break
+			if err := checkHeaderColumns(parts); err != nil {
+				return "", nil, fmt.Errorf("line 1: %v: '%s'", err, line)
+			}
+			tags = line
+			continue
+		}
+		if len(line) == 0 {
+			if len(cols) == 0 {
+				return "", nil, fmt.Errorf("line %d: header ends without describing any table", n)
 			}
-			// append new table/columns set to the list of tables/columns set
-			d.cols = append(d.cols, line)
+			return tags, cols, nil
+		}
+		switch {
+		case name == tagsPrefix:
+			return "", nil, fmt.Errorf("line %d: '%s' follows the tables: the header must end with a blank line", n, line)
+		case len(name) == 0:
+			return "", nil, fmt.Errorf("line %d: table has no name: '%s'", n, line)
+		case tables[name] > 0:
+			return "", nil, fmt.Errorf("line %d: table '%s' is described twice, on lines %d and %d", n, name, tables[name], n)
+		}
+		if err := checkHeaderColumns(parts); err != nil {
+			return "", nil, fmt.Errorf("line %d: %v: '%s'", n, err, line)
 		}
-		i++
+		tables[name] = n
+		cols = append(cols, line)
 	}
 }
 
+// checkHeaderColumns returns an error if the line of the header split into
+// parts, a table or the tags followed by their columns, has no columns or
+// columns without a name
+func checkHeaderColumns(parts []string) error {
+	if len(parts) < 2 {
+		return fmt.Errorf("%s has no columns", parts[0])
+	}
+	for i, spec := range parts[1:] {
+		if name := strings.SplitN(spec, ":", 2)[0]; len(strings.TrimSpace(name)) == 0 {
+			return fmt.Errorf("column %d of %s has no name", i+1, parts[0])
+		}
+	}
+	return nil
+}
+
 // loader.DBCreator interface implementation
 func (d *dbCreator) DBExists(dbName string) bool {
-	db := sqlx.MustConnect(dbType, getConnectString(false))
+	db := connect(false)
 	defer db.Close()
 
-	sql := fmt.Sprintf("SELECT name, engine FROM system.databases WHERE name = '%s'", dbName)
-	if debug > 0 {
-		fmt.Printf(sql)
-	}
-	var rows []struct {
-		Name   string `db:"name"`
-		Engine string `db:"engine"`
-	}
-
-	err := db.Select(&rows, sql)
+	sql := databaseExistsSQL(dbName)
+	debugLog.printf(1, "%s", sql)
+	names, err := db.queryStrings(sql)
 	if err != nil {
 		panic(err)
 	}
-	for _, row := range rows {
-		if row.Name == dbName {
+	for _, name := range names {
+		if name == dbName {
 			return true
 		}
 	}
@@ -96,26 +294,77 @@ func (d *dbCreator) DBExists(dbName string) bool {
 
 // loader.DBCreator interface implementation
 func (d *dbCreator) RemoveOldDB(dbName string) error {
-	// We do not want to drop DB
-	return nil
+	// We do not want to drop DB, unless its tables are replicated: those of a
+	// previous run would otherwise be reused along with their replication
+	// metadata, which may not match the tables to create. The engine of an
+	// existing database cannot be changed either.
+	if !replicated && len(dbEngine) == 0 {
+		return nil
+	}
+	db := connect(false)
+	defer db.Close()
+
+	sql := dropDatabaseSQL(dbName)
+	debugLog.printf(1, "%s", sql)
+	_, err := db.Exec(sql)
+	return err
 }
 
 // loader.DBCreator interface implementation
 func (d *dbCreator) CreateDB(dbName string) error {
-	// Connect to ClickHouse in general and CREATE DATABASE
-	db := sqlx.MustConnect(dbType, getConnectString(false))
-	sql := fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", dbName)
-	_, err := db.Exec(sql)
+	createDB, statements, err := d.schemaStatements(dbName)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	if len(ddlFile) > 0 {
+		if err := writeDDLFile(ddlFile, dbName, createDB, statements); err != nil {
+			return err
+		}
+		// The schema is only written down then
+		if createSchemaOnly {
+			return nil
+		}
+	}
+
+	// Connect to ClickHouse in general and CREATE DATABASE
+	db := connect(false)
+	execStatements(db, []string{createDB})
 	db.Close()
 	db = nil
 
 	// Connect to specified database within ClickHouse
-	db = sqlx.MustConnect(dbType, getConnectString(true))
+	db = connect(true)
 	defer db.Close()
 
+	if len(projections) > 0 {
+		if err := checkProjectionsSupport(db); err != nil {
+			return err
+		}
+	}
+	execStatements(db, statements)
+	return nil
+}
+
+// schemaStatements returns the statements CreateDB runs: the CREATE DATABASE
+// statement of dbName, run without a database, then those creating its tables
+// and emptying them of the rows of a previous run, run within it. The metrics
+// tables are checked first, and tableCols and tableColTypes filled with their
+// columns.
+func (d *dbCreator) schemaStatements(dbName string) (string, []string, error) {
+	// d.cols content are lines (metrics descriptions) as:
+	// cpu,usage_user,usage_system,usage_idle,usage_nice,usage_iowait,usage_irq,usage_softirq,usage_steal,usage_guest,usage_guest_nice
+	// disk,total,free,used,used_percent,inodes_total,inodes_free,inodes_used
+	// nginx,accepts,active,handled,reading,requests,waiting,writing
+	// generalised description:
+	// tableName,fieldName1,...,fieldNameX
+	tableSpecs := make([][]string, 0, len(d.cols))
+	for _, cols := range d.cols {
+		tableSpecs = append(tableSpecs, strings.Split(strings.TrimSpace(cols), ","))
+	}
+	if singleTable {
+		tableSpecs = [][]string{singleTableSpec(tableSpecs)}
+	}
+
 	// d.tags content:
 	//tags,hostname,region,datacenter,rack,os,arch,team,service,service_version,service_environment
 	//
@@ -126,40 +375,249 @@ func (d *dbCreator) CreateDB(dbName string) error {
 	// so we'll use tags[1:] for tags specification
 	parts := strings.Split(strings.TrimSpace(d.tags), ",")
 	if parts[0] != "tags" {
-		return fmt.Errorf("input header in wrong format. got '%s', expected 'tags'", parts[0])
+		return "", nil, fmt.Errorf("input header in wrong format. got '%s', expected 'tags'", parts[0])
 	}
-	createTagsTable(db, parts[1:])
+
+	// The sorting key of each metrics table is checked before anything is
+	// created
+	for _, tableSpec := range tableSpecs {
+		columns := metricsColumnNames(tableSpec[1:], parts[1:])
+		if err := checkOrderBy(tableSpec[0], sortingKey(parts[1:]), columns); err != nil {
+			return "", nil, err
+		}
+	}
+
+	var statements []string
 	tableCols["tags"] = parts[1:]
+	if !denormalizeTags {
+		statements = append(statements, tagsTableStatements(parts[1:])...)
+		// Tags rows are sharded by their id as metrics rows are by default,
+		// so that they are found on the same shards
+		statements = append(statements, distTableStatements(dbName, "tags", "id")...)
+	}
 
-	// d.cols content are lines (metrics descriptions) as:
-	// cpu,usage_user,usage_system,usage_idle,usage_nice,usage_iowait,usage_irq,usage_softirq,usage_steal,usage_guest,usage_guest_nice
-	// disk,total,free,used,used_percent,inodes_total,inodes_free,inodes_used
-	// nginx,accepts,active,handled,reading,requests,waiting,writing
-	// generalised description:
-	// tableName,fieldName1,...,fieldNameX
-	for _, cols := range d.cols {
-		// cols content:
+	for _, tableSpec := range tableSpecs {
+		// tableSpec content:
 		// cpu,usage_user,usage_system,usage_idle,usage_nice,usage_iowait,usage_irq,usage_softirq,usage_steal,usage_guest,usage_guest_nice
-		createMetricsTable(db, strings.Split(strings.TrimSpace(cols), ","))
+		statements = append(statements, metricsTableStatements(tableSpec)...)
+		statements = append(statements, distTableStatements(dbName, tableSpec[0], metricsShardingKey(parts[1:]))...)
+		if rollupInterval > 0 {
+			statements = append(statements, rollupStatements(dbName, tableSpec[0], metricsShardingKey(parts[1:]))...)
+		}
 	}
 
+	return createDatabaseSQL(dbName), statements, nil
+}
+
+// writeDDLFile writes the statements creating database dbName (see
+// schemaStatements) to the file named fileName
+func writeDDLFile(fileName, dbName, createDB string, statements []string) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("cannot create DDL file: %v", err)
+	}
+	if err := writeDDL(file, dbName, createDB, statements); err != nil {
+		file.Close()
+		return fmt.Errorf("cannot write DDL file: %v", err)
+	}
+	return file.Close()
+}
+
+// writeDDL writes the statements creating database dbName (see
+// schemaStatements) to w, each ended by a semicolon, as a script
+// clickhouse-client --multiquery can run. A USE statement stands for the
+// connection to the database the statements of the tables are run on.
+func writeDDL(w io.Writer, dbName, createDB string, statements []string) error {
+	all := append([]string{createDB, "USE " + dbName}, statements...)
+	for _, sql := range all {
+		if _, err := fmt.Fprintf(w, "%s;\n\n", strings.TrimSpace(sql)); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// execer runs statements, as sqlx.DB does
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// execStatements runs statements on db, in order
+func execStatements(db execer, statements []string) {
+	for _, sql := range statements {
+		debugLog.printf(1, "%s", sql)
+		_, err := db.Exec(sql)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
 func (d *dbCreator) PostCreateDB(dbName string) error {
 	parts := strings.Split(strings.TrimSpace(d.tags), ",")
 	tableCols["tags"] = parts[1:]
 
 	for _, cols := range d.cols {
 		parts := strings.Split(strings.TrimSpace(cols), ",")
-		tableCols[parts[0]] = parts[1:]
+		tableCols[parts[0]], tableColTypes[parts[0]] = splitColumnSpecs(parts[1:])
+	}
+
+	// New tags rows follow those of a previous load when appending to it, but
+	// for -dedup-token-prefix, whose ids are the same on every run
+	if !denormalizeTags && len(dedupTokenPrefix) == 0 {
+		db := connect(true)
+		err := readLastTagsID(db)
+		db.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	// The rows the tables hold before the load are those of a previous load
+	// when appending to it
+	if postLoadVerify {
+		db := connect(true)
+		err := readInitialRows(db)
+		db.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if materializeProjections {
+		return materializeTableProjections(d.cols)
+	}
+	return nil
+}
+
+// materializeTableProjections adds the projections to the metrics tables of
+// the given column descriptions, unless they have them already, and
+// materializes them in their existing parts. This runs as mutations in the
+// background.
+func materializeTableProjections(cols []string) error {
+	db := connect(true)
+	defer db.Close()
+	if err := checkProjectionsSupport(db); err != nil {
+		return err
+	}
+
+	tableNames := []string{singleTableName}
+	if !singleTable {
+		tableNames = tableNames[:0]
+		for _, c := range cols {
+			tableNames = append(tableNames, strings.SplitN(strings.TrimSpace(c), ",", 2)[0])
+		}
 	}
+	for _, tableName := range tableNames {
+		for _, p := range projections {
+			for _, sql := range []string{
+				fmt.Sprintf("ALTER TABLE %s%s ADD PROJECTION IF NOT EXISTS %s (%s)", tableName, onCluster(), p.name, p.query),
+				fmt.Sprintf("ALTER TABLE %s%s MATERIALIZE PROJECTION %s", tableName, onCluster(), p.name),
+			} {
+				debugLog.printf(1, "%s", sql)
+				if _, err := db.Exec(sql); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
 
+// readLastTagsID sets lastTagsID to the largest id of the tags table of db,
+// that of the Distributed table on a cluster, or 0 if it is empty
+func readLastTagsID(db schemaConn) error {
+	table := "tags"
+	if len(cluster) > 0 {
+		table += distTableSuffix
+	}
+	values, err := db.queryStrings(fmt.Sprintf("SELECT toString(max(id)) FROM %s", table))
+	if err != nil {
+		return fmt.Errorf("cannot read the last id of %s: %v", table, err)
+	}
+	if len(values) != 1 {
+		return fmt.Errorf("cannot read the last id of %s: got %d rows", table, len(values))
+	}
+	id, err := strconv.ParseUint(values[0], 10, 32)
+	if err != nil {
+		return fmt.Errorf("cannot read the last id of %s: %v", table, err)
+	}
+	atomic.StoreUint32(&lastTagsID, uint32(id))
 	return nil
 }
 
 // createTagsTable builds CREATE TABLE SQL statement and runs it
 func createTagsTable(db *sqlx.DB, tags []string) {
+	execStatements(db, tagsTableStatements(tags))
+}
+
+// tagsTableStatements returns the statements creating the tags table, emptied
+// of the rows of a previous run
+func tagsTableStatements(tags []string) []string {
+	return []string{tagsTableSQL(tags), truncateTableSQL("tags")}
+}
+
+// createMetricsTable builds CREATE TABLE SQL statement and runs it
+func createMetricsTable(db *sqlx.DB, tableSpec []string) {
+	execStatements(db, metricsTableStatements(tableSpec))
+}
+
+// metricsTableStatements returns the statements creating the metrics table of
+// tableSpec, emptied of the rows of a previous run, filling tableCols and
+// tableColTypes with its columns
+func metricsTableStatements(tableSpec []string) []string {
+	// tableSpec contain
+	// 0: table name
+	// 1: table column spec 1
+	// N: table column spec N
+
+	// Ex.: cpu OR disk OR nginx
+	tableName := tableSpec[0]
+	tableCols[tableName], tableColTypes[tableName] = splitColumnSpecs(tableSpec[1:])
+
+	indexes := getIndexDefinitions(tableCols[tableName])
+	for _, p := range projections {
+		indexes = append(indexes, p.definition())
+	}
+	sql := metricsTableSQL(tableName, getColumnDefinitions(tableSpec[1:]), indexes)
+	return []string{sql, truncateTableSQL(tableName)}
+}
+
+// createDistTable creates the Distributed table over the local tables of
+// tableName in database dbName across the cluster, if there is one
+func createDistTable(db *sqlx.DB, dbName, tableName, shardingKey string) {
+	execStatements(db, distTableStatements(dbName, tableName, shardingKey))
+}
+
+// distTableStatements returns the statements creating the Distributed table
+// over the local tables of tableName, none if there is no cluster
+func distTableStatements(dbName, tableName, shardingKey string) []string {
+	if len(cluster) == 0 {
+		return nil
+	}
+	return []string{distTableSQL(dbName, tableName, shardingKey)}
+}
+
+// createRollup creates the rollup table of the metrics table tableName along
+// with the materialized view feeding it, and its Distributed table if there
+// is a cluster
+func createRollup(db *sqlx.DB, dbName, tableName, shardingKey string) {
+	execStatements(db, rollupStatements(dbName, tableName, shardingKey))
+}
+
+// rollupStatements returns the statements of createRollup
+func rollupStatements(dbName, tableName, shardingKey string) []string {
+	statements := []string{
+		rollupTableSQL(tableName),
+		rollupViewSQL(tableName),
+		truncateTableSQL(rollupTableName(tableName)),
+	}
+	return append(statements, distTableStatements(dbName, rollupTableName(tableName), shardingKey)...)
+}
+
+// tagsTableSQL returns the CREATE TABLE statement of the tags table, with a
+// String column for each tag
+func tagsTableSQL(tags []string) string {
 	// prepare COLUMNs specification for CREATE TABLE statement
 	// all columns would be of type String
 	cols := strings.Join(tags, " String,\n ")
@@ -169,97 +627,1098 @@ func createTagsTable(db *sqlx.DB, tags []string) {
 	//index := strings.Join(tags, ","	)
 	index := "id"
 
-	sql := fmt.Sprintf(`
-		CREATE TABLE IF NOT EXISTS tags(
+	return fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS tags%s(
 			created_date Date     DEFAULT today(),
 			created_at   DateTime DEFAULT now(),
 			id           UInt32,
 			%s
-		) ENGINE = MergeTree(created_date, (%s), 8192)
+		) %s
 		`,
+		onCluster(),
 		cols,
-		index)
-	if debug > 0 {
-		fmt.Printf(sql)
+		tagsEngine.clause("tags", tableLayout{dateColumn: "created_date", orderBy: index}))
+}
+
+// additionalTagsColumnDefinition returns the definition of the additional_tags
+// column of metrics tables, a JSON string unless additionalTagsMap is set
+func additionalTagsColumnDefinition() string {
+	if additionalTagsMap {
+		return "additional_tags Map(String, String)"
 	}
-	_, err := db.Exec(sql)
+	return "additional_tags String   DEFAULT ''"
+}
+
+// metricsTableSQL returns the CREATE TABLE statement of a metrics table, with
+// the given column definitions and index and projection definitions (see
+// getColumnDefinitions, getIndexDefinitions and projection.definition)
+func metricsTableSQL(tableName string, columnsWithType []string, indexes []string) string {
+	measurementSQL := ""
+	if singleTable {
+		measurementSQL = "\n" + measurementColumn + " LowCardinality(String),"
+	}
+	indexesSQL := ""
+	if len(indexes) > 0 {
+		indexesSQL = ",\n" + strings.Join(indexes, ",\n")
+	}
+	return fmt.Sprintf(`
+			CREATE TABLE IF NOT EXISTS %s%s (
+				created_date    Date     DEFAULT toDate(created_at),
+				%s,%s
+				%s,
+				%s,
+				%s%s
+			) %s
+			`,
+		tableName,
+		onCluster(),
+		strings.Join(timeColumnDefinitions(), ","),
+		measurementSQL,
+		strings.Join(tagsColumnDefinitions(), ","),
+		strings.Join(columnsWithType, ","),
+		additionalTagsColumnDefinition(),
+		indexesSQL,
+		metricsEngine.clause(tableName, tableLayout{
+			dateColumn:  "created_date",
+			partitionBy: partitionExpression("created_date", chunkTime),
+			orderBy:     strings.Join(sortingKey(tableCols["tags"]), ", "),
+			ttl:         ttlExpression(),
+		}))
+}
+
+// rollupFunctions are the aggregate functions whose states rollup tables hold
+// for each metrics column
+var rollupFunctions = []string{"avg", "min", "max"}
+
+// rollupTableName returns the name of the rollup table of tableName, e.g.,
+// cpu_rollup_1h
+func rollupTableName(tableName string) string {
+	n, u := largestIntervalUnit(rollupInterval)
+	return fmt.Sprintf("%s_rollup_%d%s", tableName, n, u.abbrev)
+}
+
+// rollupKeyColumns returns the columns, along with the time bucket, that the
+// rows of metrics tables are rolled up by: the measurement with a single
+// table, then tags_id or the tags with denormalized tags
+func rollupKeyColumns() []string {
+	var columns []string
+	if singleTable {
+		columns = append(columns, measurementColumn)
+	}
+	if denormalizeTags {
+		return append(columns, tableCols["tags"]...)
+	}
+	return append(columns, "tags_id")
+}
+
+// rollupTableSQL returns the CREATE TABLE statement of the rollup table of
+// tableName, holding the states of rollupFunctions of each of its numeric
+// columns per rollupKeyColumns and rollupInterval
+func rollupTableSQL(tableName string) string {
+	var columns []string
+	if singleTable {
+		columns = append(columns, measurementColumn+" LowCardinality(String)")
+	}
+	columns = append(columns, tagsColumnDefinitions()...)
+	colTypes := tableColTypes[tableName]
+	for i, column := range tableCols[tableName] {
+		if len(column) == 0 || colTypes[i] == columnTypeString {
+			continue
+		}
+		colType := colTypes[i]
+		if nullableFields {
+			colType = "Nullable(" + colType + ")"
+		}
+		for _, f := range rollupFunctions {
+			columns = append(columns, fmt.Sprintf("%s_%s AggregateFunction(%s, %s)", column, f, f, colType))
+		}
+	}
+	rollupName := rollupTableName(tableName)
+	return fmt.Sprintf(`
+			CREATE TABLE IF NOT EXISTS %s%s (
+				created_date Date     DEFAULT toDate(bucket),
+				bucket       DateTime,
+				%s
+			) %s
+			`,
+		rollupName,
+		onCluster(),
+		strings.Join(columns, ",\n"),
+		mergeTreeEngine{name: engineAggregatingMergeTree}.clause(rollupName, tableLayout{
+			dateColumn:  "created_date",
+			partitionBy: partitionExpression("created_date", chunkTime),
+			orderBy:     strings.Join(append(rollupKeyColumns(), "bucket"), ", "),
+		}))
+}
+
+// rollupViewSQL returns the CREATE MATERIALIZED VIEW statement of the view
+// rolling up the rows inserted into tableName into its rollup table
+func rollupViewSQL(tableName string) string {
+	keys := rollupKeyColumns()
+	columns := append([]string{}, keys...)
+	colTypes := tableColTypes[tableName]
+	for i, column := range tableCols[tableName] {
+		if len(column) == 0 || colTypes[i] == columnTypeString {
+			continue
+		}
+		for _, f := range rollupFunctions {
+			columns = append(columns, fmt.Sprintf("%sState(%s) AS %s_%s", f, column, column, f))
+		}
+	}
+	// The time bucket is a DateTime whatever the precision of created_at
+	rollupName := rollupTableName(tableName)
+	return fmt.Sprintf(`
+			CREATE MATERIALIZED VIEW IF NOT EXISTS %s_mv%s TO %s AS
+			SELECT
+				toStartOfInterval(toDateTime(created_at), INTERVAL %s) AS bucket,
+				%s
+			FROM %s
+			GROUP BY bucket, %s
+			`,
+		rollupName,
+		onCluster(),
+		rollupName,
+		intervalSQL(rollupInterval),
+		strings.Join(columns, ",\n"),
+		tableName,
+		strings.Join(keys, ", "))
+}
+
+// tagsColumnDefinitions returns the definitions of the columns of metrics
+// tables referring to the tags of their rows: tags_id or, if denormalizeTags
+// is set, a column per tag.
+func tagsColumnDefinitions() []string {
+	if !denormalizeTags {
+		return []string{"tags_id UInt32"}
+	}
+	definitions := make([]string, 0, len(tableCols["tags"]))
+	for _, tag := range tableCols["tags"] {
+		definitions = append(definitions, tag+" LowCardinality(String)")
+	}
+	return definitions
+}
+
+// sortingKey returns the columns metrics tables are sorted by: those of
+// orderBy, tags_id standing for all the tag columns if denormalizeTags is set
+func sortingKey(tags []string) []string {
+	if !denormalizeTags {
+		return orderBy
+	}
+	key := make([]string, 0, len(orderBy)+len(tags))
+	for _, column := range orderBy {
+		if column == "tags_id" {
+			key = append(key, tags...)
+		} else {
+			key = append(key, column)
+		}
+	}
+	return key
+}
+
+// metricsShardingKey returns the sharding key of Distributed metrics tables:
+// shardingKey, tags_id standing for a hash of all the tag columns if
+// denormalizeTags is set
+func metricsShardingKey(tags []string) string {
+	if !denormalizeTags || shardingKey != "tags_id" {
+		return shardingKey
+	}
+	return fmt.Sprintf("cityHash64(%s)", strings.Join(tags, ", "))
+}
+
+// singleTableSpec returns the spec of the single table holding the rows of all
+// the metrics tables of tableSpecs: a column per column of each, named after
+// both (see singleTableColumn).
+func singleTableSpec(tableSpecs [][]string) []string {
+	spec := []string{singleTableName}
+	for _, tableSpec := range tableSpecs {
+		for _, column := range tableSpec[1:] {
+			if len(column) > 0 {
+				column = singleTableColumn(tableSpec[0], column)
+			}
+			spec = append(spec, column)
+		}
+	}
+	return spec
+}
+
+// singleTableColumn returns the name, or spec, of the column of the single
+// table holding column of tableName, e.g., cpu_usage_user
+func singleTableColumn(tableName, column string) string {
+	return tableName + "_" + column
+}
+
+// metricsColumnNames returns the names of all the columns of a metrics table
+// with the given column specs, tags being those of the tags table
+func metricsColumnNames(columnSpecs []string, tags []string) []string {
+	names := []string{"created_date"}
+	names = append(names, timeColumns()...)
+	if denormalizeTags {
+		names = append(names, tags...)
+	} else {
+		names = append(names, "tags_id")
+	}
+	names = append(names, "additional_tags")
+	if singleTable {
+		names = append(names, measurementColumn)
+	}
+	if inTableTag && len(tags) > 0 {
+		names = append(names, tags[0])
+	}
+	specNames, _ := splitColumnSpecs(columnSpecs)
+	return append(names, specNames...)
+}
+
+// checkOrderBy returns an error if a column of the sorting key is not one of
+// the columns of tableName
+func checkOrderBy(tableName string, sortingKey []string, columns []string) error {
+	for _, key := range sortingKey {
+		found := false
+		for _, column := range columns {
+			if column == key {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("cannot order table '%s' by '%s': no such column, must be one of %s",
+				tableName, key, strings.Join(columns, ","))
+		}
+	}
+	return nil
+}
+
+// timeColumnDefinitions returns the definitions of the columns holding the time
+// of metrics rows: created_at, a DateTime64 of timePrecision or, if it is 0, a
+// DateTime along with the nanoseconds within its second.
+func timeColumnDefinitions() []string {
+	if timePrecision == 0 {
+		return []string{
+			fmt.Sprintf("created_at DateTime Codec(%s)", codecs.time),
+			columnTimeNanos + " UInt32 Codec(ZSTD)",
+		}
+	}
+	return []string{fmt.Sprintf("created_at DateTime64(%d) Codec(%s)", timePrecision, codecs.time)}
+}
+
+// distTableSQL returns the CREATE TABLE statement of the Distributed table
+// over the local tables of tableName in database dbName, sharding rows by
+// shardingKey
+func distTableSQL(dbName, tableName, shardingKey string) string {
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s%s%s AS %s ENGINE = Distributed(%s, %s, %s, %s)",
+		tableName, distTableSuffix, onCluster(), tableName, cluster, dbName, tableName, shardingKey)
+}
+
+// createDatabaseSQL returns the CREATE DATABASE statement of dbName, on every
+// server of the cluster if there is one, with dbEngine if set
+func createDatabaseSQL(dbName string) string {
+	engine := ""
+	if len(dbEngine) > 0 {
+		engine = " ENGINE = " + dbEngine
+	}
+	return fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s%s%s", dbName, onCluster(), engine)
+}
+
+// dropDatabaseSQL returns the DROP DATABASE statement of dbName, on every
+// server of the cluster if there is one. SYNC waits for the replicas of its
+// tables to be removed from ZooKeeper, so the same paths can be used right
+// away by the tables created next.
+func dropDatabaseSQL(dbName string) string {
+	return fmt.Sprintf("DROP DATABASE IF EXISTS %s%s SYNC", dbName, onCluster())
+}
+
+// databaseExistsSQL returns the query of the name of dbName, on any replica
+// of the cluster if there is one
+func databaseExistsSQL(dbName string) string {
+	databases := "system.databases"
+	if len(cluster) > 0 {
+		databases = fmt.Sprintf("clusterAllReplicas(%s, system.databases)", quoteString(cluster))
+	}
+	return fmt.Sprintf("SELECT DISTINCT name FROM %s WHERE name = %s", databases, quoteString(dbName))
+}
+
+// onCluster returns the ON CLUSTER clause running DDL on every server of the
+// cluster, if there is one
+func onCluster() string {
+	if len(cluster) == 0 {
+		return ""
+	}
+	return " ON CLUSTER " + cluster
+}
+
+// insertTable returns the table rows of tableName are inserted into: its
+// Distributed table if there is one and inserts target it, else the local
+// table of the server connected to.
+func insertTable(tableName string) string {
+	if len(cluster) == 0 || insertTarget == insertTargetLocal {
+		return tableName
+	}
+	return tableName + distTableSuffix
+}
+
+// Table engines of the MergeTree family tables can be created with
+const (
+	engineMergeTree          = "MergeTree"
+	engineReplacingMergeTree = "ReplacingMergeTree"
+	engineSummingMergeTree   = "SummingMergeTree"
+	// engineAggregatingMergeTree is only used for rollup tables
+	engineAggregatingMergeTree = "AggregatingMergeTree"
+)
+
+// mergeTreeEngine is a table engine of the MergeTree family along with its
+// own parameters, e.g., the version column of a ReplacingMergeTree. The zero
+// value is a plain MergeTree.
+type mergeTreeEngine struct {
+	name string
+	args string
+}
+
+// parseMergeTreeEngine parses an engine given as its name, optionally
+// followed by its parameters in parentheses, e.g., ReplacingMergeTree or
+// ReplacingMergeTree(created_at).
+func parseMergeTreeEngine(s string) (mergeTreeEngine, error) {
+	s = strings.TrimSpace(s)
+	e := mergeTreeEngine{name: s}
+	if i := strings.Index(s, "("); i >= 0 {
+		if !strings.HasSuffix(s, ")") {
+			return e, fmt.Errorf("invalid engine '%s': parameters must be enclosed in parentheses", s)
+		}
+		e.name, e.args = strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:len(s)-1])
+	}
+	switch e.name {
+	case engineMergeTree:
+		if len(e.args) > 0 {
+			return e, fmt.Errorf("invalid engine '%s': %s takes no parameters", s, engineMergeTree)
+		}
+	case engineReplacingMergeTree, engineSummingMergeTree:
+	default:
+		return e, fmt.Errorf("invalid engine '%s': must be %s, %s or %s", s,
+			engineMergeTree, engineReplacingMergeTree, engineSummingMergeTree)
+	}
+	return e, nil
+}
+
+// tableLayout tells how the rows of a table are partitioned and sorted
+type tableLayout struct {
+	// dateColumn is the Date column of the rows, partitioned by month if
+	// partitionBy is empty
+	dateColumn  string
+	partitionBy string
+	orderBy     string
+	// ttl, if not empty, is the TTL expression of the rows
+	ttl string
+}
+
+// clause returns the ENGINE clause of table, whose rows are laid out as l
+// tells. Unless legacyDDL is set, it uses the PARTITION BY / ORDER BY syntax,
+// as the older one, which only partitions by month and has no TTL, is
+// rejected by recent servers by default. If replicated is set, the Replicated
+// variant of the engine is used.
+func (e mergeTreeEngine) clause(table string, l tableLayout) string {
+	name := e.name
+	if len(name) == 0 {
+		name = engineMergeTree
+	}
+	// The parameters of the replicated engines come first
+	var params []string
+	if replicated {
+		name = "Replicated" + name
+		params = append(params, quoteString(zooPath(table)), quoteString(replicaName))
+	}
+	if legacyDDL {
+		// The engine's own parameters follow the common ones
+		params = append(params, l.dateColumn, "("+l.orderBy+")", strconv.Itoa(indexGranularity))
+		if len(e.args) > 0 {
+			params = append(params, e.args)
+		}
+		return fmt.Sprintf("ENGINE = %s(%s)", name, strings.Join(params, ", "))
+	}
+	if len(e.args) > 0 {
+		params = append(params, e.args)
+	}
+	if len(params) > 0 || name != engineMergeTree {
+		name = fmt.Sprintf("%s(%s)", name, strings.Join(params, ", "))
+	}
+	partitionBy := l.partitionBy
+	if len(partitionBy) == 0 {
+		partitionBy = fmt.Sprintf("toYYYYMM(%s)", l.dateColumn)
+	}
+	ttl := ""
+	if len(l.ttl) > 0 {
+		ttl = " TTL " + l.ttl
+	}
+	return fmt.Sprintf("ENGINE = %s PARTITION BY %s ORDER BY (%s)%s SETTINGS %s",
+		name, partitionBy, l.orderBy, ttl, granularitySettings())
+}
+
+// granularitySettings returns the settings of the index granularity of tables:
+// indexGranularity and, unless it is negative, indexGranularityBytes, which
+// disables adaptive granularity if 0.
+func granularitySettings() string {
+	settings := fmt.Sprintf("index_granularity = %d", indexGranularity)
+	if indexGranularityBytes >= 0 {
+		settings += fmt.Sprintf(", index_granularity_bytes = %d", indexGranularityBytes)
+	}
+	return settings
+}
+
+// Partitions of metrics tables are at least a day long, which -chunk-time
+// values under minChunkTime are capped to, and at most a month long, which
+// values of monthChunkTime or more stand for.
+const (
+	minChunkTime   = 12 * time.Hour
+	monthChunkTime = 30 * 24 * time.Hour
+)
+
+// partitionExpression returns the PARTITION BY expression of tables with
+// chunks of chunk of dateColumn: a partition per day up to 2 days, per n
+// days up to a month, and per month beyond.
+func partitionExpression(dateColumn string, chunk time.Duration) string {
+	const day = 24 * time.Hour
+	switch {
+	case chunk >= monthChunkTime:
+		return fmt.Sprintf("toYYYYMM(%s)", dateColumn)
+	case chunk >= 2*day:
+		return fmt.Sprintf("toStartOfInterval(%s, INTERVAL %d DAY)", dateColumn, chunk/day)
+	default:
+		return dateColumn
+	}
+}
+
+// ttlExpression returns the TTL expression of metrics tables, applying
+// ttlAction to rows ttl older than their created_at, or "" if ttl is 0
+func ttlExpression() string {
+	if ttl == 0 {
+		return ""
+	}
+	// TTL expressions must be a Date or DateTime, which a DateTime64 is not
+	// in all versions
+	return fmt.Sprintf("toDateTime(created_at) + INTERVAL %s %s", intervalSQL(ttl), ttlAction)
+}
+
+// intervalUnit is a unit of the intervals of TTL expressions and rollups
+type intervalUnit struct {
+	d    time.Duration
+	name string
+	// abbrev is the abbreviation of the unit in the names of rollup tables
+	abbrev string
+}
+
+// intervalUnits are the units of intervals, the largest first
+var intervalUnits = []intervalUnit{
+	{24 * time.Hour, "DAY", "d"},
+	{time.Hour, "HOUR", "h"},
+	{time.Minute, "MINUTE", "m"},
+	{time.Second, "SECOND", "s"},
+}
+
+// largestIntervalUnit returns the largest unit the whole number of seconds d
+// is a whole number of, and that number
+func largestIntervalUnit(d time.Duration) (int64, intervalUnit) {
+	for _, u := range intervalUnits {
+		if d%u.d == 0 {
+			return int64(d / u.d), u
+		}
+	}
+	u := intervalUnits[len(intervalUnits)-1]
+	return int64(d / u.d), u
+}
+
+// intervalSQL returns d as the operand of an INTERVAL, e.g., 12 HOUR
+func intervalSQL(d time.Duration) string {
+	n, u := largestIntervalUnit(d)
+	return fmt.Sprintf("%d %s", n, u.name)
+}
+
+// checkTTL returns an error if ttl and ttlAction cannot make a TTL expression
+func checkTTL(ttl time.Duration, ttlAction string) error {
+	if ttl < 0 || ttl%time.Second != 0 {
+		return fmt.Errorf("invalid TTL %v: must be a positive whole number of seconds", ttl)
+	}
+	if ttl > 0 && legacyDDL {
+		return fmt.Errorf("TTL cannot be set on tables created with the legacy syntax")
+	}
+	if !ttlActionRegexp.MatchString(ttlAction) {
+		return fmt.Errorf("invalid TTL action '%s': must be %s, TO VOLUME 'name' or TO DISK 'name'", ttlAction, ttlActionDelete)
+	}
+	return nil
+}
+
+// zooPath returns the ZooKeeper path of the replicated table, i.e.,
+// zooPathTemplate with {database} and {table} replaced. Other macros, such as
+// {shard}, are left for the server to replace.
+func zooPath(table string) string {
+	r := strings.NewReplacer("{database}", loader.DatabaseName(), "{table}", table)
+	return r.Replace(zooPathTemplate)
+}
+
+// quoteString returns s as a ClickHouse string literal
+func quoteString(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	return "'" + r.Replace(s) + "'"
+}
+
+// columnCodecs are the compression codecs of the columns of metrics tables,
+// each a chain of codecs as in a Codec(...) clause
+type columnCodecs struct {
+	// time is the codec of created_at
+	time string
+	// metrics is the codec of the Float64 metrics columns
+	metrics string
+}
+
+// defaultCodecs suit time series: the times and values of consecutive rows
+// of a host are close to one another
+var defaultCodecs = columnCodecs{time: "DoubleDelta, ZSTD", metrics: "Gorilla, ZSTD"}
+
+// codecNames are the names of the compression codecs columns can be given
+var codecNames = map[string]bool{
+	"NONE":        true,
+	"LZ4":         true,
+	"LZ4HC":       true,
+	"ZSTD":        true,
+	"Delta":       true,
+	"DoubleDelta": true,
+	"Gorilla":     true,
+	"T64":         true,
+}
+
+// parseColumnCodecs parses codecs given as a comma-separated list of codecs,
+// each column kind (time or metrics) followed by a colon preceding the chain
+// of its codecs, e.g., time:DoubleDelta,metrics:Gorilla,ZSTD(3). Column kinds
+// that are not given keep their default codecs.
+func parseColumnCodecs(s string) (columnCodecs, error) {
+	codecs := defaultCodecs
+	chains := make(map[string][]string)
+	kind := ""
+	for _, codec := range splitOutsideParens(s) {
+		codec = strings.TrimSpace(codec)
+		if i := strings.Index(codec, ":"); i >= 0 {
+			kind, codec = strings.TrimSpace(codec[:i]), strings.TrimSpace(codec[i+1:])
+			if kind != "time" && kind != "metrics" {
+				return codecs, fmt.Errorf("invalid codec column kind '%s': must be time or metrics", kind)
+			}
+			if _, ok := chains[kind]; ok {
+				return codecs, fmt.Errorf("codecs of %s are given twice", kind)
+			}
+			chains[kind] = nil
+		} else if len(kind) == 0 {
+			return codecs, fmt.Errorf("invalid codec '%s': must follow a column kind, as in metrics:%s", codec, codec)
+		}
+		if err := checkCodec(codec); err != nil {
+			return codecs, err
+		}
+		chains[kind] = append(chains[kind], codec)
+	}
+	if chain, ok := chains["time"]; ok {
+		codecs.time = strings.Join(chain, ", ")
+	}
+	if chain, ok := chains["metrics"]; ok {
+		codecs.metrics = strings.Join(chain, ", ")
+	}
+	return codecs, nil
+}
+
+// checkCodec returns an error if codec is not a known codec name, optionally
+// followed by its integer level or size in parentheses
+func checkCodec(codec string) error {
+	name, arg := codec, ""
+	if i := strings.Index(codec, "("); i >= 0 {
+		if !strings.HasSuffix(codec, ")") {
+			return fmt.Errorf("invalid codec '%s': parameters must be enclosed in parentheses", codec)
+		}
+		name, arg = codec[:i], codec[i+1:len(codec)-1]
+		if _, err := strconv.ParseUint(strings.TrimSpace(arg), 10, 8); err != nil {
+			return fmt.Errorf("invalid codec '%s': parameter must be an integer", codec)
+		}
+	}
+	if !codecNames[name] {
+		return fmt.Errorf("invalid codec '%s': unknown codec %s", codec, name)
+	}
+	return nil
+}
+
+// splitOutsideParens splits s at the commas that are not in parentheses
+func splitOutsideParens(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// skipIndex is a data-skipping index of a column, e.g., minmax with a
+// granularity of 4
+type skipIndex struct {
+	indexType   string
+	granularity int
+}
+
+// skipIndexTypeRegexp matches the types of data-skipping indexes
+var skipIndexTypeRegexp = regexp.MustCompile(
+	`^(minmax|set\(\d+\)|bloom_filter(\([0-9.]+\))?|tokenbf_v1\(\d+, *\d+, *\d+\)|ngrambf_v1\(\d+, *\d+, *\d+, *\d+\))$`)
+
+// parseSkipIndex parses a data-skipping index given as its type followed by a
+// colon and its granularity, e.g., minmax:4 or tokenbf_v1(512, 3, 0):1, or ""
+// for no index.
+func parseSkipIndex(s string) (*skipIndex, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return nil, fmt.Errorf("invalid index '%s': must be type:granularity, e.g., minmax:4", s)
+	}
+	idx := &skipIndex{indexType: strings.TrimSpace(s[:i])}
+	if !skipIndexTypeRegexp.MatchString(idx.indexType) {
+		return nil, fmt.Errorf("invalid index '%s': unknown type %s, must be minmax, set(n), bloom_filter, tokenbf_v1(...) or ngrambf_v1(...)", s, idx.indexType)
+	}
+	granularity, err := strconv.Atoi(strings.TrimSpace(s[i+1:]))
+	if err != nil || granularity <= 0 {
+		return nil, fmt.Errorf("invalid index '%s': granularity must be a positive integer", s)
+	}
+	idx.granularity = granularity
+	return idx, nil
+}
+
+// definition returns the definition of the index of column in a CREATE TABLE
+// statement
+func (idx *skipIndex) definition(column string) string {
+	return idx.expressionDefinition(column, column)
+}
+
+// expressionDefinition returns the definition of the index named after column
+// of the given expression in a CREATE TABLE statement
+func (idx *skipIndex) expressionDefinition(column, expr string) string {
+	return fmt.Sprintf("INDEX idx_%s %s TYPE %s GRANULARITY %d", column, expr, idx.indexType, idx.granularity)
+}
+
+// projection is a projection of metrics tables, e.g., their rows sorted
+// another way, which the server keeps in each of their parts
+type projection struct {
+	name  string
+	query string
+}
+
+// parseProjections parses projections given as name:query separated by
+// semicolons, e.g., by_time:ORDER BY (created_at, tags_id). Queries that do
+// not start with SELECT select all columns.
+func parseProjections(s string) ([]projection, error) {
+	var ps []projection
+	names := make(map[string]bool)
+	for _, spec := range strings.Split(s, ";") {
+		if spec = strings.TrimSpace(spec); len(spec) == 0 {
+			continue
+		}
+		parts := strings.SplitN(spec, ":", 2)
+		if len(parts) != 2 || len(strings.TrimSpace(parts[1])) == 0 {
+			return nil, fmt.Errorf("invalid projection '%s': must be name:query", spec)
+		}
+		p := projection{name: strings.TrimSpace(parts[0]), query: strings.TrimSpace(parts[1])}
+		if !projectionNameRegexp.MatchString(p.name) {
+			return nil, fmt.Errorf("invalid projection name '%s'", p.name)
+		}
+		if names[p.name] {
+			return nil, fmt.Errorf("duplicate projection '%s'", p.name)
+		}
+		names[p.name] = true
+		if !strings.HasPrefix(strings.ToUpper(p.query), "SELECT ") {
+			p.query = "SELECT * " + p.query
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+// definition returns the definition of the projection in a CREATE TABLE
+// statement
+func (p projection) definition() string {
+	return fmt.Sprintf("PROJECTION %s (%s)", p.name, p.query)
+}
+
+// checkProjectionsSupport returns an error if the server db is connected to
+// is too old for projections
+func checkProjectionsSupport(db schemaConn) error {
+	version, err := db.queryStrings("SELECT version()")
 	if err != nil {
-		panic(err)
+		return err
 	}
-	truncateTable(db, "tags")
+	if len(version) != 1 {
+		return fmt.Errorf("cannot get server version")
+	}
+	return checkProjectionsVersion(version[0])
 }
 
-// createMetricsTable builds CREATE TABLE SQL statement and runs it
-func createMetricsTable(db *sqlx.DB, tableSpec []string) {
-	// tableSpec contain
-	// 0: table name
-	// 1: table column name 1
-	// N: table column name N
+// checkProjectionsVersion returns an error if the server version, e.g.,
+// 21.8.10.19, is older than minProjectionsVersion
+func checkProjectionsVersion(version string) error {
+	parts := strings.Split(version, ".")
+	for i, min := range minProjectionsVersion {
+		if i >= len(parts) {
+			break
+		}
+		n, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return fmt.Errorf("cannot parse server version '%s': %v", version, err)
+		}
+		if n > min {
+			return nil
+		}
+		if n < min {
+			return fmt.Errorf("ClickHouse %s does not support -projections: %d.%d or later is needed",
+				version, minProjectionsVersion[0], minProjectionsVersion[1])
+		}
+	}
+	return nil
+}
 
-	// Ex.: cpu OR disk OR nginx
-	tableName := tableSpec[0]
-	tableCols[tableName] = tableSpec[1:]
+// getIndexDefinitions builds the data-skipping index definitions of a metrics
+// table with the given metrics columns: fieldIndex on tags_id, or the tag
+// columns with denormalized tags, and on the first fieldIndexCount metrics
+// columns, all of them if -1, and additionalTagsIndex on additional_tags, or
+// its keys if it is a map.
+func getIndexDefinitions(columnNames []string) []string {
+	var indexes []string
+	if fieldIndex != nil {
+		if denormalizeTags {
+			for _, tag := range tableCols["tags"] {
+				indexes = append(indexes, fieldIndex.definition(tag))
+			}
+		} else {
+			indexes = append(indexes, fieldIndex.definition("tags_id"))
+		}
+		for i, column := range columnNames {
+			if fieldIndexCount >= 0 && i >= fieldIndexCount {
+				break
+			}
+			if len(column) > 0 {
+				indexes = append(indexes, fieldIndex.definition(column))
+			}
+		}
+	}
+	if additionalTagsIndex != nil && additionalTagsMap {
+		// Maps are indexed by their keys
+		indexes = append(indexes, additionalTagsIndex.expressionDefinition("additional_tags", "mapKeys(additional_tags)"))
+	} else if additionalTagsIndex != nil {
+		indexes = append(indexes, additionalTagsIndex.definition("additional_tags"))
+	}
+	return indexes
+}
 
+// getColumnDefinitions builds the column definitions of a metrics table from the
+// column specs found in the data header. Ex.: "usage_user Float64 Codec(Gorilla, ZSTD)"
+func getColumnDefinitions(columnSpecs []string) []string {
 	// We'll have some service columns in table to be created and columnNames contains all column names to be created
 	columnNames := []string{}
+	columnTypes := []string{}
 
 	if inTableTag {
 		// First column in the table - service column - partitioning field
 		partitioningColumn := tableCols["tags"][0] // would be 'hostname'
 		columnNames = append(columnNames, partitioningColumn)
+		columnTypes = append(columnTypes, columnTypeFloat64)
 	}
 
-	// Add all column names from tableSpec into columnNames
-	columnNames = append(columnNames, tableSpec[1:]...)
+	// Add all column names and types from columnSpecs
+	names, types := splitColumnSpecs(columnSpecs)
+	columnNames = append(columnNames, names...)
+	columnTypes = append(columnTypes, types...)
 
 	// columnsWithType - column specifications with type. Ex.: "cpu_usage Float64"
 	columnsWithType := []string{}
-	for _, column := range columnNames {
+	for i, column := range columnNames {
 		if len(column) == 0 {
 			// Skip nameless columns
 			continue
 		}
-		columnsWithType = append(columnsWithType, fmt.Sprintf("%s Float64 Codec(Gorilla, ZSTD)", column))
+		colType := columnTypes[i]
+		codec := codecs.metrics
+		switch colType {
+		case columnTypeFloat64:
+		case columnTypeString:
+			codec = "ZSTD"
+		default:
+			codec = "DoubleDelta, ZSTD"
+		}
+		// Metrics may be missing, but not strings, whose empty values are
+		// values of their own
+		if nullableFields && i >= len(columnNames)-len(names) && colType != columnTypeString {
+			colType = "Nullable(" + colType + ")"
+		}
+		columnsWithType = append(columnsWithType, fmt.Sprintf("%s %s Codec(%s)", column, colType, codec))
 	}
+	return columnsWithType
+}
 
-	sql := fmt.Sprintf(`
-			CREATE TABLE IF NOT EXISTS %s (
-				created_date    Date     DEFAULT today(),
-				created_at      DateTime DEFAULT now() Codec(DoubleDelta, ZSTD),
-				tags_id         UInt32,
-				%s,
-				additional_tags String   DEFAULT ''
-			) ENGINE = MergeTree(created_date, (tags_id, created_at), 8192)
-			`,
-		tableName,
-		strings.Join(columnsWithType, ","))
-	if debug > 0 {
-		fmt.Printf(sql)
-	}
-	_, err := db.Exec(sql)
-	if err != nil {
-		panic(err)
+// splitColumnSpecs splits column specs of the form 'name' or 'name:type' into
+// column names and their ClickHouse types. Columns without a type are Float64,
+// and bools are stored as 0 or 1 in UInt8 columns.
+func splitColumnSpecs(columnSpecs []string) ([]string, []string) {
+	names := make([]string, 0, len(columnSpecs))
+	types := make([]string, 0, len(columnSpecs))
+	for _, spec := range columnSpecs {
+		parts := strings.SplitN(spec, ":", 2)
+		names = append(names, parts[0])
+		colType := columnTypeFloat64
+		if len(parts) == 2 {
+			switch parts[1] {
+			case "int64":
+				colType = columnTypeInt64
+			case "uint64":
+				colType = columnTypeUInt64
+			case "string":
+				colType = columnTypeString
+			case "bool":
+				colType = columnTypeBool
+			}
+		}
+		types = append(types, colType)
 	}
-	truncateTable(db, tableName)
+	return names, types
 }
 
-func truncateTable(db *sqlx.DB, tableName string) {
-	sql := fmt.Sprintf("TRUNCATE TABLE %s", tableName)
-	_, err := db.Exec(sql)
-	if err != nil {
-		panic(err)
-	}
+// truncateTableSQL returns the TRUNCATE TABLE statement of tableName
+func truncateTableSQL(tableName string) string {
+	return fmt.Sprintf("TRUNCATE TABLE %s%s", tableName, onCluster())
 }
 
 // getConnectString() builds connect string to ClickHouse
 // db - whether database specification should be added to the connection string
 func getConnectString(db bool) string {
+	return getServerConnectString(serverAddress(), db)
+}
+
+// getServerConnectString builds the connect string to the ClickHouse server
+// at address, as host:port
+func getServerConnectString(address string, db bool) string {
 	// connectString: tcp://127.0.0.1:9000?debug=true
 	// ClickHouse ex.:
 	// tcp://host1:9000?username=user&password=qwerty&database=clicks&read_timeout=10&write_timeout=20&alt_hosts=host2:9000,host3:9000
+	connectString := fmt.Sprintf("tcp://%s?username=%s&password=%s", address, url.QueryEscape(user), url.QueryEscape(password))
 	if db {
-		return fmt.Sprintf("tcp://%s:%s?username=%s&password=%s&database=%s", host, port, user, password, loader.DatabaseName())
-	} else {
-		return fmt.Sprintf("tcp://%s:%s?username=%s&password=%s", host, port, user, password)
+		connectString += "&database=" + loader.DatabaseName()
 	}
+	return connectString + tlsParams() + driverParams()
+}
+
+// setting is a ClickHouse setting of -ch-settings, sent along with each query
+type setting struct {
+	name  string
+	value string
+}
+
+// settingNameRegexp matches the names of ClickHouse settings
+var settingNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// loaderParams are the parameters of the connect string set by the loader
+// itself, from its other flags, which -ch-settings must not give
+var loaderParams = []string{
+	"username", "password", "database", "secure", "skip_verify", "tls_config", "alt_hosts", "compress", "read_timeout", "write_timeout",
+	"async_insert", "wait_for_async_insert", "insert_quorum", "insert_deduplication_token",
+}
+
+// parseSettings parses the comma-separated name=value settings of
+// -ch-settings, e.g., max_insert_block_size=1048576,send_timeout=600
+func parseSettings(spec string) ([]setting, error) {
+	if len(spec) == 0 {
+		return nil, nil
+	}
+	var settings []setting
+	for _, s := range strings.Split(spec, ",") {
+		parts := strings.SplitN(s, "=", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid -ch-settings '%s': '%s' must be name=value", spec, s)
+		}
+		if len(name) == 0 {
+			return nil, fmt.Errorf("invalid -ch-settings '%s': names must not be empty", spec)
+		}
+		if !settingNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("invalid -ch-settings '%s': invalid name '%s'", spec, name)
+		}
+		for _, p := range loaderParams {
+			if name == p {
+				return nil, fmt.Errorf("invalid -ch-settings '%s': %s is set by the flags of the loader", spec, name)
+			}
+		}
+		settings = append(settings, setting{name: name, value: strings.TrimSpace(parts[1])})
+	}
+	return settings, nil
+}
+
+// driverParams returns the parameters of the connect string with the
+// -compress, -read-timeout and -write-timeout of the driver, and the
+// -ch-settings, their values URL-escaped. The driver of database/sql only
+// compresses with LZ4, so -compress zstd has it do so.
+func driverParams() string {
+	var params string
+	if compress != compressNone {
+		params += "&compress=true"
+	}
+	if readTimeout > 0 {
+		params += "&read_timeout=" + strconv.FormatFloat(readTimeout.Seconds(), 'f', -1, 64)
+	}
+	if writeTimeout > 0 {
+		params += "&write_timeout=" + strconv.FormatFloat(writeTimeout.Seconds(), 'f', -1, 64)
+	}
+	return params + settingsParams(chSettings)
+}
+
+// settingsParams returns the parameters of the connect string giving settings,
+// their values URL-escaped
+func settingsParams(settings []setting) string {
+	var params string
+	for _, s := range settings {
+		params += "&" + s.name + "=" + url.QueryEscape(s.value)
+	}
+	return params
+}
+
+// insertSettings returns the settings of the connections of the workers
+// inserting rows, on top of -ch-settings: those of -async-insert and
+// -insert-quorum
+func insertSettings() []setting {
+	var settings []setting
+	if asyncInsert {
+		wait := "0"
+		if waitForAsyncInsert {
+			wait = "1"
+		}
+		settings = append(settings, setting{name: "async_insert", value: "1"}, setting{name: "wait_for_async_insert", value: wait})
+	}
+	if insertQuorum > 0 {
+		settings = append(settings, setting{name: "insert_quorum", value: strconv.Itoa(insertQuorum)})
+	}
+	return settings
+}
+
+// tlsConfigName is the name the TLS config verifying the server against
+// caCert is registered with the driver under
+const tlsConfigName = "tsbs"
+
+// tlsParams returns the parameters of the connect string enabling TLS if
+// secure is set
+func tlsParams() string {
+	if !secure {
+		return ""
+	}
+	params := "&secure=true"
+	if skipVerify {
+		params += "&skip_verify=true"
+	}
+	if len(caCert) > 0 {
+		params += "&tls_config=" + tlsConfigName
+	}
+	return params
+}
+
+// registerCACert registers with the driver the TLS config verifying the
+// server against the CA certificates of the PEM file fileName
+func registerCACert(fileName string) error {
+	config, err := tlsConfigWithCA(fileName)
+	if err != nil {
+		return err
+	}
+	return clickhouse.RegisterTLSConfig(tlsConfigName, config)
+}
+
+// tlsConfig returns the TLS config of secure connections: verifying the
+// server against caCert if set, or not at all with skipVerify
+func tlsConfig() (*tls.Config, error) {
+	if len(caCert) > 0 {
+		return tlsConfigWithCA(caCert)
+	}
+	return &tls.Config{InsecureSkipVerify: skipVerify}, nil
+}
+
+// tlsConfigWithCA returns the TLS config verifying the server against the CA
+// certificates of the PEM file fileName, unless skipVerify is set
+func tlsConfigWithCA(fileName string) (*tls.Config, error) {
+	pem, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read CA certificates: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no CA certificates in %s", fileName)
+	}
+	return &tls.Config{RootCAs: pool, InsecureSkipVerify: skipVerify}, nil
+}
+
+// serverPort returns the port to connect to: port if given, else the default
+// port of protocol with or without TLS
+func serverPort(port string, portGiven, secure bool, protocol string) string {
+	switch {
+	case portGiven:
+		return port
+	case protocol == protocolHTTP && secure:
+		return defaultSecureHTTPPort
+	case protocol == protocolHTTP:
+		return defaultHTTPPort
+	case secure:
+		return defaultSecurePort
+	}
+	return port
+}
+
+// schemaConn is a connection to ClickHouse running DDL and queries about the
+// server, over the native protocol or HTTP
+type schemaConn interface {
+	execer
+	// queryStrings returns the values of the single column of the rows of
+	// query
+	queryStrings(query string) ([]string, error)
+	Close() error
+}
+
+// nativeConn is a schemaConn over the native protocol
+type nativeConn struct {
+	*sqlx.DB
+}
+
+func (c nativeConn) queryStrings(query string) ([]string, error) {
+	var values []string
+	err := c.Select(&values, query)
+	return values, err
+}
+
+// connect returns a schemaConn to the server over protocol, to the database
+// of the benchmark if db is set
+func connect(db bool) schemaConn {
+	if protocol == protocolHTTP {
+		c, err := newHTTPConn(serverAddress(), db)
+		if err != nil {
+			panic(err)
+		}
+		return c
+	}
+	debugLog.printf(1, "connecting to %s", redactConnectString(getConnectString(db)))
+	return nativeConn{sqlx.MustConnect(dbType, getConnectString(db))}
+}
+
+// checkAddress returns an error if host and port cannot be put together into
+// the tcp://host:port of the connect string, e.g., because host is a URL or
+// already has a port.
+func checkAddress(host, port string) error {
+	if strings.ContainsAny(host, "/?#@") {
+		return fmt.Errorf("invalid host '%s': must be a hostname or IP address, without a scheme or path", host)
+	}
+	// IPv6 addresses are enclosed in brackets to tell them from the port
+	if strings.Contains(host, ":") && !(strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]")) {
+		return fmt.Errorf("invalid host '%s': the port must be given with -port, and IPv6 addresses in brackets", host)
+	}
+	if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+		return fmt.Errorf("invalid port '%s': must be a number from 1 to 65535", port)
+	}
+	return nil
 }
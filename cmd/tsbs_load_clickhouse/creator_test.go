@@ -3,8 +3,14 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"io/ioutil"
 	"log"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/timescale/tsbs/cmd/tsbs_generate_data/serialize"
 )
 
 func TestDBCreatorReadDataHeader(t *testing.T) {
@@ -88,3 +94,1155 @@ func TestDBCreatorReadDataHeader(t *testing.T) {
 		}
 	}
 }
+
+func TestParseDataHeader(t *testing.T) {
+	cases := []struct {
+		desc     string
+		input    string
+		wantTags string
+		wantCols []string
+		wantErr  string
+	}{
+		{
+			desc:     "valid header",
+			input:    "tags,hostname,region\ncpu,usage_user,usage_system:uint64\nmem,used\n\ntags,hostname=host_0\n",
+			wantTags: "tags,hostname,region",
+			wantCols: []string{"cpu,usage_user,usage_system:uint64", "mem,used"},
+		},
+		{desc: "empty", input: "", wantErr: "input is empty"},
+		{desc: "gzip", input: "\x1f\x8b\x08\x00\x00\x00", wantErr: "input is gzip-compressed, load it with -file or decompress it first, e.g., with gunzip -c"},
+		{desc: "zstd", input: "\x28\xb5\x2f\xfd\x00", wantErr: "input is zstd-compressed, load it with -file or decompress it first, e.g., with zstd -dc"},
+		{desc: "binary", input: "tags\x00\x01\x02", wantErr: "input is binary, not the data of tsbs_generate_data -format clickhouse"},
+		{
+			desc:    "other format",
+			input:   "cpu,hostname=host_0 usage_user=58 1451606400000000000\n",
+			wantErr: "line 1: 'cpu,hostname=host_0 usage_user=58 1451606400000000000' does not start with the tags, as 'tags,hostname,region' does: is it the data of tsbs_generate_data -format clickhouse?",
+		},
+		{desc: "no tags", input: "tags\ncpu,usage_user\n\n", wantErr: "line 1: tags has no columns: 'tags'"},
+		{desc: "truncated", input: "tags,hostname\ncpu,usage_user\nmem,us", wantErr: "line 3: input ends before the blank line ending the header, after 'mem,us'"},
+		{desc: "no tables", input: "tags,hostname\n\n", wantErr: "line 2: header ends without describing any table"},
+		{desc: "table without columns", input: "tags,hostname\ncpu,usage_user\nmem\n\n", wantErr: "line 3: mem has no columns: 'mem'"},
+		{desc: "column without name", input: "tags,hostname\ncpu,usage_user,:uint64\n\n", wantErr: "line 2: column 2 of cpu has no name: 'cpu,usage_user,:uint64'"},
+		{desc: "table without name", input: "tags,hostname\n,usage_user\n\n", wantErr: "line 2: table has no name: ',usage_user'"},
+		{
+			desc:    "duplicate table",
+			input:   "tags,hostname\ncpu,usage_user\nmem,used\ncpu,usage_system\n\n",
+			wantErr: "line 4: table 'cpu' is described twice, on lines 2 and 4",
+		},
+		{
+			desc:    "no blank line",
+			input:   "tags,hostname\ncpu,usage_user\ntags,hostname=host_0\ncpu,1451606400000000000,58\n",
+			wantErr: "line 3: 'tags,hostname=host_0' follows the tables: the header must end with a blank line",
+		},
+	}
+	for _, c := range cases {
+		tags, cols, err := parseDataHeader(bufio.NewReader(strings.NewReader(c.input)))
+		if len(c.wantErr) > 0 {
+			if err == nil || err.Error() != c.wantErr {
+				t.Errorf("%s: incorrect error: got %v want %s", c.desc, err, c.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.desc, err)
+		} else if tags != c.wantTags || !reflect.DeepEqual(cols, c.wantCols) {
+			t.Errorf("%s: incorrect header: got %s %v want %s %v", c.desc, tags, cols, c.wantTags, c.wantCols)
+		}
+	}
+}
+
+func TestDBCreatorReadSchema(t *testing.T) {
+	schema := &serialize.Schema{
+		Tags: []string{"tag1", "tag2"},
+		Measurements: []serialize.SchemaMeasurement{
+			{Name: "cols", Columns: []serialize.SchemaColumn{{Name: "col1", Type: "float64"}, {Name: "col2", Type: "uint64"}}},
+			{Name: "cols2", Columns: []serialize.SchemaColumn{{Name: "col21", Type: "int64"}}},
+		},
+	}
+	wantTags := "tags,tag1,tag2"
+	wantCols := []string{"cols,col1,col2:uint64", "cols2,col21:int64"}
+	cases := []struct {
+		desc        string
+		schema      *serialize.Schema
+		noHeader    bool
+		input       string
+		wantRest    string
+		shouldFatal bool
+	}{
+		{
+			desc:     "no header",
+			schema:   schema,
+			noHeader: true,
+			input:    "row1\nrow2\n",
+			wantRest: "row1\nrow2\n",
+		},
+		{
+			desc:     "matching header",
+			schema:   schema,
+			input:    "tags,tag1,tag2\ncols,col1,col2:uint64\ncols2,col21:int64\n\nrow1\n",
+			wantRest: "row1\n",
+		},
+		{
+			desc:   "matching header w/ explicit float",
+			schema: schema,
+			input:  "tags,tag1,tag2\ncols,col1:float64,col2:uint64\ncols2,col21:int64\n\n",
+		},
+		{
+			desc:        "header w/ different tags",
+			schema:      schema,
+			input:       "tags,tag1\ncols,col1,col2:uint64\ncols2,col21:int64\n\n",
+			shouldFatal: true,
+		},
+		{
+			desc:        "header w/ fewer tables",
+			schema:      schema,
+			input:       "tags,tag1,tag2\ncols,col1,col2:uint64\n\n",
+			shouldFatal: true,
+		},
+		{
+			desc:        "header w/ different table",
+			schema:      schema,
+			input:       "tags,tag1,tag2\ncols,col1,col2:uint64\ncols3,col21:int64\n\n",
+			shouldFatal: true,
+		},
+		{
+			desc:        "header w/ different column type",
+			schema:      schema,
+			input:       "tags,tag1,tag2\ncols,col1,col2\ncols2,col21:int64\n\n",
+			shouldFatal: true,
+		},
+		{
+			desc: "unsupported column type",
+			schema: &serialize.Schema{
+				Tags:         []string{"tag1"},
+				Measurements: []serialize.SchemaMeasurement{{Name: "cols", Columns: []serialize.SchemaColumn{{Name: "col1", Type: "decimal"}}}},
+			},
+			noHeader:    true,
+			shouldFatal: true,
+		},
+	}
+
+	oldDataHeader := dataHeader
+	defer func() {
+		dataHeader = oldDataHeader
+		fatal = log.Fatalf
+	}()
+	for _, c := range cases {
+		dataHeader = !c.noHeader
+		dbc := &dbCreator{}
+		br := bufio.NewReader(bytes.NewReader([]byte(c.input)))
+		isCalled := false
+		fatal = func(fmt string, args ...interface{}) {
+			isCalled = true
+			log.Printf(fmt, args...)
+		}
+		dbc.readSchema(c.schema, br)
+		if c.shouldFatal {
+			if !isCalled {
+				t.Errorf("%s: did not call fatal when it should", c.desc)
+			}
+			continue
+		}
+		if isCalled {
+			t.Errorf("%s: called fatal when it should not", c.desc)
+		}
+		if dbc.tags != wantTags {
+			t.Errorf("%s: incorrect tags: got\n%s\nwant\n%s", c.desc, dbc.tags, wantTags)
+		}
+		if !reflect.DeepEqual(dbc.cols, wantCols) {
+			t.Errorf("%s: incorrect cols: got\n%v\nwant\n%v", c.desc, dbc.cols, wantCols)
+		}
+		// Only the header, if any, is consumed
+		if rest, _ := ioutil.ReadAll(br); string(rest) != c.wantRest {
+			t.Errorf("%s: incorrect data left: got\n%s\nwant\n%s", c.desc, rest, c.wantRest)
+		}
+	}
+}
+
+func TestSplitColumnSpecs(t *testing.T) {
+	specs := []string{"total:uint64", "used_percent", "active:int64", "ratio:float64", "other:unknown", "status:string", "up:bool"}
+	wantNames := []string{"total", "used_percent", "active", "ratio", "other", "status", "up"}
+	wantTypes := []string{columnTypeUInt64, columnTypeFloat64, columnTypeInt64, columnTypeFloat64, columnTypeFloat64, columnTypeString, columnTypeBool}
+	names, types := splitColumnSpecs(specs)
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("incorrect names: got %v want %v", names, wantNames)
+	}
+	if !reflect.DeepEqual(types, wantTypes) {
+		t.Errorf("incorrect types: got %v want %v", types, wantTypes)
+	}
+}
+
+func TestGetColumnDefinitions(t *testing.T) {
+	cases := []struct {
+		desc       string
+		inTableTag bool
+		specs      []string
+		want       []string
+	}{
+		{
+			desc:  "float only",
+			specs: []string{"usage_user", "usage_system"},
+			want: []string{
+				"usage_user Float64 Codec(Gorilla, ZSTD)",
+				"usage_system Float64 Codec(Gorilla, ZSTD)",
+			},
+		},
+		{
+			desc:  "mixed float and integer",
+			specs: []string{"total:uint64", "used_percent", "active:int64", ""},
+			want: []string{
+				"total UInt64 Codec(DoubleDelta, ZSTD)",
+				"used_percent Float64 Codec(Gorilla, ZSTD)",
+				"active Int64 Codec(DoubleDelta, ZSTD)",
+			},
+		},
+		{
+			desc:  "string and bool",
+			specs: []string{"status:string", "up:bool", "ratio"},
+			want: []string{
+				"status String Codec(ZSTD)",
+				"up UInt8 Codec(DoubleDelta, ZSTD)",
+				"ratio Float64 Codec(Gorilla, ZSTD)",
+			},
+		},
+		{
+			desc:       "mixed float and integer w/ in table tag",
+			inTableTag: true,
+			specs:      []string{"accepts:uint64", "ratio"},
+			want: []string{
+				"hostname Float64 Codec(Gorilla, ZSTD)",
+				"accepts UInt64 Codec(DoubleDelta, ZSTD)",
+				"ratio Float64 Codec(Gorilla, ZSTD)",
+			},
+		},
+	}
+
+	oldInTableTag := inTableTag
+	defer func() { inTableTag = oldInTableTag }()
+	tableCols["tags"] = []string{"hostname"}
+	for _, c := range cases {
+		inTableTag = c.inTableTag
+		if got := getColumnDefinitions(c.specs); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: incorrect column definitions: got\n%v\nwant\n%v", c.desc, got, c.want)
+		}
+	}
+}
+
+// normalizeSQL collapses the whitespace of a SQL statement into single spaces
+func normalizeSQL(sql string) string {
+	return strings.Join(strings.Fields(sql), " ")
+}
+
+func TestTableSQL(t *testing.T) {
+	cases := []struct {
+		desc       string
+		legacy     bool
+		precision  int
+		wantTags   string
+		wantMetric string
+	}{
+		{
+			desc:      "modern",
+			precision: 9,
+			wantTags: "CREATE TABLE IF NOT EXISTS tags( created_date Date DEFAULT today(), created_at DateTime DEFAULT now(), id UInt32, " +
+				"hostname String, region String ) " +
+				"ENGINE = MergeTree PARTITION BY toYYYYMM(created_date) ORDER BY (id) SETTINGS index_granularity = 8192",
+			wantMetric: "CREATE TABLE IF NOT EXISTS cpu ( created_date Date DEFAULT toDate(created_at), " +
+				"created_at DateTime64(9) Codec(DoubleDelta, ZSTD), tags_id UInt32, " +
+				"usage_user Float64 Codec(Gorilla, ZSTD),usage_system Float64 Codec(Gorilla, ZSTD), additional_tags String DEFAULT '' ) " +
+				"ENGINE = MergeTree PARTITION BY toYYYYMM(created_date) ORDER BY (tags_id, created_at) SETTINGS index_granularity = 8192",
+		},
+		{
+			desc:      "legacy",
+			legacy:    true,
+			precision: 9,
+			wantTags: "CREATE TABLE IF NOT EXISTS tags( created_date Date DEFAULT today(), created_at DateTime DEFAULT now(), id UInt32, " +
+				"hostname String, region String ) " +
+				"ENGINE = MergeTree(created_date, (id), 8192)",
+			wantMetric: "CREATE TABLE IF NOT EXISTS cpu ( created_date Date DEFAULT toDate(created_at), " +
+				"created_at DateTime64(9) Codec(DoubleDelta, ZSTD), tags_id UInt32, " +
+				"usage_user Float64 Codec(Gorilla, ZSTD),usage_system Float64 Codec(Gorilla, ZSTD), additional_tags String DEFAULT '' ) " +
+				"ENGINE = MergeTree(created_date, (tags_id, created_at), 8192)",
+		},
+		{
+			desc:      "DateTime time",
+			precision: 0,
+			wantTags: "CREATE TABLE IF NOT EXISTS tags( created_date Date DEFAULT today(), created_at DateTime DEFAULT now(), id UInt32, " +
+				"hostname String, region String ) " +
+				"ENGINE = MergeTree PARTITION BY toYYYYMM(created_date) ORDER BY (id) SETTINGS index_granularity = 8192",
+			wantMetric: "CREATE TABLE IF NOT EXISTS cpu ( created_date Date DEFAULT toDate(created_at), " +
+				"created_at DateTime Codec(DoubleDelta, ZSTD),created_at_ns UInt32 Codec(ZSTD), tags_id UInt32, " +
+				"usage_user Float64 Codec(Gorilla, ZSTD),usage_system Float64 Codec(Gorilla, ZSTD), additional_tags String DEFAULT '' ) " +
+				"ENGINE = MergeTree PARTITION BY toYYYYMM(created_date) ORDER BY (tags_id, created_at) SETTINGS index_granularity = 8192",
+		},
+		{
+			desc:      "millisecond time",
+			precision: 3,
+			wantTags: "CREATE TABLE IF NOT EXISTS tags( created_date Date DEFAULT today(), created_at DateTime DEFAULT now(), id UInt32, " +
+				"hostname String, region String ) " +
+				"ENGINE = MergeTree PARTITION BY toYYYYMM(created_date) ORDER BY (id) SETTINGS index_granularity = 8192",
+			wantMetric: "CREATE TABLE IF NOT EXISTS cpu ( created_date Date DEFAULT toDate(created_at), " +
+				"created_at DateTime64(3) Codec(DoubleDelta, ZSTD), tags_id UInt32, " +
+				"usage_user Float64 Codec(Gorilla, ZSTD),usage_system Float64 Codec(Gorilla, ZSTD), additional_tags String DEFAULT '' ) " +
+				"ENGINE = MergeTree PARTITION BY toYYYYMM(created_date) ORDER BY (tags_id, created_at) SETTINGS index_granularity = 8192",
+		},
+	}
+
+	oldLegacyDDL, oldTimePrecision := legacyDDL, timePrecision
+	defer func() { legacyDDL, timePrecision = oldLegacyDDL, oldTimePrecision }()
+	for _, c := range cases {
+		legacyDDL, timePrecision = c.legacy, c.precision
+		if got := normalizeSQL(tagsTableSQL([]string{"hostname", "region"})); got != c.wantTags {
+			t.Errorf("%s: incorrect tags table SQL: got\n%s\nwant\n%s", c.desc, got, c.wantTags)
+		}
+		cols := getColumnDefinitions([]string{"usage_user", "usage_system"})
+		if got := normalizeSQL(metricsTableSQL("cpu", cols, nil)); got != c.wantMetric {
+			t.Errorf("%s: incorrect metrics table SQL: got\n%s\nwant\n%s", c.desc, got, c.wantMetric)
+		}
+	}
+}
+
+func TestParseMergeTreeEngine(t *testing.T) {
+	cases := []struct {
+		in         string
+		want       mergeTreeEngine
+		wantModern string
+		wantLegacy string
+		wantErr    bool
+	}{
+		{
+			in:         "MergeTree",
+			want:       mergeTreeEngine{name: engineMergeTree},
+			wantModern: "ENGINE = MergeTree PARTITION BY toYYYYMM(d) ORDER BY (k) SETTINGS index_granularity = 8192",
+			wantLegacy: "ENGINE = MergeTree(d, (k), 8192)",
+		},
+		{
+			in:         "ReplacingMergeTree",
+			want:       mergeTreeEngine{name: engineReplacingMergeTree},
+			wantModern: "ENGINE = ReplacingMergeTree() PARTITION BY toYYYYMM(d) ORDER BY (k) SETTINGS index_granularity = 8192",
+			wantLegacy: "ENGINE = ReplacingMergeTree(d, (k), 8192)",
+		},
+		{
+			in:         " ReplacingMergeTree( created_at ) ",
+			want:       mergeTreeEngine{name: engineReplacingMergeTree, args: "created_at"},
+			wantModern: "ENGINE = ReplacingMergeTree(created_at) PARTITION BY toYYYYMM(d) ORDER BY (k) SETTINGS index_granularity = 8192",
+			wantLegacy: "ENGINE = ReplacingMergeTree(d, (k), 8192, created_at)",
+		},
+		{
+			in:         "SummingMergeTree((usage_user, usage_system))",
+			want:       mergeTreeEngine{name: engineSummingMergeTree, args: "(usage_user, usage_system)"},
+			wantModern: "ENGINE = SummingMergeTree((usage_user, usage_system)) PARTITION BY toYYYYMM(d) ORDER BY (k) SETTINGS index_granularity = 8192",
+			wantLegacy: "ENGINE = SummingMergeTree(d, (k), 8192, (usage_user, usage_system))",
+		},
+		{in: "MergeTree(created_at)", wantErr: true},
+		{in: "ReplacingMergeTree(created_at", wantErr: true},
+		{in: "CollapsingMergeTree(sign)", wantErr: true},
+		{in: "Log", wantErr: true},
+		{in: "", wantErr: true},
+	}
+
+	oldLegacyDDL := legacyDDL
+	defer func() { legacyDDL = oldLegacyDDL }()
+	for _, c := range cases {
+		got, err := parseMergeTreeEngine(c.in)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("%s: unexpected lack of error", c.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.in, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%s: incorrect engine: got %+v want %+v", c.in, got, c.want)
+		}
+		legacyDDL = false
+		if clause := got.clause("t", tableLayout{dateColumn: "d", orderBy: "k"}); clause != c.wantModern {
+			t.Errorf("%s: incorrect clause: got\n%s\nwant\n%s", c.in, clause, c.wantModern)
+		}
+		legacyDDL = true
+		if clause := got.clause("t", tableLayout{dateColumn: "d", orderBy: "k"}); clause != c.wantLegacy {
+			t.Errorf("%s: incorrect legacy clause: got\n%s\nwant\n%s", c.in, clause, c.wantLegacy)
+		}
+	}
+}
+
+func TestReplicatedClause(t *testing.T) {
+	cases := []struct {
+		desc     string
+		engine   mergeTreeEngine
+		template string
+		replica  string
+		legacy   bool
+		want     string
+	}{
+		{
+			desc:     "default paths",
+			template: "/clickhouse/tables/{shard}/{database}/{table}",
+			replica:  "{replica}",
+			want: "ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/benchmark/cpu', '{replica}') " +
+				"PARTITION BY toYYYYMM(d) ORDER BY (k) SETTINGS index_granularity = 8192",
+		},
+		{
+			desc:     "engine parameters",
+			engine:   mergeTreeEngine{name: engineReplacingMergeTree, args: "created_at"},
+			template: "/tables/{table}",
+			replica:  "r1",
+			want: "ENGINE = ReplicatedReplacingMergeTree('/tables/cpu', 'r1', created_at) " +
+				"PARTITION BY toYYYYMM(d) ORDER BY (k) SETTINGS index_granularity = 8192",
+		},
+		{
+			desc:     "legacy",
+			engine:   mergeTreeEngine{name: engineSummingMergeTree, args: "(usage_user)"},
+			template: "/tables/{table}",
+			replica:  "{replica}",
+			legacy:   true,
+			want:     "ENGINE = ReplicatedSummingMergeTree('/tables/cpu', '{replica}', d, (k), 8192, (usage_user))",
+		},
+		{
+			desc:     "quotes",
+			template: `/tables/{table}'\`,
+			replica:  "it's",
+			want: `ENGINE = ReplicatedMergeTree('/tables/cpu\'\\', 'it\'s') ` +
+				"PARTITION BY toYYYYMM(d) ORDER BY (k) SETTINGS index_granularity = 8192",
+		},
+	}
+
+	oldReplicated, oldTemplate, oldReplica, oldLegacyDDL := replicated, zooPathTemplate, replicaName, legacyDDL
+	defer func() {
+		replicated, zooPathTemplate, replicaName, legacyDDL = oldReplicated, oldTemplate, oldReplica, oldLegacyDDL
+	}()
+	replicated = true
+	for _, c := range cases {
+		zooPathTemplate, replicaName, legacyDDL = c.template, c.replica, c.legacy
+		if got := c.engine.clause("cpu", tableLayout{dateColumn: "d", orderBy: "k"}); got != c.want {
+			t.Errorf("%s: incorrect clause: got\n%s\nwant\n%s", c.desc, got, c.want)
+		}
+	}
+}
+
+func TestClusterSQL(t *testing.T) {
+	cases := []struct {
+		desc       string
+		cluster    string
+		target     string
+		wantTags   string
+		wantDist   string
+		wantInsert string
+	}{
+		{
+			desc:       "no cluster",
+			target:     insertTargetDistributed,
+			wantTags:   "CREATE TABLE IF NOT EXISTS tags(",
+			wantInsert: "cpu",
+		},
+		{
+			desc:       "distributed",
+			cluster:    "bench",
+			target:     insertTargetDistributed,
+			wantTags:   "CREATE TABLE IF NOT EXISTS tags ON CLUSTER bench(",
+			wantDist:   "CREATE TABLE IF NOT EXISTS cpu_dist ON CLUSTER bench AS cpu ENGINE = Distributed(bench, benchmark, cpu, tags_id)",
+			wantInsert: "cpu_dist",
+		},
+		{
+			desc:       "local",
+			cluster:    "bench",
+			target:     insertTargetLocal,
+			wantTags:   "CREATE TABLE IF NOT EXISTS tags ON CLUSTER bench(",
+			wantDist:   "CREATE TABLE IF NOT EXISTS cpu_dist ON CLUSTER bench AS cpu ENGINE = Distributed(bench, benchmark, cpu, tags_id)",
+			wantInsert: "cpu",
+		},
+	}
+
+	oldCluster, oldTarget := cluster, insertTarget
+	defer func() { cluster, insertTarget = oldCluster, oldTarget }()
+	for _, c := range cases {
+		cluster, insertTarget = c.cluster, c.target
+		if got := normalizeSQL(tagsTableSQL([]string{"hostname"})); !strings.HasPrefix(got, c.wantTags) {
+			t.Errorf("%s: incorrect tags table SQL: got\n%s\nwant prefix\n%s", c.desc, got, c.wantTags)
+		}
+		if len(c.cluster) > 0 {
+			if got := distTableSQL("benchmark", "cpu", "tags_id"); got != c.wantDist {
+				t.Errorf("%s: incorrect Distributed table SQL: got\n%s\nwant\n%s", c.desc, got, c.wantDist)
+			}
+		}
+		if got := insertTable("cpu"); got != c.wantInsert {
+			t.Errorf("%s: incorrect insert table: got %s want %s", c.desc, got, c.wantInsert)
+		}
+	}
+}
+
+func TestDatabaseSQL(t *testing.T) {
+	cases := []struct {
+		desc       string
+		cluster    string
+		engine     string
+		wantCreate string
+		wantDrop   string
+		wantExists string
+	}{
+		{
+			desc:       "server default",
+			wantCreate: "CREATE DATABASE IF NOT EXISTS benchmark",
+			wantDrop:   "DROP DATABASE IF EXISTS benchmark SYNC",
+			wantExists: "SELECT DISTINCT name FROM system.databases WHERE name = 'benchmark'",
+		},
+		{
+			desc:       "engine",
+			engine:     "Atomic",
+			wantCreate: "CREATE DATABASE IF NOT EXISTS benchmark ENGINE = Atomic",
+			wantDrop:   "DROP DATABASE IF EXISTS benchmark SYNC",
+			wantExists: "SELECT DISTINCT name FROM system.databases WHERE name = 'benchmark'",
+		},
+		{
+			desc:       "cluster",
+			cluster:    "bench",
+			engine:     "Ordinary",
+			wantCreate: "CREATE DATABASE IF NOT EXISTS benchmark ON CLUSTER bench ENGINE = Ordinary",
+			wantDrop:   "DROP DATABASE IF EXISTS benchmark ON CLUSTER bench SYNC",
+			wantExists: "SELECT DISTINCT name FROM clusterAllReplicas('bench', system.databases) WHERE name = 'benchmark'",
+		},
+	}
+
+	oldCluster, oldEngine := cluster, dbEngine
+	defer func() { cluster, dbEngine = oldCluster, oldEngine }()
+	for _, c := range cases {
+		cluster, dbEngine = c.cluster, c.engine
+		if got := createDatabaseSQL("benchmark"); got != c.wantCreate {
+			t.Errorf("%s: incorrect create SQL: got %s want %s", c.desc, got, c.wantCreate)
+		}
+		if got := dropDatabaseSQL("benchmark"); got != c.wantDrop {
+			t.Errorf("%s: incorrect drop SQL: got %s want %s", c.desc, got, c.wantDrop)
+		}
+		if got := databaseExistsSQL("benchmark"); got != c.wantExists {
+			t.Errorf("%s: incorrect exists SQL: got %s want %s", c.desc, got, c.wantExists)
+		}
+	}
+
+	for _, engine := range []string{"Atomic", "Replicated('/clickhouse/databases/tsbs', '{shard}', '{replica}')"} {
+		if !dbEngineRegexp.MatchString(engine) {
+			t.Errorf("valid engine %s does not match", engine)
+		}
+	}
+	for _, engine := range []string{"Atomic; DROP TABLE x", "(Atomic)", "Atomic(x"} {
+		if dbEngineRegexp.MatchString(engine) {
+			t.Errorf("invalid engine %s matches", engine)
+		}
+	}
+}
+
+func TestParseColumnCodecs(t *testing.T) {
+	cases := []struct {
+		in          string
+		want        columnCodecs
+		wantTimeCol string
+		wantMetrics []string
+		wantErr     bool
+	}{
+		{
+			in:          "time:DoubleDelta,metrics:Gorilla,ZSTD(3)",
+			want:        columnCodecs{time: "DoubleDelta", metrics: "Gorilla, ZSTD(3)"},
+			wantTimeCol: "created_at DateTime64(9) Codec(DoubleDelta)",
+			wantMetrics: []string{"usage_user Float64 Codec(Gorilla, ZSTD(3))", "usage_total Int64 Codec(DoubleDelta, ZSTD)"},
+		},
+		{
+			in:          "metrics: T64, LZ4HC(9)",
+			want:        columnCodecs{time: "DoubleDelta, ZSTD", metrics: "T64, LZ4HC(9)"},
+			wantTimeCol: "created_at DateTime64(9) Codec(DoubleDelta, ZSTD)",
+			wantMetrics: []string{"usage_user Float64 Codec(T64, LZ4HC(9))", "usage_total Int64 Codec(DoubleDelta, ZSTD)"},
+		},
+		{
+			in:          "time:Delta,ZSTD(1)",
+			want:        columnCodecs{time: "Delta, ZSTD(1)", metrics: "Gorilla, ZSTD"},
+			wantTimeCol: "created_at DateTime64(9) Codec(Delta, ZSTD(1))",
+			wantMetrics: []string{"usage_user Float64 Codec(Gorilla, ZSTD)", "usage_total Int64 Codec(DoubleDelta, ZSTD)"},
+		},
+		{
+			in:          "metrics:NONE,time:LZ4",
+			want:        columnCodecs{time: "LZ4", metrics: "NONE"},
+			wantTimeCol: "created_at DateTime64(9) Codec(LZ4)",
+			wantMetrics: []string{"usage_user Float64 Codec(NONE)", "usage_total Int64 Codec(DoubleDelta, ZSTD)"},
+		},
+		{in: "Gorilla", wantErr: true},
+		{in: "metrics:Gorila", wantErr: true},
+		{in: "metrics:ZSTD(high)", wantErr: true},
+		{in: "metrics:ZSTD(3", wantErr: true},
+		{in: "tags:ZSTD", wantErr: true},
+		{in: "time:LZ4,time:ZSTD", wantErr: true},
+		{in: "time:", wantErr: true},
+	}
+
+	oldCodecs, oldTimePrecision := codecs, timePrecision
+	defer func() { codecs, timePrecision = oldCodecs, oldTimePrecision }()
+	timePrecision = 9
+	for _, c := range cases {
+		got, err := parseColumnCodecs(c.in)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("%s: unexpected lack of error", c.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.in, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%s: incorrect codecs: got %+v want %+v", c.in, got, c.want)
+		}
+		codecs = got
+		if timeCol := timeColumnDefinitions()[0]; timeCol != c.wantTimeCol {
+			t.Errorf("%s: incorrect time column: got %s want %s", c.in, timeCol, c.wantTimeCol)
+		}
+		if metrics := getColumnDefinitions([]string{"usage_user", "usage_total:int64"}); !reflect.DeepEqual(metrics, c.wantMetrics) {
+			t.Errorf("%s: incorrect metrics columns: got %v want %v", c.in, metrics, c.wantMetrics)
+		}
+	}
+}
+
+func TestTTLExpression(t *testing.T) {
+	cases := []struct {
+		desc    string
+		ttl     time.Duration
+		action  string
+		legacy  bool
+		want    string
+		wantErr bool
+	}{
+		{desc: "no TTL", action: ttlActionDelete, want: ""},
+		{desc: "days", ttl: 30 * 24 * time.Hour, action: ttlActionDelete, want: "toDateTime(created_at) + INTERVAL 30 DAY DELETE"},
+		{desc: "hours", ttl: 36 * time.Hour, action: ttlActionDelete, want: "toDateTime(created_at) + INTERVAL 36 HOUR DELETE"},
+		{desc: "minutes", ttl: 90 * time.Minute, action: "TO VOLUME 'cold'", want: "toDateTime(created_at) + INTERVAL 90 MINUTE TO VOLUME 'cold'"},
+		{desc: "seconds", ttl: 90 * time.Second, action: "TO DISK 's3'", want: "toDateTime(created_at) + INTERVAL 90 SECOND TO DISK 's3'"},
+		{desc: "negative", ttl: -time.Hour, action: ttlActionDelete, wantErr: true},
+		{desc: "fraction of a second", ttl: 1500 * time.Millisecond, action: ttlActionDelete, wantErr: true},
+		{desc: "unknown action", ttl: time.Hour, action: "RECOMPRESS", wantErr: true},
+		{desc: "unquoted volume", ttl: time.Hour, action: "TO VOLUME cold", wantErr: true},
+		{desc: "quote in volume", ttl: time.Hour, action: "TO VOLUME 'co'ld'", wantErr: true},
+		{desc: "legacy", ttl: time.Hour, action: ttlActionDelete, legacy: true, wantErr: true},
+	}
+
+	oldTTL, oldTTLAction, oldLegacyDDL := ttl, ttlAction, legacyDDL
+	defer func() { ttl, ttlAction, legacyDDL = oldTTL, oldTTLAction, oldLegacyDDL }()
+	for _, c := range cases {
+		legacyDDL = c.legacy
+		err := checkTTL(c.ttl, c.action)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("%s: unexpected lack of error", c.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.desc, err)
+			continue
+		}
+		ttl, ttlAction = c.ttl, c.action
+		if got := ttlExpression(); got != c.want {
+			t.Errorf("%s: incorrect TTL expression: got %s want %s", c.desc, got, c.want)
+		}
+		want := "ENGINE = MergeTree PARTITION BY toYYYYMM(d) ORDER BY (k) SETTINGS index_granularity = 8192"
+		if len(c.want) > 0 {
+			want = "ENGINE = MergeTree PARTITION BY toYYYYMM(d) ORDER BY (k) TTL " + c.want + " SETTINGS index_granularity = 8192"
+		}
+		if got := (mergeTreeEngine{}).clause("cpu", tableLayout{dateColumn: "d", orderBy: "k", ttl: ttlExpression()}); got != want {
+			t.Errorf("%s: incorrect clause: got\n%s\nwant\n%s", c.desc, got, want)
+		}
+	}
+}
+
+func TestOrderBy(t *testing.T) {
+	cases := []struct {
+		desc       string
+		orderBy    []string
+		inTableTag bool
+		wantOrder  string
+		wantErr    bool
+	}{
+		{desc: "tags first", orderBy: []string{"tags_id", "created_at"}, wantOrder: "ORDER BY (tags_id, created_at)"},
+		{desc: "time first", orderBy: []string{"created_at", "tags_id"}, wantOrder: "ORDER BY (created_at, tags_id)"},
+		{desc: "time only", orderBy: []string{"created_at"}, wantOrder: "ORDER BY (created_at)"},
+		{desc: "date and metric", orderBy: []string{"created_date", "usage_user", "created_at"}, wantOrder: "ORDER BY (created_date, usage_user, created_at)"},
+		{desc: "in-table tag", orderBy: []string{"hostname", "created_at"}, inTableTag: true, wantOrder: "ORDER BY (hostname, created_at)"},
+		{desc: "tag not in table", orderBy: []string{"hostname", "created_at"}, wantErr: true},
+		{desc: "unknown column", orderBy: []string{"tags_id", "time"}, wantErr: true},
+	}
+
+	oldOrderBy, oldInTableTag, oldTimePrecision := orderBy, inTableTag, timePrecision
+	defer func() { orderBy, inTableTag, timePrecision = oldOrderBy, oldInTableTag, oldTimePrecision }()
+	timePrecision = 9
+	columnSpecs := []string{"usage_user", "usage_system:int64"}
+	for _, c := range cases {
+		orderBy, inTableTag = c.orderBy, c.inTableTag
+		err := checkOrderBy("cpu", orderBy, metricsColumnNames(columnSpecs, []string{"hostname", "region"}))
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("%s: unexpected lack of error", c.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.desc, err)
+			continue
+		}
+		if got := metricsTableSQL("cpu", nil, nil); !strings.Contains(got, c.wantOrder+" ") {
+			t.Errorf("%s: incorrect sorting key: got\n%s\nwant %s", c.desc, got, c.wantOrder)
+		}
+	}
+}
+
+func TestPartitionExpression(t *testing.T) {
+	cases := []struct {
+		chunk time.Duration
+		want  string
+	}{
+		{chunk: time.Minute, want: "d"},
+		{chunk: 12 * time.Hour, want: "d"},
+		{chunk: 24 * time.Hour, want: "d"},
+		{chunk: 47 * time.Hour, want: "d"},
+		{chunk: 48 * time.Hour, want: "toStartOfInterval(d, INTERVAL 2 DAY)"},
+		{chunk: 7*24*time.Hour + time.Hour, want: "toStartOfInterval(d, INTERVAL 7 DAY)"},
+		{chunk: 29 * 24 * time.Hour, want: "toStartOfInterval(d, INTERVAL 29 DAY)"},
+		{chunk: 30 * 24 * time.Hour, want: "toYYYYMM(d)"},
+		{chunk: 365 * 24 * time.Hour, want: "toYYYYMM(d)"},
+	}
+	for _, c := range cases {
+		if got := partitionExpression("d", c.chunk); got != c.want {
+			t.Errorf("%v: incorrect partition expression: got %s want %s", c.chunk, got, c.want)
+		}
+	}
+
+	oldChunkTime := chunkTime
+	defer func() { chunkTime = oldChunkTime }()
+	chunkTime = 24 * time.Hour
+	if got := metricsTableSQL("cpu", nil, nil); !strings.Contains(got, "PARTITION BY created_date ORDER BY") {
+		t.Errorf("incorrect partitioning of metrics table by day:\n%s", got)
+	}
+}
+
+func TestGranularitySettings(t *testing.T) {
+	cases := []struct {
+		desc        string
+		granularity int
+		bytes       int
+		want        string
+	}{
+		{desc: "defaults", granularity: 8192, bytes: -1, want: "SETTINGS index_granularity = 8192"},
+		{desc: "rows", granularity: 1024, bytes: -1, want: "SETTINGS index_granularity = 1024"},
+		{desc: "bytes", granularity: 8192, bytes: 1 << 20, want: "SETTINGS index_granularity = 8192, index_granularity_bytes = 1048576"},
+		{desc: "non-adaptive", granularity: 4096, bytes: 0, want: "SETTINGS index_granularity = 4096, index_granularity_bytes = 0"},
+	}
+
+	oldGranularity, oldBytes := indexGranularity, indexGranularityBytes
+	defer func() { indexGranularity, indexGranularityBytes = oldGranularity, oldBytes }()
+	for _, c := range cases {
+		indexGranularity, indexGranularityBytes = c.granularity, c.bytes
+		if got := normalizeSQL(tagsTableSQL([]string{"hostname"})); !strings.HasSuffix(got, c.want) {
+			t.Errorf("%s: incorrect tags table settings: got\n%s\nwant suffix\n%s", c.desc, got, c.want)
+		}
+		if got := normalizeSQL(metricsTableSQL("cpu", nil, nil)); !strings.HasSuffix(got, c.want) {
+			t.Errorf("%s: incorrect metrics table settings: got\n%s\nwant suffix\n%s", c.desc, got, c.want)
+		}
+	}
+}
+
+func TestIndexDefinitions(t *testing.T) {
+	cases := []struct {
+		desc           string
+		fieldIndex     string
+		count          int
+		additionalTags string
+		want           []string
+		wantErr        bool
+	}{
+		{desc: "no index", want: nil},
+		{
+			desc:       "tags_id only",
+			fieldIndex: "minmax:4",
+			want:       []string{"INDEX idx_tags_id tags_id TYPE minmax GRANULARITY 4"},
+		},
+		{
+			desc:       "some fields",
+			fieldIndex: "set(100):2",
+			count:      1,
+			want: []string{
+				"INDEX idx_tags_id tags_id TYPE set(100) GRANULARITY 2",
+				"INDEX idx_usage_user usage_user TYPE set(100) GRANULARITY 2",
+			},
+		},
+		{
+			desc:           "all fields and additional tags",
+			fieldIndex:     "minmax:1",
+			count:          -1,
+			additionalTags: "tokenbf_v1(512, 3, 0):4",
+			want: []string{
+				"INDEX idx_tags_id tags_id TYPE minmax GRANULARITY 1",
+				"INDEX idx_usage_user usage_user TYPE minmax GRANULARITY 1",
+				"INDEX idx_usage_system usage_system TYPE minmax GRANULARITY 1",
+				"INDEX idx_additional_tags additional_tags TYPE tokenbf_v1(512, 3, 0) GRANULARITY 4",
+			},
+		},
+		{
+			desc:           "additional tags only",
+			additionalTags: "bloom_filter(0.01):8",
+			want:           []string{"INDEX idx_additional_tags additional_tags TYPE bloom_filter(0.01) GRANULARITY 8"},
+		},
+		{desc: "no granularity", fieldIndex: "minmax", wantErr: true},
+		{desc: "bad granularity", fieldIndex: "minmax:0", wantErr: true},
+		{desc: "unknown type", fieldIndex: "btree:4", wantErr: true},
+		{desc: "bad parameters", additionalTags: "tokenbf_v1(512):4", wantErr: true},
+	}
+
+	oldFieldIndex, oldCount, oldAdditionalTags := fieldIndex, fieldIndexCount, additionalTagsIndex
+	defer func() { fieldIndex, fieldIndexCount, additionalTagsIndex = oldFieldIndex, oldCount, oldAdditionalTags }()
+	for _, c := range cases {
+		var err error
+		fieldIndex, err = parseSkipIndex(c.fieldIndex)
+		if err == nil {
+			additionalTagsIndex, err = parseSkipIndex(c.additionalTags)
+		}
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("%s: unexpected lack of error", c.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.desc, err)
+			continue
+		}
+		fieldIndexCount = c.count
+		got := getIndexDefinitions([]string{"usage_user", "usage_system"})
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: incorrect indexes: got %v want %v", c.desc, got, c.want)
+		}
+		sql := normalizeSQL(metricsTableSQL("cpu", []string{"usage_user Float64"}, got))
+		wantSQL := "additional_tags String DEFAULT '' )"
+		if len(c.want) > 0 {
+			wantSQL = "additional_tags String DEFAULT '', " + strings.Join(c.want, ", ") + " )"
+		}
+		if !strings.Contains(sql, wantSQL) {
+			t.Errorf("%s: incorrect indexes in SQL: got\n%s\nwant\n%s", c.desc, sql, wantSQL)
+		}
+	}
+}
+
+func TestNullableColumnDefinitions(t *testing.T) {
+	oldNullable := nullableFields
+	defer func() { nullableFields = oldNullable }()
+	nullableFields = true
+
+	got := getColumnDefinitions([]string{"usage_user", "usage_total:int64", "status:string"})
+	want := []string{
+		"usage_user Nullable(Float64) Codec(Gorilla, ZSTD)",
+		"usage_total Nullable(Int64) Codec(DoubleDelta, ZSTD)",
+		"status String Codec(ZSTD)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect column definitions: got\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestSingleTableSpec(t *testing.T) {
+	oldSingleTable, oldTimePrecision := singleTable, timePrecision
+	defer func() { singleTable, timePrecision = oldSingleTable, oldTimePrecision }()
+	singleTable, timePrecision = true, 9
+
+	got := singleTableSpec([][]string{
+		{"cpu", "usage_user", "usage_system"},
+		{"mem", "total:uint64", "used_percent"},
+		{"disk", "used", "free"},
+	})
+	want := []string{"metrics", "cpu_usage_user", "cpu_usage_system", "mem_total:uint64", "mem_used_percent", "disk_used", "disk_free"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect single table spec: got %v want %v", got, want)
+	}
+
+	wantSQL := "CREATE TABLE IF NOT EXISTS metrics ( created_date Date DEFAULT toDate(created_at), " +
+		"created_at DateTime64(9) Codec(DoubleDelta, ZSTD), measurement LowCardinality(String), tags_id UInt32, " +
+		"cpu_usage_user Float64 Codec(Gorilla, ZSTD),mem_total UInt64 Codec(DoubleDelta, ZSTD), additional_tags String DEFAULT '' ) ENGINE"
+	sql := normalizeSQL(metricsTableSQL("metrics", getColumnDefinitions([]string{"cpu_usage_user", "mem_total:uint64"}), nil))
+	if !strings.HasPrefix(sql, wantSQL) {
+		t.Errorf("incorrect single table SQL: got\n%s\nwant prefix\n%s", sql, wantSQL)
+	}
+	if err := checkOrderBy("metrics", orderBy, metricsColumnNames(got[1:], nil)); err != nil {
+		t.Errorf("unexpected error ordering the single table: %v", err)
+	}
+	oldOrderBy := orderBy
+	defer func() { orderBy = oldOrderBy }()
+	orderBy = []string{"measurement", "tags_id", "created_at"}
+	if err := checkOrderBy("metrics", orderBy, metricsColumnNames(got[1:], nil)); err != nil {
+		t.Errorf("unexpected error ordering the single table by measurement: %v", err)
+	}
+}
+
+func TestDenormalizedTagsSQL(t *testing.T) {
+	oldCols, oldDenormalize, oldTimePrecision := tableCols, denormalizeTags, timePrecision
+	oldOrderBy, oldShardingKey := orderBy, shardingKey
+	defer func() {
+		tableCols, denormalizeTags, timePrecision = oldCols, oldDenormalize, oldTimePrecision
+		orderBy, shardingKey = oldOrderBy, oldShardingKey
+	}()
+	tags := []string{"hostname", "region"}
+	tableCols = map[string][]string{"tags": tags}
+	denormalizeTags, timePrecision = true, 9
+	orderBy, shardingKey = []string{"tags_id", "created_at"}, "tags_id"
+
+	want := "CREATE TABLE IF NOT EXISTS cpu ( created_date Date DEFAULT toDate(created_at), " +
+		"created_at DateTime64(9) Codec(DoubleDelta, ZSTD), hostname LowCardinality(String),region LowCardinality(String), " +
+		"usage_user Float64 Codec(Gorilla, ZSTD), additional_tags String DEFAULT '' ) " +
+		"ENGINE = MergeTree PARTITION BY toYYYYMM(created_date) ORDER BY (hostname, region, created_at)"
+	if got := normalizeSQL(metricsTableSQL("cpu", getColumnDefinitions([]string{"usage_user"}), nil)); !strings.HasPrefix(got, want) {
+		t.Errorf("incorrect metrics table SQL: got\n%s\nwant prefix\n%s", got, want)
+	}
+	columns := metricsColumnNames([]string{"usage_user"}, tags)
+	if err := checkOrderBy("cpu", sortingKey(tags), columns); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := checkOrderBy("cpu", []string{"tags_id"}, columns); err == nil {
+		t.Errorf("unexpected lack of error ordering by tags_id")
+	}
+	if got := metricsShardingKey(tags); got != "cityHash64(hostname, region)" {
+		t.Errorf("incorrect sharding key: got %s want cityHash64(hostname, region)", got)
+	}
+	shardingKey = "rand()"
+	if got := metricsShardingKey(tags); got != "rand()" {
+		t.Errorf("incorrect sharding key: got %s want rand()", got)
+	}
+
+	oldFieldIndex := fieldIndex
+	defer func() { fieldIndex = oldFieldIndex }()
+	fieldIndex = &skipIndex{indexType: "minmax", granularity: 1}
+	wantIndexes := []string{
+		"INDEX idx_hostname hostname TYPE minmax GRANULARITY 1",
+		"INDEX idx_region region TYPE minmax GRANULARITY 1",
+	}
+	if got := getIndexDefinitions(nil); !reflect.DeepEqual(got, wantIndexes) {
+		t.Errorf("incorrect indexes: got %v want %v", got, wantIndexes)
+	}
+}
+
+func TestAdditionalTagsMapSQL(t *testing.T) {
+	oldCols, oldAdditionalTagsMap, oldIndex := tableCols, additionalTagsMap, additionalTagsIndex
+	defer func() { tableCols, additionalTagsMap, additionalTagsIndex = oldCols, oldAdditionalTagsMap, oldIndex }()
+	tableCols = map[string][]string{"tags": {"hostname"}}
+	additionalTagsMap = true
+	additionalTagsIndex = &skipIndex{indexType: "bloom_filter", granularity: 4}
+
+	indexes := getIndexDefinitions(nil)
+	want := "additional_tags Map(String, String), " +
+		"INDEX idx_additional_tags mapKeys(additional_tags) TYPE bloom_filter GRANULARITY 4 )"
+	if got := normalizeSQL(metricsTableSQL("cpu", getColumnDefinitions([]string{"usage_user"}), indexes)); !strings.Contains(got, want) {
+		t.Errorf("incorrect metrics table SQL: got\n%s\nwant to contain\n%s", got, want)
+	}
+}
+
+func TestRollupSQL(t *testing.T) {
+	oldCols, oldTypes, oldInterval, oldTimePrecision := tableCols, tableColTypes, rollupInterval, timePrecision
+	defer func() {
+		tableCols, tableColTypes, rollupInterval, timePrecision = oldCols, oldTypes, oldInterval, oldTimePrecision
+	}()
+	tableCols = map[string][]string{"tags": {"hostname"}, "cpu": {"usage_user", "status"}}
+	tableColTypes = map[string][]string{"cpu": {columnTypeFloat64, columnTypeString}}
+	timePrecision = 9
+
+	names := []struct {
+		interval time.Duration
+		want     string
+	}{
+		{time.Hour, "cpu_rollup_1h"},
+		{24 * time.Hour, "cpu_rollup_1d"},
+		{90 * time.Minute, "cpu_rollup_90m"},
+		{45 * time.Second, "cpu_rollup_45s"},
+	}
+	for _, c := range names {
+		rollupInterval = c.interval
+		if got := rollupTableName("cpu"); got != c.want {
+			t.Errorf("incorrect rollup table name for %v: got %s want %s", c.interval, got, c.want)
+		}
+	}
+
+	rollupInterval = time.Hour
+	wantTable := "CREATE TABLE IF NOT EXISTS cpu_rollup_1h ( created_date Date DEFAULT toDate(bucket), bucket DateTime, " +
+		"tags_id UInt32, usage_user_avg AggregateFunction(avg, Float64), usage_user_min AggregateFunction(min, Float64), " +
+		"usage_user_max AggregateFunction(max, Float64) ) ENGINE = AggregatingMergeTree() " +
+		"PARTITION BY toYYYYMM(created_date) ORDER BY (tags_id, bucket) SETTINGS index_granularity = 8192"
+	if got := normalizeSQL(rollupTableSQL("cpu")); got != wantTable {
+		t.Errorf("incorrect rollup table SQL: got\n%s\nwant\n%s", got, wantTable)
+	}
+	wantView := "CREATE MATERIALIZED VIEW IF NOT EXISTS cpu_rollup_1h_mv TO cpu_rollup_1h AS SELECT " +
+		"toStartOfInterval(toDateTime(created_at), INTERVAL 1 HOUR) AS bucket, tags_id, " +
+		"avgState(usage_user) AS usage_user_avg, minState(usage_user) AS usage_user_min, maxState(usage_user) AS usage_user_max " +
+		"FROM cpu GROUP BY bucket, tags_id"
+	if got := normalizeSQL(rollupViewSQL("cpu")); got != wantView {
+		t.Errorf("incorrect rollup view SQL: got\n%s\nwant\n%s", got, wantView)
+	}
+}
+
+func TestParseProjections(t *testing.T) {
+	cases := []struct {
+		desc    string
+		spec    string
+		want    []string
+		wantErr bool
+	}{
+		{desc: "none", spec: ""},
+		{
+			desc: "order by",
+			spec: "by_time:ORDER BY (created_at, tags_id)",
+			want: []string{"PROJECTION by_time (SELECT * ORDER BY (created_at, tags_id))"},
+		},
+		{
+			desc: "several with select",
+			spec: "by_time: ORDER BY created_at ; hourly:select tags_id, max(usage_user) GROUP BY tags_id;",
+			want: []string{
+				"PROJECTION by_time (SELECT * ORDER BY created_at)",
+				"PROJECTION hourly (select tags_id, max(usage_user) GROUP BY tags_id)",
+			},
+		},
+		{desc: "no query", spec: "by_time", wantErr: true},
+		{desc: "empty query", spec: "by_time: ", wantErr: true},
+		{desc: "bad name", spec: "by time:ORDER BY created_at", wantErr: true},
+		{desc: "duplicate", spec: "p:ORDER BY created_at;p:ORDER BY tags_id", wantErr: true},
+	}
+	for _, c := range cases {
+		ps, err := parseProjections(c.spec)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("%s: unexpected lack of error", c.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.desc, err)
+			continue
+		}
+		var got []string
+		for _, p := range ps {
+			got = append(got, p.definition())
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: incorrect projections: got %v want %v", c.desc, got, c.want)
+		}
+	}
+}
+
+func TestCheckProjectionsVersion(t *testing.T) {
+	cases := []struct {
+		version string
+		wantErr bool
+	}{
+		{version: "21.6.1.1"},
+		{version: "21.8.10.19"},
+		{version: "23.3.2.37"},
+		{version: "21.5.9.4", wantErr: true},
+		{version: "20.12.3.3", wantErr: true},
+		{version: "x.y", wantErr: true},
+	}
+	for _, c := range cases {
+		if err := checkProjectionsVersion(c.version); (err != nil) != c.wantErr {
+			t.Errorf("%s: incorrect error: got %v want error %v", c.version, err, c.wantErr)
+		}
+	}
+}
+
+func TestSchemaStatements(t *testing.T) {
+	cases := []struct {
+		desc    string
+		tags    string
+		cluster string
+		rollups time.Duration
+		want    []string
+		wantErr bool
+	}{
+		{
+			desc: "tables",
+			tags: "tags,hostname,region",
+			want: []string{
+				"CREATE TABLE IF NOT EXISTS tags(",
+				"TRUNCATE TABLE tags",
+				"CREATE TABLE IF NOT EXISTS cpu (",
+				"TRUNCATE TABLE cpu",
+				"CREATE TABLE IF NOT EXISTS mem (",
+				"TRUNCATE TABLE mem",
+			},
+		},
+		{
+			desc:    "cluster with rollups",
+			tags:    "tags,hostname,region",
+			cluster: "bench",
+			rollups: time.Hour,
+			want: []string{
+				"CREATE TABLE IF NOT EXISTS tags ON CLUSTER bench(",
+				"TRUNCATE TABLE tags ON CLUSTER bench",
+				"CREATE TABLE IF NOT EXISTS tags_dist ON CLUSTER bench AS tags",
+				"CREATE TABLE IF NOT EXISTS cpu ON CLUSTER bench (",
+				"TRUNCATE TABLE cpu ON CLUSTER bench",
+				"CREATE TABLE IF NOT EXISTS cpu_dist ON CLUSTER bench AS cpu",
+				"CREATE TABLE IF NOT EXISTS cpu_rollup_1h ON CLUSTER bench (",
+				"CREATE MATERIALIZED VIEW IF NOT EXISTS cpu_rollup_1h_mv ON CLUSTER bench TO cpu_rollup_1h",
+				"TRUNCATE TABLE cpu_rollup_1h ON CLUSTER bench",
+				"CREATE TABLE IF NOT EXISTS cpu_rollup_1h_dist ON CLUSTER bench AS cpu_rollup_1h",
+				"CREATE TABLE IF NOT EXISTS mem ON CLUSTER bench (",
+				"TRUNCATE TABLE mem ON CLUSTER bench",
+				"CREATE TABLE IF NOT EXISTS mem_dist ON CLUSTER bench AS mem",
+				"CREATE TABLE IF NOT EXISTS mem_rollup_1h ON CLUSTER bench (",
+				"CREATE MATERIALIZED VIEW IF NOT EXISTS mem_rollup_1h_mv ON CLUSTER bench TO mem_rollup_1h",
+				"TRUNCATE TABLE mem_rollup_1h ON CLUSTER bench",
+				"CREATE TABLE IF NOT EXISTS mem_rollup_1h_dist ON CLUSTER bench AS mem_rollup_1h",
+			},
+		},
+		{desc: "bad header", tags: "hostname,region", wantErr: true},
+	}
+
+	oldCols, oldTypes, oldCluster, oldRollups := tableCols, tableColTypes, cluster, rollupInterval
+	defer func() { tableCols, tableColTypes, cluster, rollupInterval = oldCols, oldTypes, oldCluster, oldRollups }()
+	for _, c := range cases {
+		tableCols, tableColTypes = make(map[string][]string), make(map[string][]string)
+		cluster, rollupInterval = c.cluster, c.rollups
+		d := &dbCreator{tags: c.tags, cols: []string{"cpu,usage_user", "mem,total:uint64"}}
+		createDB, statements, err := d.schemaStatements("benchmark")
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("%s: unexpected lack of error", c.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.desc, err)
+			continue
+		}
+		if want := createDatabaseSQL("benchmark"); createDB != want {
+			t.Errorf("%s: incorrect create database SQL: got %s want %s", c.desc, createDB, want)
+		}
+		if len(statements) != len(c.want) {
+			t.Errorf("%s: incorrect number of statements: got %d want %d", c.desc, len(statements), len(c.want))
+			continue
+		}
+		for i, sql := range statements {
+			if got := normalizeSQL(sql); !strings.HasPrefix(got, c.want[i]) {
+				t.Errorf("%s: incorrect statement %d: got\n%s\nwant prefix\n%s", c.desc, i, got, c.want[i])
+			}
+		}
+		if !reflect.DeepEqual(tableCols["cpu"], []string{"usage_user"}) || !reflect.DeepEqual(tableColTypes["mem"], []string{columnTypeUInt64}) {
+			t.Errorf("%s: incorrect columns: got %v and %v", c.desc, tableCols, tableColTypes)
+		}
+	}
+}
+
+func TestWriteDDL(t *testing.T) {
+	statements := []string{"\n\tCREATE TABLE IF NOT EXISTS cpu (\n\t\ttags_id UInt32\n\t) ENGINE = Log\n", "TRUNCATE TABLE cpu"}
+	var b bytes.Buffer
+	if err := writeDDL(&b, "benchmark", "CREATE DATABASE IF NOT EXISTS benchmark", statements); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "CREATE DATABASE IF NOT EXISTS benchmark;\n\n" +
+		"USE benchmark;\n\n" +
+		"CREATE TABLE IF NOT EXISTS cpu (\n\t\ttags_id UInt32\n\t) ENGINE = Log;\n\n" +
+		"TRUNCATE TABLE cpu;\n\n"
+	if got := b.String(); got != want {
+		t.Errorf("incorrect DDL: got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/timescale/tsbs/cmd/tsbs_generate_data/serialize"
+)
+
+// debugLogger writes the lines of -debug at most its level to out, stderr
+// rather than stdout, where the results of the loader are printed. Lines
+// printed for each batch are rate-limited, for them not to slow down the load.
+type debugLogger struct {
+	mutex sync.Mutex
+	out   io.Writer
+	level int
+	// interval is the least time between lines of the same key of printEvery
+	interval time.Duration
+	now      func() time.Time
+	last     map[string]time.Time
+	skipped  map[string]int
+}
+
+// debugLog is the debugLogger of -debug
+var debugLog = newDebugLogger(os.Stderr, 0)
+
+func newDebugLogger(out io.Writer, level int) *debugLogger {
+	return &debugLogger{
+		out:      out,
+		level:    level,
+		interval: time.Second,
+		now:      time.Now,
+		last:     make(map[string]time.Time),
+		skipped:  make(map[string]int),
+	}
+}
+
+// enabled tells whether lines of level are printed
+func (l *debugLogger) enabled(level int) bool {
+	return l != nil && l.level >= level
+}
+
+// printf prints a line of level, formatted by format and args
+func (l *debugLogger) printf(level int, format string, args ...interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.write(fmt.Sprintf(format, args...))
+}
+
+// printEvery prints a line of level, formatted by format and args, unless a
+// line of the same key was printed less than interval ago. The number of
+// lines skipped since the last one is printed along with it.
+func (l *debugLogger) printEvery(level int, key string, format string, args ...interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.now()
+	if last, ok := l.last[key]; ok && now.Sub(last) < l.interval {
+		l.skipped[key]++
+		return
+	}
+	line := fmt.Sprintf(format, args...)
+	if n := l.skipped[key]; n > 0 {
+		line = fmt.Sprintf("%s (%d similar lines skipped)", line, n)
+	}
+	l.last[key], l.skipped[key] = now, 0
+	l.write(line)
+}
+
+// write writes line, ending it with a newline. The caller holds the mutex.
+func (l *debugLogger) write(line string) {
+	if !strings.HasSuffix(line, "\n") {
+		line += "\n"
+	}
+	io.WriteString(l.out, line)
+}
+
+// debugRowMetrics is the number of metrics of the rows dumped at -debug 2
+const debugRowMetrics = 5
+
+// debugRow describes row, of tableName, for its tags and first metrics to be
+// compared with the columns of the table, e.g., "tags hostname=host_0,...,
+// metrics usage_user=58 usage_system=2 ... (10 values for 10 columns)"
+func debugRow(tableName string, row *insertData) string {
+	metrics := serialize.SplitTextFields(row.fields, ',')[1:]
+	cols := tableCols[tableName]
+	var b strings.Builder
+	fmt.Fprintf(&b, "tags %s, metrics", row.tags)
+	for i, v := range metrics {
+		if i == debugRowMetrics {
+			b.WriteString(" ...")
+			break
+		}
+		col := "?"
+		if i < len(cols) {
+			col = cols[i]
+		}
+		fmt.Fprintf(&b, " %s=%s", col, v)
+	}
+	fmt.Fprintf(&b, " (%d values for %d columns)", len(metrics), len(cols))
+	return b.String()
+}
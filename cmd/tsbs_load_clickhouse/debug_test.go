@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestDebugLoggerLevels(t *testing.T) {
+	for level, want := range []string{
+		"",
+		"schema 1%\n",
+		"schema 1%\ninsert 2\n",
+	} {
+		var b bytes.Buffer
+		l := newDebugLogger(&b, level)
+		l.printf(1, "schema %d%%", 1)
+		l.printf(2, "insert %d\n", 2)
+		if got := b.String(); got != want {
+			t.Errorf("level %d: incorrect output: got %q want %q", level, got, want)
+		}
+	}
+
+	// SQL with % is printed as it is
+	var b bytes.Buffer
+	l := newDebugLogger(&b, 1)
+	sql := "CREATE TABLE t (a String DEFAULT '%s %d')"
+	l.printf(1, "%s", sql)
+	if got := b.String(); got != sql+"\n" {
+		t.Errorf("incorrect SQL printed: got %q want %q", got, sql+"\n")
+	}
+
+	// A nil logger prints nothing
+	var nilLogger *debugLogger
+	nilLogger.printf(0, "nothing")
+}
+
+func TestDebugLoggerRateLimit(t *testing.T) {
+	var b bytes.Buffer
+	l := newDebugLogger(&b, 2)
+	now := time.Unix(1451606400, 0)
+	l.now = func() time.Time { return now }
+
+	// Lines of each key are printed once per second, with the number of those
+	// skipped in between
+	for i := 0; i < 5; i++ {
+		l.printEvery(2, "cpu", "cpu %d", i)
+		l.printEvery(2, "mem", "mem %d", i)
+		now = now.Add(300 * time.Millisecond)
+	}
+	l.printEvery(3, "cpu", "cpu at level 3")
+	want := "cpu 0\nmem 0\ncpu 4 (3 similar lines skipped)\nmem 4 (3 similar lines skipped)\n"
+	if got := b.String(); got != want {
+		t.Errorf("incorrect output: got %q want %q", got, want)
+	}
+}
+
+func TestDebugRow(t *testing.T) {
+	oldCols := tableCols
+	defer func() { tableCols = oldCols }()
+	tableCols = map[string][]string{"cpu": {"usage_user", "usage_system"}, "mem": {"a", "b", "c", "d", "e", "f"}}
+
+	cases := []struct {
+		table  string
+		fields string
+		want   string
+	}{
+		{table: "cpu", fields: "1451606400,58,2", want: "tags hostname=host_0, metrics usage_user=58 usage_system=2 (2 values for 2 columns)"},
+		{table: "cpu", fields: "1451606400,58,2,3", want: "tags hostname=host_0, metrics usage_user=58 usage_system=2 ?=3 (3 values for 2 columns)"},
+		{table: "cpu", fields: "1451606400", want: "tags hostname=host_0, metrics (0 values for 2 columns)"},
+		{table: "mem", fields: "1451606400,1,2,3,4,5,6", want: "tags hostname=host_0, metrics a=1 b=2 c=3 d=4 e=5 ... (6 values for 6 columns)"},
+	}
+	for _, c := range cases {
+		got := debugRow(c.table, &insertData{tags: "hostname=host_0", fields: c.fields})
+		if got != c.want {
+			t.Errorf("%s: incorrect row: got %q want %q", c.fields, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+// Policies assigning the servers of -hosts to workers
+const (
+	// hostPolicyRoundRobin connects worker n to host n % len(hosts)
+	hostPolicyRoundRobin = "round-robin"
+	// hostPolicyAltHosts connects all workers to the first host, the driver
+	// failing over to the others as alt_hosts
+	hostPolicyAltHosts = "alt-hosts"
+	// hostPolicyRandom connects each worker to a host picked at random
+	hostPolicyRandom = "random"
+)
+
+// parseHosts parses the comma-separated hosts of -hosts, each a hostname or
+// IP address with an optional :port, into host:port addresses, defaultPort
+// being the port of those without.
+func parseHosts(spec, defaultPort string) ([]string, error) {
+	var addresses []string
+	for _, h := range strings.Split(spec, ",") {
+		h = strings.TrimSpace(h)
+		hostname, hostPort := h, defaultPort
+		// The port follows the last colon, unless it is within an IPv6
+		// address, which must then be in brackets
+		if i := strings.LastIndex(h, ":"); i > strings.LastIndex(h, "]") && (strings.HasPrefix(h, "[") || strings.Count(h, ":") == 1) {
+			hostname, hostPort = h[:i], h[i+1:]
+		}
+		if len(hostname) == 0 {
+			return nil, fmt.Errorf("invalid -hosts '%s': hosts must not be empty", spec)
+		}
+		if err := checkAddress(hostname, hostPort); err != nil {
+			return nil, fmt.Errorf("invalid -hosts '%s': %v", spec, err)
+		}
+		addresses = append(addresses, hostname+":"+hostPort)
+	}
+	return addresses, nil
+}
+
+// serverAddress returns the host:port of the server the schema is created
+// through: the first of -hosts, else -host and -port
+func serverAddress() string {
+	if len(hosts) > 0 {
+		return hosts[0]
+	}
+	return host + ":" + port
+}
+
+// hostIndex returns the index of the host, out of numHosts, that policy
+// assigns to worker workerNum
+func hostIndex(policy string, workerNum, numHosts int) int {
+	switch policy {
+	case hostPolicyRoundRobin:
+		return workerNum % numHosts
+	case hostPolicyRandom:
+		return rand.Intn(numHosts)
+	default:
+		return 0
+	}
+}
+
+// workerAddress returns the host:port of the server worker workerNum inserts
+// into, as assigned by -host-policy
+func workerAddress(workerNum int) string {
+	if len(hosts) == 0 {
+		return serverAddress()
+	}
+	return hosts[hostIndex(hostPolicy, workerNum, len(hosts))]
+}
+
+// workerAddresses returns the address of the server of worker workerNum,
+// followed by the other hosts it fails over to with the alt-hosts policy
+func workerAddresses(workerNum int) []string {
+	addresses := []string{workerAddress(workerNum)}
+	if hostPolicy == hostPolicyAltHosts && len(hosts) > 1 {
+		addresses = append(addresses, hosts[1:]...)
+	}
+	return addresses
+}
+
+// workerConnectString returns the connect string of worker workerNum to the
+// database on its server, with the insertSettings, along with the other hosts
+// as alt_hosts with the alt-hosts policy
+func workerConnectString(workerNum int) string {
+	addresses := workerAddresses(workerNum)
+	connectString := getServerConnectString(addresses[0], true) + settingsParams(insertSettings())
+	if len(addresses) > 1 {
+		connectString += "&alt_hosts=" + strings.Join(addresses[1:], ",")
+	}
+	return connectString
+}
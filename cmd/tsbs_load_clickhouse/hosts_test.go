@@ -0,0 +1,134 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseHosts(t *testing.T) {
+	cases := []struct {
+		desc      string
+		spec      string
+		want      []string
+		shouldErr bool
+	}{
+		{desc: "one host", spec: "ch1", want: []string{"ch1:9000"}},
+		{desc: "ports", spec: "ch1:9001, ch2,10.0.0.3:9440", want: []string{"ch1:9001", "ch2:9000", "10.0.0.3:9440"}},
+		{desc: "IPv6", spec: "[::1],[fe80::1]:9001", want: []string{"[::1]:9000", "[fe80::1]:9001"}},
+		{desc: "IPv6 without brackets", spec: "::1", shouldErr: true},
+		{desc: "empty host", spec: "ch1,,ch2", shouldErr: true},
+		{desc: "empty hostname", spec: ":9001", shouldErr: true},
+		{desc: "invalid port", spec: "ch1:x", shouldErr: true},
+		{desc: "URL", spec: "http://ch1", shouldErr: true},
+	}
+	for _, c := range cases {
+		got, err := parseHosts(c.spec, "9000")
+		if c.shouldErr {
+			if err == nil {
+				t.Errorf("%s: unexpected lack of error", c.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.desc, err)
+		} else if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: incorrect hosts: got %v want %v", c.desc, got, c.want)
+		}
+	}
+}
+
+func TestHostIndex(t *testing.T) {
+	const numHosts, numWorkers = 3, 8
+	cases := []struct {
+		policy string
+		want   []int
+	}{
+		{policy: hostPolicyRoundRobin, want: []int{0, 1, 2, 0, 1, 2, 0, 1}},
+		{policy: hostPolicyAltHosts, want: []int{0, 0, 0, 0, 0, 0, 0, 0}},
+	}
+	for _, c := range cases {
+		got := make([]int, numWorkers)
+		for i := range got {
+			got[i] = hostIndex(c.policy, i, numHosts)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: incorrect hosts: got %v want %v", c.policy, got, c.want)
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		if got := hostIndex(hostPolicyRandom, i, numHosts); got < 0 || got >= numHosts {
+			t.Fatalf("random: incorrect host of worker %d: got %d want less than %d", i, got, numHosts)
+		}
+	}
+}
+
+func TestWorkerConnectString(t *testing.T) {
+	oldHost, oldPort, oldHosts, oldHostPolicy := host, port, hosts, hostPolicy
+	defer func() { host, port, hosts, hostPolicy = oldHost, oldPort, oldHosts, oldHostPolicy }()
+	host, port = "localhost", "9000"
+
+	cases := []struct {
+		desc      string
+		hosts     []string
+		policy    string
+		workerNum int
+		want      string
+	}{
+		{desc: "no hosts", policy: hostPolicyRoundRobin, workerNum: 3, want: "tcp://localhost:9000?"},
+		{desc: "round-robin", hosts: []string{"ch1:9000", "ch2:9001"}, policy: hostPolicyRoundRobin, workerNum: 3, want: "tcp://ch2:9001?"},
+		{desc: "alt-hosts", hosts: []string{"ch1:9000", "ch2:9001", "ch3:9000"}, policy: hostPolicyAltHosts, workerNum: 3, want: "tcp://ch1:9000?"},
+	}
+	for _, c := range cases {
+		hosts, hostPolicy = c.hosts, c.policy
+		got := workerConnectString(c.workerNum)
+		if !strings.HasPrefix(got, c.want) {
+			t.Errorf("%s: incorrect connect string: got %s want prefix %s", c.desc, got, c.want)
+		}
+		hasAltHosts := strings.HasSuffix(got, "&alt_hosts=ch2:9001,ch3:9000")
+		if wantAltHosts := c.policy == hostPolicyAltHosts; hasAltHosts != wantAltHosts {
+			t.Errorf("%s: incorrect alt_hosts: got %s", c.desc, got)
+		}
+		if !strings.Contains(got, "&database=") {
+			t.Errorf("%s: connect string lacks the database: got %s", c.desc, got)
+		}
+	}
+
+	hosts = []string{"ch1:9000", "ch2:9001"}
+	if got := getConnectString(false); !strings.HasPrefix(got, "tcp://ch1:9000?") {
+		t.Errorf("incorrect connect string of the schema: got %s want the first host", got)
+	}
+}
+
+func TestWorkerConnectStringAsyncInsert(t *testing.T) {
+	oldHost, oldPort, oldHosts := host, port, hosts
+	oldAsyncInsert, oldWaitForAsyncInsert := asyncInsert, waitForAsyncInsert
+	defer func() {
+		host, port, hosts = oldHost, oldPort, oldHosts
+		asyncInsert, waitForAsyncInsert = oldAsyncInsert, oldWaitForAsyncInsert
+	}()
+	host, port, hosts = "localhost", "9000", nil
+
+	cases := []struct {
+		asyncInsert        bool
+		waitForAsyncInsert bool
+		want               string
+	}{
+		{asyncInsert: false, waitForAsyncInsert: true, want: ""},
+		{asyncInsert: true, waitForAsyncInsert: true, want: "&async_insert=1&wait_for_async_insert=1"},
+		{asyncInsert: true, waitForAsyncInsert: false, want: "&async_insert=1&wait_for_async_insert=0"},
+	}
+	for _, c := range cases {
+		asyncInsert, waitForAsyncInsert = c.asyncInsert, c.waitForAsyncInsert
+		want := getConnectString(true) + c.want
+		if got := workerConnectString(0); got != want {
+			t.Errorf("incorrect connect string with async insert %v and wait %v: got %s want %s",
+				c.asyncInsert, c.waitForAsyncInsert, got, want)
+		}
+		// Only the connections inserting rows insert asynchronously
+		if got := getConnectString(true); strings.Contains(got, "async_insert") {
+			t.Errorf("incorrect connect string of the schema: got %s want no async_insert", got)
+		}
+	}
+}
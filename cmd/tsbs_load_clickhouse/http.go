@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Protocols the loader talks to ClickHouse with
+const (
+	protocolNative = "native"
+	protocolHTTP   = "http"
+)
+
+// httpConn is a connection to the HTTP interface of ClickHouse, which runs
+// statements and inserts rows as TabSeparated
+type httpConn struct {
+	client   *http.Client
+	url      string
+	database string
+	// settings are sent along with each request, after -ch-settings
+	settings []setting
+}
+
+// newHTTPConn returns a connection to the HTTP interface of the server at
+// address, as host:port, to the database of the benchmark if db is set, with
+// TLS if secure is set
+func newHTTPConn(address string, db bool) (*httpConn, error) {
+	scheme := "http"
+	transport := &http.Transport{ResponseHeaderTimeout: readTimeout, MaxIdleConnsPerHost: connectionsPerWorker}
+	if secure {
+		scheme = "https"
+		config, err := tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = config
+	}
+	c := &httpConn{
+		client: &http.Client{Transport: transport},
+		url:    fmt.Sprintf("%s://%s/", scheme, address),
+	}
+	if db {
+		c.database = loader.DatabaseName()
+	}
+	return c, nil
+}
+
+// do posts query to the server, along with the data read from body if not
+// nil, with the settings of the connection and then those given, and returns
+// the response. The body of error responses is returned as the error.
+func (c *httpConn) do(query string, body io.Reader, settings []setting) ([]byte, error) {
+	params := url.Values{}
+	if len(c.database) > 0 {
+		params.Set("database", c.database)
+	}
+	for _, settings := range [][]setting{chSettings, c.settings, settings} {
+		for _, s := range settings {
+			params.Set(s.name, s.value)
+		}
+	}
+	if body == nil {
+		body = strings.NewReader(query)
+	} else {
+		params.Set("query", query)
+	}
+	req, err := http.NewRequest(http.MethodPost, c.url+"?"+params.Encode(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-ClickHouse-User", user)
+	req.Header.Set("X-ClickHouse-Key", password)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	out, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
+
+// Exec runs a statement without arguments, as sqlx.DB.Exec
+func (c *httpConn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	if len(args) > 0 {
+		return nil, fmt.Errorf("statements run over HTTP take no arguments")
+	}
+	if _, err := c.do(query, nil, nil); err != nil {
+		return nil, err
+	}
+	return driver.ResultNoRows, nil
+}
+
+// queryStrings returns the values of the single column of the rows of query
+func (c *httpConn) queryStrings(query string) ([]string, error) {
+	out, err := c.do(query+" FORMAT TabSeparatedRaw", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, nil
+	}
+	return strings.Split(strings.TrimSuffix(string(out), "\n"), "\n"), nil
+}
+
+// insert inserts rows, the values of cols, into table in a single request,
+// with settings of its own
+func (c *httpConn) insert(table string, cols []string, rows [][]interface{}, settings []setting) error {
+	var b []byte
+	for _, r := range rows {
+		b = appendTSVRow(b, r)
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) FORMAT TabSeparated", table, strings.Join(cols, ","))
+	debugLog.printEvery(2, "insert "+table, "%s", query)
+	if _, err := c.do(query, bytes.NewReader(b), settings); err != nil {
+		return fmt.Errorf("cannot insert %d rows into %s: %v", len(rows), table, err)
+	}
+	return nil
+}
+
+// Close closes the idle connections to the server
+func (c *httpConn) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+// appendTSVRow appends the values of a row, as made by buildRows, to b in the
+// TabSeparated format
+func appendTSVRow(b []byte, row []interface{}) []byte {
+	for i, v := range row {
+		if i > 0 {
+			b = append(b, '\t')
+		}
+		b = appendTSVValue(b, v)
+	}
+	return append(b, '\n')
+}
+
+// tsvEscaper escapes the characters strings of TabSeparated fields cannot hold
+var tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`)
+
+// appendTSVValue appends the TabSeparated field of v to b. Times are given
+// as Unix timestamps, with timePrecision decimal places, so that they do not
+// depend on the time zone of the server.
+func appendTSVValue(b []byte, v interface{}) []byte {
+	switch x := v.(type) {
+	case nil:
+		return append(b, `\N`...)
+	case string:
+		return append(b, tsvEscaper.Replace(x)...)
+	case time.Time:
+		b = strconv.AppendInt(b, x.Unix(), 10)
+		if timePrecision == 0 {
+			return b
+		}
+		frac := strconv.Itoa(1e9 + x.Nanosecond())[1:]
+		return append(append(b, '.'), frac[:timePrecision]...)
+	case float64:
+		return strconv.AppendFloat(b, x, 'g', -1, 64)
+	case int:
+		return strconv.AppendInt(b, int64(x), 10)
+	case int64:
+		return strconv.AppendInt(b, x, 10)
+	case uint8:
+		return strconv.AppendUint(b, uint64(x), 10)
+	case uint32:
+		return strconv.AppendUint(b, uint64(x), 10)
+	case uint64:
+		return strconv.AppendUint(b, x, 10)
+	case map[string]string:
+		// Maps are written as literals, their keys sorted
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b = append(b, '{')
+		for i, k := range keys {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = append(append(append(b, tsvQuote(k)...), ':'), tsvQuote(x[k])...)
+		}
+		return append(b, '}')
+	default:
+		return append(b, tsvEscaper.Replace(fmt.Sprint(x))...)
+	}
+}
+
+// tsvQuoter escapes the characters of strings quoted within TabSeparated
+// fields, which are not escaped again as a whole
+var tsvQuoter = strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\t", `\t`, "\n", `\n`)
+
+// tsvQuote returns s quoted as a string within a TabSeparated field, e.g., a
+// key of a map
+func tsvQuote(s string) string {
+	return "'" + tsvQuoter.Replace(s) + "'"
+}
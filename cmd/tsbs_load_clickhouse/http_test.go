@@ -0,0 +1,150 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppendTSVRow(t *testing.T) {
+	ts := time.Unix(1451606400, 123456789)
+	cases := []struct {
+		desc      string
+		precision int
+		row       []interface{}
+		want      string
+	}{
+		{
+			desc:      "numbers",
+			precision: 9,
+			row:       []interface{}{ts, 3, int64(-4), uint64(5), uint32(6), uint8(1), 0.5, 1e21},
+			want:      "1451606400.123456789\t3\t-4\t5\t6\t1\t0.5\t1e+21\n",
+		},
+		{
+			desc:      "time in milliseconds",
+			precision: 3,
+			row:       []interface{}{ts},
+			want:      "1451606400.123\n",
+		},
+		{
+			desc:      "DateTime and nanoseconds",
+			precision: 0,
+			row:       []interface{}{ts.Truncate(time.Second), uint32(ts.Nanosecond())},
+			want:      "1451606400\t123456789\n",
+		},
+		{
+			desc:      "strings and NULL",
+			precision: 9,
+			row:       []interface{}{"host_0", "a\tb\nc\\d", "", nil},
+			want:      "host_0\ta\\tb\\nc\\\\d\t\t\\N\n",
+		},
+		{
+			desc:      "maps",
+			precision: 9,
+			row:       []interface{}{map[string]string{"url": "/a'b", "nginx_port": "80"}, map[string]string{}},
+			want:      "{'nginx_port':'80','url':'/a\\'b'}\t{}\n",
+		},
+	}
+
+	oldTimePrecision := timePrecision
+	defer func() { timePrecision = oldTimePrecision }()
+	for _, c := range cases {
+		timePrecision = c.precision
+		if got := string(appendTSVRow(nil, c.row)); got != c.want {
+			t.Errorf("%s: incorrect row: got %q want %q", c.desc, got, c.want)
+		}
+	}
+}
+
+func TestHTTPConn(t *testing.T) {
+	type request struct {
+		params url.Values
+		body   string
+		user   string
+	}
+	var requests []request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, request{params: r.URL.Query(), body: string(body), user: r.Header.Get("X-ClickHouse-User")})
+		switch {
+		case strings.Contains(r.URL.Query().Get("query"), "INSERT INTO bad"):
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Code: 27. DB::Exception: Cannot parse input\n"))
+		case strings.HasPrefix(string(body), "SELECT"):
+			w.Write([]byte("benchmark\nother\n"))
+		}
+	}))
+	defer server.Close()
+
+	oldHost, oldPort, oldUser, oldSettings := host, port, user, chSettings
+	defer func() { host, port, user, chSettings = oldHost, oldPort, oldUser, oldSettings }()
+	serverURL, _ := url.Parse(server.URL)
+	host, port, _ = net.SplitHostPort(serverURL.Host)
+	user = "tsbs"
+	chSettings = []setting{{name: "log_comment", value: "a&b"}}
+
+	c, err := newHTTPConn(serverAddress(), true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer c.Close()
+
+	c.settings = []setting{{name: "async_insert", value: "1"}}
+	if _, err := c.Exec("CREATE TABLE cpu (x UInt8) ENGINE = Log"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := c.Exec("SELECT ?", 1); err == nil {
+		t.Errorf("unexpected lack of error running a statement with arguments")
+	}
+	names, err := c.queryStrings("SELECT name FROM system.databases")
+	if err != nil || len(names) != 2 || names[0] != "benchmark" || names[1] != "other" {
+		t.Errorf("incorrect query result: got %v, %v want [benchmark other]", names, err)
+	}
+	rows := [][]interface{}{{1, "host_0"}, {2, "host_1"}}
+	if err := c.insert("tags", []string{"id", "hostname"}, rows, []setting{{name: "insert_deduplication_token", value: "run-0-1-1"}}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err = c.insert("bad", []string{"id"}, [][]interface{}{{1}, {2}, {3}}, nil)
+	if err == nil || !strings.Contains(err.Error(), "3 rows into bad") || !strings.Contains(err.Error(), "Cannot parse input") {
+		t.Errorf("incorrect error: got %v want the table, number of rows and server error", err)
+	}
+
+	if len(requests) != 4 {
+		t.Fatalf("incorrect number of requests: got %d want 4", len(requests))
+	}
+	for i, r := range requests {
+		if r.params.Get("database") != "benchmark" || r.user != "tsbs" {
+			t.Errorf("request %d: incorrect database %s or user %s", i, r.params.Get("database"), r.user)
+		}
+		if got := r.params.Get("log_comment"); got != "a&b" {
+			t.Errorf("request %d: incorrect setting: got %s want a&b", i, got)
+		}
+		if got := r.params.Get("async_insert"); got != "1" {
+			t.Errorf("request %d: incorrect connection setting: got %s want 1", i, got)
+		}
+	}
+	if got := requests[0].body; got != "CREATE TABLE cpu (x UInt8) ENGINE = Log" {
+		t.Errorf("incorrect statement: got %s", got)
+	}
+	if got := requests[1].body; got != "SELECT name FROM system.databases FORMAT TabSeparatedRaw" {
+		t.Errorf("incorrect query: got %s", got)
+	}
+	insert := requests[2]
+	if got, want := insert.params.Get("query"), "INSERT INTO tags (id,hostname) FORMAT TabSeparated"; got != want {
+		t.Errorf("incorrect insert query: got %s want %s", got, want)
+	}
+	if got, want := insert.body, "1\thost_0\n2\thost_1\n"; got != want {
+		t.Errorf("incorrect insert body: got %q want %q", got, want)
+	}
+	if got := insert.params.Get("insert_deduplication_token"); got != "run-0-1-1" {
+		t.Errorf("incorrect insert setting: got %s want run-0-1-1", got)
+	}
+	if got := requests[3].params.Get("insert_deduplication_token"); got != "" {
+		t.Errorf("incorrect setting of another insert: got %s want none", got)
+	}
+}
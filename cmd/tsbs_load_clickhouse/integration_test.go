@@ -0,0 +1,1402 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/timescale/tsbs/load"
+)
+
+const (
+	// testHostEnv names the environment variable with the host of a
+	// ClickHouse server to run the integration tests against, which are
+	// skipped if it is not set. Its native port is given by -port.
+	testHostEnv = "TSBS_CLICKHOUSE_TEST_HOST"
+	// testDBName is the database the integration tests (re)create
+	testDBName = "tsbs_integration_test"
+)
+
+// testDB returns a connection to an empty test database of the server named
+// by testHostEnv, skipping the test if there is none, and a function dropping
+// the database once done.
+func testDB(t testing.TB) (*sqlx.DB, func()) {
+	testHost := os.Getenv(testHostEnv)
+	if len(testHost) == 0 {
+		t.Skipf("%s is not set", testHostEnv)
+	}
+	oldHost := host
+	host = testHost
+	defer func() { host = oldHost }()
+
+	server, err := sqlx.Connect(dbType, getConnectString(false))
+	if err != nil {
+		t.Fatalf("cannot connect to %s: %v", testHost, err)
+	}
+	defer server.Close()
+	for _, sql := range []string{
+		fmt.Sprintf("DROP DATABASE IF EXISTS %s", testDBName),
+		fmt.Sprintf("CREATE DATABASE %s", testDBName),
+	} {
+		if _, err := server.Exec(sql); err != nil {
+			t.Fatalf("cannot recreate test database: %v", err)
+		}
+	}
+
+	db, err := sqlx.Connect(dbType, fmt.Sprintf("%s&database=%s", getConnectString(false), testDBName))
+	if err != nil {
+		t.Fatalf("cannot connect to test database: %v", err)
+	}
+	return db, func() {
+		db.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s", testDBName))
+		db.Close()
+	}
+}
+
+func TestCreateTablesIntegration(t *testing.T) {
+	db, drop := testDB(t)
+	defer drop()
+
+	createTagsTable(db, []string{"hostname", "region"})
+	createMetricsTable(db, []string{"cpu", "usage_user", "usage_system:int64"})
+
+	var rows []struct {
+		Name       string `db:"name"`
+		EngineFull string `db:"engine_full"`
+	}
+	sql := fmt.Sprintf("SELECT name, engine_full FROM system.tables WHERE database = '%s' ORDER BY name", testDBName)
+	if err := db.Select(&rows, sql); err != nil {
+		t.Fatalf("cannot list tables: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("incorrect number of tables: got %d want 2", len(rows))
+	}
+	wantOrder := map[string]string{"cpu": "ORDER BY (tags_id, created_at)", "tags": "ORDER BY id"}
+	for _, row := range rows {
+		if !strings.Contains(row.EngineFull, "PARTITION BY toYYYYMM(created_date)") {
+			t.Errorf("table %s is not partitioned by month: %s", row.Name, row.EngineFull)
+		}
+		if !strings.Contains(row.EngineFull, wantOrder[row.Name]) {
+			t.Errorf("table %s is not sorted by %s: %s", row.Name, wantOrder[row.Name], row.EngineFull)
+		}
+	}
+}
+
+func TestCreateTablesEnginesIntegration(t *testing.T) {
+	db, drop := testDB(t)
+	defer drop()
+
+	oldEngine := metricsEngine
+	defer func() { metricsEngine = oldEngine }()
+	for _, spec := range []string{"MergeTree", "ReplacingMergeTree(created_at)", "SummingMergeTree"} {
+		var err error
+		metricsEngine, err = parseMergeTreeEngine(spec)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", spec, err)
+		}
+		table := strings.ToLower(metricsEngine.name)
+		createMetricsTable(db, []string{table, "usage_user", "usage_system:int64"})
+
+		var engines []string
+		sql := fmt.Sprintf("SELECT engine FROM system.tables WHERE database = '%s' AND name = '%s'", testDBName, table)
+		if err := db.Get(&engines, sql); err != nil {
+			t.Fatalf("%s: cannot read engine: %v", spec, err)
+		}
+		if len(engines) != 1 || engines[0] != metricsEngine.name {
+			t.Errorf("%s: incorrect engine: got %v want %s", spec, engines, metricsEngine.name)
+		}
+	}
+}
+
+// TestReplicatedIntegration needs the server to have a (test) keeper and the
+// shard and replica macros, as a single-node cluster would.
+func TestReplicatedIntegration(t *testing.T) {
+	db, drop := testDB(t)
+	defer drop()
+	var keeperNodes uint64
+	if err := db.Get(&keeperNodes, "SELECT count() FROM system.zookeeper WHERE path = '/'"); err != nil {
+		t.Skipf("server has no keeper: %v", err)
+	}
+
+	oldHost, oldReplicated, oldTemplate := host, replicated, zooPathTemplate
+	defer func() { host, replicated, zooPathTemplate = oldHost, oldReplicated, oldTemplate }()
+	host = os.Getenv(testHostEnv)
+	replicated = true
+	zooPathTemplate = "/clickhouse/tables/{shard}/" + testDBName + "/{table}"
+
+	createTagsTable(db, []string{"hostname", "region"})
+	createMetricsTable(db, []string{"cpu", "usage_user", "usage_system:int64"})
+	if _, err := db.Exec("INSERT INTO cpu (tags_id, usage_user, usage_system) VALUES (1, 0.5, 2)"); err != nil {
+		t.Fatalf("cannot insert into replicated table: %v", err)
+	}
+	var engines []string
+	sql := fmt.Sprintf("SELECT engine FROM system.tables WHERE database = '%s' ORDER BY name", testDBName)
+	if err := db.Get(&engines, sql); err != nil {
+		t.Fatalf("cannot list tables: %v", err)
+	}
+	if len(engines) != 2 || engines[0] != "ReplicatedMergeTree" || engines[1] != "ReplicatedMergeTree" {
+		t.Errorf("incorrect engines: got %v want 2 ReplicatedMergeTree", engines)
+	}
+
+	// The tables can be created again right after the database is dropped
+	d := &dbCreator{}
+	for i := 0; i < 2; i++ {
+		if err := d.RemoveOldDB(testDBName); err != nil {
+			t.Fatalf("cannot drop replicated database: %v", err)
+		}
+		if d.DBExists(testDBName) {
+			t.Fatalf("database still exists after drop")
+		}
+		server := sqlx.MustConnect(dbType, getConnectString(false))
+		_, err := server.Exec(fmt.Sprintf("CREATE DATABASE %s", testDBName))
+		server.Close()
+		if err != nil {
+			t.Fatalf("cannot create database again: %v", err)
+		}
+		again := sqlx.MustConnect(dbType, fmt.Sprintf("%s&database=%s", getConnectString(false), testDBName))
+		createMetricsTable(again, []string{"cpu", "usage_user", "usage_system:int64"})
+		again.Close()
+	}
+}
+
+// testClusterEnv names the environment variable with the cluster of the
+// server named by testHostEnv to run the Distributed tables test on, which is
+// skipped if it is not set.
+const testClusterEnv = "TSBS_CLICKHOUSE_TEST_CLUSTER"
+
+func TestDistributedIntegration(t *testing.T) {
+	testCluster := os.Getenv(testClusterEnv)
+	if len(testCluster) == 0 {
+		t.Skipf("%s is not set", testClusterEnv)
+	}
+	db, drop := testDB(t)
+	defer drop()
+
+	oldHost, oldCluster, oldTarget := host, cluster, insertTarget
+	defer func() { host, cluster, insertTarget = oldHost, oldCluster, oldTarget }()
+	host = os.Getenv(testHostEnv)
+	cluster = testCluster
+	insertTarget = insertTargetDistributed
+
+	// The test database must exist on every server of the cluster
+	for _, sql := range []string{
+		fmt.Sprintf("DROP DATABASE IF EXISTS %s%s SYNC", testDBName, onCluster()),
+		fmt.Sprintf("CREATE DATABASE %s%s", testDBName, onCluster()),
+	} {
+		if _, err := db.Exec(sql); err != nil {
+			t.Fatalf("cannot recreate test database on cluster: %v", err)
+		}
+	}
+	defer db.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s%s SYNC", testDBName, onCluster()))
+
+	tags := []string{"hostname", "region"}
+	createTagsTable(db, tags)
+	tableCols["tags"] = tags
+	createDistTable(db, testDBName, "tags", "id")
+	createMetricsTable(db, []string{"cpu", "usage_user"})
+	createDistTable(db, testDBName, "cpu", shardingKey)
+
+	const numHosts, numRows = 20, 1000
+	rows := make([]*insertData, numRows)
+	for i := range rows {
+		rows[i] = &insertData{
+			tags:   fmt.Sprintf("hostname=host_%d,region=eu-west-1", i%numHosts),
+			fields: fmt.Sprintf("%d,%d", 1451606400+i, i),
+		}
+	}
+	p := &processor{db: db, csi: newSyncCSI()}
+	mustProcessCSI(t, p, "cpu", rows)
+
+	for _, table := range []string{"tags", "cpu"} {
+		if _, err := db.Exec(fmt.Sprintf("SYSTEM FLUSH DISTRIBUTED %s%s", table, distTableSuffix)); err != nil {
+			t.Fatalf("cannot flush %s%s: %v", table, distTableSuffix, err)
+		}
+	}
+	var shards []struct {
+		Shard uint32 `db:"shard"`
+		Rows  uint64 `db:"rows"`
+	}
+	sql := fmt.Sprintf("SELECT _shard_num AS shard, count() AS rows FROM cpu%s GROUP BY shard", distTableSuffix)
+	if err := db.Select(&shards, sql); err != nil {
+		t.Fatalf("cannot count rows per shard: %v", err)
+	}
+	total := uint64(0)
+	for _, s := range shards {
+		total += s.Rows
+	}
+	if total != numRows {
+		t.Errorf("incorrect number of rows: got %d want %d", total, numRows)
+	}
+	// Rows are sharded by host, so shards get whole hosts' rows
+	for _, s := range shards {
+		if mean := total / uint64(len(shards)); s.Rows < mean/2 || s.Rows > mean*3/2 {
+			t.Errorf("shard %d is unbalanced: got %d rows, %d on average", s.Shard, s.Rows, mean)
+		}
+	}
+}
+
+func TestTimePrecisionIntegration(t *testing.T) {
+	db, drop := testDB(t)
+	defer drop()
+
+	oldTimePrecision := timePrecision
+	defer func() { timePrecision = oldTimePrecision }()
+	tableCols["tags"] = []string{"hostname"}
+	createTagsTable(db, tableCols["tags"])
+
+	const ns = 1451606401123456789
+	for _, precision := range []int{9, 0} {
+		timePrecision = precision
+		table := fmt.Sprintf("cpu_%d", precision)
+		createMetricsTable(db, []string{table, "usage_user"})
+		p := &processor{db: db, csi: newSyncCSI()}
+		mustProcessCSI(t, p, table, []*insertData{{tags: "hostname=host_0", fields: fmt.Sprintf("%d,1", ns)}})
+
+		// Queries select time ranges by comparing created_at to times, which
+		// both representations support
+		nanos := "toUnixTimestamp64Nano(created_at)"
+		if precision == 0 {
+			nanos = fmt.Sprintf("toInt64(toUnixTimestamp(created_at)) * 1000000000 + %s", columnTimeNanos)
+		}
+		var rows []struct {
+			Nanos  int64 `db:"nanos"`
+			DateOK uint8 `db:"date_ok"`
+		}
+		sql := fmt.Sprintf("SELECT %s AS nanos, created_date = toDate(created_at) AS date_ok FROM %s "+
+			"WHERE created_at >= toDateTime('2016-01-01 00:00:01', 'UTC') AND created_at < toDateTime('2016-01-01 00:00:02', 'UTC')",
+			nanos, table)
+		if err := db.Select(&rows, sql); err != nil {
+			t.Fatalf("precision %d: cannot read time: %v", precision, err)
+		}
+		if len(rows) != 1 {
+			t.Fatalf("precision %d: incorrect number of rows: got %d want 1", precision, len(rows))
+		}
+		if rows[0].Nanos != ns {
+			t.Errorf("precision %d: incorrect time: got %d want %d", precision, rows[0].Nanos, int64(ns))
+		}
+		if rows[0].DateOK != 1 {
+			t.Errorf("precision %d: created_date is not the date of created_at", precision)
+		}
+	}
+}
+
+func TestCodecsIntegration(t *testing.T) {
+	db, drop := testDB(t)
+	defer drop()
+
+	oldCodecs, oldTimePrecision := codecs, timePrecision
+	defer func() { codecs, timePrecision = oldCodecs, oldTimePrecision }()
+	timePrecision = 9
+	var err error
+	if codecs, err = parseColumnCodecs("time:DoubleDelta,metrics:Gorilla,ZSTD(3)"); err != nil {
+		t.Fatal(err)
+	}
+	tableCols["tags"] = []string{"hostname"}
+	createTagsTable(db, tableCols["tags"])
+	createMetricsTable(db, []string{"cpu", "usage_user"})
+	p := &processor{db: db, csi: newSyncCSI()}
+	mustProcessCSI(t, p, "cpu", []*insertData{{tags: "hostname=host_0", fields: "1451606400000000000,1.5"}})
+
+	var rows []struct {
+		Name  string `db:"name"`
+		Codec string `db:"compression_codec"`
+	}
+	sql := fmt.Sprintf("SELECT name, compression_codec FROM system.columns WHERE database = '%s' AND table = 'cpu' "+
+		"AND name IN ('created_at', 'usage_user') ORDER BY name", testDBName)
+	if err := db.Select(&rows, sql); err != nil {
+		t.Fatalf("cannot read codecs: %v", err)
+	}
+	want := map[string]string{"created_at": "CODEC(DoubleDelta)", "usage_user": "CODEC(Gorilla, ZSTD(3))"}
+	if len(rows) != len(want) {
+		t.Fatalf("incorrect number of columns: got %d want %d", len(rows), len(want))
+	}
+	for _, row := range rows {
+		if row.Codec != want[row.Name] {
+			t.Errorf("incorrect codec of %s: got %s want %s", row.Name, row.Codec, want[row.Name])
+		}
+	}
+}
+
+func TestTTLIntegration(t *testing.T) {
+	db, drop := testDB(t)
+	defer drop()
+
+	oldTTL, oldTTLAction := ttl, ttlAction
+	defer func() { ttl, ttlAction = oldTTL, oldTTLAction }()
+	ttl, ttlAction = 30*24*time.Hour, ttlActionDelete
+	createMetricsTable(db, []string{"cpu", "usage_user"})
+
+	var create string
+	if err := db.Get(&create, "SHOW CREATE TABLE cpu"); err != nil {
+		t.Fatalf("cannot show table: %v", err)
+	}
+	if want := "TTL toDateTime(created_at) + toIntervalDay(30)"; !strings.Contains(create, want) {
+		t.Errorf("table has no TTL %s:\n%s", want, create)
+	}
+}
+
+func TestOrderByTimeFirstIntegration(t *testing.T) {
+	db, drop := testDB(t)
+	defer drop()
+
+	oldOrderBy := orderBy
+	defer func() { orderBy = oldOrderBy }()
+	orderBy = []string{"created_at", "tags_id"}
+	tableCols["tags"] = []string{"hostname"}
+	createTagsTable(db, tableCols["tags"])
+	createMetricsTable(db, []string{"cpu", "usage_user"})
+
+	const numRows = 100
+	rows := make([]*insertData, numRows)
+	for i := range rows {
+		rows[i] = &insertData{
+			tags:   fmt.Sprintf("hostname=host_%d", i%10),
+			fields: fmt.Sprintf("%d,%d", 1451606400+i, i),
+		}
+	}
+	p := &processor{db: db, csi: newSyncCSI()}
+	mustProcessCSI(t, p, "cpu", rows)
+
+	var sortingKey string
+	sql := fmt.Sprintf("SELECT sorting_key FROM system.tables WHERE database = '%s' AND name = 'cpu'", testDBName)
+	if err := db.Get(&sortingKey, sql); err != nil {
+		t.Fatalf("cannot read sorting key: %v", err)
+	}
+	if sortingKey != "created_at, tags_id" {
+		t.Errorf("incorrect sorting key: got %s want created_at, tags_id", sortingKey)
+	}
+	var count uint64
+	if err := db.Get(&count, "SELECT count() FROM cpu"); err != nil {
+		t.Fatalf("cannot count rows: %v", err)
+	}
+	if count != numRows {
+		t.Errorf("incorrect number of rows: got %d want %d", count, numRows)
+	}
+}
+
+func TestChunkTimeIntegration(t *testing.T) {
+	db, drop := testDB(t)
+	defer drop()
+
+	oldChunkTime := chunkTime
+	defer func() { chunkTime = oldChunkTime }()
+	chunkTime = 7 * 24 * time.Hour
+	tableCols["tags"] = []string{"hostname"}
+	createTagsTable(db, tableCols["tags"])
+	createMetricsTable(db, []string{"cpu", "usage_user"})
+
+	// 4 weeks of hourly rows span 4 partitions of 7 days, or 5 unless they
+	// start at the start of one
+	rows := make([]*insertData, 4*7*24)
+	for i := range rows {
+		rows[i] = &insertData{tags: "hostname=host_0", fields: fmt.Sprintf("%d,%d", 1451606400+3600*i, i)}
+	}
+	p := &processor{db: db, csi: newSyncCSI()}
+	mustProcessCSI(t, p, "cpu", rows)
+
+	var partitions uint64
+	sql := fmt.Sprintf("SELECT uniqExact(partition) FROM system.parts WHERE database = '%s' AND table = 'cpu' AND active", testDBName)
+	if err := db.Get(&partitions, sql); err != nil {
+		t.Fatalf("cannot count partitions: %v", err)
+	}
+	if partitions < 4 || partitions > 5 {
+		t.Errorf("incorrect number of partitions: got %d want 4 or 5", partitions)
+	}
+}
+
+func TestGranularitySettingsIntegration(t *testing.T) {
+	db, drop := testDB(t)
+	defer drop()
+
+	oldGranularity, oldBytes := indexGranularity, indexGranularityBytes
+	defer func() { indexGranularity, indexGranularityBytes = oldGranularity, oldBytes }()
+	indexGranularity, indexGranularityBytes = 1024, 0
+	createTagsTable(db, []string{"hostname"})
+	createMetricsTable(db, []string{"cpu", "usage_user"})
+
+	var rows []struct {
+		Name   string `db:"name"`
+		Create string `db:"create_table_query"`
+	}
+	sql := fmt.Sprintf("SELECT name, create_table_query FROM system.tables WHERE database = '%s'", testDBName)
+	if err := db.Select(&rows, sql); err != nil {
+		t.Fatalf("cannot list tables: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("incorrect number of tables: got %d want 2", len(rows))
+	}
+	for _, row := range rows {
+		if want := "SETTINGS index_granularity = 1024, index_granularity_bytes = 0"; !strings.Contains(row.Create, want) {
+			t.Errorf("table %s is not created with %s:\n%s", row.Name, want, row.Create)
+		}
+	}
+}
+
+func TestIndexesIntegration(t *testing.T) {
+	db, drop := testDB(t)
+	defer drop()
+
+	oldFieldIndex, oldCount, oldAdditionalTags := fieldIndex, fieldIndexCount, additionalTagsIndex
+	defer func() { fieldIndex, fieldIndexCount, additionalTagsIndex = oldFieldIndex, oldCount, oldAdditionalTags }()
+	fieldIndex, fieldIndexCount = &skipIndex{indexType: "minmax", granularity: 4}, 1
+	additionalTagsIndex = &skipIndex{indexType: "tokenbf_v1(512, 3, 0)", granularity: 2}
+	createMetricsTable(db, []string{"cpu", "usage_user", "usage_system"})
+
+	var rows []struct {
+		Name        string `db:"name"`
+		Type        string `db:"type"`
+		Granularity uint64 `db:"granularity"`
+	}
+	sql := fmt.Sprintf("SELECT name, type, granularity FROM system.data_skipping_indices "+
+		"WHERE database = '%s' AND table = 'cpu' ORDER BY name", testDBName)
+	if err := db.Select(&rows, sql); err != nil {
+		t.Fatalf("cannot list indexes: %v", err)
+	}
+	want := []string{"idx_additional_tags tokenbf_v1 2", "idx_tags_id minmax 4", "idx_usage_user minmax 4"}
+	var got []string
+	for _, row := range rows {
+		got = append(got, fmt.Sprintf("%s %s %d", row.Name, row.Type, row.Granularity))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect indexes: got %v want %v", got, want)
+	}
+}
+
+func TestSingleTableIntegration(t *testing.T) {
+	db, drop := testDB(t)
+	defer drop()
+
+	oldSingleTable := singleTable
+	defer func() { singleTable = oldSingleTable }()
+	tableSpecs := [][]string{
+		{"cpu", "usage_user", "usage_system"},
+		{"mem", "total:uint64", "used_percent"},
+	}
+	tableCols["tags"] = []string{"hostname"}
+	createTagsTable(db, tableCols["tags"])
+
+	// The same rows are loaded into a table per measurement, then into the
+	// single table
+	load := func() (metricCnt uint64, rowCnt uint64) {
+		p := &processor{db: db, csi: newSyncCSI()}
+		for _, tableSpec := range tableSpecs {
+			tableCols[tableSpec[0]], tableColTypes[tableSpec[0]] = splitColumnSpecs(tableSpec[1:])
+			rows := make([]*insertData, 50)
+			for i := range rows {
+				rows[i] = &insertData{
+					tags:   fmt.Sprintf("hostname=host_%d", i%5),
+					fields: fmt.Sprintf("%d,%d,%d", 1451606400+i, i, i),
+				}
+			}
+			metricCnt += mustProcessCSI(t, p, tableSpec[0], rows)
+		}
+		tables := "cpu UNION ALL SELECT count() AS c FROM mem"
+		if singleTable {
+			tables = singleTableName
+		}
+		err := db.Get(&rowCnt, fmt.Sprintf("SELECT sum(c) FROM (SELECT count() AS c FROM %s)", tables))
+		if err != nil {
+			t.Fatalf("single table %v: cannot count rows: %v", singleTable, err)
+		}
+		return metricCnt, rowCnt
+	}
+
+	singleTable = false
+	for _, tableSpec := range tableSpecs {
+		createMetricsTable(db, tableSpec)
+	}
+	wantMetrics, wantRows := load()
+
+	singleTable = true
+	createMetricsTable(db, singleTableSpec(tableSpecs))
+	gotMetrics, gotRows := load()
+	if gotMetrics != wantMetrics || gotRows != wantRows {
+		t.Errorf("incorrect counts in single table: got %d metrics in %d rows want %d in %d",
+			gotMetrics, gotRows, wantMetrics, wantRows)
+	}
+	var measurements uint64
+	sql := fmt.Sprintf("SELECT uniqExact(%s) FROM %s", measurementColumn, singleTableName)
+	if err := db.Get(&measurements, sql); err != nil {
+		t.Fatalf("cannot count measurements: %v", err)
+	}
+	if measurements != uint64(len(tableSpecs)) {
+		t.Errorf("incorrect number of measurements: got %d want %d", measurements, len(tableSpecs))
+	}
+}
+
+func TestAdditionalTagsMapIntegration(t *testing.T) {
+	db, drop := testDB(t)
+	defer drop()
+
+	oldAdditionalTagsMap := additionalTagsMap
+	defer func() { additionalTagsMap = oldAdditionalTagsMap }()
+	additionalTagsMap = true
+	tableCols["tags"] = []string{"hostname"}
+	createTagsTable(db, tableCols["tags"])
+	createMetricsTable(db, []string{"cpu", "usage_user"})
+	tableCols["cpu"], tableColTypes["cpu"] = splitColumnSpecs([]string{"usage_user"})
+
+	rows := make([]*insertData, 10)
+	for i := range rows {
+		tags := fmt.Sprintf("hostname=host_%d", i)
+		if i%2 == 0 {
+			tags += fmt.Sprintf(",nginx_port=%d,url=/a=b", 80+i%4)
+		}
+		rows[i] = &insertData{tags: tags, fields: fmt.Sprintf("%d,%d", 1451606400+i, i)}
+	}
+	p := &processor{db: db, csi: newSyncCSI()}
+	mustProcessCSI(t, p, "cpu", rows)
+
+	var cnt uint64
+	sql := "SELECT count() FROM cpu WHERE additional_tags['nginx_port'] = '80' AND additional_tags['url'] = '/a=b'"
+	if err := db.Get(&cnt, sql); err != nil {
+		t.Fatalf("cannot query additional tags: %v", err)
+	}
+	if cnt != 3 {
+		t.Errorf("incorrect number of rows with additional tags: got %d want 3", cnt)
+	}
+}
+
+func TestRollupsIntegration(t *testing.T) {
+	db, drop := testDB(t)
+	defer drop()
+
+	oldInterval := rollupInterval
+	defer func() { rollupInterval = oldInterval }()
+	rollupInterval = time.Hour
+	tableCols["tags"] = []string{"hostname"}
+	createTagsTable(db, tableCols["tags"])
+	createMetricsTable(db, []string{"cpu", "usage_user"})
+	createRollup(db, testDBName, "cpu", "tags_id")
+
+	// One day of cpu rows every 10 minutes
+	const hosts = 5
+	var rows []*insertData
+	for ts := 1451606400; ts < 1451606400+24*3600; ts += 600 {
+		for h := 0; h < hosts; h++ {
+			rows = append(rows, &insertData{
+				tags:   fmt.Sprintf("hostname=host_%d", h),
+				fields: fmt.Sprintf("%d,%d", ts, h),
+			})
+		}
+	}
+	p := &processor{db: db, csi: newSyncCSI()}
+	mustProcessCSI(t, p, "cpu", rows)
+
+	if _, err := db.Exec("OPTIMIZE TABLE cpu_rollup_1h FINAL"); err != nil {
+		t.Fatalf("cannot merge rollups: %v", err)
+	}
+	var cnt uint64
+	if err := db.Get(&cnt, "SELECT count() FROM cpu_rollup_1h"); err != nil {
+		t.Fatalf("cannot count rollups: %v", err)
+	}
+	if cnt != hosts*24 {
+		t.Errorf("incorrect number of rollups: got %d want %d", cnt, hosts*24)
+	}
+	var maxUsage float64
+	if err := db.Get(&maxUsage, "SELECT maxMerge(usage_user_max) FROM cpu_rollup_1h"); err != nil {
+		t.Fatalf("cannot query rollups: %v", err)
+	}
+	if maxUsage != hosts-1 {
+		t.Errorf("incorrect max usage: got %v want %d", maxUsage, hosts-1)
+	}
+}
+
+func TestProjectionsIntegration(t *testing.T) {
+	db, drop := testDB(t)
+	defer drop()
+	if err := checkProjectionsSupport(nativeConn{db}); err != nil {
+		t.Skip(err)
+	}
+
+	oldProjections := projections
+	defer func() { projections = oldProjections }()
+	var err error
+	if projections, err = parseProjections("by_time:ORDER BY (created_at, tags_id)"); err != nil {
+		t.Fatal(err)
+	}
+	tableCols["tags"] = []string{"hostname"}
+	createTagsTable(db, tableCols["tags"])
+	createMetricsTable(db, []string{"cpu", "usage_user"})
+
+	rows := make([]*insertData, 100)
+	for i := range rows {
+		rows[i] = &insertData{
+			tags:   fmt.Sprintf("hostname=host_%d", i%10),
+			fields: fmt.Sprintf("%d,%d", 1451606400+i, i),
+		}
+	}
+	p := &processor{db: db, csi: newSyncCSI()}
+	mustProcessCSI(t, p, "cpu", rows)
+
+	var cnt uint64
+	sql := "SELECT count() FROM system.projection_parts WHERE database = ? AND table = 'cpu' AND name = 'by_time' AND active"
+	if err := db.Get(&cnt, sql, testDBName); err != nil {
+		t.Fatalf("cannot count projection parts: %v", err)
+	}
+	if cnt == 0 {
+		t.Errorf("no parts of projection by_time")
+	}
+}
+
+func TestDatabaseClusterIntegration(t *testing.T) {
+	testCluster := os.Getenv(testClusterEnv)
+	if len(testCluster) == 0 {
+		t.Skipf("%s is not set", testClusterEnv)
+	}
+	db, drop := testDB(t)
+	defer drop()
+
+	oldHost, oldCluster, oldEngine := host, cluster, dbEngine
+	defer func() { host, cluster, dbEngine = oldHost, oldCluster, oldEngine }()
+	host = os.Getenv(testHostEnv)
+	cluster = testCluster
+	dbEngine = "Atomic"
+
+	// The database is recreated on every server, dropping the one of testDB
+	d := &dbCreator{}
+	if err := d.RemoveOldDB(testDBName); err != nil {
+		t.Fatalf("cannot drop database on cluster: %v", err)
+	}
+	if d.DBExists(testDBName) {
+		t.Fatalf("database still exists on cluster after drop")
+	}
+	if _, err := db.Exec(createDatabaseSQL(testDBName)); err != nil {
+		t.Fatalf("cannot create database on cluster: %v", err)
+	}
+	defer db.Exec(dropDatabaseSQL(testDBName))
+	if !d.DBExists(testDBName) {
+		t.Fatalf("database does not exist on cluster after create")
+	}
+
+	var servers, engines uint64
+	sql := fmt.Sprintf("SELECT count() FROM system.clusters WHERE cluster = %s", quoteString(cluster))
+	if err := db.Get(&servers, sql); err != nil {
+		t.Fatalf("cannot count servers: %v", err)
+	}
+	sql = fmt.Sprintf("SELECT count() FROM clusterAllReplicas(%s, system.databases) WHERE name = %s AND engine = %s",
+		quoteString(cluster), quoteString(testDBName), quoteString(dbEngine))
+	if err := db.Get(&engines, sql); err != nil {
+		t.Fatalf("cannot count databases: %v", err)
+	}
+	if engines != servers {
+		t.Errorf("incorrect number of %s databases: got %d want one on each of %d servers", dbEngine, engines, servers)
+	}
+}
+
+// testTLSCAEnv names the environment variable with the CA certificate of the
+// server named by testHostEnv, accepting TLS connections on its default
+// secure port, to run the TLS test against, which is skipped if it is not set.
+const testTLSCAEnv = "TSBS_CLICKHOUSE_TEST_TLS_CA"
+
+func TestSecureIntegration(t *testing.T) {
+	testCA := os.Getenv(testTLSCAEnv)
+	if len(testCA) == 0 {
+		t.Skipf("%s is not set", testTLSCAEnv)
+	}
+	testHost := os.Getenv(testHostEnv)
+	if len(testHost) == 0 {
+		t.Skipf("%s is not set", testHostEnv)
+	}
+
+	oldHost, oldPort, oldSecure, oldSkipVerify, oldCACert := host, port, secure, skipVerify, caCert
+	defer func() { host, port, secure, skipVerify, caCert = oldHost, oldPort, oldSecure, oldSkipVerify, oldCACert }()
+	host, port, secure = testHost, serverPort(port, false, true, protocolNative), true
+	cases := []struct {
+		desc       string
+		skipVerify bool
+		caCert     string
+	}{
+		{desc: "CA", caCert: testCA},
+		{desc: "skip verify", skipVerify: true},
+	}
+	for _, c := range cases {
+		skipVerify, caCert = c.skipVerify, c.caCert
+		if len(caCert) > 0 {
+			if err := registerCACert(caCert); err != nil {
+				t.Fatalf("%s: cannot register CA: %v", c.desc, err)
+			}
+		}
+		db, err := sqlx.Connect(dbType, getConnectString(false))
+		if err != nil {
+			t.Errorf("%s: cannot connect: %v", c.desc, err)
+			continue
+		}
+		var one uint8
+		if err := db.Get(&one, "SELECT 1"); err != nil || one != 1 {
+			t.Errorf("%s: incorrect result: got %d, %v want 1", c.desc, one, err)
+		}
+		db.Close()
+	}
+}
+
+// createLoadTestTables creates the tags table and a cpu table with metrics of
+// several types, and returns rows to load into them, with characters to escape
+func createLoadTestTables(db *sqlx.DB) []*insertData {
+	tableCols["tags"] = []string{"hostname", "region"}
+	createTagsTable(db, tableCols["tags"])
+	cols := []string{"usage_user", "usage_system:int64", "status:string"}
+	createMetricsTable(db, append([]string{"cpu"}, cols...))
+	tableCols["cpu"], tableColTypes["cpu"] = splitColumnSpecs(cols)
+
+	rows := make([]*insertData, 200)
+	for i := range rows {
+		rows[i] = &insertData{
+			tags:   fmt.Sprintf("hostname=host_%d,region=eu-west-1,url=/a'b\\c", i%10),
+			fields: fmt.Sprintf("%d,%d.5,%d,up\tfor %d", 1451606400000000000+int64(i)*1234567, i, -i, i),
+		}
+	}
+	return rows
+}
+
+// mustProcessCSI inserts rows into table with p, failing the test if it
+// cannot, and returns the number of metrics inserted
+func mustProcessCSI(t testing.TB, p *processor, table string, rows []*insertData) uint64 {
+	metricCnt, err := p.processCSI(table, rows)
+	if err != nil {
+		t.Fatalf("cannot insert %d rows into %s: %v", len(rows), table, err)
+	}
+	return metricCnt
+}
+
+// loadSummary sums up the rows loaded by loadTestRows
+type loadSummary struct {
+	Metrics uint64
+	Rows    uint64  `db:"rows"`
+	Tags    uint64  `db:"tags"`
+	Sum     float64 `db:"sum"`
+	MaxTime string  `db:"max_time"`
+	Status  string  `db:"status"`
+	URL     string  `db:"url"`
+}
+
+// loadTestRows loads rows, those of createLoadTestTables, into its emptied
+// tables with p, and sums up the rows loaded
+func loadTestRows(t *testing.T, db *sqlx.DB, p *processor, rows []*insertData) loadSummary {
+	for _, table := range []string{"tags", "cpu"} {
+		if _, err := db.Exec("TRUNCATE TABLE " + table); err != nil {
+			t.Fatalf("cannot truncate %s: %v", table, err)
+		}
+	}
+	r := loadSummary{Metrics: mustProcessCSI(t, p, "cpu", rows)}
+	sql := "SELECT count() AS rows, uniqExact(tags_id) AS tags, sum(usage_user) + sum(usage_system) AS sum, " +
+		"toString(max(created_at)) AS max_time, max(status) AS status, any(additional_tags['url']) AS url FROM cpu"
+	if err := db.Get(&r, sql); err != nil {
+		t.Fatalf("cannot query loaded rows: %v", err)
+	}
+	return r
+}
+
+func TestHTTPIntegration(t *testing.T) {
+	db, drop := testDB(t)
+	defer drop()
+
+	oldHost, oldPort, oldAdditionalTagsMap := host, port, additionalTagsMap
+	defer func() { host, port, additionalTagsMap = oldHost, oldPort, oldAdditionalTagsMap }()
+	host, port, additionalTagsMap = os.Getenv(testHostEnv), serverPort(port, false, secure, protocolHTTP), true
+	rows := createLoadTestTables(db)
+
+	// The same rows are loaded over each protocol into emptied tables
+	want := loadTestRows(t, db, &processor{db: db, csi: newSyncCSI()}, rows)
+	c, err := newHTTPConn(serverAddress(), false)
+	if err != nil {
+		t.Fatalf("cannot connect over HTTP: %v", err)
+	}
+	defer c.Close()
+	c.database = testDBName
+	got := loadTestRows(t, db, &processor{http: c, csi: newSyncCSI()}, rows)
+	if got != want {
+		t.Errorf("incorrect rows loaded over HTTP: got %+v want %+v as over the native protocol", got, want)
+	}
+	if want.Rows != 200 || want.URL != "/a'b\\c" {
+		t.Errorf("incorrect rows loaded over the native protocol: got %+v", want)
+	}
+}
+
+// testHostsEnv names the environment variable with the -hosts of two or more
+// servers to run TestHostsIntegration against
+const testHostsEnv = "TSBS_CLICKHOUSE_TEST_HOSTS"
+
+func TestHostsIntegration(t *testing.T) {
+	testHosts := os.Getenv(testHostsEnv)
+	if len(testHosts) == 0 {
+		t.Skipf("%s is not set", testHostsEnv)
+	}
+	oldHosts, oldHostPolicy := hosts, hostPolicy
+	defer func() { hosts, hostPolicy = oldHosts, oldHostPolicy }()
+	var err error
+	if hosts, err = parseHosts(testHosts, port); err != nil {
+		t.Fatal(err)
+	}
+	hostPolicy = hostPolicyRoundRobin
+
+	// Each server gets its own database and tables
+	tableCols["tags"] = []string{"hostname"}
+	tableCols["cpu"], tableColTypes["cpu"] = splitColumnSpecs([]string{"usage_user"})
+	servers := make([]*sqlx.DB, len(hosts))
+	for i, address := range hosts {
+		server, err := sqlx.Connect(dbType, getServerConnectString(address, false))
+		if err != nil {
+			t.Fatalf("cannot connect to %s: %v", address, err)
+		}
+		defer server.Close()
+		for _, sql := range []string{
+			fmt.Sprintf("DROP DATABASE IF EXISTS %s", testDBName),
+			fmt.Sprintf("CREATE DATABASE %s", testDBName),
+		} {
+			if _, err := server.Exec(sql); err != nil {
+				t.Fatalf("cannot recreate test database on %s: %v", address, err)
+			}
+		}
+		defer server.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s", testDBName))
+		db := sqlx.MustConnect(dbType, fmt.Sprintf("%s&database=%s", getServerConnectString(address, false), testDBName))
+		defer db.Close()
+		createTagsTable(db, tableCols["tags"])
+		createMetricsTable(db, []string{"cpu", "usage_user"})
+		servers[i] = db
+	}
+
+	// Worker n inserts n+1 rows into host n % len(hosts)
+	numWorkers := 2 * len(hosts)
+	for n := 0; n < numWorkers; n++ {
+		db, err := sqlx.Connect(dbType, strings.Replace(workerConnectString(n), "&database="+loader.DatabaseName(), "&database="+testDBName, 1))
+		if err != nil {
+			t.Fatalf("worker %d cannot connect: %v", n, err)
+		}
+		rows := make([]*insertData, n+1)
+		for i := range rows {
+			rows[i] = &insertData{tags: fmt.Sprintf("hostname=host_%d", i), fields: fmt.Sprintf("%d,%d", 1451606400+i, i)}
+		}
+		p := &processor{db: db, csi: newSyncCSI()}
+		mustProcessCSI(t, p, "cpu", rows)
+		db.Close()
+	}
+
+	for i, db := range servers {
+		var cnt int
+		if err := db.Get(&cnt, "SELECT count() FROM cpu"); err != nil {
+			t.Fatalf("cannot count the rows of %s: %v", hosts[i], err)
+		}
+		// Host i gets the rows of workers i and i + len(hosts)
+		if want := i + 1 + i + len(hosts) + 1; cnt != want {
+			t.Errorf("incorrect number of rows inserted into %s: got %d want %d", hosts[i], cnt, want)
+		}
+	}
+}
+
+func TestAsyncInsertIntegration(t *testing.T) {
+	db, drop := testDB(t)
+	defer drop()
+
+	var supported int
+	if err := db.Get(&supported, "SELECT count() FROM system.settings WHERE name = 'async_insert'"); err != nil {
+		t.Fatal(err)
+	}
+	if supported == 0 {
+		t.Skip("server does not support async inserts")
+	}
+
+	oldHost, oldAsyncInsert, oldWaitForAsyncInsert := host, asyncInsert, waitForAsyncInsert
+	defer func() { host, asyncInsert, waitForAsyncInsert = oldHost, oldAsyncInsert, oldWaitForAsyncInsert }()
+	host, asyncInsert, waitForAsyncInsert = os.Getenv(testHostEnv), true, true
+	tableCols["tags"] = []string{"hostname"}
+	createTagsTable(db, tableCols["tags"])
+	createMetricsTable(db, []string{"cpu", "usage_user"})
+	tableCols["cpu"], tableColTypes["cpu"] = splitColumnSpecs([]string{"usage_user"})
+
+	conn := sqlx.MustConnect(dbType, fmt.Sprintf("%s&database=%s%s", getConnectString(false), testDBName, settingsParams(insertSettings())))
+	defer conn.Close()
+	p := &processor{db: conn, csi: newSyncCSI()}
+	// Rows are inserted one at a time, as with -batch-size 1
+	const numRows = 20
+	var metricCnt uint64
+	for i := 0; i < numRows; i++ {
+		row := &insertData{tags: fmt.Sprintf("hostname=host_%d", i%4), fields: fmt.Sprintf("%d,%d", 1451606400+i, i)}
+		metricCnt += mustProcessCSI(t, p, "cpu", []*insertData{row})
+	}
+	if metricCnt != numRows {
+		t.Errorf("incorrect number of metrics: got %d want %d", metricCnt, numRows)
+	}
+
+	// The rows have been written once the inserts waiting for them return
+	var cnt int
+	if err := db.Get(&cnt, "SELECT count() FROM cpu"); err != nil {
+		t.Fatalf("cannot count rows: %v", err)
+	}
+	if cnt != numRows {
+		t.Errorf("incorrect number of rows: got %d want %d", cnt, numRows)
+	}
+
+	// The log of async inserts only exists if the server is configured with it
+	if _, err := db.Exec("SYSTEM FLUSH LOGS"); err != nil {
+		t.Fatalf("cannot flush logs: %v", err)
+	}
+	var logged int
+	if err := db.Get(&logged, "SELECT count() FROM system.tables WHERE database = 'system' AND name = 'asynchronous_insert_log'"); err != nil {
+		t.Fatal(err)
+	}
+	if logged == 0 {
+		t.Log("system.asynchronous_insert_log is not enabled")
+		return
+	}
+	sql := "SELECT count() FROM system.asynchronous_insert_log WHERE database = ? AND table = 'cpu'"
+	if err := db.Get(&logged, sql, testDBName); err != nil {
+		t.Fatalf("cannot query the log of async inserts: %v", err)
+	}
+	if logged == 0 {
+		t.Errorf("incorrect number of async inserts logged: got 0 want some")
+	}
+}
+
+func TestInsertAPIIntegration(t *testing.T) {
+	db, drop := testDB(t)
+	defer drop()
+
+	oldHost, oldAdditionalTagsMap, oldNullableFields := host, additionalTagsMap, nullableFields
+	defer func() { host, additionalTagsMap, nullableFields = oldHost, oldAdditionalTagsMap, oldNullableFields }()
+	host, additionalTagsMap, nullableFields = os.Getenv(testHostEnv), true, true
+	rows := createLoadTestTables(db)
+	// Some rows lack values, inserted as NULL
+	for i := 0; i < len(rows); i += 7 {
+		fields := strings.Split(rows[i].fields, ",")
+		fields[1], fields[3] = "", ""
+		rows[i].fields = strings.Join(fields, ",")
+	}
+
+	// The same rows are loaded with each API into emptied tables
+	want := loadTestRows(t, db, &processor{db: db, csi: newSyncCSI()}, rows)
+	conn, err := openBatchConn([]string{serverAddress()}, testDBName)
+	if err != nil {
+		t.Fatalf("cannot connect with clickhouse-go v2: %v", err)
+	}
+	defer conn.Close()
+	got := loadTestRows(t, db, &processor{conn: conn, csi: newSyncCSI()}, rows)
+	if got != want {
+		t.Errorf("incorrect rows loaded with -insert-api batch: got %+v want %+v as with prepare", got, want)
+	}
+	if want.Rows != 200 {
+		t.Errorf("incorrect rows loaded with -insert-api prepare: got %+v", want)
+	}
+}
+
+// BenchmarkInsertIntegration compares the rows per second inserted with each
+// -insert-api, in batches of cpu rows of 10 metrics
+func BenchmarkInsertIntegration(b *testing.B) {
+	db, drop := testDB(b)
+	defer drop()
+
+	oldHost := host
+	defer func() { host = oldHost }()
+	host = os.Getenv(testHostEnv)
+	tableCols["tags"] = []string{"hostname"}
+	createTagsTable(db, tableCols["tags"])
+	cols := []string{"cpu"}
+	for i := 0; i < 10; i++ {
+		cols = append(cols, fmt.Sprintf("usage_%d", i))
+	}
+	createMetricsTable(db, cols)
+	tableCols["cpu"], tableColTypes["cpu"] = splitColumnSpecs(cols[1:])
+
+	const batchSize = 10000
+	rows := make([]*insertData, batchSize)
+	for i := range rows {
+		rows[i] = &insertData{
+			tags:   fmt.Sprintf("hostname=host_%d", i%100),
+			fields: fmt.Sprintf("%d%s", 1451606400000000000+int64(i)*int64(time.Second), strings.Repeat(",58.5", 10)),
+		}
+	}
+
+	conn, err := openBatchConn([]string{serverAddress()}, testDBName)
+	if err != nil {
+		b.Fatalf("cannot connect with clickhouse-go v2: %v", err)
+	}
+	defer conn.Close()
+	processors := map[string]*processor{
+		insertAPIPrepare: {db: db, csi: newSyncCSI()},
+		insertAPIBatch:   {conn: conn, csi: newSyncCSI()},
+	}
+	for _, api := range []string{insertAPIPrepare, insertAPIBatch} {
+		b.Run(api, func(b *testing.B) {
+			p := processors[api]
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				mustProcessCSI(b, p, "cpu", rows)
+			}
+			b.ReportMetric(float64(b.N*batchSize)/time.Since(start).Seconds(), "rows/s")
+		})
+	}
+}
+
+func TestDedupTokenIntegration(t *testing.T) {
+	db, drop := testDB(t)
+	defer drop()
+
+	var supported int
+	if err := db.Get(&supported, "SELECT count() FROM system.settings WHERE name = 'insert_deduplication_token'"); err != nil {
+		t.Fatal(err)
+	}
+	if supported == 0 {
+		t.Skip("server does not support deduplication tokens")
+	}
+
+	oldHost, oldDedupTokenPrefix := host, dedupTokenPrefix
+	defer func() { host, dedupTokenPrefix = oldHost, oldDedupTokenPrefix }()
+	host, dedupTokenPrefix = os.Getenv(testHostEnv), "test"
+	tableCols["tags"] = []string{"hostname"}
+	createTagsTable(db, tableCols["tags"])
+	createMetricsTable(db, []string{"cpu", "usage_user"})
+	createMetricsTable(db, []string{"mem", "used"})
+	tableCols["cpu"], tableColTypes["cpu"] = splitColumnSpecs([]string{"usage_user"})
+	tableCols["mem"], tableColTypes["mem"] = splitColumnSpecs([]string{"used"})
+	// Tables that are not replicated only deduplicate inserts with a window
+	for _, table := range []string{"tags", "cpu", "mem"} {
+		if _, err := db.Exec("ALTER TABLE " + table + " MODIFY SETTING non_replicated_deduplication_window = 100"); err != nil {
+			t.Fatalf("cannot set the deduplication window of %s: %v", table, err)
+		}
+	}
+
+	conn, err := openBatchConn([]string{serverAddress()}, testDBName)
+	if err != nil {
+		t.Fatalf("cannot connect with clickhouse-go v2: %v", err)
+	}
+	defer conn.Close()
+	// Each run loads the same batches with a worker of its own, as a rerun of
+	// the loader with -hash-workers does
+	counts := make([]int, 2)
+	for run := range counts {
+		p := &processor{conn: conn, csi: newSyncCSI()}
+		for batch := 0; batch < 3; batch++ {
+			b := &tableArr{m: map[string][]*insertData{}}
+			for i := 0; i < 10; i++ {
+				ts := 1451606400 + batch*10 + i
+				tags := fmt.Sprintf("hostname=host_%d", batch)
+				b.m["cpu"] = append(b.m["cpu"], &insertData{tags: tags, fields: fmt.Sprintf("%d,%d", ts, i)})
+				b.m["mem"] = append(b.m["mem"], &insertData{tags: tags, fields: fmt.Sprintf("%d,%d", ts, i)})
+			}
+			p.ProcessBatch(b, true)
+		}
+		sql := "SELECT (SELECT count() FROM tags) + (SELECT count() FROM cpu) + (SELECT count() FROM mem)"
+		if err := db.Get(&counts[run], sql); err != nil {
+			t.Fatalf("run %d: cannot count rows: %v", run, err)
+		}
+	}
+	if want := 3 + 2*30; counts[0] != want || counts[1] != want {
+		t.Errorf("incorrect number of rows: got %d after the first run and %d after the second want %d", counts[0], counts[1], want)
+	}
+}
+
+// BenchmarkConnectionsPerWorkerIntegration compares the rows per second a
+// worker inserts with 1 and 2 connections, in batches of 4 tables
+func BenchmarkConnectionsPerWorkerIntegration(b *testing.B) {
+	db, drop := testDB(b)
+	defer drop()
+
+	oldHost, oldConnectionsPerWorker := host, connectionsPerWorker
+	defer func() { host, connectionsPerWorker = oldHost, oldConnectionsPerWorker }()
+	host = os.Getenv(testHostEnv)
+	tableCols["tags"] = []string{"hostname"}
+	createTagsTable(db, tableCols["tags"])
+	tables := []string{"cpu", "disk", "mem", "net"}
+	cols := []string{"usage_0", "usage_1", "usage_2", "usage_3", "usage_4"}
+	for _, table := range tables {
+		createMetricsTable(db, append([]string{table}, cols...))
+		tableCols[table], tableColTypes[table] = splitColumnSpecs(cols)
+	}
+
+	const rowsPerTable = 5000
+	rows := make([]*insertData, rowsPerTable)
+	for i := range rows {
+		rows[i] = &insertData{
+			tags:   fmt.Sprintf("hostname=host_%d", i%100),
+			fields: fmt.Sprintf("%d%s", 1451606400000000000+int64(i)*int64(time.Second), strings.Repeat(",58.5", len(cols))),
+		}
+	}
+	for _, connections := range []int{1, 2} {
+		b.Run(fmt.Sprintf("connections=%d", connections), func(b *testing.B) {
+			connectionsPerWorker = connections
+			conn := sqlx.MustConnect(dbType, fmt.Sprintf("%s&database=%s", getConnectString(false), testDBName))
+			defer conn.Close()
+			conn.SetMaxOpenConns(connections)
+			conn.SetMaxIdleConns(connections)
+			p := &processor{db: conn, csi: newSyncCSI()}
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				batch := &tableArr{m: map[string][]*insertData{}}
+				for _, table := range tables {
+					batch.m[table] = rows
+				}
+				p.ProcessBatch(batch, true)
+			}
+			b.ReportMetric(float64(b.N*len(tables)*rowsPerTable)/time.Since(start).Seconds(), "rows/s")
+		})
+	}
+}
+
+func TestCompressIntegration(t *testing.T) {
+	db, drop := testDB(t)
+	defer drop()
+
+	var supported int
+	if err := db.Get(&supported, "SELECT count() FROM system.columns WHERE database = 'system' AND table = 'query_log' AND name = 'log_comment'"); err != nil {
+		t.Fatal(err)
+	}
+	if supported == 0 {
+		t.Skip("server does not log the log_comment of queries")
+	}
+
+	oldHost, oldCompress, oldSettings := host, compress, chSettings
+	defer func() { host, compress, chSettings = oldHost, oldCompress, oldSettings }()
+	host = os.Getenv(testHostEnv)
+	rows := createLoadTestTables(db)
+	// More rows make for blocks worth compressing
+	for len(rows) < 2000 {
+		rows = append(rows, rows[:200]...)
+	}
+
+	// The same rows are loaded with each API and compression, the inserts
+	// being told apart in the query log by their log_comment
+	cases := []struct {
+		insertAPI string
+		compress  string
+	}{
+		{insertAPI: insertAPIPrepare, compress: compressNone},
+		{insertAPI: insertAPIPrepare, compress: compressLZ4},
+		{insertAPI: insertAPIBatch, compress: compressNone},
+		{insertAPI: insertAPIBatch, compress: compressLZ4},
+		{insertAPI: insertAPIBatch, compress: compressZSTD},
+	}
+	for _, c := range cases {
+		compress = c.compress
+		chSettings = []setting{{name: "log_comment", value: "tsbs-" + c.insertAPI + "-" + c.compress}}
+		p := &processor{csi: newSyncCSI()}
+		if c.insertAPI == insertAPIPrepare {
+			conn := sqlx.MustConnect(dbType, fmt.Sprintf("%s&database=%s", getConnectString(false), testDBName))
+			defer conn.Close()
+			p.db = conn
+		} else {
+			conn, err := openBatchConn([]string{serverAddress()}, testDBName)
+			if err != nil {
+				t.Fatalf("cannot connect with clickhouse-go v2 and %s: %v", c.compress, err)
+			}
+			defer conn.Close()
+			p.conn = conn
+		}
+		if got := loadTestRows(t, db, p, rows); got.Rows != uint64(len(rows)) {
+			t.Errorf("-insert-api %s -compress %s: incorrect rows loaded: got %+v", c.insertAPI, c.compress, got)
+		}
+	}
+
+	if _, err := db.Exec("SYSTEM FLUSH LOGS"); err != nil {
+		t.Fatalf("cannot flush logs: %v", err)
+	}
+	received := make(map[string]uint64)
+	for _, c := range cases {
+		comment := "tsbs-" + c.insertAPI + "-" + c.compress
+		var bytes uint64
+		sql := "SELECT sum(ProfileEvents['NetworkReceiveBytes']) FROM system.query_log " +
+			"WHERE type = 'QueryFinish' AND log_comment = ? AND query LIKE '%INSERT INTO cpu%'"
+		if err := db.Get(&bytes, sql, comment); err != nil {
+			t.Fatalf("cannot query the log of %s: %v", comment, err)
+		}
+		if bytes == 0 {
+			t.Fatalf("%s: incorrect bytes received: got 0 want some", comment)
+		}
+		received[comment] = bytes
+	}
+	for _, c := range cases {
+		if c.compress == compressNone {
+			continue
+		}
+		comment, uncompressed := "tsbs-"+c.insertAPI+"-"+c.compress, "tsbs-"+c.insertAPI+"-"+compressNone
+		if received[comment] >= received[uncompressed] {
+			t.Errorf("%s: incorrect bytes received: got %d want less than the %d uncompressed", comment, received[comment], received[uncompressed])
+		}
+	}
+}
+
+func TestTagsIDsIntegration(t *testing.T) {
+	db, drop := testDB(t)
+	defer drop()
+
+	oldHost, oldLastTagsID := host, lastTagsID
+	defer func() { host, lastTagsID = oldHost, oldLastTagsID }()
+	host, lastTagsID = os.Getenv(testHostEnv), 0
+	tableCols["tags"] = []string{"hostname"}
+	createTagsTable(db, tableCols["tags"])
+	createMetricsTable(db, []string{"cpu", "usage_user"})
+	tableCols["cpu"], tableColTypes["cpu"] = splitColumnSpecs([]string{"usage_user"})
+
+	// Worker n loads the rows of hosts n*5 to n*5+4, each with its own cache
+	// of tags ids as with -hash-workers, the usage_user of a row being the
+	// number of its host
+	load := func(workerNum int, hosts []int) {
+		p := &processor{db: db, csi: newSyncCSI(), workerNum: workerNum}
+		var rows []*insertData
+		for i, h := range hosts {
+			rows = append(rows, &insertData{tags: fmt.Sprintf("hostname=host_%d", h), fields: fmt.Sprintf("%d,%d", 1451606400+i, h)})
+		}
+		mustProcessCSI(t, p, "cpu", rows)
+	}
+	check := func(desc string, wantTags int) {
+		var r struct {
+			Rows  int `db:"rows"`
+			Wrong int `db:"wrong"`
+		}
+		sql := "SELECT count() AS rows, countIf(tags.hostname != concat('host_', toString(toUInt32(cpu.usage_user)))) AS wrong " +
+			"FROM cpu ANY LEFT JOIN tags ON cpu.tags_id = tags.id"
+		if err := db.Get(&r, sql); err != nil {
+			t.Fatalf("%s: cannot join cpu rows to their tags: %v", desc, err)
+		}
+		if r.Wrong > 0 {
+			t.Errorf("%s: incorrect hostnames: got %d of %d rows joined to the tags of another host", desc, r.Wrong, r.Rows)
+		}
+		var tags, ids int
+		if err := db.QueryRow("SELECT count(), uniqExact(id) FROM tags").Scan(&tags, &ids); err != nil {
+			t.Fatalf("%s: cannot count tags: %v", desc, err)
+		}
+		if tags != wantTags || ids != wantTags {
+			t.Errorf("%s: incorrect tags: got %d rows with %d ids want %d", desc, tags, ids, wantTags)
+		}
+	}
+	for n := 0; n < 2; n++ {
+		load(n, []int{n * 5, n*5 + 1, n*5 + 2, n*5 + 3, n*5 + 4, n * 5})
+	}
+	check("first load", 10)
+
+	// A later load appending to the tables follows the ids of the first
+	lastTagsID = 0
+	if err := readLastTagsID(nativeConn{db}); err != nil {
+		t.Fatal(err)
+	}
+	if lastTagsID != 10 {
+		t.Errorf("incorrect last id: got %d want 10", lastTagsID)
+	}
+	load(0, []int{10, 11})
+	check("appending load", 12)
+}
+
+func TestInsertValuesIntegration(t *testing.T) {
+	db, drop := testDB(t)
+	defer drop()
+
+	oldHost := host
+	defer func() { host = oldHost }()
+	host = os.Getenv(testHostEnv)
+	tableCols["tags"] = []string{"hostname"}
+	createTagsTable(db, tableCols["tags"])
+	createMetricsTable(db, []string{"cpu", "usage_user", "usage_system"})
+	tableCols["cpu"], tableColTypes["cpu"] = splitColumnSpecs([]string{"usage_user", "usage_system"})
+
+	// Values that a round trip through strings or Float32 would change
+	values := [][]string{
+		{"0.1", "58"},
+		{"1.7976931348623157e+308", "5e-324"},
+		{"-0", "123456789.12345679"},
+		{"0.30000000000000004", "-2.2250738585072014e-308"},
+	}
+	var input strings.Builder
+	for i, v := range values {
+		fmt.Fprintf(&input, "tags,hostname=host_%d\ncpu,%d,%s,%s\n", i%2, 1451606400+i, v[0], v[1])
+	}
+
+	for _, api := range []string{insertAPIPrepare, insertAPIBatch} {
+		if _, err := db.Exec("TRUNCATE TABLE cpu"); err != nil {
+			t.Fatal(err)
+		}
+		p := &processor{csi: newSyncCSI()}
+		if api == insertAPIPrepare {
+			p.db = db
+		} else {
+			conn, err := openBatchConn([]string{serverAddress()}, testDBName)
+			if err != nil {
+				t.Fatalf("cannot connect with clickhouse-go v2: %v", err)
+			}
+			defer conn.Close()
+			p.conn = conn
+		}
+		decoder := &decoder{scanner: bufio.NewScanner(strings.NewReader(input.String()))}
+		var rows []*insertData
+		for item := decoder.Decode(nil); item != nil; item = decoder.Decode(nil) {
+			rows = append(rows, item.Data.(*point).row)
+		}
+		mustProcessCSI(t, p, "cpu", rows)
+
+		var got []struct {
+			UsageUser   float64 `db:"usage_user"`
+			UsageSystem float64 `db:"usage_system"`
+		}
+		if err := db.Select(&got, "SELECT usage_user, usage_system FROM cpu ORDER BY created_at"); err != nil {
+			t.Fatalf("-insert-api %s: cannot query values: %v", api, err)
+		}
+		if len(got) != len(values) {
+			t.Fatalf("-insert-api %s: incorrect number of rows: got %d want %d", api, len(got), len(values))
+		}
+		for i, v := range values {
+			for j, value := range []float64{got[i].UsageUser, got[i].UsageSystem} {
+				want, _ := strconv.ParseFloat(v[j], 64)
+				if math.Float64bits(value) != math.Float64bits(want) {
+					t.Errorf("-insert-api %s: incorrect value of row %d: got %v want %s", api, i, value, v[j])
+				}
+			}
+		}
+	}
+}
+
+func TestPostLoadVerifyIntegration(t *testing.T) {
+	db, drop := testDB(t)
+	defer drop()
+
+	oldHost, oldInitialRows := host, initialRows
+	defer func() { host, initialRows = oldHost, oldInitialRows }()
+	host = os.Getenv(testHostEnv)
+	tableCols, tableColTypes = make(map[string][]string), make(map[string][]string)
+	insertedRows.m = make(map[string]uint64)
+	rows := createLoadTestTables(db)
+	if err := readInitialRows(nativeConn{db}); err != nil {
+		t.Fatal(err)
+	}
+	mustProcessCSI(t, &processor{db: db, csi: newSyncCSI()}, "cpu", rows)
+
+	checks, err := verifyRows(nativeConn{db})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []load.VerifyCheck{{Name: "cpu", Want: 200, Got: 200}, {Name: "tags", Want: 10, Got: 10}}
+	if !reflect.DeepEqual(checks, want) {
+		t.Errorf("incorrect checks: got %+v want %+v", checks, want)
+	}
+
+	// Rows deleted behind the back of the loader, the rows of a host, fail
+	// the check of their table once the mutation deleting them is done
+	if _, err := db.Exec("ALTER TABLE cpu DELETE WHERE tags_id = (SELECT min(id) FROM tags)"); err != nil {
+		t.Fatalf("cannot delete rows: %v", err)
+	}
+	for i := 0; ; i++ {
+		var pending int
+		if err := db.Get(&pending, "SELECT count() FROM system.mutations WHERE database = ? AND table = 'cpu' AND NOT is_done", testDBName); err != nil {
+			t.Fatalf("cannot read mutations: %v", err)
+		}
+		if pending == 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatal("rows not deleted in time")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	checks, err = verifyRows(nativeConn{db})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want[0].Got = 180
+	if !reflect.DeepEqual(checks, want) {
+		t.Errorf("incorrect checks after deleting rows: got %+v want %+v", checks, want)
+	}
+}
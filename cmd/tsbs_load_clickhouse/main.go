@@ -6,14 +6,40 @@ package main
 import (
 	"bufio"
 	"flag"
-	"github.com/timescale/tsbs/load"
+	"fmt"
 	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/timescale/tsbs/cmd/tsbs_generate_data/serialize"
+	"github.com/timescale/tsbs/load"
 )
 
 const (
 	dbType       = "clickhouse"
 	timeValueIdx = "TIME-VALUE"
 	valueTimeIdx = "VALUE-TIME"
+
+	timestampPrecisionAuto = "auto"
+
+	emptyFieldsZero    = "zero"
+	emptyFieldsSkipRow = "skip-row"
+
+	insertTargetDistributed = "distributed"
+	insertTargetLocal       = "local"
+
+	// Compression methods of the native protocol
+	compressNone = "none"
+	compressLZ4  = "lz4"
+	compressZSTD = "zstd"
+
+	// Native and HTTP ports of ClickHouse, with and without TLS
+	defaultPort           = "9000"
+	defaultSecurePort     = "9440"
+	defaultHTTPPort       = "8123"
+	defaultSecureHTTPPort = "8443"
 )
 
 // Program option vars:
@@ -22,24 +48,193 @@ var (
 	port     string
 	user     string
 	password string
+	// connectionFile, if set, names the file of the host, port, user and
+	// password not given by their flag or environment variable
+	connectionFile string
+	// hosts, if set, are the host:port addresses of the servers workers
+	// insert into, assigned to them by hostPolicy, instead of host and port
+	hosts      []string
+	hostPolicy string
+	// protocol is the protocol to talk to ClickHouse with: native or http
+	protocol string
+	// insertAPI is the API rows are inserted with over the native protocol:
+	// batch or prepare
+	insertAPI string
+	// compress is the method the blocks sent and received over the native
+	// protocol are compressed with: none, lz4 or zstd
+	compress string
+	// readTimeout and writeTimeout, if positive, are the timeouts of the
+	// driver reading from and writing to the server
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	// chSettings are the ClickHouse settings sent along with each query
+	chSettings []setting
+	// asyncInsert, if set, has the server buffer the inserted rows and write
+	// them asynchronously, acknowledging each insert once they are written if
+	// waitForAsyncInsert is set, as soon as they are buffered otherwise
+	asyncInsert        bool
+	waitForAsyncInsert bool
+	// insertQuorum, if positive, is the number of replicas each insert must
+	// be written to to succeed
+	insertQuorum int
+	// dedupTokenPrefix, if set, starts the insert_deduplication_token of each
+	// insert, for the server to skip those of a previous run
+	dedupTokenPrefix string
+	// numWorkers is the -workers of the loader, by which the ids of the tags
+	// rows of the workers are interleaved with -dedup-token-prefix
+	numWorkers int
+
+	// secure, if set, connects with TLS, verifying the certificate of the
+	// server against caCert if set, or not at all with skipVerify
+	secure     bool
+	skipVerify bool
+	caCert     string
+
+	// maxRetries is the number of times a failed insert is retried, waiting
+	// retryBackoff, doubled on each retry, if its error is retryable
+	maxRetries   int
+	retryBackoff time.Duration
+
+	// connectionsPerWorker is the number of connections of each worker, over
+	// which the tables of its batches are inserted concurrently
+	connectionsPerWorker int
+
+	// batchPerTable, if set, has each worker hold back the rows of each table
+	// until there are tableBatchSize, the -batch-size of the loader, to
+	// insert them at once
+	batchPerTable  bool
+	tableBatchSize int
 
 	logBatches  bool
 	inTableTag  bool
 	hashWorkers bool
+	// hashFunction hashes the hostnames of the points to workers with
+	// hashWorkers: hashFNV or hashJump
+	hashFunction string
+
+	// batchLogFile, if set, is the file the inserts of the tables of the
+	// batches are logged into as CSV (see batchLogHeader)
+	batchLogFile string
+
+	// dynamicTags, if set, tells that the tag values of a host change over
+	// time, so a tags row is identified by all of its values, not the hostname
+	dynamicTags bool
+
+	// dbEngine, if set, is the engine of the database, e.g., Atomic
+	dbEngine string
+
+	// legacyDDL, if set, creates tables with the deprecated MergeTree syntax
+	// that old servers need
+	legacyDDL bool
+	// metricsEngine and tagsEngine are the engines of the metrics tables and
+	// of the tags table
+	metricsEngine mergeTreeEngine
+	tagsEngine    mergeTreeEngine
+	// chunkTime is the time span of the partitions of metrics tables
+	chunkTime time.Duration
+	// orderBy are the columns metrics tables are sorted by, their primary key
+	orderBy []string
+	// ttl, if not 0, is the age of the metrics rows ttlAction is applied to,
+	// e.g., DELETE or TO VOLUME 'cold'
+	ttl       time.Duration
+	ttlAction string
+	// projections are the projections of metrics tables, materialized in
+	// their existing parts as well if materializeProjections is set
+	projections            []projection
+	materializeProjections bool
+	// rollupInterval, if not 0, is the time bucket of the rollups of each
+	// metrics table, kept up to date by a materialized view
+	rollupInterval time.Duration
+	// fieldIndex, if set, is the data-skipping index of tags_id and of the
+	// first fieldIndexCount metrics columns, and additionalTagsIndex that of
+	// additional_tags
+	fieldIndex          *skipIndex
+	fieldIndexCount     int
+	additionalTagsIndex *skipIndex
+	// indexGranularity and indexGranularityBytes are the maximum number of
+	// rows and, if not negative, bytes between the marks of the primary index
+	// of each table
+	indexGranularity      int
+	indexGranularityBytes int
+	// codecs are the compression codecs of the columns of metrics tables
+	codecs columnCodecs
+
+	// replicated, if set, creates tables with the Replicated variant of their
+	// engine, registered in ZooKeeper under zooPathTemplate as replicaName
+	replicated      bool
+	zooPathTemplate string
+	replicaName     string
+
+	// cluster, if set, is the cluster the tables are created on, along with
+	// Distributed tables named after them with distTableSuffix, sharding rows
+	// by shardingKey. insertTarget tells which of them rows are inserted into.
+	cluster         string
+	distTableSuffix string
+	shardingKey     string
+	insertTarget    string
 
+	// schemaFile, if set, describes the tables of the input; a header at the
+	// start of the input (see dataHeader) is then only checked against it
+	schemaFile string
+	dataHeader bool
+
+	// wantChecksum, if set, is the checksum the input is verified against
+	wantChecksum *serialize.Checksum
+
+	// createSchemaOnly, if set, only creates the database and its tables,
+	// without loading anything. ddlFile, if set, is the file the statements
+	// creating them are written to, the only thing done with createSchemaOnly.
+	createSchemaOnly bool
+	ddlFile          string
+
+	// debug is the level of the lines printed by debugLog
 	debug int
+
+	// singleTable, if set, creates a single metrics table for the rows of all
+	// the tables of the input
+	singleTable bool
+
+	// denormalizeTags, if set, stores all the tags of each row in metrics
+	// tables, without a tags table
+	denormalizeTags bool
+
+	// additionalTagsMap, if set, stores additional_tags as a Map(String,
+	// String) instead of a JSON string
+	additionalTagsMap bool
+
+	// nullableFields, if set, creates metrics columns as Nullable, missing
+	// values being inserted as NULL. Otherwise, emptyFields tells whether
+	// they are zero-filled or their rows skipped.
+	nullableFields bool
+	emptyFields    string
+
+	// timePrecision is the number of decimal places of the seconds of the
+	// DateTime64 time of metrics rows, or 0 to keep the nanoseconds apart of
+	// a DateTime, for servers without DateTime64
+	timePrecision int
+
+	// timestampUnit is the unit of the timestamps in the input, or 0 to
+	// detect it from each timestamp with -timestamp-precision auto
+	timestampUnit time.Duration
 )
 
 // String values of tags and fields to insert - string representation
 type insertData struct {
 	tags   string // hostname=host_0,region=eu-west-1,datacenter=eu-west-1b,rack=67,os=Ubuntu16.10,arch=x86,team=NYC,service=7,service_version=0,service_environment=production
 	fields string // 1451606400000000000,58,2,24,61,22,63,6,44,80,38
+	// pooled tells that the row is of insertDataPool, to be put back into it
+	// once processed
+	pooled bool
 }
 
 // Global vars
 var (
-	loader    *load.BenchmarkRunner
-	tableCols map[string][]string
+	loader        *load.BenchmarkRunner
+	tableCols     map[string][]string
+	tableColTypes map[string][]string
+	// dataHeaderLines is the number of lines of the data header, its blank
+	// line included, if read
+	dataHeaderLines int
 )
 
 // allows for testing
@@ -50,29 +245,417 @@ func init() {
 	loader = load.GetBenchmarkRunner()
 
 	flag.StringVar(&host, "host", "localhost", "Hostname of ClickHouse instance")
-	flag.StringVar(&port, "port", "9000", "Port of ClickHouse instance")
+	flag.StringVar(&port, "port", defaultPort,
+		"Port of ClickHouse instance, "+defaultSecurePort+" by default with -secure, and "+defaultHTTPPort+" or "+defaultSecureHTTPPort+" with -protocol http")
+	var hostsSpec string
+	flag.StringVar(&hostsSpec, "hosts", "",
+		"Comma-separated hosts, with optional :port, workers insert into instead of -host, as assigned by -host-policy. The schema is created through the first")
+	flag.StringVar(&hostPolicy, "host-policy", hostPolicyRoundRobin,
+		"How workers are assigned -hosts (choices: round-robin, alt-hosts, random). round-robin assigns worker n host n modulo their number, alt-hosts connects all to the first, failing over to the others")
 	flag.StringVar(&user, "user", "default", "User to connect to ClickHouse as")
-	flag.StringVar(&password, "password", "", "Password to connect to ClickHouse")
+	flag.StringVar(&password, "password", "",
+		"Password to connect to ClickHouse. Better given by CLICKHOUSE_PASSWORD or -connection-file, which do not show it to other users")
+	flag.StringVar(&connectionFile, "connection-file", "",
+		"File of key=value lines giving the host, port, user and password of the connection, for those not given by their flag or CLICKHOUSE_HOST, CLICKHOUSE_PORT, CLICKHOUSE_USER or CLICKHOUSE_PASSWORD")
+	flag.StringVar(&protocol, "protocol", protocolNative,
+		"Protocol to talk to ClickHouse with (choices: native, http). http posts each batch as TabSeparated")
+	flag.StringVar(&compress, "compress", compressNone,
+		"Method to compress the blocks sent and received over the native protocol with (choices: none, lz4, zstd). zstd needs -insert-api batch, the schema being created with lz4")
+	flag.DurationVar(&readTimeout, "read-timeout", 0,
+		"Timeout of reading from ClickHouse, e.g., its response to a large batch. 0 for the default of the driver")
+	flag.DurationVar(&writeTimeout, "write-timeout", 0,
+		"Timeout of writing to ClickHouse with -insert-api prepare and when creating the schema, e.g., a large batch. 0 for the default of the driver")
+	var settingsSpec string
+	flag.StringVar(&settingsSpec, "ch-settings", "",
+		"Comma-separated ClickHouse settings, as name=value, sent along with each query, e.g., max_insert_block_size=1048576,send_timeout=600")
+	flag.BoolVar(&asyncInsert, "async-insert", false,
+		"Whether to insert with async_insert=1, the server buffering the rows of many small inserts, each batch being one, so -batch-size sets their size. Needs ClickHouse 21.11 or later")
+	flag.BoolVar(&waitForAsyncInsert, "wait-for-async-insert", true,
+		"Whether each insert with -async-insert waits for its rows to be written, wait_for_async_insert=1, rather than only buffered")
+	flag.StringVar(&insertAPI, "insert-api", insertAPIBatch,
+		"API rows are inserted with over the native protocol (choices: batch, prepare). batch appends whole columns to a clickhouse-go v2 batch, prepare executes a prepared INSERT per row in a transaction")
+	flag.IntVar(&insertQuorum, "insert-quorum", 0,
+		"Number of replicas each insert into replicated tables must be written to, insert_quorum. 0 for none")
+	flag.StringVar(&dedupTokenPrefix, "dedup-token-prefix", "",
+		"Prefix of the insert_deduplication_token of each insert, followed by the worker, batch and insert numbers, for a rerun on the same input not to duplicate rows. Needs -hash-workers, and -insert-api batch or -protocol http")
+	flag.BoolVar(&secure, "secure", false, "Whether to connect to ClickHouse with TLS")
+	flag.BoolVar(&skipVerify, "skip-verify", false, "Whether to skip the verification of the certificate of the server with -secure")
+	flag.StringVar(&caCert, "ca-cert", "", "PEM file of the CA certificates to verify the certificate of the server against with -secure, instead of those of the system")
 
-	flag.BoolVar(&logBatches, "log-batches", false, "Whether to time individual batches.")
+	flag.IntVar(&maxRetries, "max-retries", 0,
+		"Number of times an insert failing on a retryable error, e.g., too many parts or a lost connection, is retried before the load fails. 0 for none")
+	flag.DurationVar(&retryBackoff, "retry-backoff", time.Second,
+		"Time to wait for before the first retry of a failed insert, doubled on each retry, up to half of it being taken off at random")
+	flag.IntVar(&connectionsPerWorker, "connections-per-worker", 1,
+		"Number of connections of each worker, over which the tables of each of its batches are inserted concurrently. 1 inserts them in turn")
+	flag.BoolVar(&batchPerTable, "batch-per-table", false,
+		"Whether each worker holds back the rows of each table until it has -batch-size of them to insert at once, rather than inserting the rows of each table of each batch")
+
+	flag.BoolVar(&logBatches, "log-batches", false,
+		"Whether to print the worker, table, rows, metrics, rates and latency of each insert of the tables of each batch")
+	flag.StringVar(&batchLogFile, "batch-log-file", "",
+		"File to write the worker, table, rows, metrics, rates and latency of each insert of the tables of each batch to as CSV. Empty for none")
 
 	// TODO - This flag could potentially be done as a string/enum with other options besides no-hash, round-robin, etc
 	flag.BoolVar(&hashWorkers, "hash-workers", false, "Whether to consistently hash insert data to the same workers (i.e., the data for a particular host always goes to the same worker)")
+	flag.StringVar(&hashFunction, "hash-function", hashFNV,
+		"Function hashing hostnames to workers with -hash-workers: fnv (FNV-1a modulo the number of workers) or jump (jump consistent hash, moving the fewest hostnames when the number of workers changes)")
+
+	flag.BoolVar(&dynamicTags, "dynamic-tags", false,
+		"Whether the tag values of a host change over time (tsbs_generate_data -deploy-interval or -team-reassign-rate), so each distinct set of tag values gets its own tags row")
+
+	flag.StringVar(&dbEngine, "db-engine", "",
+		"Engine of the database, e.g., Atomic or Ordinary, with optional parameters. An existing database is dropped to be created with it. Empty for the server default")
+
+	flag.BoolVar(&legacyDDL, "legacy-ddl", false,
+		"Whether to create tables with the deprecated MergeTree(date, (keys), granularity) syntax, for servers older than 1.1.54310")
+
+	var engine, tagsEngineSpec string
+	flag.StringVar(&engine, "engine", engineMergeTree,
+		"Engine of the metrics tables: MergeTree, ReplacingMergeTree or SummingMergeTree, with optional parameters, e.g., ReplacingMergeTree(created_at)")
+	flag.StringVar(&tagsEngineSpec, "tags-engine", engineMergeTree,
+		"Engine of the tags table, as for -engine")
+
+	flag.IntVar(&indexGranularity, "index-granularity", defaultIndexGranularity,
+		"Maximum number of rows between the marks of the primary index of each table")
+	flag.IntVar(&indexGranularityBytes, "index-granularity-bytes", -1,
+		"Maximum number of bytes between the marks of the primary index of each table. 0 disables adaptive granularity, -1 keeps the server default")
+
+	var fieldIndexSpec, additionalTagsIndexSpec string
+	flag.StringVar(&fieldIndexSpec, "field-index", "",
+		"Data-skipping index of tags_id and of the first -field-index-count metrics columns, as type:granularity, e.g., minmax:4. Empty for none")
+	flag.IntVar(&fieldIndexCount, "field-index-count", 0, "Number of metrics columns with a -field-index (-1 for all)")
+	flag.StringVar(&additionalTagsIndexSpec, "additional-tags-index", "",
+		"Data-skipping index of additional_tags, as type:granularity, e.g., tokenbf_v1(512, 3, 0):4 or bloom_filter:4. Empty for none")
+
+	var codecSpec string
+	flag.StringVar(&codecSpec, "codec", "",
+		"Compression codecs of the time and Float64 metrics columns, each column kind followed by its chain of codecs, e.g., time:DoubleDelta,metrics:Gorilla,ZSTD(3). Empty for time:DoubleDelta,ZSTD,metrics:Gorilla,ZSTD")
+
+	flag.DurationVar(&chunkTime, "chunk-time", monthChunkTime,
+		"Time span of the partitions of metrics tables: under 48h by day (created_date), under 720h by its whole number of days (toStartOfInterval(created_date, INTERVAL n DAY)), else by month (toYYYYMM(created_date)). Values under 12h make too many partitions and are capped to a day")
 
-	flag.IntVar(&debug, "debug", 0, "Debug printing (choices: 0, 1, 2). (default 0)")
+	var orderBySpec string
+	flag.StringVar(&orderBySpec, "order-by", "tags_id,created_at",
+		"Comma-separated columns metrics tables are sorted by, e.g., created_at,tags_id to put time first")
+
+	flag.DurationVar(&ttl, "ttl", 0,
+		"Age of the metrics rows, after their created_at, that -ttl-action is applied to, e.g., 720h. 0 for no TTL")
+	flag.StringVar(&ttlAction, "ttl-action", ttlActionDelete,
+		"TTL action applied to the metrics rows older than -ttl: DELETE, TO VOLUME 'name' or TO DISK 'name'")
+	var projectionsSpec string
+	flag.StringVar(&projectionsSpec, "projections", "",
+		"Projections of metrics tables, as name:query separated by semicolons, e.g., 'by_time:ORDER BY (created_at, tags_id)'. Queries without SELECT select all columns. Needs ClickHouse 21.6 or later")
+	flag.BoolVar(&materializeProjections, "materialize-projections", false,
+		"Whether to add -projections to existing metrics tables and materialize them in their existing parts, e.g., when appending with -do-create-db=false")
+	flag.DurationVar(&rollupInterval, "create-rollups", 0,
+		"Time bucket of the rollups, with avg, min and max states per tags_id, of each metrics table, e.g., 1h for cpu_rollup_1h, kept up to date by a materialized view. 0 for none")
+
+	flag.BoolVar(&replicated, "replicated", false,
+		"Whether to create tables with the Replicated variant of their engine, e.g., ReplicatedMergeTree. The database is then dropped beforehand if it exists")
+	flag.StringVar(&zooPathTemplate, "zoo-path-template", "/clickhouse/tables/{shard}/{database}/{table}",
+		"ZooKeeper path of replicated tables. {database} and {table} are replaced by the loader, other macros such as {shard} by the server")
+	flag.StringVar(&replicaName, "replica-name", "{replica}",
+		"Replica name of replicated tables, usually a macro replaced by the server")
+
+	flag.StringVar(&cluster, "cluster", "",
+		"Cluster to create the tables on, ON CLUSTER, along with Distributed tables over them. Empty to only create local tables")
+	flag.StringVar(&distTableSuffix, "dist-table-suffix", "_dist",
+		"Suffix of the names of the Distributed tables, added to those of the local tables")
+	flag.StringVar(&shardingKey, "sharding-key", "tags_id",
+		"Sharding key of the Distributed metrics tables, e.g., rand()")
+	flag.StringVar(&insertTarget, "insert-target", insertTargetDistributed,
+		"Tables to insert rows into with -cluster (choices: distributed, local). local inserts into the tables of the server connected to")
+
+	flag.StringVar(&schemaFile, "schema-file", "",
+		"JSON schema written by tsbs_generate_data -schema-file to create the tables from, instead of the header of the input")
+	flag.BoolVar(&dataHeader, "data-header", true,
+		"Whether the input starts with a header describing its tables. Set to false for headerless input described by -schema-file")
+
+	var checksumFile string
+	flag.StringVar(&checksumFile, "verify-checksum", "",
+		"File with the summary printed by tsbs_generate_data -checksum to verify the input against. Use with -do-load=false to only verify")
+
+	flag.BoolVar(&createSchemaOnly, "create-schema-only", false,
+		"Whether to only create the database and its tables, described by the header of the input or -schema-file, and exit without loading")
+	flag.StringVar(&ddlFile, "ddl-file", "",
+		"File to write the statements creating the database and its tables to. With -create-schema-only, they are only written, without connecting to ClickHouse")
+
+	flag.IntVar(&debug, "debug", 0, "Debug printing to stderr (choices: 0, 1, 2). 1 prints the SQL creating the schema, 2 also the inserts and the first row of batches, up to once per second per table. (default 0)")
+
+	var timestampPrecision string
+	flag.StringVar(&timestampPrecision, "timestamp-precision", serialize.PrecisionNanoseconds,
+		"Unit of the input timestamps (s, ms, us, ns), as given to tsbs_generate_data, or 'auto' to detect it from the size of each timestamp, which reads ns timestamps before 1973 as of a coarser unit")
+
+	flag.BoolVar(&singleTable, "single-table", false,
+		"Whether to load all measurements into a single wide 'metrics' table, with their columns prefixed by their name and a 'measurement' column, instead of a table each")
+
+	flag.BoolVar(&denormalizeTags, "denormalize-tags", false,
+		"Whether to store all the tags of each row in LowCardinality(String) columns of metrics tables, instead of a tags_id referring to a tags table, which is not created")
+
+	flag.BoolVar(&additionalTagsMap, "additional-tags-map", false,
+		"Whether to store additional_tags as a Map(String, String), which needs ClickHouse 21.8 or later, instead of a JSON string")
+
+	flag.BoolVar(&nullableFields, "nullable-fields", false,
+		"Whether to create metrics columns as Nullable, empty values in the input being inserted as NULL")
+	flag.StringVar(&emptyFields, "empty-fields", emptyFieldsZero,
+		"What to do with rows with empty values without -nullable-fields (choices: zero, skip-row)")
+
+	flag.IntVar(&timePrecision, "time-precision", 9,
+		"Decimal places of the seconds of the DateTime64 time column of metrics tables (1-9). 0 stores a DateTime along with a nanoseconds column, for servers without DateTime64")
 
 	flag.Parse()
+	debugLog.level = debug
+
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	// The connection flags that are not given are read from the environment,
+	// else from the connection file
+	var connectionValues map[string]string
+	if len(connectionFile) > 0 {
+		var err error
+		if connectionValues, err = readConnectionFile(connectionFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for name, value := range resolveConnection(given, os.LookupEnv, connectionValues) {
+		if err := flag.Set(name, value); err != nil {
+			log.Fatalf("invalid %s of the connection: %v", name, err)
+		}
+		given[name] = true
+	}
+	portGiven, hostGiven, waitGiven := given["port"], given["host"], given["wait-for-async-insert"]
+	if protocol != protocolNative && protocol != protocolHTTP {
+		log.Fatalf("invalid -protocol '%s': must be %s or %s", protocol, protocolNative, protocolHTTP)
+	}
+	if insertAPI != insertAPIBatch && insertAPI != insertAPIPrepare {
+		log.Fatalf("invalid -insert-api '%s': must be %s or %s", insertAPI, insertAPIBatch, insertAPIPrepare)
+	}
+	if compress != compressNone && compress != compressLZ4 && compress != compressZSTD {
+		log.Fatalf("invalid -compress '%s': must be %s, %s or %s", compress, compressNone, compressLZ4, compressZSTD)
+	}
+	if compress != compressNone && protocol == protocolHTTP {
+		log.Fatal("-compress needs -protocol native")
+	}
+	if compress == compressZSTD && insertAPI == insertAPIPrepare {
+		log.Fatal("-compress zstd needs -insert-api batch, the driver of prepare only compressing with lz4")
+	}
+	port = serverPort(port, portGiven, secure, protocol)
+	if err := checkAddress(host, port); err != nil {
+		log.Fatal(err)
+	}
+	if hostPolicy != hostPolicyRoundRobin && hostPolicy != hostPolicyAltHosts && hostPolicy != hostPolicyRandom {
+		log.Fatalf("invalid -host-policy '%s': must be %s, %s or %s", hostPolicy, hostPolicyRoundRobin, hostPolicyAltHosts, hostPolicyRandom)
+	}
+	if len(hostsSpec) > 0 {
+		if hostGiven {
+			log.Fatal("-hosts cannot be used with -host")
+		}
+		var err error
+		if hosts, err = parseHosts(hostsSpec, port); err != nil {
+			log.Fatal(err)
+		}
+		if hostPolicy == hostPolicyAltHosts && protocol == protocolHTTP {
+			log.Fatal("-host-policy alt-hosts needs -protocol native, the HTTP interface does not fail over")
+		}
+	}
+	if readTimeout < 0 || writeTimeout < 0 {
+		log.Fatal("-read-timeout and -write-timeout must not be negative")
+	}
+	var err error
+	if chSettings, err = parseSettings(settingsSpec); err != nil {
+		log.Fatal(err)
+	}
+	if waitGiven && !asyncInsert {
+		log.Fatal("-wait-for-async-insert needs -async-insert")
+	}
+	if insertQuorum < 0 {
+		log.Fatalf("invalid -insert-quorum %d: must not be negative", insertQuorum)
+	}
+	if maxRetries < 0 || retryBackoff < 0 {
+		log.Fatal("-max-retries and -retry-backoff must not be negative")
+	}
+	if connectionsPerWorker < 1 {
+		log.Fatalf("invalid -connections-per-worker %d: must be positive", connectionsPerWorker)
+	}
+	switch hashFunction {
+	case hashFNV:
+	case hashJump:
+		if !hashWorkers {
+			log.Fatal("-hash-function jump needs -hash-workers")
+		}
+	default:
+		log.Fatalf("invalid -hash-function %s: must be %s or %s", hashFunction, hashFNV, hashJump)
+	}
+	if len(dedupTokenPrefix) > 0 {
+		// Workers only get the same batches on every run with -hash-workers
+		if !hashWorkers {
+			log.Fatal("-dedup-token-prefix needs -hash-workers")
+		}
+		if protocol == protocolNative && insertAPI == insertAPIPrepare {
+			log.Fatal("-dedup-token-prefix cannot be used with -insert-api prepare, whose driver cannot set it per insert")
+		}
+		// Concurrent inserts insert new tags in any order
+		if connectionsPerWorker > 1 {
+			log.Fatal("-dedup-token-prefix cannot be used with -connections-per-worker above 1")
+		}
+		numWorkers = int(flag.Lookup("workers").Value.(flag.Getter).Get().(uint))
+	}
+	if postLoadVerify = flag.Lookup("post-load-verify").Value.(flag.Getter).Get().(bool); postLoadVerify && asyncInsert && !waitForAsyncInsert {
+		log.Fatal("-post-load-verify needs -wait-for-async-insert, the rows being counted before they are written otherwise")
+	}
+	if batchPerTable {
+		tableBatchSize = int(flag.Lookup("batch-size").Value.(flag.Getter).Get().(uint))
+	}
+	if len(batchLogFile) > 0 {
+		f, err := os.Create(batchLogFile)
+		if err != nil {
+			log.Fatalf("cannot create -batch-log-file: %v", err)
+		}
+		if batchLogOut, err = newBatchLogWriter(f); err != nil {
+			log.Fatalf("cannot write -batch-log-file: %v", err)
+		}
+	}
+	if !secure && (skipVerify || len(caCert) > 0) {
+		log.Fatal("-skip-verify and -ca-cert need -secure")
+	}
+	if len(caCert) > 0 {
+		if err := registerCACert(caCert); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if metricsEngine, err = parseMergeTreeEngine(engine); err != nil {
+		log.Fatal(err)
+	}
+	if tagsEngine, err = parseMergeTreeEngine(tagsEngineSpec); err != nil {
+		log.Fatal(err)
+	}
+	if chunkTime < minChunkTime {
+		log.Printf("warning: -chunk-time %v would make too many partitions, partitioning by day instead", chunkTime)
+	}
+	if legacyDDL && chunkTime < monthChunkTime {
+		log.Fatalf("-chunk-time %v cannot be used with -legacy-ddl, whose tables are partitioned by month", chunkTime)
+	}
+	for _, column := range strings.Split(orderBySpec, ",") {
+		if column = strings.TrimSpace(column); len(column) == 0 {
+			log.Fatalf("invalid -order-by '%s': column names must not be empty", orderBySpec)
+		}
+		orderBy = append(orderBy, column)
+	}
+	if fieldIndex, err = parseSkipIndex(fieldIndexSpec); err != nil {
+		log.Fatal(err)
+	}
+	if fieldIndexCount < -1 {
+		log.Fatalf("invalid -field-index-count %d: must be -1 or more", fieldIndexCount)
+	}
+	if additionalTagsIndex, err = parseSkipIndex(additionalTagsIndexSpec); err != nil {
+		log.Fatal(err)
+	}
+	if indexGranularity <= 0 {
+		log.Fatalf("invalid -index-granularity %d: must be positive", indexGranularity)
+	}
+	if legacyDDL && indexGranularityBytes >= 0 {
+		log.Fatal("-index-granularity-bytes cannot be used with -legacy-ddl")
+	}
+	if err = checkTTL(ttl, ttlAction); err != nil {
+		log.Fatal(err)
+	}
+	if projections, err = parseProjections(projectionsSpec); err != nil {
+		log.Fatal(err)
+	}
+	if legacyDDL && len(projections) > 0 {
+		log.Fatal("-projections cannot be used with -legacy-ddl")
+	}
+	if materializeProjections && len(projections) == 0 {
+		log.Fatal("-materialize-projections needs -projections")
+	}
+	if rollupInterval < 0 || rollupInterval%time.Second != 0 {
+		log.Fatalf("invalid -create-rollups %v: must be a positive whole number of seconds", rollupInterval)
+	}
+	codecs = defaultCodecs
+	if len(codecSpec) > 0 {
+		if codecs, err = parseColumnCodecs(codecSpec); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if len(dbEngine) > 0 && !dbEngineRegexp.MatchString(dbEngine) {
+		log.Fatalf("invalid -db-engine '%s': must be an engine name with optional parameters in parentheses", dbEngine)
+	}
+	if replicated && !strings.Contains(zooPathTemplate, "{table}") {
+		log.Fatalf("invalid -zoo-path-template '%s': must contain {table} for each table to have its own path", zooPathTemplate)
+	}
+
+	if insertTarget != insertTargetDistributed && insertTarget != insertTargetLocal {
+		log.Fatalf("invalid -insert-target '%s': must be %s or %s", insertTarget, insertTargetDistributed, insertTargetLocal)
+	}
+	if len(cluster) > 0 && len(distTableSuffix) == 0 {
+		log.Fatal("-dist-table-suffix must not be empty, the Distributed tables would have the names of the local ones")
+	}
+
+	if emptyFields != emptyFieldsZero && emptyFields != emptyFieldsSkipRow {
+		log.Fatalf("invalid -empty-fields '%s': must be %s or %s", emptyFields, emptyFieldsZero, emptyFieldsSkipRow)
+	}
+	if timePrecision < 0 || timePrecision > 9 {
+		log.Fatalf("invalid -time-precision %d: must be from 0 to 9", timePrecision)
+	}
+
+	if timestampPrecision != timestampPrecisionAuto {
+		timestampUnit, err = serialize.ParseTimestampPrecision(timestampPrecision)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if !dataHeader && len(schemaFile) == 0 {
+		log.Fatal("-data-header=false needs the tables to be described by -schema-file")
+	}
+	if len(checksumFile) > 0 {
+		// The checksum is of the points of a decoder, each stream having one
+		if flag.Lookup("scan-streams").Value.(flag.Getter).Get().(int) > 1 {
+			log.Fatal("-verify-checksum cannot be used with -scan-streams above 1")
+		}
+		file, err := os.Open(checksumFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		wantChecksum, err = serialize.ReadChecksum(file)
+		file.Close()
+		if err != nil {
+			log.Fatalf("%s: %v", checksumFile, err)
+		}
+	}
 	tableCols = make(map[string][]string)
+	tableColTypes = make(map[string][]string)
 }
 
 // loader.Benchmark interface implementation
-type benchmark struct{}
+type benchmark struct {
+	// indexer is the indexer of the hostnames of -hash-workers, once created
+	indexer *load.KeyHashIndexer
+}
 
 // loader.Benchmark interface implementation
 func (b *benchmark) GetPointDecoder(br *bufio.Reader) load.PointDecoder {
-	return &decoder{
+	d := &decoder{
 		scanner: bufio.NewScanner(br),
+		line:    dataHeaderLines,
+	}
+	if wantChecksum != nil {
+		d.checksum = serialize.NewChecksum(wantChecksum.Group, wantChecksum.Groups)
+		d.wantChecksum = wantChecksum
+	}
+	if len(schemaFile) > 0 {
+		// Tables are created from the schema before any data is decoded
+		d.tableCols = tableCols
+	} else if dataHeaderLines > 0 {
+		// The tables of the header are those created before any data is
+		// decoded
+		d.headerTables = make(map[string]bool)
+		for table := range tableCols {
+			d.headerTables[table] = table != tagsPrefix
+		}
 	}
+	return d
 }
 
 // loader.Benchmark interface implementation
@@ -83,9 +666,8 @@ func (b *benchmark) GetBatchFactory() load.BatchFactory {
 // loader.Benchmark interface implementation
 func (b *benchmark) GetPointIndexer(maxPartitions uint) load.PointIndexer {
 	if hashWorkers {
-		return &hostnameIndexer{
-			partitions: maxPartitions,
-		}
+		b.indexer = newHostnameIndexer(maxPartitions, hashFunction == hashJump)
+		return b.indexer
 	}
 	return &load.ConstantIndexer{}
 }
@@ -100,10 +682,65 @@ func (b *benchmark) GetDBCreator() load.DBCreator {
 	return &dbCreator{}
 }
 
+// load.HeaderReader interface implementation, for the header of each of the
+// files of -file to be checked to be that of the first, none with
+// -data-header=false
+func (b *benchmark) ReadHeader(br *bufio.Reader) (string, error) {
+	if !dataHeader {
+		return "", nil
+	}
+	tags, cols, err := parseDataHeader(br)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(append([]string{tags}, cols...), "\n"), nil
+}
+
 func main() {
+	if createSchemaOnly {
+		createSchema()
+		return
+	}
+	b := &benchmark{}
 	if hashWorkers {
-		loader.RunBenchmark(&benchmark{}, load.WorkerPerQueue)
+		loader.RunBenchmark(b, load.WorkerPerQueue)
 	} else {
-		loader.RunBenchmark(&benchmark{}, load.SingleQueue)
+		loader.RunBenchmark(b, load.SingleQueue)
+	}
+	// The points are all indexed once the benchmark has run
+	if b.indexer != nil {
+		debugLog.printf(1, "points per worker hashed with %s: %s", hashFunction, balance(b.indexer.Counts()))
+	}
+	if n := atomic.LoadUint64(&emptyFieldCount); n > 0 {
+		action := "inserted as NULL"
+		if !nullableFields {
+			action = map[string]string{emptyFieldsZero: "zero-filled", emptyFieldsSkipRow: "skipped with their rows"}[emptyFields]
+		}
+		log.Printf("%d empty values %s, not counted as metrics", n, action)
+	}
+	if protocol == protocolNative {
+		fmt.Printf("Native protocol compression: %s\n", compress)
+	}
+	// The workers have written their records
+	if batchLogOut != nil {
+		if err := batchLogOut.close(); err != nil {
+			log.Fatalf("cannot write -batch-log-file: %v", err)
+		}
+	}
+}
+
+// createSchema creates the database and its tables as the loader would before
+// loading, or only writes the statements creating them to ddlFile if set
+func createSchema() {
+	creator := &dbCreator{}
+	creator.Init()
+	dbName := loader.DatabaseName()
+	if len(ddlFile) == 0 && creator.DBExists(dbName) {
+		if err := creator.RemoveOldDB(dbName); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := creator.CreateDB(dbName); err != nil {
+		log.Fatal(err)
 	}
 }
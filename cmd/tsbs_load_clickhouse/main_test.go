@@ -1,22 +1,321 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestGetConnectString(t *testing.T) {
 	wantHost := "localhost"
+	wantPort := "9123"
 	wantUser := "default"
 	wantPassword := ""
 	wantDB := "benchmark"
-	want := fmt.Sprintf("tcp://%s:9000?username=%s&password=%s&database=%s", wantHost, wantUser, wantPassword, wantDB)
 
 	host = wantHost
+	port = wantPort
 	user = wantUser
 	password = wantPassword
-	connStr := getConnectString(true)
-	if connStr != want {
-		t.Errorf("incorrect connect string: got %s want %s", connStr, want)
+	defer func() { port = "9000" }()
+
+	cases := []struct {
+		db   bool
+		want string
+	}{
+		{
+			db:   true,
+			want: fmt.Sprintf("tcp://%s:%s?username=%s&password=%s&database=%s", wantHost, wantPort, wantUser, wantPassword, wantDB),
+		},
+		{
+			db:   false,
+			want: fmt.Sprintf("tcp://%s:%s?username=%s&password=%s", wantHost, wantPort, wantUser, wantPassword),
+		},
+	}
+	for _, c := range cases {
+		if connStr := getConnectString(c.db); connStr != c.want {
+			t.Errorf("incorrect connect string with db %v: got %s want %s", c.db, connStr, c.want)
+		}
+	}
+}
+
+func TestCheckAddress(t *testing.T) {
+	cases := []struct {
+		host    string
+		port    string
+		wantErr bool
+	}{
+		{host: "localhost", port: "9000"},
+		{host: "10.0.0.1", port: "19000"},
+		{host: "[::1]", port: "9000"},
+		{host: "tcp://localhost", port: "9000", wantErr: true},
+		{host: "localhost/db", port: "9000", wantErr: true},
+		{host: "localhost:9000", port: "9000", wantErr: true},
+		{host: "::1", port: "9000", wantErr: true},
+		{host: "localhost", port: "9000/db", wantErr: true},
+		{host: "localhost", port: "http", wantErr: true},
+		{host: "localhost", port: "0", wantErr: true},
+		{host: "localhost", port: "65536", wantErr: true},
+	}
+	for _, c := range cases {
+		err := checkAddress(c.host, c.port)
+		if c.wantErr && err == nil {
+			t.Errorf("unexpected lack of error for %s and port %s", c.host, c.port)
+		} else if !c.wantErr && err != nil {
+			t.Errorf("unexpected error for %s and port %s: %v", c.host, c.port, err)
+		}
+	}
+}
+
+func TestGetConnectStringTLS(t *testing.T) {
+	cases := []struct {
+		secure     bool
+		skipVerify bool
+		caCert     string
+		want       string
+	}{
+		{want: "tcp://localhost:9440?username=default&password="},
+		{secure: true, want: "tcp://localhost:9440?username=default&password=&secure=true"},
+		{secure: true, skipVerify: true, want: "tcp://localhost:9440?username=default&password=&secure=true&skip_verify=true"},
+		{secure: true, caCert: "ca.pem", want: "tcp://localhost:9440?username=default&password=&secure=true&tls_config=" + tlsConfigName},
+		{
+			secure:     true,
+			skipVerify: true,
+			caCert:     "ca.pem",
+			want:       "tcp://localhost:9440?username=default&password=&secure=true&skip_verify=true&tls_config=" + tlsConfigName,
+		},
+	}
+
+	oldHost, oldPort, oldUser, oldPassword := host, port, user, password
+	oldSecure, oldSkipVerify, oldCACert := secure, skipVerify, caCert
+	defer func() {
+		host, port, user, password = oldHost, oldPort, oldUser, oldPassword
+		secure, skipVerify, caCert = oldSecure, oldSkipVerify, oldCACert
+	}()
+	host, port, user, password = "localhost", defaultSecurePort, "default", ""
+	for _, c := range cases {
+		secure, skipVerify, caCert = c.secure, c.skipVerify, c.caCert
+		if got := getConnectString(false); got != c.want {
+			t.Errorf("incorrect connect string with secure %v, skip verify %v and CA %q: got %s want %s",
+				c.secure, c.skipVerify, c.caCert, got, c.want)
+		}
+		if got, want := getConnectString(true), strings.Replace(c.want, "password=", "password=&database=benchmark", 1); got != want {
+			t.Errorf("incorrect connect string with db: got %s want %s", got, want)
+		}
+	}
+}
+
+func TestParseSettings(t *testing.T) {
+	cases := []struct {
+		desc      string
+		spec      string
+		want      []setting
+		shouldErr bool
+	}{
+		{desc: "none", spec: ""},
+		{
+			desc: "several",
+			spec: "max_insert_block_size=1048576, send_timeout=600",
+			want: []setting{{name: "max_insert_block_size", value: "1048576"}, {name: "send_timeout", value: "600"}},
+		},
+		{desc: "value with =", spec: "log_comment=a=b", want: []setting{{name: "log_comment", value: "a=b"}}},
+		{desc: "empty value", spec: "log_comment=", want: []setting{{name: "log_comment", value: ""}}},
+		{desc: "empty name", spec: "=1", shouldErr: true},
+		{desc: "empty setting", spec: "send_timeout=600,", shouldErr: true},
+		{desc: "no value", spec: "send_timeout", shouldErr: true},
+		{desc: "invalid name", spec: "send timeout=600", shouldErr: true},
+		{desc: "loader parameter", spec: "database=other", shouldErr: true},
+		{desc: "timeout parameter", spec: "read_timeout=10", shouldErr: true},
+	}
+	for _, c := range cases {
+		got, err := parseSettings(c.spec)
+		if c.shouldErr {
+			if err == nil {
+				t.Errorf("%s: unexpected lack of error", c.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.desc, err)
+		} else if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: incorrect settings: got %v want %v", c.desc, got, c.want)
+		}
+	}
+}
+
+func TestGetConnectStringDriverParams(t *testing.T) {
+	cases := []struct {
+		desc         string
+		compress     string
+		readTimeout  time.Duration
+		writeTimeout time.Duration
+		settings     string
+		want         string
+	}{
+		{desc: "none", want: ""},
+		{desc: "lz4", compress: compressLZ4, want: "&compress=true"},
+		{desc: "zstd", compress: compressZSTD, want: "&compress=true"},
+		{desc: "compress and timeouts", compress: compressLZ4, readTimeout: time.Second, want: "&compress=true&read_timeout=1"},
+		{desc: "timeouts", readTimeout: 10 * time.Second, writeTimeout: 1500 * time.Millisecond, want: "&read_timeout=10&write_timeout=1.5"},
+		{
+			desc:     "settings",
+			settings: "max_insert_block_size=1048576,send_timeout=600",
+			want:     "&max_insert_block_size=1048576&send_timeout=600",
+		},
+		{desc: "escaped settings", settings: "log_comment=a&b c=d/é", want: "&log_comment=a%26b+c%3Dd%2F%C3%A9"},
+		{
+			desc:        "timeouts and settings",
+			readTimeout: time.Minute,
+			settings:    "max_threads=2",
+			want:        "&read_timeout=60&max_threads=2",
+		},
+	}
+
+	oldHost, oldPort, oldUser, oldPassword := host, port, user, password
+	oldCompress, oldReadTimeout, oldWriteTimeout, oldSettings := compress, readTimeout, writeTimeout, chSettings
+	defer func() {
+		host, port, user, password = oldHost, oldPort, oldUser, oldPassword
+		compress, readTimeout, writeTimeout, chSettings = oldCompress, oldReadTimeout, oldWriteTimeout, oldSettings
+	}()
+	host, port, user, password = "localhost", defaultPort, "default", ""
+	for _, c := range cases {
+		var err error
+		compress, readTimeout, writeTimeout = c.compress, c.readTimeout, c.writeTimeout
+		if len(compress) == 0 {
+			compress = compressNone
+		}
+		if chSettings, err = parseSettings(c.settings); err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.desc, err)
+		}
+		want := "tcp://localhost:9000?username=default&password=" + c.want
+		if got := getConnectString(false); got != want {
+			t.Errorf("%s: incorrect connect string: got %s want %s", c.desc, got, want)
+		}
+		want = "tcp://localhost:9000?username=default&password=&database=benchmark" + c.want
+		if got := getConnectString(true); got != want {
+			t.Errorf("%s: incorrect connect string with db: got %s want %s", c.desc, got, want)
+		}
+	}
+}
+
+func TestInsertSettings(t *testing.T) {
+	cases := []struct {
+		desc         string
+		asyncInsert  bool
+		insertQuorum int
+		want         []setting
+	}{
+		{desc: "none"},
+		{desc: "quorum", insertQuorum: 2, want: []setting{{name: "insert_quorum", value: "2"}}},
+		{
+			desc:         "async insert and quorum",
+			asyncInsert:  true,
+			insertQuorum: 3,
+			want: []setting{
+				{name: "async_insert", value: "1"}, {name: "wait_for_async_insert", value: "1"}, {name: "insert_quorum", value: "3"},
+			},
+		},
+	}
+
+	oldAsyncInsert, oldWaitForAsyncInsert, oldInsertQuorum := asyncInsert, waitForAsyncInsert, insertQuorum
+	defer func() {
+		asyncInsert, waitForAsyncInsert, insertQuorum = oldAsyncInsert, oldWaitForAsyncInsert, oldInsertQuorum
+	}()
+	waitForAsyncInsert = true
+	for _, c := range cases {
+		asyncInsert, insertQuorum = c.asyncInsert, c.insertQuorum
+		if got := insertSettings(); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: incorrect settings: got %v want %v", c.desc, got, c.want)
+		}
+	}
+}
+
+func TestServerPort(t *testing.T) {
+	cases := []struct {
+		port      string
+		portGiven bool
+		secure    bool
+		protocol  string
+		want      string
+	}{
+		{port: defaultPort, protocol: protocolNative, want: defaultPort},
+		{port: defaultPort, secure: true, protocol: protocolNative, want: defaultSecurePort},
+		{port: "9000", portGiven: true, secure: true, protocol: protocolNative, want: "9000"},
+		{port: "19440", portGiven: true, secure: true, protocol: protocolNative, want: "19440"},
+		{port: "19000", portGiven: true, protocol: protocolNative, want: "19000"},
+		{port: defaultPort, protocol: protocolHTTP, want: defaultHTTPPort},
+		{port: defaultPort, secure: true, protocol: protocolHTTP, want: defaultSecureHTTPPort},
+		{port: "18123", portGiven: true, protocol: protocolHTTP, want: "18123"},
+	}
+	for _, c := range cases {
+		if got := serverPort(c.port, c.portGiven, c.secure, c.protocol); got != c.want {
+			t.Errorf("incorrect port for %s given %v with secure %v over %s: got %s want %s",
+				c.port, c.portGiven, c.secure, c.protocol, got, c.want)
+		}
+	}
+}
+
+func TestRegisterCACert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tsbs_ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	notPEM := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(notPEM, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, fileName := range []string{notPEM, filepath.Join(dir, "missing.pem")} {
+		if err := registerCACert(fileName); err == nil {
+			t.Errorf("unexpected lack of error for %s", fileName)
+		}
+	}
+}
+
+func TestReadHeader(t *testing.T) {
+	oldDataHeader := dataHeader
+	defer func() { dataHeader = oldDataHeader }()
+	data := "tags,hostname string,region string\ncpu,usage_user,usage_system\nmem,used\n\n" +
+		"tags,hostname=host_0,region=eu-west-1\ncpu,1451606400000000000,58,2\n"
+
+	cases := []struct {
+		noHeader bool
+		data     string
+		want     string
+		wantErr  bool
+	}{
+		{data: data, want: "tags,hostname string,region string\ncpu,usage_user,usage_system\nmem,used"},
+		{noHeader: true, data: data, want: ""},
+		{data: "cpu,usage_user\n\n", wantErr: true},
+	}
+	for _, c := range cases {
+		dataHeader = !c.noHeader
+		br := bufio.NewReader(strings.NewReader(c.data))
+		got, err := (&benchmark{}).ReadHeader(br)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("%q: unexpected lack of error", c.data)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", c.data, err)
+		}
+		if got != c.want {
+			t.Errorf("%q: incorrect header: got %q want %q", c.data, got, c.want)
+		}
+		// The data is left to be decoded after the header
+		if c.noHeader {
+			continue
+		}
+		if line, _ := br.ReadString('\n'); !strings.HasPrefix(line, "tags,hostname=host_0") {
+			t.Errorf("%q: incorrect data after the header: %q", c.data, line)
+		}
 	}
 }
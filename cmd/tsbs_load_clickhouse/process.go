@@ -2,26 +2,43 @@ package main
 
 import (
 	"fmt"
+	"log"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/kshvakov/clickhouse"
+	"github.com/timescale/tsbs/cmd/tsbs_generate_data/serialize"
 	"github.com/timescale/tsbs/load"
 )
 
 type syncCSI struct {
-	// Map hostname to tags.id for this host
-	m     map[string]int64
-	mutex *sync.RWMutex
+	// Map the key of a tags row (see tagsKey) to its tags.id
+	m map[string]int64
+	// pending maps the keys of the tags rows being inserted to their insert,
+	// for the workers needing them too to wait for it rather than insert
+	// them again
+	pending map[string]*tagsInsert
+	mutex   *sync.RWMutex
+}
+
+// tagsInsert is an insert of new tags rows, made without holding the lock of
+// the syncCSI, which is done once its rows are in the map or err says why not
+type tagsInsert struct {
+	done chan struct{}
+	err  error
 }
 
 func newSyncCSI() *syncCSI {
 	return &syncCSI{
-		m:     make(map[string]int64),
-		mutex: &sync.RWMutex{},
+		m:       make(map[string]int64),
+		pending: make(map[string]*tagsInsert),
+		mutex:   &sync.RWMutex{},
 	}
 }
 
@@ -29,26 +46,102 @@ func newSyncCSI() *syncCSI {
 // therefore all workers need to know about the same map from hostname -> tags_id
 var globalSyncCSI = newSyncCSI()
 
-// subsystemTagsToJSON converts equations as
-// a=b
-// c=d
+// tagsKey returns the key identifying a row of common tag values: its hostname
+// or, if the tags of a host change over time, all of its values.
+func tagsKey(tagRow []string) string {
+	if !dynamicTags {
+		return tagRow[0]
+	}
+	if n := len(tableCols["tags"]); len(tagRow) > n {
+		tagRow = tagRow[:n]
+	}
+	return strings.Join(tagRow, ",")
+}
+
+// parseAdditionalTags splits the tags of a row that are not common, as
+// a=b,c=d, into their keys and values. Values may hold '=' and a tag without
+// one has an empty value. Empty tags are skipped.
+func parseAdditionalTags(s string) (keys, values []string) {
+	for _, t := range strings.Split(s, ",") {
+		if len(t) == 0 {
+			continue
+		}
+		kv := strings.SplitN(t, "=", 2)
+		keys = append(keys, kv[0])
+		if len(kv) > 1 {
+			values = append(values, kv[1])
+		} else {
+			values = append(values, "")
+		}
+	}
+	return keys, values
+}
+
+// subsystemTagsToJSON converts keys
+// a, c
+// and values
+// b, d
 // into JSON STRING '{"a": "b", "c": "d"}'
-func subsystemTagsToJSON(tags []string) string {
+func subsystemTagsToJSON(keys, values []string) string {
 	json := "{"
-	for i, t := range tags {
-		args := strings.Split(t, "=")
+	for i, k := range keys {
 		if i > 0 {
 			json += ","
 		}
-		json += fmt.Sprintf("\"%s\": \"%s\"", args[0], args[1])
+		json += fmt.Sprintf("\"%s\": \"%s\"", k, values[i])
 	}
 	json += "}"
 	return json
 }
 
-// insertTags fills tags table with values
-func insertTags(db *sqlx.DB, startId int, rows [][]string, returnResults bool) map[string]int64 {
-	// Map hostname to tags_id
+// additionalTagsValue returns the value of the additional_tags column for the
+// tags of a row that are not common, as a=b,c=d: a map with additionalTagsMap,
+// a JSON string otherwise, empty if there are none
+func additionalTagsValue(s string) interface{} {
+	keys, values := parseAdditionalTags(s)
+	if additionalTagsMap {
+		m := make(map[string]string, len(keys))
+		for i, k := range keys {
+			m[k] = values[i]
+		}
+		return m
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	return subsystemTagsToJSON(keys, values)
+}
+
+// lastTagsID is the id of the last tags row inserted by any worker, or found
+// in the tags table when appending to it. The syncCSI of each worker with
+// -hash-workers being its own, ids are taken from it for them to be unique.
+var lastTagsID uint32
+
+// newTagsIDs returns the ids of n new tags rows, following those of any
+// worker. With -dedup-token-prefix, each run must give the tags rows of a
+// worker the same ids, whichever order the workers insert them in, so worker w
+// numbers its i-th tags row i*numWorkers+w+1 instead, its syncCSI being its
+// own and only changed by its inserts, made in turn.
+func (p *processor) newTagsIDs(n int) []uint32 {
+	ids := make([]uint32, n)
+	if len(dedupTokenPrefix) > 0 {
+		for i := range ids {
+			ids[i] = uint32((len(p.csi.m)+i)*numWorkers + p.workerNum + 1)
+		}
+		return ids
+	}
+	last := atomic.AddUint32(&lastTagsID, uint32(n))
+	for i := range ids {
+		ids[i] = last - uint32(n-1-i)
+	}
+	return ids
+}
+
+// insertTags inserts rows of tags, with new ids, into the tags table, and
+// returns the map of their keys (see tagsKey) to their ids. The caller has
+// made them pending in p.csi, for no other insert to insert them.
+func (p *processor) insertTags(rows [][]string) (map[string]int64, error) {
+	// Map tags key to tags_id
 	ret := make(map[string]int64)
 
 	// reflect tags table structure which is
@@ -58,99 +151,237 @@ func insertTags(db *sqlx.DB, startId int, rows [][]string, returnResults bool) m
 	//   %s
 	// ) engine=MergeTree(created_at, (%s), 8192)
 
+	// Columns. Ex.:
+	// id,hostname,region,datacenter,rack,os,arch,team,service,service_version,service_environment
+	cols := append([]string{"id"}, tableCols["tags"]...)
+
+	values := make([][]interface{}, 0, len(rows))
+	ids := p.newTagsIDs(len(rows))
+	for j, row := range rows {
+		// Place id at the beginning, and all the rest of column values afterwards
+		r := make([]interface{}, len(row)+1) // +1 here for additional 'id' column value
+		// The id column is a UInt32
+		r[0] = ids[j]
+		for i, value := range row {
+			r[i+1] = value
+		}
+		values = append(values, r)
+
+		// Map tags key -> tags_id
+		ret[tagsKey(row)] = int64(ids[j])
+	}
+
+	if err := p.insert(insertTable("tags"), cols, values); err != nil {
+		return nil, err
+	}
+	addInsertedRows("tags", len(values))
+	return ret, nil
+}
+
+// insert inserts rows, the values of cols, into table as a single batch, over
+// HTTP if the processor talks to the server with it. An insert failing on a
+// retryable error is retried up to -max-retries times, with the same
+// deduplication token, if any.
+func (p *processor) insert(table string, cols []string, rows [][]interface{}) error {
+	settings := p.dedupSettings()
+	for attempt := 0; ; attempt++ {
+		err := p.insertOnce(table, cols, rows, settings)
+		if err == nil || attempt >= maxRetries || !retryableError(err) {
+			return err
+		}
+		delay := retryDelay(attempt)
+		log.Printf("worker %d: retrying insert into %s in %v (%d of %d): %v", p.workerNum, table, delay, attempt+1, maxRetries, err)
+		sleep(delay)
+	}
+}
+
+// insertOnce inserts rows, the values of cols, into table with settings of
+// its own, once
+func (p *processor) insertOnce(table string, cols []string, rows [][]interface{}, settings []setting) error {
+	if p.http != nil {
+		return p.http.insert(table, cols, rows, settings)
+	}
+	if p.conn != nil {
+		return insertBatch(p.conn, table, cols, rows, settings)
+	}
+	return insertNative(p.db, table, cols, rows)
+}
+
+// dedupSettings returns the settings of the next insert of the batch: with
+// -dedup-token-prefix, its insert_deduplication_token, made of the worker, the
+// batch and the insert within it, so that it is the same on every run loading
+// the same input with -hash-workers
+func (p *processor) dedupSettings() []setting {
+	if len(dedupTokenPrefix) == 0 {
+		return nil
+	}
+	p.insertNum++
+	token := fmt.Sprintf("%s-%d-%d-%d", dedupTokenPrefix, p.workerNum, p.batchNum, p.insertNum)
+	return []setting{{name: "insert_deduplication_token", value: token}}
+}
+
+// insertNative inserts rows, the values of cols, into table over the native
+// protocol
+func insertNative(db *sqlx.DB, table string, cols []string, rows [][]interface{}) error {
 	// build insert-multiple-rows INSERT statement like:
-	// INSERT INTO tags (
+	// INSERT INTO table (
 	//   ... list of column names ...
-	// ) VALUES
-	// ( ... row 1 values ... ),
-	// ( ... row 2 values ... ),
-	// ...
-	// ( ... row N values ... ),
-
-	// Columns. Ex.:
-	// hostname,region,datacenter,rack,os,arch,team,service,service_version,service_environment
-	cols := tableCols["tags"]
-	// Add id column to prepared statement
+	// ) VALUES (
+	//   ?,?,?
+	// )
 	sql := fmt.Sprintf(`
-		INSERT INTO tags(
-			id,%s
+		INSERT INTO %s (
+			%s
 		) VALUES (
-			?%s
+			%s
 		)
 		`,
+		table,
 		strings.Join(cols, ","),
-		strings.Repeat(",?", len(cols)))
-	if debug > 0 {
-		fmt.Printf(sql)
-	}
+		strings.Repeat(",?", len(cols))[1:]) // We need '?,?,?', but repeat ",?" thus we need to chop off 1-st char
+	debugLog.printEvery(2, "insert "+table, "%s", strings.TrimSpace(sql))
 
-	// In a single transaction insert tags row-by-row
+	// In a single transaction insert row-by-row
 	// ClickHouse driver accumulates all rows inside a transaction into one batch
 	tx, err := db.Begin()
 	if err != nil {
-		panic(err)
+		return err
 	}
 	stmt, err := tx.Prepare(sql)
 	if err != nil {
-		panic(err)
+		tx.Rollback()
+		return err
 	}
-	defer stmt.Close()
-
-	id := startId
-	for _, row := range rows {
-		// id of the new tag
-		id++
-
-		// unfortunately, it is not possible to pass a slice into variadic function of type interface
-		// more details on the item:
-		// https://blog.learngoprogramming.com/golang-variadic-funcs-how-to-patterns-369408f19085
-		// Passing a slice to variadic param with an empty-interface
-		var variadicArgs []interface{} = make([]interface{}, len(row)+1) // +1 here for additional 'id' column value
-		// Place id at the beginning
-		variadicArgs[0] = id
-		// And all the rest of column values afterwards
-		for i, value := range row {
-			variadicArgs[i+1] = value
+	for _, r := range rows {
+		if _, err := stmt.Exec(r...); err != nil {
+			stmt.Close()
+			tx.Rollback()
+			return fmt.Errorf("cannot insert %d rows into %s: %v", len(rows), table, err)
 		}
+	}
+	if err := stmt.Close(); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
 
-		// And now expand []interface{} with the same data as 'row' contains (plus 'id') in Exec(args ...interface{})
-		_, err := stmt.Exec(variadicArgs...)
-		if err != nil {
-			panic(err)
-		}
+// parseTimestamp converts a Unix timestamp in the given unit into a time. If
+// unit is 0, it is detected from the magnitude of the timestamp, which is
+// unambiguous for any time between 1973 and 2286.
+func parseTimestamp(v string, unit time.Duration) (time.Time, error) {
+	ts, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if unit == 0 {
+		unit = detectTimestampUnit(ts)
+	}
+	return time.Unix(0, ts*int64(unit)), nil
+}
 
-		// Fill map hostname -> id
-		if returnResults {
-			// Map hostname -> tags_id
-			ret[row[0]] = int64(id)
-		}
+// detectTimestampUnit guesses the unit of a Unix timestamp from its number of
+// digits
+func detectTimestampUnit(ts int64) time.Duration {
+	if ts < 0 {
+		ts = -ts
+	}
+	switch {
+	case ts < 1e11:
+		return time.Second
+	case ts < 1e14:
+		return time.Millisecond
+	case ts < 1e17:
+		return time.Microsecond
+	default:
+		return time.Nanosecond
 	}
+}
 
-	err = tx.Commit()
-	if err != nil {
-		panic(err)
+// parseMetricValue converts the string representation of a metric into a value
+// matching the ClickHouse type of its column
+func parseMetricValue(v string, colType string) (interface{}, error) {
+	switch colType {
+	case columnTypeInt64:
+		return strconv.ParseInt(v, 10, 64)
+	case columnTypeUInt64:
+		return strconv.ParseUint(v, 10, 64)
+	case columnTypeString:
+		return v, nil
+	case columnTypeBool:
+		b, err := strconv.ParseBool(v)
+		if b {
+			return uint8(1), err
+		}
+		return uint8(0), err
+	default:
+		return strconv.ParseFloat(v, 64)
 	}
+}
 
-	if returnResults {
-		return ret
+// emptyFieldCount is the number of missing values in the rows processed
+var emptyFieldCount uint64
+
+// zeroMetricValue returns the value of type colType that missing values are
+// filled with
+func zeroMetricValue(colType string) interface{} {
+	switch colType {
+	case columnTypeInt64:
+		return int64(0)
+	case columnTypeUInt64:
+		return uint64(0)
+	case columnTypeString:
+		return ""
+	case columnTypeBool:
+		return uint8(0)
+	default:
+		return float64(0)
 	}
+}
 
-	return nil
+// timeColumns returns the columns of metrics tables holding the time of
+// their rows (see timeColumnDefinitions)
+func timeColumns() []string {
+	if timePrecision == 0 {
+		return []string{"created_at", columnTimeNanos}
+	}
+	return []string{"created_at"}
 }
 
-// Process part of incoming data - insert into tables
-func (p *processor) processCSI(tableName string, rows []*insertData) uint64 {
-	tagRows := make([][]string, 0, len(rows))
-	dataRows := make([][]interface{}, 0, len(rows))
-	ret := uint64(0)
-	commonTagsLen := len(tableCols["tags"])
+// timeValues returns the values of the time columns of a metrics row at t
+func timeValues(t time.Time) []interface{} {
+	if timePrecision == 0 {
+		return []interface{}{t.Truncate(time.Second), uint32(t.Nanosecond())}
+	}
+	return []interface{}{t}
+}
 
-	colLen := len(tableCols[tableName]) + 2
+// rowLen returns the number of columns of the rows inserted into tableName
+func rowLen(tableName string) int {
+	n := len(tableCols[tableName]) + len(timeColumns()) + 2
+	if denormalizeTags {
+		// Tag columns instead of tags_id
+		n += len(tableCols["tags"]) - 1
+	}
 	if inTableTag {
-		colLen++
+		n++
+	}
+	if singleTable {
+		n++
 	}
+	return n
+}
+
+// buildRows splits rows of tableName into their common tags and the values of
+// their columns, tags_id being left nil at tagsIdPosition, and counts their
+// metrics. Rows with missing values are skipped, per emptyFields, unless
+// nullableFields is set.
+func buildRows(tableName string, rows []*insertData) (tagRows [][]string, dataRows [][]interface{}, tagsIdPosition int, metricCnt uint64, err error) {
+	tagRows = make([][]string, 0, len(rows))
+	dataRows = make([][]interface{}, 0, len(rows))
+	commonTagsLen := len(tableCols["tags"])
 
-	var tagsIdPosition int = 0
+	colLen := rowLen(tableName)
 
 	for _, data := range rows {
 		// Split the tags into individual common tags and
@@ -171,160 +402,309 @@ func (p *processor) processCSI(tableName string, rows []*insertData) uint64 {
 		//	eu-west-1
 		//	eu-west-1b
 		// )
+		if len(tags) < commonTagsLen {
+			return nil, nil, 0, 0, fmt.Errorf("%s row has %d tags, want at least %d: %s", tableName, len(tags), commonTagsLen, data.tags)
+		}
 		for i := 0; i < commonTagsLen; i++ {
-			tags[i] = strings.Split(tags[i], "=")[1]
+			parts := strings.SplitN(tags[i], "=", 2)
+			if len(parts) != 2 {
+				return nil, nil, 0, 0, fmt.Errorf("%s row has tag '%s', want name=value", tableName, tags[i])
+			}
+			tags[i] = parts[1]
 		}
-		// prepare JSON for tags that are not common
-		var json interface{} = nil
+		// prepare the map or JSON of the tags that are not common
+		additionalTags := ""
 		if len(tags) > commonTagsLen {
-			// Join additional tags into JSON string
-			json = subsystemTagsToJSON(strings.Split(tags[commonTagsLen], ","))
-		} else {
-			// No additional tags
-			json = ""
+			additionalTags = tags[commonTagsLen]
 		}
 
 		// fields line ex.:
 		// 1451606400000000000,58,2,24,61,22,63,6,44,80,38
-		metrics := strings.Split(data.fields, ",")
+		// String values have their commas escaped
+		metrics := serialize.SplitTextFields(data.fields, ',')
 
-		// Count number of metrics processed
-		ret += uint64(len(metrics) - 1) // 1-st field is timestamp, do not count it
 		// metrics = (
 		// 	1451606400000000000,
 		// 	58,
 		// )
 
-		// Build string TimeStamp as '2006-01-02 15:04:05.999999 -0700'
-		// convert time from 1451606400000000000 (int64 UNIX TIMESTAMP with nanoseconds)
-		timestampNano, err := strconv.ParseInt(metrics[0], 10, 64)
+		// convert time from 1451606400000000000 (int64 UNIX TIMESTAMP, in nanoseconds by default)
+		timeUTC, err := parseTimestamp(metrics[0], timestampUnit)
 		if err != nil {
-			panic(err)
+			return nil, nil, 0, 0, fmt.Errorf("%s row has an invalid timestamp: %v", tableName, err)
 		}
-		timeUTC := time.Unix(0, timestampNano)
 
-		// use nil at 2-nd position as placeholder for tagKey
+		// use nil after the time columns as placeholder for tagKey
 		r := make([]interface{}, 0, colLen)
 		// First columns in table are
-		// created_date
-		// created_at
-		// tags_id - would be nil for now
+		// created_at - along with created_at_ns with DateTime time columns
+		// tags_id - would be nil for now, or the tags themselves with denormalized tags
 		// additional_tags
-		tagsIdPosition = 2 // what is the position of the tags_id in the row - nil value
-		r = append(r,
-			timeUTC,    // created_date
-			timeUTC,    // created_at
-			nil,        // tags_id
-			json)       // additional_tags
+		// created_date is derived from created_at by the server
+		r = append(r, timeValues(timeUTC)...)
+		if denormalizeTags {
+			tagsIdPosition = -1 // there is no tags_id
+			for _, tag := range tags[:commonTagsLen] {
+				r = append(r, tag)
+			}
+		} else {
+			tagsIdPosition = len(r) // what is the position of the tags_id in the row - nil value
+			r = append(r, nil)      // tags_id
+		}
+		r = append(r, additionalTagsValue(additionalTags)) // additional_tags
 
 		if inTableTag {
 			r = append(r, tags[0]) // tags[0] = hostname
 		}
-		for _, v := range metrics[1:] {
-			f64, err := strconv.ParseFloat(v, 64)
+		colTypes := tableColTypes[tableName]
+		values, empty := 0, 0
+		for i, v := range metrics[1:] { // 1-st field is timestamp, do not count it
+			colType := columnTypeFloat64
+			if i < len(colTypes) {
+				colType = colTypes[i]
+			}
+			// Empty values of other columns than String ones are missing
+			if len(v) == 0 && colType != columnTypeString {
+				empty++
+				if nullableFields {
+					r = append(r, nil)
+				} else {
+					r = append(r, zeroMetricValue(colType))
+				}
+				continue
+			}
+			value, err := parseMetricValue(v, colType)
 			if err != nil {
-				panic(err)
+				return nil, nil, 0, 0, fmt.Errorf("%s row has an invalid value: %v", tableName, err)
+			}
+			r = append(r, value)
+			values++
+		}
+		if empty > 0 {
+			atomic.AddUint64(&emptyFieldCount, uint64(empty))
+			if !nullableFields && emptyFields == emptyFieldsSkipRow {
+				continue
 			}
-			r = append(r, f64)
 		}
 
+		if singleTable {
+			r = append(r, tableName) // measurement
+		}
+
+		// Count number of metrics processed, missing ones aside
+		metricCnt += uint64(values)
 		dataRows = append(dataRows, r)
 		tagRows = append(tagRows, tags)
 	}
+	return tagRows, dataRows, tagsIdPosition, metricCnt, nil
+}
+
+// insertNewTags inserts those of the tags rows of tagRows, new to it when
+// looked up, that no other insert has added to p.csi since or is inserting,
+// without holding its lock for others to look up and insert theirs meanwhile.
+// It then waits for the inserts of the others, returning their rows that
+// failed to be inserted, to be inserted again.
+func (p *processor) insertNewTags(tagRows [][]string) (failed [][]string, err error) {
+	var missingTags [][]string
+	var waitTags [][]string
+	var waits []*tagsInsert
+	insert := &tagsInsert{done: make(chan struct{})}
+	p.csi.mutex.Lock()
+	for _, tagRow := range tagRows {
+		key := tagsKey(tagRow)
+		if _, ok := p.csi.m[key]; ok {
+			continue
+		}
+		if other, ok := p.csi.pending[key]; ok {
+			waitTags = append(waitTags, tagRow)
+			waits = append(waits, other)
+			continue
+		}
+		p.csi.pending[key] = insert
+		missingTags = append(missingTags, tagRow)
+	}
+	p.csi.mutex.Unlock()
 
+	if len(missingTags) > 0 {
+		keyToTags, err := p.insertTags(missingTags)
+		p.csi.mutex.Lock()
+		// Insert new tags into map as well
+		for key, tagsId := range keyToTags {
+			p.csi.m[key] = tagsId
+		}
+		for _, tagRow := range missingTags {
+			delete(p.csi.pending, tagsKey(tagRow))
+		}
+		p.csi.mutex.Unlock()
+		insert.err = err
+		close(insert.done)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i, other := range waits {
+		<-other.done
+		if other.err != nil {
+			failed = append(failed, waitTags[i])
+		}
+	}
+	return failed, nil
+}
+
+// setTagsIDs sets the tags_id at tagsIdPosition of each data row to the id of
+// the tags row of its tags, inserting the tags rows that are new
+func (p *processor) setTagsIDs(tagRows [][]string, dataRows [][]interface{}, tagsIdPosition int) error {
 	// Check if any of these tags has yet to be inserted
 	// New tags in this batch, need to be inserted
-	newTags := make([][]string, 0, len(rows))
+	newTags := make([][]string, 0, len(tagRows))
+	batchTags := make(map[string]bool)
 	p.csi.mutex.RLock()
 	for _, tagRow := range tagRows {
 		// tagRow contains what was called `tags` earlier - see one screen higher
 		// tagRow[0] = hostname
-		if _, ok := p.csi.m[tagRow[0]]; !ok {
+		key := tagsKey(tagRow)
+		if _, ok := p.csi.m[key]; !ok && !batchTags[key] {
 			// Tags of this hostname are not listed as inserted - new tags line, add it for creation
 			newTags = append(newTags, tagRow)
+			batchTags[key] = true
 		}
 	}
 	p.csi.mutex.RUnlock()
 
 	// Deal with new tags
-	if len(newTags) > 0 {
-		// We have new tags to insert
-		p.csi.mutex.Lock()
-		hostnameToTags := insertTags(p.db, len(p.csi.m), newTags, true)
-		// Insert new tags into map as well
-		for hostName, tagsId := range hostnameToTags {
-			p.csi.m[hostName] = tagsId
+	for len(newTags) > 0 {
+		var err error
+		if newTags, err = p.insertNewTags(newTags); err != nil {
+			return err
 		}
-		p.csi.mutex.Unlock()
 	}
 
 	// Deal with tag ids for each data row
 	p.csi.mutex.RLock()
 	for i := range dataRows {
-		// tagKey = hostname
-		tagKey := tagRows[i][0]
+		// tagKey = hostname, or all tag values with dynamic tags
+		tagKey := tagsKey(tagRows[i])
 		// Insert id of the tag (tags.id) for this host into tags_id position of the dataRows record
 		// refers to
 		// nil,		// tags_id
 
-		dataRows[i][tagsIdPosition] = p.csi.m[tagKey]
+		dataRows[i][tagsIdPosition] = uint32(p.csi.m[tagKey]) // as the UInt32 tags_id column
 	}
 	p.csi.mutex.RUnlock()
+	return nil
+}
+
+// Process part of incoming data - insert into tables. It returns the number
+// of metrics inserted, or an error if the rows are malformed or cannot be
+// inserted, none of them being inserted then.
+func (p *processor) processCSI(tableName string, rows []*insertData) (uint64, error) {
+	if len(rows) > 0 {
+		debugLog.printEvery(2, "row "+tableName, "%s row: %s", tableName, debugRow(tableName, rows[0]))
+	}
+	tagRows, dataRows, tagsIdPosition, ret, err := buildRows(tableName, rows)
+	if err != nil {
+		return 0, err
+	}
+	// Rows refer to their tags by id, unless they hold them
+	if !denormalizeTags {
+		if err := p.setTagsIDs(tagRows, dataRows, tagsIdPosition); err != nil {
+			return 0, err
+		}
+	}
 
 	// Prepare column names
-	cols := make([]string, 0, colLen)
-	// First columns would be "created_date", "created_at", "time", "tags_id", "additional_tags"
+	cols := make([]string, 0, rowLen(tableName))
+	// First columns would be the time columns, "tags_id" or the tags, "additional_tags"
 	// Inspite of "additional_tags" being added the last one in CREATE TABLE stmt
-	// it goes as a third one here - because we can move columns - they are named
+	// it goes right after "tags_id" here - because we can move columns - they are named
 	// and it is easier to keep variable coumns at the end of the list
-	cols = append(cols, "created_date", "created_at", "tags_id", "additional_tags")
+	cols = append(cols, timeColumns()...)
+	if denormalizeTags {
+		cols = append(cols, tableCols["tags"]...)
+	} else {
+		cols = append(cols, "tags_id")
+	}
+	cols = append(cols, "additional_tags")
 	if inTableTag {
 		cols = append(cols, tableCols["tags"][0]) // hostname
 	}
-	cols = append(cols, tableCols[tableName]...)
-
-	// INSERT statement template
-	sql := fmt.Sprintf(`
-		INSERT INTO %s (
-			%s
-		) VALUES (
-			%s
-		)
-		`,
-		tableName,
-		strings.Join(cols, ","),
-		strings.Repeat(",?", len(cols))[1:]) // We need '?,?,?', but repeat ",?" thus we need to chop off 1-st char
-
-	tx := p.db.MustBegin()
-	stmt, err := tx.Prepare(sql)
-	for _, r := range dataRows {
-		_, err := stmt.Exec(r...)
-		if err != nil {
-			panic(err)
+	target := tableName
+	if singleTable {
+		// Rows of all metrics tables are inserted into the single table
+		target = singleTableName
+		for _, column := range tableCols[tableName] {
+			if len(column) > 0 {
+				column = singleTableColumn(tableName, column)
+			}
+			cols = append(cols, column)
 		}
+		cols = append(cols, measurementColumn)
+	} else {
+		cols = append(cols, tableCols[tableName]...)
 	}
-	err = stmt.Close()
-	if err != nil {
-		panic(err)
-	}
-	err = tx.Commit()
-	if err != nil {
-		panic(err)
+
+	if err := p.insert(insertTable(target), cols, dataRows); err != nil {
+		return 0, err
 	}
+	addInsertedRows(target, len(dataRows))
 
-	return ret
+	return ret, nil
 }
 
 // load.Processor interface implementation
 type processor struct {
 	db  *sqlx.DB
 	csi *syncCSI
+	// http, if set, is the connection rows are inserted with instead of db
+	http *httpConn
+	// conn, if set, is the clickhouse-go v2 connection rows are inserted
+	// with instead of db, with -insert-api batch
+	conn driver.Conn
+
+	workerNum int
+	// batchNum and insertNum number the batches processed and the inserts of
+	// the current one, from 1, for their deduplication tokens
+	batchNum  int
+	insertNum int
+	// pending holds the rows of each table with -batch-per-table until there
+	// are tableBatchSize of them
+	pending *tableArr
+	// batchLog, if set, logs the inserts of the tables of the batches
+	batchLog *batchLogger
 }
 
 // load.Processor interface implementation
 func (p *processor) Init(workerNum int, doLoad bool) {
+	p.workerNum = workerNum
+	if batchPerTable {
+		p.pending = &tableArr{m: map[string][]*insertData{}}
+	}
+	if logBatches || batchLogOut != nil {
+		p.batchLog = newBatchLogger(workerNum, logBatches, batchLogOut)
+	}
 	if doLoad {
-		p.db = sqlx.MustConnect(dbType, getConnectString(true))
+		debugLog.printf(1, "worker %d inserts into %s", workerNum, workerAddress(workerNum))
+		var err error
+		switch {
+		case protocol == protocolHTTP:
+			if p.http, err = newHTTPConn(workerAddress(workerNum), true); err != nil {
+				panic(err)
+			}
+			p.http.settings = insertSettings()
+		case insertAPI == insertAPIBatch:
+			if p.conn, err = openBatchConn(workerAddresses(workerNum), loader.DatabaseName()); err != nil {
+				panic(err)
+			}
+		default:
+			p.db = sqlx.MustConnect(dbType, workerConnectString(workerNum))
+			p.db.SetMaxOpenConns(connectionsPerWorker)
+			p.db.SetMaxIdleConns(connectionsPerWorker)
+		}
+		// Rows hold their tags with denormalized tags, there are no ids to cache
+		if denormalizeTags {
+			return
+		}
 		if hashWorkers {
 			p.csi = newSyncCSI()
 		} else {
@@ -335,32 +715,151 @@ func (p *processor) Init(workerNum int, doLoad bool) {
 
 // load.ProcessorCloser interface implementation
 func (p *processor) Close(doLoad bool) {
-	if doLoad {
+	p.batchLog.flush()
+	if !doLoad {
+		return
+	}
+	switch {
+	case p.http != nil:
+		p.http.Close()
+	case p.conn != nil:
+		p.conn.Close()
+	default:
 		p.db.Close()
 	}
 }
 
-// load.Processor interface implementation
+// runConcurrently calls f for each of n items, up to limit calls at once, or
+// in order if limit is 1. A panic of any call, e.g., a failed insert, is
+// raised again once all calls have returned.
+func runConcurrently(n, limit int, f func(i int)) {
+	if limit <= 1 {
+		for i := 0; i < n; i++ {
+			f(i)
+		}
+		return
+	}
+	sem := make(chan struct{}, limit)
+	panics := make([]interface{}, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				panics[i] = recover()
+				<-sem
+				wg.Done()
+			}()
+			f(i)
+		}(i)
+	}
+	wg.Wait()
+	for _, p := range panics {
+		if p != nil {
+			panic(p)
+		}
+	}
+}
+
+// load.Processor interface implementation, panicking on the error of
+// ProcessBatchWithError
 func (p *processor) ProcessBatch(b load.Batch, doLoad bool) (uint64, uint64) {
+	metricCnt, rowCnt, err := p.ProcessBatchWithError(b, doLoad)
+	if err != nil {
+		panic(err)
+	}
+	return metricCnt, rowCnt
+}
+
+// load.ProcessorWithError interface implementation. Each table of the batch is
+// inserted even if others fail, the counts being those of the tables inserted
+// and the error that of the first table failing. With -batch-per-table, the
+// rows of the batch are held back instead, and the tables having
+// tableBatchSize rows held back are inserted.
+func (p *processor) ProcessBatchWithError(b load.Batch, doLoad bool) (uint64, uint64, error) {
 	batches := b.(*tableArr)
+	if p.pending != nil {
+		batches.moveTo(p.pending)
+		batches.recycle()
+		batches = p.pending.takeFull(tableBatchSize)
+		if batches.Len() == 0 {
+			batches.recycle()
+			return 0, 0, nil
+		}
+	}
+	return p.processTables(batches, doLoad)
+}
+
+// load.ProcessorFlusher interface implementation, inserting the rows held back
+// with -batch-per-table
+func (p *processor) Flush(doLoad bool) (uint64, uint64, error) {
+	if p.pending == nil || p.pending.Len() == 0 {
+		return 0, 0, nil
+	}
+	batches := p.pending
+	p.pending = &tableArr{m: map[string][]*insertData{}}
+	return p.processTables(batches, doLoad)
+}
+
+// processTables inserts the rows of each table of batches, as
+// ProcessBatchWithError does, and recycles batches. If the tables failing to
+// be inserted all failed on retryable errors, batches is rather left with
+// them only, for it to be loaded again with -batch-retries without inserting
+// the other tables twice, unless its rows are those held back with
+// -batch-per-table.
+func (p *processor) processTables(batches *tableArr, doLoad bool) (uint64, uint64, error) {
 	rowCnt := 0
 	metricCnt := uint64(0)
-	for tableName, rows := range batches.m {
-		rowCnt += len(rows)
-		if doLoad {
+	p.batchNum++
+	p.insertNum = 0
+	// Tables are processed in order, for the same batch to be inserted the
+	// same way on every run, unless they are inserted concurrently
+	tableNames := make([]string, 0, len(batches.m))
+	for tableName := range batches.m {
+		tableNames = append(tableNames, tableName)
+		rowCnt += len(batches.m[tableName])
+	}
+	sort.Strings(tableNames)
+	var err error
+	if doLoad {
+		metricCnts := make([]uint64, len(tableNames))
+		errs := make([]error, len(tableNames))
+		ends := make([]time.Time, len(tableNames))
+		tooks := make([]time.Duration, len(tableNames))
+		runConcurrently(len(tableNames), connectionsPerWorker, func(i int) {
 			start := time.Now()
-			metricCnt += p.processCSI(tableName, rows)
-
-			if logBatches {
-				now := time.Now()
-				took := now.Sub(start)
-				batchSize := len(rows)
-				fmt.Printf("BATCH: batchsize %d row rate %f/sec (took %v)\n", batchSize, float64(batchSize)/float64(took.Seconds()), took)
+			metricCnts[i], errs[i] = p.processCSI(tableNames[i], batches.m[tableNames[i]])
+			ends[i] = time.Now()
+			tooks[i] = ends[i].Sub(start)
+		})
+		// The inserts are logged once done, rather than by the concurrent
+		// calls above, for the records of the worker to be buffered together
+		retry := p.pending == nil
+		for i, cnt := range metricCnts {
+			if errs[i] != nil {
+				rowCnt -= len(batches.m[tableNames[i]])
+				if err == nil {
+					err = errs[i]
+				}
+				retry = retry && retryableError(errs[i])
+				continue
+			}
+			metricCnt += cnt
+			if p.batchLog != nil {
+				p.batchLog.log(ends[i], p.batchNum, tableNames[i], len(batches.m[tableNames[i]]), cnt, tooks[i])
+			}
+		}
+		if err != nil && retry {
+			for i, tableName := range tableNames {
+				if errs[i] == nil {
+					batches.remove(tableName)
+				}
 			}
+			return metricCnt, uint64(rowCnt), &load.RetryableError{Err: err}
 		}
 	}
-	batches.m = map[string][]*insertData{}
-	batches.cnt = 0
+	batches.recycle()
 
-	return metricCnt, uint64(rowCnt)
+	return metricCnt, uint64(rowCnt), err
 }
@@ -0,0 +1,926 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/timescale/tsbs/cmd/tsbs_generate_data/serialize"
+)
+
+func TestParseMetricValue(t *testing.T) {
+	cases := []struct {
+		desc      string
+		value     string
+		colType   string
+		want      interface{}
+		shouldErr bool
+	}{
+		{desc: "float", value: "1.5", colType: columnTypeFloat64, want: 1.5},
+		{desc: "integer in float column", value: "58", colType: columnTypeFloat64, want: float64(58)},
+		{desc: "int64", value: "-3", colType: columnTypeInt64, want: int64(-3)},
+		{desc: "uint64", value: "18446744073709551615", colType: columnTypeUInt64, want: uint64(18446744073709551615)},
+		{desc: "negative uint64", value: "-3", colType: columnTypeUInt64, shouldErr: true},
+		{desc: "float in int64 column", value: "1.5", colType: columnTypeInt64, shouldErr: true},
+		{desc: "string", value: "up, ready", colType: columnTypeString, want: "up, ready"},
+		{desc: "true", value: "true", colType: columnTypeBool, want: uint8(1)},
+		{desc: "false", value: "false", colType: columnTypeBool, want: uint8(0)},
+		{desc: "invalid bool", value: "yes", colType: columnTypeBool, shouldErr: true},
+	}
+
+	for _, c := range cases {
+		got, err := parseMetricValue(c.value, c.colType)
+		if c.shouldErr {
+			if err == nil {
+				t.Errorf("%s: unexpected lack of error", c.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.desc, err)
+		} else if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: incorrect value: got %v (%T) want %v (%T)", c.desc, got, got, c.want, c.want)
+		}
+	}
+}
+
+func TestParseTimestampRoundTrip(t *testing.T) {
+	ts := time.Unix(1451606400, 123456789).UTC()
+	p := serialize.NewPoint()
+	p.SetMeasurementName([]byte("cpu"))
+	p.AppendTag([]byte("hostname"), []byte("host_0"))
+	p.AppendField([]byte("usage_user"), 1.0)
+	p.SetTimestamp(&ts)
+
+	for _, precision := range []string{
+		serialize.PrecisionSeconds,
+		serialize.PrecisionMilliseconds,
+		serialize.PrecisionMicroseconds,
+		serialize.PrecisionNanoseconds,
+	} {
+		unit, err := serialize.ParseTimestampPrecision(precision)
+		if err != nil {
+			t.Fatalf("unexpected error for precision %s: %v", precision, err)
+		}
+		var buf bytes.Buffer
+		s := &serialize.TimescaleDBSerializer{TimestampUnit: unit}
+		if err := s.Serialize(p, &buf); err != nil {
+			t.Fatalf("unexpected error serializing with precision %s: %v", precision, err)
+		}
+		// second line is the fields, led by the measurement and timestamp
+		fields := strings.Split(strings.Split(buf.String(), "\n")[1], ",")
+		want := ts.Truncate(unit)
+
+		for _, given := range []time.Duration{0, unit} {
+			got, err := parseTimestamp(fields[1], given)
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", precision, err)
+			} else if !got.Equal(want) {
+				t.Errorf("%s (given unit %v): incorrect time: got %v want %v", precision, given, got, want)
+			}
+		}
+	}
+
+	if _, err := parseTimestamp("now", 0); err == nil {
+		t.Errorf("unexpected lack of error for non-numeric timestamp")
+	}
+}
+
+func TestTimestampPrecisionDefault(t *testing.T) {
+	// The timestamps are nanoseconds unless told otherwise, for those before
+	// 1973 not to be detected as of a coarser unit
+	if timestampUnit != time.Nanosecond {
+		t.Errorf("incorrect default timestamp unit: got %v want %v", timestampUnit, time.Nanosecond)
+	}
+	got, err := parseTimestamp("1000000000", timestampUnit)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := time.Unix(1, 0); !got.Equal(want) {
+		t.Errorf("incorrect time: got %v want %v", got, want)
+	}
+}
+
+func TestTagsKey(t *testing.T) {
+	oldCols, oldDynamic := tableCols, dynamicTags
+	defer func() { tableCols, dynamicTags = oldCols, oldDynamic }()
+	tableCols = map[string][]string{"tags": {"hostname", "team", "service_version"}}
+
+	rows := [][]string{
+		{"host_0", "SF", "0"},
+		{"host_0", "SF", "1"},
+		{"host_0", "NYC", "1"},
+		{"host_1", "SF", "0"},
+		// extra tags are not part of the key
+		{"host_1", "SF", "0", "nginx_port=80"},
+	}
+	cases := []struct {
+		desc    string
+		dynamic bool
+		want    int
+	}{
+		{desc: "static tags", dynamic: false, want: 2},
+		{desc: "dynamic tags", dynamic: true, want: 4},
+	}
+	for _, c := range cases {
+		dynamicTags = c.dynamic
+		keys := make(map[string]bool)
+		for _, row := range rows {
+			keys[tagsKey(row)] = true
+		}
+		if got := len(keys); got != c.want {
+			t.Errorf("%s: incorrect number of distinct tags rows: got %d want %d", c.desc, got, c.want)
+		}
+	}
+}
+
+func TestTimeValues(t *testing.T) {
+	ts := time.Date(2016, time.January, 1, 0, 0, 1, 123456789, time.UTC)
+	cases := []struct {
+		desc      string
+		precision int
+		wantCols  []string
+		want      []interface{}
+	}{
+		{
+			desc:      "DateTime64",
+			precision: 9,
+			wantCols:  []string{"created_at"},
+			want:      []interface{}{ts},
+		},
+		{
+			desc:      "DateTime",
+			precision: 0,
+			wantCols:  []string{"created_at", "created_at_ns"},
+			want:      []interface{}{time.Date(2016, time.January, 1, 0, 0, 1, 0, time.UTC), uint32(123456789)},
+		},
+	}
+
+	oldTimePrecision := timePrecision
+	defer func() { timePrecision = oldTimePrecision }()
+	for _, c := range cases {
+		timePrecision = c.precision
+		if got := timeColumns(); !reflect.DeepEqual(got, c.wantCols) {
+			t.Errorf("%s: incorrect columns: got %v want %v", c.desc, got, c.wantCols)
+		}
+		if got := timeValues(ts); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: incorrect values: got %v want %v", c.desc, got, c.want)
+		}
+	}
+}
+
+func TestBuildRowsEmptyFields(t *testing.T) {
+	rows := []*insertData{
+		{tags: "hostname=host_0", fields: "1451606400,1.5,2,ok"},
+		{tags: "hostname=host_1", fields: "1451606400,,2,ok"},
+		{tags: "hostname=host_2", fields: "1451606400,,,"},
+	}
+	cases := []struct {
+		desc       string
+		nullable   bool
+		empty      string
+		wantValues [][]interface{}
+		wantCnt    uint64
+	}{
+		{
+			desc:     "nullable",
+			nullable: true,
+			empty:    emptyFieldsZero,
+			wantValues: [][]interface{}{
+				{1.5, int64(2), "ok"},
+				{nil, int64(2), "ok"},
+				{nil, nil, ""},
+			},
+			wantCnt: 6,
+		},
+		{
+			desc:  "zero-filled",
+			empty: emptyFieldsZero,
+			wantValues: [][]interface{}{
+				{1.5, int64(2), "ok"},
+				{float64(0), int64(2), "ok"},
+				{float64(0), int64(0), ""},
+			},
+			wantCnt: 6,
+		},
+		{
+			desc:       "skipped",
+			empty:      emptyFieldsSkipRow,
+			wantValues: [][]interface{}{{1.5, int64(2), "ok"}},
+			wantCnt:    3,
+		},
+	}
+
+	oldCols, oldTypes, oldNullable, oldEmpty := tableCols, tableColTypes, nullableFields, emptyFields
+	defer func() {
+		tableCols, tableColTypes, nullableFields, emptyFields = oldCols, oldTypes, oldNullable, oldEmpty
+	}()
+	tableCols = map[string][]string{"tags": {"hostname"}}
+	tableColTypes = map[string][]string{}
+	tableCols["cpu"], tableColTypes["cpu"] = splitColumnSpecs([]string{"usage_user", "usage_system:int64", "status:string"})
+	for _, c := range cases {
+		nullableFields, emptyFields = c.nullable, c.empty
+		oldEmptyCount := emptyFieldCount
+		tagRows, dataRows, tagsIdPosition, metricCnt, err := buildRows("cpu", rows)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.desc, err)
+		}
+		if metricCnt != c.wantCnt {
+			t.Errorf("%s: incorrect metric count: got %d want %d", c.desc, metricCnt, c.wantCnt)
+		}
+		if got := emptyFieldCount - oldEmptyCount; got != 3 {
+			t.Errorf("%s: incorrect empty field count: got %d want 3", c.desc, got)
+		}
+		// A dry run counts the rows and metrics that would be inserted
+		batch := &tableArr{m: map[string][]*insertData{"cpu": rows}, cnt: len(rows)}
+		if metrics, rowCnt := batch.Counts(); metrics != metricCnt || rowCnt != uint64(len(dataRows)) {
+			t.Errorf("%s: incorrect dry run counts: got %d metrics and %d rows want %d and %d", c.desc, metrics, rowCnt, metricCnt, len(dataRows))
+		}
+		if len(dataRows) != len(c.wantValues) || len(tagRows) != len(c.wantValues) {
+			t.Errorf("%s: incorrect number of rows: got %d want %d", c.desc, len(dataRows), len(c.wantValues))
+			continue
+		}
+		for i, r := range dataRows {
+			// Metrics follow tags_id and additional_tags
+			if got := r[tagsIdPosition+2:]; !reflect.DeepEqual(got, c.wantValues[i]) {
+				t.Errorf("%s: incorrect values of row %d: got %v want %v", c.desc, i, got, c.wantValues[i])
+			}
+		}
+	}
+}
+
+func TestBuildRowsSingleTable(t *testing.T) {
+	oldCols, oldTypes, oldSingleTable := tableCols, tableColTypes, singleTable
+	defer func() { tableCols, tableColTypes, singleTable = oldCols, oldTypes, oldSingleTable }()
+	tableCols = map[string][]string{"tags": {"hostname"}, "cpu": {"usage_user", "usage_system"}}
+	tableColTypes = map[string][]string{"cpu": {columnTypeFloat64, columnTypeFloat64}}
+
+	rows := []*insertData{{tags: "hostname=host_0", fields: "1451606400,1.5,2.5"}}
+	for _, single := range []bool{false, true} {
+		singleTable = single
+		_, dataRows, tagsIdPosition, metricCnt, err := buildRows("cpu", rows)
+		if err != nil {
+			t.Fatalf("single table %v: unexpected error: %v", single, err)
+		}
+		if metricCnt != 2 {
+			t.Errorf("single table %v: incorrect metric count: got %d want 2", single, metricCnt)
+		}
+		want := []interface{}{1.5, 2.5}
+		if single {
+			want = append(want, "cpu")
+		}
+		if got := dataRows[0][tagsIdPosition+2:]; !reflect.DeepEqual(got, want) {
+			t.Errorf("single table %v: incorrect values: got %v want %v", single, got, want)
+		}
+		if got := rowLen("cpu"); got != len(dataRows[0]) {
+			t.Errorf("single table %v: incorrect row length: got %d want %d", single, got, len(dataRows[0]))
+		}
+	}
+}
+
+func TestBuildRowsDenormalizedTags(t *testing.T) {
+	oldCols, oldTypes, oldDenormalize := tableCols, tableColTypes, denormalizeTags
+	defer func() { tableCols, tableColTypes, denormalizeTags = oldCols, oldTypes, oldDenormalize }()
+	tableCols = map[string][]string{"tags": {"hostname", "region"}, "cpu": {"usage_user"}}
+	tableColTypes = map[string][]string{"cpu": {columnTypeFloat64}}
+	denormalizeTags = true
+
+	rows := []*insertData{
+		{tags: "hostname=host_0,region=eu-west-1", fields: "1451606400,1.5"},
+		{tags: "hostname=host_1,region=us-east-1,nginx_port=80", fields: "1451606400,2.5"},
+	}
+	tagRows, dataRows, tagsIdPosition, metricCnt, err := buildRows("cpu", rows)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tagsIdPosition >= 0 {
+		t.Errorf("unexpected tags_id position %d", tagsIdPosition)
+	}
+	if metricCnt != 2 || len(tagRows) != 2 {
+		t.Errorf("incorrect counts: got %d metrics in %d rows want 2 in 2", metricCnt, len(tagRows))
+	}
+	want := [][]interface{}{
+		{"host_0", "eu-west-1", "", 1.5},
+		{"host_1", "us-east-1", `{"nginx_port": "80"}`, 2.5},
+	}
+	for i, r := range dataRows {
+		// Tags follow created_at
+		if got := r[1:]; !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("incorrect row %d: got %v want %v", i, got, want[i])
+		}
+		if len(r) != rowLen("cpu") {
+			t.Errorf("incorrect length of row %d: got %d want %d", i, len(r), rowLen("cpu"))
+		}
+	}
+}
+
+func TestBuildRowsErrors(t *testing.T) {
+	oldCols, oldTypes := tableCols, tableColTypes
+	defer func() { tableCols, tableColTypes = oldCols, oldTypes }()
+	tableCols = map[string][]string{"tags": {"hostname", "region"}, "cpu": {"usage_user", "usage_system"}}
+	tableColTypes = map[string][]string{"cpu": {columnTypeFloat64, columnTypeInt64}}
+
+	cases := []struct {
+		desc string
+		row  *insertData
+		want string
+	}{
+		{
+			desc: "missing tag",
+			row:  &insertData{tags: "hostname=host_0", fields: "1451606400,1.5,2"},
+			want: "cpu row has 1 tags, want at least 2: hostname=host_0",
+		},
+		{
+			desc: "tag without value",
+			row:  &insertData{tags: "hostname=host_0,eu-west-1", fields: "1451606400,1.5,2"},
+			want: "cpu row has tag 'eu-west-1', want name=value",
+		},
+		{
+			desc: "invalid timestamp",
+			row:  &insertData{tags: "hostname=host_0,region=eu-west-1", fields: "2016-01-01,1.5,2"},
+			want: `cpu row has an invalid timestamp: strconv.ParseInt: parsing "2016-01-01": invalid syntax`,
+		},
+		{
+			desc: "invalid value",
+			row:  &insertData{tags: "hostname=host_0,region=eu-west-1", fields: "1451606400,1.5,2.5"},
+			want: `cpu row has an invalid value: strconv.ParseInt: parsing "2.5": invalid syntax`,
+		},
+	}
+	for _, c := range cases {
+		rows := []*insertData{{tags: "hostname=host_1,region=eu-west-1", fields: "1451606400,1.5,2"}, c.row}
+		if _, _, _, _, err := buildRows("cpu", rows); err == nil || err.Error() != c.want {
+			t.Errorf("%s: incorrect error: got %v want %s", c.desc, err, c.want)
+		}
+	}
+}
+
+func TestParseAdditionalTags(t *testing.T) {
+	cases := []struct {
+		desc       string
+		input      string
+		wantKeys   []string
+		wantValues []string
+		wantJSON   string
+	}{
+		{desc: "none", input: "", wantJSON: ""},
+		{
+			desc:       "one",
+			input:      "nginx_port=80",
+			wantKeys:   []string{"nginx_port"},
+			wantValues: []string{"80"},
+			wantJSON:   `{"nginx_port": "80"}`,
+		},
+		{
+			desc:       "several",
+			input:      "a=b,c=d",
+			wantKeys:   []string{"a", "c"},
+			wantValues: []string{"b", "d"},
+			wantJSON:   `{"a": "b","c": "d"}`,
+		},
+		{
+			desc:       "= in value",
+			input:      "url=/q?x=1",
+			wantKeys:   []string{"url"},
+			wantValues: []string{"/q?x=1"},
+			wantJSON:   `{"url": "/q?x=1"}`,
+		},
+		{
+			desc:       "empty value and missing =",
+			input:      "a=,b",
+			wantKeys:   []string{"a", "b"},
+			wantValues: []string{"", ""},
+			wantJSON:   `{"a": "","b": ""}`,
+		},
+		{
+			desc:       "empty tags",
+			input:      ",a=b,,",
+			wantKeys:   []string{"a"},
+			wantValues: []string{"b"},
+			wantJSON:   `{"a": "b"}`,
+		},
+	}
+
+	oldAdditionalTagsMap := additionalTagsMap
+	defer func() { additionalTagsMap = oldAdditionalTagsMap }()
+	for _, c := range cases {
+		keys, values := parseAdditionalTags(c.input)
+		if !reflect.DeepEqual(keys, c.wantKeys) || !reflect.DeepEqual(values, c.wantValues) {
+			t.Errorf("%s: incorrect tags: got %v=%v want %v=%v", c.desc, keys, values, c.wantKeys, c.wantValues)
+		}
+
+		additionalTagsMap = false
+		if got := additionalTagsValue(c.input); got != c.wantJSON {
+			t.Errorf("%s: incorrect JSON: got %v want %s", c.desc, got, c.wantJSON)
+		}
+		additionalTagsMap = true
+		want := make(map[string]string)
+		for i, k := range c.wantKeys {
+			want[k] = c.wantValues[i]
+		}
+		if got := additionalTagsValue(c.input); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: incorrect map: got %v want %v", c.desc, got, want)
+		}
+	}
+}
+
+func TestDedupSettings(t *testing.T) {
+	oldDedupTokenPrefix := dedupTokenPrefix
+	defer func() { dedupTokenPrefix = oldDedupTokenPrefix }()
+
+	dedupTokenPrefix = ""
+	p := &processor{workerNum: 2}
+	if got := p.dedupSettings(); got != nil {
+		t.Errorf("incorrect settings without a prefix: got %v want none", got)
+	}
+
+	dedupTokenPrefix = "run"
+	for _, batch := range []struct {
+		batchNum int
+		inserts  int
+		want     []string
+	}{
+		{batchNum: 1, inserts: 2, want: []string{"run-2-1-1", "run-2-1-2"}},
+		{batchNum: 2, inserts: 1, want: []string{"run-2-2-1"}},
+	} {
+		// As ProcessBatch does for each batch
+		p.batchNum, p.insertNum = batch.batchNum, 0
+		for i := 0; i < batch.inserts; i++ {
+			got := p.dedupSettings()
+			want := []setting{{name: "insert_deduplication_token", value: batch.want[i]}}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("incorrect settings of insert %d of batch %d: got %v want %v", i+1, batch.batchNum, got, want)
+			}
+		}
+	}
+}
+
+func TestNewTagsIDs(t *testing.T) {
+	oldLastTagsID, oldDedupTokenPrefix, oldNumWorkers := lastTagsID, dedupTokenPrefix, numWorkers
+	defer func() { lastTagsID, dedupTokenPrefix, numWorkers = oldLastTagsID, oldDedupTokenPrefix, oldNumWorkers }()
+
+	// Workers take their ids in turn, after those already in the tags table
+	dedupTokenPrefix, lastTagsID = "", 10
+	p1 := &processor{workerNum: 0, csi: newSyncCSI()}
+	p2 := &processor{workerNum: 1, csi: newSyncCSI()}
+	for _, c := range []struct {
+		p    *processor
+		n    int
+		want []uint32
+	}{
+		{p: p1, n: 3, want: []uint32{11, 12, 13}},
+		{p: p2, n: 2, want: []uint32{14, 15}},
+		{p: p1, n: 1, want: []uint32{16}},
+		{p: p2, n: 0, want: []uint32{}},
+	} {
+		if got := c.p.newTagsIDs(c.n); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("worker %d: incorrect ids of %d tags: got %v want %v", c.p.workerNum, c.n, got, c.want)
+		}
+	}
+
+	// With deduplication tokens, those of a worker do not depend on the others
+	dedupTokenPrefix, numWorkers = "run", 4
+	p := &processor{workerNum: 2, csi: newSyncCSI()}
+	p.csi.m["host_0"] = 3
+	if got, want := p.newTagsIDs(2), []uint32{7, 11}; !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect ids with -dedup-token-prefix: got %v want %v", got, want)
+	}
+	if lastTagsID != 16 {
+		t.Errorf("incorrect last id with -dedup-token-prefix: got %d want 16", lastTagsID)
+	}
+}
+
+func TestInsertTags(t *testing.T) {
+	var query, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		query, body = r.URL.Query().Get("query"), string(b)
+	}))
+	defer server.Close()
+
+	oldHost, oldPort, oldLastTagsID, oldDedupTokenPrefix := host, port, lastTagsID, dedupTokenPrefix
+	defer func() {
+		host, port, lastTagsID, dedupTokenPrefix = oldHost, oldPort, oldLastTagsID, oldDedupTokenPrefix
+	}()
+	serverURL, _ := url.Parse(server.URL)
+	host, port, _ = net.SplitHostPort(serverURL.Host)
+	lastTagsID, dedupTokenPrefix = 0, ""
+	tableCols["tags"] = []string{"hostname", "region"}
+
+	c, err := newHTTPConn(serverAddress(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	p := &processor{http: c, csi: newSyncCSI()}
+	// Values are sent as they are, but for the characters TabSeparated escapes
+	rows := [][]string{
+		{"host'0", "eu-west-1, 'b'"},
+		{"hôst_1", "東京"},
+		{"host_2", "a\\b\tc"},
+	}
+	got, err := p.insertTags(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int64{"host'0": 1, "hôst_1": 2, "host_2": 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect ids: got %v want %v", got, want)
+	}
+	if want := "INSERT INTO tags (id,hostname,region) FORMAT TabSeparated"; query != want {
+		t.Errorf("incorrect query: got %s want %s", query, want)
+	}
+	if want := "1\thost'0\teu-west-1, 'b'\n2\thôst_1\t東京\n3\thost_2\ta\\\\b\\tc\n"; body != want {
+		t.Errorf("incorrect rows: got %q want %q", body, want)
+	}
+}
+
+func TestSetTagsIDsConcurrently(t *testing.T) {
+	// The server takes its time inserting tags rows, failing the first insert
+	// of host_7
+	var mutex sync.Mutex
+	inserted := make(map[string][]string)
+	failed := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		time.Sleep(2 * time.Millisecond)
+		mutex.Lock()
+		defer mutex.Unlock()
+		lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+		if !failed && strings.Contains(string(body), "\thost_7\n") {
+			failed = true
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Code: 60. DB::Exception: Table benchmark.tags doesn't exist")
+			return
+		}
+		for _, line := range lines {
+			fields := strings.Split(line, "\t")
+			inserted[fields[1]] = append(inserted[fields[1]], fields[0])
+		}
+	}))
+	defer server.Close()
+
+	oldHost, oldPort, oldLastTagsID := host, port, lastTagsID
+	defer func() { host, port, lastTagsID = oldHost, oldPort, oldLastTagsID }()
+	serverURL, _ := url.Parse(server.URL)
+	host, port, _ = net.SplitHostPort(serverURL.Host)
+	lastTagsID = 0
+	tableCols["tags"] = []string{"hostname"}
+
+	// Workers share the cache, as without -hash-workers, each setting the
+	// ids of 20 hosts of 40 in turns of 5, overlapping with the others
+	const workers, hosts = 16, 40
+	csi := newSyncCSI()
+	ids := make([]map[string]uint32, workers)
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			c, err := newHTTPConn(serverAddress(), true)
+			if err != nil {
+				errs[w] = err
+				return
+			}
+			defer c.Close()
+			p := &processor{http: c, csi: csi, workerNum: w}
+			ids[w] = make(map[string]uint32)
+			for start := 0; start < 20; start += 5 {
+				var tagRows [][]string
+				var dataRows [][]interface{}
+				for i := start; i < start+5; i++ {
+					tagRows = append(tagRows, []string{fmt.Sprintf("host_%d", (w*3+i)%hosts)})
+					dataRows = append(dataRows, []interface{}{nil})
+				}
+				if err := p.setTagsIDs(tagRows, dataRows, 0); err != nil {
+					errs[w] = err
+					return
+				}
+				for i, row := range dataRows {
+					ids[w][tagRows[i][0]] = row[0].(uint32)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// Only the worker whose insert failed fails, the others inserting the
+	// hosts of that insert again
+	failures := 0
+	for _, err := range errs {
+		if err != nil {
+			failures++
+		}
+	}
+	if failures != 1 {
+		t.Errorf("incorrect number of failed workers: got %d want 1: %v", failures, errs)
+	}
+	// Each host is inserted once, and all workers give it the id it was
+	// inserted with
+	if len(inserted) != hosts {
+		t.Errorf("incorrect number of hosts inserted: got %d want %d", len(inserted), hosts)
+	}
+	for hostname, hostIDs := range inserted {
+		if len(hostIDs) != 1 {
+			t.Errorf("%s inserted %d times with ids %v", hostname, len(hostIDs), hostIDs)
+			continue
+		}
+		if want := strconv.FormatInt(csi.m[hostname], 10); hostIDs[0] != want {
+			t.Errorf("incorrect cached id of %s: got %s want %s", hostname, want, hostIDs[0])
+		}
+		for w, workerIDs := range ids {
+			if id, ok := workerIDs[hostname]; ok && strconv.FormatUint(uint64(id), 10) != hostIDs[0] {
+				t.Errorf("incorrect id of %s set by worker %d: got %d want %s", hostname, w, id, hostIDs[0])
+			}
+		}
+	}
+	if len(csi.pending) != 0 {
+		t.Errorf("inserts left pending: %v", csi.pending)
+	}
+}
+
+func TestProcessBatchMalformedRow(t *testing.T) {
+	var mutex sync.Mutex
+	inserted := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mutex.Lock()
+		inserted[strings.Fields(r.URL.Query().Get("query"))[2]] += strings.Count(string(body), "\n")
+		mutex.Unlock()
+	}))
+	defer server.Close()
+
+	oldHost, oldPort := host, port
+	defer func() { host, port = oldHost, oldPort }()
+	serverURL, _ := url.Parse(server.URL)
+	host, port, _ = net.SplitHostPort(serverURL.Host)
+	tableCols["tags"] = []string{"hostname"}
+	for _, table := range []string{"cpu", "mem"} {
+		tableCols[table], tableColTypes[table] = splitColumnSpecs([]string{"a", "b"})
+	}
+	c, err := newHTTPConn(serverAddress(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	p := &processor{http: c, csi: newSyncCSI()}
+
+	// Batches of 4 points, the second with a malformed cpu line
+	input := "tags,hostname=host_0\ncpu,1451606400,1,2\ntags,hostname=host_0\nmem,1451606400,1,2\n" +
+		"tags,hostname=host_1\ncpu,1451606400,1,2\ntags,hostname=host_1\nmem,1451606400,1,2\n" +
+		"tags,hostname=host_0\ncpu,1451606410,1,2\ntags,hostname=host_0\nmem,1451606410,1,2\n" +
+		"tags,hostname=host_1\ncpu,1451606410,x,2\ntags,hostname=host_1\nmem,1451606410,1,2\n" +
+		"tags,hostname=host_0\ncpu,1451606420,1,2\ntags,hostname=host_0\nmem,1451606420,1,2\n"
+	decoder := &decoder{scanner: bufio.NewScanner(strings.NewReader(input))}
+	var metricCnt, rowCnt uint64
+	var errs []error
+	f := &factory{}
+	b := f.New()
+	flush := func() {
+		m, r, err := p.ProcessBatchWithError(b, true)
+		metricCnt, rowCnt = metricCnt+m, rowCnt+r
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for item := decoder.Decode(nil); item != nil; item = decoder.Decode(nil) {
+		b.Append(item)
+		if b.Len() == 4 {
+			flush()
+			b = f.New()
+		}
+	}
+	flush()
+
+	// The cpu rows of the malformed batch are skipped, but for its mem rows
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), `cpu row has an invalid value: strconv.ParseFloat: parsing "x"`) {
+		t.Errorf("incorrect errors: got %v want that of the malformed line", errs)
+	}
+	if metricCnt != 16 || rowCnt != 8 {
+		t.Errorf("incorrect counts: got %d metrics and %d rows want 16 and 8", metricCnt, rowCnt)
+	}
+	if want := map[string]int{"tags": 2, "cpu": 3, "mem": 5}; !reflect.DeepEqual(inserted, want) {
+		t.Errorf("incorrect rows inserted: got %v want %v", inserted, want)
+	}
+}
+
+func TestRunConcurrently(t *testing.T) {
+	for _, limit := range []int{1, 2, 4} {
+		var mutex sync.Mutex
+		running, maxRunning := 0, 0
+		var order []int
+		runConcurrently(10, limit, func(i int) {
+			mutex.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			order = append(order, i)
+			mutex.Unlock()
+			time.Sleep(time.Millisecond)
+			mutex.Lock()
+			running--
+			mutex.Unlock()
+		})
+		if len(order) != 10 {
+			t.Errorf("limit %d: incorrect number of calls: got %d want 10", limit, len(order))
+		}
+		if maxRunning > limit {
+			t.Errorf("limit %d: incorrect number of concurrent calls: got %d", limit, maxRunning)
+		}
+		if limit == 1 && !reflect.DeepEqual(order, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}) {
+			t.Errorf("limit 1: incorrect order of calls: got %v", order)
+		}
+	}
+
+	// A panic of any call is raised once all have returned
+	calls := int32(0)
+	func() {
+		defer func() {
+			if r := recover(); r != "cannot insert" {
+				t.Errorf("incorrect panic: got %v want cannot insert", r)
+			}
+		}()
+		runConcurrently(5, 2, func(i int) {
+			atomic.AddInt32(&calls, 1)
+			if i == 1 {
+				panic("cannot insert")
+			}
+		})
+	}()
+	if calls != 5 {
+		t.Errorf("incorrect number of calls with a panic: got %d want 5", calls)
+	}
+}
+
+func TestProcessBatchConcurrently(t *testing.T) {
+	// The server takes its time inserting, failing inserts into fail
+	var mutex sync.Mutex
+	inserted := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		table := strings.Fields(r.URL.Query().Get("query"))[2]
+		time.Sleep(5 * time.Millisecond)
+		if table == "fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		mutex.Lock()
+		inserted[table] += strings.Count(string(body), "\n")
+		mutex.Unlock()
+	}))
+	defer server.Close()
+
+	oldHost, oldPort, oldConnectionsPerWorker := host, port, connectionsPerWorker
+	defer func() { host, port, connectionsPerWorker = oldHost, oldPort, oldConnectionsPerWorker }()
+	serverURL, _ := url.Parse(server.URL)
+	host, port, _ = net.SplitHostPort(serverURL.Host)
+	tables := []string{"cpu", "disk", "mem", "net", "fail"}
+	tableCols["tags"] = []string{"hostname"}
+	for _, table := range tables {
+		tableCols[table], tableColTypes[table] = splitColumnSpecs([]string{"a", "b"})
+	}
+
+	for _, connections := range []int{1, 3} {
+		connectionsPerWorker = connections
+		c, err := newHTTPConn(serverAddress(), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := &processor{http: c, csi: newSyncCSI()}
+		inserted = make(map[string]int)
+		b := &tableArr{m: map[string][]*insertData{}}
+		for _, table := range tables[:4] {
+			for i := 0; i < 10; i++ {
+				row := &insertData{tags: fmt.Sprintf("hostname=host_%d", i), fields: fmt.Sprintf("%d,1,2", 1451606400+i)}
+				b.m[table] = append(b.m[table], row)
+			}
+		}
+		metricCnt, rowCnt := p.ProcessBatch(b, true)
+		if metricCnt != 80 || rowCnt != 40 {
+			t.Errorf("%d connections: incorrect counts: got %d metrics and %d rows want 80 and 40", connections, metricCnt, rowCnt)
+		}
+		// The tags of the hosts are inserted once, whichever table has them first
+		want := map[string]int{"cpu": 10, "disk": 10, "mem": 10, "net": 10, "tags": 10}
+		if !reflect.DeepEqual(inserted, want) {
+			t.Errorf("%d connections: incorrect rows inserted: got %v want %v", connections, inserted, want)
+		}
+
+		// A failed insert fails the batch
+		b.m["fail"] = []*insertData{{tags: "hostname=host_0", fields: "1451606400,1,2"}}
+		b.m["cpu"] = []*insertData{{tags: "hostname=host_0", fields: "1451606400,1,2"}}
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("%d connections: unexpected lack of failure", connections)
+				}
+			}()
+			p.ProcessBatch(b, true)
+		}()
+		c.Close()
+	}
+}
+
+func TestProcessBatchPerTable(t *testing.T) {
+	// The server records the number of rows of each insert into each table
+	var mutex sync.Mutex
+	inserts := make(map[string][]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		table := strings.Fields(r.URL.Query().Get("query"))[2]
+		mutex.Lock()
+		inserts[table] = append(inserts[table], strings.Count(string(body), "\n"))
+		mutex.Unlock()
+	}))
+	defer server.Close()
+
+	oldHost, oldPort, oldBatchPerTable, oldTableBatchSize := host, port, batchPerTable, tableBatchSize
+	defer func() {
+		host, port, batchPerTable, tableBatchSize = oldHost, oldPort, oldBatchPerTable, oldTableBatchSize
+	}()
+	serverURL, _ := url.Parse(server.URL)
+	host, port, _ = net.SplitHostPort(serverURL.Host)
+	batchPerTable, tableBatchSize = true, 4
+	tableCols["tags"] = []string{"hostname"}
+	for _, table := range []string{"cpu", "mem"} {
+		tableCols[table], tableColTypes[table] = splitColumnSpecs([]string{"a", "b"})
+	}
+	c, err := newHTTPConn(serverAddress(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	p := &processor{http: c, csi: newSyncCSI()}
+	p.Init(0, false)
+
+	// Each batch has 3 cpu rows and 1 mem row, each table being inserted
+	// once it has 4 rows held back, the rest being flushed
+	wantRows := []uint64{0, 6, 0, 10, 0}
+	for i, want := range wantRows {
+		b := &tableArr{m: map[string][]*insertData{}}
+		for j := 0; j < 3; j++ {
+			b.m["cpu"] = append(b.m["cpu"], &insertData{tags: fmt.Sprintf("hostname=host_%d", j), fields: fmt.Sprintf("%d,1,2", 1451606400+i)})
+		}
+		b.m["mem"] = []*insertData{{tags: "hostname=host_0", fields: fmt.Sprintf("%d,1,2", 1451606400+i)}}
+		b.cnt = 4
+		metricCnt, rowCnt := p.ProcessBatch(b, true)
+		if metricCnt != 2*want || rowCnt != want {
+			t.Errorf("batch %d: incorrect counts: got %d metrics and %d rows want %d and %d", i, metricCnt, rowCnt, 2*want, want)
+		}
+	}
+	metricCnt, rowCnt, err := p.Flush(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metricCnt != 8 || rowCnt != 4 {
+		t.Errorf("incorrect counts of flush: got %d metrics and %d rows want 8 and 4", metricCnt, rowCnt)
+	}
+	// Nothing is left to flush
+	if metricCnt, rowCnt, err = p.Flush(true); metricCnt != 0 || rowCnt != 0 || err != nil {
+		t.Errorf("incorrect second flush: got %d metrics, %d rows and %v want nothing", metricCnt, rowCnt, err)
+	}
+	want := map[string][]int{"cpu": {6, 6, 3}, "mem": {4, 1}, "tags": {3}}
+	if !reflect.DeepEqual(inserts, want) {
+		t.Errorf("incorrect sizes of inserts: got %v want %v", inserts, want)
+	}
+}
+
+// BenchmarkBuildRows measures the assembly of batches of cpu rows of 10
+// metrics, their times being bound as time.Time
+func BenchmarkBuildRows(b *testing.B) {
+	oldCols, oldTypes := tableCols, tableColTypes
+	defer func() { tableCols, tableColTypes = oldCols, oldTypes }()
+	cols := make([]string, 10)
+	for i := range cols {
+		cols[i] = fmt.Sprintf("usage_%d", i)
+	}
+	tableCols = map[string][]string{"tags": {"hostname"}}
+	tableColTypes = map[string][]string{}
+	tableCols["cpu"], tableColTypes["cpu"] = splitColumnSpecs(cols)
+
+	rows := make([]*insertData, 1000)
+	for i := range rows {
+		rows[i] = &insertData{
+			tags:   fmt.Sprintf("hostname=host_%d", i%100),
+			fields: fmt.Sprintf("%d%s", 1451606400000000000+int64(i)*int64(time.Second), strings.Repeat(",58.5", len(cols))),
+		}
+	}
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		if _, _, _, _, err := buildRows("cpu", rows); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(b.N*len(rows))/time.Since(start).Seconds(), "rows/s")
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"math/rand"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// retryableCodes are the codes of the ClickHouse exceptions that a failed
+// insert is retried on, the server being busy or unreachable for a while
+var retryableCodes = map[int]bool{
+	159: true, // TIMEOUT_EXCEEDED
+	202: true, // TOO_MANY_SIMULTANEOUS_QUERIES
+	209: true, // SOCKET_TIMEOUT
+	210: true, // NETWORK_ERROR
+	242: true, // TABLE_IS_READ_ONLY, e.g., while the replica reconnects to ZooKeeper
+	252: true, // TOO_MANY_PARTS, until merges catch up
+	285: true, // TOO_FEW_LIVE_REPLICAS
+	319: true, // UNKNOWN_STATUS_OF_INSERT
+}
+
+// exceptionCodeRegexp matches the code of a ClickHouse exception in the
+// errors of the drivers, "code: 252, message: ...", and of the HTTP interface,
+// "Code: 252. DB::Exception: ..."
+var exceptionCodeRegexp = regexp.MustCompile(`\b[Cc]ode: (\d+)`)
+
+// retryableMessages are parts of the messages of the errors of connections
+// that are lost or time out, which the drivers do not always return as
+// net.Error, and of HTTP responses of proxies and load balancers
+var retryableMessages = []string{
+	"connection reset", "connection refused", "broken pipe", "i/o timeout", "EOF", "bad connection",
+	"502 Bad Gateway", "503 Service Unavailable", "504 Gateway Timeout",
+}
+
+// retryableError tells whether err, of a failed insert, may not happen again,
+// for the insert to be retried
+func retryableError(err error) bool {
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	message := err.Error()
+	if m := exceptionCodeRegexp.FindStringSubmatch(message); m != nil {
+		code, _ := strconv.Atoi(m[1])
+		return retryableCodes[code]
+	}
+	for _, s := range retryableMessages {
+		if strings.Contains(message, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryDelay returns the time to wait for before retry number attempt, from
+// 0, of a failed insert: -retry-backoff doubled on each attempt, of which a
+// random part up to half is taken off, for the workers failing at once not to
+// retry at once
+func retryDelay(attempt int) time.Duration {
+	if attempt > 20 {
+		attempt = 20
+	}
+	d := retryBackoff << uint(attempt)
+	if half := int64(d / 2); half > 0 {
+		d -= time.Duration(rand.Int63n(half + 1))
+	}
+	return d
+}
+
+// sleep waits between retries, replaced by tests
+var sleep = time.Sleep
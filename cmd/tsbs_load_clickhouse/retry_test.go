@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/timescale/tsbs/load"
+)
+
+func TestRetryableError(t *testing.T) {
+	cases := []struct {
+		desc string
+		err  error
+		want bool
+	}{
+		{desc: "too many parts", err: errors.New("code: 252, message: Too many parts (300). Merges are processing significantly slower than inserts"), want: true},
+		{desc: "too many parts over HTTP", err: errors.New("500 Internal Server Error: Code: 252. DB::Exception: Too many parts (300)"), want: true},
+		{desc: "wrapped timeout", err: fmt.Errorf("cannot insert 10 rows into cpu: %v", errors.New("code: 159, message: Timeout exceeded")), want: true},
+		{desc: "net.Error", err: &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}, want: true},
+		{desc: "connection reset", err: errors.New("cannot insert 10 rows into cpu: read tcp 127.0.0.1:9000: connection reset by peer"), want: true},
+		{desc: "EOF", err: errors.New("unexpected EOF"), want: true},
+		{desc: "unavailable", err: errors.New("503 Service Unavailable: "), want: true},
+		{desc: "unknown table", err: errors.New("code: 60, message: Table benchmark.cpu doesn't exist"), want: false},
+		{desc: "syntax error with EOF", err: errors.New("code: 62, message: Syntax error: failed at position 10 (end of query): EOF"), want: false},
+		{desc: "type mismatch", err: errors.New("cannot insert 10 rows into cpu: column 3: converting string to Float64 is unsupported"), want: false},
+	}
+	for _, c := range cases {
+		if got := retryableError(c.err); got != c.want {
+			t.Errorf("%s: incorrect retryable: got %v want %v", c.desc, got, c.want)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	oldRetryBackoff := retryBackoff
+	defer func() { retryBackoff = oldRetryBackoff }()
+	retryBackoff = 100 * time.Millisecond
+
+	for attempt, max := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond} {
+		for i := 0; i < 100; i++ {
+			if got := retryDelay(attempt); got < max/2 || got > max {
+				t.Fatalf("incorrect delay of attempt %d: got %v want between %v and %v", attempt, got, max/2, max)
+			}
+		}
+	}
+	// The delay stops doubling rather than overflow
+	if got := retryDelay(100); got <= 0 {
+		t.Errorf("incorrect delay of attempt 100: got %v want positive", got)
+	}
+}
+
+func TestProcessBatchRetries(t *testing.T) {
+	// The server fails the first failures inserts into each table with
+	// too many parts, then inserts them
+	var mutex sync.Mutex
+	failures := 0
+	attempts := make(map[string]int)
+	inserted := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		table := strings.Fields(r.URL.Query().Get("query"))[2]
+		mutex.Lock()
+		defer mutex.Unlock()
+		attempts[table]++
+		if attempts[table] <= failures || table == "fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Code: 252. DB::Exception: Too many parts (300)")
+			return
+		}
+		inserted[table] += strings.Count(string(body), "\n")
+	}))
+	defer server.Close()
+
+	oldHost, oldPort, oldMaxRetries, oldRetryBackoff := host, port, maxRetries, retryBackoff
+	defer func() {
+		host, port, maxRetries, retryBackoff, sleep = oldHost, oldPort, oldMaxRetries, oldRetryBackoff, time.Sleep
+	}()
+	serverURL, _ := url.Parse(server.URL)
+	host, port, _ = net.SplitHostPort(serverURL.Host)
+	var delays []time.Duration
+	sleep = func(d time.Duration) { delays = append(delays, d) }
+	maxRetries, retryBackoff = 3, time.Second
+	tableCols["tags"] = []string{"hostname"}
+	for _, table := range []string{"cpu", "fail"} {
+		tableCols[table], tableColTypes[table] = splitColumnSpecs([]string{"a", "b"})
+	}
+	c, err := newHTTPConn(serverAddress(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for _, f := range []int{0, 1, 3} {
+		failures, delays = f, nil
+		attempts, inserted = make(map[string]int), make(map[string]int)
+		p := &processor{http: c, csi: newSyncCSI()}
+		b := &tableArr{m: map[string][]*insertData{}}
+		for i := 0; i < 10; i++ {
+			row := &insertData{tags: fmt.Sprintf("hostname=host_%d", i), fields: fmt.Sprintf("%d,1,2", 1451606400+i)}
+			b.m["cpu"] = append(b.m["cpu"], row)
+		}
+		// The rows and metrics retried are only counted once
+		metricCnt, rowCnt := p.ProcessBatch(b, true)
+		if metricCnt != 20 || rowCnt != 10 {
+			t.Errorf("%d failures: incorrect counts: got %d metrics and %d rows want 20 and 10", f, metricCnt, rowCnt)
+		}
+		if inserted["tags"] != 10 || inserted["cpu"] != 10 {
+			t.Errorf("%d failures: incorrect rows inserted: got %v want 10 tags and cpu rows", f, inserted)
+		}
+		// The tags and cpu rows are each retried after 1s, 2s, 4s, less up
+		// to half at random
+		if len(delays) != 2*f {
+			t.Fatalf("%d failures: incorrect number of retries: got %d want %d", f, len(delays), 2*f)
+		}
+		for i, d := range delays {
+			if max := time.Second << uint(i%f); d < max/2 || d > max {
+				t.Errorf("%d failures: incorrect delay of retry %d: got %v want between %v and %v", f, i, d, max/2, max)
+			}
+		}
+	}
+
+	// The batch fails once the retries of an insert are exhausted
+	failures, delays = 0, nil
+	p := &processor{http: c, csi: newSyncCSI()}
+	b := &tableArr{m: map[string][]*insertData{"fail": {{tags: "hostname=host_0", fields: "1451606400,1,2"}}}}
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("unexpected lack of failure after %d retries", maxRetries)
+			}
+		}()
+		p.ProcessBatch(b, true)
+	}()
+	if attempts["fail"] != maxRetries+1 {
+		t.Errorf("incorrect number of attempts: got %d want %d", attempts["fail"], maxRetries+1)
+	}
+
+	// The batch is then left with the rows of the table failing only, to be
+	// loaded again by the loader
+	inserted = make(map[string]int)
+	b = &tableArr{m: map[string][]*insertData{
+		"cpu":  {{tags: "hostname=host_0", fields: "1451606400,1,2"}},
+		"fail": {{tags: "hostname=host_0", fields: "1451606400,1,2"}},
+	}, cnt: 2}
+	metricCnt, rowCnt, err := p.ProcessBatchWithError(b, true)
+	if _, ok := err.(*load.RetryableError); !ok {
+		t.Errorf("incorrect error: got %v want a retryable one", err)
+	}
+	if metricCnt != 2 || rowCnt != 1 || inserted["cpu"] != 1 {
+		t.Errorf("incorrect counts: got %d metrics and %d rows, %d cpu rows inserted want 2, 1 and 1", metricCnt, rowCnt, inserted["cpu"])
+	}
+	if _, ok := b.m["cpu"]; ok || len(b.m["fail"]) != 1 || b.Len() != 1 {
+		t.Errorf("incorrect batch left to retry: got %v of %d rows want the fail row only", b.m, b.Len())
+	}
+
+	// The rows held back with -batch-per-table are not retried
+	p.pending = &tableArr{m: map[string][]*insertData{}}
+	b = &tableArr{m: map[string][]*insertData{"fail": {{tags: "hostname=host_0", fields: "1451606400,1,2"}}}, cnt: 1}
+	oldTableBatchSize := tableBatchSize
+	defer func() { tableBatchSize = oldTableBatchSize }()
+	tableBatchSize = 1
+	if _, _, err := p.ProcessBatchWithError(b, true); err == nil {
+		t.Errorf("unexpected lack of error with -batch-per-table")
+	} else if _, ok := err.(*load.RetryableError); ok {
+		t.Errorf("incorrect error with -batch-per-table: got a retryable one want another")
+	}
+}
@@ -2,24 +2,65 @@ package main
 
 import (
 	"bufio"
-	"hash/fnv"
+	"fmt"
+	"io"
+	"log"
+	"math"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/timescale/tsbs/cmd/tsbs_generate_data/serialize"
 	"github.com/timescale/tsbs/load"
 )
 
-// hostnameIndexer is used to consistently send the same hostnames to the same queue
-type hostnameIndexer struct {
-	partitions uint
+// Functions hashing the hostnames of the points to workers with -hash-workers
+const (
+	// hashFNV takes the FNV-1a hash of the hostname modulo the number of
+	// workers
+	hashFNV = "fnv"
+	// hashJump takes the jump consistent hash of the hostname, which moves
+	// the fewest hostnames when the number of workers changes
+	hashJump = "jump"
+)
+
+// newHostnameIndexer returns the load.KeyHashIndexer consistently sending the
+// points of the same hostname, their first tag, to the same queue, with the
+// jump consistent hash if jump is set
+func newHostnameIndexer(maxPartitions uint, jump bool) *load.KeyHashIndexer {
+	i := load.NewStringKeyHashIndexer(pointHostname, maxPartitions)
+	i.Jump = jump
+	return i
+}
+
+// pointHostname returns the hostname tag of the point p, e.g.,
+// "hostname=host_0", the key of the indexer of -hash-workers
+func pointHostname(p *load.Point) string {
+	hostname, _ := splitPrefix(p.Data.(*point).row.tags)
+	return hostname
 }
 
-// scan.PointIndexer interface implementation
-func (i *hostnameIndexer) GetIndex(item *load.Point) int {
-	p := item.Data.(*point)
-	hostname := strings.SplitN(p.row.tags, ",", 2)[0]
-	h := fnv.New32a()
-	h.Write([]byte(hostname))
-	return int(h.Sum32()) % int(i.partitions)
+// balance describes the numbers of points sent to each partition, and how many
+// times as many points the fullest partition got as the emptiest did
+func balance(counts []uint64) string {
+	var b strings.Builder
+	min, max := uint64(math.MaxUint64), uint64(0)
+	for idx, n := range counts {
+		if idx > 0 {
+			b.WriteString(" ")
+		}
+		fmt.Fprintf(&b, "%d", n)
+		if n < min {
+			min = n
+		}
+		if n > max {
+			max = n
+		}
+	}
+	if min > 0 {
+		fmt.Fprintf(&b, " (max/min %.2f)", float64(max)/float64(min))
+	}
+	return b.String()
 }
 
 // Point is a single row of data keyed by which table it belongs
@@ -31,10 +72,27 @@ type point struct {
 	row   *insertData
 }
 
+// load.Timestamped interface implementation, for -replay-speed: the time of
+// the first of the fields, the zero Time if it is not a timestamp, for the row
+// to fail to be inserted rather than to be paced
+func (p *point) Timestamp() time.Time {
+	v, _ := splitPrefix(p.row.fields)
+	ts, err := parseTimestamp(v, timestampUnit)
+	if err != nil {
+		return time.Time{}
+	}
+	return ts
+}
+
 // scan.Batch interface implementation
 type tableArr struct {
 	m   map[string][]*insertData
 	cnt int
+	// bytes is the size of the lines of the rows in the input
+	bytes int
+	// spare holds the emptied slices of the tables of the batch once
+	// processed, to be appended to again (see recycle)
+	spare map[string][]*insertData
 }
 
 // scan.Batch interface implementation
@@ -42,12 +100,106 @@ func (ta *tableArr) Len() int {
 	return ta.cnt
 }
 
+// load.ByteLenner interface implementation
+func (ta *tableArr) ByteLen() int {
+	return ta.bytes
+}
+
 // scan.Batch interface implementation
 func (ta *tableArr) Append(item *load.Point) {
 	that := item.Data.(*point)
 	k := that.table
-	ta.m[k] = append(ta.m[k], that.row)
+	rows, ok := ta.m[k]
+	if !ok {
+		rows = ta.spare[k]
+	}
+	ta.m[k] = append(rows, that.row)
 	ta.cnt++
+	ta.bytes += rowBytes(k, that.row)
+}
+
+// load.BatchCounter interface implementation, for -dry-run. The rows and their
+// metrics are counted as buildRows counts those it inserts: missing values are
+// not metrics, and rows with any are not rows if skipped per emptyFields.
+func (ta *tableArr) Counts() (metricCount, rowCount uint64) {
+	for table, rows := range ta.m {
+		colTypes := tableColTypes[table]
+		for _, row := range rows {
+			values, empty := countValues(row.fields, colTypes)
+			if empty > 0 && !nullableFields && emptyFields == emptyFieldsSkipRow {
+				continue
+			}
+			metricCount += uint64(values)
+			rowCount++
+		}
+	}
+	return metricCount, rowCount
+}
+
+// countValues returns the numbers of values of fields, a line of values
+// following its timestamp, and of missing ones, of columns of colTypes
+func countValues(fields string, colTypes []string) (values, empty int) {
+	for i, v := range serialize.SplitTextFields(fields, ',')[1:] {
+		colType := columnTypeFloat64
+		if i < len(colTypes) {
+			colType = colTypes[i]
+		}
+		if len(v) == 0 && colType != columnTypeString {
+			empty++
+		} else {
+			values++
+		}
+	}
+	return values, empty
+}
+
+// rowBytes returns the size of the lines of row, of table, in the input: its
+// tags line and its line of values, each with its prefix and newline
+func rowBytes(table string, row *insertData) int {
+	return len(tagsPrefix) + len(row.tags) + len(table) + len(row.fields) + 4
+}
+
+// moveTo appends the rows of ta to those of dst, emptying ta
+func (ta *tableArr) moveTo(dst *tableArr) {
+	if ta.spare == nil {
+		ta.spare = map[string][]*insertData{}
+	}
+	for table, rows := range ta.m {
+		dstRows, ok := dst.m[table]
+		if !ok {
+			dstRows = dst.spare[table]
+		}
+		dst.m[table] = append(dstRows, rows...)
+		for i := range rows {
+			rows[i] = nil
+		}
+		ta.spare[table] = rows[:0]
+		delete(ta.m, table)
+	}
+	dst.cnt += ta.cnt
+	dst.bytes += ta.bytes
+	ta.cnt, ta.bytes = 0, 0
+}
+
+// takeFull takes the rows of the tables of ta having at least n of them, as a
+// batch of their own
+func (ta *tableArr) takeFull(n int) *tableArr {
+	full := tableArrPool.Get().(*tableArr)
+	for table, rows := range ta.m {
+		if len(rows) < n {
+			continue
+		}
+		full.m[table] = rows
+		full.cnt += len(rows)
+		ta.cnt -= len(rows)
+		for _, row := range rows {
+			n := rowBytes(table, row)
+			full.bytes += n
+			ta.bytes -= n
+		}
+		delete(ta.m, table)
+	}
+	return full
 }
 
 // scan.BatchFactory interface implementation
@@ -55,59 +207,196 @@ type factory struct{}
 
 // scan.BatchFactory interface implementation
 func (f *factory) New() load.Batch {
-	return &tableArr{
-		m:   map[string][]*insertData{},
-		cnt: 0,
+	return tableArrPool.Get().(*tableArr)
+}
+
+// tableArrPool holds the batches processed, to be filled again, their maps
+// and slices with them
+var tableArrPool = sync.Pool{
+	New: func() interface{} {
+		return &tableArr{m: map[string][]*insertData{}}
+	},
+}
+
+// insertDataPool holds the rows of the batches processed, to be decoded into
+// again
+var insertDataPool = sync.Pool{
+	New: func() interface{} { return &insertData{} },
+}
+
+// newInsertData returns a row to decode into, which is recycled once its
+// batch is processed
+func newInsertData() *insertData {
+	data := insertDataPool.Get().(*insertData)
+	data.pooled = true
+	return data
+}
+
+// recycle empties ta, putting its rows decoded by newInsertData back into
+// insertDataPool and itself into tableArrPool, once its batch is processed.
+// Its rows are then no longer referred to: the values of the rows inserted
+// are strings of their own, or parsed from them.
+func (ta *tableArr) recycle() {
+	for table := range ta.m {
+		ta.remove(table)
+	}
+	ta.cnt, ta.bytes = 0, 0
+	tableArrPool.Put(ta)
+}
+
+// remove takes the rows of table out of ta, putting those decoded by
+// newInsertData back into insertDataPool, e.g., once they are inserted
+func (ta *tableArr) remove(table string) {
+	if ta.spare == nil {
+		ta.spare = map[string][]*insertData{}
 	}
+	rows := ta.m[table]
+	for i, row := range rows {
+		ta.bytes -= rowBytes(table, row)
+		if row.pooled {
+			*row = insertData{}
+			insertDataPool.Put(row)
+		}
+		rows[i] = nil
+	}
+	ta.cnt -= len(rows)
+	ta.spare[table] = rows[:0]
+	delete(ta.m, table)
 }
 
 // scan.PointDecoder interface implementation
 type decoder struct {
 	scanner *bufio.Scanner
+	// tableCols, if set, are the columns of each table, which every row
+	// is checked against
+	tableCols map[string][]string
+	// headerTables, if set, are the tables of the data header, which the
+	// table of every row must be one of
+	headerTables map[string]bool
+	// line is the number of the last line of the input scanned, the header
+	// included
+	line int
+	// checksum, if set, sums up the points decoded so far, to be checked
+	// against wantChecksum once all of them are
+	checksum     *serialize.Checksum
+	wantChecksum *serialize.Checksum
+	// buf holds the lines of the point being decoded
+	buf []byte
+	// held is set if the line last scanned is not decoded yet, but is the
+	// first line of the next point
+	held bool
 }
 
 const tagsPrefix = "tags"
 
+// splitPrefix splits line into its prefix, up to the first comma, and the rest
+// of it, without allocating
+func splitPrefix(line string) (prefix, rest string) {
+	i := strings.IndexByte(line, ',')
+	if i < 0 {
+		return line, ""
+	}
+	return line[:i], line[i+1:]
+}
+
+// scanLine scans the next line, unless the line last scanned is held for the
+// next point. The load fails if the input cannot be read.
+func (d *decoder) scanLine() bool {
+	if d.held {
+		d.held = false
+		return true
+	}
+	if !d.scanner.Scan() {
+		if err := d.scanner.Err(); err != nil {
+			fatal("line %d: scan error: %v", d.line+1, err)
+		}
+		return false
+	}
+	d.line++
+	return true
+}
+
 // scan.PointDecoder interface implementation
-func (d *decoder) Decode(_ *bufio.Reader) *load.Point {
+func (d *decoder) Decode(br *bufio.Reader) *load.Point {
+	p, err := d.DecodeWithError(br)
+	if err != nil && err != io.EOF {
+		fatal("%v", err)
+		return nil
+	}
+	return p
+}
+
+// load.PointDecoderWithError interface implementation. Lines that cannot be
+// decoded are skipped up to the next tags line, for the points following them
+// to be decoded.
+func (d *decoder) DecodeWithError(_ *bufio.Reader) (*load.Point, error) {
 	// Data Point Example
 	// tags,hostname=host_0,region=eu-west-1,datacenter=eu-west-1b,rack=67,os=Ubuntu16.10,arch=x86,team=NYC,service=7,service_version=0,service_environment=production
 	// cpu,1451606400000000000,58,2,24,61,22,63,6,44,80,38
 
-	data := &insertData{}
-	ok := d.scanner.Scan()
-	if !ok && d.scanner.Err() == nil {
+	if !d.scanLine() {
 		// nothing scanned & no error = EOF
-		return nil
-	} else if !ok {
-		fatal("scan error: %v", d.scanner.Err())
-		return nil
+		if d.checksum != nil {
+			if err := d.checksum.Check(d.wantChecksum); err != nil {
+				fatal("data does not match checksum: %v", err)
+				return nil, io.EOF
+			}
+			log.Printf("data matches checksum: %d bytes", d.checksum.Bytes)
+		}
+		return nil, io.EOF
 	}
 
 	// The first line is a CSV line of tags with the first element being "tags"
 	// Ex.:
 	// tags,hostname=host_0,region=eu-west-1,datacenter=eu-west-1b,rack=67,os=Ubuntu16.10,arch=x86,team=NYC,service=7,service_version=0,service_environment=production
-	parts := strings.SplitN(d.scanner.Text(), ",", 2) // prefix & then rest of line
-	prefix := parts[0]
-	if prefix != tagsPrefix {
-		fatal("data file in invalid format; got %s expected %s", prefix, tagsPrefix)
-		return nil
+	// Both lines are copied into buf, the scanner reusing its own, to be made
+	// a single string
+	d.buf = append(d.buf[:0], d.scanner.Bytes()...)
+	d.buf = append(d.buf, '\n')
+	tagsLen := len(d.buf)
+	if prefix, _ := splitPrefix(string(d.buf[:tagsLen-1])); prefix != tagsPrefix {
+		return nil, fmt.Errorf("line %d: data file in invalid format; got %s expected %s", d.line, prefix, tagsPrefix)
 	}
-	data.tags = parts[1]
 
 	// Scan again to get the data line
 	// cpu,1451606400000000000,58,2,24,61,22,63,6,44,80,38
-	ok = d.scanner.Scan()
-	if !ok {
-		fatal("scan error: %v", d.scanner.Err())
-		return nil
+	if !d.scanLine() {
+		return nil, fmt.Errorf("line %d: tags without the data line following them at the end of the input", d.line)
+	}
+	d.buf = append(d.buf, d.scanner.Bytes()...)
+	d.buf = append(d.buf, '\n')
+	lines := string(d.buf)
+	tagsLine, fieldsLine := lines[:tagsLen-1], lines[tagsLen:len(lines)-1]
+
+	_, tags := splitPrefix(tagsLine)
+	prefix, fields := splitPrefix(fieldsLine)
+	// If the data line is missing, the tags are those of the next point
+	d.held = prefix == tagsPrefix
+
+	if d.checksum != nil {
+		d.checksum.AddPoint(prefix, d.buf)
+	}
+
+	if d.tableCols != nil {
+		cols, ok := d.tableCols[prefix]
+		if !ok {
+			return nil, fmt.Errorf("line %d: data has table %s, which the schema does not describe", d.line, prefix)
+		}
+		// A timestamp followed by a value per column
+		if got := strings.Count(fields, ","); got != len(cols) {
+			return nil, fmt.Errorf("line %d: data has %d values for table %s, whose schema has %d columns", d.line, got, prefix, len(cols))
+		}
+	} else if d.headerTables != nil && !d.headerTables[prefix] {
+		return nil, fmt.Errorf("line %d: table %s is not described by the data header: '%s'", d.line, prefix, fieldsLine)
+	}
+	if d.held {
+		return nil, fmt.Errorf("line %d: tags not followed by a data line: '%s'", d.line-1, tagsLine)
 	}
-	parts = strings.SplitN(d.scanner.Text(), ",", 2) // prefix & then rest of line
-	prefix = parts[0]
-	data.fields = parts[1]
 
+	data := newInsertData()
+	data.tags, data.fields = tags, fields
 	return load.NewPoint(&point{
 		table: prefix,
 		row:   data,
-	})
+	}), nil
 }
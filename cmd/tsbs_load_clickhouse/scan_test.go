@@ -3,10 +3,19 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
 	"log"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/timescale/tsbs/cmd/tsbs_generate_data/serialize"
 	"github.com/timescale/tsbs/load"
 )
 
@@ -45,6 +54,81 @@ func TestHypertableArr(t *testing.T) {
 	if len(ha.m) != 2 {
 		t.Errorf("tableArr does not have 2 different hypertables")
 	}
+	// The size of the rows is that of their lines in the input,
+	// "tags,t1,t2\ntable1,0,f1,f2\n" and "tags,t3,t4\ntable2,1,f3,f4\n"
+	if got, want := ha.ByteLen(), 2*len("tags,t1,t2\ntable1,0,f1,f2\n"); got != want {
+		t.Errorf("incorrect byte length: got %d want %d", got, want)
+	}
+}
+
+func TestHostnameIndexer(t *testing.T) {
+	hostPoint := func(host int, tags string) *load.Point {
+		return &load.Point{Data: &point{table: "cpu", row: &insertData{tags: fmt.Sprintf("hostname=host_%d%s", host, tags)}}}
+	}
+	for _, jump := range []bool{false, true} {
+		// The points of a hostname go to the same partition whatever their
+		// other tags, and hostnames spread evenly
+		i := newHostnameIndexer(8, jump)
+		for host := 0; host < 10000; host++ {
+			idx := i.GetIndex(hostPoint(host, ",region=eu-west-1"))
+			if got := i.GetIndex(hostPoint(host, ",region=us-east-1,os=Ubuntu16.10")); got != idx {
+				t.Errorf("jump %v: host_%d sent to partitions %d and %d", jump, host, idx, got)
+			}
+			if got := i.GetIndex(hostPoint(host, "")); got != idx {
+				t.Errorf("jump %v: host_%d without other tags sent to partitions %d and %d", jump, host, idx, got)
+			}
+		}
+		counts := i.Counts()
+		min, max := counts[0], counts[0]
+		total := uint64(0)
+		for _, n := range counts {
+			if n < min {
+				min = n
+			}
+			if n > max {
+				max = n
+			}
+			total += n
+		}
+		if total != 30000 {
+			t.Errorf("jump %v: incorrect total count: got %d want 30000", jump, total)
+		}
+		if float64(max) > 1.1*float64(min) {
+			t.Errorf("jump %v: uneven partitions: got %s", jump, balance(counts))
+		}
+
+		// Indexing does not allocate
+		p := hostPoint(0, ",region=eu-west-1")
+		if allocs := testing.AllocsPerRun(100, func() { i.GetIndex(p) }); allocs != 0 {
+			t.Errorf("jump %v: incorrect allocations: got %v want 0", jump, allocs)
+		}
+	}
+
+	// The hostnames go to the partitions published by the load package, those
+	// of the FNV-1a hash of hash/fnv modulo the partitions as before
+	for host := 0; host < 1000; host++ {
+		hostname := fmt.Sprintf("hostname=host_%d", host)
+		h := fnv.New32a()
+		h.Write([]byte(hostname))
+		for _, jump := range []bool{false, true} {
+			want := int(h.Sum32() % 8)
+			if jump {
+				want = load.KeyJumpPartition([]byte(hostname), 8)
+			}
+			if got := newHostnameIndexer(8, jump).GetIndex(hostPoint(host, ",region=eu-west-1")); got != want {
+				t.Errorf("jump %v: host_%d sent to partition %d want %d", jump, host, got, want)
+			}
+		}
+	}
+}
+
+func TestHostnameIndexerBalance(t *testing.T) {
+	if got, want := balance([]uint64{100, 120, 80}), "100 120 80 (max/min 1.50)"; got != want {
+		t.Errorf("incorrect balance: got %s want %s", got, want)
+	}
+	if got, want := balance([]uint64{10, 0, 5}), "10 0 5"; got != want {
+		t.Errorf("incorrect balance: got %s want %s", got, want)
+	}
 }
 
 func TestDecode(t *testing.T) {
@@ -104,6 +188,207 @@ func TestDecode(t *testing.T) {
 	}
 }
 
+func TestDecodeCheckTableCols(t *testing.T) {
+	tableCols := map[string][]string{"cpu": {"usage_user", "usage_system"}}
+	cases := []struct {
+		desc        string
+		input       string
+		shouldFatal bool
+	}{
+		{
+			desc:  "matching values",
+			input: "tags,tag1text,tag2text\ncpu,140,0.0,0.0\n",
+		},
+		{
+			desc:        "too few values",
+			input:       "tags,tag1text,tag2text\ncpu,140,0.0\n",
+			shouldFatal: true,
+		},
+		{
+			desc:        "too many values",
+			input:       "tags,tag1text,tag2text\ncpu,140,0.0,0.0,0.0\n",
+			shouldFatal: true,
+		},
+		{
+			desc:        "unknown table",
+			input:       "tags,tag1text,tag2text\nmem,140,0.0,0.0\n",
+			shouldFatal: true,
+		},
+	}
+	defer func() { fatal = log.Fatalf }()
+	for _, c := range cases {
+		br := bufio.NewReader(bytes.NewReader([]byte(c.input)))
+		decoder := &decoder{scanner: bufio.NewScanner(br), tableCols: tableCols}
+		isCalled := false
+		fatal = func(fmt string, args ...interface{}) {
+			isCalled = true
+			log.Printf(fmt, args...)
+		}
+		p := decoder.Decode(br)
+		if c.shouldFatal && !isCalled {
+			t.Errorf("%s: did not call fatal when it should", c.desc)
+		} else if !c.shouldFatal && (isCalled || p == nil) {
+			t.Errorf("%s: unexpected failure to decode", c.desc)
+		}
+	}
+}
+
+func TestDecodeCheckHeaderTables(t *testing.T) {
+	headerTables := map[string]bool{"cpu": true, "tags": false}
+	cases := []struct {
+		desc      string
+		input     string
+		wantFatal string
+	}{
+		{
+			desc:  "tables of the header",
+			input: "tags,tag1text\ncpu,140,0.0\ntags,tag1text\ncpu,150,1.0\n",
+		},
+		{
+			desc:      "table not in the header",
+			input:     "tags,tag1text\ncpu,140,0.0\ntags,tag1text\nmem,150,1.0\n",
+			wantFatal: "line 8: table mem is not described by the data header: 'mem,150,1.0'",
+		},
+		{
+			desc:      "tags as table",
+			input:     "tags,tag1text\ntags,tag2text\n",
+			wantFatal: "line 6: table tags is not described by the data header: 'tags,tag2text'",
+		},
+		{
+			desc:      "tags line missing",
+			input:     "tags,tag1text\ncpu,140,0.0\ncpu,150,1.0\ncpu,160,2.0\n",
+			wantFatal: "line 7: data file in invalid format; got cpu expected tags",
+		},
+	}
+	defer func() { fatal = log.Fatalf }()
+	for _, c := range cases {
+		br := bufio.NewReader(strings.NewReader(c.input))
+		// The data follows a header of 4 lines
+		decoder := &decoder{scanner: bufio.NewScanner(br), headerTables: headerTables, line: 4}
+		var fatalMessage string
+		fatal = func(format string, args ...interface{}) {
+			fatalMessage = fmt.Sprintf(format, args...)
+		}
+		for decoder.Decode(br) != nil {
+		}
+		if fatalMessage != c.wantFatal {
+			t.Errorf("%s: incorrect fatal message: got '%s' want '%s'", c.desc, fatalMessage, c.wantFatal)
+		}
+	}
+}
+
+func TestDecodeCorruptedInput(t *testing.T) {
+	tableCols := map[string][]string{"cpu": {"usage_user", "usage_system"}}
+	input := strings.Join([]string{
+		"tags,host_0",
+		"cpu,1,0.0,0.0",
+		"cpu,2,0.0,0.0",
+		"tags,host_1",
+		"cpu,3,0.0,0.0",
+		"tags,host_2",
+		"tags,host_3",
+		"cpu,4,0.0,0.0",
+		"tags,host_4",
+		"disk,5,0.0,0.0",
+		"tags,host_5",
+		"cpu,6,0.0,0.0",
+		"tags,host_6",
+	}, "\n") + "\n"
+	wantRows := []string{"host_0 1,0.0,0.0", "host_1 3,0.0,0.0", "host_3 4,0.0,0.0", "host_5 6,0.0,0.0"}
+	wantErrors := []string{
+		"line 3: data file in invalid format; got cpu expected tags",
+		"line 7: data has table tags, which the schema does not describe",
+		"line 10: data has table disk, which the schema does not describe",
+		"line 13: tags without the data line following them at the end of the input",
+	}
+
+	// The points following the lines that cannot be decoded are decoded, for
+	// them to be skipped
+	br := bufio.NewReader(strings.NewReader(input))
+	skipDecoder := &decoder{scanner: bufio.NewScanner(br), tableCols: tableCols}
+	var rows, errors []string
+	for {
+		p, err := skipDecoder.DecodeWithError(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+		data := p.Data.(*point)
+		rows = append(rows, data.row.tags+" "+data.row.fields)
+	}
+	if !reflect.DeepEqual(rows, wantRows) {
+		t.Errorf("incorrect rows: got %v want %v", rows, wantRows)
+	}
+	if !reflect.DeepEqual(errors, wantErrors) {
+		t.Errorf("incorrect errors: got %v want %v", errors, wantErrors)
+	}
+
+	// Or the load is aborted on the first of them
+	defer func() { fatal = log.Fatalf }()
+	var fatalMessages []string
+	fatal = func(format string, args ...interface{}) {
+		fatalMessages = append(fatalMessages, fmt.Sprintf(format, args...))
+	}
+	br = bufio.NewReader(strings.NewReader(input))
+	abortDecoder := &decoder{scanner: bufio.NewScanner(br), tableCols: tableCols}
+	for abortDecoder.Decode(br) != nil {
+	}
+	if !reflect.DeepEqual(fatalMessages, wantErrors[:1]) {
+		t.Errorf("incorrect fatal messages: got %v want %v", fatalMessages, wantErrors[:1])
+	}
+}
+
+func TestDecodeVerifyChecksum(t *testing.T) {
+	input := "tags,tag1text,tag2text\ncpu,140,0.0,0.0\ntags,tag1text,tag2text\nmem,140,1.0\n"
+	want := serialize.NewChecksum(0, 1)
+	want.AddPoint("cpu", []byte("tags,tag1text,tag2text\ncpu,140,0.0,0.0\n"))
+	want.AddPoint("mem", []byte("tags,tag1text,tag2text\nmem,140,1.0\n"))
+	cases := []struct {
+		desc        string
+		input       string
+		shouldFatal bool
+	}{
+		{
+			desc:  "matching data",
+			input: input,
+		},
+		{
+			desc:        "changed value",
+			input:       strings.Replace(input, "1.0", "2.0", 1),
+			shouldFatal: true,
+		},
+		{
+			desc:        "missing point",
+			input:       "tags,tag1text,tag2text\ncpu,140,0.0,0.0\n",
+			shouldFatal: true,
+		},
+	}
+	defer func() { fatal = log.Fatalf }()
+	for _, c := range cases {
+		br := bufio.NewReader(bytes.NewReader([]byte(c.input)))
+		decoder := &decoder{
+			scanner:      bufio.NewScanner(br),
+			checksum:     serialize.NewChecksum(0, 1),
+			wantChecksum: want,
+		}
+		isCalled := false
+		fatal = func(fmt string, args ...interface{}) {
+			isCalled = true
+			log.Printf(fmt, args...)
+		}
+		for decoder.Decode(br) != nil {
+		}
+		if c.shouldFatal && !isCalled {
+			t.Errorf("%s: did not call fatal when it should", c.desc)
+		} else if !c.shouldFatal && isCalled {
+			t.Errorf("%s: called fatal when it should not", c.desc)
+		}
+	}
+}
+
 func TestDecodeEOF(t *testing.T) {
 	input := []byte("tags,tag1text,tag2text\ncpu,140,0.0,0.0\n")
 	br := bufio.NewReader(bytes.NewReader([]byte(input)))
@@ -115,3 +400,226 @@ func TestDecodeEOF(t *testing.T) {
 		t.Errorf("expected p to be nil, got %v", p)
 	}
 }
+
+func TestDecodePooledRows(t *testing.T) {
+	input := "tags,hostname=host_0\ncpu,140,1.0\ntags,hostname=host_1\ncpu,150,2.0\ntags,hostname=host_2\ncpu,160,3.0\n"
+	br := bufio.NewReader(bytes.NewReader([]byte(input)))
+	decoder := &decoder{scanner: bufio.NewScanner(br)}
+	f := &factory{}
+
+	// The rows of a batch not yet processed are neither reused nor changed by
+	// the points decoded next
+	b := f.New().(*tableArr)
+	b.Append(decoder.Decode(br))
+	b.Append(decoder.Decode(br))
+	next := decoder.Decode(br).Data.(*point).row
+	rows := b.m["cpu"]
+	for i, row := range rows {
+		if row == next {
+			t.Errorf("row %d of the batch reused by the point decoded next", i)
+		}
+		if want := fmt.Sprintf("hostname=host_%d", i); row.tags != want {
+			t.Errorf("incorrect tags of row %d: got %s want %s", i, row.tags, want)
+		}
+		if want := fmt.Sprintf("%d,%d.0", 140+10*i, i+1); row.fields != want {
+			t.Errorf("incorrect fields of row %d: got %s want %s", i, row.fields, want)
+		}
+	}
+
+	// Once processed, the batch is emptied and its table keeps its slice
+	p := &processor{}
+	if _, rowCnt := p.ProcessBatch(b, false); rowCnt != 2 {
+		t.Errorf("incorrect row count: got %d want 2", rowCnt)
+	}
+	if b.Len() != 0 || len(b.m) != 0 {
+		t.Errorf("batch not emptied once processed: got %d rows of %d tables", b.Len(), len(b.m))
+	}
+	if spare := b.spare["cpu"]; len(spare) != 0 || cap(spare) < 2 {
+		t.Errorf("incorrect spare slice of cpu: got len %d cap %d want len 0 cap at least 2", len(spare), cap(spare))
+	}
+	b.Append(&load.Point{Data: &point{table: "cpu", row: next}})
+	if b.Len() != 1 || len(b.m["cpu"]) != 1 || b.m["cpu"][0] != next {
+		t.Errorf("incorrect batch appended to once processed: got %v", b.m)
+	}
+	// The batch is back in the pool, so is emptied for the batches of the
+	// tests that follow
+	b.spare["cpu"] = b.m["cpu"][:0]
+	delete(b.m, "cpu")
+	b.cnt, b.bytes = 0, 0
+}
+
+func TestDecodeCompressedInput(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("tags,hostname,region\ncpu,usage_user,usage_system\nmem,used,free\n\n")
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&buf, "tags,hostname=host_%d,region=eu-west-1\n", i%10)
+		fmt.Fprintf(&buf, "cpu,%d,58,2\n", 1451606400000000000+int64(i)*10e9)
+		fmt.Fprintf(&buf, "tags,hostname=host_%d,region=eu-west-1\n", i%10)
+		fmt.Fprintf(&buf, "mem,%d,%d,1024\n", 1451606400000000000+int64(i)*10e9, i)
+	}
+	var gzipped bytes.Buffer
+	w := gzip.NewWriter(&gzipped)
+	w.Write(buf.Bytes())
+	w.Close()
+
+	// batches decodes the file fileName into batches of 30 rows, described by
+	// the tags and fields of their rows by table
+	batches := func(desc string, data []byte) []map[string][]string {
+		f, err := ioutil.TempFile("", "tsbs-clickhouse")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		f.Write(data)
+		f.Close()
+		br, err := load.OpenInput(f.Name())
+		if err != nil {
+			t.Fatalf("%s: cannot open input: %v", desc, err)
+		}
+		if _, _, err := parseDataHeader(br); err != nil {
+			t.Fatalf("%s: incorrect header: %v", desc, err)
+		}
+		decoder := &decoder{scanner: bufio.NewScanner(br)}
+		fac := &factory{}
+		var got []map[string][]string
+		b := fac.New().(*tableArr)
+		for item := decoder.Decode(br); ; item = decoder.Decode(br) {
+			if item != nil {
+				b.Append(item)
+			}
+			if b.Len() == 30 || (item == nil && b.Len() > 0) {
+				rows := make(map[string][]string)
+				for table, data := range b.m {
+					for _, row := range data {
+						rows[table] = append(rows[table], row.tags+" "+row.fields)
+					}
+				}
+				got = append(got, rows)
+				b = fac.New().(*tableArr)
+			}
+			if item == nil {
+				return got
+			}
+		}
+	}
+
+	want := batches("uncompressed", buf.Bytes())
+	if len(want) != 7 {
+		t.Fatalf("incorrect number of batches: got %d want 7", len(want))
+	}
+	if got := batches("gzip-compressed", gzipped.Bytes()); !reflect.DeepEqual(got, want) {
+		t.Errorf("batches of the gzip-compressed input differ from those uncompressed:\ngot %v\nwant %v", got, want)
+	}
+}
+
+func TestDryRunCounts(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("tags,hostname\ncpu,usage_user,usage_system\nmem,used,free\n\n")
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(&buf, "tags,hostname=host_%d\ncpu,%d,58,2\n", i%10, 1451606400000000000+int64(i)*10e9)
+		// Every 5th mem row misses a value
+		used := fmt.Sprint(i)
+		if i%5 == 0 {
+			used = ""
+		}
+		fmt.Fprintf(&buf, "tags,hostname=host_%d\nmem,%d,%s,1024\n", i%10, 1451606400000000000+int64(i)*10e9, used)
+	}
+	f, err := ioutil.TempFile("", "tsbs-clickhouse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write(buf.Bytes())
+	f.Close()
+
+	oldCols, oldTypes, oldNullable, oldEmpty := tableCols, tableColTypes, nullableFields, emptyFields
+	defer func() {
+		tableCols, tableColTypes, nullableFields, emptyFields = oldCols, oldTypes, oldNullable, oldEmpty
+	}()
+	tableCols, tableColTypes, nullableFields = map[string][]string{}, map[string][]string{}, false
+	cases := []struct {
+		empty       string
+		wantMetrics uint64
+		wantRows    uint64
+	}{
+		{
+			empty:       emptyFieldsZero,
+			wantMetrics: 50*2 + 40*2 + 10,
+			wantRows:    100,
+		},
+		{
+			empty:       emptyFieldsSkipRow,
+			wantMetrics: 50*2 + 40*2,
+			wantRows:    90,
+		},
+	}
+	for _, c := range cases {
+		emptyFields = c.empty
+		br, err := load.OpenInput(f.Name())
+		if err != nil {
+			t.Fatalf("%s: cannot open input: %v", c.empty, err)
+		}
+		if _, _, err := parseDataHeader(br); err != nil {
+			t.Fatalf("%s: incorrect header: %v", c.empty, err)
+		}
+		decoder := &decoder{scanner: bufio.NewScanner(br)}
+		b := (&factory{}).New().(*tableArr)
+		for item := decoder.Decode(br); item != nil; item = decoder.Decode(br) {
+			b.Append(item)
+		}
+		metrics, rows := b.Counts()
+		if metrics != c.wantMetrics || rows != c.wantRows {
+			t.Errorf("%s: incorrect counts: got %d metrics and %d rows want %d and %d", c.empty, metrics, rows, c.wantMetrics, c.wantRows)
+		}
+		b.recycle()
+	}
+}
+
+func BenchmarkDecodeAndBatch(b *testing.B) {
+	var buf bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&buf, "tags,hostname=host_%d,region=eu-west-1,datacenter=eu-west-1b,rack=67,os=Ubuntu16.10\n", i%100)
+		fmt.Fprintf(&buf, "cpu,%d,58,2,24,61,22,63,6,44,80,38\n", 1451606400000000000+int64(i)*10e9)
+	}
+	input := buf.Bytes()
+	f := &factory{}
+	p := &processor{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		br := bufio.NewReader(bytes.NewReader(input))
+		decoder := &decoder{scanner: bufio.NewScanner(br)}
+		batch := f.New()
+		for item := decoder.Decode(br); item != nil; item = decoder.Decode(br) {
+			batch.Append(item)
+		}
+		p.ProcessBatch(batch, false)
+	}
+}
+
+func TestPointTimestamp(t *testing.T) {
+	// The unit of the timestamps is detected unless given
+	cases := []struct {
+		fields string
+		unit   time.Duration
+		want   time.Time
+	}{
+		{fields: "1451606400000000000,58,2", want: time.Unix(1451606400, 0)},
+		{fields: "1451606410000000000", want: time.Unix(1451606410, 0)},
+		{fields: "1451606400,58,2", unit: time.Second, want: time.Unix(1451606400, 0)},
+		{fields: "1451606400,58,2", want: time.Unix(1451606400, 0)},
+		{fields: "2016-01-01,58,2", want: time.Time{}},
+	}
+	oldTimestampUnit := timestampUnit
+	defer func() { timestampUnit = oldTimestampUnit }()
+	for _, c := range cases {
+		timestampUnit = c.unit
+		data := newInsertData()
+		data.fields = c.fields
+		p := &point{table: "cpu", row: data}
+		var ts load.Timestamped = p
+		if got := ts.Timestamp(); !got.Equal(c.want) {
+			t.Errorf("%s: incorrect timestamp: got %v want %v", c.fields, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+#!/bin/sh
+# Generates the CA and the server certificate for localhost of the TLS test
+# server into certs/
+set -e
+cd "$(dirname "$0")"
+mkdir -p certs
+cd certs
+openssl req -x509 -newkey rsa:2048 -nodes -days 365 -subj "/CN=tsbs test CA" \
+    -keyout ca.key -out ca.crt
+openssl req -newkey rsa:2048 -nodes -subj "/CN=localhost" \
+    -keyout server.key -out server.csr
+printf "subjectAltName=DNS:localhost,IP:127.0.0.1\n" > server.ext
+openssl x509 -req -days 365 -in server.csr -CA ca.crt -CAkey ca.key -CAcreateserial \
+    -extfile server.ext -out server.crt
+rm server.csr server.ext
+# The server runs as the clickhouse user
+chmod 644 server.key
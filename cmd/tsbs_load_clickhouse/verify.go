@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/timescale/tsbs/load"
+)
+
+// postLoadVerify is the -post-load-verify of the loader, for which the rows
+// of the tables are counted before the load and those inserted during it
+var postLoadVerify bool
+
+// insertedRows counts the rows inserted into each table, by the name of its
+// local table, and initialRows the rows the tables held before the load, e.g.,
+// when appending to them
+var insertedRows = struct {
+	sync.Mutex
+	m map[string]uint64
+}{m: make(map[string]uint64)}
+var initialRows map[string]uint64
+
+// addInsertedRows counts n rows inserted into table
+func addInsertedRows(table string, n int) {
+	insertedRows.Lock()
+	insertedRows.m[table] += uint64(n)
+	insertedRows.Unlock()
+}
+
+// verifiedTables returns the tables whose rows are checked after the load: the
+// metrics tables, or the single one, and the tags table unless the tags are
+// denormalized
+func verifiedTables() []string {
+	var tables []string
+	if singleTable {
+		tables = append(tables, singleTableName)
+	} else {
+		for table := range tableCols {
+			if table != tagsPrefix {
+				tables = append(tables, table)
+			}
+		}
+		sort.Strings(tables)
+	}
+	if !denormalizeTags {
+		tables = append(tables, tagsPrefix)
+	}
+	return tables
+}
+
+// countRows returns the rows of table, those of its Distributed table on a
+// cluster, over all of its shards
+func countRows(db schemaConn, table string) (uint64, error) {
+	if len(cluster) > 0 {
+		table += distTableSuffix
+	}
+	values, err := db.queryStrings(fmt.Sprintf("SELECT toString(count()) FROM %s", table))
+	if err != nil {
+		return 0, fmt.Errorf("cannot count the rows of %s: %v", table, err)
+	}
+	if len(values) != 1 {
+		return 0, fmt.Errorf("cannot count the rows of %s: got %d rows", table, len(values))
+	}
+	n, err := strconv.ParseUint(values[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot count the rows of %s: %v", table, err)
+	}
+	return n, nil
+}
+
+// readInitialRows sets initialRows to the rows of the verified tables of db
+// before the load
+func readInitialRows(db schemaConn) error {
+	initialRows = make(map[string]uint64)
+	for _, table := range verifiedTables() {
+		n, err := countRows(db, table)
+		if err != nil {
+			return err
+		}
+		initialRows[table] = n
+	}
+	return nil
+}
+
+// verifyRows checks that each verified table of db holds the rows inserted
+// into it on top of those it held before. Rows skipped for their empty values
+// with -empty-fields skip-row are not inserted, so not expected. Rows inserted
+// into Distributed tables are flushed to their shards first.
+func verifyRows(db schemaConn) ([]load.VerifyCheck, error) {
+	tables := verifiedTables()
+	if len(cluster) > 0 && insertTarget == insertTargetDistributed {
+		for _, table := range tables {
+			sql := fmt.Sprintf("SYSTEM FLUSH DISTRIBUTED %s%s%s", table, distTableSuffix, onCluster())
+			debugLog.printf(1, "%s", sql)
+			if _, err := db.Exec(sql); err != nil {
+				return nil, fmt.Errorf("cannot flush %s%s: %v", table, distTableSuffix, err)
+			}
+		}
+	}
+	insertedRows.Lock()
+	defer insertedRows.Unlock()
+	checks := make([]load.VerifyCheck, 0, len(tables))
+	for _, table := range tables {
+		n, err := countRows(db, table)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, load.VerifyCheck{Name: table, Want: initialRows[table] + insertedRows.m[table], Got: n})
+	}
+	return checks, nil
+}
+
+// load.Verifier interface implementation
+func (b *benchmark) Verify(_ string) ([]load.VerifyCheck, error) {
+	db := connect(true)
+	defer db.Close()
+	return verifyRows(db)
+}
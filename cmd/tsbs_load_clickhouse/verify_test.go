@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/timescale/tsbs/load"
+)
+
+// testCountingServer is the HTTP interface of a server counting the rows
+// inserted into each table and answering the counts of rows
+type testCountingServer struct {
+	mutex sync.Mutex
+	rows  map[string]uint64
+}
+
+func (s *testCountingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if query := r.URL.Query().Get("query"); strings.HasPrefix(query, "INSERT INTO ") {
+		table := strings.Fields(query)[2]
+		s.rows[table] += uint64(bytes.Count(body, []byte("\n")))
+		return
+	}
+	var table string
+	if _, err := fmt.Sscanf(string(body), "SELECT toString(count()) FROM %s FORMAT TabSeparatedRaw", &table); err != nil {
+		http.Error(w, "unexpected query: "+string(body), http.StatusBadRequest)
+		return
+	}
+	fmt.Fprintf(w, "%d\n", s.rows[table])
+}
+
+func TestVerifyRows(t *testing.T) {
+	server := &testCountingServer{rows: map[string]uint64{"cpu": 5, "tags": 1}}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	oldHost, oldPort, oldEmptyFields, oldInitialRows := host, port, emptyFields, initialRows
+	defer func() { host, port, emptyFields, initialRows = oldHost, oldPort, oldEmptyFields, oldInitialRows }()
+	serverURL, _ := url.Parse(httpServer.URL)
+	host, port, _ = net.SplitHostPort(serverURL.Host)
+	emptyFields = emptyFieldsSkipRow
+	insertedRows.m = make(map[string]uint64)
+	tableCols = map[string][]string{"tags": {"hostname"}}
+	tableCols["cpu"], tableColTypes["cpu"] = splitColumnSpecs([]string{"usage_user", "usage_system"})
+	c, err := newHTTPConn(serverAddress(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// The tables hold the rows of a previous load, appended to
+	if err := readInitialRows(c); err != nil {
+		t.Fatal(err)
+	}
+	// Rows with empty values are skipped, not inserted
+	var rows []*insertData
+	for i := 0; i < 10; i++ {
+		fields := fmt.Sprintf("%d,%d,%d", 1451606400+i, i, i)
+		if i%5 == 0 {
+			fields = fmt.Sprintf("%d,,%d", 1451606400+i, i)
+		}
+		rows = append(rows, &insertData{tags: fmt.Sprintf("hostname=host_%d", i%3), fields: fields})
+	}
+	p := &processor{http: c, csi: newSyncCSI()}
+	if _, err := p.processCSI("cpu", rows); err != nil {
+		t.Fatalf("cannot insert rows: %v", err)
+	}
+
+	checks, err := verifyRows(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []load.VerifyCheck{{Name: "cpu", Want: 13, Got: 13}, {Name: "tags", Want: 4, Got: 4}}
+	if !reflect.DeepEqual(checks, want) {
+		t.Errorf("incorrect checks: got %+v want %+v", checks, want)
+	}
+
+	// Rows deleted behind the back of the loader fail their check
+	server.mutex.Lock()
+	server.rows["cpu"] -= 3
+	server.mutex.Unlock()
+	checks, err = verifyRows(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want[0].Got = 10
+	if !reflect.DeepEqual(checks, want) {
+		t.Errorf("incorrect checks after deleting rows: got %+v want %+v", checks, want)
+	}
+}
+
+func TestVerifiedTables(t *testing.T) {
+	oldSingleTable, oldDenormalizeTags := singleTable, denormalizeTags
+	defer func() { singleTable, denormalizeTags = oldSingleTable, oldDenormalizeTags }()
+	tableCols = map[string][]string{"tags": {"hostname"}, "mem": {"used"}, "cpu": {"usage_user"}}
+
+	cases := []struct {
+		singleTable     bool
+		denormalizeTags bool
+		want            []string
+	}{
+		{want: []string{"cpu", "mem", "tags"}},
+		{singleTable: true, want: []string{singleTableName, "tags"}},
+		{denormalizeTags: true, want: []string{"cpu", "mem"}},
+	}
+	for _, c := range cases {
+		singleTable, denormalizeTags = c.singleTable, c.denormalizeTags
+		if got := verifiedTables(); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("single table %v, denormalized tags %v: incorrect tables: got %v want %v", c.singleTable, c.denormalizeTags, got, c.want)
+		}
+	}
+}
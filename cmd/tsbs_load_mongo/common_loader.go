@@ -2,11 +2,14 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"log"
+	"time"
 
+	"github.com/globalsign/mgo/bson"
 	flatbuffers "github.com/google/flatbuffers/go"
 	"github.com/timescale/tsbs/cmd/tsbs_generate_data/serialize"
 	"github.com/timescale/tsbs/load"
@@ -50,6 +53,84 @@ func (d *decoder) Decode(r *bufio.Reader) *load.Point {
 	return load.NewPoint(item)
 }
 
+// rawPoint is a point as written by the serializer with the raw doc style
+type rawPoint struct {
+	Measurement string `bson:"measurement"`
+	TimestampNS int64  `bson:"timestamp_ns"`
+	Tags        bson.D `bson:"tags"`
+	Fields      bson.D `bson:"fields"`
+}
+
+// rawDecoder reads plain BSON documents, converting each to the MongoPoint
+// the rest of the loader works with.
+type rawDecoder struct {
+	lenBuf []byte
+	point  *serialize.Point
+	fb     *serialize.MongoSerializer
+	buf    bytes.Buffer
+}
+
+func newRawDecoder() *rawDecoder {
+	return &rawDecoder{
+		lenBuf: make([]byte, 4),
+		point:  serialize.NewPoint(),
+		fb:     &serialize.MongoSerializer{},
+	}
+}
+
+func (d *rawDecoder) Decode(r *bufio.Reader) *load.Point {
+	_, err := io.ReadFull(r, d.lenBuf)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	// the length of a BSON document includes the length itself
+	l := int(binary.LittleEndian.Uint32(d.lenBuf))
+	if l < len(d.lenBuf) {
+		log.Fatalf("invalid BSON document length %d", l)
+	}
+	docBuf := make([]byte, l)
+	copy(docBuf, d.lenBuf)
+	if _, err := io.ReadFull(r, docBuf[len(d.lenBuf):]); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	doc := rawPoint{}
+	if err := bson.Unmarshal(docBuf, &doc); err != nil {
+		log.Fatalf("cannot parse BSON document: %v", err)
+	}
+
+	d.point.Reset()
+	d.point.SetMeasurementName([]byte(doc.Measurement))
+	ts := time.Unix(0, doc.TimestampNS)
+	d.point.SetTimestamp(&ts)
+	for _, tag := range doc.Tags {
+		value, ok := tag.Value.(string)
+		if !ok {
+			log.Fatalf("tag %s has non-string value %v", tag.Name, tag.Value)
+		}
+		d.point.AppendTag([]byte(tag.Name), []byte(value))
+	}
+	for _, field := range doc.Fields {
+		d.point.AppendField([]byte(field.Name), field.Value)
+	}
+
+	// serialize as the flatbuffer, skipping its length
+	d.buf.Reset()
+	if err := d.fb.Serialize(d.point, &d.buf); err != nil {
+		log.Fatal(err.Error())
+	}
+	itemBuf := d.buf.Bytes()[8:]
+	itemBuf = append(make([]byte, 0, len(itemBuf)), itemBuf...)
+	item := &serialize.MongoPoint{}
+	item.Init(itemBuf, flatbuffers.GetUOffsetT(itemBuf))
+
+	return load.NewPoint(item)
+}
+
 type batch struct {
 	arr []*serialize.MongoPoint
 }
@@ -75,6 +156,9 @@ type mongoBenchmark struct {
 }
 
 func (b *mongoBenchmark) GetPointDecoder(_ *bufio.Reader) load.PointDecoder {
+	if docStyle == serialize.MongoDocStyleRaw {
+		return newRawDecoder()
+	}
 	return &decoder{lenBuf: make([]byte, 8)}
 }
 
@@ -5,8 +5,10 @@ package main
 
 import (
 	"flag"
+	"log"
 	"time"
 
+	"github.com/timescale/tsbs/cmd/tsbs_generate_data/serialize"
 	"github.com/timescale/tsbs/load"
 )
 
@@ -23,6 +25,7 @@ const (
 var (
 	daemonURL    string
 	documentPer  bool
+	docStyle     string
 	writeTimeout time.Duration
 )
 
@@ -38,8 +41,13 @@ func init() {
 	flag.StringVar(&daemonURL, "url", "localhost:27017", "Mongo URL.")
 	flag.DurationVar(&writeTimeout, "write-timeout", 10*time.Second, "Write timeout.")
 	flag.BoolVar(&documentPer, "document-per-event", false, "Whether to use one document per event or aggregate by hour")
+	flag.StringVar(&docStyle, "doc-style", serialize.MongoDocStyleFlatbuffer, "Style of the input documents, as set by -mongo-doc-style when generating it: flatbuffer or raw")
 
 	flag.Parse()
+
+	if docStyle != serialize.MongoDocStyleFlatbuffer && docStyle != serialize.MongoDocStyleRaw {
+		log.Fatalf("invalid doc style '%s': must be %s or %s", docStyle, serialize.MongoDocStyleFlatbuffer, serialize.MongoDocStyleRaw)
+	}
 }
 
 func main() {
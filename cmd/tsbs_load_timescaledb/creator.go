@@ -12,8 +12,20 @@ import (
 
 const tagsKey = "tags"
 
+// SQL types of the value columns
+const (
+	sqlTypeBigint  = "BIGINT"
+	sqlTypeDouble  = "DOUBLE PRECISION"
+	sqlTypeText    = "TEXT"
+	sqlTypeBoolean = "BOOLEAN"
+)
+
 var tableCols = make(map[string][]string)
 
+// tableColTypes holds the SQL types of the columns of tableCols, for decoding
+// their values
+var tableColTypes = make(map[string][]string)
+
 type dbCreator struct {
 	br      *bufio.Reader
 	tags    string
@@ -143,8 +155,15 @@ func (d *dbCreator) PostCreateDB(dbName string) error {
 	for _, tableDef := range d.cols {
 		columns := strings.Split(strings.TrimSpace(tableDef), ",")
 		tableName := columns[0]
-		// tableCols is a global map. Globally cache the available columns for the given table
-		tableCols[tableName] = columns[1:]
+		// tableCols is a global map. Globally cache the available columns for the given table,
+		// without any type annotations
+		tableCols[tableName] = make([]string, 0, len(columns)-1)
+		tableColTypes[tableName] = make([]string, 0, len(columns)-1)
+		for _, column := range columns[1:] {
+			name, colType := splitColumnType(column)
+			tableCols[tableName] = append(tableCols[tableName], name)
+			tableColTypes[tableName] = append(tableColTypes[tableName], colType)
+		}
 
 		fieldDefs, indexDefs := d.getFieldAndIndexDefinitions(columns)
 		if createMetricsTable {
@@ -171,11 +190,11 @@ func (d *dbCreator) getFieldAndIndexDefinitions(columns []string) ([]string, []s
 
 	allCols = append(allCols, columns[1:]...)
 	extraCols := 0 // set to 1 when hostname is kept in-table
-	for idx, field := range allCols {
-		if len(field) == 0 {
+	for idx, column := range allCols {
+		if len(column) == 0 {
 			continue
 		}
-		fieldType := "DOUBLE PRECISION"
+		field, fieldType := splitColumnType(column)
 		idxType := fieldIndex
 		// This condition handles the case where we keep the primary tag key in the table
 		// and partition on it. Since under the current implementation this tag is always
@@ -196,6 +215,23 @@ func (d *dbCreator) getFieldAndIndexDefinitions(columns []string) ([]string, []s
 	return fieldDefs, indexDefs
 }
 
+// splitColumnType splits a column from the data header, which is either a plain
+// name or name:type (e.g., accepts:uint64), into its name and SQL type
+func splitColumnType(column string) (string, string) {
+	parts := strings.SplitN(column, ":", 2)
+	if len(parts) == 2 {
+		switch parts[1] {
+		case "int64", "uint64":
+			return parts[0], sqlTypeBigint
+		case "string":
+			return parts[0], sqlTypeText
+		case "bool":
+			return parts[0], sqlTypeBoolean
+		}
+	}
+	return parts[0], sqlTypeDouble
+}
+
 // createTableAndIndexes takes a list of field and index definitions for a given tableName and constructs
 // the necessary table, index, and potential hypertable based on the user's settings
 func (d *dbCreator) createTableAndIndexes(dbBench *sql.DB, tableName string, fieldDefs []string, indexDefs []string) {
@@ -254,6 +254,14 @@ func TestDBCreatorGetFieldAndIndexDefinitions(t *testing.T) {
 			wantFieldDefs:   []string{"usage_user DOUBLE PRECISION", "usage_system DOUBLE PRECISION", "usage_idle DOUBLE PRECISION", "usage_nice DOUBLE PRECISION"},
 			wantIndexDefs:   []string{"CREATE INDEX ON cpu (usage_user, time DESC)", "CREATE INDEX ON cpu (usage_system, time DESC)"},
 		},
+		{
+			desc:            "mixed float and integer fields",
+			columns:         []string{"mem", "total:uint64", "used_percent", "active:int64"},
+			fieldIndexCount: 1,
+			inTableTag:      false,
+			wantFieldDefs:   []string{"total BIGINT", "used_percent DOUBLE PRECISION", "active BIGINT"},
+			wantIndexDefs:   []string{"CREATE INDEX ON mem (total, time DESC)"},
+		},
 	}
 
 	for _, c := range cases {
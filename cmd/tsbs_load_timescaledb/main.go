@@ -58,6 +58,10 @@ var (
 type insertData struct {
 	tags   string
 	fields string
+	// ts and values replace fields when read from binary input, already
+	// decoded
+	ts     time.Time
+	values []interface{}
 }
 
 // Global vars
@@ -108,6 +112,9 @@ func init() {
 type benchmark struct{}
 
 func (b *benchmark) GetPointDecoder(br *bufio.Reader) load.PointDecoder {
+	if isBinaryInput(br) {
+		return newBinaryDecoder(br)
+	}
 	return &decoder{scanner: bufio.NewScanner(br)}
 }
 
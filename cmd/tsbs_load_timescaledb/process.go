@@ -13,6 +13,7 @@ import (
 	"github.com/jackc/pgx"
 	"github.com/jackc/pgx/stdlib"
 	"github.com/lib/pq"
+	"github.com/timescale/tsbs/cmd/tsbs_generate_data/serialize"
 	"github.com/timescale/tsbs/load"
 )
 
@@ -108,11 +109,28 @@ func insertTags(db *sql.DB, tagRows [][]string, returnResults bool) map[string]i
 	return nil
 }
 
+// parseValue converts a value of the text input into one matching the SQL
+// type of its column
+func parseValue(v string, colType string) (interface{}, error) {
+	switch colType {
+	case sqlTypeBigint:
+		return strconv.ParseInt(v, 10, 64)
+	case sqlTypeText:
+		return v, nil
+	case sqlTypeBoolean:
+		return strconv.ParseBool(v)
+	default:
+		return strconv.ParseFloat(v, 64)
+	}
+}
+
 // splitTagsAndMetrics takes an array of insertData (sharded by hypertable) and
 // divides the tags from data into appropriate slices that can then be used in
-// SQL queries to insert into their respective tables. Additionally, it also
-// returns the number of metrics (i.e., non-tag fields) for the data processed.
-func splitTagsAndMetrics(rows []*insertData, dataCols int) ([][]string, [][]interface{}, uint64) {
+// SQL queries to insert into their respective tables. Values of text input are
+// parsed according to colTypes, the SQL types of the hypertable's columns.
+// Additionally, it also returns the number of metrics (i.e., non-tag fields)
+// for the data processed.
+func splitTagsAndMetrics(rows []*insertData, colTypes []string, dataCols int) ([][]string, [][]interface{}, uint64) {
 	tagRows := make([][]string, 0, len(rows))
 	dataRows := make([][]interface{}, 0, len(rows))
 	numMetrics := uint64(0)
@@ -133,27 +151,38 @@ func splitTagsAndMetrics(rows []*insertData, dataCols int) ([][]string, [][]inte
 			json = subsystemTagsToJSON(strings.Split(tags[commonTagsLen], ","))
 		}
 
-		metrics := strings.Split(data.fields, ",")
-		numMetrics += uint64(len(metrics) - 1) // 1 field is timestamp
-
-		timeInt, err := strconv.ParseInt(metrics[0], 10, 64)
-		if err != nil {
-			panic(err)
-		}
-		ts := time.Unix(0, timeInt)
-
 		// use nil at 2nd position as placeholder for tagKey
 		r := make([]interface{}, 3, dataCols)
-		r[0], r[1], r[2] = ts, nil, json
+		r[1], r[2] = nil, json
 		if inTableTag {
 			r = append(r, tags[0])
 		}
-		for _, v := range metrics[1:] {
-			num, err := strconv.ParseFloat(v, 64)
+
+		if data.values != nil {
+			// binary input is already decoded
+			numMetrics += uint64(len(data.values))
+			r[0] = data.ts
+			r = append(r, data.values...)
+		} else {
+			metrics := serialize.SplitTextFields(data.fields, ',')
+			numMetrics += uint64(len(metrics) - 1) // 1 field is timestamp
+
+			timeInt, err := strconv.ParseInt(metrics[0], 10, 64)
 			if err != nil {
 				panic(err)
 			}
-			r = append(r, num)
+			r[0] = time.Unix(0, timeInt)
+			for i, v := range metrics[1:] {
+				colType := sqlTypeDouble
+				if i < len(colTypes) {
+					colType = colTypes[i]
+				}
+				value, err := parseValue(v, colType)
+				if err != nil {
+					panic(err)
+				}
+				r = append(r, value)
+			}
 		}
 
 		dataRows = append(dataRows, r)
@@ -168,7 +197,7 @@ func (p *processor) processCSI(hypertable string, rows []*insertData) uint64 {
 	if inTableTag {
 		colLen++
 	}
-	tagRows, dataRows, numMetrics := splitTagsAndMetrics(rows, colLen)
+	tagRows, dataRows, numMetrics := splitTagsAndMetrics(rows, tableColTypes[hypertable], colLen)
 
 	// Check if any of these tags has yet to be inserted
 	newTags := make([][]string, 0, len(rows))
@@ -82,6 +82,14 @@ func TestSplitTagsAndMetrics(t *testing.T) {
 		},
 	}
 
+	binaryData := []*insertData{
+		{
+			tags:   "tag1=foo,tag2=bar",
+			ts:     time.Unix(0, 100),
+			values: []interface{}{1.0, int64(5), nil},
+		},
+	}
+
 	cases := []struct {
 		desc        string
 		rows        []*insertData
@@ -133,6 +141,16 @@ func TestSplitTagsAndMetrics(t *testing.T) {
 				[]interface{}{toTS("200"), nil, map[string]interface{}{"tag3": "BAZ"}, "foofoo", 1.0, 5.0, 45.0},
 			},
 		},
+		{
+			desc:        "binary input",
+			inTableTag:  true,
+			rows:        binaryData,
+			wantMetrics: 3,
+			wantTags:    [][]string{{"foo", "bar"}},
+			wantData: [][]interface{}{
+				[]interface{}{toTS("100"), nil, nil, "foo", 1.0, int64(5), nil},
+			},
+		},
 		{
 			desc: "invalid timestamp",
 			rows: []*insertData{
@@ -152,13 +170,13 @@ func TestSplitTagsAndMetrics(t *testing.T) {
 					t.Errorf("%s: did not panic when should", c.desc)
 				}
 			}()
-			splitTagsAndMetrics(c.rows, numCols+numExtraCols)
+			splitTagsAndMetrics(c.rows, nil, numCols+numExtraCols)
 		}
 
 		oldInTableTag := inTableTag
 		inTableTag = c.inTableTag
 
-		gotTags, gotData, numMetrics := splitTagsAndMetrics(c.rows, numCols+numExtraCols)
+		gotTags, gotData, numMetrics := splitTagsAndMetrics(c.rows, nil, numCols+numExtraCols)
 		if numMetrics != c.wantMetrics {
 			t.Errorf("%s: number of metrics incorrect: got %d want %d", c.desc, numMetrics, c.wantMetrics)
 		}
@@ -211,3 +229,34 @@ func TestSplitTagsAndMetrics(t *testing.T) {
 		inTableTag = oldInTableTag
 	}
 }
+
+func TestParseValue(t *testing.T) {
+	cases := []struct {
+		desc      string
+		value     string
+		colType   string
+		want      interface{}
+		shouldErr bool
+	}{
+		{desc: "double", value: "1.5", colType: sqlTypeDouble, want: 1.5},
+		{desc: "bigint", value: "-3", colType: sqlTypeBigint, want: int64(-3)},
+		{desc: "text", value: "up, ready", colType: sqlTypeText, want: "up, ready"},
+		{desc: "boolean", value: "true", colType: sqlTypeBoolean, want: true},
+		{desc: "invalid boolean", value: "yes", colType: sqlTypeBoolean, shouldErr: true},
+		{desc: "float in bigint column", value: "1.5", colType: sqlTypeBigint, shouldErr: true},
+	}
+	for _, c := range cases {
+		got, err := parseValue(c.value, c.colType)
+		if c.shouldErr {
+			if err == nil {
+				t.Errorf("%s: unexpected lack of error", c.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.desc, err)
+		} else if got != c.want {
+			t.Errorf("%s: incorrect value: got %v (%T) want %v (%T)", c.desc, got, got, c.want, c.want)
+		}
+	}
+}
@@ -2,9 +2,13 @@ package main
 
 import (
 	"bufio"
+	"encoding/binary"
 	"hash/fnv"
+	"io"
+	"math"
 	"strings"
 
+	"github.com/timescale/tsbs/cmd/tsbs_generate_data/serialize"
 	"github.com/timescale/tsbs/load"
 )
 
@@ -92,3 +96,139 @@ func (d *decoder) Decode(_ *bufio.Reader) *load.Point {
 		row:        data,
 	})
 }
+
+// isBinaryInput reports whether the data in br, after its header, is in
+// PostgreSQL's binary COPY format, as written by tsbs_generate_data -pg-binary
+func isBinaryInput(br *bufio.Reader) bool {
+	sig, err := br.Peek(len(serialize.PGCopySignature))
+	return err == nil && string(sig) == serialize.PGCopySignature
+}
+
+// binaryDecoder reads the tuples written by
+// serialize.TimescaleDBBinarySerializer, which hold the measurement, the time,
+// the tags as text and then the values of a point.
+type binaryDecoder struct {
+	br  *bufio.Reader
+	buf []byte
+}
+
+// binaryFixedFields is the number of fields in a tuple before the values
+const binaryFixedFields = 3
+
+func newBinaryDecoder(br *bufio.Reader) *binaryDecoder {
+	d := &binaryDecoder{br: br}
+	// The signature is followed by flags, then the length of a header
+	// extension to skip
+	if header, ok := d.read(len(serialize.PGCopySignature) + 8); ok {
+		d.read(int(binary.BigEndian.Uint32(header[len(serialize.PGCopySignature)+4:])))
+	}
+	return d
+}
+
+// read returns the next n bytes of input, valid until the next call, or
+// false if they cannot be read.
+func (d *binaryDecoder) read(n int) ([]byte, bool) {
+	if cap(d.buf) < n {
+		d.buf = make([]byte, n)
+	}
+	buf := d.buf[:n]
+	if _, err := io.ReadFull(d.br, buf); err != nil {
+		fatal("cannot read binary input: %v", err)
+		return nil, false
+	}
+	return buf, true
+}
+
+// readField returns the next field of a tuple, valid until the next call, nil
+// if it is NULL, or false if it cannot be read.
+func (d *binaryDecoder) readField() ([]byte, bool) {
+	buf, ok := d.read(4)
+	if !ok {
+		return nil, false
+	}
+	l := int32(binary.BigEndian.Uint32(buf))
+	if l == -1 {
+		return nil, true
+	} else if l < 0 {
+		fatal("invalid field length in binary input: %d", l)
+		return nil, false
+	}
+	return d.read(int(l))
+}
+
+func (d *binaryDecoder) Decode(_ *bufio.Reader) *load.Point {
+	if _, err := d.br.Peek(1); err == io.EOF {
+		return nil
+	}
+	buf, ok := d.read(2)
+	if !ok {
+		return nil
+	}
+	n := int(int16(binary.BigEndian.Uint16(buf)))
+	if n == -1 { // trailer
+		return nil
+	} else if n < binaryFixedFields {
+		fatal("binary input tuple has %d fields, expected at least %d", n, binaryFixedFields)
+		return nil
+	}
+
+	buf, ok = d.readField()
+	if !ok {
+		return nil
+	}
+	hypertable := string(buf)
+
+	buf, ok = d.readField()
+	if !ok {
+		return nil
+	} else if len(buf) != 8 {
+		fatal("time of binary input tuple has %d bytes, expected 8", len(buf))
+		return nil
+	}
+	data := &insertData{ts: serialize.PGTime(int64(binary.BigEndian.Uint64(buf)))}
+
+	buf, ok = d.readField()
+	if !ok {
+		return nil
+	}
+	data.tags = string(buf)
+
+	colTypes := tableColTypes[hypertable]
+	data.values = make([]interface{}, n-binaryFixedFields)
+	for i := range data.values {
+		buf, ok = d.readField()
+		colType := sqlTypeDouble
+		if i < len(colTypes) {
+			colType = colTypes[i]
+		}
+		if !ok {
+			return nil
+		} else if buf == nil {
+			continue // NULL
+		} else if colType == sqlTypeText {
+			data.values[i] = string(buf)
+			continue
+		} else if colType == sqlTypeBoolean {
+			if len(buf) != 1 {
+				fatal("value %d of binary input tuple has %d bytes, expected 1", i, len(buf))
+				return nil
+			}
+			data.values[i] = buf[0] != 0
+			continue
+		} else if len(buf) != 8 {
+			fatal("value %d of binary input tuple has %d bytes, expected 8", i, len(buf))
+			return nil
+		}
+		bits := binary.BigEndian.Uint64(buf)
+		if colType == sqlTypeBigint {
+			data.values[i] = int64(bits)
+		} else {
+			data.values[i] = math.Float64frombits(bits)
+		}
+	}
+
+	return load.NewPoint(&point{
+		hypertable: hypertable,
+		row:        data,
+	})
+}
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"log"
 	"testing"
+	"time"
 
+	"github.com/timescale/tsbs/cmd/tsbs_generate_data/serialize"
 	"github.com/timescale/tsbs/load"
 )
 
@@ -169,3 +171,177 @@ func TestDecodeEOF(t *testing.T) {
 		t.Errorf("expected p to be nil, got %v", p)
 	}
 }
+
+func binaryInput(t *testing.T, points ...*serialize.Point) []byte {
+	s := &serialize.TimescaleDBBinarySerializer{IntegerFields: true}
+	buf := bytes.NewBuffer(serialize.PGCopyHeader())
+	for _, p := range points {
+		if err := s.Serialize(p, buf); err != nil {
+			t.Fatalf("cannot serialize point: %v", err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestIsBinaryInput(t *testing.T) {
+	cases := []struct {
+		desc  string
+		input []byte
+		want  bool
+	}{
+		{desc: "text input", input: []byte("tags,tag1text,tag2text\ncpu,140,0.0,0.0\n")},
+		{desc: "no input", input: []byte{}},
+		{desc: "short input", input: []byte("PGCOPY")},
+		{desc: "binary input", input: serialize.PGCopyHeader(), want: true},
+	}
+	for _, c := range cases {
+		br := bufio.NewReader(bytes.NewReader(c.input))
+		if got := isBinaryInput(br); got != c.want {
+			t.Errorf("%s: incorrect result: got %v want %v", c.desc, got, c.want)
+		}
+	}
+}
+
+func TestBinaryDecode(t *testing.T) {
+	oldTypes := tableColTypes
+	defer func() { tableColTypes = oldTypes }()
+	tableColTypes = map[string][]string{
+		"cpu": {sqlTypeDouble, sqlTypeBigint},
+	}
+
+	ts := time.Unix(1451606400, 123456789)
+	p1 := serialize.NewPoint()
+	p1.SetMeasurementName([]byte("cpu"))
+	p1.SetTimestamp(&ts)
+	p1.AppendTag([]byte("hostname"), []byte("host_0"))
+	p1.AppendTag([]byte("region"), []byte("eu-west-1"))
+	p1.AppendField([]byte("usage_user"), 58.1317132304976170)
+	p1.AppendField([]byte("usage_count"), int64(5000000000))
+	p2 := serialize.NewPoint()
+	p2.SetMeasurementName([]byte("mem"))
+	p2.SetTimestamp(&ts)
+	p2.AppendTag([]byte("hostname"), []byte("host_1"))
+	p2.AppendField([]byte("used_percent"), 0.5)
+	p2.AppendField([]byte("missing"), nil)
+
+	br := bufio.NewReader(bytes.NewReader(binaryInput(t, p1, p2)))
+	if !isBinaryInput(br) {
+		t.Fatalf("binary input not detected")
+	}
+	decoder := newBinaryDecoder(br)
+
+	cases := []struct {
+		wantTable  string
+		wantTags   string
+		wantValues []interface{}
+	}{
+		{
+			wantTable:  "cpu",
+			wantTags:   "hostname=host_0,region=eu-west-1",
+			wantValues: []interface{}{58.1317132304976170, int64(5000000000)},
+		},
+		{
+			// mem has no known column types, so values are floats
+			wantTable:  "mem",
+			wantTags:   "hostname=host_1",
+			wantValues: []interface{}{0.5, nil},
+		},
+	}
+	wantTS := ts.Truncate(time.Microsecond)
+	for i, c := range cases {
+		p := decoder.Decode(br)
+		if p == nil {
+			t.Fatalf("point %d: unexpected end of input", i)
+		}
+		data := p.Data.(*point)
+		if data.hypertable != c.wantTable {
+			t.Errorf("point %d: incorrect hypertable: got %s want %s", i, data.hypertable, c.wantTable)
+		}
+		if data.row.tags != c.wantTags {
+			t.Errorf("point %d: incorrect tags: got %s want %s", i, data.row.tags, c.wantTags)
+		}
+		if !data.row.ts.Equal(wantTS) {
+			t.Errorf("point %d: incorrect time: got %v want %v", i, data.row.ts, wantTS)
+		}
+		if got := len(data.row.values); got != len(c.wantValues) {
+			t.Errorf("point %d: incorrect number of values: got %d want %d", i, got, len(c.wantValues))
+			continue
+		}
+		for j, want := range c.wantValues {
+			if got := data.row.values[j]; got != want {
+				t.Errorf("point %d: incorrect value %d: got %v (%T) want %v (%T)", i, j, got, got, want, want)
+			}
+		}
+	}
+	if p := decoder.Decode(br); p != nil {
+		t.Errorf("expected p to be nil at end of input, got %v", p)
+	}
+}
+
+func TestBinaryDecodeTrailer(t *testing.T) {
+	ts := time.Unix(1451606400, 0)
+	p := serialize.NewPoint()
+	p.SetMeasurementName([]byte("cpu"))
+	p.SetTimestamp(&ts)
+	p.AppendField([]byte("usage_user"), 1.0)
+	input := append(binaryInput(t, p), 0xff, 0xff)
+	// anything after the trailer is not read
+	input = append(input, binaryInput(t, p)...)
+
+	br := bufio.NewReader(bytes.NewReader(input))
+	decoder := newBinaryDecoder(br)
+	if p := decoder.Decode(br); p == nil {
+		t.Fatalf("unexpected end of input")
+	}
+	if p := decoder.Decode(br); p != nil {
+		t.Errorf("expected p to be nil at trailer, got %v", p)
+	}
+}
+
+func TestBinaryDecodeFatal(t *testing.T) {
+	ts := time.Unix(1451606400, 0)
+	p := serialize.NewPoint()
+	p.SetMeasurementName([]byte("cpu"))
+	p.SetTimestamp(&ts)
+	p.AppendField([]byte("usage_user"), 1.0)
+	full := binaryInput(t, p)
+	header := len(serialize.PGCopyHeader())
+
+	cases := []struct {
+		desc  string
+		input []byte
+	}{
+		{
+			desc:  "truncated tuple",
+			input: full[:len(full)-3],
+		},
+		{
+			desc:  "too few fields",
+			input: append(serialize.PGCopyHeader(), 0, 2),
+		},
+		{
+			desc:  "bad field length",
+			input: append(append(serialize.PGCopyHeader(), 0, 3), 0xff, 0xff, 0xff, 0xfe),
+		},
+		{
+			desc:  "bad time length",
+			input: append(append(append(serialize.PGCopyHeader(), full[header:header+9]...), 0, 0, 0, 4), 0, 0, 0, 0),
+		},
+	}
+	oldFatal := fatal
+	defer func() { fatal = oldFatal }()
+	for _, c := range cases {
+		isCalled := false
+		fatal = func(format string, args ...interface{}) {
+			isCalled = true
+		}
+		br := bufio.NewReader(bytes.NewReader(c.input))
+		decoder := newBinaryDecoder(br)
+		if p := decoder.Decode(br); p != nil {
+			t.Errorf("%s: expected p to be nil, got %v", c.desc, p)
+		}
+		if !isCalled {
+			t.Errorf("%s: did not call fatal when it should", c.desc)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Package config reads TSBS tool configuration from YAML files. A file maps
+// flag names to values, e.g.,
+//
+//	format: clickhouse
+//	use-case: devops
+//	scale: 100
+//	log-interval: 10s
+//
+// so that a file can hold anything that can be given on the command line and
+// is applied through the same flags, producing the same configuration.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	errUnknownKeyFmt   = "unknown key '%s' in config file"
+	errNotScalarFmt    = "value of '%s' in config file must be a single value, got %v"
+	errInvalidValueFmt = "invalid value for '%s' in config file: %v"
+)
+
+// LoadFile reads the YAML file filename and sets the flags of fs it names.
+// Flags already set explicitly (i.e., on the command line) take precedence
+// over the file and are left alone. Keys that are not flags of fs are errors.
+func LoadFile(fs *flag.FlagSet, filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("cannot read config file %s: %v", filename, err)
+	}
+	if err := Apply(fs, data); err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	return nil
+}
+
+// Apply sets the flags of fs from the YAML document data, the same way as
+// LoadFile.
+func Apply(fs *flag.FlagSet, data []byte) error {
+	values := make(map[string]interface{})
+	if err := yaml.UnmarshalStrict(data, &values); err != nil {
+		return err
+	}
+
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	// sort the keys so errors are deterministic
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if fs.Lookup(k) == nil {
+			return fmt.Errorf(errUnknownKeyFmt, k)
+		}
+		v := values[k]
+		switch v.(type) {
+		case string, bool, int, int64, uint64, float64:
+		default:
+			return fmt.Errorf(errNotScalarFmt, k, v)
+		}
+		if explicit[k] {
+			continue
+		}
+		if err := fs.Set(k, fmt.Sprint(v)); err != nil {
+			return fmt.Errorf(errInvalidValueFmt, k, err)
+		}
+	}
+	return nil
+}
+
+// Write dumps the current value of every flag of fs, except those named in
+// skip, as a YAML document that can be read back with LoadFile.
+func Write(w io.Writer, fs *flag.FlagSet, skip ...string) error {
+	skipped := make(map[string]bool)
+	for _, name := range skip {
+		skipped[name] = true
+	}
+	values := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		if !skipped[f.Name] {
+			values[f.Name] = f.Value.String()
+		}
+	})
+	data, err := yaml.Marshal(values)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
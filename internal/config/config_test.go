@@ -0,0 +1,136 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	format   string
+	scale    uint64
+	interval time.Duration
+	prob     float64
+	typed    bool
+}
+
+func newTestFlagSet() (*flag.FlagSet, *testConfig) {
+	c := &testConfig{}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.StringVar(&c.format, "format", "", "")
+	fs.Uint64Var(&c.scale, "scale", 1, "")
+	fs.DurationVar(&c.interval, "log-interval", 10*time.Second, "")
+	fs.Float64Var(&c.prob, "gap-probability", 0, "")
+	fs.BoolVar(&c.typed, "integer-fields", false, "")
+	return fs, c
+}
+
+func TestApply(t *testing.T) {
+	fs, c := newTestFlagSet()
+	doc := `
+# benchmark run
+format: clickhouse
+scale: 100
+log-interval: 1m
+gap-probability: 0.25
+integer-fields: true
+`
+	if err := Apply(fs, []byte(doc)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := testConfig{format: "clickhouse", scale: 100, interval: time.Minute, prob: 0.25, typed: true}
+	if *c != want {
+		t.Errorf("incorrect config: got %+v want %+v", *c, want)
+	}
+}
+
+func TestApplyCommandLinePrecedence(t *testing.T) {
+	fs, c := newTestFlagSet()
+	if err := fs.Parse([]string{"-scale", "5"}); err != nil {
+		t.Fatalf("unexpected parse error: %v", err)
+	}
+	if err := Apply(fs, []byte("format: influx\nscale: 100\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.scale != 5 {
+		t.Errorf("command line value overridden: got scale %d want %d", c.scale, 5)
+	}
+	if c.format != "influx" {
+		t.Errorf("file value not applied: got format %s want %s", c.format, "influx")
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	cases := []struct {
+		desc    string
+		doc     string
+		wantErr string
+	}{
+		{
+			desc:    "unknown key",
+			doc:     "format: influx\nscael: 100\n",
+			wantErr: "unknown key 'scael'",
+		},
+		{
+			desc:    "invalid value",
+			doc:     "scale: lots\n",
+			wantErr: "invalid value for 'scale'",
+		},
+		{
+			desc:    "nested value",
+			doc:     "scale:\n  hosts: 5\n",
+			wantErr: "value of 'scale' in config file must be a single value",
+		},
+	}
+	for _, c := range cases {
+		fs, _ := newTestFlagSet()
+		err := Apply(fs, []byte(c.doc))
+		if err == nil {
+			t.Errorf("%s: unexpected lack of error", c.desc)
+		} else if !strings.Contains(err.Error(), c.wantErr) {
+			t.Errorf("%s: incorrect error: got\n%s\nwant it to contain\n%s", c.desc, err.Error(), c.wantErr)
+		}
+	}
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	fs, c := newTestFlagSet()
+	if err := fs.Parse([]string{"-format", "timescaledb", "-scale", "7", "-log-interval", "500ms"}); err != nil {
+		t.Fatalf("unexpected parse error: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, fs, "integer-fields"); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	if strings.Contains(buf.String(), "integer-fields") {
+		t.Errorf("skipped flag written:\n%s", buf.String())
+	}
+
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "run.yaml")
+	if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("could not write config file: %v", err)
+	}
+
+	fs2, c2 := newTestFlagSet()
+	if err := LoadFile(fs2, filename); err != nil {
+		t.Fatalf("unexpected error loading written config:\n%s\n%v", buf.String(), err)
+	}
+	if *c2 != *c {
+		t.Errorf("config does not round trip: got %+v want %+v", *c2, *c)
+	}
+
+	if err := LoadFile(fs2, filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Errorf("unexpected lack of error for missing file")
+	}
+}
@@ -0,0 +1,26 @@
+package inputs
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/timescale/tsbs/cmd/tsbs_generate_data/serialize"
+)
+
+// checksumSerializer wraps a PointSerializer to add every Point it serializes
+// to a Checksum, exactly as written.
+type checksumSerializer struct {
+	serialize.PointSerializer
+	sum *serialize.Checksum
+	buf bytes.Buffer
+}
+
+func (s *checksumSerializer) Serialize(p *serialize.Point, w io.Writer) error {
+	s.buf.Reset()
+	if err := s.PointSerializer.Serialize(p, &s.buf); err != nil {
+		return err
+	}
+	s.sum.AddPoint(string(p.MeasurementName()), s.buf.Bytes())
+	_, err := w.Write(s.buf.Bytes())
+	return err
+}
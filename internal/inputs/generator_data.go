@@ -2,6 +2,7 @@ package inputs
 
 import (
 	"bufio"
+	"bytes"
 	"flag"
 	"fmt"
 	"io"
@@ -20,13 +21,50 @@ const (
 	ErrNoConfig          = "no GeneratorConfig provided"
 	ErrInvalidDataConfig = "invalid config: DataGenerator needs a DataGeneratorConfig"
 
-	errLogIntervalZero    = "cannot have log interval of 0"
-	errTotalGroupsZero    = "incorrect interleaved groups configuration: total groups = 0"
-	errInvalidGroupsFmt   = "incorrect interleaved groups configuration: id %d >= total groups %d"
-	errCannotParseTimeFmt = "cannot parse time from string '%s': %v"
+	errLogIntervalZero     = "cannot have log interval of 0"
+	errLogIntervalNeg      = "cannot have negative log interval"
+	errMaxLatenessNeg      = "cannot have negative max lateness"
+	errGapProbabilityFmt   = "gap probability must be between 0 and 1: got %v"
+	errAnomalyProbFmt      = "anomaly probability must be between 0 and 1: got %v"
+	errAnomalyDuration     = "anomaly duration must be positive when anomalies are enabled"
+	errIntegerFieldsFmt    = "integer fields are not supported for format '%s'"
+	errPGBinaryFmt         = "binary COPY output is not supported for format '%s'"
+	errCassandraLayoutFmt  = "cassandra bucket and key order are not supported for format '%s'"
+	errExtraTagCardZero    = "extra tag cardinality must be positive when extra tags are enabled"
+	errHostChurnRateNeg    = "cannot have negative host churn rate"
+	errDeployIntervalNeg   = "cannot have negative deploy interval"
+	errTeamReassignNeg     = "cannot have negative team reassign rate"
+	errHostFileShortFmt    = "host file %s has %d hosts, fewer than scale %d; use -host-file-cycle to reuse them"
+	errDurationNeg         = "cannot have negative duration"
+	errDurationTimeEnd     = "cannot use both -duration and -timestamp-end"
+	errDurationLimit       = "cannot use both -duration and -max-data-points"
+	errOrderingFmt         = "invalid ordering '%s': must be %s or %s"
+	errHostMajorFmt        = "host-major ordering cannot be combined with %s"
+	errHostMajorMemoryZero = "host-major memory must be positive"
+	errWorkersRealtime     = "cannot use both -workers and -realtime-rate"
+	errMeasurementCovFmt   = "measurement coverage must be greater than 0 and at most 1: got %v"
+	errFloatPrecisionNeg   = "cannot have negative float precision"
+	errMongoDocStyleFmt    = "invalid mongo document style '%s': must be %s or %s"
+	errFileLimitNoFile     = "cannot use -file-size-limit without -file"
+	errRealtimeRateFmt     = "invalid realtime rate '%s': must be a non-negative speedup such as 1x or 10x"
+	errTotalGroupsZero     = "incorrect interleaved groups configuration: total groups = 0"
+	errInvalidGroupsFmt    = "incorrect interleaved groups configuration: id %d >= total groups %d"
+	errGroupsNeedSeed      = "incorrect interleaved groups configuration: all groups need the same explicit -seed"
+	errCannotParseTimeFmt  = "cannot parse time from string '%s': %v"
 )
 
-const defaultLogInterval = 10 * time.Second
+// Orders in which generated points can be written
+const (
+	orderingTimeMajor = "time-major"
+	orderingHostMajor = "host-major"
+)
+
+const (
+	defaultLogInterval     = 10 * time.Second
+	defaultAnomalyDuration = 10 * time.Minute
+
+	defaultExtraTagCardinality = 1000
+)
 
 // DataGeneratorConfig is the GeneratorConfig that should be used with a
 // DataGenerator. It includes all the fields from a BaseConfig, as well as some
@@ -38,10 +76,54 @@ type DataGeneratorConfig struct {
 	LogInterval          time.Duration
 	InterleavedGroupID   uint
 	InterleavedNumGroups uint
+	Duration             time.Duration
+	MaxPointsPerHost     uint64
+	MaxLateness          time.Duration
+	GapProbability       float64
+	MaxGapLength         uint64
+	AnomalyProbability   float64
+	AnomalyDuration      time.Duration
+	AnomalyManifest      string
+	SchemaFile           string
+	Checksum             bool
+	DistributionParams   string
+	Seasonality          string
+	ExtraTagCount        uint64
+	ExtraTagCardinality  uint64
+	HostChurnRate        float64
+	DeployInterval       time.Duration
+	TeamReassignRate     float64
+	HostFile             string
+	HostFileCycle        bool
+	IntegerFields        bool
+	PGBinary             bool
+	CassandraBucket      string
+	CassandraKeyOrder    string
+	RealtimeRate         string
+	FileSizeLimit        uint64
+	Ordering             string
+	HostMajorBatch       uint64
+	HostMajorMemory      uint64
+	TimestampPrecision   string
+	FloatPrecision       int
+	MongoDocStyle        string
+	MeasurementCoverage  float64
+	ScaleRamp            string
+	Workers              uint
+
+	// TimeEndGiven tells whether -timestamp-end was given, which it cannot be
+	// along with -duration even with its default value
+	TimeEndGiven bool
 }
 
 // Validate checks that the values of the DataGeneratorConfig are reasonable.
 func (c *DataGeneratorConfig) Validate() error {
+	// A seed picked from the clock would differ between the processes
+	// generating each group, which then simulate different data
+	if c.InterleavedNumGroups > 1 && c.Seed == 0 {
+		return fmt.Errorf(errGroupsNeedSeed)
+	}
+
 	err := c.BaseConfig.Validate()
 	if err != nil {
 		return err
@@ -53,6 +135,146 @@ func (c *DataGeneratorConfig) Validate() error {
 
 	if c.LogInterval == 0 {
 		return fmt.Errorf(errLogIntervalZero)
+	} else if c.LogInterval < 0 {
+		return fmt.Errorf(errLogIntervalNeg)
+	}
+
+	if c.Duration < 0 {
+		return fmt.Errorf(errDurationNeg)
+	} else if c.Duration > 0 {
+		if c.TimeEndGiven {
+			return fmt.Errorf(errDurationTimeEnd)
+		}
+		if c.Limit > 0 {
+			return fmt.Errorf(errDurationLimit)
+		}
+	}
+
+	if c.MaxLateness < 0 {
+		return fmt.Errorf(errMaxLatenessNeg)
+	}
+
+	if c.GapProbability < 0 || c.GapProbability > 1 {
+		return fmt.Errorf(errGapProbabilityFmt, c.GapProbability)
+	}
+
+	if c.MaxGapLength == 0 {
+		c.MaxGapLength = 1
+	}
+
+	if c.AnomalyProbability < 0 || c.AnomalyProbability > 1 {
+		return fmt.Errorf(errAnomalyProbFmt, c.AnomalyProbability)
+	}
+
+	if c.AnomalyProbability > 0 && c.AnomalyDuration <= 0 {
+		return fmt.Errorf(errAnomalyDuration)
+	}
+
+	if _, err := devops.ParseDistributionParams(c.DistributionParams); err != nil {
+		return err
+	}
+
+	if _, err := devops.ParseSeasonality(c.Seasonality); err != nil {
+		return err
+	}
+
+	if c.ExtraTagCount > 0 && c.ExtraTagCardinality == 0 {
+		return fmt.Errorf(errExtraTagCardZero)
+	}
+
+	if c.HostChurnRate < 0 {
+		return fmt.Errorf(errHostChurnRateNeg)
+	}
+
+	if c.DeployInterval < 0 {
+		return fmt.Errorf(errDeployIntervalNeg)
+	}
+
+	if c.TeamReassignRate < 0 {
+		return fmt.Errorf(errTeamReassignNeg)
+	}
+
+	if _, err := devops.ParseScaleRamp(c.ScaleRamp); err != nil {
+		return err
+	}
+
+	if c.MeasurementCoverage == 0 {
+		c.MeasurementCoverage = 1
+	} else if c.MeasurementCoverage < 0 || c.MeasurementCoverage > 1 {
+		return fmt.Errorf(errMeasurementCovFmt, c.MeasurementCoverage)
+	}
+
+	if c.FloatPrecision < 0 {
+		return fmt.Errorf(errFloatPrecisionNeg)
+	}
+
+	switch c.MongoDocStyle {
+	case "":
+		c.MongoDocStyle = serialize.MongoDocStyleFlatbuffer
+	case serialize.MongoDocStyleFlatbuffer, serialize.MongoDocStyleRaw:
+	default:
+		return fmt.Errorf(errMongoDocStyleFmt, c.MongoDocStyle, serialize.MongoDocStyleFlatbuffer, serialize.MongoDocStyleRaw)
+	}
+
+	switch c.Ordering {
+	case "":
+		c.Ordering = orderingTimeMajor
+	case orderingTimeMajor:
+	case orderingHostMajor:
+		if c.MaxLateness > 0 {
+			return fmt.Errorf(errHostMajorFmt, "-max-lateness")
+		}
+		if c.InterleavedNumGroups > 1 {
+			return fmt.Errorf(errHostMajorFmt, "-interleaved-generation-groups")
+		}
+		if rate, _ := parseRealtimeRate(c.RealtimeRate); rate > 0 {
+			return fmt.Errorf(errHostMajorFmt, "-realtime-rate")
+		}
+		if c.Workers > 1 {
+			return fmt.Errorf(errHostMajorFmt, "-workers")
+		}
+		if c.FileSizeLimit > 0 {
+			return fmt.Errorf(errHostMajorFmt, "-file-size-limit")
+		}
+		if c.HostMajorMemory == 0 {
+			return fmt.Errorf(errHostMajorMemoryZero)
+		}
+	default:
+		return fmt.Errorf(errOrderingFmt, c.Ordering, orderingTimeMajor, orderingHostMajor)
+	}
+
+	if len(c.TimestampPrecision) == 0 {
+		c.TimestampPrecision = serialize.PrecisionNanoseconds
+	} else if _, err := serialize.ParseTimestampPrecision(c.TimestampPrecision); err != nil {
+		return err
+	}
+
+	if c.FileSizeLimit > 0 && len(c.File) == 0 {
+		return fmt.Errorf(errFileLimitNoFile)
+	}
+
+	if rate, err := parseRealtimeRate(c.RealtimeRate); err != nil {
+		return err
+	} else if rate > 0 && c.Workers > 1 {
+		return fmt.Errorf(errWorkersRealtime)
+	}
+
+	if c.Workers == 0 {
+		c.Workers = 1
+	}
+
+	if c.IntegerFields && c.Format != FormatClickhouse && c.Format != FormatTimescaleDB {
+		return fmt.Errorf(errIntegerFieldsFmt, c.Format)
+	}
+	if c.PGBinary && c.Format != FormatTimescaleDB {
+		return fmt.Errorf(errPGBinaryFmt, c.Format)
+	}
+	layout, err := serialize.ParseCassandraLayout(c.CassandraBucket, c.CassandraKeyOrder)
+	if err != nil {
+		return err
+	}
+	if !layout.IsDefault() && c.Format != FormatCassandra {
+		return fmt.Errorf(errCassandraLayoutFmt, c.Format)
 	}
 
 	err = validateGroups(c.InterleavedGroupID, c.InterleavedNumGroups)
@@ -69,6 +291,75 @@ func (c *DataGeneratorConfig) AddToFlagSet(fs *flag.FlagSet) {
 	flag.UintVar(&c.InterleavedNumGroups, "interleaved-generation-groups", 1,
 		"The number of round-robin serialization groups. Use this to scale up data generation to multiple processes.")
 
+	fs.DurationVar(&c.Duration, "duration", 0,
+		"Amount of time to generate data for, starting at -timestamp-start. Overrides the default -timestamp-end; 0 means to use -timestamp-end")
+	fs.Uint64Var(&c.MaxPointsPerHost, "max-points-per-host", 0,
+		"Limit the number of reporting intervals generated for each host, ending the data early if needed. 0 = no limit")
+
+	fs.DurationVar(&c.MaxLateness, "max-lateness", 0,
+		"Maximum time a point can be delayed in the output, producing out-of-order data. 0 means points are written in order")
+	fs.Float64Var(&c.GapProbability, "gap-probability", 0,
+		"Probability (0-1) that a host skips any given reporting interval, leaving a gap in its data")
+	fs.Uint64Var(&c.MaxGapLength, "max-gap-length", 1, "Maximum number of consecutive intervals a single gap can span")
+
+	fs.Float64Var(&c.AnomalyProbability, "anomaly-probability", 0,
+		"Probability (0-1) that a host's measurement enters an anomaly, with values pinned high or low, in any given interval")
+	fs.DurationVar(&c.AnomalyDuration, "anomaly-duration", defaultAnomalyDuration, "How long an anomaly lasts once started")
+	fs.StringVar(&c.AnomalyManifest, "anomaly-manifest", "", "Write a CSV of all injected anomalies (host, measurement, start, end) to this path")
+	fs.BoolVar(&c.Checksum, "checksum", false,
+		"Print a JSON summary of the generated points (count per measurement, bytes, hash) to stderr at exit, to check the data against later")
+	fs.StringVar(&c.SchemaFile, "schema-file", "",
+		"Write a JSON description of the tag keys, measurements and column types of the generated data to this path")
+
+	fs.StringVar(&c.DistributionParams, "distribution-params", "",
+		"Comma-separated key=value overrides of devops distribution parameters "+
+			"(keys: cpu.step_stddev, disk.step_mean, disk.step_stddev, mem.step_stddev, mem.min, mem.max)")
+	fs.StringVar(&c.Seasonality, "seasonality", "",
+		"Comma-separated measurement=daily[:weekend] amplitudes, as fractions of each field's range, "+
+			"of a daily cycle peaking at midday and a weekend dip (e.g., cpu=0.3:0.1,mem=0.2)")
+	fs.Uint64Var(&c.ExtraTagCount, "extra-tag-count", 0, "Number of extra tags (extra_tag_0, extra_tag_1, ...) to add to each host")
+	fs.Uint64Var(&c.ExtraTagCardinality, "extra-tag-cardinality", defaultExtraTagCardinality, "Number of distinct values of each extra tag")
+	fs.Float64Var(&c.HostChurnRate, "host-churn-rate", 0,
+		"Hosts replaced by new ones with fresh names and tags per simulated day, as a fraction of -scale")
+	fs.DurationVar(&c.DeployInterval, "deploy-interval", 0,
+		"Simulated time between deploys, each bumping the service_version tag of every host (0 means never)")
+	fs.Float64Var(&c.TeamReassignRate, "team-reassign-rate", 0,
+		"Hosts moved to another team per simulated day, as a fraction of -scale")
+	fs.StringVar(&c.ScaleRamp, "scale-ramp", devops.ScaleRampLinear,
+		"How the number of reporting hosts grows from -initial-scale to -scale over the run: "+
+			"linear, exponential or step:N@D to add N hosts every D (e.g., step:1000@6h)")
+	fs.Float64Var(&c.MeasurementCoverage, "measurement-coverage", 1,
+		"Probability (0-1] that each host reports each measurement other than cpu, mem and disk, e.g., to have only some hosts run nginx")
+	fs.StringVar(&c.HostFile, "host-file", "",
+		"CSV (with header) or JSON lines file with the hostname and other machine tag values of each host, instead of generated ones")
+	fs.BoolVar(&c.HostFileCycle, "host-file-cycle", false,
+		"Reuse the hosts of -host-file, with a suffix added to their hostnames, if scale exceeds their number (default is to error)")
+	fs.BoolVar(&c.IntegerFields, "integer-fields", false,
+		"Annotate integer fields with their type (e.g., accepts:uint64) in the header, so loaders create integer columns. Only for clickhouse and timescaledb formats")
+	fs.BoolVar(&c.PGBinary, "pg-binary", false,
+		"Write the points in PostgreSQL's binary COPY format after the usual text header, so the loader does not parse text. Only for timescaledb format")
+	fs.StringVar(&c.CassandraBucket, "cassandra-bucket", "24h",
+		"Span of time of each series (and so partition) in cassandra format, evenly dividing a day, e.g., 24h, 12h or 1h")
+	fs.StringVar(&c.CassandraKeyOrder, "cassandra-key-order", "series,field,bucket",
+		"Order of the parts of the series IDs in cassandra format: series (measurement and tags), field and bucket, comma-separated")
+	fs.Uint64Var(&c.FileSizeLimit, "file-size-limit", 0,
+		"Split the output of -file into name.000, name.001, ..., each starting a new file once this many bytes are written. 0 = single file")
+	fs.StringVar(&c.Ordering, "ordering", orderingTimeMajor,
+		fmt.Sprintf("Order of the output: %s (all hosts per timestamp) or %s (all timestamps per host)", orderingTimeMajor, orderingHostMajor))
+	fs.Uint64Var(&c.HostMajorBatch, "host-major-batch", 0,
+		"Most hosts whose points are buffered in memory per pass over the simulation with host-major ordering. 0 = as many as fit in -host-major-memory")
+	fs.Uint64Var(&c.HostMajorMemory, "host-major-memory", 1<<30,
+		"Bytes of points buffered in memory per pass over the simulation with host-major ordering. Each pass runs the whole simulation, so the output takes about as many passes as it is larger than this")
+	fs.StringVar(&c.TimestampPrecision, "timestamp-precision", serialize.PrecisionNanoseconds,
+		"Unit of the timestamps written (s, ms, us, ns). Finer parts are truncated. Only tsbs_load_clickhouse accepts units other than ns")
+	fs.IntVar(&c.FloatPrecision, "float-precision", 0,
+		"Number of decimals float values are written with in text formats. 0 means as many as needed to be exact; a fixed number is much faster")
+	fs.StringVar(&c.MongoDocStyle, "mongo-doc-style", serialize.MongoDocStyleFlatbuffer,
+		fmt.Sprintf("Documents written for the mongo format: %s (read by tsbs_load_mongo) or %s (plain BSON documents, as read by mongorestore)", serialize.MongoDocStyleFlatbuffer, serialize.MongoDocStyleRaw))
+	fs.StringVar(&c.RealtimeRate, "realtime-rate", "0",
+		"Pace output so simulated time advances at this multiple of wall-clock time (e.g., 1x, 10x). 0 means as fast as possible")
+	fs.UintVar(&c.Workers, "workers", 1,
+		"Number of goroutines serializing points. The output is the same whatever the number")
 }
 
 // DataGenerator is a type of Generator for creating data that will be consumed
@@ -79,6 +370,9 @@ type DataGenerator struct {
 	// os.Stdout unless File is specified in the GeneratorConfig passed to
 	// Generate.
 	Out io.Writer
+	// DebugOut is where non-generated messages should be written. If nil, it
+	// will be os.Stderr.
+	DebugOut io.Writer
 
 	config  *DataGeneratorConfig
 	tsStart time.Time
@@ -87,6 +381,13 @@ type DataGenerator struct {
 	// bufOut represents the buffered writer that should actually be passed to
 	// any operations that write out data.
 	bufOut *bufio.Writer
+
+	// header is the header block written before any points, if the format has
+	// one, and is repeated at the top of each file when rotating.
+	header   []byte
+	rotating *rotatingFile
+	// outFile is the file(s) bufOut writes to, if any
+	outFile io.Closer
 }
 
 func (g *DataGenerator) init(config GeneratorConfig) error {
@@ -109,17 +410,39 @@ func (g *DataGenerator) init(config GeneratorConfig) error {
 	if err != nil {
 		return fmt.Errorf(errCannotParseTimeFmt, g.config.TimeStart, err)
 	}
-	g.tsEnd, err = ParseUTCTime(g.config.TimeEnd)
-	if err != nil {
-		return fmt.Errorf(errCannotParseTimeFmt, g.config.TimeEnd, err)
+	if g.config.Duration > 0 {
+		g.tsEnd = g.tsStart.Add(g.config.Duration)
+	} else {
+		g.tsEnd, err = ParseUTCTime(g.config.TimeEnd)
+		if err != nil {
+			return fmt.Errorf(errCannotParseTimeFmt, g.config.TimeEnd, err)
+		}
+	}
+	if n := g.config.MaxPointsPerHost; n > 0 {
+		if end := g.tsStart.Add(time.Duration(n) * g.config.LogInterval); end.Before(g.tsEnd) {
+			g.tsEnd = end
+		}
 	}
 
 	if g.Out == nil {
 		g.Out = os.Stdout
 	}
-	g.bufOut, err = getBufferedWriter(g.config.File, g.Out)
-	if err != nil {
-		return err
+	if g.config.FileSizeLimit > 0 {
+		g.rotating, err = newRotatingFile(g.config.File, int64(g.config.FileSizeLimit))
+		if err != nil {
+			return err
+		}
+		g.bufOut = bufio.NewWriterSize(g.rotating, defaultWriteSize)
+		g.outFile = g.rotating
+	} else {
+		g.bufOut, g.outFile, err = getBufferedWriter(g.config.File, g.Out)
+		if err != nil {
+			return err
+		}
+	}
+
+	if g.DebugOut == nil {
+		g.DebugOut = os.Stderr
 	}
 
 	return nil
@@ -130,6 +453,7 @@ func (g *DataGenerator) Generate(config GeneratorConfig) error {
 	if err != nil {
 		return err
 	}
+	defer g.closeOutput()
 
 	rand.Seed(g.config.Seed)
 
@@ -143,12 +467,103 @@ func (g *DataGenerator) Generate(config GeneratorConfig) error {
 	if err != nil {
 		return err
 	}
+	err = g.writeSchemaFile(sim, g.config.SchemaFile)
+	if err != nil {
+		return err
+	}
 
-	return g.runSimulator(sim, serializer, g.config)
+	var parallel *parallelSerializer
+	if g.config.Workers > 1 {
+		serializers := make([]serialize.PointSerializer, g.config.Workers)
+		serializers[0] = serializer
+		for i := 1; i < len(serializers); i++ {
+			serializers[i], err = g.newSerializer(g.config.Format)
+			if err != nil {
+				return err
+			}
+		}
+		parallel = newParallelSerializer(serializers, g.bufOut)
+		// The serializers wrapping it below run on the points serialized by
+		// the goroutines, in order
+		serializer = parallel.replay
+	}
+
+	// Columns of a measurement have a single type, so a field must keep its
+	// type in every point
+	serializer = &fieldTypeSerializer{PointSerializer: serializer, checker: serialize.NewFieldTypeChecker()}
+
+	var checksum *serialize.Checksum
+	var checksummer *checksumSerializer
+	if g.config.Checksum {
+		checksum = serialize.NewChecksum(g.config.InterleavedGroupID, g.config.InterleavedNumGroups)
+		checksummer = &checksumSerializer{PointSerializer: serializer, sum: checksum}
+		serializer = checksummer
+	}
+
+	if g.rotating != nil {
+		serializer = &rotatingSerializer{
+			PointSerializer: serializer,
+			out:             g.rotating,
+			buf:             g.bufOut,
+			header:          g.header,
+		}
+	}
+
+	if parallel != nil {
+		parallel.start(serializer)
+		serializer = parallel
+	}
+
+	if g.config.Ordering == orderingHostMajor {
+		newSim := func() common.Simulator {
+			rand.Seed(g.config.Seed)
+			return scfg.NewSimulator(g.config.LogInterval, g.config.Limit)
+		}
+		err = g.runHostMajor(sim, newSim, serializer, checksummer)
+	} else {
+		err = g.runSimulator(sim, serializer, g.config)
+	}
+	if err != nil {
+		return err
+	}
+	err = g.closeOutput()
+	if err != nil {
+		return err
+	}
+
+	if checksum != nil {
+		err = checksum.Write(g.DebugOut)
+		if err != nil {
+			return err
+		}
+	}
+
+	return g.writeAnomalyManifest(sim, g.config.AnomalyManifest)
 }
 
-func (g *DataGenerator) runSimulator(sim common.Simulator, serializer serialize.PointSerializer, dgc *DataGeneratorConfig) error {
-	defer g.bufOut.Flush()
+func (g *DataGenerator) runSimulator(sim common.Simulator, serializer serialize.PointSerializer, dgc *DataGeneratorConfig) (err error) {
+	defer g.flushOutput(&err)
+	if parallel, ok := serializer.(*parallelSerializer); ok {
+		// Points still being serialized must be written before flushing
+		defer func() {
+			if closeErr := parallel.Close(); err == nil && closeErr != nil {
+				err = fmt.Errorf("can not serialize point: %s", closeErr)
+			}
+		}()
+	}
+
+	var late *latenessBuffer
+	if dgc.MaxLateness > 0 {
+		late = newLatenessBuffer(dgc.MaxLateness, dgc.Seed, serializer, g.bufOut)
+	}
+
+	var pacer *realtimePacer
+	rate, err := parseRealtimeRate(dgc.RealtimeRate)
+	if err != nil {
+		return err
+	} else if rate > 0 {
+		pacer = newRealtimePacer(rate, g.bufOut)
+	}
 
 	currGroupID := uint(0)
 	point := serialize.NewPoint()
@@ -159,50 +574,291 @@ func (g *DataGenerator) runSimulator(sim common.Simulator, serializer serialize.
 			continue
 		}
 
-		// in the default case this is always true
-		if currGroupID == dgc.InterleavedGroupID {
-			err := serializer.Serialize(point, g.bufOut)
+		// Every group runs the full simulation and only decides which points to
+		// write afterwards, so all groups consume the same random stream and
+		// together write each point exactly once. In the default case this is
+		// always true.
+		inGroup := currGroupID == dgc.InterleavedGroupID
+		currGroupID = (currGroupID + 1) % dgc.InterleavedNumGroups
+
+		if !inGroup {
+			// Points of other groups still take their lateness draw so ours get
+			// the same delays as in a single group run
+			if late != nil {
+				late.Skip()
+			}
+		} else {
+			if pacer != nil {
+				if err := pacer.Wait(*point.Timestamp()); err != nil {
+					return fmt.Errorf("can not flush output: %s", err)
+				}
+			}
+			if late != nil {
+				err = late.Add(point)
+			} else {
+				err = serializer.Serialize(point, g.bufOut)
+			}
 			if err != nil {
 				return fmt.Errorf("can not serialize point: %s", err)
 			}
 		}
 		point.Reset()
+	}
 
-		currGroupID = (currGroupID + 1) % dgc.InterleavedNumGroups
+	if late != nil {
+		if err := late.Flush(); err != nil {
+			return fmt.Errorf("can not serialize point: %s", err)
+		}
 	}
 	return nil
 }
 
+// hostIndexer is implemented by Simulators that can tell which host each
+// Point is for
+type hostIndexer interface {
+	NumHosts() int
+	LastHostIndex() int
+}
+
+// runHostMajor writes all points of one host before moving on to the next.
+// Since hosts are simulated together, this takes a full run of the simulation
+// for every batch of hosts, keeping only the points of that batch: as many of
+// the hosts left as have their points fit in dgc.HostMajorMemory bytes, and at
+// most dgc.HostMajorBatch unless 0. Once the points buffered exceed the memory,
+// those of the last host of the batch are dropped, for it to be left to the
+// next pass, so a pass buffers at least one host whatever its size and the
+// whole simulation runs about as many times as the output is larger than the
+// memory. newSim must return a Simulator that reproduces sim from the start.
+// The points of each host are added to a Checksum of their own by checksummer,
+// if not nil, which is added to that of checksummer only once they are
+// written, for those of the hosts dropped not to be counted twice.
+func (g *DataGenerator) runHostMajor(sim common.Simulator, newSim func() common.Simulator, serializer serialize.PointSerializer, checksummer *checksumSerializer) (err error) {
+	defer g.flushOutput(&err)
+
+	indexer, ok := sim.(hostIndexer)
+	if !ok {
+		return fmt.Errorf("use case '%s' does not support host-major ordering", g.config.Use)
+	}
+	numHosts := indexer.NumHosts()
+	memory := int(g.config.HostMajorMemory)
+	var checksum *serialize.Checksum
+	if checksummer != nil {
+		checksum = checksummer.sum
+		defer func() { checksummer.sum = checksum }()
+	}
+
+	point := serialize.NewPoint()
+	for first, batch := 0, 0; first < numHosts; first += batch {
+		if first > 0 {
+			sim = newSim()
+			indexer = sim.(hostIndexer)
+		}
+		batch = numHosts - first
+		if max := int(g.config.HostMajorBatch); max > 0 && batch > max {
+			batch = max
+		}
+		bufs := make([]bytes.Buffer, batch)
+		sums := make([]*serialize.Checksum, batch)
+		buffered := 0
+		for !sim.Finished() {
+			write := sim.Next(point)
+			if i := indexer.LastHostIndex() - first; write && i >= 0 && i < batch {
+				n := bufs[i].Len()
+				if checksummer != nil {
+					if sums[i] == nil {
+						sums[i] = serialize.NewChecksum(checksum.Group, checksum.Groups)
+					}
+					checksummer.sum = sums[i]
+				}
+				if err := serializer.Serialize(point, &bufs[i]); err != nil {
+					return fmt.Errorf("can not serialize point: %s", err)
+				}
+				buffered += bufs[i].Len() - n
+				for buffered > memory && batch > 1 {
+					batch--
+					buffered -= bufs[batch].Len()
+					bufs[batch] = bytes.Buffer{}
+				}
+			}
+			point.Reset()
+		}
+		for i := 0; i < batch; i++ {
+			if _, err := g.bufOut.Write(bufs[i].Bytes()); err != nil {
+				return err
+			}
+			if sums[i] != nil {
+				checksum.Merge(sums[i])
+			}
+		}
+	}
+	return nil
+}
+
+// flushOutput flushes the buffered output, setting *err to the error flushing
+// it unless it is already set.
+func (g *DataGenerator) flushOutput(err *error) {
+	if flushErr := g.bufOut.Flush(); *err == nil && flushErr != nil {
+		*err = fmt.Errorf("can not flush output: %s", flushErr)
+	}
+}
+
+// closeOutput closes the output file, if any, once all data has been flushed
+// to it. It is a no-op if called again.
+func (g *DataGenerator) closeOutput() error {
+	if g.outFile == nil {
+		return nil
+	}
+	err := g.outFile.Close()
+	g.outFile = nil
+	if err != nil {
+		return fmt.Errorf("cannot close output file %s: %v", g.config.File, err)
+	}
+	return nil
+}
+
+// anomalyReporter is implemented by Simulators that inject anomalies
+type anomalyReporter interface {
+	Anomalies() []devops.Anomaly
+}
+
+// writeAnomalyManifest writes a CSV with one line per anomaly injected by sim
+// to filename, if one is given.
+func (g *DataGenerator) writeAnomalyManifest(sim common.Simulator, filename string) error {
+	if len(filename) == 0 {
+		return nil
+	}
+	reporter, ok := sim.(anomalyReporter)
+	if !ok {
+		return fmt.Errorf("use case '%s' does not support anomalies", g.config.Use)
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("cannot open file for write %s: %v", filename, err)
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	w.WriteString("hostname,measurement,start,end\n")
+	for _, a := range reporter.Anomalies() {
+		fmt.Fprintf(w, "%s,%s,%s,%s\n", a.Host, a.Measurement, a.Start.Format(time.RFC3339Nano), a.End.Format(time.RFC3339Nano))
+	}
+	return w.Flush()
+}
+
 func (g *DataGenerator) getSimulatorConfig(dgc *DataGeneratorConfig) (common.SimulatorConfig, error) {
 	var ret common.SimulatorConfig
-	var err error
+	params, err := devops.ParseDistributionParams(dgc.DistributionParams)
+	if err != nil {
+		return nil, err
+	}
+	params.Seasonality, err = devops.ParseSeasonality(dgc.Seasonality)
+	if err != nil {
+		return nil, err
+	}
+	ramp, err := devops.ParseScaleRamp(dgc.ScaleRamp)
+	if err != nil {
+		return nil, err
+	}
+	var tagSource devops.TagSource
+	if len(dgc.HostFile) > 0 {
+		inventory, err := devops.LoadHostInventory(dgc.HostFile, dgc.HostFileCycle)
+		if err != nil {
+			return nil, err
+		}
+		if !dgc.HostFileCycle && uint64(inventory.Len()) < dgc.Scale {
+			return nil, fmt.Errorf(errHostFileShortFmt, dgc.HostFile, inventory.Len(), dgc.Scale)
+		}
+		tagSource = inventory
+	}
+
 	switch dgc.Use {
 	case useCaseDevops:
 		ret = &devops.DevopsSimulatorConfig{
 			Start: g.tsStart,
 			End:   g.tsEnd,
 
-			InitHostCount:   dgc.InitialScale,
-			HostCount:       dgc.Scale,
-			HostConstructor: devops.NewHost,
+			InitHostCount:      dgc.InitialScale,
+			ScaleRamp:          ramp,
+			HostCount:          dgc.Scale,
+			HostConstructor:    devops.NewHost,
+			DistributionParams: params,
+
+			GapProbability: dgc.GapProbability,
+			MaxGapLength:   dgc.MaxGapLength,
+
+			AnomalyProbability: dgc.AnomalyProbability,
+			AnomalyDuration:    dgc.AnomalyDuration,
+
+			ExtraTagCount:       dgc.ExtraTagCount,
+			ExtraTagCardinality: dgc.ExtraTagCardinality,
+
+			HostChurnRate: dgc.HostChurnRate,
+			TagSource:     tagSource,
+
+			DeployInterval:   dgc.DeployInterval,
+			TeamReassignRate: dgc.TeamReassignRate,
+
+			MeasurementCoverage: dgc.MeasurementCoverage,
+			CoverageSeed:        dgc.Seed,
 		}
 	case useCaseCPUOnly:
 		ret = &devops.CPUOnlySimulatorConfig{
 			Start: g.tsStart,
 			End:   g.tsEnd,
 
-			InitHostCount:   dgc.InitialScale,
-			HostCount:       dgc.Scale,
-			HostConstructor: devops.NewHostCPUOnly,
+			InitHostCount:      dgc.InitialScale,
+			ScaleRamp:          ramp,
+			HostCount:          dgc.Scale,
+			HostConstructor:    devops.NewHostCPUOnly,
+			DistributionParams: params,
+
+			GapProbability: dgc.GapProbability,
+			MaxGapLength:   dgc.MaxGapLength,
+
+			AnomalyProbability: dgc.AnomalyProbability,
+			AnomalyDuration:    dgc.AnomalyDuration,
+
+			ExtraTagCount:       dgc.ExtraTagCount,
+			ExtraTagCardinality: dgc.ExtraTagCardinality,
+
+			HostChurnRate: dgc.HostChurnRate,
+			TagSource:     tagSource,
+
+			DeployInterval:   dgc.DeployInterval,
+			TeamReassignRate: dgc.TeamReassignRate,
+
+			MeasurementCoverage: dgc.MeasurementCoverage,
+			CoverageSeed:        dgc.Seed,
 		}
 	case useCaseCPUSingle:
 		ret = &devops.CPUOnlySimulatorConfig{
 			Start: g.tsStart,
 			End:   g.tsEnd,
 
-			InitHostCount:   dgc.InitialScale,
-			HostCount:       dgc.Scale,
-			HostConstructor: devops.NewHostCPUSingle,
+			InitHostCount:      dgc.InitialScale,
+			ScaleRamp:          ramp,
+			HostCount:          dgc.Scale,
+			HostConstructor:    devops.NewHostCPUSingle,
+			DistributionParams: params,
+
+			GapProbability: dgc.GapProbability,
+			MaxGapLength:   dgc.MaxGapLength,
+
+			AnomalyProbability: dgc.AnomalyProbability,
+			AnomalyDuration:    dgc.AnomalyDuration,
+
+			ExtraTagCount:       dgc.ExtraTagCount,
+			ExtraTagCardinality: dgc.ExtraTagCardinality,
+
+			HostChurnRate: dgc.HostChurnRate,
+			TagSource:     tagSource,
+
+			DeployInterval:   dgc.DeployInterval,
+			TeamReassignRate: dgc.TeamReassignRate,
+
+			MeasurementCoverage: dgc.MeasurementCoverage,
+			CoverageSeed:        dgc.Seed,
 		}
 	default:
 		err = fmt.Errorf("unknown use case: '%s'", dgc.Use)
@@ -211,26 +867,70 @@ func (g *DataGenerator) getSimulatorConfig(dgc *DataGeneratorConfig) (common.Sim
 }
 
 func (g *DataGenerator) getSerializer(sim common.Simulator, format string) (serialize.PointSerializer, error) {
+	ret, err := g.newSerializer(format)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatCassandra:
+		// The layout is only described ahead of the data if not the default
+		layout := ret.(*serialize.CassandraSerializer).Layout
+		g.header = layout.Header()
+		g.bufOut.Write(g.header)
+	case FormatCrateDB:
+		g.writeHeader(sim, false)
+	case FormatClickhouse, FormatTimescaleDB:
+		g.writeHeader(sim, g.config.IntegerFields)
+		if g.config.PGBinary {
+			// Binary tuples follow the text header, which is repeated
+			// with them at the start of each output file
+			g.header = append(g.header, serialize.PGCopyHeader()...)
+			g.bufOut.Write(serialize.PGCopyHeader())
+		}
+	}
+
+	return ret, nil
+}
+
+// newSerializer returns a new serializer of points in format, as configured.
+// Unlike getSerializer it writes no header, so it can make more than one.
+func (g *DataGenerator) newSerializer(format string) (serialize.PointSerializer, error) {
 	var ret serialize.PointSerializer
 	var err error
 
+	// a zero unit means nanoseconds, the default
+	var unit time.Duration
+	if len(g.config.TimestampPrecision) > 0 {
+		unit, err = serialize.ParseTimestampPrecision(g.config.TimestampPrecision)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	switch format {
 	case FormatCassandra:
-		ret = &serialize.CassandraSerializer{}
+		layout, err := serialize.ParseCassandraLayout(g.config.CassandraBucket, g.config.CassandraKeyOrder)
+		if err != nil {
+			return nil, err
+		}
+		ret = &serialize.CassandraSerializer{TimestampUnit: unit, FloatPrecision: g.config.FloatPrecision, Layout: layout}
 	case FormatInflux:
-		ret = &serialize.InfluxSerializer{}
+		ret = &serialize.InfluxSerializer{TimestampUnit: unit, FloatPrecision: g.config.FloatPrecision}
 	case FormatMongo:
-		ret = &serialize.MongoSerializer{}
+		ret = &serialize.MongoSerializer{TimestampUnit: unit, DocStyle: g.config.MongoDocStyle}
 	case FormatSiriDB:
-		ret = &serialize.SiriDBSerializer{}
+		ret = &serialize.SiriDBSerializer{TimestampUnit: unit}
 	case FormatCrateDB:
-		g.writeHeader(sim)
-		ret = &serialize.CrateDBSerializer{}
+		ret = &serialize.CrateDBSerializer{TimestampUnit: unit, FloatPrecision: g.config.FloatPrecision}
 	case FormatClickhouse:
 		fallthrough
 	case FormatTimescaleDB:
-		g.writeHeader(sim)
-		ret = &serialize.TimescaleDBSerializer{}
+		if g.config.PGBinary {
+			ret = &serialize.TimescaleDBBinarySerializer{IntegerFields: g.config.IntegerFields}
+		} else {
+			ret = &serialize.TimescaleDBSerializer{TimestampUnit: unit, FloatPrecision: g.config.FloatPrecision}
+		}
 	default:
 		err = fmt.Errorf(errUnknownFormatFmt, format)
 	}
@@ -238,27 +938,81 @@ func (g *DataGenerator) getSerializer(sim common.Simulator, format string) (seri
 	return ret, err
 }
 
-func (g *DataGenerator) writeHeader(sim common.Simulator) {
-	g.bufOut.WriteString("tags")
+// fieldTypeSerializer wraps a PointSerializer to check that each field of a
+// measurement has values of the same type in every Point, failing with the
+// name of the first one that does not.
+type fieldTypeSerializer struct {
+	serialize.PointSerializer
+	checker *serialize.FieldTypeChecker
+}
+
+func (s *fieldTypeSerializer) Serialize(p *serialize.Point, w io.Writer) error {
+	if err := s.checker.Check(p); err != nil {
+		return err
+	}
+	return s.PointSerializer.Serialize(p, w)
+}
+
+// fieldTypeReporter is implemented by Simulators that can report the type of
+// each of their fields
+type fieldTypeReporter interface {
+	FieldTypes() map[string][]string
+}
+
+// getSchema describes the tags and fields of sim, with measurements sorted
+// by name so the description is deterministic. If typed is set, fields take
+// the types reported by sim; otherwise they are all floats, except for
+// string and bool fields, which cannot be loaded into float columns.
+func getSchema(sim common.Simulator, typed bool) *serialize.Schema {
+	schema := &serialize.Schema{}
 	for _, key := range sim.TagKeys() {
-		g.bufOut.WriteString(",")
-		g.bufOut.Write(key)
+		schema.Tags = append(schema.Tags, string(key))
 	}
-	g.bufOut.WriteString("\n")
-	// sort the keys so the header is deterministic
 	keys := make([]string, 0)
 	fields := sim.Fields()
+	var types map[string][]string
+	if reporter, ok := sim.(fieldTypeReporter); ok {
+		types = reporter.FieldTypes()
+	}
 	for k := range fields {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
 	for _, measurementName := range keys {
-		g.bufOut.WriteString(measurementName)
-		for _, field := range fields[measurementName] {
-			g.bufOut.WriteString(",")
-			g.bufOut.Write(field)
+		m := serialize.SchemaMeasurement{Name: measurementName}
+		for i, field := range fields[measurementName] {
+			fieldType := devops.FieldTypeFloat64
+			if t := types[measurementName]; i < len(t) && (typed || !devops.IsNumericFieldType(t[i])) {
+				fieldType = t[i]
+			}
+			m.Columns = append(m.Columns, serialize.SchemaColumn{Name: string(field), Type: fieldType})
 		}
-		g.bufOut.WriteString("\n")
+		schema.Measurements = append(schema.Measurements, m)
+	}
+	return schema
+}
+
+// writeHeader writes the tags and fields of sim as the header used by the
+// CSV-like formats. If typed is set, integer fields are written as
+// name:type (e.g., accepts:uint64) so loaders can create matching columns;
+// string and bool fields always are, and fields without a type are floats.
+func (g *DataGenerator) writeHeader(sim common.Simulator, typed bool) {
+	g.header = getSchema(sim, typed).Header()
+	g.bufOut.Write(g.header)
+}
+
+// writeSchemaFile writes the schema of the data generated by sim, as JSON, to
+// filename.
+func (g *DataGenerator) writeSchemaFile(sim common.Simulator, filename string) error {
+	if len(filename) == 0 {
+		return nil
 	}
-	g.bufOut.WriteString("\n")
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("cannot open file for write %s: %v", filename, err)
+	}
+	defer file.Close()
+
+	return getSchema(sim, g.config.IntegerFields).Write(file)
 }
@@ -5,8 +5,12 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math/rand"
 	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -70,8 +74,396 @@ func TestDataGeneratorConfigValidate(t *testing.T) {
 	} else if got := err.Error(); got != errLogIntervalZero {
 		t.Errorf("incorrect error for 0 log interval: got\n%s\nwant\n%s", got, errLogIntervalZero)
 	}
+	c.LogInterval = -time.Second
+	err = c.Validate()
+	if err == nil {
+		t.Errorf("unexpected lack of error for negative log interval")
+	} else if got := err.Error(); got != errLogIntervalNeg {
+		t.Errorf("incorrect error for negative log interval: got\n%s\nwant\n%s", got, errLogIntervalNeg)
+	}
+	c.LogInterval = 100 * time.Millisecond
+	err = c.Validate()
+	if err != nil {
+		t.Errorf("unexpected error for sub-second log interval: %v", err)
+	}
 	c.LogInterval = time.Second
 
+	// Test Duration validation
+	c.Duration = -time.Hour
+	err = c.Validate()
+	if err == nil {
+		t.Errorf("unexpected lack of error for negative duration")
+	} else if got := err.Error(); got != errDurationNeg {
+		t.Errorf("incorrect error for negative duration: got\n%s\nwant\n%s", got, errDurationNeg)
+	}
+	c.Duration = time.Hour
+	for _, end := range []string{"", defaultTimeEnd} {
+		c.TimeEnd = end
+		err = c.Validate()
+		if err != nil {
+			t.Errorf("unexpected error for duration with end '%s': %v", end, err)
+		}
+	}
+	c.TimeEndGiven = true
+	for _, end := range []string{defaultTimeEnd, "2016-01-05T00:00:00Z"} {
+		c.TimeEnd = end
+		err = c.Validate()
+		if err == nil {
+			t.Errorf("unexpected lack of error for duration with explicit end '%s'", end)
+		} else if got := err.Error(); got != errDurationTimeEnd {
+			t.Errorf("incorrect error for duration with explicit end '%s': got\n%s\nwant\n%s", end, got, errDurationTimeEnd)
+		}
+	}
+	c.TimeEndGiven = false
+	c.TimeEnd = ""
+	c.Limit = 10
+	err = c.Validate()
+	if err == nil {
+		t.Errorf("unexpected lack of error for duration with limit")
+	} else if got := err.Error(); got != errDurationLimit {
+		t.Errorf("incorrect error for duration with limit: got\n%s\nwant\n%s", got, errDurationLimit)
+	}
+	c.Duration = 0
+	err = c.Validate()
+	if err != nil {
+		t.Errorf("unexpected error for limit without duration: %v", err)
+	}
+	c.Limit = 0
+
+	// Test ordering validation
+	c.Ordering = ""
+	err = c.Validate()
+	if err != nil {
+		t.Errorf("unexpected error for empty ordering: %v", err)
+	} else if c.Ordering != orderingTimeMajor {
+		t.Errorf("ordering not defaulted: got %s want %s", c.Ordering, orderingTimeMajor)
+	}
+	c.Ordering = "random"
+	err = c.Validate()
+	if err == nil {
+		t.Errorf("unexpected lack of error for bad ordering")
+	} else if got, want := err.Error(), fmt.Sprintf(errOrderingFmt, "random", orderingTimeMajor, orderingHostMajor); got != want {
+		t.Errorf("incorrect error for bad ordering: got\n%s\nwant\n%s", got, want)
+	}
+	c.Ordering = orderingHostMajor
+	err = c.Validate()
+	if err == nil {
+		t.Errorf("unexpected lack of error for zero host-major memory")
+	} else if got := err.Error(); got != errHostMajorMemoryZero {
+		t.Errorf("incorrect error for zero host-major memory: got\n%s\nwant\n%s", got, errHostMajorMemoryZero)
+	}
+	c.HostMajorMemory = 1 << 30
+	err = c.Validate()
+	if err != nil {
+		t.Errorf("unexpected error for host-major ordering: %v", err)
+	}
+	c.MaxLateness = time.Minute
+	err = c.Validate()
+	if err == nil {
+		t.Errorf("unexpected lack of error for host-major ordering with lateness")
+	} else if got, want := err.Error(), fmt.Sprintf(errHostMajorFmt, "-max-lateness"); got != want {
+		t.Errorf("incorrect error for host-major ordering with lateness: got\n%s\nwant\n%s", got, want)
+	}
+	c.MaxLateness = 0
+	c.Workers = 4
+	err = c.Validate()
+	if err == nil {
+		t.Errorf("unexpected lack of error for host-major ordering with workers")
+	} else if got, want := err.Error(), fmt.Sprintf(errHostMajorFmt, "-workers"); got != want {
+		t.Errorf("incorrect error for host-major ordering with workers: got\n%s\nwant\n%s", got, want)
+	}
+	c.Workers = 1
+	c.FileSizeLimit = 1 << 20
+	err = c.Validate()
+	if err == nil {
+		t.Errorf("unexpected lack of error for host-major ordering with file size limit")
+	} else if got, want := err.Error(), fmt.Sprintf(errHostMajorFmt, "-file-size-limit"); got != want {
+		t.Errorf("incorrect error for host-major ordering with file size limit: got\n%s\nwant\n%s", got, want)
+	}
+	c.FileSizeLimit = 0
+	c.Ordering = orderingTimeMajor
+
+	// Test Workers validation
+	c.Workers = 0
+	err = c.Validate()
+	if err != nil {
+		t.Errorf("unexpected error for 0 workers: %v", err)
+	} else if c.Workers != 1 {
+		t.Errorf("workers not defaulted: got %d want 1", c.Workers)
+	}
+	c.Workers = 4
+	c.RealtimeRate = "1x"
+	err = c.Validate()
+	if err == nil {
+		t.Errorf("unexpected lack of error for workers with realtime rate")
+	} else if got := err.Error(); got != errWorkersRealtime {
+		t.Errorf("incorrect error for workers with realtime rate: got\n%s\nwant\n%s", got, errWorkersRealtime)
+	}
+	c.RealtimeRate = ""
+	c.Workers = 1
+
+	// Test TimestampPrecision validation
+	err = c.Validate()
+	if err != nil {
+		t.Errorf("unexpected error for empty timestamp precision: %v", err)
+	} else if c.TimestampPrecision != serialize.PrecisionNanoseconds {
+		t.Errorf("timestamp precision not defaulted: got %s want %s", c.TimestampPrecision, serialize.PrecisionNanoseconds)
+	}
+	c.TimestampPrecision = "min"
+	err = c.Validate()
+	if err == nil {
+		t.Errorf("unexpected lack of error for bad timestamp precision")
+	}
+	c.TimestampPrecision = serialize.PrecisionSeconds
+	err = c.Validate()
+	if err != nil {
+		t.Errorf("unexpected error for timestamp precision of seconds: %v", err)
+	}
+
+	// Test MeasurementCoverage validation
+	err = c.Validate()
+	if err != nil {
+		t.Errorf("unexpected error for 0 measurement coverage: %v", err)
+	} else if c.MeasurementCoverage != 1 {
+		t.Errorf("measurement coverage not defaulted: got %v want %v", c.MeasurementCoverage, 1)
+	}
+	for _, cov := range []float64{-0.1, 1.1} {
+		c.MeasurementCoverage = cov
+		err = c.Validate()
+		if err == nil {
+			t.Errorf("unexpected lack of error for measurement coverage %v", cov)
+		} else if got, want := err.Error(), fmt.Sprintf(errMeasurementCovFmt, cov); got != want {
+			t.Errorf("incorrect error for measurement coverage %v: got\n%s\nwant\n%s", cov, got, want)
+		}
+	}
+	c.MeasurementCoverage = 1
+
+	// Test FloatPrecision validation
+	c.FloatPrecision = -1
+	err = c.Validate()
+	if err == nil {
+		t.Errorf("unexpected lack of error for negative float precision")
+	} else if got := err.Error(); got != errFloatPrecisionNeg {
+		t.Errorf("incorrect error for negative float precision: got\n%s\nwant\n%s", got, errFloatPrecisionNeg)
+	}
+	c.FloatPrecision = 6
+	err = c.Validate()
+	if err != nil {
+		t.Errorf("unexpected error for float precision of 6: %v", err)
+	}
+	c.FloatPrecision = 0
+
+	// Test MongoDocStyle validation
+	c.MongoDocStyle = "json"
+	err = c.Validate()
+	if err == nil {
+		t.Errorf("unexpected lack of error for bad mongo document style")
+	} else if got, want := err.Error(), fmt.Sprintf(errMongoDocStyleFmt, "json", serialize.MongoDocStyleFlatbuffer, serialize.MongoDocStyleRaw); got != want {
+		t.Errorf("incorrect error for bad mongo document style: got\n%s\nwant\n%s", got, want)
+	}
+	c.MongoDocStyle = ""
+	err = c.Validate()
+	if err != nil {
+		t.Errorf("unexpected error for empty mongo document style: %v", err)
+	} else if c.MongoDocStyle != serialize.MongoDocStyleFlatbuffer {
+		t.Errorf("mongo document style not defaulted: got %s want %s", c.MongoDocStyle, serialize.MongoDocStyleFlatbuffer)
+	}
+	c.MongoDocStyle = serialize.MongoDocStyleRaw
+	err = c.Validate()
+	if err != nil {
+		t.Errorf("unexpected error for raw mongo document style: %v", err)
+	}
+
+	// Test ScaleRamp validation
+	c.ScaleRamp = "step:1000"
+	err = c.Validate()
+	if err == nil {
+		t.Errorf("unexpected lack of error for bad scale ramp")
+	}
+	c.ScaleRamp = "step:1000@6h"
+	err = c.Validate()
+	if err != nil {
+		t.Errorf("unexpected error for step scale ramp: %v", err)
+	}
+	c.ScaleRamp = ""
+
+	// Test FileSizeLimit validation
+	c.FileSizeLimit = 1000
+	err = c.Validate()
+	if err == nil {
+		t.Errorf("unexpected lack of error for file size limit without file")
+	} else if got := err.Error(); got != errFileLimitNoFile {
+		t.Errorf("incorrect error for file size limit without file: got\n%s\nwant\n%s", got, errFileLimitNoFile)
+	}
+	c.File = "/tmp/data"
+	err = c.Validate()
+	if err != nil {
+		t.Errorf("unexpected error for file size limit with file: %v", err)
+	}
+	c.File = ""
+	c.FileSizeLimit = 0
+
+	// Test MaxLateness validation
+	c.MaxLateness = -time.Second
+	err = c.Validate()
+	if err == nil {
+		t.Errorf("unexpected lack of error for negative max lateness")
+	} else if got := err.Error(); got != errMaxLatenessNeg {
+		t.Errorf("incorrect error for negative max lateness: got\n%s\nwant\n%s", got, errMaxLatenessNeg)
+	}
+	c.MaxLateness = 0
+
+	// Test gap validation
+	for _, prob := range []float64{-0.1, 1.1} {
+		c.GapProbability = prob
+		err = c.Validate()
+		if err == nil {
+			t.Errorf("unexpected lack of error for gap probability %v", prob)
+		} else {
+			want := fmt.Sprintf(errGapProbabilityFmt, prob)
+			if got := err.Error(); got != want {
+				t.Errorf("incorrect error for gap probability %v: got\n%s\nwant\n%s", prob, got, want)
+			}
+		}
+	}
+	c.GapProbability = 0.5
+	c.MaxGapLength = 0
+	err = c.Validate()
+	if err != nil {
+		t.Errorf("unexpected error for MaxGapLength of 0: %v", err)
+	}
+	if c.MaxGapLength != 1 {
+		t.Errorf("MaxGapLength not set correctly for 0: got %d want %d", c.MaxGapLength, 1)
+	}
+	c.GapProbability = 0
+
+	// Test anomaly validation
+	c.AnomalyProbability = 2
+	err = c.Validate()
+	if err == nil {
+		t.Errorf("unexpected lack of error for anomaly probability > 1")
+	} else if got, want := err.Error(), fmt.Sprintf(errAnomalyProbFmt, 2.0); got != want {
+		t.Errorf("incorrect error for anomaly probability > 1: got\n%s\nwant\n%s", got, want)
+	}
+	c.AnomalyProbability = 0.1
+	err = c.Validate()
+	if err == nil {
+		t.Errorf("unexpected lack of error for 0 anomaly duration")
+	} else if got := err.Error(); got != errAnomalyDuration {
+		t.Errorf("incorrect error for 0 anomaly duration: got\n%s\nwant\n%s", got, errAnomalyDuration)
+	}
+	c.AnomalyProbability = 0
+
+	// Test extra tags validation
+	c.ExtraTagCount = 5
+	err = c.Validate()
+	if err == nil {
+		t.Errorf("unexpected lack of error for 0 extra tag cardinality")
+	} else if got := err.Error(); got != errExtraTagCardZero {
+		t.Errorf("incorrect error for 0 extra tag cardinality: got\n%s\nwant\n%s", got, errExtraTagCardZero)
+	}
+	c.ExtraTagCardinality = 10
+	err = c.Validate()
+	if err != nil {
+		t.Errorf("unexpected error for extra tags: %v", err)
+	}
+	c.ExtraTagCount = 0
+	c.ExtraTagCardinality = 0
+
+	// Test host churn validation
+	c.HostChurnRate = -0.1
+	err = c.Validate()
+	if err == nil {
+		t.Errorf("unexpected lack of error for negative host churn rate")
+	} else if got := err.Error(); got != errHostChurnRateNeg {
+		t.Errorf("incorrect error for negative host churn rate: got\n%s\nwant\n%s", got, errHostChurnRateNeg)
+	}
+	c.HostChurnRate = 0
+
+	// Test dynamic tags validation
+	c.DeployInterval = -time.Hour
+	err = c.Validate()
+	if err == nil {
+		t.Errorf("unexpected lack of error for negative deploy interval")
+	} else if got := err.Error(); got != errDeployIntervalNeg {
+		t.Errorf("incorrect error for negative deploy interval: got\n%s\nwant\n%s", got, errDeployIntervalNeg)
+	}
+	c.DeployInterval = 0
+
+	c.TeamReassignRate = -0.1
+	err = c.Validate()
+	if err == nil {
+		t.Errorf("unexpected lack of error for negative team reassign rate")
+	} else if got := err.Error(); got != errTeamReassignNeg {
+		t.Errorf("incorrect error for negative team reassign rate: got\n%s\nwant\n%s", got, errTeamReassignNeg)
+	}
+	c.TeamReassignRate = 0
+
+	// Test integer fields validation
+	c.IntegerFields = true
+	err = c.Validate()
+	if err != nil {
+		t.Errorf("unexpected error for integer fields with %s: %v", c.Format, err)
+	}
+	c.Format = FormatInflux
+	err = c.Validate()
+	if err == nil {
+		t.Errorf("unexpected lack of error for integer fields with %s", c.Format)
+	} else if got, want := err.Error(), fmt.Sprintf(errIntegerFieldsFmt, FormatInflux); got != want {
+		t.Errorf("incorrect error for integer fields: got\n%s\nwant\n%s", got, want)
+	}
+	c.Format = FormatTimescaleDB
+	c.IntegerFields = false
+
+	// Test PGBinary validation
+	c.PGBinary = true
+	err = c.Validate()
+	if err != nil {
+		t.Errorf("unexpected error for binary COPY output with %s: %v", c.Format, err)
+	}
+	c.Format = FormatClickhouse
+	err = c.Validate()
+	if err == nil {
+		t.Errorf("unexpected lack of error for binary COPY output with %s", c.Format)
+	} else if got, want := err.Error(), fmt.Sprintf(errPGBinaryFmt, FormatClickhouse); got != want {
+		t.Errorf("incorrect error for binary COPY output: got\n%s\nwant\n%s", got, want)
+	}
+	c.Format = FormatTimescaleDB
+	c.PGBinary = false
+
+	// Test cassandra layout validation
+	c.CassandraBucket = "24h"
+	c.CassandraKeyOrder = "series,field,bucket"
+	err = c.Validate()
+	if err != nil {
+		t.Errorf("unexpected error for default cassandra layout with %s: %v", c.Format, err)
+	}
+	c.CassandraBucket = "1h"
+	err = c.Validate()
+	if err == nil {
+		t.Errorf("unexpected lack of error for cassandra bucket with %s", c.Format)
+	} else if got, want := err.Error(), fmt.Sprintf(errCassandraLayoutFmt, FormatTimescaleDB); got != want {
+		t.Errorf("incorrect error for cassandra bucket: got\n%s\nwant\n%s", got, want)
+	}
+	c.Format = FormatCassandra
+	err = c.Validate()
+	if err != nil {
+		t.Errorf("unexpected error for cassandra bucket with %s: %v", c.Format, err)
+	}
+	c.CassandraBucket = "5h"
+	err = c.Validate()
+	if err == nil {
+		t.Errorf("unexpected lack of error for bad cassandra bucket")
+	}
+	c.CassandraBucket = ""
+	c.CassandraKeyOrder = "series,bucket"
+	err = c.Validate()
+	if err == nil {
+		t.Errorf("unexpected lack of error for bad cassandra key order")
+	}
+	c.Format = FormatTimescaleDB
+	c.CassandraKeyOrder = ""
+
 	// Test groups validation
 	c.InterleavedNumGroups = 0
 	err = c.Validate()
@@ -92,6 +484,23 @@ func TestDataGeneratorConfigValidate(t *testing.T) {
 			t.Errorf("incorrect error for group id > num groups: got\n%s\nwant\n%s", got, want)
 		}
 	}
+	c.InterleavedGroupID = 0
+
+	c.Seed = 0
+	c.InterleavedNumGroups = 2
+	err = c.Validate()
+	if err == nil {
+		t.Errorf("unexpected lack of error for groups without seed")
+	} else if got := err.Error(); got != errGroupsNeedSeed {
+		t.Errorf("incorrect error for groups without seed: got\n%s\nwant\n%s", got, errGroupsNeedSeed)
+	}
+	c.InterleavedNumGroups = 1
+	err = c.Validate()
+	if err != nil {
+		t.Errorf("unexpected error for single group without seed: %v", err)
+	} else if c.Seed == 0 {
+		t.Errorf("seed not set for single group")
+	}
 }
 
 func TestDataGeneratorInit(t *testing.T) {
@@ -168,6 +577,73 @@ func TestDataGeneratorInit(t *testing.T) {
 	}
 }
 
+func TestDataGeneratorInitTimeRange(t *testing.T) {
+	start, _ := ParseUTCTime(defaultTimeStart)
+	cases := []struct {
+		desc             string
+		timeEnd          string
+		duration         time.Duration
+		maxPointsPerHost uint64
+		want             time.Time
+	}{
+		{
+			desc:    "end only",
+			timeEnd: "2016-01-03T00:00:00Z",
+			want:    start.Add(48 * time.Hour),
+		},
+		{
+			desc:     "duration overrides default end",
+			timeEnd:  defaultTimeEnd,
+			duration: 6 * time.Hour,
+			want:     start.Add(6 * time.Hour),
+		},
+		{
+			desc:     "duration past default end",
+			duration: 72 * time.Hour,
+			want:     start.Add(72 * time.Hour),
+		},
+		{
+			desc:             "points per host truncates end",
+			timeEnd:          defaultTimeEnd,
+			maxPointsPerHost: 10,
+			want:             start.Add(10 * time.Second),
+		},
+		{
+			desc:             "points per host truncates duration",
+			duration:         time.Hour,
+			maxPointsPerHost: 10,
+			want:             start.Add(10 * time.Second),
+		},
+		{
+			desc:             "points per host beyond duration",
+			duration:         5 * time.Second,
+			maxPointsPerHost: 10,
+			want:             start.Add(5 * time.Second),
+		},
+	}
+	for _, c := range cases {
+		dg := &DataGenerator{Out: &bytes.Buffer{}}
+		config := &DataGeneratorConfig{
+			BaseConfig: BaseConfig{
+				Format:    FormatTimescaleDB,
+				Use:       useCaseCPUOnly,
+				Scale:     1,
+				TimeStart: defaultTimeStart,
+				TimeEnd:   c.timeEnd,
+			},
+			LogInterval:          time.Second,
+			InterleavedNumGroups: 1,
+			Duration:             c.duration,
+			MaxPointsPerHost:     c.maxPointsPerHost,
+		}
+		if err := dg.init(config); err != nil {
+			t.Errorf("%s: unexpected error: %v", c.desc, err)
+		} else if !dg.tsEnd.Equal(c.want) {
+			t.Errorf("%s: incorrect end: got %v want %v", c.desc, dg.tsEnd, c.want)
+		}
+	}
+}
+
 const correctData = `tags,hostname,region,datacenter,rack,os,arch,team,service,service_version,service_environment
 cpu,usage_user,usage_system,usage_idle,usage_nice,usage_iowait,usage_irq,usage_softirq,usage_steal,usage_guest,usage_guest_nice
 
@@ -214,6 +690,780 @@ func TestDataGeneratorGenerate(t *testing.T) {
 
 }
 
+func TestDataGeneratorGenerateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generate")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	newConfig := func() *DataGeneratorConfig {
+		return &DataGeneratorConfig{
+			BaseConfig: BaseConfig{
+				Seed:      123,
+				Limit:     100,
+				Format:    FormatTimescaleDB,
+				Use:       useCaseDevops,
+				Scale:     3,
+				TimeStart: defaultTimeStart,
+				TimeEnd:   defaultTimeEnd,
+			},
+			LogInterval:          time.Second,
+			InterleavedNumGroups: 1,
+		}
+	}
+
+	var buf bytes.Buffer
+	dg := &DataGenerator{Out: &buf}
+	if err := dg.Generate(newConfig()); err != nil {
+		t.Fatalf("unexpected error generating to stdout: %v", err)
+	}
+
+	c := newConfig()
+	c.File = filepath.Join(dir, "data.csv")
+	dg = &DataGenerator{}
+	if err := dg.Generate(c); err != nil {
+		t.Fatalf("unexpected error generating to file: %v", err)
+	}
+	if dg.outFile != nil {
+		t.Errorf("output file not closed")
+	}
+	got, err := ioutil.ReadFile(c.File)
+	if err != nil {
+		t.Fatalf("could not read output file: %v", err)
+	}
+	if !bytes.Equal(got, buf.Bytes()) {
+		t.Errorf("file output differs from stdout output:\ngot\n%s\nwant\n%s", got, buf.Bytes())
+	}
+
+	c = newConfig()
+	c.File = filepath.Join(dir, "missing", "data.csv")
+	dg = &DataGenerator{}
+	if err := dg.Generate(c); err == nil {
+		t.Errorf("unexpected lack of error for unwritable file")
+	}
+}
+
+func TestDataGeneratorGenerateInterleavedGroups(t *testing.T) {
+	const numGroups = 3
+	generate := func(groupID, numGroups uint, maxLateness time.Duration) []string {
+		c := &DataGeneratorConfig{
+			BaseConfig: BaseConfig{
+				Seed:      123,
+				Format:    FormatInflux,
+				Use:       useCaseDevops,
+				Scale:     5,
+				TimeStart: defaultTimeStart,
+				TimeEnd:   "2016-01-01T01:00:00Z",
+			},
+			InitialScale:         2,
+			LogInterval:          time.Minute,
+			InterleavedGroupID:   groupID,
+			InterleavedNumGroups: numGroups,
+			MaxLateness:          maxLateness,
+			GapProbability:       0.2,
+			MaxGapLength:         3,
+			AnomalyProbability:   0.05,
+			AnomalyDuration:      10 * time.Minute,
+			HostChurnRate:        10,
+			DeployInterval:       10 * time.Minute,
+			TeamReassignRate:     10,
+		}
+		var buf bytes.Buffer
+		dg := &DataGenerator{Out: &buf}
+		if err := dg.Generate(c); err != nil {
+			t.Fatalf("unexpected error generating group %d of %d: %v", groupID, numGroups, err)
+		}
+		return strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	}
+
+	for _, maxLateness := range []time.Duration{0, 5 * time.Minute} {
+		want := generate(0, 1, maxLateness)
+		groups := make([][]string, numGroups)
+		total := 0
+		for i := range groups {
+			groups[i] = generate(uint(i), numGroups, maxLateness)
+			total += len(groups[i])
+		}
+		if total != len(want) {
+			t.Errorf("lateness %v: incorrect number of points over all groups: got %d want %d", maxLateness, total, len(want))
+			continue
+		}
+
+		if maxLateness == 0 {
+			// points are assigned to groups round-robin in output order
+			for i, line := range want {
+				if got := groups[i%numGroups][i/numGroups]; got != line {
+					t.Fatalf("lateness %v: point %d differs in group %d: got\n%s\nwant\n%s", maxLateness, i, i%numGroups, got, line)
+				}
+			}
+			continue
+		}
+
+		// each group writes a subset of the points in the same relative order,
+		// and together they write each point exactly once
+		counts := make(map[string]int)
+		for _, line := range want {
+			counts[line]++
+		}
+		for i, lines := range groups {
+			j := 0
+			for _, line := range lines {
+				for j < len(want) && want[j] != line {
+					j++
+				}
+				if j == len(want) {
+					t.Errorf("lateness %v: group %d output is not a subsequence of the single group output at:\n%s", maxLateness, i, line)
+					break
+				}
+				j++
+				counts[line]--
+			}
+		}
+		for line, n := range counts {
+			if n != 0 {
+				t.Errorf("lateness %v: point written %d extra times over all groups:\n%s", maxLateness, -n, line)
+			}
+		}
+	}
+}
+
+// testHostMajorConfig returns the config of the data set of the host-major
+// tests, with its points in ordering
+func testHostMajorConfig(ordering string, batch, memory uint64) *DataGeneratorConfig {
+	return &DataGeneratorConfig{
+		BaseConfig: BaseConfig{
+			Seed:      123,
+			Format:    FormatInflux,
+			Use:       useCaseDevops,
+			Scale:     5,
+			TimeStart: defaultTimeStart,
+			TimeEnd:   "2016-01-01T01:00:00Z",
+		},
+		InitialScale:         2,
+		LogInterval:          time.Minute,
+		InterleavedNumGroups: 1,
+		GapProbability:       0.2,
+		MaxGapLength:         3,
+		HostChurnRate:        10,
+		Ordering:             ordering,
+		HostMajorBatch:       batch,
+		HostMajorMemory:      memory,
+	}
+}
+
+func TestDataGeneratorGenerateHostMajor(t *testing.T) {
+	generate := func(ordering string, batch, memory uint64) []string {
+		c := testHostMajorConfig(ordering, batch, memory)
+		var buf bytes.Buffer
+		dg := &DataGenerator{Out: &buf}
+		if err := dg.Generate(c); err != nil {
+			t.Fatalf("unexpected error generating %s: %v", ordering, err)
+		}
+		return strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	}
+	hostname := func(line string) string {
+		return strings.SplitN(strings.SplitN(line, "hostname=", 2)[1], ",", 2)[0]
+	}
+
+	timeMajor := generate(orderingTimeMajor, 0, 0)
+	want := append([]string{}, timeMajor...)
+	sort.Strings(want)
+	cases := []struct {
+		batch  uint64
+		memory uint64
+	}{
+		{batch: 1, memory: 1 << 30},
+		{batch: 2, memory: 1 << 30},
+		{batch: 10, memory: 1 << 30},
+		{batch: 0, memory: 1 << 30},
+		{batch: 0, memory: 200000},
+		{batch: 0, memory: 1},
+	}
+	for _, c := range cases {
+		batch := fmt.Sprintf("%d of %d bytes", c.batch, c.memory)
+		hostMajor := generate(orderingHostMajor, c.batch, c.memory)
+
+		// each host's points come in one run, in time order
+		seen := make(map[string]bool)
+		for i, line := range hostMajor {
+			name := hostname(line)
+			if i > 0 && name == hostname(hostMajor[i-1]) {
+				continue
+			}
+			if seen[name] {
+				t.Errorf("batch %s: points of %s are not contiguous", batch, name)
+			}
+			seen[name] = true
+		}
+
+		got := append([]string{}, hostMajor...)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("batch %s: host-major points differ from time-major points: got %d points want %d", batch, len(got), len(want))
+		}
+	}
+}
+
+func TestDataGeneratorGenerateHostMajorChecksum(t *testing.T) {
+	// The checksum is that of the points written, whatever the hosts dropped
+	// from the passes over the simulation
+	checksum := func(ordering string, memory uint64) string {
+		c := testHostMajorConfig(ordering, 0, memory)
+		c.Checksum = true
+		var out, debugOut bytes.Buffer
+		dg := &DataGenerator{Out: &out, DebugOut: &debugOut}
+		if err := dg.Generate(c); err != nil {
+			t.Fatalf("unexpected error generating %s: %v", ordering, err)
+		}
+		return debugOut.String()
+	}
+	want := checksum(orderingTimeMajor, 0)
+	for _, memory := range []uint64{1 << 30, 200000, 1} {
+		if got := checksum(orderingHostMajor, memory); got != want {
+			t.Errorf("%d bytes: incorrect host-major checksum: got %s want %s", memory, got, want)
+		}
+	}
+}
+
+func TestDataGeneratorRunHostMajorPasses(t *testing.T) {
+	cases := []struct {
+		desc       string
+		batch      uint64
+		memory     uint64
+		wantPasses int
+	}{
+		{desc: "all hosts fit", memory: 1 << 30, wantPasses: 1},
+		{desc: "batch of 2", batch: 2, memory: 1 << 30, wantPasses: 3},
+		{desc: "host per pass", memory: 1, wantPasses: 5},
+		{desc: "some hosts fit", memory: 200000, wantPasses: 3},
+	}
+	for _, c := range cases {
+		g := &DataGenerator{Out: &bytes.Buffer{}}
+		if err := g.init(testHostMajorConfig(orderingHostMajor, c.batch, c.memory)); err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.desc, err)
+		}
+		rand.Seed(g.config.Seed)
+		scfg, err := g.getSimulatorConfig(g.config)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.desc, err)
+		}
+		sim := scfg.NewSimulator(g.config.LogInterval, g.config.Limit)
+		serializer, err := g.getSerializer(sim, g.config.Format)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.desc, err)
+		}
+		passes := 1
+		newSim := func() common.Simulator {
+			passes++
+			rand.Seed(g.config.Seed)
+			return scfg.NewSimulator(g.config.LogInterval, g.config.Limit)
+		}
+		if err := g.runHostMajor(sim, newSim, serializer, nil); err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.desc, err)
+		}
+		if passes != c.wantPasses {
+			t.Errorf("%s: incorrect passes over the simulation: got %d want %d", c.desc, passes, c.wantPasses)
+		}
+	}
+}
+
+func TestDataGeneratorGenerateFlushError(t *testing.T) {
+	// The points all fit in the buffer, so they are only written when flushed
+	for _, ordering := range []string{orderingTimeMajor, orderingHostMajor} {
+		g := &DataGenerator{Out: &badWriter{}}
+		err := g.Generate(testHostMajorConfig(ordering, 0, 1<<30))
+		if want := "can not flush output: error writing"; err == nil || err.Error() != want {
+			t.Errorf("%s: incorrect error: got %v want %s", ordering, err, want)
+		}
+	}
+}
+
+func TestDataGeneratorGenerateWorkers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "workers")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		desc   string
+		config func(c *DataGeneratorConfig)
+	}{
+		{desc: "influx", config: func(c *DataGeneratorConfig) {}},
+		{desc: "timescaledb", config: func(c *DataGeneratorConfig) { c.Format = FormatTimescaleDB }},
+		{desc: "timescaledb binary", config: func(c *DataGeneratorConfig) {
+			c.Format = FormatTimescaleDB
+			c.PGBinary = true
+		}},
+		{desc: "cassandra", config: func(c *DataGeneratorConfig) { c.Format = FormatCassandra }},
+		{desc: "late points", config: func(c *DataGeneratorConfig) { c.MaxLateness = time.Minute }},
+		{desc: "interleaved group", config: func(c *DataGeneratorConfig) {
+			c.InterleavedGroupID = 1
+			c.InterleavedNumGroups = 3
+		}},
+		{desc: "checksum", config: func(c *DataGeneratorConfig) { c.Checksum = true }},
+	}
+
+	generate := func(desc string, workers uint, config func(c *DataGeneratorConfig)) (string, string) {
+		c := &DataGeneratorConfig{
+			BaseConfig: BaseConfig{
+				Seed:      123,
+				Format:    FormatInflux,
+				Use:       useCaseDevops,
+				Scale:     10,
+				TimeStart: defaultTimeStart,
+				TimeEnd:   "2016-01-01T01:00:00Z",
+			},
+			LogInterval:          10 * time.Second,
+			InterleavedNumGroups: 1,
+			Workers:              workers,
+		}
+		config(c)
+		var out, debug bytes.Buffer
+		dg := &DataGenerator{Out: &out, DebugOut: &debug}
+		if err := dg.Generate(c); err != nil {
+			t.Fatalf("%s: unexpected error with %d workers: %v", desc, workers, err)
+		}
+		return out.String(), debug.String()
+	}
+
+	for _, c := range cases {
+		want, wantDebug := generate(c.desc, 1, c.config)
+		if len(want) == 0 {
+			t.Fatalf("%s: no output", c.desc)
+		}
+		for _, workers := range []uint{2, 4} {
+			got, gotDebug := generate(c.desc, workers, c.config)
+			if got != want {
+				t.Errorf("%s: output with %d workers differs from a single one", c.desc, workers)
+			}
+			if gotDebug != wantDebug {
+				t.Errorf("%s: debug output with %d workers differs from a single one: got\n%s\nwant\n%s", c.desc, workers, gotDebug, wantDebug)
+			}
+		}
+	}
+
+	// Files are rotated at the same points
+	readFiles := func(workers uint) []string {
+		c := &DataGeneratorConfig{
+			BaseConfig: BaseConfig{
+				Seed:      123,
+				Format:    FormatClickhouse,
+				Use:       useCaseCPUOnly,
+				Scale:     10,
+				TimeStart: defaultTimeStart,
+				TimeEnd:   "2016-01-01T01:00:00Z",
+			},
+			LogInterval:          10 * time.Second,
+			InterleavedNumGroups: 1,
+			Workers:              workers,
+			FileSizeLimit:        20000,
+		}
+		c.File = filepath.Join(dir, fmt.Sprintf("data%d", workers))
+		dg := &DataGenerator{}
+		if err := dg.Generate(c); err != nil {
+			t.Fatalf("unexpected error rotating files with %d workers: %v", workers, err)
+		}
+		var files []string
+		for i := 0; ; i++ {
+			data, err := ioutil.ReadFile(chunkName(c.File, i))
+			if os.IsNotExist(err) {
+				break
+			} else if err != nil {
+				t.Fatalf("could not read chunk %d: %v", i, err)
+			}
+			files = append(files, string(data))
+		}
+		return files
+	}
+	want := readFiles(1)
+	if len(want) < 2 {
+		t.Fatalf("expected output to be split into several files, got %d", len(want))
+	}
+	if got := readFiles(4); !reflect.DeepEqual(got, want) {
+		t.Errorf("rotated files with 4 workers differ from a single one: got %d files want %d", len(got), len(want))
+	}
+}
+
+func BenchmarkDataGeneratorGenerateWorkers(b *testing.B) {
+	for _, workers := range []uint{1, 2, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				c := &DataGeneratorConfig{
+					BaseConfig: BaseConfig{
+						Seed:      123,
+						Format:    FormatTimescaleDB,
+						Use:       useCaseCPUOnly,
+						Scale:     4000,
+						TimeStart: defaultTimeStart,
+						TimeEnd:   "2016-01-01T00:05:00Z",
+					},
+					LogInterval:          10 * time.Second,
+					InterleavedNumGroups: 1,
+					Workers:              workers,
+				}
+				dg := &DataGenerator{Out: ioutil.Discard}
+				if err := dg.Generate(c); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestDataGeneratorGenerateIntegerFields(t *testing.T) {
+	c := &DataGeneratorConfig{
+		BaseConfig: BaseConfig{
+			Seed:      123,
+			Limit:     1,
+			Format:    FormatClickhouse,
+			Use:       useCaseDevops,
+			Scale:     1,
+			TimeStart: defaultTimeStart,
+			TimeEnd:   defaultTimeEnd,
+		},
+		LogInterval:          time.Second,
+		InterleavedNumGroups: 1,
+		IntegerFields:        true,
+	}
+	var buf bytes.Buffer
+	dg := &DataGenerator{Out: &buf}
+	err := dg.Generate(c)
+	if err != nil {
+		t.Fatalf("unexpected error when generating: got %v", err)
+	}
+
+	header := make(map[string]string)
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if len(line) == 0 {
+			break
+		}
+		header[strings.SplitN(line, ",", 2)[0]] = line
+	}
+	wantHeader := map[string]string{
+		"cpu":   "cpu,usage_user:int64,usage_system:int64,usage_idle:int64,usage_nice:int64,usage_iowait:int64,usage_irq:int64,usage_softirq:int64,usage_steal:int64,usage_guest:int64,usage_guest_nice:int64",
+		"mem":   "mem,total:uint64,available:uint64,used:uint64,free:uint64,cached:uint64,buffered:uint64,used_percent,available_percent,buffered_percent",
+		"nginx": "nginx,accepts:uint64,active:int64,handled:uint64,reading:int64,requests:uint64,waiting:int64,writing:int64",
+	}
+	for name, want := range wantHeader {
+		if got := header[name]; got != want {
+			t.Errorf("incorrect header for %s:\ngot\n%s\nwant\n%s", name, got, want)
+		}
+	}
+}
+
+func TestDataGeneratorGenerateExtraTags(t *testing.T) {
+	cases := []struct {
+		desc  string
+		count uint64
+	}{
+		{desc: "no extra tags", count: 0},
+		{desc: "many extra tags", count: 40},
+	}
+	for _, c := range cases {
+		cfg := &DataGeneratorConfig{
+			BaseConfig: BaseConfig{
+				Seed:      123,
+				Limit:     1,
+				Format:    FormatClickhouse,
+				Use:       useCaseCPUOnly,
+				Scale:     1,
+				TimeStart: defaultTimeStart,
+				TimeEnd:   defaultTimeEnd,
+			},
+			LogInterval:          time.Second,
+			InterleavedNumGroups: 1,
+			ExtraTagCount:        c.count,
+			ExtraTagCardinality:  1000000,
+		}
+		var buf bytes.Buffer
+		dg := &DataGenerator{Out: &buf}
+		err := dg.Generate(cfg)
+		if err != nil {
+			t.Fatalf("%s: unexpected error when generating: got %v", c.desc, err)
+		}
+
+		lines := strings.Split(buf.String(), "\n")
+		want := "tags," + string(bytes.Join(devops.MachineTagKeys, []byte(",")))
+		for i := uint64(0); i < c.count; i++ {
+			want += fmt.Sprintf(",extra_tag_%d", i)
+		}
+		if got := lines[0]; got != want {
+			t.Errorf("%s: incorrect tags header:\ngot\n%s\nwant\n%s", c.desc, got, want)
+		}
+		// header, measurement line and blank line, then the point's tags
+		if got, want := len(strings.Split(lines[3], ",")), len(devops.MachineTagKeys)+int(c.count)+1; got != want {
+			t.Errorf("%s: incorrect number of tags in point: got %d want %d", c.desc, got, want)
+		}
+	}
+}
+
+func TestDataGeneratorGenerateAnomalyManifest(t *testing.T) {
+	f, err := ioutil.TempFile("", "anomalies")
+	if err != nil {
+		t.Fatalf("could not create temp file: %v", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	c := &DataGeneratorConfig{
+		BaseConfig: BaseConfig{
+			Seed:      123,
+			Format:    FormatTimescaleDB,
+			Use:       useCaseCPUOnly,
+			Scale:     10,
+			TimeStart: defaultTimeStart,
+			TimeEnd:   "2016-01-01T01:00:00Z",
+		},
+		LogInterval:          10 * time.Second,
+		InterleavedNumGroups: 1,
+		AnomalyProbability:   0.01,
+		AnomalyDuration:      time.Minute,
+		AnomalyManifest:      f.Name(),
+	}
+	dg := &DataGenerator{Out: ioutil.Discard}
+	err = dg.Generate(c)
+	if err != nil {
+		t.Fatalf("unexpected error when generating: got %v", err)
+	}
+
+	contents, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatalf("could not read manifest: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	if got, want := lines[0], "hostname,measurement,start,end"; got != want {
+		t.Errorf("incorrect manifest header: got %s want %s", got, want)
+	}
+	if len(lines) < 2 {
+		t.Fatalf("no anomalies in manifest")
+	}
+	if got := len(strings.Split(lines[1], ",")); got != 4 {
+		t.Errorf("incorrect number of manifest columns: got %d want 4", got)
+	}
+}
+
+func TestDataGeneratorGenerateSchemaFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "schema")
+	if err != nil {
+		t.Fatalf("could not create temp file: %v", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	c := &DataGeneratorConfig{
+		BaseConfig: BaseConfig{
+			Seed:      123,
+			Format:    FormatClickhouse,
+			Use:       useCaseDevops,
+			Scale:     2,
+			TimeStart: defaultTimeStart,
+			TimeEnd:   "2016-01-01T00:01:00Z",
+		},
+		LogInterval:          10 * time.Second,
+		InterleavedNumGroups: 1,
+		IntegerFields:        true,
+		SchemaFile:           f.Name(),
+	}
+	var buf bytes.Buffer
+	dg := &DataGenerator{Out: &buf}
+	err = dg.Generate(c)
+	if err != nil {
+		t.Fatalf("unexpected error when generating: got %v", err)
+	}
+
+	file, err := os.Open(f.Name())
+	if err != nil {
+		t.Fatalf("could not open schema: %v", err)
+	}
+	defer file.Close()
+	schema, err := serialize.ReadSchema(file)
+	if err != nil {
+		t.Fatalf("could not read schema: %v", err)
+	}
+	if got, want := strings.Join(schema.Tags, ","), string(bytes.Join(devops.MachineTagKeys, []byte(","))); got != want {
+		t.Errorf("incorrect schema tags: got %s want %s", got, want)
+	}
+	// The sidecar must describe exactly what the in-band header does
+	header := schema.Header()
+	if got := buf.Bytes(); !bytes.HasPrefix(got, header) {
+		t.Errorf("schema does not match data header: got\n%s\nwant prefix\n%s", got[:len(header)], header)
+	}
+	found := false
+	for _, m := range schema.Measurements {
+		for _, col := range m.Columns {
+			if m.Name == "disk" && col.Name == "total" {
+				found = true
+				if col.Type != devops.FieldTypeUInt64 {
+					t.Errorf("incorrect type of disk total: got %s want %s", col.Type, devops.FieldTypeUInt64)
+				}
+			}
+		}
+	}
+	if !found {
+		t.Errorf("disk total missing from schema")
+	}
+}
+
+func TestDataGeneratorGenerateChecksum(t *testing.T) {
+	generate := func(groupID, groups uint) (string, *serialize.Checksum) {
+		c := &DataGeneratorConfig{
+			BaseConfig: BaseConfig{
+				Seed:      123,
+				Format:    FormatClickhouse,
+				Use:       useCaseDevops,
+				Scale:     3,
+				TimeStart: defaultTimeStart,
+				TimeEnd:   "2016-01-01T00:10:00Z",
+			},
+			LogInterval:          10 * time.Second,
+			InterleavedGroupID:   groupID,
+			InterleavedNumGroups: groups,
+			Checksum:             true,
+		}
+		var out, debug bytes.Buffer
+		dg := &DataGenerator{Out: &out, DebugOut: &debug}
+		if err := dg.Generate(c); err != nil {
+			t.Fatalf("unexpected error when generating: got %v", err)
+		}
+		sum, err := serialize.ReadChecksum(&debug)
+		if err != nil {
+			t.Fatalf("could not read checksum: %v", err)
+		}
+		return out.String(), sum
+	}
+	// recompute sums up the points of data, skipping its header
+	recompute := func(data string) *serialize.Checksum {
+		sum := serialize.NewChecksum(0, 1)
+		lines := strings.Split(strings.SplitN(data, "\n\n", 2)[1], "\n")
+		for i := 0; i+1 < len(lines); i += 2 {
+			measurement := strings.SplitN(lines[i+1], ",", 2)[0]
+			sum.AddPoint(measurement, []byte(lines[i]+"\n"+lines[i+1]+"\n"))
+		}
+		return sum
+	}
+
+	data, sum := generate(0, 1)
+	data2, sum2 := generate(0, 1)
+	if data != data2 || !reflect.DeepEqual(sum, sum2) {
+		t.Errorf("checksum differs between runs: got %v and %v", sum, sum2)
+	}
+	if got := sum.Points["cpu"]; got != 3*60 {
+		t.Errorf("incorrect number of cpu points: got %d want %d", got, 3*60)
+	}
+	if err := recompute(data).Check(sum); err != nil {
+		t.Errorf("checksum does not match data: %v", err)
+	}
+
+	// Change a single byte of the last point
+	changed := []byte(data)
+	i := len(changed) - 2
+	if changed[i] == '1' {
+		changed[i] = '2'
+	} else {
+		changed[i] = '1'
+	}
+	if err := recompute(string(changed)).Check(sum); err == nil {
+		t.Errorf("checksum matches data with a changed byte")
+	}
+
+	// Groups together make up the whole data
+	_, group0 := generate(0, 2)
+	_, group1 := generate(1, 2)
+	if group0.Group != 0 || group1.Group != 1 || group1.Groups != 2 {
+		t.Errorf("incorrect groups in checksums: got %d/%d and %d/%d", group0.Group, group0.Groups, group1.Group, group1.Groups)
+	}
+	for m, n := range group1.Points {
+		group0.Points[m] += n
+	}
+	group0.Bytes += group1.Bytes
+	group0.Hash += group1.Hash
+	if err := group0.Check(sum); err != nil {
+		t.Errorf("checksums of groups do not add up to that of all data: %v", err)
+	}
+}
+
+// BenchmarkDataGeneratorFloatFields shows the cost of formatting float fields,
+// run on points of floats only as the use cases all generate integers
+func BenchmarkDataGeneratorFloatFields(b *testing.B) {
+	for _, precision := range []int{0, 6} {
+		b.Run(fmt.Sprintf("float-precision=%d", precision), func(b *testing.B) {
+			dgc := &DataGeneratorConfig{
+				BaseConfig: BaseConfig{
+					Scale:  1,
+					Format: FormatClickhouse,
+				},
+				InitialScale:         1,
+				LogInterval:          defaultLogInterval,
+				InterleavedNumGroups: 1,
+				FloatPrecision:       precision,
+			}
+			g := &DataGenerator{
+				config: dgc,
+				bufOut: bufio.NewWriter(ioutil.Discard),
+			}
+			serializer, err := g.newSerializer(dgc.Format)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				sim := newTestFloatSimulator(1000)
+				if err := g.runSimulator(sim, serializer, dgc); err != nil {
+					b.Fatalf("unexpected error when generating: got %v", err)
+				}
+			}
+		})
+	}
+}
+
+var testFloatFieldKeys = [][]byte{
+	[]byte("usage_user"), []byte("usage_system"), []byte("usage_idle"), []byte("usage_nice"), []byte("usage_iowait"),
+	[]byte("usage_irq"), []byte("usage_softirq"), []byte("usage_steal"), []byte("usage_guest"), []byte("usage_guest_nice"),
+}
+
+// testFloatSimulator simulates limit points of cpu with float fields of
+// random values
+type testFloatSimulator struct {
+	limit     uint64
+	iteration uint64
+	values    []float64
+	timestamp time.Time
+}
+
+func newTestFloatSimulator(limit uint64) *testFloatSimulator {
+	r := rand.New(rand.NewSource(123))
+	values := make([]float64, 1024)
+	for i := range values {
+		values[i] = 100 * r.Float64()
+	}
+	return &testFloatSimulator{limit: limit, values: values, timestamp: time.Unix(1451606400, 0)}
+}
+
+func (s *testFloatSimulator) Finished() bool {
+	return s.iteration >= s.limit
+}
+
+func (s *testFloatSimulator) Next(p *serialize.Point) bool {
+	p.SetMeasurementName([]byte("cpu"))
+	p.SetTimestamp(&s.timestamp)
+	p.AppendTag([]byte("hostname"), []byte("host_0"))
+	for i, key := range testFloatFieldKeys {
+		p.AppendField(key, s.values[(int(s.iteration)+i)%len(s.values)])
+	}
+	s.iteration++
+	return true
+}
+
+func (s *testFloatSimulator) Fields() map[string][][]byte {
+	return map[string][][]byte{"cpu": testFloatFieldKeys}
+}
+
+func (s *testFloatSimulator) TagKeys() [][]byte {
+	return [][]byte{[]byte("hostname")}
+}
+
 var keyIteration = []byte("iteration")
 
 type testSimulator struct {
@@ -370,6 +1620,33 @@ func TestRunSimulator(t *testing.T) {
 	}
 }
 
+func TestFieldTypeSerializer(t *testing.T) {
+	var buf bytes.Buffer
+	s := &fieldTypeSerializer{PointSerializer: &serialize.InfluxSerializer{}, checker: serialize.NewFieldTypeChecker()}
+	now := time.Unix(0, 0)
+	p := serialize.NewPoint()
+	p.SetMeasurementName([]byte("app"))
+	p.SetTimestamp(&now)
+	p.AppendFieldString([]byte("status"), []byte("ok"))
+	if err := s.Serialize(p, &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	p.Reset()
+	p.SetMeasurementName([]byte("app"))
+	p.SetTimestamp(&now)
+	p.AppendFieldFloat64([]byte("status"), 1)
+	err := s.Serialize(p, &buf)
+	if err == nil {
+		t.Fatalf("no error for a field changing type")
+	} else if !strings.Contains(err.Error(), "'status'") {
+		t.Errorf("error does not name the field: %v", err)
+	}
+	if got := strings.Count(buf.String(), "\n"); got != 1 {
+		t.Errorf("incorrect number of points written: got %d want 1", got)
+	}
+}
+
 func TestGetSimulatorConfig(t *testing.T) {
 	dgc := &DataGeneratorConfig{
 		BaseConfig: BaseConfig{
@@ -403,6 +1680,53 @@ func TestGetSimulatorConfig(t *testing.T) {
 	}
 }
 
+func TestGetSimulatorConfigHostFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "hosts")
+	if err != nil {
+		t.Fatalf("could not create temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("hostname,region,datacenter,rack,os,arch,team,service,service_version,service_environment\n")
+	f.WriteString("web01,us-east-1,us-east-1a,1,Ubuntu16.04LTS,x64,SF,1,0,production\n")
+	f.Close()
+
+	dgc := &DataGeneratorConfig{
+		BaseConfig: BaseConfig{
+			Use:   useCaseCPUOnly,
+			Scale: 2,
+		},
+		InitialScale: 2,
+		LogInterval:  defaultLogInterval,
+		HostFile:     f.Name(),
+	}
+	g := &DataGenerator{config: dgc}
+
+	_, err = g.getSimulatorConfig(dgc)
+	if err == nil {
+		t.Errorf("unexpected lack of error for host file shorter than scale")
+	} else if got, want := err.Error(), fmt.Sprintf(errHostFileShortFmt, f.Name(), 1, 2); got != want {
+		t.Errorf("incorrect error for short host file: got\n%s\nwant\n%s", got, want)
+	}
+
+	dgc.HostFileCycle = true
+	scfg, err := g.getSimulatorConfig(dgc)
+	if err != nil {
+		t.Fatalf("unexpected error for cycled host file: %v", err)
+	}
+	if scfg.(*devops.CPUOnlySimulatorConfig).TagSource == nil {
+		t.Errorf("tag source not set from host file")
+	}
+
+	dgc.HostFile = ""
+	scfg, err = g.getSimulatorConfig(dgc)
+	if err != nil {
+		t.Fatalf("unexpected error without host file: %v", err)
+	}
+	if scfg.(*devops.CPUOnlySimulatorConfig).TagSource != nil {
+		t.Errorf("tag source set without host file")
+	}
+}
+
 func TestGetSerializer(t *testing.T) {
 	dgc := &DataGeneratorConfig{
 		BaseConfig: BaseConfig{
@@ -449,4 +1773,26 @@ func TestGetSerializer(t *testing.T) {
 	if err == nil {
 		t.Errorf("unexpected lack of error creating bogus serializer")
 	}
+
+	dgc.CassandraBucket = "1h"
+	checkType(FormatCassandra, &serialize.CassandraSerializer{})
+	if got, want := string(g.header), "#layout bucket=1h0m0s key=series,field,bucket\n"; got != want {
+		t.Errorf("incorrect cassandra layout header: got %q want %q", got, want)
+	}
+	dgc.CassandraBucket = ""
+
+	dgc.PGBinary = true
+	checkType(FormatTimescaleDB, &serialize.TimescaleDBBinarySerializer{})
+	if !bytes.HasSuffix(g.header, serialize.PGCopyHeader()) {
+		t.Errorf("header does not end with the binary COPY header: %q", g.header)
+	}
+	dgc.PGBinary = false
+
+	dgc.TimestampPrecision = serialize.PrecisionMilliseconds
+	s, err := g.getSerializer(sim, FormatInflux)
+	if err != nil {
+		t.Errorf("unexpected error making serializer with precision: %v", err)
+	} else if got := s.(*serialize.InfluxSerializer).TimestampUnit; got != time.Millisecond {
+		t.Errorf("incorrect timestamp unit: got %v want %v", got, time.Millisecond)
+	}
 }
@@ -99,6 +99,8 @@ type QueryGenerator struct {
 	// bufOut represents the buffered writer that should actually be passed to
 	// any operations that write out data.
 	bufOut *bufio.Writer
+	// outFile is the file bufOut writes to, if any
+	outFile io.Closer
 }
 
 // NewQueryGenerator returns a QueryGenerator that is set up to work with a given
@@ -123,7 +125,13 @@ func (g *QueryGenerator) Generate(config GeneratorConfig) error {
 
 	filler := g.useCaseMatrix[g.config.Use][g.config.QueryType](useGen)
 
-	return g.runQueryGeneration(useGen, filler, g.config)
+	err = g.runQueryGeneration(useGen, filler, g.config)
+	if g.outFile != nil {
+		if cerr := g.outFile.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("cannot close output file %s: %v", g.config.File, cerr)
+		}
+	}
+	return err
 }
 
 func (g *QueryGenerator) init(config GeneratorConfig) error {
@@ -162,7 +170,7 @@ func (g *QueryGenerator) init(config GeneratorConfig) error {
 	if g.Out == nil {
 		g.Out = os.Stdout
 	}
-	g.bufOut, err = getBufferedWriter(g.config.File, g.Out)
+	g.bufOut, g.outFile, err = getBufferedWriter(g.config.File, g.Out)
 	if err != nil {
 		return err
 	}
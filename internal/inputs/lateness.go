@@ -0,0 +1,127 @@
+package inputs
+
+import (
+	"container/heap"
+	"io"
+	"math/rand"
+	"time"
+
+	"github.com/timescale/tsbs/cmd/tsbs_generate_data/serialize"
+)
+
+// latePoint is a buffered Point along with the time it should be released
+type latePoint struct {
+	point   *serialize.Point
+	release time.Time
+	seq     uint64
+}
+
+// latePointHeap is a min-heap of latePoints ordered by release time. Ties are
+// broken by arrival order so the output is deterministic.
+type latePointHeap []*latePoint
+
+func (h latePointHeap) Len() int { return len(h) }
+func (h latePointHeap) Less(i, j int) bool {
+	if h[i].release.Equal(h[j].release) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].release.Before(h[j].release)
+}
+func (h latePointHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *latePointHeap) Push(x interface{}) { *h = append(*h, x.(*latePoint)) }
+func (h *latePointHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return x
+}
+
+// latenessBuffer delays Points by a random amount of time, bounded by
+// maxLateness, before handing them to a serializer. Since every Point is
+// released no later than maxLateness after its own timestamp, no Point ends up
+// displaced by more than maxLateness relative to the Points around it.
+type latenessBuffer struct {
+	maxLateness time.Duration
+	rng         *rand.Rand
+	serializer  serialize.PointSerializer
+	w           io.Writer
+
+	pending latePointHeap
+	free    []*latePoint
+	seq     uint64
+}
+
+func newLatenessBuffer(maxLateness time.Duration, seed int64, serializer serialize.PointSerializer, w io.Writer) *latenessBuffer {
+	return &latenessBuffer{
+		maxLateness: maxLateness,
+		rng:         rand.New(rand.NewSource(seed)),
+		serializer:  serializer,
+		w:           w,
+	}
+}
+
+// Add buffers a copy of p and serializes any buffered Points whose release
+// time is not after p's timestamp. Points are expected to be added in
+// non-decreasing timestamp order.
+func (b *latenessBuffer) Add(p *serialize.Point) error {
+	now := *p.Timestamp()
+	if err := b.releaseUntil(now); err != nil {
+		return err
+	}
+
+	var lp *latePoint
+	if n := len(b.free); n > 0 {
+		lp = b.free[n-1]
+		b.free = b.free[:n-1]
+	} else {
+		lp = &latePoint{point: serialize.NewPoint()}
+	}
+	lp.point.Copy(p)
+	lp.release = now.Add(b.delay())
+	lp.seq = b.seq
+	b.seq++
+	heap.Push(&b.pending, lp)
+	return nil
+}
+
+// Skip accounts for a Point that is not written, e.g., one belonging to
+// another interleaved group, by consuming the randomness Add would have. This
+// keeps the delays of the Points that are added the same no matter which ones
+// are skipped.
+func (b *latenessBuffer) Skip() {
+	b.delay()
+	b.seq++
+}
+
+func (b *latenessBuffer) delay() time.Duration {
+	return time.Duration(b.rng.Int63n(int64(b.maxLateness) + 1))
+}
+
+// Flush serializes all remaining buffered Points.
+func (b *latenessBuffer) Flush() error {
+	for b.pending.Len() > 0 {
+		if err := b.release(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *latenessBuffer) releaseUntil(t time.Time) error {
+	for b.pending.Len() > 0 && !b.pending[0].release.After(t) {
+		if err := b.release(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *latenessBuffer) release() error {
+	lp := heap.Pop(&b.pending).(*latePoint)
+	err := b.serializer.Serialize(lp.point, b.w)
+	lp.point.Reset()
+	b.free = append(b.free, lp)
+	return err
+}
@@ -0,0 +1,125 @@
+package inputs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/timescale/tsbs/cmd/tsbs_generate_data/serialize"
+)
+
+// timedSimulator emits one point per interval, sharing a single timestamp
+// between calls to Next the same way the devops simulator does.
+type timedSimulator struct {
+	limit    uint64
+	interval time.Duration
+	made     uint64
+	now      time.Time
+}
+
+func (s *timedSimulator) Finished() bool {
+	return s.made >= s.limit
+}
+
+func (s *timedSimulator) Next(p *serialize.Point) bool {
+	s.now = time.Unix(0, 0).Add(time.Duration(s.made) * s.interval)
+	p.SetTimestamp(&s.now)
+	p.AppendField(keyIteration, s.made)
+	s.made++
+	return true
+}
+
+func (s *timedSimulator) Fields() map[string][][]byte {
+	return nil
+}
+
+func (s *timedSimulator) TagKeys() [][]byte {
+	return nil
+}
+
+type timedSerializer struct{}
+
+func (s *timedSerializer) Serialize(p *serialize.Point, w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%d,%d\n", p.GetFieldValue(keyIteration).(uint64), p.Timestamp().UnixNano())
+	return err
+}
+
+func runLateness(t *testing.T, limit uint64, maxLateness time.Duration, seed int64) []string {
+	var buf bytes.Buffer
+	dgc := &DataGeneratorConfig{
+		BaseConfig: BaseConfig{
+			Scale: 1,
+			Limit: limit,
+			Seed:  seed,
+		},
+		InitialScale:         1,
+		LogInterval:          time.Second,
+		InterleavedNumGroups: 1,
+		MaxLateness:          maxLateness,
+	}
+	g := &DataGenerator{
+		config: dgc,
+		bufOut: bufio.NewWriter(&buf),
+	}
+	sim := &timedSimulator{limit: limit, interval: time.Second}
+	if err := g.runSimulator(sim, &timedSerializer{}, dgc); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return strings.Split(strings.TrimSpace(buf.String()), "\n")
+}
+
+func TestRunSimulatorMaxLateness(t *testing.T) {
+	const limit = 1000
+	maxLateness := 30 * time.Second
+
+	lines := runLateness(t, limit, maxLateness, 123)
+	if got := len(lines); got != limit {
+		t.Fatalf("incorrect number of points: got %d want %d", got, limit)
+	}
+
+	seen := make(map[string]bool)
+	outOfOrder := 0
+	maxSeen := int64(-1)
+	for _, line := range lines {
+		if seen[line] {
+			t.Errorf("duplicate point: %s", line)
+		}
+		seen[line] = true
+
+		ts, err := strconv.ParseInt(strings.Split(line, ",")[1], 10, 64)
+		if err != nil {
+			t.Fatalf("could not parse timestamp from %s: %v", line, err)
+		}
+		if ts < maxSeen {
+			outOfOrder++
+			if displaced := time.Duration(maxSeen - ts); displaced > maxLateness {
+				t.Errorf("point displaced too far: got %v want <= %v", displaced, maxLateness)
+			}
+		} else {
+			maxSeen = ts
+		}
+	}
+	if outOfOrder == 0 {
+		t.Errorf("no points written out of order")
+	}
+
+	again := runLateness(t, limit, maxLateness, 123)
+	if strings.Join(again, "\n") != strings.Join(lines, "\n") {
+		t.Errorf("output not deterministic for same seed")
+	}
+}
+
+func TestRunSimulatorNoLateness(t *testing.T) {
+	lines := runLateness(t, 100, 0, 123)
+	for i, line := range lines {
+		want := fmt.Sprintf("%d,%d", i, time.Duration(i)*time.Second)
+		if line != want {
+			t.Errorf("incorrect line: got %s want %s", line, want)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package inputs
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/timescale/tsbs/cmd/tsbs_generate_data/serialize"
+)
+
+// pointsPerChunk is the number of Points handed to a serializer goroutine at
+// a time, enough for the handoff to cost little next to serializing them
+const pointsPerChunk = 1000
+
+// pointChunk is a run of consecutive Points serialized by one goroutine
+type pointChunk struct {
+	seq    uint64
+	points []*serialize.Point
+	n      int
+
+	buf bytes.Buffer
+	// ends holds, for each Point serialized, where its output ends in buf
+	ends []int
+	// err is the error serializing the Point after the last one in ends
+	err error
+}
+
+// replaySerializer writes the output of a Point serialized beforehand, or
+// returns the error serializing it, so the serializers wrapping it behave as
+// if it was serialized there and then.
+type replaySerializer struct {
+	out []byte
+	err error
+}
+
+func (s *replaySerializer) Serialize(_ *serialize.Point, w io.Writer) error {
+	if s.err != nil {
+		return s.err
+	}
+	_, err := w.Write(s.out)
+	return err
+}
+
+// parallelSerializer serializes Points on a pool of goroutines, each with its
+// own serializer, while a single goroutine writes them out in the order they
+// were given. Points are copied and handed out in sequence-numbered chunks.
+// The writing goroutine passes each serialized Point through ordered, the
+// serializers wrapping replay (e.g., to check field types, sum up checksums
+// or rotate files), so the output is the same as with a single serializer.
+type parallelSerializer struct {
+	ordered serialize.PointSerializer
+	replay  *replaySerializer
+	w       io.Writer
+
+	serializers []serialize.PointSerializer
+	cur         *pointChunk
+	seq         uint64
+
+	free chan *pointChunk
+	work chan *pointChunk
+	done chan *pointChunk
+
+	workers sync.WaitGroup
+	written chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// newParallelSerializer returns a parallelSerializer running one goroutine
+// per serializer and writing to w. It must be started, with the serializers
+// wrapping its replay, before use.
+func newParallelSerializer(serializers []serialize.PointSerializer, w io.Writer) *parallelSerializer {
+	// Enough chunks for every goroutine to work on one while as many wait
+	// to be written
+	chunks := 2 * len(serializers)
+	s := &parallelSerializer{
+		replay:      &replaySerializer{},
+		w:           w,
+		serializers: serializers,
+		free:        make(chan *pointChunk, chunks),
+		work:        make(chan *pointChunk, chunks),
+		done:        make(chan *pointChunk, chunks),
+		written:     make(chan struct{}),
+	}
+	for i := 0; i < chunks; i++ {
+		s.free <- &pointChunk{points: make([]*serialize.Point, 0, pointsPerChunk)}
+	}
+	return s
+}
+
+// start launches the goroutines, writing each Point through ordered, which
+// must end with s.replay.
+func (s *parallelSerializer) start(ordered serialize.PointSerializer) {
+	s.ordered = ordered
+	s.workers.Add(len(s.serializers))
+	for _, ps := range s.serializers {
+		go s.serialize(ps)
+	}
+	go s.write()
+}
+
+// Serialize queues a copy of p to be serialized and written to the writer
+// given to newParallelSerializer, w being ignored. It returns the first error
+// serializing or writing the Points queued before, if any.
+func (s *parallelSerializer) Serialize(p *serialize.Point, _ io.Writer) error {
+	if err := s.firstErr(); err != nil {
+		return err
+	}
+	if s.cur == nil {
+		s.cur = <-s.free
+		s.cur.seq = s.seq
+		s.cur.n = 0
+		s.seq++
+	}
+	c := s.cur
+	if c.n == len(c.points) {
+		c.points = append(c.points, serialize.NewPoint())
+	}
+	c.points[c.n].Copy(p)
+	c.n++
+	if c.n == pointsPerChunk {
+		s.work <- c
+		s.cur = nil
+	}
+	return nil
+}
+
+// Close waits for all queued Points to be written, stopping the goroutines,
+// and returns the first error serializing or writing them, if any.
+func (s *parallelSerializer) Close() error {
+	if s.cur != nil {
+		s.work <- s.cur
+		s.cur = nil
+	}
+	close(s.work)
+	s.workers.Wait()
+	close(s.done)
+	<-s.written
+	return s.firstErr()
+}
+
+// serialize serializes the Points of each chunk of work with ps, stopping at
+// the first error
+func (s *parallelSerializer) serialize(ps serialize.PointSerializer) {
+	defer s.workers.Done()
+	for c := range s.work {
+		c.buf.Reset()
+		c.ends = c.ends[:0]
+		c.err = nil
+		for _, p := range c.points[:c.n] {
+			if err := ps.Serialize(p, &c.buf); err != nil {
+				c.err = err
+				break
+			}
+			c.ends = append(c.ends, c.buf.Len())
+		}
+		s.done <- c
+	}
+}
+
+// write writes serialized chunks in sequence, holding back those done ahead
+// of their turn. After an error, chunks are only returned to be reused.
+func (s *parallelSerializer) write() {
+	defer close(s.written)
+	pending := make(map[uint64]*pointChunk)
+	next := uint64(0)
+	for c := range s.done {
+		pending[c.seq] = c
+		for c, ok := pending[next]; ok; c, ok = pending[next] {
+			delete(pending, next)
+			next++
+			if s.firstErr() == nil {
+				if err := s.writeChunk(c); err != nil {
+					s.mu.Lock()
+					s.err = err
+					s.mu.Unlock()
+				}
+			}
+			s.free <- c
+		}
+	}
+}
+
+// writeChunk writes each serialized Point of c through s.ordered, up to the
+// one that could not be serialized, if any
+func (s *parallelSerializer) writeChunk(c *pointChunk) error {
+	out := c.buf.Bytes()
+	start := 0
+	for i, p := range c.points[:c.n] {
+		if i < len(c.ends) {
+			s.replay.out, s.replay.err = out[start:c.ends[i]], nil
+			start = c.ends[i]
+		} else {
+			s.replay.out, s.replay.err = nil, c.err
+		}
+		if err := s.ordered.Serialize(p, s.w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *parallelSerializer) firstErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
@@ -0,0 +1,86 @@
+package inputs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/timescale/tsbs/cmd/tsbs_generate_data/serialize"
+)
+
+// failingSerializer writes the measurement name of each Point on a line of
+// its own, failing for the one named fail
+type failingSerializer struct {
+	fail string
+}
+
+func (s *failingSerializer) Serialize(p *serialize.Point, w io.Writer) error {
+	if string(p.MeasurementName()) == s.fail {
+		return fmt.Errorf("cannot serialize %s", s.fail)
+	}
+	_, err := fmt.Fprintf(w, "%s\n", p.MeasurementName())
+	return err
+}
+
+func TestParallelSerializer(t *testing.T) {
+	const numPoints = 5*pointsPerChunk + 10
+	cases := []struct {
+		desc    string
+		workers int
+		fail    int
+	}{
+		{desc: "one worker", workers: 1, fail: -1},
+		{desc: "several workers", workers: 4, fail: -1},
+		{desc: "error in a later chunk", workers: 4, fail: 3*pointsPerChunk + 7},
+		{desc: "error in the last chunk", workers: 3, fail: numPoints - 1},
+	}
+
+	ts := time.Now()
+	for _, c := range cases {
+		fail := fmt.Sprintf("m%d", c.fail)
+		serializers := make([]serialize.PointSerializer, c.workers)
+		for i := range serializers {
+			serializers[i] = &failingSerializer{fail: fail}
+		}
+		var buf bytes.Buffer
+		ps := newParallelSerializer(serializers, &buf)
+		ps.start(ps.replay)
+
+		p := serialize.NewPoint()
+		var err error
+		for i := 0; i < numPoints && err == nil; i++ {
+			p.SetMeasurementName([]byte(fmt.Sprintf("m%d", i)))
+			p.SetTimestamp(&ts)
+			err = ps.Serialize(p, nil)
+			p.Reset()
+		}
+		if closeErr := ps.Close(); err == nil {
+			err = closeErr
+		}
+
+		written := c.fail
+		if c.fail < 0 {
+			written = numPoints
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", c.desc, err)
+			}
+		} else if err == nil || err.Error() != "cannot serialize "+fail {
+			t.Errorf("%s: incorrect error: got %v want cannot serialize %s", c.desc, err, fail)
+		}
+
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		if len(lines) != written {
+			t.Errorf("%s: incorrect number of points written: got %d want %d", c.desc, len(lines), written)
+			continue
+		}
+		for i, line := range lines {
+			if want := fmt.Sprintf("m%d", i); line != want {
+				t.Errorf("%s: incorrect point %d: got %s want %s", c.desc, i, line, want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package inputs
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// realtimeFlushInterval is the longest a paced generator holds on to output
+// before flushing it, so downstream consumers see data promptly.
+const realtimeFlushInterval = time.Second
+
+// parseRealtimeRate parses a speedup such as "1x" or "10x" (the trailing x is
+// optional). 0 or an empty string means no pacing.
+func parseRealtimeRate(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if len(s) == 0 {
+		return 0, nil
+	}
+	rate, err := strconv.ParseFloat(strings.TrimSuffix(strings.ToLower(s), "x"), 64)
+	if err != nil || rate < 0 {
+		return 0, fmt.Errorf(errRealtimeRateFmt, s)
+	}
+	return rate, nil
+}
+
+// realtimePacer delays output so that simulated timestamps advance at rate
+// times the speed of the wall clock, measured from the first point seen.
+type realtimePacer struct {
+	rate float64
+	w    *bufio.Writer
+
+	// now and sleep are swapped out in tests
+	now   func() time.Time
+	sleep func(time.Duration)
+
+	started   bool
+	simStart  time.Time
+	wallStart time.Time
+	lastFlush time.Time
+}
+
+func newRealtimePacer(rate float64, w *bufio.Writer) *realtimePacer {
+	return &realtimePacer{
+		rate:  rate,
+		w:     w,
+		now:   time.Now,
+		sleep: time.Sleep,
+	}
+}
+
+// Wait blocks until the wall clock catches up with simulated time ts, flushing
+// buffered output before sleeping and at least every realtimeFlushInterval.
+func (p *realtimePacer) Wait(ts time.Time) error {
+	now := p.now()
+	if !p.started {
+		p.started = true
+		p.simStart = ts
+		p.wallStart = now
+		p.lastFlush = now
+		return nil
+	}
+
+	due := p.wallStart.Add(time.Duration(float64(ts.Sub(p.simStart)) / p.rate))
+	if wait := due.Sub(now); wait > 0 {
+		if err := p.flush(now); err != nil {
+			return err
+		}
+		p.sleep(wait)
+		return nil
+	}
+	if now.Sub(p.lastFlush) >= realtimeFlushInterval {
+		return p.flush(now)
+	}
+	return nil
+}
+
+func (p *realtimePacer) flush(now time.Time) error {
+	p.lastFlush = now
+	return p.w.Flush()
+}
@@ -0,0 +1,114 @@
+package inputs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseRealtimeRate(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{in: "", want: 0},
+		{in: "0", want: 0},
+		{in: "1x", want: 1},
+		{in: "10X", want: 10},
+		{in: "0.5", want: 0.5},
+		{in: "x", wantErr: true},
+		{in: "fast", wantErr: true},
+		{in: "-1x", wantErr: true},
+	}
+	for _, c := range cases {
+		got, err := parseRealtimeRate(c.in)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("%q: unexpected lack of error", c.in)
+			} else if want := fmt.Sprintf(errRealtimeRateFmt, c.in); err.Error() != want {
+				t.Errorf("%q: incorrect error: got\n%s\nwant\n%s", c.in, err.Error(), want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", c.in, err)
+		} else if got != c.want {
+			t.Errorf("%q: incorrect rate: got %v want %v", c.in, got, c.want)
+		}
+	}
+}
+
+// fakeClock is a wall clock that only moves when slept on or advanced.
+type fakeClock struct {
+	now   time.Time
+	slept time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.slept += d
+	c.now = c.now.Add(d)
+}
+
+func TestRealtimePacer(t *testing.T) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	p := newRealtimePacer(10, w)
+	p.now = clock.Now
+	p.sleep = clock.Sleep
+
+	simStart := time.Unix(0, 0)
+	// 100 points 10s apart in simulated time is 99s at 10x
+	for i := 0; i < 100; i++ {
+		if err := p.Wait(simStart.Add(time.Duration(i) * 10 * time.Second)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		w.WriteString("x")
+		if i > 0 && buf.Len() != i {
+			t.Fatalf("output not flushed before sleeping: got %d bytes want %d", buf.Len(), i)
+		}
+	}
+	if want := 99 * time.Second; clock.slept != want {
+		t.Errorf("incorrect total sleep: got %v want %v", clock.slept, want)
+	}
+
+	// Points already due should not sleep again
+	before := clock.slept
+	if err := p.Wait(simStart.Add(500 * time.Second)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if clock.slept != before {
+		t.Errorf("unexpected sleep for a point already due: slept %v", clock.slept-before)
+	}
+}
+
+func TestRealtimePacerFlushesWhenBehind(t *testing.T) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	p := newRealtimePacer(1, w)
+	p.now = clock.Now
+	p.sleep = clock.Sleep
+
+	simStart := time.Unix(0, 0)
+	p.Wait(simStart)
+	w.WriteString("a")
+	// Falling behind by less than the flush interval keeps output buffered
+	clock.now = clock.now.Add(realtimeFlushInterval / 2)
+	p.Wait(simStart)
+	if buf.Len() != 0 {
+		t.Errorf("output flushed too early: got %d bytes", buf.Len())
+	}
+	clock.now = clock.now.Add(realtimeFlushInterval)
+	p.Wait(simStart)
+	if buf.Len() != 1 {
+		t.Errorf("output not flushed after flush interval: got %d bytes", buf.Len())
+	}
+	if clock.slept != 0 {
+		t.Errorf("unexpected sleep when behind: slept %v", clock.slept)
+	}
+}
@@ -0,0 +1,91 @@
+package inputs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/timescale/tsbs/cmd/tsbs_generate_data/serialize"
+)
+
+// rotatingFile is an io.Writer over a sequence of files named name.000,
+// name.001, ..., moving on to the next file when Rotate is called.
+type rotatingFile struct {
+	name    string
+	limit   int64
+	index   int
+	file    *os.File
+	written int64
+}
+
+func newRotatingFile(name string, limit int64) (*rotatingFile, error) {
+	f := &rotatingFile{name: name, limit: limit, index: -1}
+	if err := f.Rotate(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// chunkName returns the name of the i-th file written by a rotatingFile
+func chunkName(name string, i int) string {
+	return fmt.Sprintf("%s.%03d", name, i)
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	n, err := f.file.Write(p)
+	f.written += int64(n)
+	return n, err
+}
+
+// Rotate closes the current file, if any, and starts writing to the next one.
+func (f *rotatingFile) Rotate() error {
+	if err := f.Close(); err != nil {
+		return err
+	}
+	f.index++
+	filename := chunkName(f.name, f.index)
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("cannot open file for write %s: %v", filename, err)
+	}
+	f.file = file
+	f.written = 0
+	return nil
+}
+
+// Close syncs and closes the current file.
+func (f *rotatingFile) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := outputFile{f.file}.Close()
+	f.file = nil
+	return err
+}
+
+// rotatingSerializer wraps a PointSerializer so that, once the output file has
+// reached its size limit, the next Point starts a new file. Rotating only
+// between Points means a Point is never split across files, and repeating the
+// header keeps every file loadable on its own.
+type rotatingSerializer struct {
+	serialize.PointSerializer
+	out    *rotatingFile
+	buf    *bufio.Writer
+	header []byte
+}
+
+func (s *rotatingSerializer) Serialize(p *serialize.Point, w io.Writer) error {
+	if s.out.written+int64(s.buf.Buffered()) >= s.out.limit {
+		if err := s.buf.Flush(); err != nil {
+			return err
+		}
+		if err := s.out.Rotate(); err != nil {
+			return err
+		}
+		if _, err := s.buf.Write(s.header); err != nil {
+			return err
+		}
+	}
+	return s.PointSerializer.Serialize(p, w)
+}
@@ -0,0 +1,128 @@
+package inputs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "data")
+	f, err := newRotatingFile(name, 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	f.Write([]byte("first"))
+	if f.written != 5 {
+		t.Errorf("incorrect bytes written: got %d want %d", f.written, 5)
+	}
+	if err := f.Rotate(); err != nil {
+		t.Fatalf("unexpected error rotating: %v", err)
+	}
+	f.Write([]byte("second"))
+	if err := f.Close(); err != nil {
+		t.Fatalf("unexpected error closing: %v", err)
+	}
+
+	for i, want := range []string{"first", "second"} {
+		got, err := ioutil.ReadFile(chunkName(name, i))
+		if err != nil {
+			t.Errorf("could not read chunk %d: %v", i, err)
+		} else if string(got) != want {
+			t.Errorf("incorrect contents of chunk %d: got %s want %s", i, got, want)
+		}
+	}
+	if got := chunkName(name, 1); got != name+".001" {
+		t.Errorf("incorrect chunk name: got %s", got)
+	}
+}
+
+// splitHeader splits generated output at the blank line ending its header
+func splitHeader(t *testing.T, data string) (string, string) {
+	idx := strings.Index(data, "\n\n")
+	if idx < 0 {
+		t.Fatalf("no header found in:\n%s", data)
+	}
+	return data[:idx+2], data[idx+2:]
+}
+
+func TestDataGeneratorGenerateFileSizeLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	newConfig := func() *DataGeneratorConfig {
+		return &DataGeneratorConfig{
+			BaseConfig: BaseConfig{
+				Seed:      123,
+				Limit:     50,
+				Format:    FormatClickhouse,
+				Use:       useCaseCPUOnly,
+				Scale:     2,
+				TimeStart: defaultTimeStart,
+				TimeEnd:   defaultTimeEnd,
+			},
+			LogInterval:          time.Second,
+			InterleavedNumGroups: 1,
+		}
+	}
+
+	var buf bytes.Buffer
+	dg := &DataGenerator{Out: &buf}
+	if err := dg.Generate(newConfig()); err != nil {
+		t.Fatalf("unexpected error generating unrotated data: %v", err)
+	}
+	wantHeader, wantRows := splitHeader(t, buf.String())
+
+	c := newConfig()
+	c.File = filepath.Join(dir, "data")
+	c.FileSizeLimit = 1000
+	dg = &DataGenerator{}
+	if err := dg.Generate(c); err != nil {
+		t.Fatalf("unexpected error generating rotated data: %v", err)
+	}
+
+	var rows string
+	files := 0
+	for ; ; files++ {
+		data, err := ioutil.ReadFile(chunkName(c.File, files))
+		if os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			t.Fatalf("could not read chunk %d: %v", files, err)
+		}
+		header, chunkRows := splitHeader(t, string(data))
+		if header != wantHeader {
+			t.Errorf("incorrect header in chunk %d: got\n%s\nwant\n%s", files, header, wantHeader)
+		}
+		if len(chunkRows) == 0 {
+			t.Errorf("chunk %d has no data", files)
+		}
+		// every chunk should end on a complete point, i.e., a full fields line
+		if !strings.HasPrefix(chunkRows, "tags,") || !strings.HasSuffix(chunkRows, "\n") {
+			t.Errorf("chunk %d does not hold whole points:\n%s", files, chunkRows)
+		}
+		rows += chunkRows
+	}
+	if files < 2 {
+		t.Errorf("expected output to be split into several files, got %d", files)
+	}
+	if rows != wantRows {
+		t.Errorf("rotated rows do not match unrotated output:\ngot\n%s\nwant\n%s", rows, wantRows)
+	}
+	if _, err := os.Stat(c.File); !os.IsNotExist(err) {
+		t.Errorf("unexpected unsuffixed output file %s", c.File)
+	}
+}
@@ -69,17 +69,34 @@ func ParseUTCTime(s string) (time.Time, error) {
 
 const defaultWriteSize = 4 << 20 // 4 MB
 
-func getBufferedWriter(filename string, fallback io.Writer) (*bufio.Writer, error) {
+// outputFile is a file written by a generator, which is synced to disk when
+// closed so the output is complete once the generator returns.
+type outputFile struct {
+	*os.File
+}
+
+func (f outputFile) Close() error {
+	if err := f.File.Sync(); err != nil {
+		f.File.Close()
+		return err
+	}
+	return f.File.Close()
+}
+
+// getBufferedWriter returns a buffered writer to filename or, if no filename
+// is given, fallback. The returned io.Closer closes the file after the writer
+// is flushed, and is nil when writing to fallback.
+func getBufferedWriter(filename string, fallback io.Writer) (*bufio.Writer, io.Closer, error) {
 	// If filename is given, output should go to a file
 	if len(filename) > 0 {
 		file, err := os.Create(filename)
 		if err != nil {
-			return nil, fmt.Errorf("cannot open file for write %s: %v", filename, err)
+			return nil, nil, fmt.Errorf("cannot open file for write %s: %v", filename, err)
 		}
-		return bufio.NewWriterSize(file, defaultWriteSize), nil
+		return bufio.NewWriterSize(file, defaultWriteSize), outputFile{file}, nil
 	}
 
-	return bufio.NewWriterSize(fallback, defaultWriteSize), nil
+	return bufio.NewWriterSize(fallback, defaultWriteSize), nil, nil
 }
 
 // validateGroups checks validity of combination groupID and totalGroups
@@ -0,0 +1,87 @@
+package load
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Values of -on-decode-error
+const (
+	decodeErrorAbort = "abort"
+	decodeErrorSkip  = "skip"
+)
+
+// errorDecoder decodes points with a PointDecoderWithError, skipping the data
+// it fails to decode or aborting the load as set by -on-decode-error
+type errorDecoder struct {
+	decoder PointDecoderWithError
+	l       *BenchmarkRunner
+}
+
+// Decode decodes the next point the decoder does not fail to decode, or
+// returns nil at the end of the input
+func (d *errorDecoder) Decode(br *bufio.Reader) *Point {
+	for {
+		p, err := d.decoder.DecodeWithError(br)
+		if err == nil {
+			return p
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if !d.l.decodeFailed(err) {
+			return nil
+		}
+	}
+}
+
+// decodeFailed records err, of data that failed to be decoded, returning
+// whether the data that follows is to be decoded. Otherwise the load is
+// aborted with the summary of what was loaded so far.
+func (l *BenchmarkRunner) decodeFailed(err error) bool {
+	atomic.AddUint64(&l.decodeErrCnt, 1)
+	l.errMutex.Lock()
+	if len(l.decodeErrMessages) < maxErrorMessages {
+		l.decodeErrMessages = append(l.decodeErrMessages, err.Error())
+	}
+	l.errMutex.Unlock()
+
+	if l.onDecodeError == decodeErrorSkip {
+		log.Printf("skipping input that cannot be decoded: %v", err)
+		return true
+	}
+	l.abortOnce.Do(func() {
+		atomic.StoreUint32(&l.aborted, 1)
+		took := time.Since(l.start)
+		l.summary(took)
+		l.result(took)
+		fatal("cannot decode the input, aborting: %v", err)
+	})
+	return false
+}
+
+// decodeErrorSummary prints the number of items of the input skipped for
+// failing to be decoded, if any, and the first of their errors
+func (l *BenchmarkRunner) decodeErrorSummary() {
+	cnt := atomic.LoadUint64(&l.decodeErrCnt)
+	if cnt == 0 {
+		return
+	}
+	l.errMutex.Lock()
+	defer l.errMutex.Unlock()
+	printFn("%s", formatDecodeErrors(cnt, l.decodeErrMessages))
+}
+
+// formatDecodeErrors formats the summary of cnt items of the input that failed
+// to be decoded, of the errors messages
+func formatDecodeErrors(cnt uint64, messages []string) string {
+	s := fmt.Sprintf("failed to decode %d items of the input:\n", cnt)
+	for _, m := range messages {
+		s += m + "\n"
+	}
+	return s
+}
@@ -0,0 +1,101 @@
+package load
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// testErrorDecoder decodes points of a byte, failing to decode those of the
+// offsets of failOffsets
+type testErrorDecoder struct {
+	testDecoder
+	offset      int
+	failOffsets map[int]bool
+}
+
+func (d *testErrorDecoder) DecodeWithError(br *bufio.Reader) (*Point, error) {
+	p, err := d.testDecoder.DecodeWithError(br)
+	d.offset++
+	if err == nil && d.failOffsets[d.offset-1] {
+		return nil, fmt.Errorf("offset %d: cannot decode %d", d.offset-1, p.Data)
+	}
+	return p, err
+}
+
+type testErrorDecoderBenchmark struct {
+	testSlowBenchmark
+	decoder *testErrorDecoder
+}
+
+func (b *testErrorDecoderBenchmark) GetPointDecoder(_ *bufio.Reader) PointDecoder { return b.decoder }
+
+func TestScanDecodeErrors(t *testing.T) {
+	oldFatal, oldPrintFn := fatal, printFn
+	defer func() { fatal, printFn = oldFatal, oldPrintFn }()
+	printFn = func(s string, args ...interface{}) (n int, err error) { return 0, nil }
+
+	data := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	cases := []struct {
+		onDecodeError string
+		wantMetrics   uint64
+		wantFatal     []string
+	}{
+		{
+			onDecodeError: decodeErrorSkip,
+			wantMetrics:   8,
+		},
+		{
+			// Only what was read before the first error is loaded
+			onDecodeError: decodeErrorAbort,
+			wantMetrics:   3,
+			wantFatal:     []string{"cannot decode the input, aborting: offset 3: cannot decode 3"},
+		},
+	}
+	for _, c := range cases {
+		var fatalMessages []string
+		fatal = func(format string, args ...interface{}) {
+			fatalMessages = append(fatalMessages, fmt.Sprintf(format, args...))
+		}
+		l := &BenchmarkRunner{
+			batchSize:     2,
+			workers:       1,
+			onDecodeError: c.onDecodeError,
+			doLoad:        true,
+			br:            bufio.NewReader(bytes.NewReader(data)),
+		}
+		b := &testErrorDecoderBenchmark{decoder: &testErrorDecoder{failOffsets: map[int]bool{3: true, 7: true}}}
+		channels := l.createChannels(WorkerPerQueue)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go l.work(b, &wg, channels[0], 0)
+		read := l.scan(b, channels)
+		channels[0].close()
+		wg.Wait()
+
+		if read != c.wantMetrics || l.metricCnt != c.wantMetrics {
+			t.Errorf("%s: incorrect counts: got %d read and %d metrics want %d", c.onDecodeError, read, l.metricCnt, c.wantMetrics)
+		}
+		wantErrCnt := uint64(2)
+		wantMessages := []string{"offset 3: cannot decode 3", "offset 7: cannot decode 7"}
+		if c.onDecodeError == decodeErrorAbort {
+			wantErrCnt, wantMessages = 1, wantMessages[:1]
+		}
+		if l.decodeErrCnt != wantErrCnt || !reflect.DeepEqual(l.decodeErrMessages, wantMessages) {
+			t.Errorf("%s: incorrect errors: got %d %v want %d %v", c.onDecodeError, l.decodeErrCnt, l.decodeErrMessages, wantErrCnt, wantMessages)
+		}
+		if !reflect.DeepEqual(fatalMessages, c.wantFatal) {
+			t.Errorf("%s: incorrect fatal messages: got %v want %v", c.onDecodeError, fatalMessages, c.wantFatal)
+		}
+	}
+}
+
+func TestFormatDecodeErrors(t *testing.T) {
+	want := "failed to decode 12 items of the input:\nline 3: bad\nline 9: bad\n"
+	if got := formatDecodeErrors(12, []string{"line 3: bad", "line 9: bad"}); got != want {
+		t.Errorf("incorrect summary\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
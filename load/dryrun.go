@@ -0,0 +1,26 @@
+package load
+
+// BatchCounter is a Batch that counts the metrics and rows of its points, for
+// them to be counted with -dry-run, without a Processor
+type BatchCounter interface {
+	Batch
+	// Counts returns the numbers of metrics and rows of the points of the
+	// batch, as its Processor would load them
+	Counts() (metricCount, rowCount uint64)
+}
+
+// dryRunProcessor is the Processor of -dry-run, which loads nothing but counts
+// the metrics and rows of the batches, the points of a batch that does not
+// count them being counted as rows of no metrics
+type dryRunProcessor struct{}
+
+// Init does nothing, there being nothing to connect to
+func (p *dryRunProcessor) Init(_ int, _ bool) {}
+
+// ProcessBatch counts the metrics and rows of b
+func (p *dryRunProcessor) ProcessBatch(b Batch, _ bool) (metricCount, rowCount uint64) {
+	if c, ok := b.(BatchCounter); ok {
+		return c.Counts()
+	}
+	return 0, uint64(b.Len())
+}
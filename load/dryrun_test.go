@@ -0,0 +1,86 @@
+package load
+
+import (
+	"os"
+	"testing"
+)
+
+// testCountedBatch is a batch of points of a byte, each a row of as many
+// metrics as its value
+type testCountedBatch struct {
+	testBatch
+	metrics uint64
+}
+
+func (b *testCountedBatch) Append(p *Point) {
+	b.testBatch.Append(p)
+	b.metrics += uint64(p.Data.(byte))
+}
+
+func (b *testCountedBatch) Counts() (metricCount, rowCount uint64) {
+	return b.metrics, uint64(b.Len())
+}
+
+type testCountedFactory struct{}
+
+func (f *testCountedFactory) New() Batch { return &testCountedBatch{} }
+
+// testDryRunBenchmark decodes points of a byte into batches of factory, with
+// no processor to load them
+type testDryRunBenchmark struct {
+	testSlowBenchmark
+	factory BatchFactory
+}
+
+func (b *testDryRunBenchmark) GetBatchFactory() BatchFactory { return b.factory }
+func (b *testDryRunBenchmark) GetProcessor() Processor {
+	panic("processor got in a dry run")
+}
+
+func TestDryRun(t *testing.T) {
+	data := make([]byte, 1000)
+	var metrics uint64
+	for i := range data {
+		data[i] = byte(i % 4)
+		metrics += uint64(i % 4)
+	}
+	fileName := writeTempInput(t, data)
+	defer os.Remove(fileName)
+
+	cases := []struct {
+		desc        string
+		factory     BatchFactory
+		wantMetrics uint64
+	}{
+		{
+			desc:        "counted batches",
+			factory:     &testCountedFactory{},
+			wantMetrics: metrics,
+		},
+		{
+			// The points are counted as rows
+			desc:    "uncounted batches",
+			factory: &testFactory{},
+		},
+	}
+	for _, c := range cases {
+		l := &BenchmarkRunner{
+			batchSize:     7,
+			workers:       3,
+			doLoad:        true,
+			dryRun:        true,
+			onDecodeError: decodeErrorAbort,
+			fileName:      fileName,
+		}
+		l.RunBenchmark(&testDryRunBenchmark{factory: c.factory}, WorkerPerQueue)
+		if l.doLoad {
+			t.Errorf("%s: load not disabled", c.desc)
+		}
+		if l.metricCnt != c.wantMetrics || l.rowCnt != uint64(len(data)) {
+			t.Errorf("%s: incorrect counts: got %d metrics and %d rows want %d and %d", c.desc, l.metricCnt, l.rowCnt, c.wantMetrics, len(data))
+		}
+		if l.itemsRead != uint64(len(data)) || l.scanTook == 0 {
+			t.Errorf("%s: incorrect read: got %d items in %dns want %d", c.desc, l.itemsRead, l.scanTook, len(data))
+		}
+	}
+}
@@ -1,25 +1,41 @@
 package load
 
+import "time"
+
 // duplexChannel acts as a two-way channel for communicating from a scan routine
 // to a worker goroutine. The toWorker channel sends data to the worker for it
 // to process and the toScan channel allows the worker to acknowledge completion.
 // Using this we can accomplish better flow control between the scanner and workers.
 type duplexChannel struct {
-	toWorker  chan Batch
+	toWorker  chan queuedBatch
 	toScanner chan bool
 }
 
+// queuedBatch is a Batch for the workers, with the time the scanner queued it
+// at for the time it waited for a worker to take it to be measured, and the
+// number of times it was retried
+type queuedBatch struct {
+	Batch
+	queued  time.Time
+	retries int
+}
+
 // newDuplexChannel returns a duplexChannel with specified buffer sizes
 func newDuplexChannel(queueLen int) *duplexChannel {
 	return &duplexChannel{
-		toWorker:  make(chan Batch, queueLen),
+		toWorker:  make(chan queuedBatch, queueLen),
 		toScanner: make(chan bool, queueLen),
 	}
 }
 
 // sendToWorker passes a batch of work on to the worker from the scanner
 func (dc *duplexChannel) sendToWorker(b Batch) {
-	dc.toWorker <- b
+	dc.sendQueued(queuedBatch{Batch: b, queued: time.Now()})
+}
+
+// sendQueued passes a batch of work the scanner queued on to the worker
+func (dc *duplexChannel) sendQueued(q queuedBatch) {
+	dc.toWorker <- q
 }
 
 // sendToScanner passes an acknowledge to the scanner from the worker
@@ -35,8 +35,8 @@ func TestNewDuplexChannel(t *testing.T) {
 func TestSendToWorker(t *testing.T) {
 	ch := newDuplexChannel(1)
 	ch.sendToWorker(&testBatch{})
-	if res, ok := <-ch.toWorker; !ok || res == nil {
-		t.Errorf("sendToWorker did not send item or sent nil")
+	if res, ok := <-ch.toWorker; !ok || res.Batch == nil || res.queued.IsZero() {
+		t.Errorf("sendToWorker did not send item, or sent nil or without the time it was queued at")
 	}
 }
 
@@ -0,0 +1,200 @@
+package load
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// HeaderReader is a Benchmark whose input starts with a header, e.g., the
+// tables to create, read by ReadHeader. The header of each of the files of
+// -file after the first is checked to be that of the first and skipped, for it
+// not to be decoded as data.
+type HeaderReader interface {
+	Benchmark
+
+	// ReadHeader reads the header at the start of the input of br, returning
+	// it in a form to compare it with that of another input
+	ReadHeader(br *bufio.Reader) (string, error)
+}
+
+// inputFiles returns the files to read the input from, those of -file, a
+// comma-separated list of file names or glob patterns, e.g., data/cpu-*.gz,
+// the files matching a pattern being sorted, or none to read stdin
+func inputFiles(spec string) ([]string, error) {
+	if len(spec) == 0 {
+		return nil, nil
+	}
+	var files []string
+	for _, pattern := range strings.Split(spec, ",") {
+		if !strings.ContainsAny(pattern, "*?[") {
+			files = append(files, pattern)
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -file pattern %s: %v", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no file matches -file pattern %s", pattern)
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// checkHeaders checks that the header of each of files, as read by
+// readHeader, is that of the first
+func checkHeaders(files []string, readHeader func(*bufio.Reader) (string, error)) error {
+	var first string
+	for i, fileName := range files {
+		header, err := readFileHeader(fileName, readHeader)
+		if err != nil {
+			return fmt.Errorf("cannot read the header of %s: %v", fileName, err)
+		}
+		if i == 0 {
+			first = header
+		} else if header != first {
+			return fmt.Errorf("header of %s is not that of %s: the files must be of the same data set", fileName, files[0])
+		}
+	}
+	return nil
+}
+
+// readFileHeader returns the header of the file fileName, as read by readHeader
+func readFileHeader(fileName string, readHeader func(*bufio.Reader) (string, error)) (string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	br, err := openData(f)
+	if err != nil {
+		return "", err
+	}
+	return readHeader(br)
+}
+
+// openFiles opens files to be read in streams concurrent streams, as many as
+// files at most, file i being read by stream i % streams after the files of
+// that stream before it. It returns a reader counting the bytes read from all
+// of them, their total size and a buffered Reader of the data of each stream.
+// The header of the first file, as read by readHeader unless nil, is left at
+// the start of the first stream, that of the other files being skipped.
+func openFiles(files []string, streams int, readHeader func(*bufio.Reader) (string, error)) (input *countingReader, size int64, brs []*bufio.Reader, err error) {
+	for _, fileName := range files {
+		info, err := os.Stat(fileName)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+	}
+	if streams > len(files) {
+		streams = len(files)
+	}
+	input = &countingReader{}
+	readers := make([]*filesReader, streams)
+	for i := range readers {
+		readers[i] = &filesReader{input: input, readHeader: readHeader, keepHeader: i == 0}
+	}
+	for i, fileName := range files {
+		r := readers[i%streams]
+		r.files = append(r.files, fileName)
+	}
+	for _, r := range readers {
+		brs = append(brs, bufio.NewReaderSize(r, defaultReadSize))
+	}
+	return input, size, brs, nil
+}
+
+// filesReader reads the data of files one after the other, each decompressed
+// if it is compressed and its header skipped, unless keepHeader is set for the
+// first, a line ending being added to a file not ending with one for its last
+// line not to run into the first of the next
+type filesReader struct {
+	files      []string
+	input      *countingReader
+	readHeader func(*bufio.Reader) (string, error)
+	keepHeader bool
+
+	f         *os.File
+	br        *bufio.Reader
+	last      byte
+	endOfLine bool
+}
+
+// countingInput counts the bytes read from r into the countingReader c of the
+// whole input
+type countingInput struct {
+	r io.Reader
+	c *countingReader
+}
+
+func (c *countingInput) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddUint64(&c.c.n, uint64(n))
+	return n, err
+}
+
+func (r *filesReader) Read(p []byte) (int, error) {
+	for {
+		if r.endOfLine && len(p) > 0 {
+			r.endOfLine = false
+			p[0] = '\n'
+			return 1, nil
+		}
+		if r.br == nil {
+			if len(r.files) == 0 {
+				return 0, io.EOF
+			}
+			if err := r.open(); err != nil {
+				return 0, err
+			}
+		}
+		n, err := r.br.Read(p)
+		if n > 0 {
+			r.last = p[n-1]
+		}
+		if err == io.EOF {
+			r.f.Close()
+			r.f, r.br = nil, nil
+			r.endOfLine = r.last != '\n' && r.last != 0
+			r.last = 0
+			if n == 0 {
+				continue
+			}
+			return n, nil
+		}
+		return n, err
+	}
+}
+
+// open opens the next of the files, skipping its header unless it is kept
+func (r *filesReader) open() error {
+	fileName := r.files[0]
+	r.files = r.files[1:]
+	f, err := os.Open(fileName)
+	if err != nil {
+		return fmt.Errorf("cannot open file for read %s: %v", fileName, err)
+	}
+	br, err := openData(&countingInput{r: f, c: r.input})
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("cannot read %s: %v", fileName, err)
+	}
+	if r.readHeader != nil && !r.keepHeader {
+		if _, err := r.readHeader(br); err != nil {
+			f.Close()
+			return fmt.Errorf("cannot read the header of %s: %v", fileName, err)
+		}
+	}
+	r.keepHeader = false
+	r.f, r.br = f, br
+	return nil
+}
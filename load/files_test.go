@@ -0,0 +1,333 @@
+package load
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testLinesDecoder decodes a point of each line
+type testLinesDecoder struct{}
+
+func (d *testLinesDecoder) Decode(br *bufio.Reader) *Point {
+	line, err := br.ReadString('\n')
+	if len(line) == 0 && err != nil {
+		return nil
+	}
+	return NewPoint(strings.TrimSuffix(line, "\n"))
+}
+
+type testLinesBatch struct {
+	lines []string
+}
+
+func (b *testLinesBatch) Len() int        { return len(b.lines) }
+func (b *testLinesBatch) Append(p *Point) { b.lines = append(b.lines, p.Data.(string)) }
+
+type testLinesFactory struct{}
+
+func (f *testLinesFactory) New() Batch { return &testLinesBatch{} }
+
+// testLinesProcessor collects the lines loaded by all the workers
+type testLinesProcessor struct {
+	testProcessor
+	mu    *sync.Mutex
+	lines *[]string
+}
+
+func (p *testLinesProcessor) ProcessBatch(b Batch, doLoad bool) (metricCount, rowCount uint64) {
+	lines := b.(*testLinesBatch).lines
+	p.mu.Lock()
+	*p.lines = append(*p.lines, lines...)
+	p.mu.Unlock()
+	return uint64(len(lines)), uint64(len(lines))
+}
+
+// testLinesIndexer indexes the lines by their length, as -hash-workers does
+// by host
+type testLinesIndexer struct {
+	partitions uint
+}
+
+func (i *testLinesIndexer) GetIndex(p *Point) int {
+	return len(p.Data.(string)) % int(i.partitions)
+}
+
+// testHeaderCreator reads the header at the start of the input when
+// initialized, as the creators of the databases whose input has one do
+type testHeaderCreator struct {
+	testCreator
+	l      *BenchmarkRunner
+	header string
+}
+
+func (c *testHeaderCreator) Init() {
+	c.header, _ = readTestHeader(c.l.GetBufferedReader())
+}
+
+// testHeaderBenchmark loads the lines of its input after its header, of the
+// lines up to a blank line
+type testHeaderBenchmark struct {
+	creator *testHeaderCreator
+	hash    bool
+	mu      sync.Mutex
+	lines   []string
+}
+
+func (b *testHeaderBenchmark) GetPointDecoder(_ *bufio.Reader) PointDecoder {
+	return &testLinesDecoder{}
+}
+func (b *testHeaderBenchmark) GetBatchFactory() BatchFactory { return &testLinesFactory{} }
+func (b *testHeaderBenchmark) GetPointIndexer(maxPartitions uint) PointIndexer {
+	if b.hash {
+		return &testLinesIndexer{partitions: maxPartitions}
+	}
+	return &ConstantIndexer{}
+}
+func (b *testHeaderBenchmark) GetProcessor() Processor {
+	return &testLinesProcessor{mu: &b.mu, lines: &b.lines}
+}
+func (b *testHeaderBenchmark) GetDBCreator() DBCreator { return b.creator }
+func (b *testHeaderBenchmark) ReadHeader(br *bufio.Reader) (string, error) {
+	return readTestHeader(br)
+}
+
+func readTestHeader(br *bufio.Reader) (string, error) {
+	var header []string
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("input ends in the header: %v", err)
+		}
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			return strings.Join(header, "\n"), nil
+		}
+		header = append(header, line)
+	}
+}
+
+// writeTestFiles writes the files of the lines of each of counts under a
+// header, the second gzip-compressed, returning their names and lines
+func writeTestFiles(t *testing.T, dir string, header string, counts []int) (files []string, lines []string) {
+	for i, n := range counts {
+		var data bytes.Buffer
+		data.WriteString(header + "\n\n")
+		for j := 0; j < n; j++ {
+			line := fmt.Sprintf("file %d line %d", i, j)
+			data.WriteString(line + "\n")
+			lines = append(lines, line)
+		}
+		fileName := filepath.Join(dir, fmt.Sprintf("data-%d", i))
+		b := data.Bytes()
+		if i == 1 {
+			var gzipped bytes.Buffer
+			w := gzip.NewWriter(&gzipped)
+			w.Write(b)
+			w.Close()
+			b = gzipped.Bytes()
+		}
+		if err := ioutil.WriteFile(fileName, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, fileName)
+	}
+	return files, lines
+}
+
+func TestInputFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tsbs-files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"cpu-2", "cpu-1", "cpu-10", "mem"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	in := func(name string) string { return filepath.Join(dir, name) }
+
+	cases := []struct {
+		spec    string
+		want    []string
+		wantErr string
+	}{
+		{spec: "", want: nil},
+		{spec: in("mem"), want: []string{in("mem")}},
+		{spec: in("mem") + "," + in("cpu-1"), want: []string{in("mem"), in("cpu-1")}},
+		{spec: in("cpu-*"), want: []string{in("cpu-1"), in("cpu-10"), in("cpu-2")}},
+		{spec: in("mem") + "," + in("cpu-?"), want: []string{in("mem"), in("cpu-1"), in("cpu-2")}},
+		{spec: in("disk-*"), wantErr: "no file matches -file pattern " + in("disk-*")},
+		{spec: in("cpu-["), wantErr: "invalid -file pattern " + in("cpu-[") + ": syntax error in pattern"},
+	}
+	for _, c := range cases {
+		got, err := inputFiles(c.spec)
+		if c.wantErr != "" {
+			if err == nil || err.Error() != c.wantErr {
+				t.Errorf("%q: incorrect error: got %v want %s", c.spec, err, c.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", c.spec, err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%q: incorrect files: got %v want %v", c.spec, got, c.want)
+		}
+	}
+}
+
+func TestLoadFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tsbs-files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	header := "tags,hostname\ncpu,usage_user"
+	files, lines := writeTestFiles(t, dir, header, []int{7, 600, 30})
+	var size int64
+	for _, fileName := range files {
+		info, err := os.Stat(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += info.Size()
+	}
+	sort.Strings(lines)
+
+	cases := []struct {
+		desc    string
+		file    string
+		streams int
+		limit   uint64
+		hash    bool
+	}{
+		{desc: "list in a stream", file: strings.Join(files, ","), streams: 1},
+		{desc: "glob in a stream", file: filepath.Join(dir, "data-*"), streams: 1},
+		{desc: "stream per file", file: strings.Join(files, ","), streams: 3},
+		{desc: "more streams than files", file: strings.Join(files, ","), streams: 8},
+		{desc: "two streams", file: strings.Join(files, ","), streams: 2},
+		{desc: "hash workers", file: strings.Join(files, ","), streams: 3, hash: true},
+		{desc: "limit", file: strings.Join(files, ","), streams: 3, limit: 100},
+	}
+	for _, c := range cases {
+		l := &BenchmarkRunner{
+			dbName:        "benchmark",
+			batchSize:     10,
+			workers:       4,
+			limit:         c.limit,
+			doLoad:        true,
+			onDecodeError: decodeErrorAbort,
+			fileName:      c.file,
+			scanStreams:   c.streams,
+		}
+		b := &testHeaderBenchmark{creator: &testHeaderCreator{l: l}, hash: c.hash}
+		workQueues := uint(SingleQueue)
+		if c.hash {
+			workQueues = WorkerPerQueue
+		}
+		l.RunBenchmark(b, workQueues)
+
+		if b.creator.header != header {
+			t.Errorf("%s: incorrect header read by the creator: got %q want %q", c.desc, b.creator.header, header)
+		}
+		got := append([]string(nil), b.lines...)
+		sort.Strings(got)
+		if c.limit > 0 {
+			if uint64(len(got)) != c.limit || l.itemsRead != c.limit {
+				t.Errorf("%s: incorrect items loaded: got %d of %d read want %d", c.desc, len(got), l.itemsRead, c.limit)
+			}
+			for _, line := range got {
+				if !strings.HasPrefix(line, "file ") {
+					t.Errorf("%s: incorrect line loaded: %q", c.desc, line)
+				}
+			}
+			continue
+		}
+		if !reflect.DeepEqual(got, lines) {
+			t.Errorf("%s: incorrect lines loaded: got %d want %d, from %v to %v", c.desc, len(got), len(lines), got[:2], got[len(got)-2:])
+		}
+		if l.itemsRead != uint64(len(lines)) || l.rowCnt != uint64(len(lines)) {
+			t.Errorf("%s: incorrect items read: got %d, %d rows loaded want %d", c.desc, l.itemsRead, l.rowCnt, len(lines))
+		}
+		if l.inputSize != size || l.input.bytesRead() != uint64(size) {
+			t.Errorf("%s: incorrect input size: got %d, %d bytes read want %d", c.desc, l.inputSize, l.input.bytesRead(), size)
+		}
+	}
+}
+
+func TestLoadFilesHeaderMismatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tsbs-files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files, _ := writeTestFiles(t, dir, "tags,hostname\ncpu,usage_user", []int{3, 3})
+	other := filepath.Join(dir, "other")
+	if err := ioutil.WriteFile(other, []byte("tags,hostname\nmem,used\n\nline\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	oldFatal := fatal
+	defer func() { fatal = oldFatal }()
+	var fatalMessages []string
+	fatal = func(format string, args ...interface{}) {
+		fatalMessages = append(fatalMessages, fmt.Sprintf(format, args...))
+	}
+
+	l := &BenchmarkRunner{fileName: strings.Join(append(files, other), ","), scanStreams: 2}
+	l.readHeader = (&testHeaderBenchmark{}).ReadHeader
+	if br := l.GetBufferedReader(); br != nil {
+		t.Errorf("input opened despite its headers not matching")
+	}
+	want := []string{fmt.Sprintf("header of %s is not that of %s: the files must be of the same data set", other, files[0])}
+	if !reflect.DeepEqual(fatalMessages, want) {
+		t.Errorf("incorrect fatal messages: got %v want %v", fatalMessages, want)
+	}
+}
+
+func TestFilesReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tsbs-files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// The last line of a file not ending with a line ending is not run into
+	// the first of the next
+	contents := []string{"h\n\na\nb", "h\n\nc\n", "", "h\n\nd"}
+	var files []string
+	for i, content := range contents {
+		fileName := filepath.Join(dir, fmt.Sprintf("data-%d", i))
+		if err := ioutil.WriteFile(fileName, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, fileName)
+	}
+	readHeader := func(br *bufio.Reader) (string, error) {
+		if _, err := br.Peek(1); err == io.EOF {
+			return "", nil
+		}
+		return readTestHeader(br)
+	}
+	_, _, brs, err := openFiles(files, 1, readHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(brs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "h\n\na\nb\nc\nd\n"; string(got) != want {
+		t.Errorf("incorrect data read: got %q want %q", got, want)
+	}
+}
@@ -0,0 +1,131 @@
+package load
+
+// KeyHashIndexer puts the points with the same key, e.g., their hostname, on
+// the same channel, for the loaders hashing their points to workers with
+// -hash-workers to place them alike whatever the database. The channel of a
+// key is published for tools outside of the loaders to predict it, and is
+// pinned by the tests not to change:
+//
+//   - by default, KeyPartition: the 32-bit FNV-1a hash of the key modulo the
+//     number of channels
+//   - with Jump set, KeyJumpPartition: the jump consistent hash of Lamping and
+//     Veach of the 64-bit FNV-1a hash of the key, its bits mixed by the
+//     finalizer of MurmurHash3, which moves the fewest keys to other channels
+//     when the number of channels changes
+type KeyHashIndexer struct {
+	key       func(*Point) []byte
+	stringKey func(*Point) string
+	// Jump, if set, places the keys with KeyJumpPartition rather than
+	// KeyPartition
+	Jump       bool
+	partitions uint
+	// counts are the numbers of points put on each channel
+	counts []uint64
+}
+
+// NewKeyHashIndexer returns a KeyHashIndexer over maxPartitions channels of
+// the points by the key returned by key
+func NewKeyHashIndexer(key func(*Point) []byte, maxPartitions uint) *KeyHashIndexer {
+	return &KeyHashIndexer{key: key, partitions: maxPartitions, counts: make([]uint64, maxPartitions)}
+}
+
+// NewStringKeyHashIndexer returns a KeyHashIndexer over maxPartitions channels
+// of the points by the key returned by key, as a string for the keys that are
+// parts of the strings decoded not to be copied to be hashed
+func NewStringKeyHashIndexer(key func(*Point) string, maxPartitions uint) *KeyHashIndexer {
+	return &KeyHashIndexer{stringKey: key, partitions: maxPartitions, counts: make([]uint64, maxPartitions)}
+}
+
+// GetIndex returns the channel of the key of p
+func (i *KeyHashIndexer) GetIndex(p *Point) int {
+	var idx int
+	switch {
+	case i.stringKey != nil && i.Jump:
+		idx = jumpHash(mix64(fnv64aString(i.stringKey(p))), i.partitions)
+	case i.stringKey != nil:
+		idx = int(fnv32aString(i.stringKey(p)) % uint32(i.partitions))
+	case i.Jump:
+		idx = KeyJumpPartition(i.key(p), i.partitions)
+	default:
+		idx = KeyPartition(i.key(p), i.partitions)
+	}
+	i.counts[idx]++
+	return idx
+}
+
+// Counts returns the numbers of points put on each channel so far
+func (i *KeyHashIndexer) Counts() []uint64 {
+	return i.counts
+}
+
+// KeyHash returns the 32-bit FNV-1a hash of key, as hash/fnv does
+func KeyHash(key []byte) uint32 {
+	h := uint32(2166136261)
+	for _, c := range key {
+		h ^= uint32(c)
+		h *= 16777619
+	}
+	return h
+}
+
+// KeyPartition returns the channel of key among partitions, its KeyHash modulo
+// partitions
+func KeyPartition(key []byte, partitions uint) int {
+	return int(KeyHash(key) % uint32(partitions))
+}
+
+// KeyJumpPartition returns the channel of key among partitions, the jump
+// consistent hash of its 64-bit FNV-1a hash mixed by the finalizer of
+// MurmurHash3: a key only moves, to the new channel, when partitions grows by 1
+func KeyJumpPartition(key []byte, partitions uint) int {
+	h := uint64(14695981039346656037)
+	for _, c := range key {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+	return jumpHash(mix64(h), partitions)
+}
+
+// fnv32aString returns the KeyHash of s without copying it
+func fnv32aString(s string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(s); i++ {
+		h ^= uint32(s[i])
+		h *= 16777619
+	}
+	return h
+}
+
+// fnv64aString returns the 64-bit FNV-1a hash of s without copying it
+func fnv64aString(s string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+// mix64 mixes the bits of h, the finalizer of MurmurHash3, for the hashes of
+// keys differing in their last characters, e.g., hostnames, to differ in all
+// bits
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// jumpHash returns the bucket of key among n, as by the jump consistent hash
+// of Lamping and Veach
+func jumpHash(key uint64, n uint) int {
+	b, j := int64(-1), int64(0)
+	for j < int64(n) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
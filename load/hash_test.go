@@ -0,0 +1,104 @@
+package load
+
+import (
+	"fmt"
+	"hash/fnv"
+	"testing"
+)
+
+// testHostPoint is the point of the hostname host_<host>
+func testHostPoint(host int) *Point {
+	return NewPoint(fmt.Sprintf("host_%d", host))
+}
+
+func TestKeyHashPinned(t *testing.T) {
+	// The placement of the keys is published: changing any of these moves
+	// the hosts to other workers than those of the previous versions
+	key := []byte("host_123")
+	if got, want := KeyHash(key), uint32(2359680032); got != want {
+		t.Errorf("incorrect hash of host_123: got %d want %d", got, want)
+	}
+	cases := []struct {
+		partitions uint
+		want       int
+		wantJump   int
+	}{
+		{partitions: 1, want: 0, wantJump: 0},
+		{partitions: 2, want: 0, wantJump: 1},
+		{partitions: 3, want: 2, wantJump: 1},
+		{partitions: 8, want: 0, wantJump: 5},
+		{partitions: 10, want: 2, wantJump: 5},
+		{partitions: 64, want: 32, wantJump: 10},
+	}
+	for _, c := range cases {
+		if got := KeyPartition(key, c.partitions); got != c.want {
+			t.Errorf("%d partitions: incorrect partition of host_123: got %d want %d", c.partitions, got, c.want)
+		}
+		if got := KeyJumpPartition(key, c.partitions); got != c.wantJump {
+			t.Errorf("%d partitions: incorrect jump partition of host_123: got %d want %d", c.partitions, got, c.wantJump)
+		}
+	}
+
+	// The hashes are those of FNV-1a as hash/fnv has them
+	for _, s := range []string{"", "host_0", "host_123", "hostname=host_12345"} {
+		h := fnv.New32a()
+		h.Write([]byte(s))
+		if got := KeyHash([]byte(s)); got != h.Sum32() {
+			t.Errorf("incorrect FNV-1a hash of '%s': got %d want %d", s, got, h.Sum32())
+		}
+		if got := fnv32aString(s); got != h.Sum32() {
+			t.Errorf("incorrect FNV-1a hash of string '%s': got %d want %d", s, got, h.Sum32())
+		}
+		h64 := fnv.New64a()
+		h64.Write([]byte(s))
+		if got := fnv64aString(s); got != h64.Sum64() {
+			t.Errorf("incorrect 64-bit FNV-1a hash of '%s': got %d want %d", s, got, h64.Sum64())
+		}
+	}
+}
+
+func TestKeyHashIndexerDistribution(t *testing.T) {
+	const hosts = 10000
+	// chiSquaredBound is the chi-squared statistic of 15 degrees of freedom
+	// exceeded by chance once in 1000
+	const partitions, chiSquaredBound = 16, 37.7
+	key := func(p *Point) []byte { return []byte(p.Data.(string)) }
+	stringKey := func(p *Point) string { return p.Data.(string) }
+	for _, jump := range []bool{false, true} {
+		i := NewKeyHashIndexer(key, partitions)
+		i.Jump = jump
+		s := NewStringKeyHashIndexer(stringKey, partitions)
+		s.Jump = jump
+		for host := 0; host < hosts; host++ {
+			p := testHostPoint(host)
+			idx := i.GetIndex(p)
+			if got := s.GetIndex(p); got != idx {
+				t.Errorf("jump %v: host_%d put on partition %d by its bytes and %d by its string", jump, host, idx, got)
+			}
+			if got := i.GetIndex(p); got != idx {
+				t.Errorf("jump %v: host_%d put on partitions %d and %d", jump, host, idx, got)
+			}
+		}
+		expected := float64(hosts) / partitions
+		chiSquared := 0.0
+		for _, n := range s.Counts() {
+			d := float64(n) - expected
+			chiSquared += d * d / expected
+		}
+		if chiSquared > chiSquaredBound {
+			t.Errorf("jump %v: uneven partitions: got chi-squared %.1f over %v want at most %v", jump, chiSquared, s.Counts(), chiSquaredBound)
+		}
+	}
+}
+
+func TestKeyJumpPartitionStable(t *testing.T) {
+	// A key only moves, to the new partition, when one is added
+	for host := 0; host < 10000; host++ {
+		key := []byte(fmt.Sprintf("host_%d", host))
+		for n := uint(1); n < 16; n++ {
+			if before, after := KeyJumpPartition(key, n), KeyJumpPartition(key, n+1); after != before && after != int(n) {
+				t.Errorf("host_%d moved from partition %d to %d of %d", host, before, after, n+1)
+			}
+		}
+	}
+}
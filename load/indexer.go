@@ -0,0 +1,100 @@
+package load
+
+import (
+	"fmt"
+	"log"
+)
+
+// Values of -worker-distribution
+const (
+	workerDistributionLoader      = ""
+	workerDistributionRoundRobin  = "round-robin"
+	workerDistributionLeastLoaded = "least-loaded"
+)
+
+// QueueDepthIndexer is a PointIndexer that is told the number of batches
+// outstanding on each channel as the scanner sends them and the workers
+// acknowledge them, for it to choose the channels by them
+type QueueDepthIndexer interface {
+	PointIndexer
+	// SetQueueDepth sets the number of batches of channel idx sent or queued
+	// to be sent to its workers, and not acknowledged yet
+	SetQueueDepth(idx int, depth int)
+}
+
+// RoundRobinIndexer puts the points on each channel in turn, for the batches to
+// be spread evenly over the workers when their order does not matter
+type RoundRobinIndexer struct {
+	partitions int
+	next       int
+}
+
+// NewRoundRobinIndexer returns a RoundRobinIndexer over maxPartitions channels
+func NewRoundRobinIndexer(maxPartitions uint) *RoundRobinIndexer {
+	return &RoundRobinIndexer{partitions: int(maxPartitions)}
+}
+
+// GetIndex returns the channel after that of the previous Point
+func (i *RoundRobinIndexer) GetIndex(_ *Point) int {
+	idx := i.next
+	i.next = (i.next + 1) % i.partitions
+	return idx
+}
+
+// LeastLoadedIndexer puts the points on the channel with the fewest batches
+// outstanding, the first of them if several have as few, for slower workers
+// to be sent fewer batches
+type LeastLoadedIndexer struct {
+	depths []int
+}
+
+// NewLeastLoadedIndexer returns a LeastLoadedIndexer over maxPartitions
+// channels
+func NewLeastLoadedIndexer(maxPartitions uint) *LeastLoadedIndexer {
+	return &LeastLoadedIndexer{depths: make([]int, maxPartitions)}
+}
+
+// GetIndex returns the channel with the fewest batches outstanding
+func (i *LeastLoadedIndexer) GetIndex(_ *Point) int {
+	idx := 0
+	for j, depth := range i.depths {
+		if depth < i.depths[idx] {
+			idx = j
+		}
+	}
+	return idx
+}
+
+// SetQueueDepth sets the number of batches outstanding on channel idx
+func (i *LeastLoadedIndexer) SetQueueDepth(idx int, depth int) {
+	i.depths[idx] = depth
+}
+
+// validateWorkerDistribution returns an error if distribution is not a value of
+// -worker-distribution
+func validateWorkerDistribution(distribution string) error {
+	switch distribution {
+	case workerDistributionLoader, workerDistributionRoundRobin, workerDistributionLeastLoaded:
+		return nil
+	}
+	return fmt.Errorf("invalid -worker-distribution %s: must be %s or %s", distribution, workerDistributionRoundRobin, workerDistributionLeastLoaded)
+}
+
+// pointIndexer returns the PointIndexer of the points over numChannels
+// channels: that of -worker-distribution, unless the Benchmark indexes them
+// otherwise than on a single channel, e.g., by their hosts, for which its own
+// indexer is kept
+func (l *BenchmarkRunner) pointIndexer(b Benchmark, numChannels uint) PointIndexer {
+	indexer := b.GetPointIndexer(numChannels)
+	if l.workerDistribution == workerDistributionLoader {
+		return indexer
+	}
+	if _, ok := indexer.(*ConstantIndexer); !ok {
+		log.Printf("the loader distributes the points over its workers itself, ignoring -worker-distribution %s", l.workerDistribution)
+		return indexer
+	}
+	if l.workerDistribution == workerDistributionRoundRobin {
+		return NewRoundRobinIndexer(numChannels)
+	}
+	return NewLeastLoadedIndexer(numChannels)
+}
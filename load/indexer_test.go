@@ -0,0 +1,128 @@
+package load
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRoundRobinIndexer(t *testing.T) {
+	i := NewRoundRobinIndexer(3)
+	var got []int
+	for j := 0; j < 7; j++ {
+		got = append(got, i.GetIndex(&Point{}))
+	}
+	if want := []int{0, 1, 2, 0, 1, 2, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect indexes: got %v want %v", got, want)
+	}
+}
+
+func TestLeastLoadedIndexer(t *testing.T) {
+	i := NewLeastLoadedIndexer(3)
+	cases := []struct {
+		desc  string
+		idx   int
+		depth int
+		want  int
+	}{
+		{desc: "all idle", want: 0},
+		{desc: "first loaded", idx: 0, depth: 2, want: 1},
+		{desc: "second loaded", idx: 1, depth: 1, want: 2},
+		{desc: "third most loaded", idx: 2, depth: 3, want: 1},
+		{desc: "first done", idx: 0, depth: 0, want: 0},
+	}
+	for _, c := range cases {
+		i.SetQueueDepth(c.idx, c.depth)
+		if got := i.GetIndex(&Point{}); got != c.want {
+			t.Errorf("%s: incorrect index: got %d want %d", c.desc, got, c.want)
+		}
+	}
+}
+
+func TestScanLeastLoaded(t *testing.T) {
+	const numChannels, items = 3, 300
+	channels := make([]*duplexChannel, numChannels)
+	batches := make([]int, numChannels)
+	var wg sync.WaitGroup
+	for i := range channels {
+		channels[i] = newDuplexChannel(1)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for range channels[i].toWorker {
+				// The first channel, which is picked on ties, is slowed down
+				if i == 0 {
+					time.Sleep(20 * time.Millisecond)
+				}
+				batches[i]++
+				channels[i].sendToScanner()
+			}
+		}(i)
+	}
+	br := bufio.NewReader(bytes.NewReader(make([]byte, items)))
+	read := scanWithIndexer(channels, 1, 0, 0, br, &testDecoder{}, &testFactory{}, NewLeastLoadedIndexer(numChannels), outstandingLimit{}, scanPacing{}, nil)
+	for _, ch := range channels {
+		ch.close()
+	}
+	wg.Wait()
+	if read != items || batches[0]+batches[1]+batches[2] != items {
+		t.Fatalf("incorrect items: got %d read and %v batches want %d", read, batches, items)
+	}
+	if batches[0] > items/10 {
+		t.Errorf("slow channel not avoided: got %v batches", batches)
+	}
+}
+
+// testIndexerBenchmark indexes the points with its indexer
+type testIndexerBenchmark struct {
+	testBenchmark
+	indexer PointIndexer
+}
+
+func (b *testIndexerBenchmark) GetPointIndexer(_ uint) PointIndexer { return b.indexer }
+
+func TestPointIndexer(t *testing.T) {
+	loaderIndexer := &testModIndexer{3}
+	cases := []struct {
+		desc         string
+		distribution string
+		indexer      PointIndexer
+		want         PointIndexer
+	}{
+		{
+			desc:    "loader",
+			indexer: &ConstantIndexer{},
+			want:    &ConstantIndexer{},
+		},
+		{
+			desc:         "round-robin",
+			distribution: workerDistributionRoundRobin,
+			indexer:      &ConstantIndexer{},
+			want:         NewRoundRobinIndexer(3),
+		},
+		{
+			desc:         "least-loaded",
+			distribution: workerDistributionLeastLoaded,
+			indexer:      &ConstantIndexer{},
+			want:         NewLeastLoadedIndexer(3),
+		},
+		{
+			desc:         "loader override",
+			distribution: workerDistributionLeastLoaded,
+			indexer:      loaderIndexer,
+			want:         loaderIndexer,
+		},
+	}
+	for _, c := range cases {
+		l := &BenchmarkRunner{workerDistribution: c.distribution}
+		if got := l.pointIndexer(&testIndexerBenchmark{indexer: c.indexer}, 3); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: incorrect indexer: got %#v want %#v", c.desc, got, c.want)
+		}
+	}
+	if err := validateWorkerDistribution("random"); err == nil {
+		t.Errorf("no error for an invalid distribution")
+	}
+}
@@ -0,0 +1,83 @@
+package load
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Magic numbers of the compressed inputs that are decompressed as they are read
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// OpenInput returns a buffered Reader of the data of the file fileName,
+// decompressed if it is gzip- or zstd-compressed, or of stdin if empty
+func OpenInput(fileName string) (*bufio.Reader, error) {
+	_, _, br, err := openInput(fileName)
+	return br, err
+}
+
+// openInput opens the file fileName, or stdin if empty, returning a reader
+// counting the bytes read from it, its size if it is a regular file and a
+// buffered Reader of its data, decompressed if the file is compressed. Stdin
+// is not, for the loader not to wait for it to be written to until it loads.
+func openInput(fileName string) (input *countingReader, size int64, br *bufio.Reader, err error) {
+	if len(fileName) == 0 {
+		input = &countingReader{r: os.Stdin}
+		return input, inputSize(os.Stdin), bufio.NewReaderSize(input, defaultReadSize), nil
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	input = &countingReader{r: f}
+	br, err = openData(input)
+	if err != nil {
+		f.Close()
+		return nil, 0, nil, err
+	}
+	return input, inputSize(f), br, nil
+}
+
+// openData returns a buffered Reader of the data read from r, decompressed if
+// it is compressed
+func openData(r io.Reader) (*bufio.Reader, error) {
+	br := bufio.NewReaderSize(r, defaultReadSize)
+	data, err := decompress(br)
+	if err != nil {
+		return nil, err
+	}
+	if data != br {
+		br = bufio.NewReaderSize(data, defaultReadSize)
+	}
+	return br, nil
+}
+
+// decompress returns a reader of the data of br, decompressing it if it starts
+// with the magic number of gzip or zstd, br itself otherwise
+func decompress(br *bufio.Reader) (io.Reader, error) {
+	// Fewer bytes than those of a magic number are not compressed
+	magic, _ := br.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		r, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read gzip-compressed input: %v", err)
+		}
+		return r, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		r, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read zstd-compressed input: %v", err)
+		}
+		return r, nil
+	}
+	return br, nil
+}
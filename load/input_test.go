@@ -0,0 +1,93 @@
+package load
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// writeTempInput writes data to a temporary file, returning its name
+func writeTempInput(t *testing.T, data []byte) string {
+	f, err := ioutil.TempFile("", "tsbs-input")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestOpenInput(t *testing.T) {
+	data := []byte(strings.Repeat("cpu,hostname=host_0 usage_user=58 1451606400000000000\n", 1000))
+	var gzipped bytes.Buffer
+	w := gzip.NewWriter(&gzipped)
+	w.Write(data)
+	w.Close()
+
+	cases := []struct {
+		desc      string
+		input     []byte
+		want      []byte
+		wantError string
+	}{
+		{
+			desc:  "uncompressed",
+			input: data,
+			want:  data,
+		},
+		{
+			desc:  "gzip-compressed",
+			input: gzipped.Bytes(),
+			want:  data,
+		},
+		{
+			desc:  "shorter than a magic number",
+			input: []byte{0x1f},
+			want:  []byte{0x1f},
+		},
+		{
+			desc:  "empty",
+			input: []byte{},
+			want:  []byte{},
+		},
+		{
+			desc:      "truncated gzip header",
+			input:     gzipped.Bytes()[:5],
+			wantError: "cannot read gzip-compressed input: unexpected EOF",
+		},
+	}
+	for _, c := range cases {
+		fileName := writeTempInput(t, c.input)
+		defer os.Remove(fileName)
+		input, size, br, err := openInput(fileName)
+		if c.wantError != "" {
+			if err == nil || err.Error() != c.wantError {
+				t.Errorf("%s: incorrect error: got %v want %s", c.desc, err, c.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: cannot open input: %v", c.desc, err)
+		}
+		got, err := ioutil.ReadAll(br)
+		if err != nil {
+			t.Fatalf("%s: cannot read input: %v", c.desc, err)
+		}
+		if !bytes.Equal(got, c.want) {
+			t.Errorf("%s: incorrect data: got %d bytes want %d", c.desc, len(got), len(c.want))
+		}
+		// The progress is of the file read, compressed or not
+		if size != int64(len(c.input)) || input.bytesRead() != uint64(len(c.input)) {
+			t.Errorf("%s: incorrect size and bytes read: got %d and %d want %d", c.desc, size, input.bytesRead(), len(c.input))
+		}
+	}
+
+	if _, err := OpenInput("/nonexistent/tsbs-input"); err == nil {
+		t.Errorf("no error opening a nonexistent file")
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"log"
 	"math"
 	"os"
+	"os/signal"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -23,6 +25,10 @@ const (
 	SingleQueue = 1
 
 	errDBExistsFmt = "database \"%s\" exists: aborting."
+
+	// maxErrorMessages is the number of errors of failed batches whose
+	// messages are kept for the summary
+	maxErrorMessages = 10
 )
 
 // change for more useful testing
@@ -54,20 +60,115 @@ type Benchmark interface {
 // flags across all database systems and ultimately running a supplied Benchmark
 type BenchmarkRunner struct {
 	// flag fields
-	dbName          string
-	batchSize       uint
-	workers         uint
-	limit           uint64
-	doLoad          bool
-	doCreateDB      bool
-	doAbortOnExist  bool
-	reportingPeriod time.Duration
-	fileName        string
+	dbName              string
+	batchSize           uint
+	batchBytes          uint64
+	maxOutstanding      int
+	maxOutstandingBytes uint64
+	workers             uint
+	limit               uint64
+	maxDuration         time.Duration
+	warmup              time.Duration
+	warmupRows          uint64
+	rateLimit           float64
+	rateLimitMetric     float64
+	replaySpeed         float64
+	maxBatchAge         time.Duration
+	doLoad              bool
+	dryRun              bool
+	verify              bool
+	doCreateDB          bool
+	doAbortOnExist      bool
+	abortOnError        bool
+	batchRetries        int
+	batchRetryBackoff   time.Duration
+	failedBatchFile     string
+	onDecodeError       string
+	workerDistribution  string
+	workerRamp          string
+	reportingPeriod     time.Duration
+	metricsAddr         string
+	fileName            string
+	scanStreams         int
+	resultsFile         string
 
 	// non-flag fields
 	br        *bufio.Reader
 	metricCnt uint64
 	rowCnt    uint64
+	start     time.Time
+
+	// streams are the readers of the streams of the input after that of br,
+	// if -file has several files read in -scan-streams streams
+	streams []*bufio.Reader
+	// readHeader reads the header of each of the files of -file, if the
+	// Benchmark is a HeaderReader
+	readHeader func(*bufio.Reader) (string, error)
+
+	// live are the metrics exposed on -metrics-addr, nil if not set
+	live *liveMetrics
+
+	// input counts the bytes read from the input, of inputSize if it is a
+	// regular file, 0 otherwise, and itemsRead the items decoded from it
+	input     *countingReader
+	inputSize int64
+	itemsRead uint64
+
+	// scanBlocked is the time in nanoseconds the scanner was blocked waiting
+	// for the workers to load the batches outstanding, and scanTook the time
+	// it took to read the input, once read
+	scanBlocked int64
+	scanTook    int64
+
+	// stop is closed for no more of the input to be read, once maxDuration
+	// passed, setting timeLimited to 1, or the load is interrupted, setting
+	// interrupted to 1. stopped is set to 1 once reading stopped so.
+	stop        chan struct{}
+	stopOnce    sync.Once
+	stopped     uint32
+	timeLimited uint32
+	interrupted uint32
+
+	// warmupTook is the time in nanoseconds the warmup took once it ended,
+	// and warmupMetricCnt and warmupRowCnt what was loaded during it
+	warmupOnce      sync.Once
+	warmupTook      int64
+	warmupMetricCnt uint64
+	warmupRowCnt    uint64
+
+	// errCnts are the numbers of batches each worker failed to load, and
+	// errMessages the messages of the first errors, with a ProcessorWithError.
+	// aborted is set to 1 once the load is aborted on an error.
+	errMutex    sync.Mutex
+	errCnts     map[int]uint64
+	errMessages []string
+	abortOnce   sync.Once
+	aborted     uint32
+
+	// retriedBatches is the number of times batches were retried, and
+	// spilledBatches the number written to failedBatchOut, of -failed-batch-file
+	retriedBatches uint64
+	spilledBatches uint64
+	spillMutex     sync.Mutex
+	failedBatchOut *os.File
+
+	// decodeErrCnt is the number of items of the input that failed to be
+	// decoded and decodeErrMessages the messages of the first of them
+	decodeErrCnt      uint64
+	decodeErrMessages []string
+
+	// workerStats are the stats of the workers done loading, and
+	// rampPlateaus what was loaded over each plateau of -worker-ramp
+	statsMutex   sync.Mutex
+	workerStats  []WorkerStats
+	rampPlateaus []rampPlateau
+
+	// ramp is the schedule of -worker-ramp, if set, of the workers waiting
+	// for their rampGates to be closed to load, or rampEnd once the input
+	// is read
+	ramp      *workerRamp
+	rampGates []chan struct{}
+	rampEnd   chan struct{}
 }
 
 var loader = &BenchmarkRunner{}
@@ -84,13 +185,35 @@ func GetBenchmarkRunnerWithBatchSize(batchSize uint) *BenchmarkRunner {
 	// fill flag fields of BenchmarkRunner struct
 	flag.StringVar(&loader.dbName, "db-name", "benchmark", "Name of database")
 	flag.UintVar(&loader.batchSize, "batch-size", batchSize, "Number of items to batch together in a single insert")
+	flag.Uint64Var(&loader.batchBytes, "batch-size-bytes", 0, "Size in bytes of the items of a batch, as measured by the databases supporting it, at which it is inserted if it does not have -batch-size items first (0 = no limit).")
+	flag.IntVar(&loader.maxOutstanding, "max-outstanding-batches", 0, "Number of batches read ahead of the workers, sent to them or queued to be and not loaded yet, at which the input is not read until one is loaded (0 = 3 times what the queues of the workers hold, unless -max-outstanding-bytes is set).")
+	flag.Uint64Var(&loader.maxOutstandingBytes, "max-outstanding-bytes", 0, "Size in bytes of the items of the batches read ahead of the workers, as measured by the databases supporting it, at which the input is not read until one is loaded, to bound the memory they take (0 = no limit).")
 	flag.UintVar(&loader.workers, "workers", 1, "Number of parallel clients inserting")
 	flag.Uint64Var(&loader.limit, "limit", 0, "Number of items to insert (0 = all of them).")
+	flag.Float64Var(&loader.rateLimit, "rate-limit", 0, "Rows per second to load at most, the input being read at that rate (0 = no limit).")
+	flag.Float64Var(&loader.rateLimitMetric, "rate-limit-metrics", 0, "Metrics per second to load at most, the input being read at that rate as estimated by the metrics per row loaded so far (0 = no limit).")
+	flag.DurationVar(&loader.maxDuration, "max-duration", 0, "Duration after which no more items are read, those read being inserted, whether or not -limit items were (0 = no limit).")
+	flag.DurationVar(&loader.warmup, "warmup", 0, "Duration of the warmup of the load, e.g., while connections are set up and caches warm, after which the sustained rates are measured and printed in the summary besides those of the whole load (0 = no warmup, unless -warmup-rows is set).")
+	flag.Uint64Var(&loader.warmupRows, "warmup-rows", 0, "Number of rows loaded during the warmup of the load, after which the sustained rates are measured, the warmup ending once either it or -warmup is reached (0 = no warmup, unless -warmup is set).")
+	flag.Float64Var(&loader.replaySpeed, "replay-speed", 0, "Speed to replay the input at, relative to the pace of the timestamps of its points, e.g., 10 for 10 seconds of them to be loaded each second, the points being read as fast as possible otherwise. Use with -max-batch-age for the batches not to wait for -batch-size points. Only for databases whose points have timestamps (0 = as fast as possible).")
+	flag.DurationVar(&loader.maxBatchAge, "max-batch-age", 0, "Age of the first point of a batch not full at which it is sent anyway, even while the input is waited for, e.g., with -replay-speed, -rate-limit or a slow pipe, for the batches to be bounded in time rather than size (0 = sent once full).")
 	flag.BoolVar(&loader.doLoad, "do-load", true, "Whether to write data. Set this flag to false to check input read speed.")
+	flag.BoolVar(&loader.dryRun, "dry-run", false, "Whether to only read, decode and batch the input, counting the rows and metrics of the batches rather than loading them, to measure the rate the loader reads the input at without the database. Implies -do-load=false.")
+	flag.BoolVar(&loader.verify, "post-load-verify", false, "Whether to check, once loaded, that the database holds what was loaded, e.g., the rows of each table, failing if it does not. Only for databases whose benchmark supports it.")
 	flag.BoolVar(&loader.doCreateDB, "do-create-db", true, "Whether to create the database. Disable on all but one client if running on a multi client setup.")
 	flag.BoolVar(&loader.doAbortOnExist, "do-abort-on-exist", false, "Whether to abort if a database with the given name already exists.")
+	flag.BoolVar(&loader.abortOnError, "abort-on-error", true, "Whether to abort on the first batch that fails to be loaded, rather than skip it and report the errors in the summary. Only for databases whose processor returns errors.")
+	flag.IntVar(&loader.batchRetries, "batch-retries", 0, "Number of times to load again a batch that failed to be loaded with an error the database may not return again, e.g., as it was busy, before it fails for good. Only for databases whose processor returns such errors (0 = not retried).")
+	flag.DurationVar(&loader.batchRetryBackoff, "batch-retry-backoff", time.Second, "Time to wait for before retrying a batch with -batch-retries, doubled on each retry of the batch, the worker loading the other batches meanwhile.")
+	flag.StringVar(&loader.failedBatchFile, "failed-batch-file", "", "File to write the batches that fail to be loaded for good to, as they were in the input, for them to be loaded later. Only for databases whose batches can be written back (empty = not written).")
+	flag.StringVar(&loader.onDecodeError, "on-decode-error", decodeErrorAbort, "Whether to abort on the first item of the input that fails to be decoded, or skip it and report the errors in the summary (abort|skip). Only for databases whose decoder returns errors.")
+	flag.StringVar(&loader.workerDistribution, "worker-distribution", workerDistributionLoader, "How the points are distributed over the work queues of the workers, unless the database distributes them itself, e.g., by host: round-robin, for each queue to get as many, or least-loaded, for each to go to the queue with the fewest batches outstanding, each worker then having a queue of its own (empty = as the database does, usually on a queue shared by the workers).")
+	flag.StringVar(&loader.workerRamp, "worker-ramp", "", "Schedule of workers to load with, e.g., start=4,step=4,every=2m for 4 workers to load at first and 4 more every 2 minutes up to -workers, the rates of each number of workers being printed in the summary. Not with -hash-workers or -worker-distribution, the workers needing to share a queue (empty = all the workers at once).")
 	flag.DurationVar(&loader.reportingPeriod, "reporting-period", 10*time.Second, "Period to report write stats")
-	flag.StringVar(&loader.fileName, "file", "", "File name to read data from")
+	flag.StringVar(&loader.metricsAddr, "metrics-addr", "", "Address to serve the metrics of the load on while it runs, e.g., :9100, on /metrics in the Prometheus text format: items read, rows and metrics loaded, batches and insert time histogram of each worker, queue depths and errors (empty = not served).")
+	flag.StringVar(&loader.resultsFile, "results-file", "", "File to write the results of the load to at its end, as JSON, for scripts to read them rather than parse the summary (empty = not written).")
+	flag.StringVar(&loader.fileName, "file", "", "File name to read data from, decompressed if it is gzip- or zstd-compressed, rather than stdin. Several files can be given as a comma-separated list of names or glob patterns, e.g., data/cpu-*.gz, read one after the other, those matching a pattern sorted, the header of each being checked to be that of the first and skipped by the databases whose input has one.")
+	flag.IntVar(&loader.scanStreams, "scan-streams", 1, "Number of streams to read the files of -file in concurrently, each decoding a share of the files, the points of all of them being batched for the same workers, for the loader not to be limited by the rate one stream is decoded at (0 = 1).")
 
 	return loader
 }
@@ -103,6 +226,81 @@ func (l *BenchmarkRunner) DatabaseName() string {
 // RunBenchmark takes in a Benchmark b, a bufio.Reader br, and holders for number of metrics and rows
 // and uses those to run the load benchmark
 func (l *BenchmarkRunner) RunBenchmark(b Benchmark, workQueues uint) {
+	if l.onDecodeError != decodeErrorAbort && l.onDecodeError != decodeErrorSkip {
+		fatal("invalid -on-decode-error %s: must be %s or %s", l.onDecodeError, decodeErrorAbort, decodeErrorSkip)
+		return
+	}
+	if err := validateWorkerDistribution(l.workerDistribution); err != nil {
+		fatal("%v", err)
+		return
+	}
+	if l.maxOutstanding < 0 {
+		fatal("invalid -max-outstanding-batches %d: must not be negative", l.maxOutstanding)
+		return
+	}
+	if l.replaySpeed < 0 || l.maxBatchAge < 0 {
+		fatal("-replay-speed and -max-batch-age must not be negative")
+		return
+	}
+	if l.batchRetries < 0 || l.batchRetryBackoff < 0 {
+		fatal("-batch-retries and -batch-retry-backoff must not be negative")
+		return
+	}
+	if l.scanStreams < 0 {
+		fatal("invalid -scan-streams %d: must not be negative", l.scanStreams)
+		return
+	}
+	if l.verify {
+		if _, ok := b.(Verifier); !ok {
+			fatal("-post-load-verify is not supported by this loader")
+			return
+		}
+		if l.dryRun || !l.doLoad {
+			fatal("-post-load-verify needs the data to be loaded: not with -dry-run or -do-load=false")
+			return
+		}
+	}
+	if len(l.workerRamp) > 0 {
+		ramp, err := parseWorkerRamp(l.workerRamp)
+		if err != nil {
+			fatal("%v", err)
+			return
+		}
+		l.ramp = ramp
+	}
+	// Nothing is loaded, the batches being counted instead
+	if l.dryRun {
+		l.doLoad = false
+	}
+	// The points are distributed over the queues, one per worker
+	if l.workerDistribution != workerDistributionLoader {
+		workQueues = WorkerPerQueue
+	}
+
+	// Interrupting the load stops it reading the input, for what was read to
+	// be loaded and the summary printed, rather than kills it mid-insert
+	l.stop = make(chan struct{})
+	interrupts := make(chan os.Signal, 2)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	go l.handleInterrupts(interrupts)
+
+	if len(l.failedBatchFile) > 0 {
+		if _, ok := b.GetBatchFactory().New().(BatchSerializer); !ok {
+			fatal("-failed-batch-file is not supported by this loader")
+			return
+		}
+		f, err := os.Create(l.failedBatchFile)
+		if err != nil {
+			fatal("cannot create -failed-batch-file: %v", err)
+			return
+		}
+		defer f.Close()
+		l.failedBatchOut = f
+	}
+	if h, ok := b.(HeaderReader); ok {
+		l.readHeader = h.ReadHeader
+	}
 	l.br = l.GetBufferedReader()
 
 	// Create required DB
@@ -110,51 +308,109 @@ func (l *BenchmarkRunner) RunBenchmark(b Benchmark, workQueues uint) {
 	defer cleanupFn()
 
 	channels := l.createChannels(workQueues)
+	// The workers activated by the ramp take the batches of those before
+	if l.ramp != nil && len(channels) > 1 {
+		fatal("-worker-ramp needs the workers to share a queue: not with -hash-workers or -worker-distribution")
+		return
+	}
+
+	if len(l.metricsAddr) > 0 {
+		live, err := l.serveMetrics(channels)
+		if err != nil {
+			fatal("cannot serve -metrics-addr: %v", err)
+			return
+		}
+		defer live.close()
+		l.live = live
+	}
 
 	// Launch all worker processes in background
 	var wg sync.WaitGroup
+	stopRamp := func() {}
+	if l.ramp != nil {
+		stopRamp = l.startWorkerRamp()
+	}
 	for i := 0; i < int(l.workers); i++ {
 		wg.Add(1)
 		go l.work(b, &wg, channels[i%len(channels)], i)
 	}
 
 	// Start scan process - actual data read process
-	start := time.Now()
+	l.start = time.Now()
 	l.scan(b, channels)
 
 	// After scan process completed (no more data to come) - begin shutdown process
 
-	// Close all communication channels to/from workers
+	// Close all communication channels to/from workers, the workers the ramp
+	// did not activate yet having nothing left to load
 	for _, c := range channels {
 		c.close()
 	}
+	if l.ramp != nil {
+		close(l.rampEnd)
+	}
 
 	// Wait for all workers to finish
 	wg.Wait()
+	stopRamp()
 	end := time.Now()
 
-	l.summary(end.Sub(start))
+	l.summary(end.Sub(l.start))
+	l.result(end.Sub(l.start))
+	l.postLoadVerify(b)
 }
 
-// GetBufferedReader returns the buffered Reader that should be used by the loader
+// GetBufferedReader returns the buffered Reader that should be used by the
+// loader, of the data of -file, decompressed if it is gzip- or zstd-compressed,
+// or of stdin. If -file has several files, it is that of the first stream of
+// them, starting with the header of the first file.
 func (l *BenchmarkRunner) GetBufferedReader() *bufio.Reader {
 	if l.br == nil {
-		if len(l.fileName) > 0 {
-			// Read from specified file
-			file, err := os.Open(l.fileName)
-			if err != nil {
-				fatal("cannot open file for read %s: %v", l.fileName, err)
-				return nil
-			}
-			l.br = bufio.NewReaderSize(file, defaultReadSize)
-		} else {
-			// Read from STDIN
-			l.br = bufio.NewReaderSize(os.Stdin, defaultReadSize)
+		files, err := inputFiles(l.fileName)
+		if err != nil {
+			fatal("%v", err)
+			return nil
+		}
+		if len(files) > 1 {
+			return l.openFiles(files)
 		}
+		fileName := l.fileName
+		if len(files) == 1 {
+			fileName = files[0]
+		}
+		input, size, br, err := openInput(fileName)
+		if err != nil {
+			fatal("cannot open file for read %s: %v", fileName, err)
+			return nil
+		}
+		l.input, l.inputSize, l.br = input, size, br
 	}
 	return l.br
 }
 
+// openFiles opens the files of -file to be read in -scan-streams streams,
+// after checking that their headers match, returning the reader of the first
+// stream
+func (l *BenchmarkRunner) openFiles(files []string) *bufio.Reader {
+	if l.readHeader != nil {
+		if err := checkHeaders(files, l.readHeader); err != nil {
+			fatal("%v", err)
+			return nil
+		}
+	}
+	streams := l.scanStreams
+	if streams < 1 {
+		streams = 1
+	}
+	input, size, brs, err := openFiles(files, streams, l.readHeader)
+	if err != nil {
+		fatal("cannot open file for read: %v", err)
+		return nil
+	}
+	l.input, l.inputSize, l.br, l.streams = input, size, brs[0], brs[1:]
+	return l.br
+}
+
 // useDBCreator handles a DBCreator by running it according to flags set by the
 // user. The function returns a function that the caller should defer or run
 // when the benchmark is finished
@@ -195,7 +451,10 @@ func (l *BenchmarkRunner) useDBCreator(dbc DBCreator) func() {
 
 		switch dbcp := dbc.(type) {
 		case DBCreatorPost:
-			dbcp.PostCreateDB(l.dbName)
+			err := dbcp.PostCreateDB(l.dbName)
+			if err != nil {
+				panic(err)
+			}
 		}
 	}
 	return closeFn
@@ -237,42 +496,250 @@ func (l *BenchmarkRunner) scan(b Benchmark, channels []*duplexChannel) uint64 {
 	}
 
 	// Scan incoming data
-	return scanWithIndexer(channels, l.batchSize, l.limit, l.br, b.GetPointDecoder(l.br), b.GetBatchFactory(), b.GetPointIndexer(uint(len(channels))))
+	decoder := b.GetPointDecoder(l.br)
+	if len(l.streams) > 0 {
+		decoders, readers := []PointDecoder{decoder}, []*bufio.Reader{l.br}
+		for _, br := range l.streams {
+			decoders = append(decoders, b.GetPointDecoder(br))
+			readers = append(readers, br)
+		}
+		var streams *streamsDecoder
+		decoder, streams = newStreamsDecoder(decoders, readers)
+		defer streams.close()
+	}
+	if d, ok := decoder.(PointDecoderWithError); ok {
+		decoder = &errorDecoder{decoder: d, l: l}
+	}
+	decoder = &countingDecoder{PointDecoder: decoder, n: &l.itemsRead}
+	if l.rateLimit > 0 || l.rateLimitMetric > 0 {
+		d := &rateLimitedDecoder{PointDecoder: decoder, metricCnt: &l.metricCnt, rowCnt: &l.rowCnt}
+		// Bursts of a batch at most, of the rows of any of the channels
+		if l.rateLimit > 0 {
+			d.rows = newTokenBucket(l.rateLimit, float64(l.batchSize))
+		}
+		if l.rateLimitMetric > 0 {
+			d.metrics = newTokenBucket(l.rateLimitMetric, float64(l.batchSize))
+		}
+		decoder = d
+	}
+	if l.stop == nil {
+		l.stop = make(chan struct{})
+	}
+	if l.maxDuration > 0 {
+		timer := time.AfterFunc(time.Until(l.start.Add(l.maxDuration)), func() { l.stopReading(&l.timeLimited) })
+		defer timer.Stop()
+	}
+	decoder = &stopDecoder{PointDecoder: decoder, stop: l.stop, stopped: &l.stopped}
+	pacing := scanPacing{stop: l.stop, maxBatchAge: l.maxBatchAge}
+	if l.replaySpeed > 0 {
+		pacing.replay = &replayClock{speed: l.replaySpeed}
+	}
+	read := scanWithIndexer(channels, l.batchSize, l.batchBytes, l.limit, l.br, decoder, b.GetBatchFactory(), l.pointIndexer(b, uint(len(channels))), outstandingLimit{batches: l.maxOutstanding, bytes: l.maxOutstandingBytes}, pacing, &l.scanBlocked)
+	atomic.StoreInt64(&l.scanTook, int64(time.Since(l.start)))
+	return read
+}
+
+// stopDecoder decodes no more points once stop is closed, as if the input
+// ended, setting stopped to 1
+type stopDecoder struct {
+	PointDecoder
+	stop    <-chan struct{}
+	stopped *uint32
+}
+
+// Decode decodes a point, unless reading the input was stopped
+func (d *stopDecoder) Decode(br *bufio.Reader) *Point {
+	select {
+	case <-d.stop:
+		atomic.StoreUint32(d.stopped, 1)
+		return nil
+	default:
+	}
+	return d.PointDecoder.Decode(br)
+}
+
+// stopReading stops the reading of the input, setting reason to 1
+func (l *BenchmarkRunner) stopReading(reason *uint32) {
+	atomic.StoreUint32(reason, 1)
+	l.stopOnce.Do(func() { close(l.stop) })
+}
+
+// handleInterrupts stops the reading of the input on the first of interrupts,
+// the batches outstanding being loaded and the processors closed, and exits
+// on the second
+func (l *BenchmarkRunner) handleInterrupts(interrupts <-chan os.Signal) {
+	<-interrupts
+	printFn("\ninterrupted: loading what was read, interrupt again to exit at once\n")
+	l.stopReading(&l.interrupted)
+	<-interrupts
+	fatal("interrupted again, exiting without loading what was read")
 }
 
 // work is the processing function for each worker in the loader
 func (l *BenchmarkRunner) work(b Benchmark, wg *sync.WaitGroup, c *duplexChannel, workerNum int) {
+	if !l.waitForRamp(workerNum) {
+		wg.Done()
+		return
+	}
 
-	// Prepare processor
-	proc := b.GetProcessor()
+	// Prepare processor, the batches only being counted in a dry run
+	var proc Processor = &dryRunProcessor{}
+	if !l.dryRun {
+		proc = b.GetProcessor()
+	}
 	proc.Init(workerNum, l.doLoad)
 
+	stats := WorkerStats{Worker: workerNum}
+
 	// Process batches coming from duplexChannel.toWorker queue
 	// and send ACKs into duplexChannel.toScanner queue
-	for b := range c.toWorker {
-		metricCnt, rowCnt := proc.ProcessBatch(b, l.doLoad)
+	for q := range c.toWorker {
+		b := q.Batch
+		var metricCnt, rowCnt uint64
+		var err error
+		start := time.Now()
+		stats.QueueWait += start.Sub(q.queued)
+		switch p := proc.(type) {
+		case ProcessorWithError:
+			metricCnt, rowCnt, err = p.ProcessBatchWithError(b, l.doLoad)
+		default:
+			metricCnt, rowCnt = proc.ProcessBatch(b, l.doLoad)
+		}
+		took := time.Since(start)
+		stats.add(metricCnt, rowCnt, took)
+		l.live.observeBatch(workerNum, took)
 		atomic.AddUint64(&l.metricCnt, metricCnt)
 		atomic.AddUint64(&l.rowCnt, rowCnt)
+		if l.warmingUp(time.Now()) {
+			stats.addWarmup(rowCnt)
+		}
+		if err != nil {
+			if l.retryBatch(c, q, err, workerNum) {
+				continue
+			}
+			l.spillBatch(b)
+			l.batchFailed(workerNum, err)
+		}
 		c.sendToScanner()
 	}
 
+	// Flush what proc held back of the batches, if any
+	if p, ok := proc.(ProcessorFlusher); ok {
+		start := time.Now()
+		metricCnt, rowCnt, err := p.Flush(l.doLoad)
+		// Counted as a batch only if it loaded what was held back
+		if metricCnt > 0 || rowCnt > 0 {
+			stats.add(metricCnt, rowCnt, time.Since(start))
+		}
+		atomic.AddUint64(&l.metricCnt, metricCnt)
+		atomic.AddUint64(&l.rowCnt, rowCnt)
+		if l.warmingUp(time.Now()) && (metricCnt > 0 || rowCnt > 0) {
+			stats.addWarmup(rowCnt)
+		}
+		if err != nil {
+			l.batchFailed(workerNum, err)
+		}
+	}
+
 	// Close proc if necessary
 	switch c := proc.(type) {
 	case ProcessorCloser:
 		c.Close(l.doLoad)
 	}
 
+	l.addWorkerStats(stats)
 	wg.Done()
 }
 
+// batchFailed records err, of a batch worker workerNum failed to load, and
+// aborts the load with the summary of what was loaded so far, if it must
+func (l *BenchmarkRunner) batchFailed(workerNum int, err error) {
+	l.errMutex.Lock()
+	if l.errCnts == nil {
+		l.errCnts = make(map[int]uint64)
+	}
+	l.errCnts[workerNum]++
+	if len(l.errMessages) < maxErrorMessages {
+		l.errMessages = append(l.errMessages, fmt.Sprintf("worker %d: %v", workerNum, err))
+	}
+	l.errMutex.Unlock()
+
+	if l.abortOnError {
+		l.abortOnce.Do(func() {
+			atomic.StoreUint32(&l.aborted, 1)
+			took := time.Since(l.start)
+			l.summary(took)
+			l.result(took)
+			fatal("worker %d failed to load a batch, aborting: %v", workerNum, err)
+		})
+	}
+}
+
 // summary prints the summary of statistics from loading
 func (l *BenchmarkRunner) summary(took time.Duration) {
-	metricRate := float64(l.metricCnt) / float64(took.Seconds())
+	// Workers may still be loading if the load is aborted
+	metricCnt, rowCnt := atomic.LoadUint64(&l.metricCnt), atomic.LoadUint64(&l.rowCnt)
+	metricRate := float64(metricCnt) / float64(took.Seconds())
 	printFn("\nSummary:\n")
-	printFn("loaded %d metrics in %0.3fsec with %d workers (mean rate %0.2f metrics/sec)\n", l.metricCnt, took.Seconds(), l.workers, metricRate)
-	if l.rowCnt > 0 {
-		rowRate := float64(l.rowCnt) / float64(took.Seconds())
-		printFn("loaded %d rows in %0.3fsec with %d workers (mean rate %0.2f rows/sec)\n", l.rowCnt, took.Seconds(), l.workers, rowRate)
+	if l.dryRun {
+		printFn("dry run: nothing loaded, the metrics and rows being those of the batches read\n")
+	}
+	printFn("loaded %d metrics in %0.3fsec with %d workers (mean rate %0.2f metrics/sec)\n", metricCnt, took.Seconds(), l.workers, metricRate)
+	if rowCnt > 0 {
+		rowRate := float64(rowCnt) / float64(took.Seconds())
+		printFn("loaded %d rows in %0.3fsec with %d workers (mean rate %0.2f rows/sec)\n", rowCnt, took.Seconds(), l.workers, rowRate)
+	}
+	// The input is read in less time than took if the workers took longer,
+	// unless the load is aborted before it is read
+	if itemsRead := atomic.LoadUint64(&l.itemsRead); itemsRead > 0 {
+		readTook := time.Duration(atomic.LoadInt64(&l.scanTook))
+		if readTook == 0 {
+			readTook = took
+		}
+		printFn("read %d items in %0.3fsec (mean rate %0.2f items/sec)\n", itemsRead, readTook.Seconds(), float64(itemsRead)/readTook.Seconds())
+	}
+	if l.rateLimit > 0 {
+		rowRate := float64(rowCnt) / float64(took.Seconds())
+		printFn("rate limited to %0.2f rows/sec: achieved %0.2f rows/sec (%0.1f%%)\n", l.rateLimit, rowRate, 100*rowRate/l.rateLimit)
+	}
+	if l.rateLimitMetric > 0 {
+		printFn("rate limited to %0.2f metrics/sec: achieved %0.2f metrics/sec (%0.1f%%)\n", l.rateLimitMetric, metricRate, 100*metricRate/l.rateLimitMetric)
+	}
+	if atomic.LoadUint32(&l.interrupted) == 1 {
+		printFn("interrupted: stopped reading the input, what was read being loaded\n")
+	} else if atomic.LoadUint32(&l.stopped) == 1 && atomic.LoadUint32(&l.timeLimited) == 1 {
+		printFn("stopped reading the input after -max-duration %v\n", l.maxDuration)
+	}
+	l.warmupSummary(took)
+	l.workerSummary(took)
+	l.rampSummary()
+	l.decodeErrorSummary()
+	l.retrySummary()
+	l.errorSummary()
+}
+
+// errorSummary prints the numbers of batches each worker failed to load, if
+// any, and the first of their errors
+func (l *BenchmarkRunner) errorSummary() {
+	l.errMutex.Lock()
+	defer l.errMutex.Unlock()
+	if len(l.errCnts) == 0 {
+		return
+	}
+	workers := make([]int, 0, len(l.errCnts))
+	total := uint64(0)
+	for w, cnt := range l.errCnts {
+		workers = append(workers, w)
+		total += cnt
+	}
+	sort.Ints(workers)
+	printFn("failed to load all of %d batches:\n", total)
+	for _, w := range workers {
+		printFn("worker %d: %d batches\n", w, l.errCnts[w])
+	}
+	printFn("first errors:\n")
+	for _, m := range l.errMessages {
+		printFn("%s\n", m)
 	}
 }
 
@@ -283,7 +750,13 @@ func (l *BenchmarkRunner) report(period time.Duration) {
 	prevColCount := uint64(0)
 	prevRowCount := uint64(0)
 
-	printFn("time,per. metric/s,metric total,overall metric/s,per. row/s,row total,overall row/s\n")
+	// With a warmup, a last column tells whether the load was warming up
+	warmup := l.warmup > 0 || l.warmupRows > 0
+	header := "time,per. metric/s,metric total,overall metric/s,per. row/s,row total,overall row/s,items read,bytes read,percent read,eta sec"
+	if warmup {
+		header += ",warmup"
+	}
+	printFn("%s\n", header)
 	for now := range time.NewTicker(period).C {
 		cCount := atomic.LoadUint64(&l.metricCnt)
 		rCount := atomic.LoadUint64(&l.rowCnt)
@@ -292,12 +765,17 @@ func (l *BenchmarkRunner) report(period time.Duration) {
 		took := now.Sub(prevTime)
 		colrate := float64(cCount-prevColCount) / float64(took.Seconds())
 		overallColRate := float64(cCount) / float64(sinceStart.Seconds())
+		items, bytesRead, percent, eta := l.progress(sinceStart)
+		warmingUp := ""
+		if warmup {
+			warmingUp = fmt.Sprintf(",%t", l.warmingUp(now))
+		}
 		if rCount > 0 {
 			rowrate := float64(rCount-prevRowCount) / float64(took.Seconds())
 			overallRowRate := float64(rCount) / float64(sinceStart.Seconds())
-			printFn("%d,%0.2f,%E,%0.2f,%0.2f,%E,%0.2f\n", now.Unix(), colrate, float64(cCount), overallColRate, rowrate, float64(rCount), overallRowRate)
+			printFn("%d,%0.2f,%E,%0.2f,%0.2f,%E,%0.2f,%d,%d,%s,%s%s\n", now.Unix(), colrate, float64(cCount), overallColRate, rowrate, float64(rCount), overallRowRate, items, bytesRead, percent, eta, warmingUp)
 		} else {
-			printFn("%d,%0.2f,%E,%0.2f,-,-,-\n", now.Unix(), colrate, float64(cCount), overallColRate)
+			printFn("%d,%0.2f,%E,%0.2f,-,-,-,%d,%d,%s,%s%s\n", now.Unix(), colrate, float64(cCount), overallColRate, items, bytesRead, percent, eta, warmingUp)
 		}
 
 		prevColCount = cCount
@@ -0,0 +1,136 @@
+package load
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// insertSecondsBuckets are the upper bounds of the buckets of the histogram
+// of the time batches take to be loaded, those of the Prometheus clients by
+// default
+var insertSecondsBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// liveMetrics are the metrics of the load exposed on -metrics-addr in the
+// Prometheus text format while it runs, those kept by the BenchmarkRunner
+// anyway being read as they are scraped. A nil liveMetrics keeps none, for
+// the workers not to pay for them when -metrics-addr is not set.
+type liveMetrics struct {
+	l        *BenchmarkRunner
+	channels []*duplexChannel
+	workers  []*insertHistogram
+	server   *http.Server
+}
+
+// insertHistogram is the histogram of the time the batches of a worker take
+// to be loaded, its buckets counting those of each bound alone
+type insertHistogram struct {
+	buckets []uint64
+	count   uint64
+	sumNs   int64
+}
+
+// observe counts a batch loaded in took
+func (h *insertHistogram) observe(took time.Duration) {
+	seconds := took.Seconds()
+	i := sort.SearchFloat64s(insertSecondsBuckets, seconds)
+	if i < len(h.buckets) {
+		atomic.AddUint64(&h.buckets[i], 1)
+	}
+	atomic.AddInt64(&h.sumNs, int64(took))
+	atomic.AddUint64(&h.count, 1)
+}
+
+// serveMetrics starts the HTTP server of -metrics-addr, exposing the metrics
+// of the load on /metrics, returning them
+func (l *BenchmarkRunner) serveMetrics(channels []*duplexChannel) (*liveMetrics, error) {
+	listener, err := net.Listen("tcp", l.metricsAddr)
+	if err != nil {
+		return nil, err
+	}
+	m := &liveMetrics{l: l, channels: channels}
+	for i := uint(0); i < l.workers; i++ {
+		m.workers = append(m.workers, &insertHistogram{buckets: make([]uint64, len(insertSecondsBuckets))})
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(m.format())
+	})
+	m.server = &http.Server{Handler: mux}
+	go m.server.Serve(listener)
+	return m, nil
+}
+
+// close stops the HTTP server
+func (m *liveMetrics) close() {
+	if m != nil {
+		m.server.Close()
+	}
+}
+
+// observeBatch counts a batch worker workerNum loaded in took
+func (m *liveMetrics) observeBatch(workerNum int, took time.Duration) {
+	if m == nil || workerNum >= len(m.workers) {
+		return
+	}
+	m.workers[workerNum].observe(took)
+}
+
+// format returns the metrics in the Prometheus text format
+func (m *liveMetrics) format() []byte {
+	l := m.l
+	var buf bytes.Buffer
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	metric("tsbs_load_items_read_total", "counter", "Items of the input read and decoded.")
+	fmt.Fprintf(&buf, "tsbs_load_items_read_total %d\n", atomic.LoadUint64(&l.itemsRead))
+	metric("tsbs_load_bytes_read_total", "counter", "Bytes of the input read.")
+	fmt.Fprintf(&buf, "tsbs_load_bytes_read_total %d\n", l.input.bytesRead())
+	metric("tsbs_load_metrics_total", "counter", "Metrics loaded.")
+	fmt.Fprintf(&buf, "tsbs_load_metrics_total %d\n", atomic.LoadUint64(&l.metricCnt))
+	metric("tsbs_load_rows_total", "counter", "Rows loaded.")
+	fmt.Fprintf(&buf, "tsbs_load_rows_total %d\n", atomic.LoadUint64(&l.rowCnt))
+	metric("tsbs_load_scan_blocked_seconds_total", "counter", "Time the reading of the input was blocked waiting for the workers.")
+	fmt.Fprintf(&buf, "tsbs_load_scan_blocked_seconds_total %g\n", time.Duration(atomic.LoadInt64(&l.scanBlocked)).Seconds())
+
+	metric("tsbs_load_queue_depth", "gauge", "Batches queued for the workers of each queue and not taken yet.")
+	for i, ch := range m.channels {
+		fmt.Fprintf(&buf, "tsbs_load_queue_depth{queue=\"%d\"} %d\n", i, len(ch.toWorker))
+	}
+
+	metric("tsbs_load_batches_total", "counter", "Batches each worker loaded or failed to.")
+	for i, h := range m.workers {
+		fmt.Fprintf(&buf, "tsbs_load_batches_total{worker=\"%d\"} %d\n", i, atomic.LoadUint64(&h.count))
+	}
+	metric("tsbs_load_insert_seconds", "histogram", "Time each worker took to load its batches.")
+	for i, h := range m.workers {
+		cumulative := uint64(0)
+		for j, bound := range insertSecondsBuckets {
+			cumulative += atomic.LoadUint64(&h.buckets[j])
+			fmt.Fprintf(&buf, "tsbs_load_insert_seconds_bucket{worker=\"%d\",le=\"%g\"} %d\n", i, bound, cumulative)
+		}
+		count := atomic.LoadUint64(&h.count)
+		fmt.Fprintf(&buf, "tsbs_load_insert_seconds_bucket{worker=\"%d\",le=\"+Inf\"} %d\n", i, count)
+		fmt.Fprintf(&buf, "tsbs_load_insert_seconds_sum{worker=\"%d\"} %g\n", i, time.Duration(atomic.LoadInt64(&h.sumNs)).Seconds())
+		fmt.Fprintf(&buf, "tsbs_load_insert_seconds_count{worker=\"%d\"} %d\n", i, count)
+	}
+
+	metric("tsbs_load_failed_batches_total", "counter", "Batches each worker failed to load for good.")
+	l.errMutex.Lock()
+	for i := range m.workers {
+		fmt.Fprintf(&buf, "tsbs_load_failed_batches_total{worker=\"%d\"} %d\n", i, l.errCnts[i])
+	}
+	l.errMutex.Unlock()
+	metric("tsbs_load_retried_batches_total", "counter", "Times batches were retried with -batch-retries.")
+	fmt.Fprintf(&buf, "tsbs_load_retried_batches_total %d\n", atomic.LoadUint64(&l.retriedBatches))
+	metric("tsbs_load_decode_errors_total", "counter", "Items of the input that failed to be decoded.")
+	fmt.Fprintf(&buf, "tsbs_load_decode_errors_total %d\n", atomic.LoadUint64(&l.decodeErrCnt))
+	return buf.Bytes()
+}
@@ -0,0 +1,153 @@
+package load
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testMetricsBenchmark struct {
+	testSlowBenchmark
+}
+
+func (b *testMetricsBenchmark) GetDBCreator() DBCreator { return &testCreator{} }
+
+// scrapeMetric returns the value of the sample name of the metrics served on
+// addr, false if not served
+func scrapeMetric(addr, name string) (float64, bool) {
+	resp, err := http.Get("http://" + addr + "/metrics")
+	if err != nil {
+		return 0, false
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range strings.Split(string(body), "\n") {
+		if strings.HasPrefix(line, name+" ") {
+			v, err := strconv.ParseFloat(strings.TrimPrefix(line, name+" "), 64)
+			return v, err == nil
+		}
+	}
+	return 0, false
+}
+
+func TestServeMetrics(t *testing.T) {
+	// 50 batches taking 10ms each to load
+	fileName := writeTempInput(t, make([]byte, 500))
+	defer os.Remove(fileName)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	l := &BenchmarkRunner{
+		dbName:        "benchmark",
+		batchSize:     10,
+		workers:       2,
+		doLoad:        true,
+		onDecodeError: decodeErrorAbort,
+		fileName:      fileName,
+		metricsAddr:   addr,
+	}
+	done := make(chan struct{})
+	go func() {
+		l.RunBenchmark(&testMetricsBenchmark{}, SingleQueue)
+		close(done)
+	}()
+
+	var scraped []float64
+	var batches float64
+scraping:
+	for {
+		select {
+		case <-done:
+			break scraping
+		case <-time.After(20 * time.Millisecond):
+		}
+		if v, ok := scrapeMetric(addr, "tsbs_load_metrics_total"); ok {
+			scraped = append(scraped, v)
+		}
+		if v, ok := scrapeMetric(addr, `tsbs_load_batches_total{worker="0"}`); ok {
+			batches = v
+		}
+	}
+
+	if len(scraped) < 2 {
+		t.Fatalf("too few scrapes during the load: got %v", scraped)
+	}
+	if scraped[len(scraped)-1] <= scraped[0] {
+		t.Errorf("metrics loaded did not increase while loading: got %v", scraped)
+	}
+	for i := 1; i < len(scraped); i++ {
+		if scraped[i] < scraped[i-1] || scraped[i] > 500 {
+			t.Errorf("incorrect metrics loaded scraped: got %v", scraped)
+			break
+		}
+	}
+	if batches == 0 {
+		t.Errorf("no batches of worker 0 scraped")
+	}
+	// The server is closed with the load
+	if _, ok := scrapeMetric(addr, "tsbs_load_metrics_total"); ok {
+		t.Errorf("metrics still served once the load ended")
+	}
+}
+
+func TestFormatMetrics(t *testing.T) {
+	l := &BenchmarkRunner{workers: 2, itemsRead: 120, metricCnt: 1100, rowCnt: 110, scanBlocked: int64(1500 * time.Millisecond), retriedBatches: 3, decodeErrCnt: 1}
+	l.input = &countingReader{n: 4096}
+	l.errCnts = map[int]uint64{1: 2}
+	channels := []*duplexChannel{newDuplexChannel(4)}
+	channels[0].sendToWorker(&testBatch{})
+	m := &liveMetrics{l: l, channels: channels}
+	for i := uint(0); i < l.workers; i++ {
+		m.workers = append(m.workers, &insertHistogram{buckets: make([]uint64, len(insertSecondsBuckets))})
+	}
+	m.observeBatch(0, 3*time.Millisecond)
+	m.observeBatch(0, 200*time.Millisecond)
+	m.observeBatch(0, 20*time.Second)
+	m.observeBatch(1, 10*time.Millisecond)
+	// Workers the load has not got to are not counted
+	m.observeBatch(2, time.Second)
+
+	got := string(m.format())
+	for _, want := range []string{
+		"# TYPE tsbs_load_items_read_total counter\ntsbs_load_items_read_total 120\n",
+		"tsbs_load_bytes_read_total 4096\n",
+		"tsbs_load_metrics_total 1100\n",
+		"tsbs_load_rows_total 110\n",
+		"tsbs_load_scan_blocked_seconds_total 1.5\n",
+		"# TYPE tsbs_load_queue_depth gauge\ntsbs_load_queue_depth{queue=\"0\"} 1\n",
+		"tsbs_load_batches_total{worker=\"0\"} 3\ntsbs_load_batches_total{worker=\"1\"} 1\n",
+		"# TYPE tsbs_load_insert_seconds histogram\n",
+		"tsbs_load_insert_seconds_bucket{worker=\"0\",le=\"0.005\"} 1\n",
+		"tsbs_load_insert_seconds_bucket{worker=\"0\",le=\"0.1\"} 1\n",
+		"tsbs_load_insert_seconds_bucket{worker=\"0\",le=\"0.25\"} 2\n",
+		"tsbs_load_insert_seconds_bucket{worker=\"0\",le=\"10\"} 2\n",
+		"tsbs_load_insert_seconds_bucket{worker=\"0\",le=\"+Inf\"} 3\n",
+		"tsbs_load_insert_seconds_sum{worker=\"0\"} 20.203\n",
+		"tsbs_load_insert_seconds_count{worker=\"0\"} 3\n",
+		"tsbs_load_insert_seconds_bucket{worker=\"1\",le=\"0.005\"} 0\ntsbs_load_insert_seconds_bucket{worker=\"1\",le=\"0.01\"} 1\n",
+		"tsbs_load_failed_batches_total{worker=\"0\"} 0\ntsbs_load_failed_batches_total{worker=\"1\"} 2\n",
+		"tsbs_load_retried_batches_total 3\n",
+		"tsbs_load_decode_errors_total 1\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("metrics do not contain %q:\n%s", want, got)
+		}
+	}
+
+	// No metrics are kept without -metrics-addr
+	var none *liveMetrics
+	none.observeBatch(0, time.Second)
+	none.close()
+}
@@ -8,6 +8,26 @@ type Processor interface {
 	ProcessBatch(b Batch, doLoad bool) (metricCount, rowCount uint64)
 }
 
+// ProcessorWithError is a Processor whose batches may fail to be loaded, which
+// it returns the error of rather than panicking, so that the loader can skip
+// them or abort, reporting how far the load got
+type ProcessorWithError interface {
+	Processor
+	// ProcessBatchWithError handles a single batch of data, returning the
+	// counts of what was loaded of it along with any error
+	ProcessBatchWithError(b Batch, doLoad bool) (metricCount, rowCount uint64, err error)
+}
+
+// ProcessorFlusher is a ProcessorWithError that may hold back data of the
+// batches it handles, e.g., to load more of it at once, which it loads once
+// there are no more batches, before it is closed
+type ProcessorFlusher interface {
+	ProcessorWithError
+	// Flush handles the data held back, returning the counts of what was
+	// loaded of it along with any error
+	Flush(doLoad bool) (metricCount, rowCount uint64, err error)
+}
+
 // ProcessorCloser is a Processor that also needs to close or cleanup afterwards
 type ProcessorCloser interface {
 	Processor
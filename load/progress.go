@@ -0,0 +1,106 @@
+package load
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// countingReader counts the bytes read from r, for the progress of the load to
+// be reported while they are read
+type countingReader struct {
+	r io.Reader
+	n uint64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddUint64(&c.n, uint64(n))
+	return n, err
+}
+
+// bytesRead returns the number of bytes read so far
+func (c *countingReader) bytesRead() uint64 {
+	if c == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&c.n)
+}
+
+// inputSize returns the size of f if it is a regular file, 0 if its size is
+// not known, e.g., for a pipe
+func inputSize(f *os.File) int64 {
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return 0
+	}
+	return info.Size()
+}
+
+// countingDecoder counts the points decoded by its PointDecoder into n
+type countingDecoder struct {
+	PointDecoder
+	n *uint64
+}
+
+// Decode decodes a point, counting it
+func (d *countingDecoder) Decode(br *bufio.Reader) *Point {
+	p := d.PointDecoder.Decode(br)
+	if p != nil {
+		atomic.AddUint64(d.n, 1)
+	}
+	return p
+}
+
+// progress describes how far the reading of the input got sinceStart: the
+// items and bytes read, and, if the size of the input is known, the percent of
+// it read and the seconds left until it is all read at the mean rate so far,
+// "-" otherwise. Bytes are counted as they are read ahead of the items.
+func (l *BenchmarkRunner) progress(sinceStart time.Duration) (items, bytesRead uint64, percent, eta string) {
+	items, bytesRead = atomic.LoadUint64(&l.itemsRead), l.input.bytesRead()
+	percent, eta = "-", "-"
+	if l.inputSize <= 0 {
+		return items, bytesRead, percent, eta
+	}
+	size := uint64(l.inputSize)
+	if bytesRead > size {
+		bytesRead = size
+	}
+	percent = fmt.Sprintf("%0.2f", 100*float64(bytesRead)/float64(size))
+	if bytesRead > 0 && sinceStart > 0 {
+		rate := float64(bytesRead) / sinceStart.Seconds()
+		eta = fmt.Sprintf("%0.0f", float64(size-bytesRead)/rate)
+	}
+	return items, bytesRead, percent, eta
+}
+
+// result prints the line of the results of the load, which took took, and
+// writes them to -results-file if set
+func (l *BenchmarkRunner) result(took time.Duration) {
+	printFn("%s\n", l.resultLine(took))
+	if len(l.resultsFile) > 0 {
+		if err := l.writeResults(l.resultsFile, took); err != nil {
+			fatal("cannot write -results-file %s: %v", l.resultsFile, err)
+		}
+	}
+}
+
+// resultLine returns the results of the load, which took took, in a format
+// that stays the same for scripts to parse: space-separated key=value pairs
+// following "RESULT"
+func (l *BenchmarkRunner) resultLine(took time.Duration) string {
+	metricCnt, rowCnt := atomic.LoadUint64(&l.metricCnt), atomic.LoadUint64(&l.rowCnt)
+	items, bytesRead, _, _ := l.progress(took)
+	l.errMutex.Lock()
+	failed := uint64(0)
+	for _, cnt := range l.errCnts {
+		failed += cnt
+	}
+	l.errMutex.Unlock()
+	return fmt.Sprintf("RESULT metrics=%d rows=%d items=%d bytes=%d seconds=%0.3f workers=%d metric_rate=%0.2f row_rate=%0.2f failed_batches=%d",
+		metricCnt, rowCnt, items, bytesRead, took.Seconds(), l.workers,
+		float64(metricCnt)/took.Seconds(), float64(rowCnt)/took.Seconds(), failed)
+}
@@ -0,0 +1,138 @@
+package load
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestProgress(t *testing.T) {
+	data := bytes.Repeat([]byte{1}, 1000)
+	cases := []struct {
+		desc        string
+		size        int64
+		read        int
+		sinceStart  time.Duration
+		wantPercent string
+		wantEta     string
+	}{
+		{
+			desc:        "nothing read",
+			size:        1000,
+			sinceStart:  time.Second,
+			wantPercent: "0.00",
+			wantEta:     "-",
+		},
+		{
+			desc:        "quarter read",
+			size:        1000,
+			read:        250,
+			sinceStart:  time.Second,
+			wantPercent: "25.00",
+			wantEta:     "3",
+		},
+		{
+			desc:        "all read",
+			size:        1000,
+			read:        1000,
+			sinceStart:  2 * time.Second,
+			wantPercent: "100.00",
+			wantEta:     "0",
+		},
+		{
+			desc:        "size unknown",
+			read:        250,
+			sinceStart:  time.Second,
+			wantPercent: "-",
+			wantEta:     "-",
+		},
+	}
+	for _, c := range cases {
+		l := &BenchmarkRunner{input: &countingReader{r: bytes.NewReader(data)}, inputSize: c.size}
+		if _, err := io.ReadFull(l.input, make([]byte, c.read)); err != nil {
+			t.Fatalf("%s: cannot read: %v", c.desc, err)
+		}
+		_, bytesRead, percent, eta := l.progress(c.sinceStart)
+		if bytesRead != uint64(c.read) {
+			t.Errorf("%s: incorrect bytes read: got %d want %d", c.desc, bytesRead, c.read)
+		}
+		if percent != c.wantPercent {
+			t.Errorf("%s: incorrect percent: got %s want %s", c.desc, percent, c.wantPercent)
+		}
+		if eta != c.wantEta {
+			t.Errorf("%s: incorrect eta: got %s want %s", c.desc, eta, c.wantEta)
+		}
+	}
+}
+
+func TestProgressOfFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "tsbs-load")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	// More than the buffer of the reader reads at once
+	const size = 3 * defaultReadSize / 2
+	if _, err := f.Write(bytes.Repeat([]byte{1}, size)); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	l := &BenchmarkRunner{fileName: f.Name()}
+	br := l.GetBufferedReader()
+	if l.inputSize != size {
+		t.Errorf("incorrect input size: got %d want %d", l.inputSize, size)
+	}
+	decoder := &countingDecoder{PointDecoder: &testDecoder{}, n: &l.itemsRead}
+	for decoder.Decode(br) != nil {
+	}
+	items, bytesRead, percent, eta := l.progress(time.Second)
+	if items != size || bytesRead != size {
+		t.Errorf("incorrect items and bytes read: got %d and %d want %d", items, bytesRead, size)
+	}
+	if percent != "100.00" || eta != "0" {
+		t.Errorf("incorrect percent and eta: got %s and %s want 100.00 and 0", percent, eta)
+	}
+}
+
+func TestProgressOfPipe(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	go func() {
+		w.Write(bytes.Repeat([]byte{1}, 100))
+		w.Close()
+	}()
+	// As for stdin, the size of the input is not known but what is read is
+	l := &BenchmarkRunner{input: &countingReader{r: r}, inputSize: inputSize(r)}
+	if l.inputSize != 0 {
+		t.Errorf("incorrect input size of a pipe: got %d want 0", l.inputSize)
+	}
+	if _, err := ioutil.ReadAll(l.input); err != nil {
+		t.Fatal(err)
+	}
+	_, bytesRead, percent, eta := l.progress(time.Second)
+	if bytesRead != 100 || percent != "-" || eta != "-" {
+		t.Errorf("incorrect progress: got %d, %s and %s want 100, - and -", bytesRead, percent, eta)
+	}
+}
+
+func TestResultLine(t *testing.T) {
+	l := &BenchmarkRunner{
+		workers:   2,
+		metricCnt: 30,
+		rowCnt:    3,
+		itemsRead: 4,
+		input:     &countingReader{n: 500},
+		errCnts:   map[int]uint64{0: 1, 1: 2},
+	}
+	want := "RESULT metrics=30 rows=3 items=4 bytes=500 seconds=2.000 workers=2 metric_rate=15.00 row_rate=1.50 failed_batches=3"
+	if got := l.resultLine(2 * time.Second); got != want {
+		t.Errorf("incorrect result line\ngot %s\nwant %s", got, want)
+	}
+}
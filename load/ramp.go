@@ -0,0 +1,152 @@
+package load
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// workerRamp is the schedule of -worker-ramp: start workers load at first,
+// step more of them being activated every so often, up to -workers
+type workerRamp struct {
+	start int
+	step  int
+	every time.Duration
+}
+
+// parseWorkerRamp parses a -worker-ramp of the form start=4,step=4,every=2m
+func parseWorkerRamp(s string) (*workerRamp, error) {
+	r := &workerRamp{}
+	for _, kv := range strings.Split(s, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid -worker-ramp %s: want start=N,step=N,every=duration", s)
+		}
+		var err error
+		switch parts[0] {
+		case "start":
+			r.start, err = strconv.Atoi(parts[1])
+		case "step":
+			r.step, err = strconv.Atoi(parts[1])
+		case "every":
+			r.every, err = time.ParseDuration(parts[1])
+		default:
+			return nil, fmt.Errorf("invalid -worker-ramp %s: unknown %s", s, parts[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid -worker-ramp %s: %v", s, err)
+		}
+	}
+	if r.start < 1 || r.step < 1 || r.every <= 0 {
+		return nil, fmt.Errorf("invalid -worker-ramp %s: start and step must be at least 1 and every positive", s)
+	}
+	return r, nil
+}
+
+// rampPlateau is what was loaded over a plateau of a -worker-ramp, with as
+// many workers active
+type rampPlateau struct {
+	workers int
+	took    time.Duration
+	metrics uint64
+	rows    uint64
+}
+
+// startWorkerRamp activates the first workers of the ramp and the others on
+// its schedule, each worker waiting for its gate to be closed before loading.
+// The returned function stops the ramp, recording its last plateau, once the
+// workers are done.
+func (l *BenchmarkRunner) startWorkerRamp() func() {
+	l.rampGates = make([]chan struct{}, l.workers)
+	for i := range l.rampGates {
+		l.rampGates[i] = make(chan struct{})
+	}
+	l.rampEnd = make(chan struct{})
+	active := l.ramp.start
+	if active > len(l.rampGates) {
+		active = len(l.rampGates)
+	}
+	for i := 0; i < active; i++ {
+		close(l.rampGates[i])
+	}
+
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(l.ramp.every)
+		defer ticker.Stop()
+		start := time.Now()
+		metrics, rows := atomic.LoadUint64(&l.metricCnt), atomic.LoadUint64(&l.rowCnt)
+		record := func() {
+			now := time.Now()
+			m, r := atomic.LoadUint64(&l.metricCnt), atomic.LoadUint64(&l.rowCnt)
+			l.statsMutex.Lock()
+			l.rampPlateaus = append(l.rampPlateaus, rampPlateau{workers: active, took: now.Sub(start), metrics: m - metrics, rows: r - rows})
+			l.statsMutex.Unlock()
+			start, metrics, rows = now, m, r
+		}
+		for {
+			select {
+			case <-ticker.C:
+				// The last plateau lasts until the end of the load
+				if active == len(l.rampGates) {
+					continue
+				}
+				record()
+				for n := active + l.ramp.step; active < n && active < len(l.rampGates); active++ {
+					close(l.rampGates[active])
+				}
+			case <-stop:
+				record()
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// waitForRamp waits for worker workerNum to be activated by -worker-ramp, if
+// set, returning false if the input was read first
+func (l *BenchmarkRunner) waitForRamp(workerNum int) bool {
+	if l.rampGates == nil {
+		return true
+	}
+	select {
+	case <-l.rampGates[workerNum]:
+		return true
+	case <-l.rampEnd:
+		return false
+	}
+}
+
+// rampSummary prints the rates of each plateau of -worker-ramp, if set
+func (l *BenchmarkRunner) rampSummary() {
+	l.statsMutex.Lock()
+	defer l.statsMutex.Unlock()
+	if len(l.rampPlateaus) > 0 {
+		printFn("\nworker ramp:\n%s", formatRampPlateaus(l.rampPlateaus))
+	}
+}
+
+// formatRampPlateaus returns a table of the rates of plateaus
+func formatRampPlateaus(plateaus []rampPlateau) string {
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "workers\tsec\trows\tmetrics\trows/sec\tmetrics/sec\t\n")
+	for _, p := range plateaus {
+		rowRate, metricRate := 0.0, 0.0
+		if p.took > 0 {
+			rowRate, metricRate = float64(p.rows)/p.took.Seconds(), float64(p.metrics)/p.took.Seconds()
+		}
+		fmt.Fprintf(w, "%d\t%0.3f\t%d\t%d\t%0.2f\t%0.2f\t\n", p.workers, p.took.Seconds(), p.rows, p.metrics, rowRate, metricRate)
+	}
+	w.Flush()
+	return b.String()
+}
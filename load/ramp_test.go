@@ -0,0 +1,105 @@
+package load
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWorkerRamp(t *testing.T) {
+	cases := []struct {
+		desc      string
+		ramp      string
+		want      *workerRamp
+		wantError string
+	}{
+		{
+			desc: "valid",
+			ramp: "start=4,step=2,every=2m",
+			want: &workerRamp{start: 4, step: 2, every: 2 * time.Minute},
+		},
+		{
+			desc:      "missing step",
+			ramp:      "start=4,every=2m",
+			wantError: "invalid -worker-ramp start=4,every=2m: start and step must be at least 1 and every positive",
+		},
+		{
+			desc:      "unknown key",
+			ramp:      "start=4,step=2,every=2m,stop=8",
+			wantError: "invalid -worker-ramp start=4,step=2,every=2m,stop=8: unknown stop",
+		},
+		{
+			desc:      "invalid duration",
+			ramp:      "start=4,step=2,every=2",
+			wantError: "invalid -worker-ramp start=4,step=2,every=2: time: missing unit in duration \"2\"",
+		},
+		{
+			desc:      "not key=value",
+			ramp:      "4",
+			wantError: "invalid -worker-ramp 4: want start=N,step=N,every=duration",
+		},
+	}
+	for _, c := range cases {
+		got, err := parseWorkerRamp(c.ramp)
+		if c.wantError != "" {
+			if err == nil || err.Error() != c.wantError {
+				t.Errorf("%s: incorrect error: got %v want %s", c.desc, err, c.wantError)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: incorrect ramp: got %+v, %v want %+v", c.desc, got, err, c.want)
+		}
+	}
+}
+
+func TestFormatRampPlateaus(t *testing.T) {
+	plateaus := []rampPlateau{
+		{workers: 2, took: 2 * time.Second, rows: 100, metrics: 1000},
+		{workers: 4, took: time.Second, rows: 100, metrics: 1000},
+	}
+	want := strings.Join([]string{
+		"  workers    sec  rows  metrics  rows/sec  metrics/sec",
+		"        2  2.000   100     1000     50.00       500.00",
+		"        4  1.000   100     1000    100.00      1000.00",
+		"",
+	}, "\n")
+	if got := formatRampPlateaus(plateaus); got != want {
+		t.Errorf("incorrect table\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWorkerRamp(t *testing.T) {
+	// Far more points than can be loaded in time
+	fileName := writeTempInput(t, make([]byte, 1<<20))
+	defer os.Remove(fileName)
+	// Each worker loads a batch in 10ms, all of them sharing a queue
+	l := &BenchmarkRunner{
+		batchSize:     10,
+		workers:       3,
+		maxDuration:   900 * time.Millisecond,
+		workerRamp:    "start=1,step=1,every=300ms",
+		onDecodeError: decodeErrorAbort,
+		fileName:      fileName,
+	}
+	l.RunBenchmark(&testSlowBenchmark{}, SingleQueue)
+
+	if len(l.rampPlateaus) != 3 {
+		t.Fatalf("incorrect number of plateaus: got %+v want 3", l.rampPlateaus)
+	}
+	first := float64(l.rampPlateaus[0].metrics) / l.rampPlateaus[0].took.Seconds()
+	for i, p := range l.rampPlateaus {
+		if p.workers != i+1 {
+			t.Errorf("incorrect workers of plateau %d: got %d want %d", i, p.workers, i+1)
+		}
+		// The rate scales with the workers
+		if ratio := float64(p.metrics) / p.took.Seconds() / first; ratio < 0.8*float64(i+1) || ratio > 1.2*float64(i+1) {
+			t.Errorf("incorrect rate of plateau %d: got %0.2f times that of the first want %d", i, ratio, i+1)
+		}
+	}
+	if stats := l.WorkerStats(); len(stats) != 3 || stats[0].Batches <= stats[1].Batches || stats[1].Batches <= stats[2].Batches {
+		t.Errorf("workers not activated in turn: got %+v", stats)
+	}
+}
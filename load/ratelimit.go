@@ -0,0 +1,80 @@
+package load
+
+import (
+	"bufio"
+	"sync/atomic"
+	"time"
+)
+
+// minRateLimitSleep is the least time the scanner sleeps for to keep to a rate
+// limit, shorter waits being added up until they reach it
+const minRateLimitSleep = time.Millisecond
+
+// tokenBucket limits the rate of something to rate per second, letting bursts
+// of up to capacity through. Tokens taken beyond those in the bucket are owed,
+// the taker sleeping until they are refilled.
+type tokenBucket struct {
+	rate     float64
+	capacity float64
+	tokens   float64
+	last     time.Time
+	now      func() time.Time
+	sleep    func(time.Duration)
+}
+
+// newTokenBucket returns an empty tokenBucket of rate per second and capacity
+func newTokenBucket(rate, capacity float64) *tokenBucket {
+	return &tokenBucket{
+		rate:     rate,
+		capacity: capacity,
+		last:     time.Now(),
+		now:      time.Now,
+		sleep:    time.Sleep,
+	}
+}
+
+// take takes n tokens out of the bucket, sleeping until they are refilled if
+// there were not enough of them
+func (b *tokenBucket) take(n float64) {
+	now := b.now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return
+	}
+	if wait := time.Duration(-b.tokens / b.rate * float64(time.Second)); wait >= minRateLimitSleep {
+		b.sleep(wait)
+	}
+}
+
+// rateLimitedDecoder decodes points at most at the rates of its buckets of
+// rows and metrics, either of which may be nil, for the load offered to the
+// workers to be steady. A point is taken as a row, of as many metrics as the
+// rows loaded so far have on average, 1 until rows are loaded.
+type rateLimitedDecoder struct {
+	PointDecoder
+	rows    *tokenBucket
+	metrics *tokenBucket
+	// metricCnt and rowCnt are the counts of what was loaded
+	metricCnt *uint64
+	rowCnt    *uint64
+}
+
+// Decode decodes a point, once the rate limits let it
+func (d *rateLimitedDecoder) Decode(br *bufio.Reader) *Point {
+	if d.rows != nil {
+		d.rows.take(1)
+	}
+	if d.metrics != nil {
+		metricsPerRow := 1.0
+		if rowCnt := atomic.LoadUint64(d.rowCnt); rowCnt > 0 {
+			metricsPerRow = float64(atomic.LoadUint64(d.metricCnt)) / float64(rowCnt)
+		}
+		d.metrics.take(metricsPerRow)
+	}
+	return d.PointDecoder.Decode(br)
+}
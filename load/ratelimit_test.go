@@ -0,0 +1,165 @@
+package load
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Now()
+	cases := []struct {
+		desc       string
+		at         time.Duration
+		take       float64
+		wantSleep  time.Duration
+		wantTokens float64
+	}{
+		{
+			desc:       "refilled",
+			at:         100 * time.Millisecond,
+			take:       5,
+			wantTokens: 5,
+		},
+		{
+			desc:       "full at capacity",
+			at:         time.Second,
+			take:       4,
+			wantTokens: 6,
+		},
+		{
+			desc:       "short of tokens",
+			at:         time.Second,
+			take:       16,
+			wantSleep:  100 * time.Millisecond,
+			wantTokens: -10,
+		},
+		{
+			desc:       "owing tokens",
+			at:         1050 * time.Millisecond,
+			take:       1,
+			wantSleep:  60 * time.Millisecond,
+			wantTokens: -6,
+		},
+		{
+			desc:       "owing tokens for less than the least sleep",
+			at:         1110 * time.Millisecond,
+			take:       0.05,
+			wantTokens: -0.05,
+		},
+	}
+	b := newTokenBucket(100, 10)
+	b.last = start
+	now := start
+	b.now = func() time.Time { return now }
+	for _, c := range cases {
+		var slept time.Duration
+		b.sleep = func(d time.Duration) { slept = d }
+		now = start.Add(c.at)
+		b.take(c.take)
+		if slept.Round(time.Millisecond) != c.wantSleep {
+			t.Errorf("%s: incorrect sleep: got %v want %v", c.desc, slept, c.wantSleep)
+		}
+		if math.Abs(b.tokens-c.wantTokens) > 1e-9 {
+			t.Errorf("%s: incorrect tokens: got %f want %f", c.desc, b.tokens, c.wantTokens)
+		}
+	}
+}
+
+// testRowProcessor loads 2 metrics for each row of a batch, of a row per point
+type testRowProcessor struct {
+	testProcessor
+}
+
+func (p *testRowProcessor) ProcessBatch(b Batch, doLoad bool) (metricCount, rowCount uint64) {
+	return 2 * uint64(b.Len()), uint64(b.Len())
+}
+
+type testRowBenchmark struct {
+	testSlowBenchmark
+}
+
+func (b *testRowBenchmark) GetProcessor() Processor { return &testRowProcessor{} }
+
+func TestScanRateLimit(t *testing.T) {
+	const items = 2000
+	cases := []struct {
+		desc            string
+		rateLimit       float64
+		rateLimitMetric float64
+		wantRowRate     float64
+	}{
+		{
+			desc:        "rows",
+			rateLimit:   5000,
+			wantRowRate: 5000,
+		},
+		{
+			desc:            "metrics",
+			rateLimitMetric: 10000,
+			wantRowRate:     5000,
+		},
+		{
+			desc:            "rows before metrics",
+			rateLimit:       4000,
+			rateLimitMetric: 10000,
+			wantRowRate:     4000,
+		},
+	}
+	for _, c := range cases {
+		l := &BenchmarkRunner{
+			batchSize:       50,
+			workers:         4,
+			limit:           items,
+			rateLimit:       c.rateLimit,
+			rateLimitMetric: c.rateLimitMetric,
+			doLoad:          true,
+			br:              bufio.NewReader(bytes.NewReader(make([]byte, 2*items))),
+		}
+		b := &testRowBenchmark{}
+		channels := l.createChannels(WorkerPerQueue)
+		var wg sync.WaitGroup
+		for i := 0; i < int(l.workers); i++ {
+			wg.Add(1)
+			go l.work(b, &wg, channels[i], i)
+		}
+		l.start = time.Now()
+		l.scan(b, channels)
+		for _, ch := range channels {
+			ch.close()
+		}
+		wg.Wait()
+		took := time.Since(l.start)
+
+		if l.rowCnt != items {
+			t.Fatalf("%s: incorrect rows: got %d want %d", c.desc, l.rowCnt, items)
+		}
+		rate := float64(l.rowCnt) / took.Seconds()
+		if math.Abs(rate-c.wantRowRate)/c.wantRowRate > 0.05 {
+			t.Errorf("%s: incorrect rate: got %0.2f rows/sec want %0.2f", c.desc, rate, c.wantRowRate)
+		}
+	}
+}
+
+func TestRateLimitedDecoderMetricsPerRow(t *testing.T) {
+	var metricCnt, rowCnt uint64
+	b := newTokenBucket(1e9, 1e9)
+	var taken []float64
+	b.now = func() time.Time { return b.last }
+	d := &rateLimitedDecoder{PointDecoder: &testDecoder{}, metrics: b, metricCnt: &metricCnt, rowCnt: &rowCnt}
+	br := bufio.NewReader(bytes.NewReader(make([]byte, 3)))
+	for _, loaded := range [][2]uint64{{0, 0}, {30, 10}, {45, 10}} {
+		metricCnt, rowCnt = loaded[0], loaded[1]
+		tokens := b.tokens
+		d.Decode(br)
+		taken = append(taken, tokens-b.tokens)
+	}
+	// 1 metric per row until rows are loaded
+	if want := []float64{1, 3, 4.5}; !reflect.DeepEqual(taken, want) {
+		t.Errorf("incorrect metrics taken: got %v want %v", taken, want)
+	}
+}
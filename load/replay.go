@@ -0,0 +1,43 @@
+package load
+
+import (
+	"time"
+)
+
+// replayClock paces the points of the input for them to be dispatched at
+// speed times the pace of their timestamps, the first being dispatched at
+// once and those timed before it as well
+type replayClock struct {
+	speed float64
+
+	// start is the time the first point was dispatched at, and first its
+	// timestamp
+	start time.Time
+	first time.Time
+
+	// untimed is set once a point that is not Timestamped is dispatched
+	untimed bool
+}
+
+// due returns the time p is to be dispatched at, the zero Time for it to be
+// at once if it has no timestamp. Points that are not Timestamped fail the
+// load, the loader not supporting -replay-speed.
+func (c *replayClock) due(p *Point) time.Time {
+	t, ok := p.Data.(Timestamped)
+	if !ok {
+		if !c.untimed {
+			c.untimed = true
+			fatal("-replay-speed is not supported by this loader, whose points have no timestamp")
+		}
+		return time.Time{}
+	}
+	ts := t.Timestamp()
+	if ts.IsZero() {
+		return ts
+	}
+	if c.start.IsZero() {
+		c.start, c.first = time.Now(), ts
+		return c.start
+	}
+	return c.start.Add(time.Duration(float64(ts.Sub(c.first)) / c.speed))
+}
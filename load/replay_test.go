@@ -0,0 +1,180 @@
+package load
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testTimedPoint is the data of a point at a time
+type testTimedPoint time.Time
+
+func (p testTimedPoint) Timestamp() time.Time { return time.Time(p) }
+
+// testTimedDecoder decodes a point of each byte, each interval after the
+// previous one
+type testTimedDecoder struct {
+	next     time.Time
+	interval time.Duration
+}
+
+func (d *testTimedDecoder) Decode(br *bufio.Reader) *Point {
+	if _, err := br.ReadByte(); err != nil {
+		return nil
+	}
+	p := NewPoint(testTimedPoint(d.next))
+	d.next = d.next.Add(d.interval)
+	return p
+}
+
+// testTimedBatch is a batch of timed points
+type testTimedBatch struct {
+	len int
+}
+
+func (b *testTimedBatch) Len() int        { return b.len }
+func (b *testTimedBatch) Append(_ *Point) { b.len++ }
+
+type testTimedFactory struct{}
+
+func (f *testTimedFactory) New() Batch { return &testTimedBatch{} }
+
+// testBatchCountProcessor counts the batches and the points loaded
+type testBatchCountProcessor struct {
+	testProcessor
+	mu      *sync.Mutex
+	batches *int
+	points  *int
+}
+
+func (p *testBatchCountProcessor) ProcessBatch(b Batch, doLoad bool) (metricCount, rowCount uint64) {
+	p.mu.Lock()
+	*p.batches++
+	*p.points += b.Len()
+	p.mu.Unlock()
+	return uint64(b.Len()), uint64(b.Len())
+}
+
+type testReplayBenchmark struct {
+	testSlowBenchmark
+	mu      sync.Mutex
+	batches int
+	points  int
+}
+
+func (b *testReplayBenchmark) GetPointDecoder(_ *bufio.Reader) PointDecoder {
+	return &testTimedDecoder{next: time.Unix(1451606400, 0), interval: 100 * time.Millisecond}
+}
+func (b *testReplayBenchmark) GetBatchFactory() BatchFactory { return &testTimedFactory{} }
+func (b *testReplayBenchmark) GetProcessor() Processor {
+	return &testBatchCountProcessor{mu: &b.mu, batches: &b.batches, points: &b.points}
+}
+
+func TestScanReplay(t *testing.T) {
+	// 10 seconds of points, 100ms apart
+	const points = 101
+	cases := []struct {
+		desc        string
+		speed       float64
+		maxBatchAge time.Duration
+		wantTook    time.Duration
+		minBatches  int
+		maxBatches  int
+	}{
+		{
+			desc:        "10x in batches by age",
+			speed:       10,
+			maxBatchAge: 100 * time.Millisecond,
+			wantTook:    time.Second,
+			minBatches:  5,
+			maxBatches:  20,
+		},
+		{
+			desc:       "10x in batches by size",
+			speed:      10,
+			wantTook:   time.Second,
+			minBatches: 3,
+			maxBatches: 3,
+		},
+		{
+			desc:        "20x",
+			speed:       20,
+			maxBatchAge: 100 * time.Millisecond,
+			wantTook:    500 * time.Millisecond,
+			minBatches:  3,
+			maxBatches:  10,
+		},
+	}
+	for _, c := range cases {
+		l := &BenchmarkRunner{
+			batchSize:   40,
+			workers:     2,
+			doLoad:      true,
+			replaySpeed: c.speed,
+			maxBatchAge: c.maxBatchAge,
+			br:          bufio.NewReader(bytes.NewReader(make([]byte, points))),
+		}
+		b := &testReplayBenchmark{}
+		channels := l.createChannels(SingleQueue)
+		var wg sync.WaitGroup
+		for i := 0; i < int(l.workers); i++ {
+			wg.Add(1)
+			go l.work(b, &wg, channels[0], i)
+		}
+		l.start = time.Now()
+		l.scan(b, channels)
+		for _, ch := range channels {
+			ch.close()
+		}
+		wg.Wait()
+		took := time.Since(l.start)
+
+		if b.points != points {
+			t.Errorf("%s: incorrect points loaded: got %d want %d", c.desc, b.points, points)
+		}
+		if took < c.wantTook-50*time.Millisecond || took > c.wantTook+500*time.Millisecond {
+			t.Errorf("%s: incorrect time taken: got %v want about %v", c.desc, took, c.wantTook)
+		}
+		if b.batches < c.minBatches || b.batches > c.maxBatches {
+			t.Errorf("%s: incorrect batches: got %d want %d to %d", c.desc, b.batches, c.minBatches, c.maxBatches)
+		}
+	}
+}
+
+func TestReplayClock(t *testing.T) {
+	oldFatal := fatal
+	defer func() { fatal = oldFatal }()
+	var fatalMessages []string
+	fatal = func(format string, args ...interface{}) {
+		fatalMessages = append(fatalMessages, fmt.Sprintf(format, args...))
+	}
+
+	c := &replayClock{speed: 4}
+	first := time.Unix(1451606400, 0)
+	start := time.Now()
+	if due := c.due(NewPoint(testTimedPoint(first))); due.Before(start) || due.After(time.Now()) {
+		t.Errorf("first point not due at once: due %v after the start", due.Sub(start))
+	}
+	if due := c.due(NewPoint(testTimedPoint(first.Add(10 * time.Second)))); due.Sub(c.start) != 2500*time.Millisecond {
+		t.Errorf("incorrect time due: got %v after the first want %v", due.Sub(c.start), 2500*time.Millisecond)
+	}
+	if due := c.due(NewPoint(testTimedPoint(first.Add(-time.Second)))); !due.Before(c.start) {
+		t.Errorf("point timed before the first not due at once: due %v after it", due.Sub(c.start))
+	}
+	if due := c.due(NewPoint(testTimedPoint(time.Time{}))); !due.IsZero() {
+		t.Errorf("point without a timestamp not due at once: due %v", due)
+	}
+	for i := 0; i < 2; i++ {
+		if due := c.due(NewPoint(1)); !due.IsZero() {
+			t.Errorf("untimed point not due at once: due %v", due)
+		}
+	}
+	want := []string{"-replay-speed is not supported by this loader, whose points have no timestamp"}
+	if !reflect.DeepEqual(fatalMessages, want) {
+		t.Errorf("incorrect fatal messages: got %v want %v", fatalMessages, want)
+	}
+}
@@ -0,0 +1,136 @@
+package load
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// resultsSchemaVersion is the version of the document of -results-file, to be
+// increased whenever a key is removed or changes meaning
+const resultsSchemaVersion = 1
+
+// redactedFlag is the value of the flags of passwords in -results-file
+const redactedFlag = "<redacted>"
+
+// loadResults is the document of -results-file, written at the end of the
+// load for scripts to read its results from rather than parse the summary
+type loadResults struct {
+	SchemaVersion int     `json:"schema_version"`
+	DBName        string  `json:"db_name"`
+	Metrics       uint64  `json:"metrics"`
+	Rows          uint64  `json:"rows"`
+	ItemsRead     uint64  `json:"items_read"`
+	BytesRead     uint64  `json:"bytes_read"`
+	WallSeconds   float64 `json:"wall_seconds"`
+	MetricRate    float64 `json:"metric_rate"`
+	RowRate       float64 `json:"row_rate"`
+	BatchSize     uint    `json:"batch_size"`
+	Workers       uint    `json:"workers"`
+	// Interrupted, TimeLimited and Aborted tell whether the load stopped
+	// before the end of the input, on an interrupt, after -max-duration or
+	// on an error
+	Interrupted    bool   `json:"interrupted"`
+	TimeLimited    bool   `json:"time_limited"`
+	Aborted        bool   `json:"aborted"`
+	DryRun         bool   `json:"dry_run"`
+	FailedBatches  uint64 `json:"failed_batches"`
+	RetriedBatches uint64 `json:"retried_batches"`
+	SpilledBatches uint64 `json:"spilled_batches"`
+	DecodeErrors   uint64 `json:"decode_errors"`
+	// WarmupSeconds is the time the warmup took, with -warmup or
+	// -warmup-rows, and the sustained rates those after it
+	WarmupSeconds       float64           `json:"warmup_seconds,omitempty"`
+	SustainedMetricRate float64           `json:"sustained_metric_rate,omitempty"`
+	SustainedRowRate    float64           `json:"sustained_row_rate,omitempty"`
+	PerWorker           []workerResults   `json:"per_worker"`
+	Flags               map[string]string `json:"flags"`
+}
+
+// workerResults are the results of a worker in -results-file, its rates being
+// those of its insert time
+type workerResults struct {
+	Worker           int     `json:"worker"`
+	Metrics          uint64  `json:"metrics"`
+	Rows             uint64  `json:"rows"`
+	Batches          uint64  `json:"batches"`
+	WarmupBatches    uint64  `json:"warmup_batches"`
+	InsertSeconds    float64 `json:"insert_seconds"`
+	QueueWaitSeconds float64 `json:"queue_wait_seconds"`
+	MetricRate       float64 `json:"metric_rate"`
+	RowRate          float64 `json:"row_rate"`
+}
+
+// results returns the results of the load, which took took
+func (l *BenchmarkRunner) results(took time.Duration) *loadResults {
+	metricCnt, rowCnt := atomic.LoadUint64(&l.metricCnt), atomic.LoadUint64(&l.rowCnt)
+	items, bytesRead, _, _ := l.progress(took)
+	r := &loadResults{
+		SchemaVersion:  resultsSchemaVersion,
+		DBName:         l.dbName,
+		Metrics:        metricCnt,
+		Rows:           rowCnt,
+		ItemsRead:      items,
+		BytesRead:      bytesRead,
+		WallSeconds:    took.Seconds(),
+		MetricRate:     float64(metricCnt) / took.Seconds(),
+		RowRate:        float64(rowCnt) / took.Seconds(),
+		BatchSize:      l.batchSize,
+		Workers:        l.workers,
+		Interrupted:    atomic.LoadUint32(&l.interrupted) == 1,
+		TimeLimited:    atomic.LoadUint32(&l.stopped) == 1 && atomic.LoadUint32(&l.timeLimited) == 1,
+		Aborted:        atomic.LoadUint32(&l.aborted) == 1,
+		DryRun:         l.dryRun,
+		DecodeErrors:   atomic.LoadUint64(&l.decodeErrCnt),
+		RetriedBatches: atomic.LoadUint64(&l.retriedBatches),
+		SpilledBatches: atomic.LoadUint64(&l.spilledBatches),
+		PerWorker:      []workerResults{},
+		Flags:          flagValues(flag.CommandLine),
+	}
+	if warmupTook, warmupMetricCnt, warmupRowCnt := l.warmupCounts(); warmupTook > 0 {
+		r.WarmupSeconds = warmupTook.Seconds()
+		if sustained := (took - warmupTook).Seconds(); sustained > 0 {
+			r.SustainedMetricRate = float64(metricCnt-warmupMetricCnt) / sustained
+			r.SustainedRowRate = float64(rowCnt-warmupRowCnt) / sustained
+		}
+	}
+	l.errMutex.Lock()
+	for _, cnt := range l.errCnts {
+		r.FailedBatches += cnt
+	}
+	l.errMutex.Unlock()
+	for _, s := range l.WorkerStats() {
+		w := workerResults{Worker: s.Worker, Metrics: s.Metrics, Rows: s.Rows, Batches: s.Batches, WarmupBatches: s.WarmupBatches, InsertSeconds: s.InsertTime.Seconds(), QueueWaitSeconds: s.QueueWait.Seconds()}
+		if s.InsertTime > 0 {
+			w.MetricRate, w.RowRate = float64(s.Metrics)/s.InsertTime.Seconds(), float64(s.Rows)/s.InsertTime.Seconds()
+		}
+		r.PerWorker = append(r.PerWorker, w)
+	}
+	return r
+}
+
+// writeResults writes the results of the load, which took took, to fileName
+func (l *BenchmarkRunner) writeResults(fileName string, took time.Duration) error {
+	b, err := json.MarshalIndent(l.results(took), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, append(b, '\n'), 0644)
+}
+
+// flagValues returns the values of the flags of fs by name, those of
+// passwords being redacted
+func flagValues(fs *flag.FlagSet) map[string]string {
+	values := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if strings.Contains(f.Name, "pass") && len(value) > 0 {
+			value = redactedFlag
+		}
+		values[f.Name] = value
+	})
+	return values
+}
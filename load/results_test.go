@@ -0,0 +1,127 @@
+package load
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWriteResults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "results.json")
+
+	l := &BenchmarkRunner{
+		dbName:    "benchmark",
+		batchSize: 10,
+		workers:   2,
+		doLoad:    true,
+		br:        bufio.NewReader(bytes.NewReader(make([]byte, 100))),
+		errCnts:   map[int]uint64{1: 2},
+	}
+	b := &testRowBenchmark{}
+	channels := l.createChannels(WorkerPerQueue)
+	var wg sync.WaitGroup
+	for i := 0; i < int(l.workers); i++ {
+		wg.Add(1)
+		go l.work(b, &wg, channels[i], i)
+	}
+	l.start = time.Now()
+	l.scan(b, channels)
+	for _, ch := range channels {
+		ch.close()
+	}
+	wg.Wait()
+	took := time.Since(l.start)
+	if err := l.writeResults(fileName, took); err != nil {
+		t.Fatalf("cannot write results: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("cannot unmarshal results: %v", err)
+	}
+	for _, key := range []string{"schema_version", "db_name", "metrics", "rows", "wall_seconds", "metric_rate", "row_rate",
+		"batch_size", "workers", "interrupted", "time_limited", "aborted", "failed_batches", "decode_errors", "per_worker", "flags"} {
+		if _, ok := doc[key]; !ok {
+			t.Errorf("missing key %s", key)
+		}
+	}
+
+	var r loadResults
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatalf("cannot unmarshal results: %v", err)
+	}
+	if r.SchemaVersion != resultsSchemaVersion {
+		t.Errorf("incorrect schema version: got %d want %d", r.SchemaVersion, resultsSchemaVersion)
+	}
+	if r.DBName != "benchmark" || r.BatchSize != 10 || r.Workers != 2 {
+		t.Errorf("incorrect settings: got %s, batch size %d, %d workers", r.DBName, r.BatchSize, r.Workers)
+	}
+	if r.Metrics != l.metricCnt || r.Rows != l.rowCnt || r.Rows != 100 || r.Metrics != 200 {
+		t.Errorf("incorrect counts: got %d metrics and %d rows want %d and %d", r.Metrics, r.Rows, l.metricCnt, l.rowCnt)
+	}
+	if r.ItemsRead != 100 {
+		t.Errorf("incorrect items read: got %d want %d", r.ItemsRead, 100)
+	}
+	if r.WallSeconds != took.Seconds() || r.RowRate != float64(r.Rows)/r.WallSeconds || r.MetricRate != float64(r.Metrics)/r.WallSeconds {
+		t.Errorf("incorrect rates: got %f rows/sec and %f metrics/sec in %fsec", r.RowRate, r.MetricRate, r.WallSeconds)
+	}
+	if r.Interrupted || r.TimeLimited || r.Aborted || r.DryRun {
+		t.Errorf("incorrect status: got interrupted %v, time limited %v, aborted %v, dry run %v", r.Interrupted, r.TimeLimited, r.Aborted, r.DryRun)
+	}
+	if r.FailedBatches != 2 || r.DecodeErrors != 0 {
+		t.Errorf("incorrect errors: got %d failed batches and %d decode errors", r.FailedBatches, r.DecodeErrors)
+	}
+	if len(r.PerWorker) != 2 {
+		t.Fatalf("incorrect number of workers: got %d want %d", len(r.PerWorker), 2)
+	}
+	var metrics, rows, batches uint64
+	for i, w := range r.PerWorker {
+		if w.Worker != i {
+			t.Errorf("incorrect worker: got %d want %d", w.Worker, i)
+		}
+		if w.InsertSeconds > 0 && w.RowRate != float64(w.Rows)/w.InsertSeconds {
+			t.Errorf("worker %d: incorrect row rate: got %f want %f", i, w.RowRate, float64(w.Rows)/w.InsertSeconds)
+		}
+		metrics, rows, batches = metrics+w.Metrics, rows+w.Rows, batches+w.Batches
+	}
+	if metrics != r.Metrics || rows != r.Rows || batches != 10 {
+		t.Errorf("incorrect worker totals: got %d metrics, %d rows and %d batches", metrics, rows, batches)
+	}
+}
+
+func TestFlagValues(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("host", "localhost", "")
+	fs.String("password", "", "")
+	fs.String("dbpass", "", "")
+	fs.Uint("workers", 1, "")
+	if err := fs.Parse([]string{"-password=secret", "-workers=4"}); err != nil {
+		t.Fatal(err)
+	}
+	got := flagValues(fs)
+	want := map[string]string{"host": "localhost", "password": redactedFlag, "dbpass": "", "workers": "4"}
+	if len(got) != len(want) {
+		t.Errorf("incorrect flags: got %v want %v", got, want)
+	}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("incorrect value of %s: got %q want %q", name, got[name], value)
+		}
+	}
+}
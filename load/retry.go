@@ -0,0 +1,87 @@
+package load
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// RetryableError is the error of a batch that failed to be loaded and may not
+// fail again, e.g., as the database was busy, returned by a
+// ProcessorWithError for the batch to be loaded again, up to -batch-retries
+// times. The batch is loaded again as a whole: none of it must have been
+// loaded, and it must be left as it was given to the processor.
+type RetryableError struct {
+	Err error
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+// BatchSerializer is a Batch that writes its points back in the format of the
+// input, for the batches that fail to be loaded for good to be written to
+// -failed-batch-file and loaded later
+type BatchSerializer interface {
+	Batch
+	// Serialize writes the points of the batch to w as they are in the input
+	Serialize(w io.Writer) error
+}
+
+// retryBatch queues q, of a batch that failed to be loaded with err, to be
+// loaded again on c once backed off, if err is a RetryableError and the batch
+// was retried fewer than -batch-retries times, returning whether it is. The
+// batch is acknowledged to the scanner only once loaded or failed for good,
+// for it to be counted as outstanding once.
+func (l *BenchmarkRunner) retryBatch(c *duplexChannel, q queuedBatch, err error, workerNum int) bool {
+	if _, ok := err.(*RetryableError); !ok || q.retries >= l.batchRetries {
+		return false
+	}
+	q.retries++
+	delay := retryBackoff(l.batchRetryBackoff, q.retries)
+	atomic.AddUint64(&l.retriedBatches, 1)
+	printFn("worker %d: retrying a batch in %v (%d of %d): %v\n", workerNum, delay, q.retries, l.batchRetries, err)
+	// The worker goes on with the batches queued meanwhile
+	go func() {
+		time.Sleep(delay)
+		q.queued = time.Now()
+		c.sendQueued(q)
+	}()
+	return true
+}
+
+// retryBackoff returns the time to wait for before retry number retry, from
+// 1, of a batch: backoff doubled on each retry
+func retryBackoff(backoff time.Duration, retry int) time.Duration {
+	if retry > 20 {
+		retry = 20
+	}
+	return backoff << uint(retry-1)
+}
+
+// spillBatch writes b, of a batch that failed to be loaded for good, to
+// -failed-batch-file, if set
+func (l *BenchmarkRunner) spillBatch(b Batch) {
+	if l.failedBatchOut == nil {
+		return
+	}
+	l.spillMutex.Lock()
+	err := b.(BatchSerializer).Serialize(l.failedBatchOut)
+	l.spillMutex.Unlock()
+	if err != nil {
+		fatal("cannot write -failed-batch-file %s: %v", l.failedBatchFile, err)
+		return
+	}
+	atomic.AddUint64(&l.spilledBatches, 1)
+}
+
+// retrySummary prints how many times batches were retried, and how many were
+// written to -failed-batch-file, if any
+func (l *BenchmarkRunner) retrySummary() {
+	if retried := atomic.LoadUint64(&l.retriedBatches); retried > 0 {
+		printFn("retried batches %d times, up to -batch-retries %d times each\n", retried, l.batchRetries)
+	}
+	if spilled := atomic.LoadUint64(&l.spilledBatches); spilled > 0 {
+		printFn("wrote the %d batches that failed to load to -failed-batch-file %s\n", spilled, l.failedBatchFile)
+	}
+}
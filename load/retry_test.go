@@ -0,0 +1,200 @@
+package load
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testSerialBatch is a batch of points of a byte, written back a line each
+type testSerialBatch struct {
+	points []byte
+}
+
+func (b *testSerialBatch) Len() int        { return len(b.points) }
+func (b *testSerialBatch) Append(p *Point) { b.points = append(b.points, p.Data.(byte)) }
+func (b *testSerialBatch) Serialize(w io.Writer) error {
+	for _, p := range b.points {
+		if _, err := fmt.Fprintf(w, "%d\n", p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type testSerialFactory struct{}
+
+func (f *testSerialFactory) New() Batch { return &testSerialBatch{} }
+
+// testRetryProcessor fails to load the batch starting with failing the first
+// failures times it is given, with a RetryableError if retryable
+type testRetryProcessor struct {
+	testProcessor
+	failing   byte
+	retryable bool
+	mu        *sync.Mutex
+	failures  *int
+}
+
+func (p *testRetryProcessor) ProcessBatchWithError(b Batch, doLoad bool) (metricCount, rowCount uint64, err error) {
+	points := b.(*testSerialBatch).points
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if points[0] == p.failing && *p.failures > 0 {
+		*p.failures--
+		err = fmt.Errorf("batch of %d failed", p.failing)
+		if p.retryable {
+			return 0, 0, &RetryableError{Err: err}
+		}
+		return 0, 0, err
+	}
+	return uint64(len(points)), uint64(len(points)), nil
+}
+
+type testRetryBenchmark struct {
+	testSlowBenchmark
+	retryable bool
+	mu        sync.Mutex
+	failures  int
+}
+
+func (b *testRetryBenchmark) GetBatchFactory() BatchFactory { return &testSerialFactory{} }
+func (b *testRetryBenchmark) GetDBCreator() DBCreator       { return &testCreator{} }
+func (b *testRetryBenchmark) GetProcessor() Processor {
+	return &testRetryProcessor{failing: 20, retryable: b.retryable, mu: &b.mu, failures: &b.failures}
+}
+
+func TestBatchRetries(t *testing.T) {
+	data := make([]byte, 50)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	fileName := writeTempInput(t, data)
+	defer os.Remove(fileName)
+	dir, err := ioutil.TempDir("", "tsbs-retry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The third batch, of the points from 20, fails twice
+	cases := []struct {
+		desc        string
+		retries     int
+		retryable   bool
+		wantRows    uint64
+		wantRetried uint64
+		wantFailed  uint64
+		wantSpilled string
+	}{
+		{
+			desc:        "retried until loaded",
+			retries:     3,
+			retryable:   true,
+			wantRows:    50,
+			wantRetried: 2,
+		},
+		{
+			desc:        "retried as many times as it fails",
+			retries:     2,
+			retryable:   true,
+			wantRows:    50,
+			wantRetried: 2,
+		},
+		{
+			desc:        "failed after its retries",
+			retries:     1,
+			retryable:   true,
+			wantRows:    40,
+			wantRetried: 1,
+			wantFailed:  1,
+			wantSpilled: "20\n21\n22\n23\n24\n25\n26\n27\n28\n29\n",
+		},
+		{
+			desc:        "not retryable",
+			retries:     3,
+			wantRows:    40,
+			wantFailed:  1,
+			wantSpilled: "20\n21\n22\n23\n24\n25\n26\n27\n28\n29\n",
+		},
+		{
+			desc:        "no retries",
+			retryable:   true,
+			wantRows:    40,
+			wantFailed:  1,
+			wantSpilled: "20\n21\n22\n23\n24\n25\n26\n27\n28\n29\n",
+		},
+	}
+	for i, c := range cases {
+		failedBatchFile := fmt.Sprintf("%s/failed-%d", dir, i)
+		l := &BenchmarkRunner{
+			dbName:            "benchmark",
+			batchSize:         10,
+			workers:           2,
+			doLoad:            true,
+			onDecodeError:     decodeErrorAbort,
+			batchRetries:      c.retries,
+			batchRetryBackoff: time.Millisecond,
+			failedBatchFile:   failedBatchFile,
+			fileName:          fileName,
+		}
+		b := &testRetryBenchmark{retryable: c.retryable, failures: 2}
+		l.RunBenchmark(b, SingleQueue)
+
+		if l.rowCnt != c.wantRows {
+			t.Errorf("%s: incorrect rows loaded: got %d want %d", c.desc, l.rowCnt, c.wantRows)
+		}
+		if l.retriedBatches != c.wantRetried {
+			t.Errorf("%s: incorrect retries: got %d want %d", c.desc, l.retriedBatches, c.wantRetried)
+		}
+		failed := uint64(0)
+		for _, cnt := range l.errCnts {
+			failed += cnt
+		}
+		if failed != c.wantFailed || l.spilledBatches != c.wantFailed {
+			t.Errorf("%s: incorrect failed batches: got %d, %d written want %d", c.desc, failed, l.spilledBatches, c.wantFailed)
+		}
+		spilled, err := ioutil.ReadFile(failedBatchFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(spilled) != c.wantSpilled {
+			t.Errorf("%s: incorrect -failed-batch-file: got %q want %q", c.desc, spilled, c.wantSpilled)
+		}
+	}
+}
+
+func TestFailedBatchFileNotSupported(t *testing.T) {
+	oldFatal := fatal
+	defer func() { fatal = oldFatal }()
+	var fatalMessages []string
+	fatal = func(format string, args ...interface{}) {
+		fatalMessages = append(fatalMessages, fmt.Sprintf(format, args...))
+	}
+	l := &BenchmarkRunner{
+		batchSize:       10,
+		workers:         1,
+		onDecodeError:   decodeErrorAbort,
+		failedBatchFile: "failed",
+	}
+	l.RunBenchmark(&testSlowBenchmark{}, SingleQueue)
+	want := "-failed-batch-file is not supported by this loader"
+	if len(fatalMessages) != 1 || fatalMessages[0] != want {
+		t.Errorf("incorrect fatal messages: got %v want %s", fatalMessages, want)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	var got []string
+	for retry := 1; retry <= 4; retry++ {
+		got = append(got, retryBackoff(100*time.Millisecond, retry).String())
+	}
+	if want := "100ms 200ms 400ms 800ms"; strings.Join(got, " ") != want {
+		t.Errorf("incorrect backoffs: got %v want %s", got, want)
+	}
+}
@@ -2,17 +2,18 @@ package load
 
 import (
 	"bufio"
-	"reflect"
+	"sync/atomic"
+	"time"
 )
 
 // ackAndMaybeSend adjust the unsent batches count
 // and sends one batch (if any available) to the worker via ch.
 // Returns the updated state of unsent
-func ackAndMaybeSend(ch *duplexChannel, count *int, unsent []Batch) []Batch {
+func ackAndMaybeSend(ch *duplexChannel, count *int, unsent []queuedBatch) []queuedBatch {
 	*count--
 	// If there are still batches waiting, send the next
 	if len(unsent) > 0 {
-		ch.sendToWorker(unsent[0])
+		ch.sendQueued(unsent[0])
 		if len(unsent) > 1 {
 			return unsent[1:]
 		}
@@ -23,19 +24,48 @@ func ackAndMaybeSend(ch *duplexChannel, count *int, unsent []Batch) []Batch {
 
 // sendOrQueueBatch attempts to send a Batch of data on a duplexChannel.
 // If it would block or there is other work to be sent first, the Batch is stored on a queue.
-// The count of outstanding work is adjusted upwards
-func sendOrQueueBatch(ch *duplexChannel, count *int, batch Batch, unsent []Batch) []Batch {
+// The count of outstanding work is adjusted upwards. The Batch is sent with the
+// time it was queued at, for the time it waits for a worker to be measured.
+func sendOrQueueBatch(ch *duplexChannel, count *int, batch Batch, unsent []queuedBatch) []queuedBatch {
 	// In case there are no outstanding batches yet and there are empty positions in toWorker queue
 	// we can send/put batch into toWorker queue
 	*count++
+	q := queuedBatch{Batch: batch, queued: time.Now()}
 	if len(unsent) == 0 && len(ch.toWorker) < cap(ch.toWorker) {
-		ch.sendToWorker(batch)
+		ch.sendQueued(q)
 	} else {
-		return append(unsent, batch)
+		return append(unsent, q)
 	}
 	return unsent
 }
 
+// forwardAcks forwards the acknowledgements of the workers of channels onto a
+// single channel, as the indexes of their channels, for the scanner to receive
+// them from all the workers at once, until done is closed
+func forwardAcks(channels []*duplexChannel, done <-chan struct{}) <-chan int {
+	acks := make(chan int, len(channels))
+	for i, ch := range channels {
+		go func(i int, ch *duplexChannel) {
+			for {
+				select {
+				case _, ok := <-ch.toScanner:
+					if !ok {
+						return
+					}
+					select {
+					case acks <- i:
+					case <-done:
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		}(i, ch)
+	}
+	return acks
+}
+
 // Batch is an aggregate of points for a particular data system.
 // It needs to have a way to measure it's size to make sure
 // it does not get too large and it needs a way to append a point
@@ -44,12 +74,40 @@ type Batch interface {
 	Append(*Point)
 }
 
+// ByteLenner is a Batch that also measures the size of its points in bytes, for
+// it to be sent once it reaches -batch-size-bytes, if it does not reach
+// -batch-size first
+type ByteLenner interface {
+	Batch
+	// ByteLen returns the size of the points of the batch in bytes
+	ByteLen() int
+}
+
+// batchFull tells whether b has batchSize items, or at least batchBytes bytes
+// of them if batchBytes is not 0
+func batchFull(b Batch, batchSize uint, batchBytes uint64) bool {
+	if b.Len() >= int(batchSize) {
+		return true
+	}
+	if batchBytes > 0 {
+		return uint64(b.(ByteLenner).ByteLen()) >= batchBytes
+	}
+	return false
+}
+
 // Point acts as a 'holder' for the internal representation of a point in a given load client.
 // Instead of using interface{} as a return type, we get compile safety by using Point
 type Point struct {
 	Data interface{}
 }
 
+// Timestamped is the Data of a Point that has a time, e.g., that of its row,
+// for the input to be replayed at the pace of its times with -replay-speed
+type Timestamped interface {
+	// Timestamp returns the time of the point, the zero Time if it has none
+	Timestamp() time.Time
+}
+
 // NewPoint creates a Point with the provided data as the internal representation
 func NewPoint(data interface{}) *Point {
 	return &Point{Data: data}
@@ -71,6 +129,27 @@ func (i *ConstantIndexer) GetIndex(_ *Point) int {
 	return 0
 }
 
+// outstandingLimit limits the batches outstanding, sent to the workers or
+// queued to be and not acknowledged yet, to batches of them, or of bytes, as
+// measured by a ByteLenner, for the scanner not to read too far ahead of the
+// workers. If neither is set, the batches are limited to 3 times as many as
+// the channels hold.
+type outstandingLimit struct {
+	batches int
+	bytes   uint64
+}
+
+// scanPacing paces the dispatch of the points of the input at the times
+// replay gives them, unless nil, the scanner waiting for them until stop is
+// closed. Batches not full are sent once their first point is maxBatchAge
+// old, if not 0, for them to be bounded in time rather than in size when the
+// points are paced.
+type scanPacing struct {
+	replay      *replayClock
+	stop        <-chan struct{}
+	maxBatchAge time.Duration
+}
+
 // BatchFactory returns a new empty batch for storing points.
 type BatchFactory interface {
 	// New returns a new Batch to add Points to
@@ -83,11 +162,27 @@ type PointDecoder interface {
 	Decode(*bufio.Reader) *Point
 }
 
+// PointDecoderWithError is a PointDecoder that returns the errors of the data
+// it fails to decode rather than exits, for the loader to skip them or abort
+// as set by -on-decode-error.
+type PointDecoderWithError interface {
+	PointDecoder
+	// DecodeWithError creates a Point from a data stream, returning io.EOF at
+	// its end, or an error giving the line or offset of data it cannot
+	// decode, after which it decodes the data that follows
+	DecodeWithError(*bufio.Reader) (*Point, error)
+}
+
 // ScanWithIndexer reads data from the provided bufio.Reader br until a limit is reached (if -1, all items are read).
 // Data is decoded by PointDecoder decoder and then placed into appropriate batches, using the supplied PointIndexer,
 // which are then dispatched to workers (duplexChannel chosen by PointIndexer). Scan does flow control to make sure workers are not left idle for too long
 // and also that the scanning process  does not starve them of CPU.
-func scanWithIndexer(channels []*duplexChannel, batchSize uint, limit uint64, br *bufio.Reader, decoder PointDecoder, factory BatchFactory, indexer PointIndexer) uint64 {
+// Batches are also sent once they have batchBytes bytes of items, if not 0.
+// A QueueDepthIndexer is told the number of batches outstanding on each channel.
+// The scanner waits for the workers once outstanding batches are, and the
+// nanoseconds it is blocked waiting for them are added to blocked, if not nil.
+// The points are dispatched, and the batches sent by their age, as paced.
+func scanWithIndexer(channels []*duplexChannel, batchSize uint, batchBytes uint64, limit uint64, br *bufio.Reader, decoder PointDecoder, factory BatchFactory, indexer PointIndexer, outstanding outstandingLimit, pacing scanPacing, blocked *int64) uint64 {
 	var itemsRead uint64
 	numChannels := len(channels)
 
@@ -106,34 +201,170 @@ func scanWithIndexer(channels []*duplexChannel, batchSize uint, limit uint64, br
 	for i := range fillingBatches {
 		fillingBatches[i] = factory.New()
 	}
+	if _, ok := fillingBatches[0].(ByteLenner); batchBytes > 0 && !ok {
+		panic("--batch-size-bytes is not supported by the batches of this database")
+	} else if outstanding.bytes > 0 && !ok {
+		panic("--max-outstanding-bytes is not supported by the batches of this database")
+	}
 
 	// Batches that are ready to be set when space on a channel opens
-	unsentBatches := make([][]Batch, numChannels)
+	unsentBatches := make([][]queuedBatch, numChannels)
 	for i := range unsentBatches {
-		unsentBatches[i] = []Batch{}
+		unsentBatches[i] = []queuedBatch{}
 	}
 
-	// We use Select via reflection to either select an acknowledged channel so
-	// that we can potentially send another batch, or if none are ready to continue
-	// on scanning. However, when we reach a limit of outstanding (unsent) batches,
+	// The acknowledgements of all the channels arrive on acks, in the order the
+	// workers send them, for the scanner to either take one so that it can
+	// potentially send another batch, or if none is ready to continue on
+	// scanning. However, when we reach a limit of outstanding (unsent) batches,
 	// we also want to block until one worker is done, so as not to starve the workers.
-	// Using an array with Select via reflection gives us this flexibility (i.e.,
-	// we can either pass the whole array of cases, or the array less the last item).
-	cases := make([]reflect.SelectCase, numChannels+1)
-	for i, ch := range channels {
-		cases[i] = reflect.SelectCase{
-			Dir:  reflect.SelectRecv,
-			Chan: reflect.ValueOf(ch.toScanner),
-		}
-	}
-	cases[numChannels] = reflect.SelectCase{
-		Dir: reflect.SelectDefault,
-	}
+	done := make(chan struct{})
+	defer close(done)
+	acks := forwardAcks(channels, done)
 
 	// Keep track of how many batches are outstanding (ocnt),
 	// so we don't go over a limit (olimit), in order to slow down the scanner so it doesn't starve the workers
 	ocnt := 0
-	olimit := numChannels * cap(channels[0].toWorker) * 3
+	olimit := outstanding.batches
+	if olimit == 0 && outstanding.bytes == 0 {
+		olimit = numChannels * cap(channels[0].toWorker) * 3
+	}
+
+	// The batches outstanding on each channel, for an indexer choosing the
+	// channels by them, and the bytes of those of each channel in the order
+	// they were sent, an acknowledgement being taken for the first of them,
+	// if limited. obytes is the sum of them.
+	depths := make([]int, numChannels)
+	depthIndexer, _ := indexer.(QueueDepthIndexer)
+	batchBytesSent := make([][]uint64, numChannels)
+	obytes := uint64(0)
+	sent := func(idx int, b Batch) {
+		depths[idx]++
+		if depthIndexer != nil {
+			depthIndexer.SetQueueDepth(idx, depths[idx])
+		}
+		if outstanding.bytes > 0 {
+			n := uint64(b.(ByteLenner).ByteLen())
+			batchBytesSent[idx] = append(batchBytesSent[idx], n)
+			obytes += n
+		}
+	}
+	acked := func(idx int) {
+		depths[idx]--
+		if depthIndexer != nil {
+			depthIndexer.SetQueueDepth(idx, depths[idx])
+		}
+		if len(batchBytesSent[idx]) > 0 {
+			obytes -= batchBytesSent[idx][0]
+			batchBytesSent[idx] = batchBytesSent[idx][1:]
+		}
+	}
+	full := func() bool {
+		if olimit > 0 && ocnt >= olimit {
+			return true
+		}
+		return outstanding.bytes > 0 && ocnt > 0 && obytes >= outstanding.bytes
+	}
+	dispatch := func(idx int) {
+		sent(idx, fillingBatches[idx])
+		unsentBatches[idx] = sendOrQueueBatch(channels[idx], &ocnt, fillingBatches[idx], unsentBatches[idx])
+		fillingBatches[idx] = factory.New()
+	}
+
+	// The time the first point of each filling batch was appended at, for
+	// the batches to be sent once maxBatchAge old
+	firstAppended := make([]time.Time, numChannels)
+	sendAged := func() {
+		if pacing.maxBatchAge <= 0 {
+			return
+		}
+		now := time.Now()
+		for idx, b := range fillingBatches {
+			if b.Len() > 0 && now.Sub(firstAppended[idx]) >= pacing.maxBatchAge {
+				dispatch(idx)
+			}
+		}
+	}
+	// nextAged returns the time the oldest filling batch is to be sent at,
+	// false if none is
+	nextAged := func() (time.Time, bool) {
+		var next time.Time
+		if pacing.maxBatchAge <= 0 {
+			return next, false
+		}
+		for idx, b := range fillingBatches {
+			if b.Len() > 0 && (next.IsZero() || firstAppended[idx].Before(next)) {
+				next = firstAppended[idx]
+			}
+		}
+		if next.IsZero() {
+			return next, false
+		}
+		return next.Add(pacing.maxBatchAge), true
+	}
+	// wait waits until until, unless zero, or for an item on items, unless
+	// nil, taking the acknowledgements of the workers meanwhile. It returns
+	// the item if one came, and whether stop was closed.
+	wait := func(until time.Time, items <-chan *Point, stop <-chan struct{}) (item *Point, got, stopped bool) {
+		var expired <-chan time.Time
+		if !until.IsZero() {
+			timer := time.NewTimer(time.Until(until))
+			defer timer.Stop()
+			expired = timer.C
+		}
+		for {
+			select {
+			case <-expired:
+				return nil, false, false
+			case item := <-items:
+				return item, true, false
+			case chosen := <-acks:
+				unsentBatches[chosen] = ackAndMaybeSend(channels[chosen], &ocnt, unsentBatches[chosen])
+				acked(chosen)
+			case <-stop:
+				return nil, false, true
+			}
+		}
+	}
+	// waitUntil waits until due, sending the batches that age meanwhile,
+	// unless stopped
+	waitUntil := func(due time.Time) {
+		for time.Now().Before(due) {
+			until := due
+			if aged, ok := nextAged(); ok && aged.Before(until) {
+				until = aged
+			}
+			if _, _, stopped := wait(until, nil, pacing.stop); stopped {
+				return
+			}
+			sendAged()
+		}
+	}
+
+	// With maxBatchAge, the items are decoded by a goroutine, one at a time
+	// as asked for, for the batches to be sent once aged while the decoder
+	// waits for the input, e.g., a slow pipe
+	decode := func() *Point { return decoder.Decode(br) }
+	if pacing.maxBatchAge > 0 {
+		requests := make(chan struct{})
+		defer close(requests)
+		items := make(chan *Point, 1)
+		go func() {
+			for range requests {
+				items <- decoder.Decode(br)
+			}
+		}()
+		decode = func() *Point {
+			requests <- struct{}{}
+			for {
+				until, _ := nextAged()
+				if item, got, _ := wait(until, items, nil); got {
+					return item
+				}
+				sendAged()
+			}
+		}
+	}
 	for {
 
 		// Check whether incoming items limit reached.
@@ -142,20 +373,26 @@ func scanWithIndexer(channels []*duplexChannel, batchSize uint, limit uint64, br
 			break
 		}
 
-		caseLimit := len(cases)
-		if ocnt >= olimit {
-			// We have too many outstanding batches, wait until one finishes (i.e. no default)
-			caseLimit--
-		}
-
-		// Only receive an 'ok' when it's from a channel, default does not return 'ok'
-		chosen, _, ok := reflect.Select(cases[:caseLimit])
-		if ok {
+		if full() {
+			// We have too many outstanding batches, wait until one finishes
+			start := time.Now()
+			chosen := <-acks
+			if blocked != nil {
+				atomic.AddInt64(blocked, int64(time.Since(start)))
+			}
 			unsentBatches[chosen] = ackAndMaybeSend(channels[chosen], &ocnt, unsentBatches[chosen])
+			acked(chosen)
+		} else {
+			select {
+			case chosen := <-acks:
+				unsentBatches[chosen] = ackAndMaybeSend(channels[chosen], &ocnt, unsentBatches[chosen])
+				acked(chosen)
+			default:
+			}
 		}
 
 		// Prepare new batch - decode new item and append it to batch
-		item := decoder.Decode(br)
+		item := decode()
 		if item == nil {
 			// Nothing to scan any more - input is empty or failed
 			// Time to exit
@@ -163,16 +400,26 @@ func scanWithIndexer(channels []*duplexChannel, batchSize uint, limit uint64, br
 		}
 		itemsRead++
 
+		// Dispatch the item once due, sending the batches aged meanwhile
+		if pacing.replay != nil {
+			if due := pacing.replay.due(item); !due.IsZero() {
+				waitUntil(due)
+			}
+		}
+		sendAged()
+
 		// Append new item to batch
 		idx := indexer.GetIndex(item)
 		fillingBatches[idx].Append(item)
+		if pacing.maxBatchAge > 0 && fillingBatches[idx].Len() == 1 {
+			firstAppended[idx] = time.Now()
+		}
 
-		if fillingBatches[idx].Len() >= int(batchSize) {
-			// Batch is full (contains at least batchSize items) - ready to be sent to worker,
+		if batchFull(fillingBatches[idx], batchSize, batchBytes) {
+			// Batch is full (contains at least batchSize items or batchBytes bytes) - ready to be sent to worker,
 			// or moved to outstanding, in case no workers available atm.
-			unsentBatches[idx] = sendOrQueueBatch(channels[idx], &ocnt, fillingBatches[idx], unsentBatches[idx])
-			// Place new empty batch
-			fillingBatches[idx] = factory.New()
+			// A new empty batch takes its place.
+			dispatch(idx)
 		}
 	}
 
@@ -187,17 +434,10 @@ func scanWithIndexer(channels []*duplexChannel, batchSize uint, limit uint64, br
 
 	// Wait until all the outstanding batches get acknowledged,
 	// so we don't prematurely close the acknowledge channels
-	for {
-		if ocnt == 0 {
-			// No outstanding batches any more
-			break
-		}
-
+	for ocnt > 0 {
 		// Try to send batches to workers
-		chosen, _, ok := reflect.Select(cases[:len(cases)-1])
-		if ok {
-			unsentBatches[chosen] = ackAndMaybeSend(channels[chosen], &ocnt, unsentBatches[chosen])
-		}
+		chosen := <-acks
+		unsentBatches[chosen] = ackAndMaybeSend(channels[chosen], &ocnt, unsentBatches[chosen])
 	}
 
 	return itemsRead
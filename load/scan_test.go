@@ -3,8 +3,12 @@ package load
 import (
 	"bufio"
 	"bytes"
+	"fmt"
 	"io"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 )
 
 type testBatch struct {
@@ -22,7 +26,7 @@ func (b *testBatch) Append(p *Point) {
 func TestAckAndMaybeSend(t *testing.T) {
 	cases := []struct {
 		desc         string
-		unsent       []Batch
+		unsent       []queuedBatch
 		count        int
 		afterCount   int
 		afterLen     int
@@ -37,21 +41,21 @@ func TestAckAndMaybeSend(t *testing.T) {
 		},
 		{
 			desc:       "unsent has 0 elements",
-			unsent:     []Batch{},
+			unsent:     []queuedBatch{},
 			count:      0,
 			afterCount: -1,
 			afterLen:   0,
 		},
 		{
 			desc:       "unsent has 1 element",
-			unsent:     []Batch{&testBatch{1, 1}},
+			unsent:     []queuedBatch{{Batch: &testBatch{1, 1}}},
 			count:      1,
 			afterCount: 0,
 			afterLen:   0,
 		},
 		{
 			desc:         "unsent has 2 elements",
-			unsent:       []Batch{&testBatch{1, 1}, &testBatch{2, 1}},
+			unsent:       []queuedBatch{{Batch: &testBatch{1, 1}}, {Batch: &testBatch{2, 1}}},
 			count:        2,
 			afterCount:   1,
 			afterLen:     1,
@@ -68,7 +72,7 @@ func TestAckAndMaybeSend(t *testing.T) {
 			t.Errorf("%s: len incorrect: want %d got %d", c.desc, c.afterLen, len(c.unsent))
 		}
 		if len(c.unsent) > 0 {
-			if got := c.unsent[0].(*testBatch); c.afterFirstID != got.id {
+			if got := c.unsent[0].Batch.(*testBatch); c.afterFirstID != got.id {
 				t.Errorf("%s: first element incorrect: want %d got %d", c.desc, c.afterFirstID, got.id)
 			}
 		}
@@ -78,7 +82,7 @@ func TestAckAndMaybeSend(t *testing.T) {
 func TestSendOrQueueBatch(t *testing.T) {
 	cases := []struct {
 		desc           string
-		unsent         []Batch
+		unsent         []queuedBatch
 		toSend         []Batch
 		queueSize      int
 		count          int
@@ -88,7 +92,7 @@ func TestSendOrQueueBatch(t *testing.T) {
 	}{
 		{
 			desc:           "unsent is empty, queue does not fill up",
-			unsent:         []Batch{},
+			unsent:         []queuedBatch{},
 			toSend:         []Batch{&testBatch{1, 1}},
 			queueSize:      1,
 			count:          0,
@@ -98,7 +102,7 @@ func TestSendOrQueueBatch(t *testing.T) {
 		},
 		{
 			desc:           "unsent is empty, queue fills up",
-			unsent:         []Batch{},
+			unsent:         []queuedBatch{},
 			toSend:         []Batch{&testBatch{1, 1}, &testBatch{2, 1}},
 			queueSize:      1,
 			count:          0,
@@ -108,7 +112,7 @@ func TestSendOrQueueBatch(t *testing.T) {
 		},
 		{
 			desc:           "unsent is non-empty, queue fills up",
-			unsent:         []Batch{&testBatch{1, 1}},
+			unsent:         []queuedBatch{{Batch: &testBatch{1, 1}}},
 			toSend:         []Batch{&testBatch{2, 1}, &testBatch{3, 1}},
 			queueSize:      2,
 			count:          1,
@@ -164,18 +168,21 @@ type testDecoder struct {
 }
 
 func (d *testDecoder) Decode(br *bufio.Reader) *Point {
-	ret := &Point{}
+	ret, err := d.DecodeWithError(br)
+	if err != nil && err != io.EOF {
+		panic(err)
+	}
+	return ret
+}
+
+func (d *testDecoder) DecodeWithError(br *bufio.Reader) (*Point, error) {
 	b, err := br.ReadByte()
 	if err != nil {
-		if err == io.EOF {
-			return nil
-		}
-		panic(err)
+		return nil, err
 	}
-	ret.Data = b
 	d.called++
 
-	return ret
+	return &Point{Data: b}, nil
 }
 
 type testFactory struct{}
@@ -253,13 +260,356 @@ func TestScanWithIndexer(t *testing.T) {
 						t.Errorf("%s: did not panic when should", c.desc)
 					}
 				}()
-				scanWithIndexer(channels, c.batchSize, c.limit, br, decoder, &testFactory{}, indexer)
+				scanWithIndexer(channels, c.batchSize, 0, c.limit, br, decoder, &testFactory{}, indexer, outstandingLimit{}, scanPacing{}, nil)
 			}()
 			continue
 		} else {
 			go _boringWorker(channels[0])
-			read := scanWithIndexer(channels, c.batchSize, c.limit, br, decoder, &testFactory{}, indexer)
+			read := scanWithIndexer(channels, c.batchSize, 0, c.limit, br, decoder, &testFactory{}, indexer, outstandingLimit{}, scanPacing{}, nil)
 			_checkScan(t, c.desc, decoder.called, read, c.wantCalls)
 		}
 	}
 }
+
+// testByteBatch is a batch of points whose sizes in bytes are their data
+type testByteBatch struct {
+	testBatch
+	bytes int
+}
+
+func (b *testByteBatch) Append(p *Point) {
+	b.testBatch.Append(p)
+	b.bytes += int(p.Data.(byte))
+}
+
+func (b *testByteBatch) ByteLen() int { return b.bytes }
+
+type testByteFactory struct{}
+
+func (f *testByteFactory) New() Batch {
+	return &testByteBatch{}
+}
+
+func TestScanWithIndexerBatchBytes(t *testing.T) {
+	// Points of 10 bytes, but for one of 50
+	data := []byte{10, 10, 10, 10, 10, 10, 10, 50, 10, 10}
+
+	cases := []struct {
+		desc       string
+		batchSize  uint
+		batchBytes uint64
+		want       []int
+	}{
+		{
+			desc:      "no byte limit",
+			batchSize: 4,
+			want:      []int{4, 4, 2},
+		},
+		{
+			desc:       "byte limit before size",
+			batchSize:  4,
+			batchBytes: 25,
+			want:       []int{3, 3, 2, 2},
+		},
+		{
+			desc:       "size before byte limit",
+			batchSize:  2,
+			batchBytes: 100,
+			want:       []int{2, 2, 2, 2, 2},
+		},
+		{
+			desc:       "point over byte limit",
+			batchSize:  10,
+			batchBytes: 40,
+			want:       []int{4, 4, 2},
+		},
+	}
+	for _, c := range cases {
+		br := bufio.NewReader(bytes.NewReader(data))
+		channels := []*duplexChannel{newDuplexChannel(1)}
+		var got []int
+		done := make(chan struct{})
+		go func() {
+			for b := range channels[0].toWorker {
+				got = append(got, b.Len())
+				channels[0].sendToScanner()
+			}
+			close(done)
+		}()
+		read := scanWithIndexer(channels, c.batchSize, c.batchBytes, 0, br, &testDecoder{}, &testByteFactory{}, &ConstantIndexer{}, outstandingLimit{}, scanPacing{}, nil)
+		channels[0].close()
+		<-done
+		if read != uint64(len(data)) {
+			t.Errorf("%s: read incorrect: got %d want %d", c.desc, read, len(data))
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: incorrect batch sizes: got %v want %v", c.desc, got, c.want)
+		}
+	}
+
+	// Batches that do not measure their bytes cannot be limited by them
+	defer func() {
+		if re := recover(); re == nil {
+			t.Errorf("did not panic when batches do not measure their bytes")
+		}
+	}()
+	channels := []*duplexChannel{newDuplexChannel(1)}
+	scanWithIndexer(channels, 1, 10, 0, bufio.NewReader(bytes.NewReader(data)), &testDecoder{}, &testFactory{}, &ConstantIndexer{}, outstandingLimit{}, scanPacing{}, nil)
+}
+
+// testDelayedDecoder decodes points of a byte, each after the delay at its
+// index, if any
+type testDelayedDecoder struct {
+	testDecoder
+	delays []time.Duration
+}
+
+func (d *testDelayedDecoder) Decode(br *bufio.Reader) *Point {
+	if i := int(d.called); i < len(d.delays) {
+		time.Sleep(d.delays[i])
+	}
+	return d.testDecoder.Decode(br)
+}
+
+func TestScanWithIndexerMaxBatchAge(t *testing.T) {
+	repeat := func(d time.Duration, n int) []time.Duration {
+		delays := make([]time.Duration, n)
+		for i := range delays {
+			delays[i] = d
+		}
+		return delays
+	}
+	cases := []struct {
+		desc        string
+		delays      []time.Duration
+		maxBatchAge time.Duration
+		// Batches of at most maxLen points, at least minBatches of them,
+		// the first arriving before firstBefore
+		maxLen      int
+		minBatches  int
+		firstBefore time.Duration
+	}{
+		{
+			desc:        "slow decoder",
+			delays:      repeat(20*time.Millisecond, 20),
+			maxBatchAge: 50 * time.Millisecond,
+			maxLen:      3,
+			minBatches:  6,
+			firstBefore: 150 * time.Millisecond,
+		},
+		{
+			desc:        "decoder blocked",
+			delays:      []time.Duration{0, 0, 400 * time.Millisecond, 0},
+			maxBatchAge: 50 * time.Millisecond,
+			maxLen:      2,
+			minBatches:  2,
+			firstBefore: 300 * time.Millisecond,
+		},
+		{
+			desc:        "no max age",
+			delays:      repeat(5*time.Millisecond, 10),
+			maxLen:      10,
+			minBatches:  1,
+			firstBefore: time.Second,
+		},
+	}
+	for _, c := range cases {
+		br := bufio.NewReader(bytes.NewReader(make([]byte, len(c.delays))))
+		channels := []*duplexChannel{newDuplexChannel(1)}
+		var lens []int
+		var arrived []time.Duration
+		done := make(chan struct{})
+		start := time.Now()
+		go func() {
+			for b := range channels[0].toWorker {
+				lens = append(lens, b.Len())
+				arrived = append(arrived, time.Since(start))
+				channels[0].sendToScanner()
+			}
+			close(done)
+		}()
+		decoder := &testDelayedDecoder{delays: c.delays}
+		read := scanWithIndexer(channels, 100, 0, 0, br, decoder, &testFactory{}, &ConstantIndexer{}, outstandingLimit{}, scanPacing{maxBatchAge: c.maxBatchAge}, nil)
+		channels[0].close()
+		<-done
+
+		if read != uint64(len(c.delays)) {
+			t.Errorf("%s: read incorrect: got %d want %d", c.desc, read, len(c.delays))
+		}
+		total := 0
+		for _, n := range lens {
+			total += n
+			if n > c.maxLen {
+				t.Errorf("%s: batch too large: got %d points want %d at most, of batches %v", c.desc, n, c.maxLen, lens)
+			}
+		}
+		if total != len(c.delays) {
+			t.Errorf("%s: incorrect points sent: got %d want %d", c.desc, total, len(c.delays))
+		}
+		if len(lens) < c.minBatches {
+			t.Errorf("%s: too few batches: got %v want %d at least", c.desc, lens, c.minBatches)
+		}
+		if len(arrived) > 0 && arrived[0] >= c.firstBefore {
+			t.Errorf("%s: first batch late: arrived after %v want before %v", c.desc, arrived[0], c.firstBefore)
+		}
+	}
+}
+
+// testNotifyingDecoder decodes points of a byte, notifying decoded of each
+type testNotifyingDecoder struct {
+	testDecoder
+	decoded chan<- struct{}
+}
+
+func (d *testNotifyingDecoder) Decode(br *bufio.Reader) *Point {
+	p := d.testDecoder.Decode(br)
+	if p != nil {
+		d.decoded <- struct{}{}
+	}
+	return p
+}
+
+func TestScanWithIndexerOutstandingLimit(t *testing.T) {
+	// Batches of a point of 10 bytes
+	data := bytes.Repeat([]byte{10}, 10)
+	cases := []struct {
+		desc        string
+		outstanding outstandingLimit
+		// wantDecoded is the number of points decoded before the scanner
+		// waits for the worker
+		wantDecoded int
+	}{
+		{
+			desc:        "default",
+			wantDecoded: 3,
+		},
+		{
+			desc:        "batches",
+			outstanding: outstandingLimit{batches: 5},
+			wantDecoded: 5,
+		},
+		{
+			desc:        "bytes",
+			outstanding: outstandingLimit{bytes: 35},
+			wantDecoded: 4,
+		},
+		{
+			desc:        "batches before bytes",
+			outstanding: outstandingLimit{batches: 2, bytes: 100},
+			wantDecoded: 2,
+		},
+	}
+	for _, c := range cases {
+		channels := []*duplexChannel{newDuplexChannel(1)}
+		decoded := make(chan struct{}, len(data))
+		decoder := &testNotifyingDecoder{decoded: decoded}
+		read := make(chan uint64)
+		go func() {
+			br := bufio.NewReader(bytes.NewReader(data))
+			read <- scanWithIndexer(channels, 1, 0, 0, br, decoder, &testByteFactory{}, &ConstantIndexer{}, c.outstanding, scanPacing{}, nil)
+		}()
+		// waitDecoded checks that n points are decoded, and no more until
+		// the worker loads a batch
+		waitDecoded := func(n int) bool {
+			for i := 0; i < n; i++ {
+				select {
+				case <-decoded:
+				case <-time.After(time.Second):
+					t.Errorf("%s: scanner blocked after %d points want %d", c.desc, i, n)
+					return false
+				}
+			}
+			select {
+			case <-decoded:
+				t.Errorf("%s: scanner not blocked after %d points", c.desc, n)
+				return false
+			case <-time.After(50 * time.Millisecond):
+			}
+			return true
+		}
+		// The scanner resumes, for a point more, once a batch is loaded
+		if waitDecoded(c.wantDecoded) {
+			<-channels[0].toWorker
+			channels[0].sendToScanner()
+			waitDecoded(1)
+		}
+
+		go _boringWorker(channels[0])
+		if got := <-read; got != uint64(len(data)) {
+			t.Errorf("%s: incorrect items read: got %d want %d", c.desc, got, len(data))
+		}
+		channels[0].close()
+	}
+}
+
+// testModIndexer spreads the points of testDecoder over n channels by their data
+type testModIndexer struct {
+	n int
+}
+
+func (i *testModIndexer) GetIndex(p *Point) int {
+	return int(p.Data.(byte)) % i.n
+}
+
+func TestScanWithIndexerManyChannels(t *testing.T) {
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	// Many more batches than may be outstanding, for the scanner to wait for
+	// the workers
+	const numChannels = 8
+	channels := make([]*duplexChannel, numChannels)
+	points := make([]int, numChannels)
+	var wg sync.WaitGroup
+	for i := range channels {
+		channels[i] = newDuplexChannel(1)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for b := range channels[i].toWorker {
+				points[i] += b.Len()
+				channels[i].sendToScanner()
+			}
+		}(i)
+	}
+	br := bufio.NewReader(bytes.NewReader(data))
+	read := scanWithIndexer(channels, 3, 0, 0, br, &testDecoder{}, &testFactory{}, &testModIndexer{numChannels}, outstandingLimit{}, scanPacing{}, nil)
+	// Every batch was acknowledged, so the channels can be closed
+	for _, ch := range channels {
+		ch.close()
+	}
+	wg.Wait()
+	if read != uint64(len(data)) {
+		t.Errorf("incorrect items read: got %d want %d", read, len(data))
+	}
+	for i, n := range points {
+		if want := len(data) / numChannels; n != want {
+			t.Errorf("incorrect points of channel %d: got %d want %d", i, n, want)
+		}
+	}
+}
+
+func BenchmarkScanManyWorkers(b *testing.B) {
+	data := make([]byte, 1<<16)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	for _, numChannels := range []int{1, 8, 32, 48} {
+		b.Run(fmt.Sprintf("%d channels", numChannels), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for n := 0; n < b.N; n++ {
+				channels := make([]*duplexChannel, numChannels)
+				for i := range channels {
+					channels[i] = newDuplexChannel(1)
+					go _boringWorker(channels[i])
+				}
+				br := bufio.NewReader(bytes.NewReader(data))
+				scanWithIndexer(channels, 10, 0, 0, br, &testDecoder{}, &testFactory{}, &testModIndexer{numChannels}, outstandingLimit{}, scanPacing{}, nil)
+				for _, ch := range channels {
+					ch.close()
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package load
+
+import (
+	"bufio"
+	"io"
+	"sync"
+)
+
+// streamChunkSize is the number of points a stream of the input decodes
+// before handing them over to the scanner at once, for the streams not to
+// contend on each point
+const streamChunkSize = 256
+
+// decodedPoint is a point decoded from a stream of the input, or the error
+// decoding it
+type decodedPoint struct {
+	p   *Point
+	err error
+}
+
+// streamsDecoder decodes the points of several streams of the input, each
+// with a PointDecoder of its own in a goroutine of its own, the points of the
+// streams being merged in chunks in the order they are decoded in, for them to
+// be batched by one scanner as those of a single input are
+type streamsDecoder struct {
+	chunks    chan []decodedPoint
+	chunk     []decodedPoint
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newStreamsDecoder starts decoding the streams of readers, each with the
+// PointDecoder of decoders at the same index, returning the PointDecoder of
+// the points of all of them, a PointDecoderWithError if all the decoders are,
+// and the streamsDecoder to close once no more points are decoded
+func newStreamsDecoder(decoders []PointDecoder, readers []*bufio.Reader) (PointDecoder, *streamsDecoder) {
+	d := &streamsDecoder{chunks: make(chan []decodedPoint, len(decoders)), done: make(chan struct{})}
+	withError := true
+	for _, decoder := range decoders {
+		if _, ok := decoder.(PointDecoderWithError); !ok {
+			withError = false
+		}
+	}
+	var wg sync.WaitGroup
+	for i := range decoders {
+		wg.Add(1)
+		go func(decoder PointDecoder, br *bufio.Reader) {
+			defer wg.Done()
+			d.decodeStream(decoder, br, withError)
+		}(decoders[i], readers[i])
+	}
+	go func() {
+		wg.Wait()
+		close(d.chunks)
+	}()
+	if withError {
+		return &streamsErrorDecoder{d}, d
+	}
+	return d, d
+}
+
+// decodeStream decodes the points of br with decoder until it ends or d is
+// closed, handing them over in chunks
+func (d *streamsDecoder) decodeStream(decoder PointDecoder, br *bufio.Reader, withError bool) {
+	chunk := make([]decodedPoint, 0, streamChunkSize)
+	for {
+		var p *Point
+		var err error
+		if withError {
+			p, err = decoder.(PointDecoderWithError).DecodeWithError(br)
+		} else {
+			p = decoder.Decode(br)
+		}
+		end := p == nil && (err == nil || err == io.EOF)
+		if !end {
+			chunk = append(chunk, decodedPoint{p: p, err: err})
+		}
+		if len(chunk) == streamChunkSize || (end && len(chunk) > 0) {
+			select {
+			case d.chunks <- chunk:
+			case <-d.done:
+				return
+			}
+			chunk = make([]decodedPoint, 0, streamChunkSize)
+		}
+		if end {
+			return
+		}
+	}
+}
+
+// next returns the next point decoded from any of the streams, or io.EOF once
+// all of them ended
+func (d *streamsDecoder) next() (*Point, error) {
+	for len(d.chunk) == 0 {
+		chunk, ok := <-d.chunks
+		if !ok {
+			return nil, io.EOF
+		}
+		d.chunk = chunk
+	}
+	p := d.chunk[0]
+	d.chunk = d.chunk[1:]
+	return p.p, p.err
+}
+
+// Decode returns the next point decoded from any of the streams, nil once all
+// of them ended
+func (d *streamsDecoder) Decode(_ *bufio.Reader) *Point {
+	p, _ := d.next()
+	return p
+}
+
+// close stops the streams decoding, e.g., once the limit of the items to read
+// is reached
+func (d *streamsDecoder) close() {
+	d.closeOnce.Do(func() { close(d.done) })
+}
+
+// streamsErrorDecoder is the streamsDecoder of decoders returning errors
+type streamsErrorDecoder struct {
+	*streamsDecoder
+}
+
+// DecodeWithError returns the next point decoded from any of the streams or
+// the error decoding it, io.EOF once all of them ended
+func (d *streamsErrorDecoder) DecodeWithError(_ *bufio.Reader) (*Point, error) {
+	return d.next()
+}
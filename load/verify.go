@@ -0,0 +1,64 @@
+package load
+
+import (
+	"bytes"
+	"fmt"
+	"text/tabwriter"
+)
+
+// Verifier is a Benchmark that can check, once loaded, that its database holds
+// what was loaded into it, e.g., that no rows were dropped, with
+// -post-load-verify
+type Verifier interface {
+	Benchmark
+	// Verify returns the checks of what the database dbName holds against
+	// what was loaded into it, or an error if it cannot be checked
+	Verify(dbName string) ([]VerifyCheck, error)
+}
+
+// VerifyCheck is a check of what the database holds, e.g., the rows of a
+// table, against what was loaded into it
+type VerifyCheck struct {
+	Name string
+	// Want is what was loaded and Got what the database holds
+	Want uint64
+	Got  uint64
+}
+
+// postLoadVerify checks what the database of b holds against what was loaded,
+// with -post-load-verify, printing the result of each check and failing if any
+// of them does not match
+func (l *BenchmarkRunner) postLoadVerify(b Benchmark) {
+	if !l.verify {
+		return
+	}
+	checks, err := b.(Verifier).Verify(l.dbName)
+	if err != nil {
+		fatal("cannot verify the load: %v", err)
+		return
+	}
+	table, failed := formatVerifyChecks(checks)
+	printFn("\npost-load verification:\n%s", table)
+	if failed > 0 {
+		fatal("post-load verification failed: %d of %d checks do not match what was loaded", failed, len(checks))
+	}
+}
+
+// formatVerifyChecks returns a table of checks, passing if what the database
+// holds is what was loaded, and the number of them failing
+func formatVerifyChecks(checks []VerifyCheck) (string, int) {
+	var b bytes.Buffer
+	failed := 0
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "check\tloaded\tin database\tresult\t\n")
+	for _, c := range checks {
+		result := "pass"
+		if c.Got != c.Want {
+			result = "FAIL"
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t\n", c.Name, c.Want, c.Got, result)
+	}
+	w.Flush()
+	return b.String(), failed
+}
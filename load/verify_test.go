@@ -0,0 +1,114 @@
+package load
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testVerifierBenchmark loads points of a byte, the database holding what its
+// checks say
+type testVerifierBenchmark struct {
+	testSlowBenchmark
+	checks []VerifyCheck
+	dbName string
+}
+
+func (b *testVerifierBenchmark) GetDBCreator() DBCreator { return &testCreator{} }
+func (b *testVerifierBenchmark) Verify(dbName string) ([]VerifyCheck, error) {
+	b.dbName = dbName
+	return b.checks, nil
+}
+
+func TestPostLoadVerify(t *testing.T) {
+	fileName := writeTempInput(t, make([]byte, 100))
+	defer os.Remove(fileName)
+	oldFatal := fatal
+	defer func() { fatal = oldFatal }()
+
+	cases := []struct {
+		desc      string
+		b         Benchmark
+		doLoad    bool
+		dryRun    bool
+		wantFatal []string
+	}{
+		{
+			desc:   "all pass",
+			b:      &testVerifierBenchmark{checks: []VerifyCheck{{Name: "cpu", Want: 100, Got: 100}, {Name: "tags", Want: 10, Got: 10}}},
+			doLoad: true,
+		},
+		{
+			desc:      "rows missing",
+			b:         &testVerifierBenchmark{checks: []VerifyCheck{{Name: "cpu", Want: 100, Got: 97}, {Name: "tags", Want: 10, Got: 10}}},
+			doLoad:    true,
+			wantFatal: []string{"post-load verification failed: 1 of 2 checks do not match what was loaded"},
+		},
+		{
+			desc:      "not supported",
+			b:         &testSlowBenchmark{},
+			doLoad:    true,
+			wantFatal: []string{"-post-load-verify is not supported by this loader"},
+		},
+		{
+			desc:      "nothing loaded",
+			b:         &testVerifierBenchmark{},
+			doLoad:    false,
+			wantFatal: []string{"-post-load-verify needs the data to be loaded: not with -dry-run or -do-load=false"},
+		},
+		{
+			desc:      "dry run",
+			b:         &testVerifierBenchmark{},
+			doLoad:    true,
+			dryRun:    true,
+			wantFatal: []string{"-post-load-verify needs the data to be loaded: not with -dry-run or -do-load=false"},
+		},
+	}
+	for _, c := range cases {
+		var fatalMessages []string
+		fatal = func(format string, args ...interface{}) {
+			fatalMessages = append(fatalMessages, fmt.Sprintf(format, args...))
+		}
+		l := &BenchmarkRunner{
+			dbName:        "benchmark",
+			batchSize:     10,
+			workers:       1,
+			doLoad:        c.doLoad,
+			dryRun:        c.dryRun,
+			verify:        true,
+			onDecodeError: decodeErrorAbort,
+			fileName:      fileName,
+		}
+		l.RunBenchmark(c.b, SingleQueue)
+		if !reflect.DeepEqual(fatalMessages, c.wantFatal) {
+			t.Errorf("%s: incorrect fatal messages: got %v want %v", c.desc, fatalMessages, c.wantFatal)
+		}
+		if v, ok := c.b.(*testVerifierBenchmark); ok && c.wantFatal == nil && v.dbName != "benchmark" {
+			t.Errorf("%s: incorrect database verified: got %q want %q", c.desc, v.dbName, "benchmark")
+		}
+	}
+}
+
+func TestFormatVerifyChecks(t *testing.T) {
+	checks := []VerifyCheck{
+		{Name: "cpu", Want: 1000, Got: 1000},
+		{Name: "mem", Want: 1000, Got: 990},
+		{Name: "tags", Want: 10, Got: 10},
+	}
+	want := strings.Join([]string{
+		"  check  loaded  in database  result",
+		"    cpu    1000         1000    pass",
+		"    mem    1000          990    FAIL",
+		"   tags      10           10    pass",
+		"",
+	}, "\n")
+	got, failed := formatVerifyChecks(checks)
+	if got != want {
+		t.Errorf("incorrect table\ngot:\n%s\nwant:\n%s", got, want)
+	}
+	if failed != 1 {
+		t.Errorf("incorrect number of failed checks: got %d want 1", failed)
+	}
+}
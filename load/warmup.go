@@ -0,0 +1,69 @@
+package load
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// warmingUp returns whether the load is still warming up at now, for what was
+// loaded then to be told apart from the sustained load after it, ending the
+// warmup once -warmup passed since the start or -warmup-rows rows were
+// loaded. What ends the warmup is counted as part of it.
+func (l *BenchmarkRunner) warmingUp(now time.Time) bool {
+	if l.warmup <= 0 && l.warmupRows == 0 || atomic.LoadInt64(&l.warmupTook) > 0 {
+		return false
+	}
+	if (l.warmup > 0 && now.Sub(l.start) >= l.warmup) || (l.warmupRows > 0 && atomic.LoadUint64(&l.rowCnt) >= l.warmupRows) {
+		l.warmupOnce.Do(func() {
+			atomic.StoreUint64(&l.warmupMetricCnt, atomic.LoadUint64(&l.metricCnt))
+			atomic.StoreUint64(&l.warmupRowCnt, atomic.LoadUint64(&l.rowCnt))
+			// Stored last, for the counts to be set once it is
+			took := now.Sub(l.start)
+			if took <= 0 {
+				took = 1
+			}
+			atomic.StoreInt64(&l.warmupTook, int64(took))
+		})
+	}
+	return true
+}
+
+// warmupCounts returns how long the warmup took and the metrics and rows loaded
+// during it, took being 0 if it did not end
+func (l *BenchmarkRunner) warmupCounts() (took time.Duration, metricCnt, rowCnt uint64) {
+	took = time.Duration(atomic.LoadInt64(&l.warmupTook))
+	if took == 0 {
+		return 0, 0, 0
+	}
+	return took, atomic.LoadUint64(&l.warmupMetricCnt), atomic.LoadUint64(&l.warmupRowCnt)
+}
+
+// warmupSummary prints the rates after -warmup or -warmup-rows, if set, out of
+// the load, which took took
+func (l *BenchmarkRunner) warmupSummary(took time.Duration) {
+	if l.warmup <= 0 && l.warmupRows == 0 {
+		return
+	}
+	warmupTook, warmupMetricCnt, warmupRowCnt := l.warmupCounts()
+	printFn("%s", formatWarmup(warmupTook, took, warmupMetricCnt, warmupRowCnt, atomic.LoadUint64(&l.metricCnt), atomic.LoadUint64(&l.rowCnt)))
+}
+
+// formatWarmup returns the metrics and rows loaded after a warmup, which took
+// warmupTook out of took, and their sustained rates, metricCnt and rowCnt
+// being those of the whole load
+func formatWarmup(warmupTook, took time.Duration, warmupMetricCnt, warmupRowCnt, metricCnt, rowCnt uint64) string {
+	if warmupTook == 0 {
+		return "warmup did not end: no sustained rate after it\n"
+	}
+	var b bytes.Buffer
+	sustained := took - warmupTook
+	metrics := metricCnt - warmupMetricCnt
+	fmt.Fprintf(&b, "after the warmup of %0.3fsec: loaded %d metrics in %0.3fsec (sustained rate %0.2f metrics/sec)\n", warmupTook.Seconds(), metrics, sustained.Seconds(), float64(metrics)/sustained.Seconds())
+	if rowCnt > 0 {
+		rows := rowCnt - warmupRowCnt
+		fmt.Fprintf(&b, "after the warmup of %0.3fsec: loaded %d rows in %0.3fsec (sustained rate %0.2f rows/sec)\n", warmupTook.Seconds(), rows, sustained.Seconds(), float64(rows)/sustained.Seconds())
+	}
+	return b.String()
+}
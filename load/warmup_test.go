@@ -0,0 +1,158 @@
+package load
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testWarmupProcessor loads 2 metrics per row, of a row per point, taking
+// 20ms to load each of the first 10 batches of the load, for 200ms, and 1ms
+// the others
+type testWarmupProcessor struct {
+	testProcessor
+	batches *uint64
+}
+
+func (p *testWarmupProcessor) ProcessBatch(b Batch, doLoad bool) (metricCount, rowCount uint64) {
+	if atomic.AddUint64(p.batches, 1) <= 10 {
+		time.Sleep(20 * time.Millisecond)
+	} else {
+		time.Sleep(time.Millisecond)
+	}
+	return 2 * uint64(b.Len()), uint64(b.Len())
+}
+
+type testWarmupBenchmark struct {
+	testSlowBenchmark
+	batches uint64
+}
+
+func (b *testWarmupBenchmark) GetProcessor() Processor {
+	return &testWarmupProcessor{batches: &b.batches}
+}
+
+func TestWarmup(t *testing.T) {
+	cases := []struct {
+		desc       string
+		warmup     time.Duration
+		warmupRows uint64
+		// wantWarmupBatches is 0 if not known in advance
+		wantWarmupBatches uint64
+	}{
+		{
+			desc:              "rows",
+			warmupRows:        100,
+			wantWarmupBatches: 10,
+		},
+		{
+			desc:   "duration",
+			warmup: 250 * time.Millisecond,
+		},
+	}
+	for _, c := range cases {
+		l := &BenchmarkRunner{
+			batchSize:  10,
+			workers:    1,
+			doLoad:     true,
+			warmup:     c.warmup,
+			warmupRows: c.warmupRows,
+			br:         bufio.NewReader(bytes.NewReader(make([]byte, 1000))),
+		}
+		b := &testWarmupBenchmark{}
+		channels := l.createChannels(WorkerPerQueue)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go l.work(b, &wg, channels[0], 0)
+		l.start = time.Now()
+		l.scan(b, channels)
+		channels[0].close()
+		wg.Wait()
+		took := time.Since(l.start)
+
+		warmupTook, warmupMetricCnt, warmupRowCnt := l.warmupCounts()
+		if warmupTook < c.warmup || warmupTook == 0 {
+			t.Fatalf("%s: warmup too short: got %v want at least %v", c.desc, warmupTook, c.warmup)
+		}
+		if warmupRowCnt < c.warmupRows || warmupMetricCnt != 2*warmupRowCnt {
+			t.Errorf("%s: incorrect warmup counts: got %d metrics and %d rows", c.desc, warmupMetricCnt, warmupRowCnt)
+		}
+		stats := l.WorkerStats()[0]
+		if stats.WarmupRows != warmupRowCnt || stats.WarmupBatches != warmupRowCnt/10 {
+			t.Errorf("%s: incorrect warmup stats: got %d batches and %d rows want %d rows", c.desc, stats.WarmupBatches, stats.WarmupRows, warmupRowCnt)
+		}
+		if c.wantWarmupBatches > 0 && stats.WarmupBatches != c.wantWarmupBatches {
+			t.Errorf("%s: incorrect warmup batches: got %d want %d", c.desc, stats.WarmupBatches, c.wantWarmupBatches)
+		}
+
+		// The sustained rate excludes the slow start, of 20 times slower
+		// batches, the rate of the whole load not
+		rate := float64(l.rowCnt) / took.Seconds()
+		sustainedRate := float64(l.rowCnt-warmupRowCnt) / (took - warmupTook).Seconds()
+		if sustainedRate < 2*rate {
+			t.Errorf("%s: sustained rate too low: got %0.2f rows/sec, %0.2f rows/sec for the whole load", c.desc, sustainedRate, rate)
+		}
+	}
+}
+
+func TestWarmingUpWithoutWarmup(t *testing.T) {
+	l := &BenchmarkRunner{start: time.Now(), rowCnt: 100}
+	if l.warmingUp(time.Now()) {
+		t.Errorf("warming up without a warmup")
+	}
+	if took, _, _ := l.warmupCounts(); took != 0 {
+		t.Errorf("warmup ended without a warmup: took %v", took)
+	}
+}
+
+func TestFormatWarmup(t *testing.T) {
+	cases := []struct {
+		desc            string
+		warmupTook      time.Duration
+		took            time.Duration
+		warmupMetricCnt uint64
+		warmupRowCnt    uint64
+		metricCnt       uint64
+		rowCnt          uint64
+		want            []string
+	}{
+		{
+			desc:      "warmup did not end",
+			took:      10 * time.Second,
+			metricCnt: 1000,
+			rowCnt:    100,
+			want:      []string{"warmup did not end: no sustained rate after it"},
+		},
+		{
+			desc:            "metrics and rows",
+			warmupTook:      2 * time.Second,
+			took:            10 * time.Second,
+			warmupMetricCnt: 200,
+			warmupRowCnt:    20,
+			metricCnt:       1000,
+			rowCnt:          100,
+			want: []string{
+				"after the warmup of 2.000sec: loaded 800 metrics in 8.000sec (sustained rate 100.00 metrics/sec)",
+				"after the warmup of 2.000sec: loaded 80 rows in 8.000sec (sustained rate 10.00 rows/sec)",
+			},
+		},
+		{
+			desc:            "no rows",
+			warmupTook:      time.Second,
+			took:            5 * time.Second,
+			warmupMetricCnt: 100,
+			metricCnt:       500,
+			want:            []string{"after the warmup of 1.000sec: loaded 400 metrics in 4.000sec (sustained rate 100.00 metrics/sec)"},
+		},
+	}
+	for _, c := range cases {
+		want := strings.Join(c.want, "\n") + "\n"
+		if got := formatWarmup(c.warmupTook, c.took, c.warmupMetricCnt, c.warmupRowCnt, c.metricCnt, c.rowCnt); got != want {
+			t.Errorf("%s: incorrect warmup\ngot:\n%s\nwant:\n%s", c.desc, got, want)
+		}
+	}
+}
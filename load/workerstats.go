@@ -0,0 +1,158 @@
+package load
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// WorkerStats are the totals of the batches a worker processed, for the
+// workers that were the bottleneck of the load to be found
+type WorkerStats struct {
+	Worker  int
+	Rows    uint64
+	Metrics uint64
+	Batches uint64
+	// InsertTime is the time the worker took to process its batches, and
+	// MaxLatency the longest it took to process one of them
+	InsertTime time.Duration
+	MaxLatency time.Duration
+	// QueueWait is the time the batches waited on the queue of the worker
+	// before it took them
+	QueueWait time.Duration
+	// WarmupBatches and WarmupRows are those of the batches processed while
+	// the load was warming up, with -warmup or -warmup-rows
+	WarmupBatches uint64
+	WarmupRows    uint64
+}
+
+// add adds a batch of metrics and rows, which took took to process
+func (s *WorkerStats) add(metrics, rows uint64, took time.Duration) {
+	s.Metrics += metrics
+	s.Rows += rows
+	s.Batches++
+	s.InsertTime += took
+	if took > s.MaxLatency {
+		s.MaxLatency = took
+	}
+}
+
+// addWarmup marks the batch of rows last added as processed during the warmup
+func (s *WorkerStats) addWarmup(rows uint64) {
+	s.WarmupBatches++
+	s.WarmupRows += rows
+}
+
+// addWorkerStats records the stats of a worker once it is done
+func (l *BenchmarkRunner) addWorkerStats(s WorkerStats) {
+	l.statsMutex.Lock()
+	defer l.statsMutex.Unlock()
+	l.workerStats = append(l.workerStats, s)
+}
+
+// WorkerStats returns the stats of the workers done loading, ordered by worker
+func (l *BenchmarkRunner) WorkerStats() []WorkerStats {
+	l.statsMutex.Lock()
+	defer l.statsMutex.Unlock()
+	stats := append([]WorkerStats(nil), l.workerStats...)
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Worker < stats[j].Worker })
+	return stats
+}
+
+// coefficientOfVariation returns the standard deviation of values relative to
+// their mean, 0 if their mean is 0
+func coefficientOfVariation(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	if mean == 0 {
+		return 0
+	}
+	var squares float64
+	for _, v := range values {
+		squares += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(squares/float64(len(values))) / mean
+}
+
+// workerSummary prints the stats of the workers done loading, if any, and the
+// time the scanner waited for them out of took
+func (l *BenchmarkRunner) workerSummary(took time.Duration) {
+	if stats := l.WorkerStats(); len(stats) > 0 {
+		printFn("\nper worker:\n%s", formatWorkerStats(stats))
+		printFn("%s", formatBackpressure(stats, time.Duration(atomic.LoadInt64(&l.scanBlocked)), took))
+	}
+}
+
+// formatBackpressure returns how long the scanner was blocked waiting for the
+// workers out of took, and the batches waited on their queues for a worker to
+// take them on average. The first being long shows that the database is the
+// bottleneck of the load, the second being short that the scanner is.
+func formatBackpressure(stats []WorkerStats, blocked, took time.Duration) string {
+	var batches uint64
+	var queueWait time.Duration
+	for _, s := range stats {
+		batches += s.Batches
+		queueWait += s.QueueWait
+	}
+	blockedPercent, meanQueueWait := 0.0, 0.0
+	if took > 0 {
+		blockedPercent = 100 * blocked.Seconds() / took.Seconds()
+	}
+	if batches > 0 {
+		meanQueueWait = queueWait.Seconds() / float64(batches)
+	}
+	return fmt.Sprintf("scanner blocked waiting for the workers for %0.3fsec (%0.1f%% of the load), batches queued for %0.3fsec on average\n", blocked.Seconds(), blockedPercent, meanQueueWait)
+}
+
+// formatWorkerStats returns a table of stats, with the batches of the warmup if
+// any, and how much the rows of the workers, or metrics if they load no rows,
+// and insert times vary
+func formatWorkerStats(stats []WorkerStats) string {
+	warmup := false
+	for _, s := range stats {
+		warmup = warmup || s.WarmupBatches > 0
+	}
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "worker\trows\tmetrics\tbatches\tinsert sec\tmax batch sec\tqueue wait sec\trows/sec\t")
+	if warmup {
+		fmt.Fprintf(w, "warmup batches\twarmup rows\t")
+	}
+	fmt.Fprintf(w, "\n")
+	loaded := make([]float64, len(stats))
+	insertTimes := make([]float64, len(stats))
+	rows := false
+	for i, s := range stats {
+		rate := 0.0
+		if s.InsertTime > 0 {
+			rate = float64(s.Rows) / s.InsertTime.Seconds()
+		}
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%0.3f\t%0.3f\t%0.3f\t%0.2f\t", s.Worker, s.Rows, s.Metrics, s.Batches, s.InsertTime.Seconds(), s.MaxLatency.Seconds(), s.QueueWait.Seconds(), rate)
+		if warmup {
+			fmt.Fprintf(w, "%d\t%d\t", s.WarmupBatches, s.WarmupRows)
+		}
+		fmt.Fprintf(w, "\n")
+		loaded[i], insertTimes[i] = float64(s.Metrics), s.InsertTime.Seconds()
+		rows = rows || s.Rows > 0
+	}
+	w.Flush()
+	loadedName := "metrics"
+	if rows {
+		loadedName = "rows"
+		for i, s := range stats {
+			loaded[i] = float64(s.Rows)
+		}
+	}
+	fmt.Fprintf(&b, "coefficient of variation across workers: %s %0.2f, insert time %0.2f\n", loadedName, coefficientOfVariation(loaded), coefficientOfVariation(insertTimes))
+	return b.String()
+}
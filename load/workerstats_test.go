@@ -0,0 +1,209 @@
+package load
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testSleepProcessor takes 10ms times its worker to load a batch of 2 metrics
+// and 1 row
+type testSleepProcessor struct {
+	testProcessor
+}
+
+func (p *testSleepProcessor) ProcessBatch(b Batch, doLoad bool) (metricCount, rowCount uint64) {
+	time.Sleep(time.Duration(p.worker) * 10 * time.Millisecond)
+	return 2, 1
+}
+
+type testSleepBenchmark struct {
+	testBenchmark
+}
+
+func (b *testSleepBenchmark) GetProcessor() Processor { return &testSleepProcessor{} }
+
+func TestWorkStats(t *testing.T) {
+	const workers, batches = 3, 4
+	br := &BenchmarkRunner{}
+	b := &testSleepBenchmark{}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		c := newDuplexChannel(batches)
+		for i := 0; i < batches; i++ {
+			c.sendToWorker(&testBatch{})
+		}
+		wg.Add(1)
+		go br.work(b, &wg, c, w)
+		go func() {
+			for i := 0; i < batches; i++ {
+				<-c.toScanner
+			}
+			c.close()
+		}()
+	}
+	wg.Wait()
+
+	stats := br.WorkerStats()
+	if len(stats) != workers {
+		t.Fatalf("incorrect number of stats: got %d want %d", len(stats), workers)
+	}
+	for w, s := range stats {
+		if s.Worker != w || s.Batches != batches || s.Metrics != 2*batches || s.Rows != batches {
+			t.Errorf("incorrect stats of worker %d: got %+v", w, s)
+		}
+		latency := time.Duration(w) * 10 * time.Millisecond
+		if s.MaxLatency < latency || s.InsertTime < batches*latency {
+			t.Errorf("incorrect latencies of worker %d: got max %v and total %v want at least %v and %v", w, s.MaxLatency, s.InsertTime, latency, batches*latency)
+		}
+		// The workers that sleep longer take longer
+		if w > 0 && (s.MaxLatency <= stats[w-1].MaxLatency || s.InsertTime <= stats[w-1].InsertTime) {
+			t.Errorf("latencies of worker %d not over those of worker %d: got %+v and %+v", w, w-1, s, stats[w-1])
+		}
+	}
+}
+
+func TestCoefficientOfVariation(t *testing.T) {
+	cases := []struct {
+		desc   string
+		values []float64
+		want   float64
+	}{
+		{
+			desc: "no values",
+			want: 0,
+		},
+		{
+			desc:   "zero mean",
+			values: []float64{0, 0},
+			want:   0,
+		},
+		{
+			desc:   "equal values",
+			values: []float64{5, 5, 5},
+			want:   0,
+		},
+		{
+			desc:   "skewed values",
+			values: []float64{2, 4, 4, 4, 5, 5, 7, 9},
+			want:   0.4,
+		},
+	}
+	for _, c := range cases {
+		if got := coefficientOfVariation(c.values); math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("%s: incorrect coefficient: got %f want %f", c.desc, got, c.want)
+		}
+	}
+}
+
+func TestFormatWorkerStats(t *testing.T) {
+	br := &BenchmarkRunner{}
+	br.addWorkerStats(WorkerStats{Worker: 1, Rows: 300, Metrics: 3000, Batches: 3, InsertTime: 3 * time.Second, MaxLatency: 1500 * time.Millisecond, QueueWait: 1500 * time.Millisecond})
+	br.addWorkerStats(WorkerStats{Worker: 0, Rows: 100, Metrics: 1000, Batches: 1, InsertTime: time.Second, MaxLatency: time.Second, QueueWait: 500 * time.Millisecond})
+	want := strings.Join([]string{
+		"  worker  rows  metrics  batches  insert sec  max batch sec  queue wait sec  rows/sec",
+		"       0   100     1000        1       1.000          1.000           0.500    100.00",
+		"       1   300     3000        3       3.000          1.500           1.500    100.00",
+		"coefficient of variation across workers: rows 0.50, insert time 0.50",
+		"",
+	}, "\n")
+	if got := formatWorkerStats(br.WorkerStats()); got != want {
+		t.Errorf("incorrect summary\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	// The batches of the warmup are shown only with one
+	warmupStats := []WorkerStats{{Worker: 0, Rows: 100, Metrics: 1000, Batches: 2, InsertTime: time.Second, MaxLatency: time.Second, WarmupBatches: 1, WarmupRows: 50}}
+	want = strings.Join([]string{
+		"  worker  rows  metrics  batches  insert sec  max batch sec  queue wait sec  rows/sec  warmup batches  warmup rows",
+		"       0   100     1000        2       1.000          1.000           0.000    100.00               1           50",
+		"coefficient of variation across workers: rows 0.00, insert time 0.00",
+		"",
+	}, "\n")
+	if got := formatWorkerStats(warmupStats); got != want {
+		t.Errorf("incorrect summary with warmup\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	want = "scanner blocked waiting for the workers for 2.000sec (20.0% of the load), batches queued for 0.500sec on average\n"
+	if got := formatBackpressure(br.WorkerStats(), 2*time.Second, 10*time.Second); got != want {
+		t.Errorf("incorrect backpressure\ngot: %s\nwant: %s", got, want)
+	}
+}
+
+// testSlowDecoder takes 2ms to decode a point of a byte
+type testSlowDecoder struct {
+	testDecoder
+}
+
+func (d *testSlowDecoder) Decode(br *bufio.Reader) *Point {
+	time.Sleep(2 * time.Millisecond)
+	return d.testDecoder.Decode(br)
+}
+
+// testSlowDecoderBenchmark decodes points of a byte with a testSlowDecoder,
+// loading them at once
+type testSlowDecoderBenchmark struct {
+	testSlowBenchmark
+}
+
+func (b *testSlowDecoderBenchmark) GetPointDecoder(_ *bufio.Reader) PointDecoder {
+	return &testSlowDecoder{}
+}
+func (b *testSlowDecoderBenchmark) GetProcessor() Processor { return &testRowProcessor{} }
+
+func TestQueueWait(t *testing.T) {
+	cases := []struct {
+		desc        string
+		b           Benchmark
+		slowWorkers bool
+	}{
+		{
+			desc:        "slow processor",
+			b:           &testSlowBenchmark{},
+			slowWorkers: true,
+		},
+		{
+			desc: "slow decoder",
+			b:    &testSlowDecoderBenchmark{},
+		},
+	}
+	for _, c := range cases {
+		l := &BenchmarkRunner{
+			batchSize: 5,
+			workers:   2,
+			doLoad:    true,
+			br:        bufio.NewReader(bytes.NewReader(make([]byte, 500))),
+		}
+		channels := l.createChannels(WorkerPerQueue)
+		var wg sync.WaitGroup
+		for i := 0; i < int(l.workers); i++ {
+			wg.Add(1)
+			go l.work(c.b, &wg, channels[i], i)
+		}
+		l.start = time.Now()
+		l.scan(c.b, channels)
+		for _, ch := range channels {
+			ch.close()
+		}
+		wg.Wait()
+
+		var batches uint64
+		var queueWait time.Duration
+		for _, s := range l.WorkerStats() {
+			batches += s.Batches
+			queueWait += s.QueueWait
+		}
+		meanQueueWait := queueWait / time.Duration(batches)
+		blocked := time.Duration(l.scanBlocked)
+		// The batches wait for the slow workers to load those before them,
+		// the scanner waiting for them too, while the fast ones take them at once
+		if c.slowWorkers && (meanQueueWait < 10*time.Millisecond || blocked < 100*time.Millisecond) {
+			t.Errorf("%s: waits too short: got %v queued on average and %v blocked", c.desc, meanQueueWait, blocked)
+		} else if !c.slowWorkers && (meanQueueWait > time.Millisecond || blocked > 10*time.Millisecond) {
+			t.Errorf("%s: waits too long: got %v queued on average and %v blocked", c.desc, meanQueueWait, blocked)
+		}
+	}
+}